@@ -1,13 +1,38 @@
 #!/usr/bin/env bash
 # Build CLI binaries for all platforms.
 # Usage:
-#   ./build.sh          # auto-bump patch: 1.3.1 → 1.3.2
-#   ./build.sh 2.0.0    # set explicit version
+#   ./build.sh                        # auto-bump patch: 1.3.1 → 1.3.2
+#   ./build.sh 2.0.0                  # set explicit version
+#   ./build.sh --beta 2.0.0-beta.1    # beta channel build, in dist/beta/
+#
+# Binaries are signed with minisign using the secret key in $MINISIGN_KEY
+# (default ~/.minisign/preview-cli.key), whose public key is release.pub,
+# embedded in the CLI by the "release" build tag: self-update refuses
+# binaries it can't verify, and release builds fail without the key. Create
+# the key pair once, and commit release.pub, with:
+#   minisign -G -p release.pub -s ~/.minisign/preview-cli.key
 
 set -euo pipefail
 cd "$(dirname "$0")"
 
 CURRENT=$(cat VERSION)
+MINISIGN_KEY="${MINISIGN_KEY:-$HOME/.minisign/preview-cli.key}"
+
+if [ ! -s release.pub ] || [ ! -f "$MINISIGN_KEY" ] || ! command -v minisign > /dev/null; then
+    echo "Can't sign the binaries: release.pub, $MINISIGN_KEY or minisign is missing" >&2
+    echo "(self-update refuses unsigned binaries, see the top of build.sh)" >&2
+    exit 1
+fi
+
+BETA=0
+if [ "${1:-}" = "--beta" ]; then
+    BETA=1
+    shift
+    if [ $# -lt 1 ]; then
+        echo "Usage: ./build.sh --beta VERSION" >&2
+        exit 1
+    fi
+fi
 
 if [ $# -ge 1 ]; then
     VERSION="$1"
@@ -18,8 +43,17 @@ else
     VERSION="${MAJOR}.${MINOR}.${PATCH}"
 fi
 
+DIST=dist
+if [ "$BETA" = 1 ]; then
+    # Beta builds are served to 'preview self-update --channel beta' and
+    # leave the stable VERSION alone once built
+    DIST=dist/beta
+    trap 'echo "$CURRENT" > VERSION' EXIT
+fi
+mkdir -p "$DIST"
+
 echo "$VERSION" > VERSION
-echo "$VERSION" > dist/VERSION
+echo "$VERSION" > "$DIST/VERSION"
 
 echo "Building CLI v${VERSION} (was ${CURRENT})"
 
@@ -28,16 +62,22 @@ PLATFORMS=(
     "linux/arm64"
     "darwin/amd64"
     "darwin/arm64"
+    "windows/amd64"
 )
 
 for PLATFORM in "${PLATFORMS[@]}"; do
     OS="${PLATFORM%/*}"
     ARCH="${PLATFORM#*/}"
-    OUTPUT="dist/preview-${OS}-${ARCH}"
+    OUTPUT="${DIST}/preview-${OS}-${ARCH}"
+    [ "$OS" = "windows" ] && OUTPUT="${OUTPUT}.exe"
     echo "  → ${OS}/${ARCH}"
-    GOOS=$OS GOARCH=$ARCH go build -o "$OUTPUT" .
+    GOOS=$OS GOARCH=$ARCH go build -tags release -o "$OUTPUT" .
+    # Legacy (-l) signatures, as the CLI can't verify prehashed ones; the
+    # trusted comment ties the signature to this file and version
+    minisign -S -l -s "$MINISIGN_KEY" -m "$OUTPUT" -x "${OUTPUT}.minisig" \
+        -t "file:$(basename "$OUTPUT") version:${VERSION}" > /dev/null
 done
 
 echo ""
-echo "Done! CLI v${VERSION} ready in dist/"
+echo "Done! CLI v${VERSION} ready in ${DIST}/"
 echo "Deploy with: cd ../server/ansible && ~/.local/bin/ansible-playbook -i inventory/hosts.yml playbooks/deploy-preview-manager.yml --tags cli"
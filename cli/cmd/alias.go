@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var aliasRemove bool
+
+var configAliasCmd = &cobra.Command{
+	Use:         "alias [NAME [COMMAND...]]",
+	Short:       "Define shortcuts for commands you type often",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Define, show or remove user aliases, saved in the "aliases" section of
+~/.preview-manager.json. An alias expands to a command with its arguments,
+and any arguments after the alias are appended: with "cr = drush cr",
+'preview cr drupal-test/mr-5' runs 'preview drush cr drupal-test/mr-5'.
+Aliases can use other aliases, and can't replace built-in commands.
+
+Without arguments, lists the aliases. With NAME, shows that alias; with
+COMMAND too, sets it. COMMAND is either the words of the command or a
+single quoted command line. 'preview config export' includes aliases, so a team
+can share them.
+
+Examples:
+  preview config alias cr drush cr
+  preview config alias st "pipeline list"
+  preview config alias ls5 list drupal-test --limit 5
+  preview config alias --remove cr
+  preview config alias`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadConfig()
+		switch {
+		case aliasRemove:
+			if len(args) != 1 {
+				return fmt.Errorf("--remove takes a single NAME")
+			}
+			if _, ok := cfg.Aliases[args[0]]; !ok {
+				return fmt.Errorf("no alias %q", args[0])
+			}
+			delete(cfg.Aliases, args[0])
+			if err := saveConfig(cfg); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Alias %q removed.\n", args[0])
+		case len(args) == 0:
+			if len(cfg.Aliases) == 0 {
+				fmt.Fprintln(os.Stderr, "No aliases defined; add one with 'preview config alias NAME COMMAND...'.")
+				return nil
+			}
+			names := make([]string, 0, len(cfg.Aliases))
+			for name := range cfg.Aliases {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, name := range names {
+				fmt.Fprintf(w, "%s\t= %s\n", name, cfg.Aliases[name])
+			}
+			w.Flush()
+		case len(args) == 1:
+			expansion, ok := cfg.Aliases[args[0]]
+			if !ok {
+				return fmt.Errorf("no alias %q", args[0])
+			}
+			fmt.Println(expansion)
+		default:
+			name := args[0]
+			if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t\n\"'") {
+				return fmt.Errorf("invalid alias name %q", name)
+			}
+			if isBuiltinCommand(name) {
+				return fmt.Errorf("%q is a built-in command and can't be an alias", name)
+			}
+			// A single COMMAND is a command line, as in "drush cr"
+			expansion := args[1]
+			if len(args) > 2 {
+				expansion = shellJoin(args[1:])
+			}
+			if _, err := splitShellWords(expansion); err != nil {
+				return err
+			}
+			if cfg.Aliases == nil {
+				cfg.Aliases = make(map[string]string)
+			}
+			cfg.Aliases[name] = expansion
+			if err := saveConfig(cfg); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Alias saved: %s = %s\n", name, expansion)
+		}
+		return nil
+	},
+}
+
+// isBuiltinCommand reports whether name is a top-level command or one of
+// its aliases.
+func isBuiltinCommand(name string) bool {
+	if name == "help" || name == "completion" {
+		return true
+	}
+	for _, c := range rootCmd.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandAliases replaces the command name in args, the first argument
+// that isn't a flag, by the user alias of that name. Expansions are
+// expanded again, so aliases can build on each other; a loop stops at the
+// alias that would repeat.
+func expandAliases(args []string, aliases map[string]string) ([]string, error) {
+	seen := make(map[string]bool)
+	for {
+		i := 0
+		for i < len(args) && strings.HasPrefix(args[i], "-") {
+			i++
+		}
+		if i == len(args) || isBuiltinCommand(args[i]) {
+			return args, nil
+		}
+		expansion, ok := aliases[args[i]]
+		if !ok || seen[args[i]] {
+			return args, nil
+		}
+		seen[args[i]] = true
+		words, err := splitShellWords(expansion)
+		if err != nil {
+			return nil, fmt.Errorf("alias %q: %w", args[i], err)
+		}
+		args = append(append(append([]string{}, args[:i]...), words...), args[i+1:]...)
+	}
+}
+
+// splitShellWords splits s into words with shell quoting rules: single
+// quotes, double quotes and backslash escapes. It undoes shellJoin.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func init() {
+	configAliasCmd.Flags().BoolVar(&aliasRemove, "remove", false, "Remove alias NAME")
+	// Flags after NAME belong to the aliased command
+	configAliasCmd.Flags().SetInterspersed(false)
+	configCmd.AddCommand(configAliasCmd)
+}
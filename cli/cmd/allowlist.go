@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var allowlistNote string
+var allowlistOutput string
+
+var allowlistCmd = &cobra.Command{
+	Use:     "allowlist",
+	Aliases: []string{"ip-allowlist"},
+	Short:   "Manage the IP allowlists of projects and previews",
+	Long: `Restrict the previews of a project, or a single preview, to given networks
+on top of the login, for client projects with strict access requirements.
+Once a preview has an allowlist entry, of its project or its own, requests
+from any other address are refused; removing the last entry opens it to any
+address again. Requires the manager role.`,
+}
+
+var allowlistAddCmd = &cobra.Command{
+	Use:   "add PROJECT[/PREVIEW-NAME] CIDR...",
+	Short: "Allow networks to reach the previews of a project",
+	Long: `Allow addresses or networks in CIDR notation to reach every preview of
+PROJECT, or only PROJECT/PREVIEW-NAME. Entries of a preview add to those of
+its project.
+
+Mind your own address: once a project has an allowlist, you can only open
+its previews from the networks in it. The command warns when the address
+the server sees you from isn't one of them.
+
+Examples:
+  preview allowlist add drupal-test 203.0.113.0/24 --note "client office"
+  preview allowlist add drupal-test/mr-5 198.51.100.7 2001:db8::/32`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, previewName := parseAllowlistTarget(args[0])
+		if project == "" {
+			return fmt.Errorf("expected PROJECT or PROJECT/PREVIEW-NAME, got %q", args[0])
+		}
+		for _, cidr := range args[1:] {
+			if _, err := parseNetwork(cidr); err != nil {
+				return err
+			}
+		}
+		if err := requireIPAllowlist(); err != nil {
+			return err
+		}
+		for _, cidr := range args[1:] {
+			entry, err := apiClient.AddAllowlistEntry(cmd.Context(), project, client.AllowlistRequest{
+				CIDR: cidr, PreviewName: previewName, Note: allowlistNote,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add %s: %w", cidr, err)
+			}
+			fmt.Printf("Allowed %s to reach %s (entry %d).\n", entry.CIDR, allowlistScope(entry), entry.ID)
+		}
+		warnIfLockedOut(cmd.Context(), project, previewName)
+		return nil
+	},
+}
+
+var allowlistListCmd = &cobra.Command{
+	Use:   "list [PROJECT]",
+	Short: "List the IP allowlist of a project and its previews",
+	Long: `List the IP allowlist of a project and its previews. If PROJECT is not
+given, it is detected from the git remote.
+
+Examples:
+  preview allowlist list drupal-test
+  preview allowlist list -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if allowlistOutput != "text" && allowlistOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", allowlistOutput)
+		}
+		if err := requireIPAllowlist(); err != nil {
+			return err
+		}
+		project, err := allowlistProject(args)
+		if err != nil {
+			return err
+		}
+		allowlist, err := apiClient.ListAllowlist(cmd.Context(), project)
+		if err != nil {
+			return err
+		}
+
+		if allowlistOutput == "json" {
+			data, err := json.MarshalIndent(allowlist, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(allowlist.Entries) == 0 {
+			fmt.Printf("%s has no IP allowlist: its previews are open to any address (with a login).\n", project)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tAPPLIES TO\tNETWORK\tNOTE\tADDED BY\tADDED")
+		for _, e := range allowlist.Entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", e.ID, allowlistScope(&e), e.CIDR, e.Note, e.CreatedBy, formatUploadTime(e.CreatedAt))
+		}
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\nThe server sees you from %s.\n", allowlist.ClientIP)
+		return nil
+	},
+}
+
+var allowlistRemoveCmd = &cobra.Command{
+	Use:   "remove [PROJECT] ID...",
+	Short: "Remove entries from the IP allowlist of a project",
+	Long: `Remove entries from the IP allowlist of a project by their ID (see
+'preview allowlist list'). If PROJECT is not given, it is detected from the
+git remote.
+
+Examples:
+  preview allowlist remove drupal-test 3
+  preview allowlist remove 3 4`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var projectArgs []string
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			projectArgs, args = args[:1], args[1:]
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("expected the IDs of the entries to remove")
+		}
+		ids := make([]int, len(args))
+		for i, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid allowlist entry ID %q", arg)
+			}
+			ids[i] = id
+		}
+		if err := requireIPAllowlist(); err != nil {
+			return err
+		}
+		project, err := allowlistProject(projectArgs)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := apiClient.DeleteAllowlistEntry(cmd.Context(), project, id); err != nil {
+				return fmt.Errorf("failed to remove entry %d: %w", id, err)
+			}
+			fmt.Printf("Removed entry %d from the allowlist of %s.\n", id, project)
+		}
+		return nil
+	},
+}
+
+// parseAllowlistTarget splits "PROJECT" or "PROJECT/PREVIEW-NAME".
+func parseAllowlistTarget(arg string) (string, string) {
+	project, previewName, _ := strings.Cut(strings.TrimSuffix(arg, "/"), "/")
+	return project, previewName
+}
+
+func allowlistProject(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return detectProjectSlug()
+}
+
+// allowlistScope names what an entry applies to.
+func allowlistScope(e *client.AllowlistEntry) string {
+	if e.PreviewName != nil {
+		return e.Project + "/" + *e.PreviewName
+	}
+	return e.Project + " (all previews)"
+}
+
+// parseNetwork parses an address or a CIDR network, as the server accepts.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	return nil, fmt.Errorf("invalid network %q: expected an address or CIDR, e.g. 203.0.113.0/24", s)
+}
+
+// warnIfLockedOut warns when the address the server sees the user from
+// can't reach the previews the allowlist now restricts. Failures are
+// ignored: the entries were added.
+func warnIfLockedOut(ctx context.Context, project, previewName string) {
+	allowlist, err := apiClient.ListAllowlist(ctx, project)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(allowlist.ClientIP)
+	if ip == nil {
+		return
+	}
+	for _, e := range allowlist.Entries {
+		if e.PreviewName != nil && *e.PreviewName != previewName {
+			continue
+		}
+		if network, err := parseNetwork(e.CIDR); err == nil && network.Contains(ip) {
+			return
+		}
+	}
+	target := project
+	if previewName != "" {
+		target += "/" + previewName
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the server sees you from %s, which the allowlist doesn't include: you can't open the previews of %s from here.\n", allowlist.ClientIP, target)
+}
+
+func init() {
+	allowlistAddCmd.Flags().StringVar(&allowlistNote, "note", "", "Note telling whose network it is")
+	allowlistListCmd.Flags().StringVarP(&allowlistOutput, "output", "o", "text", "Output format: text or json")
+	allowlistCmd.AddCommand(allowlistAddCmd, allowlistListCmd, allowlistRemoveCmd)
+	rootCmd.AddCommand(allowlistCmd)
+}
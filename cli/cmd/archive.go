@@ -0,0 +1,465 @@
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/pgzip"
+)
+
+// filesArchiveExcludes are top-level directories of the Drupal files dir that
+// are regenerated on the preview and never packaged.
+var filesArchiveExcludes = []string{"css", "js", "php"}
+
+// isExcludedFilesPath reports whether rel (slash-separated, relative to the
+// files dir) is inside one of filesArchiveExcludes.
+func isExcludedFilesPath(rel string) bool {
+	top := strings.SplitN(rel, "/", 2)[0]
+	for _, ex := range filesArchiveExcludes {
+		if top == ex {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSize returns the total size in bytes of the regular files under path.
+func dirSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// findHeavyFiles returns the paths (slash-separated, relative to root) of
+// regular files larger than maxBytes, skipping filesArchiveExcludes.
+func findHeavyFiles(root string, maxBytes int64) ([]string, error) {
+	var heavy []string
+	err := walkFilesDir(root, func(rel string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxBytes {
+			heavy = append(heavy, rel)
+		}
+		return nil
+	})
+	return heavy, err
+}
+
+// walkFilesDir walks root calling fn with each entry's slash-separated path
+// relative to root ("." for root itself), skipping filesArchiveExcludes.
+func walkFilesDir(root string, fn func(rel string, d fs.DirEntry) error) error {
+	return walkDir(root, func(rel string, d fs.DirEntry) error {
+		if rel != "." && isExcludedFilesPath(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(rel, d)
+	})
+}
+
+// walkDir walks root like walkFilesDir, without filesArchiveExcludes.
+func walkDir(root string, fn func(rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), d)
+	})
+}
+
+// symlinkEscapes reports whether the symlink rel (slash-separated,
+// relative to the directory archived) to link points outside the
+// directory: absolute, or with more ".." than rel has parents.
+func symlinkEscapes(rel, link string) bool {
+	if filepath.IsAbs(link) || strings.HasPrefix(link, "/") || filepath.VolumeName(link) != "" {
+		return true
+	}
+	target := path.Join(path.Dir(rel), filepath.ToSlash(link))
+	return target == ".." || strings.HasPrefix(target, "../")
+}
+
+// findEscapingSymlinks returns the symlinks under root that point outside
+// it, as "path -> target", skipping filesArchiveExcludes and skip.
+func findEscapingSymlinks(root string, skip map[string]bool) ([]string, error) {
+	var escaping []string
+	err := walkFilesDir(root, func(rel string, d fs.DirEntry) error {
+		if skip[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		full := filepath.Join(root, filepath.FromSlash(rel))
+		link, err := os.Readlink(full)
+		if err != nil {
+			return err
+		}
+		if symlinkEscapes(rel, link) {
+			escaping = append(escaping, full+" -> "+link)
+		}
+		return nil
+	})
+	return escaping, err
+}
+
+// checkArchiveName fails for tar entry names that would extract outside
+// the directory: absolute or with ".." components.
+func checkArchiveName(name string) error {
+	if strings.HasPrefix(name, "/") {
+		return fmt.Errorf("refusing to archive %q: not a relative path", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return fmt.Errorf("refusing to archive %q: it has a \"..\" component", name)
+		}
+	}
+	return nil
+}
+
+// archiveDir is a directory added to a files archive under Prefix.
+type archiveDir struct {
+	Path   string
+	Prefix string // slash-separated, e.g. "translations"
+	// Skip holds entries left out, as relative slash-separated paths.
+	Skip map[string]bool
+}
+
+// sitesManifest lists the sites of a multisite files archive, whose files
+// are under sites/SITE/. The server mounts each on docroot/sites/SITE/files.
+const sitesManifest = "preview-sites.json"
+
+// writeTarArchive writes an uncompressed tar of root to w, equivalent to
+// "tar cf - -C root ." with filesArchiveExcludes. Entries in skip (relative
+// slash-separated paths) are left out; a skipped directory is left out with
+// everything below it. Each extra directory is added under its prefix.
+// Paths always use forward slashes, so archives built on Windows extract
+// correctly on the server.
+func writeTarArchive(w io.Writer, root string, skip map[string]bool, extra ...archiveDir) error {
+	tw := tar.NewWriter(w)
+
+	if err := addTarTree(tw, root, "", skip); err != nil {
+		return err
+	}
+	for _, dir := range extra {
+		if err := addTarTree(tw, dir.Path, dir.Prefix, dir.Skip); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeSitesArchive writes an uncompressed tar of the files directories of
+// a multisite to w: a sitesManifest of sites, then each directory under its
+// prefix, as writeTarArchive does.
+func writeSitesArchive(w io.Writer, sites []string, dirs []archiveDir) error {
+	tw := tar.NewWriter(w)
+
+	manifest, err := json.Marshal(map[string][]string{"sites": sites})
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     "./" + sitesManifest,
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := addTarTree(tw, dir.Path, dir.Prefix, dir.Skip); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// addTarTree adds root to tw with entry names "./prefix/rel". Symlinks
+// are archived as links, or with --deref replaced by what they point to,
+// or with --skip-symlinks left out.
+func addTarTree(tw *tar.Writer, root, prefix string, skip map[string]bool) error {
+	seen := map[string]bool{}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		seen[real] = true
+	}
+	return addTarWalk(tw, root, prefix, skip, walkFilesDir, seen)
+}
+
+// addTarWalk adds the entries walk finds under root to tw. seen holds the
+// directories being archived, to stop at a symlink loop with --deref.
+func addTarWalk(tw *tar.Writer, root, prefix string, skip map[string]bool, walk func(string, func(string, fs.DirEntry) error) error, seen map[string]bool) error {
+	return walk(root, func(rel string, d fs.DirEntry) error {
+		if skip[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := rel
+		if prefix != "" {
+			name = path.Join(prefix, rel)
+		}
+		full := filepath.Join(root, filepath.FromSlash(rel))
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if d.Type()&fs.ModeSymlink != 0 {
+			switch {
+			case skipSymlinks:
+				return nil
+			case derefSymlinks:
+				return addTarDeref(tw, full, name, seen)
+			}
+			link, err = os.Readlink(full)
+			if err != nil {
+				return err
+			}
+			if symlinkEscapes(rel, link) {
+				return fmt.Errorf("symlink %s points outside %s (to %s)", full, root, link)
+			}
+		} else if !d.IsDir() && !d.Type().IsRegular() {
+			// Sockets, devices and pipes can't be meaningfully archived.
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		if err := writeTarHeader(tw, hdr, name, d.IsDir()); err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyTarFile(tw, full)
+	})
+}
+
+// addTarDeref adds what the symlink full points to under name: a file's
+// content or a directory's tree. A dangling symlink is left out.
+func addTarDeref(tw *tar.Writer, full, name string, seen map[string]bool) error {
+	target, err := filepath.EvalSymlinks(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Skipping dangling symlink %s\n", full)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if seen[target] {
+			return fmt.Errorf("symlink %s loops back to %s", full, target)
+		}
+		seen[target] = true
+		defer delete(seen, target)
+		return addTarWalk(tw, target, name, nil, walkDir, seen)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err := writeTarHeader(tw, hdr, name, false); err != nil {
+		return err
+	}
+	return copyTarFile(tw, target)
+}
+
+// writeTarHeader writes hdr with the entry name "./name", checked with
+// checkArchiveName.
+func writeTarHeader(tw *tar.Writer, hdr *tar.Header, name string, isDir bool) error {
+	hdr.Name = "./" + name
+	if name == "." {
+		hdr.Name = "./"
+	} else if isDir {
+		hdr.Name += "/"
+	}
+	if err := checkArchiveName(hdr.Name); err != nil {
+		return err
+	}
+	return tw.WriteHeader(hdr)
+}
+
+func copyTarFile(tw *tar.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// gzipWriter compresses into an underlying writer. Close flushes all data
+// and reports any compressor failure.
+type gzipWriter interface {
+	io.WriteCloser
+	Name() string
+}
+
+// newGzipWriter returns a level 6 gzip compressor writing to w. By default
+// it compresses in-process with pgzip, which uses all cores like pigz and
+// needs no external binary. With --use-system-compressor it uses pigz or
+// gzip from PATH instead, falling back to pgzip if neither exists.
+func newGzipWriter(w io.Writer) (gzipWriter, error) {
+	if useSystemCompressor && runtime.GOOS != "windows" {
+		for _, name := range []string{"pigz", "gzip"} {
+			if _, err := exec.LookPath(name); err == nil {
+				return newExecGzipWriter(w, name)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Neither pigz nor gzip found in PATH, using built-in compressor.")
+	}
+	gz, err := pgzip.NewWriterLevel(w, 6)
+	if err != nil {
+		return nil, err
+	}
+	return nativeGzipWriter{gz}, nil
+}
+
+type nativeGzipWriter struct {
+	*pgzip.Writer
+}
+
+func (nativeGzipWriter) Name() string { return "pgzip" }
+
+// execGzipWriter pipes writes through an external compressor process.
+type execGzipWriter struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func newExecGzipWriter(w io.Writer, name string) (*execGzipWriter, error) {
+	cmd := exec.Command(name, "-6", "-c")
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s pipe: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	return &execGzipWriter{name: name, cmd: cmd, stdin: stdin}, nil
+}
+
+func (e *execGzipWriter) Write(p []byte) (int, error) { return e.stdin.Write(p) }
+
+func (e *execGzipWriter) Name() string { return e.name }
+
+func (e *execGzipWriter) Close() error {
+	e.stdin.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %w", e.name, err)
+	}
+	return nil
+}
+
+// gzipVerifier passes a gzip stream through while decompressing it on the
+// side, so a stream that is corrupt or lacks its trailer fails the read
+// of its end instead of being uploaded as complete.
+type gzipVerifier struct {
+	r    io.Reader
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+}
+
+func verifyGzip(r io.Reader) *gzipVerifier {
+	pr, pw := io.Pipe()
+	v := &gzipVerifier{r: io.TeeReader(r, pw), pw: pw, done: make(chan error, 1)}
+	go func() {
+		gz, err := pgzip.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(io.Discard, gz)
+		}
+		// Stops the tee at the first invalid byte
+		pr.CloseWithError(err)
+		v.done <- err
+	}()
+	return v
+}
+
+func (v *gzipVerifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.r.Read(p)
+	switch {
+	case err == io.EOF:
+		v.pw.Close()
+		if verr := <-v.done; verr != nil {
+			err = fmt.Errorf("invalid gzip stream: %w", verr)
+		}
+		v.err = err
+	case err != nil:
+		v.pw.CloseWithError(err)
+		select {
+		case verr := <-v.done:
+			if verr != nil {
+				// The tee was stopped by the verifier
+				err = fmt.Errorf("invalid gzip stream: %w", verr)
+			}
+		default:
+		}
+		v.err = err
+	}
+	return n, err
+}
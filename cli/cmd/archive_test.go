@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// hostileFiles are files with names that break shell and glob handling,
+// heavy ones and light ones whose names a glob of a heavy one matches.
+var hostileFiles = map[string]bool{
+	"sp ace.bin":           true,
+	"new\nline.bin":        true,
+	"star*.bin":            true,
+	"stark.bin":            false,
+	"brack[1].bin":         true,
+	"brack1.bin":           false,
+	"qu?.bin":              true,
+	"qux.bin":              false,
+	`back\slash.bin`:       true,
+	"ünïcödé.bin":          true,
+	"-dash.bin":            true,
+	"dir with space/a.bin": true,
+	"dir with space/b.txt": false,
+	"'quote\".bin":         true,
+}
+
+func writeHostileFiles(t *testing.T) (string, []string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't allow these file names")
+	}
+	root := t.TempDir()
+	var heavy []string
+	for name, isHeavy := range hostileFiles {
+		size := 10
+		if isHeavy {
+			size = 2048
+			heavy = append(heavy, name)
+		}
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(heavy)
+	return root, heavy
+}
+
+// tarFiles lists the regular files of a tar, without their "./".
+func tarFiles(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			files = append(files, strings.TrimPrefix(hdr.Name, "./"))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func lightFiles() []string {
+	var light []string
+	for name, isHeavy := range hostileFiles {
+		if !isHeavy {
+			light = append(light, name)
+		}
+	}
+	sort.Strings(light)
+	return light
+}
+
+func TestHeavyFilesHostileNames(t *testing.T) {
+	root, want := writeHostileFiles(t)
+
+	heavy, err := findHeavyFiles(root, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(heavy)
+	if !reflect.DeepEqual(heavy, want) {
+		t.Fatalf("findHeavyFiles = %q, want %q", heavy, want)
+	}
+
+	skip := map[string]bool{}
+	for _, f := range heavy {
+		skip[f] = true
+	}
+	var buf bytes.Buffer
+	if err := writeTarArchive(&buf, root, skip); err != nil {
+		t.Fatal(err)
+	}
+	if got := tarFiles(t, &buf); !reflect.DeepEqual(got, lightFiles()) {
+		t.Fatalf("archive has %q, want %q", got, lightFiles())
+	}
+}
+
+func TestTarExcludesHostileNames(t *testing.T) {
+	root, heavy := writeHostileFiles(t)
+	if out, err := exec.Command("tar", "--version").Output(); err != nil || !bytes.Contains(out, []byte("GNU tar")) {
+		t.Skip("GNU tar not available")
+	}
+
+	excludes := filepath.Join(t.TempDir(), "excludes.txt")
+	f, err := os.Create(excludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTarExcludes(f, heavy); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, err := exec.Command("tar", "cf", "-", "-C", root, "--exclude-from="+excludes, ".").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tarFiles(t, bytes.NewReader(out)); !reflect.DeepEqual(got, lightFiles()) {
+		t.Fatalf("tar --exclude-from archived %q, want %q", got, lightFiles())
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var artifactsOutput string
+
+var artifactsCmd = &cobra.Command{
+	Use:   "artifacts",
+	Short: "List and download files from a preview container",
+	Long: `List and download arbitrary files from a preview's PHP container, such as
+compiled theme assets, generated PDFs or log files.
+
+Paths are absolute paths inside the container and may contain glob
+wildcards (*, ?, [...] and {a,b}).`,
+}
+
+var artifactsListCmd = &cobra.Command{
+	Use:   "list [PROJECT/PREVIEW-NAME] PATTERN",
+	Short: "List files in a preview container matching a pattern",
+	Long: `List the files in a preview's PHP container matching PATTERN.
+
+If PROJECT/PREVIEW-NAME is given, lists files of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview artifacts list drupal-test/mr-5 '/var/www/html/web/sites/default/files/*.pdf'
+  preview artifacts list '/var/www/html/web/themes/custom/*/dist/*'`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, previewName, err := resolveArtifactsTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		pattern := args[len(args)-1]
+
+		artifacts, err := apiClient.ListArtifacts(cmd.Context(), project, previewName, pattern)
+		if err != nil {
+			return err
+		}
+		if len(artifacts) == 0 {
+			fmt.Fprintf(os.Stderr, "No files match %s on %s/%s.\n", pattern, project, previewName)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SIZE\tPATH")
+		for _, a := range artifacts {
+			fmt.Fprintf(w, "%s\t%s\n", formatBytesShort(a.SizeBytes), a.Path)
+		}
+		return w.Flush()
+	},
+}
+
+var artifactsGetCmd = &cobra.Command{
+	Use:   "get [PROJECT/PREVIEW-NAME] PATH",
+	Short: "Download files from a preview container",
+	Long: `Download a file from a preview's PHP container.
+
+If PATH contains wildcards, every matching file is downloaded into the
+--output directory (default: the current directory). Otherwise the file is
+saved to --output, or to its base name in the current directory.
+
+If PROJECT/PREVIEW-NAME is given, downloads from that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview artifacts get drupal-test/mr-5 /var/www/html/web/sites/default/files/report.pdf -o report.pdf
+  preview artifacts get drupal-test/mr-5 '/var/www/html/web/sites/default/files/*.pdf' -o reports/`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		project, previewName, err := resolveArtifactsTarget(ctx, args)
+		if err != nil {
+			return err
+		}
+		source := args[len(args)-1]
+
+		if !hasGlob(source) {
+			output := artifactsOutput
+			if output == "" {
+				output = path.Base(source)
+			} else if info, err := os.Stat(output); err == nil && info.IsDir() {
+				output = filepath.Join(output, path.Base(source))
+			}
+			return downloadArtifact(ctx, project, previewName, source, output)
+		}
+
+		artifacts, err := apiClient.ListArtifacts(ctx, project, previewName, source)
+		if err != nil {
+			return err
+		}
+		if len(artifacts) == 0 {
+			return fmt.Errorf("no files match %s on %s/%s", source, project, previewName)
+		}
+
+		dir := artifactsOutput
+		if dir == "" {
+			dir = "."
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cannot create directory: %w", err)
+		}
+
+		// Files are saved by base name, so matches from different
+		// directories must not collide.
+		seen := make(map[string]string)
+		for _, a := range artifacts {
+			name := path.Base(a.Path)
+			if other, ok := seen[name]; ok {
+				return fmt.Errorf("%s and %s would both be saved as %s; narrow the pattern", other, a.Path, name)
+			}
+			seen[name] = a.Path
+		}
+
+		for _, a := range artifacts {
+			if err := downloadArtifact(ctx, project, previewName, a.Path, filepath.Join(dir, path.Base(a.Path))); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Downloaded %d files to %s\n", len(artifacts), dir)
+		return nil
+	},
+}
+
+// resolveArtifactsTarget returns the preview from args, which end with a
+// path or pattern, or detects it from git.
+func resolveArtifactsTarget(ctx context.Context, args []string) (project, previewName string, err error) {
+	if len(args) == 2 {
+		return parsePreviewName(args[0])
+	}
+	return detectPreview(ctx)
+}
+
+// hasGlob reports whether p contains glob wildcards.
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[{")
+}
+
+// downloadArtifact saves the file at source in the preview container to
+// output, removing output if the download fails.
+func downloadArtifact(ctx context.Context, project, previewName, source, output string) error {
+	fmt.Fprintf(os.Stderr, "Downloading %s to %s...\n", source, output)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+	defer f.Close()
+
+	if err := apiClient.DownloadArtifact(ctx, project, previewName, source, f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
+
+func init() {
+	artifactsGetCmd.Flags().StringVarP(&artifactsOutput, "output", "o", "", "Output file, or directory when PATH has wildcards")
+	artifactsCmd.AddCommand(artifactsListCmd)
+	artifactsCmd.AddCommand(artifactsGetCmd)
+	rootCmd.AddCommand(artifactsCmd)
+}
@@ -1,19 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
-	"strings"
 	"time"
 
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 func openBrowser(url string) {
@@ -23,6 +23,9 @@ func openBrowser(url string) {
 		cmd = exec.Command("open", url)
 	case "linux":
 		cmd = exec.Command("xdg-open", url)
+	case "windows":
+		// Not "cmd /c start", which splits URLs at their & separators
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
 	default:
 		return
 	}
@@ -33,125 +36,193 @@ const defaultAPIURL = "https://api.preview-mr.com"
 const appURL = "https://app.preview-mr.com"
 
 var loginNoBrowser bool
+var loginSSO bool
+var loginDevice bool
+var loginTimeout time.Duration
 
 var authLoginCmd = &cobra.Command{
-	Use:   "login",
-	Short: "Authenticate with Preview Manager",
-	Long:  "Opens the browser to authenticate. After approval, the CLI is logged in persistently.",
-	Args:  cobra.NoArgs,
+	Use:         "login",
+	Short:       "Authenticate with Preview Manager",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Opens the browser to authenticate. After approval, the CLI is logged in persistently.
+
+With --device, nothing is opened: the approval URL and request code are
+printed to approve from a browser on another device, e.g. when logged in to
+a remote workstation over SSH (the default in SSH sessions). The CLI keeps
+polling until the request is approved or --timeout passes.
+
+With --sso, authenticates through your organization's single sign-on. If
+your account belongs to several organizations you are asked to pick one;
+change it later with 'preview org switch'.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg := loadConfig()
-		if cfg.APIURL == "" {
-			cfg.APIURL = defaultAPIURL
-		}
-
-		// Check if already logged in
-		if cfg.Token != "" {
-			user, err := fetchCurrentUser(cfg)
-			if err == nil {
-				fmt.Printf("Already logged in as %s (%s)", user.Name, user.Email)
-				if user.Role != nil {
-					fmt.Printf(" [%s]", *user.Role)
-				}
-				fmt.Println()
-				fmt.Fprintln(os.Stderr, "Run 'preview logout' first to switch accounts.")
-				return nil
+		if loginTimeout <= 0 {
+			return fmt.Errorf("--timeout must be positive, e.g. --timeout 15m")
+		}
+		if loginSSO {
+			if err := requireOrgs(); err != nil {
+				return err
 			}
-			// Token invalid — continue with login flow
 		}
+		device := loginDevice || !cmd.Flags().Changed("device") && sshSession()
+		return login(cmd.Context(), device)
+	},
+}
 
-		// Generate random code
-		b := make([]byte, 16)
-		if _, err := rand.Read(b); err != nil {
-			return fmt.Errorf("failed to generate code: %w", err)
-		}
-		code := hex.EncodeToString(b)
+// login authorizes the CLI in the browser and saves the token. With
+// device, the approval URL is printed for another device instead of
+// opened.
+func login(ctx context.Context, device bool) error {
+	cfg := loadConfig()
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultAPIURL
+	}
 
-		// POST /api/auth/cli/request
-		reqURL := fmt.Sprintf("%s/api/auth/cli/request", cfg.APIURL)
-		payload := fmt.Sprintf(`{"code": %q}`, code)
-		resp, err := http.Post(reqURL, "application/json", strings.NewReader(payload))
-		if err != nil {
-			return fmt.Errorf("failed to request auth: %w", err)
+	// Check if already logged in
+	if cfg.Token != "" {
+		user, err := fetchCurrentUser(ctx, cfg)
+		if err == nil {
+			fmt.Printf("Already logged in as %s (%s)", user.Name, user.Email)
+			if user.Role != nil {
+				fmt.Printf(" [%s]", *user.Role)
+			}
+			fmt.Println()
+			fmt.Fprintln(os.Stderr, "Run 'preview logout' first to switch accounts.")
+			return nil
 		}
-		resp.Body.Close()
+		// Token invalid — continue with login flow
+	}
 
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("auth request failed (HTTP %d)", resp.StatusCode)
-		}
+	// Generate random code
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate code: %w", err)
+	}
+	code := hex.EncodeToString(b)
 
-		// Open browser
-		approveURL := fmt.Sprintf("%s/auth/cli?code=%s", appURL, code)
-		fmt.Printf("Open this URL to authenticate:\n\n  %s\n\n", approveURL)
+	// POST /api/auth/cli/request
+	c := newPublicClient(cfg.APIURL)
+	if err := c.RequestCLIAuth(ctx, code); err != nil {
+		return err
+	}
 
+	// Open browser
+	approveURL := fmt.Sprintf("%s/auth/cli?code=%s", appURL, code)
+	if loginSSO {
+		approveURL += "&sso=1"
+	}
+	if device {
+		fmt.Printf("On a device with a browser, open this URL to authenticate:\n\n  %s\n\n", approveURL)
+		// The server names the token after the start of the code
+		fmt.Printf("Request code: %s (the new token is listed as \"CLI (%s)\" in your API tokens)\n\n", code[:8], code[:8])
+	} else {
+		fmt.Printf("Open this URL to authenticate:\n\n  %s\n\n", approveURL)
 		if !loginNoBrowser {
 			openBrowser(approveURL)
 		}
+	}
 
-		fmt.Print("Waiting for authorization... (press Ctrl+C to cancel)\n")
-
-		// Poll for approval
-		pollURL := fmt.Sprintf("%s/api/auth/cli/poll/%s", cfg.APIURL, code)
-		timeout := time.After(5 * time.Minute)
-		ticker := time.NewTicker(2 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-timeout:
-				return fmt.Errorf("authorization timed out after 5 minutes")
-			case <-ticker.C:
-				token, err := pollAuth(pollURL)
-				if err != nil {
-					return err
-				}
-				if token != "" {
-					cfg.Token = token
-					if err := saveConfig(cfg); err != nil {
-						return fmt.Errorf("failed to save token: %w", err)
-					}
-					fmt.Println("Logged in successfully!")
-					return nil
-				}
-			}
-		}
-	},
-}
-
-func pollAuth(url string) (string, error) {
-	resp, err := http.Get(url)
+	auth, err := pollCLIAuth(ctx, c, code)
 	if err != nil {
-		return "", fmt.Errorf("poll failed: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
 
-	if resp.StatusCode == 404 {
-		return "", fmt.Errorf("auth request expired or not found")
+	cfg.Token = auth.Token
+	cfg.RefreshToken = auth.RefreshToken
+	cfg.User = ""
+	// Keep an organization set by 'preview setup team' or
+	// 'preview config import' if the user belongs to it
+	preset := cfg.Org
+	cfg.Org = ""
+	if org, err := findOrg(auth.Orgs, preset); preset != "" && err == nil {
+		cfg.Org = org.ID
+	} else if len(auth.Orgs) > 1 && noInput {
+		fmt.Println("You belong to several organizations; choose one with 'preview org switch ORG'.")
+	} else if len(auth.Orgs) > 0 {
+		org, err := selectOrg(auth.Orgs)
+		if err != nil {
+			return err
+		}
+		cfg.Org = org.ID
 	}
-
-	var result struct {
-		Status string `json:"status"`
-		Token  string `json:"token"`
+	// Without it API responses aren't cached
+	if user, err := fetchCurrentUser(ctx, cfg); err == nil {
+		cfg.User = user.Email
 	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("decode error: %w", err)
+	if err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
 	}
+	fmt.Println("Logged in successfully!")
+	return nil
+}
+
+// pollCLIAuth polls until the login request code is approved or
+// --timeout passes, showing the time left.
+func pollCLIAuth(ctx context.Context, c client.AccountAPI, code string) (*client.CLIAuth, error) {
+	deadline := time.Now().Add(loginTimeout)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	// A terminal gets a countdown; logs a line a minute
+	interactive := term.IsTerminal(int(os.Stdout.Fd()))
+	reported := time.Time{}
+	var pollErr error
+	for {
+		left := time.Until(deadline).Round(time.Second)
+		if left <= 0 {
+			if interactive {
+				fmt.Println()
+			}
+			if pollErr != nil {
+				return nil, fmt.Errorf("authorization timed out after %s: %w", loginTimeout, pollErr)
+			}
+			return nil, fmt.Errorf("authorization timed out after %s (use --timeout to wait longer)", loginTimeout)
+		}
+		if interactive {
+			fmt.Printf("\r\033[2KWaiting for authorization... %s left (press Ctrl+C to cancel)", left)
+		} else if time.Since(reported) >= time.Minute {
+			fmt.Printf("Waiting for authorization... %s left\n", left)
+			reported = time.Now()
+		}
 
-	if result.Status == "approved" {
-		return result.Token, nil
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		auth, err := c.PollCLIAuth(ctx, code)
+		if err != nil && !errors.Is(err, client.ErrNotFound) && ctx.Err() == nil {
+			// A network blip doesn't end the wait, the deadline does
+			pollErr = err
+			continue
+		}
+		if err == nil && auth == nil {
+			continue
+		}
+		if interactive {
+			fmt.Println()
+		}
+		return auth, err
 	}
-	return "", nil
+}
+
+// sshSession reports whether the CLI runs in an SSH session, where a
+// browser opened on the remote machine can't be used.
+func sshSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
 }
 
 var authLogoutCmd = &cobra.Command{
-	Use:   "logout",
-	Short: "Log out of Preview Manager",
-	Args:  cobra.NoArgs,
+	Use:         "logout",
+	Short:       "Log out of Preview Manager",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Args:        cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg := loadConfig()
 		cfg.Token = ""
+		cfg.RefreshToken = ""
+		cfg.Org = ""
+		cfg.User = ""
 		if err := saveConfig(cfg); err != nil {
 			return fmt.Errorf("failed to save config: %w", err)
 		}
@@ -171,54 +242,39 @@ var whoamiCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		user, err := fetchCurrentUser(cfg)
+		user, err := fetchCurrentUser(cmd.Context(), cfg)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Token is invalid or expired. Run 'preview login' to re-authenticate.")
 			os.Exit(1)
 		}
 
+		if cfg.User != user.Email {
+			// Logins of earlier versions didn't keep it
+			cfg.User = user.Email
+			saveConfig(cfg)
+		}
+
 		fmt.Printf("Logged in as %s (%s)", user.Name, user.Email)
 		if user.Role != nil {
 			fmt.Printf(" [%s]", *user.Role)
 		}
 		fmt.Println()
+		if cfg.Org != "" {
+			fmt.Printf("Organization: %s\n", cfg.Org)
+		}
 		return nil
 	},
 }
 
-type userInfo struct {
-	Email string  `json:"email"`
-	Name  string  `json:"name"`
-	Role  *string `json:"role"`
-}
-
-func fetchCurrentUser(cfg config) (*userInfo, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/auth/me", cfg.APIURL), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cfg.Token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
-	}
-
-	var user userInfo
-	body, _ := io.ReadAll(resp.Body)
-	if err := json.Unmarshal(body, &user); err != nil {
-		return nil, err
-	}
-	return &user, nil
+func fetchCurrentUser(ctx context.Context, cfg config) (*client.User, error) {
+	return newClient(cfg).CurrentUser(ctx)
 }
 
 func init() {
 	authLoginCmd.Flags().BoolVar(&loginNoBrowser, "no-browser", false, "Don't open the URL in a browser")
+	authLoginCmd.Flags().BoolVar(&loginDevice, "device", false, "Print the URL to approve from another device, without opening a browser (default in SSH sessions)")
+	authLoginCmd.Flags().DurationVar(&loginTimeout, "timeout", 5*time.Minute, "Give up waiting for approval after this long")
+	authLoginCmd.Flags().BoolVar(&loginSSO, "sso", false, "Log in with your organization's single sign-on")
 	rootCmd.AddCommand(authLoginCmd)
 	rootCmd.AddCommand(authLogoutCmd)
 	rootCmd.AddCommand(whoamiCmd)
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var authStatusOutput string
+
+// tokenExpiryWarning is how long before its expiry status warns about a
+// token that can't be renewed.
+const tokenExpiryWarning = 7 * 24 * time.Hour
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Inspect the credentials of the CLI",
+}
+
+// authStatus is the output of auth status.
+type authStatus struct {
+	Server string      `json:"server"`
+	User   client.User `json:"user"`
+	Org    string      `json:"org,omitempty"`
+	Token  struct {
+		// Source is where the details come from: "server", "jwt" (decoded
+		// from the token) or "" if unknown
+		Source      string   `json:"source"`
+		Name        string   `json:"name,omitempty"`
+		Prefix      string   `json:"prefix,omitempty"`
+		CreatedAt   string   `json:"created_at,omitempty"`
+		LastUsedAt  *string  `json:"last_used_at,omitempty"`
+		ExpiresAt   *string  `json:"expires_at"`
+		Scopes      []string `json:"scopes"`
+		Projects    []string `json:"projects,omitempty"`
+		Renewable   bool     `json:"renewable"`
+		ExpiresSoon bool     `json:"expires_soon"`
+	} `json:"token"`
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check the token and show its expiry and scopes",
+	Long: `Check that the saved token is accepted by the server and show who it
+belongs to, when it expires, what it allows and which server issued it.
+
+Warns when the token expires within 7 days and can't be renewed
+automatically, and exits with status 1 if there is no token or the server
+rejects it, so CI jobs can check their credentials before a long push.
+
+Examples:
+  preview auth status
+  preview auth status --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if authStatusOutput != "text" && authStatusOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", authStatusOutput)
+		}
+		cfg := loadConfig()
+		if cfg.Token == "" {
+			fmt.Fprintln(os.Stderr, "Not logged in. Run 'preview login' first.")
+			os.Exit(1)
+		}
+
+		c := newClient(cfg)
+		user, err := c.CurrentUser(cmd.Context())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Token is invalid or expired: %v\nRun 'preview login' to re-authenticate.\n", err)
+			os.Exit(1)
+		}
+
+		status := authStatus{Server: cfg.APIURL, User: *user, Org: cfg.Org}
+		status.Token.Renewable = c.RefreshToken != ""
+		info, err := c.GetTokenInfo(cmd.Context())
+		switch {
+		case err == nil:
+			status.Token.Source = "server"
+			status.Token.Name, status.Token.Prefix = info.Name, info.TokenPrefix
+			status.Token.CreatedAt, status.Token.LastUsedAt = info.CreatedAt, info.LastUsedAt
+			status.Token.ExpiresAt, status.Token.Scopes, status.Token.Projects = info.ExpiresAt, info.Scopes, info.Projects
+		case errors.Is(err, client.ErrNotFound):
+			// Older servers: the token may be a JWT that says it all
+			if exp, scopes, ok := jwtClaims(c.Token); ok {
+				status.Token.Source = "jwt"
+				status.Token.ExpiresAt, status.Token.Scopes = exp, scopes
+			}
+		default:
+			return fmt.Errorf("failed to get token details: %w", err)
+		}
+		if status.Token.Scopes == nil {
+			status.Token.Scopes = []string{}
+		}
+
+		var expires time.Time
+		if status.Token.ExpiresAt != nil {
+			expires, _ = time.Parse(time.RFC3339, *status.Token.ExpiresAt)
+		}
+		status.Token.ExpiresSoon = !expires.IsZero() && !status.Token.Renewable && time.Until(expires) < tokenExpiryWarning
+
+		if authStatusOutput == "json" {
+			data, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			printAuthStatus(status, expires)
+		}
+		if status.Token.ExpiresSoon {
+			fmt.Fprintf(os.Stderr, "Warning: the token expires in %s. Run 'preview login' to get a new one.\n", formatTimeLeft(time.Until(expires)))
+		}
+		return nil
+	},
+}
+
+func printAuthStatus(s authStatus, expires time.Time) {
+	fmt.Printf("Server:        %s\n", s.Server)
+	fmt.Printf("User:          %s (%s)", s.User.Name, s.User.Email)
+	if s.User.Role != nil {
+		fmt.Printf(" [%s]", *s.User.Role)
+	}
+	fmt.Println()
+	if s.Org != "" {
+		fmt.Printf("Organization:  %s\n", s.Org)
+	}
+	if s.Token.Name != "" {
+		fmt.Printf("Token:         %s (%s...)\n", s.Token.Name, s.Token.Prefix)
+	}
+	if s.Token.CreatedAt != "" {
+		fmt.Printf("Created:       %s\n", formatUploadTime(s.Token.CreatedAt))
+	}
+	if s.Token.LastUsedAt != nil {
+		fmt.Printf("Last used:     %s\n", formatUploadTime(*s.Token.LastUsedAt))
+	}
+	switch {
+	case s.Token.Source == "":
+		fmt.Println("Expires:       unknown (the server doesn't report it)")
+	case s.Token.ExpiresAt == nil:
+		fmt.Println("Expires:       never")
+	case expires.IsZero():
+		fmt.Printf("Expires:       %s\n", *s.Token.ExpiresAt)
+	default:
+		line := fmt.Sprintf("%s (in %s)", expires.Local().Format("2006-01-02 15:04"), formatTimeLeft(time.Until(expires)))
+		if s.Token.Renewable {
+			line += ", renewed automatically"
+		}
+		fmt.Printf("Expires:       %s\n", line)
+	}
+	if len(s.Token.Scopes) > 0 {
+		fmt.Printf("Scopes:        %s\n", strings.Join(s.Token.Scopes, ", "))
+	}
+	if len(s.Token.Projects) > 0 {
+		fmt.Printf("Projects:      %s\n", strings.Join(s.Token.Projects, ", "))
+	}
+}
+
+// formatTimeLeft formats the time until an expiry, e.g. "3 days" or "5h".
+func formatTimeLeft(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "0h (expired)"
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
+
+// jwtClaims returns the expiry and scopes of token if it is a JWT. The
+// signature isn't checked: only the server can, and they are only shown.
+func jwtClaims(token string) (expiresAt *string, scopes []string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, false
+	}
+	var claims struct {
+		Exp   int64    `json:"exp"`
+		Scope string   `json:"scope"`
+		Scp   []string `json:"scp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, nil, false
+	}
+	if claims.Exp > 0 {
+		exp := time.Unix(claims.Exp, 0).UTC().Format(time.RFC3339)
+		expiresAt = &exp
+	}
+	scopes = claims.Scp
+	if claims.Scope != "" {
+		scopes = strings.Fields(claims.Scope)
+	}
+	return expiresAt, scopes, true
+}
+
+func init() {
+	authStatusCmd.Flags().StringVarP(&authStatusOutput, "output", "o", "text", "Output format: text or json")
+	authCmd.AddCommand(authStatusCmd)
+	rootCmd.AddCommand(authCmd)
+}
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var baseCmd = &cobra.Command{
+	Use:   "base",
+	Short: "Inspect and restore a project's base database and files",
+	Long:  "Inspect the base database and files that new previews are created from, and restore prior versions of them.",
+}
+
+var baseHistoryCmd = &cobra.Command{
+	Use:   "history [PROJECT]",
+	Short: "Show the upload history of the base database and files",
+	Long: `Show who uploaded the base database and files of a project, when, their
+size and SHA-256 checksum, newest first. The latest upload of each kind is
+in use; prior versions the server still keeps are restorable with
+'preview base restore'.
+
+If no project is given, it is detected from the git remote in the current directory.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var slug string
+		if len(args) == 1 {
+			slug = args[0]
+		} else {
+			var err error
+			slug, err = detectProjectSlug()
+			if err != nil {
+				return err
+			}
+		}
+
+		uploads, err := apiClient.GetBaseFilesHistory(cmd.Context(), slug)
+		if err != nil {
+			return err
+		}
+		if len(uploads) == 0 {
+			fmt.Printf("No base files have been uploaded for project %q.\n", slug)
+			return nil
+		}
+
+		printBaseHistory(uploads)
+		return nil
+	},
+}
+
+var baseRestoreVersion string
+
+var baseRestoreCmd = &cobra.Command{
+	Use:   "restore db|files|db-NAME [PROJECT] --version VERSION",
+	Short: "Put a prior version of the base database or files back in use",
+	Long: `Roll back a botched push (wrong database, unsanitized dump...) by putting a
+prior version of the base database, files or secondary database (db-NAME)
+back in use. The server keeps the last versions uploads replaced, marked
+restorable by 'preview base history'.
+
+--version is the date of the upload in local time, as 'preview base history'
+shows it (2024-11-03), more of its time when several uploads share the date
+("2024-11-03 14:05"), or the start of its SHA-256. The version in use is kept in turn, so a restore can
+be undone the same way.
+
+New previews are created from the restored version. Existing previews see
+restored files at once but keep their database until rebuilt.
+
+If no project is given, it is detected from the git remote in the current directory.
+
+Examples:
+  preview base restore db --version 2024-11-03
+  preview base restore files drupal-test --version 3a6eb0790f39`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind := args[0]
+		if name, ok := strings.CutPrefix(kind, "db-"); kind != "db" && kind != "files" && (!ok || !validDatabaseName(name)) {
+			return fmt.Errorf("invalid kind %q: expected db, files or db-NAME for a secondary database", kind)
+		}
+		if baseRestoreVersion == "" {
+			return fmt.Errorf("--version is required: the date or SHA-256 of an upload listed by 'preview base history'")
+		}
+		var slug string
+		if len(args) == 2 {
+			slug = args[1]
+		} else {
+			var err error
+			slug, err = detectProjectSlug()
+			if err != nil {
+				return err
+			}
+		}
+		if err := requireBaseRestore(); err != nil {
+			return err
+		}
+
+		uploads, err := apiClient.GetBaseFilesHistory(cmd.Context(), slug)
+		if err != nil {
+			return err
+		}
+		version, err := findBaseVersion(uploads, kind, baseRestoreVersion)
+		if err != nil {
+			return err
+		}
+
+		ok, err := confirm(fmt.Sprintf("Replace the base %s of %q with the one uploaded %s by %s (%s)?",
+			kind, slug, formatUploadTime(version.UploadedAt), version.UploadedBy, formatBytesShort(version.SizeBytes)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+
+		stop := startSpinner(fmt.Sprintf("Restoring the base %s of %q...", kind, slug))
+		_, err = apiClient.RestoreBaseFile(cmd.Context(), slug, kind, version.SHA256)
+		stop()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored the base %s of %q uploaded %s.\n", kind, slug, formatUploadTime(version.UploadedAt))
+		if kind != "files" {
+			fmt.Println("Existing previews keep their database until rebuilt ('preview rebuild').")
+		}
+		return nil
+	},
+}
+
+// findBaseVersion returns the restorable upload of kind matching version,
+// the start of its local upload time, as formatUploadTime shows it, or of
+// its SHA-256.
+func findBaseVersion(uploads []client.BaseFileUpload, kind, version string) (*client.BaseFileUpload, error) {
+	var current *client.BaseFileUpload
+	matches := map[string]*client.BaseFileUpload{}
+	// 2024-11-03T14:05 as well as 2024-11-03 14:05
+	date := strings.Replace(version, "T", " ", 1)
+	for i := range uploads {
+		u := &uploads[i]
+		if u.Kind != kind {
+			continue
+		}
+		if u.Current {
+			current = u
+		}
+		if strings.HasPrefix(localUploadTime(u.UploadedAt), date) || strings.HasPrefix(u.SHA256, strings.ToLower(version)) {
+			matches[u.SHA256] = u
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no base %s has been uploaded", kind)
+	}
+	if len(matches) > 1 {
+		// e.g. the date of both the upload in use and an earlier one
+		delete(matches, current.SHA256)
+	}
+
+	var found []string
+	var match *client.BaseFileUpload
+	for _, u := range matches {
+		found = append(found, fmt.Sprintf("%s (%s)", localUploadTime(u.UploadedAt), shortSHA(u.SHA256)))
+		match = u
+	}
+	switch {
+	case len(matches) == 0:
+		return nil, fmt.Errorf("no base %s upload matches version %q (see 'preview base history')", kind, version)
+	case len(matches) > 1:
+		sort.Strings(found)
+		return nil, fmt.Errorf("version %q matches several base %s uploads: %s; give more of the date or SHA-256", version, kind, strings.Join(found, ", "))
+	case match.SHA256 == current.SHA256:
+		return nil, fmt.Errorf("the base %s uploaded %s is the one in use", kind, formatUploadTime(match.UploadedAt))
+	case !match.Restorable:
+		return nil, fmt.Errorf("the base %s uploaded %s is no longer kept on the server", kind, formatUploadTime(match.UploadedAt))
+	}
+	return match, nil
+}
+
+var baseVerifyOutput string
+
+var baseVerifyCmd = &cobra.Command{
+	Use:   "verify [PROJECT]",
+	Short: "Check the integrity of the base database and files on the server",
+	Long: `Have the server check the stored base database and files of a project:
+that their gzip streams are complete, that the database looks like an SQL
+dump creating tables, that the files archive lists completely and was
+extracted, and that they still match the checksum recorded on upload.
+Encrypted bases are only checked against their checksum.
+
+The server reads the bases in full, which can take minutes for large ones.
+Exits with an error if a check fails, so it can run on a schedule.
+
+If no project is given, it is detected from the git remote in the current directory.
+
+Examples:
+  preview base verify drupal-test
+  preview base verify --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if baseVerifyOutput != "text" && baseVerifyOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", baseVerifyOutput)
+		}
+		var slug string
+		if len(args) == 1 {
+			slug = args[0]
+		} else {
+			var err error
+			slug, err = detectProjectSlug()
+			if err != nil {
+				return err
+			}
+		}
+		if err := requireBaseVerify(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Verifying the base files of %q on the server...\n", slug)
+		checks, err := apiClient.VerifyBaseFiles(cmd.Context(), slug)
+		if err != nil {
+			return err
+		}
+
+		if baseVerifyOutput == "json" {
+			data, err := json.MarshalIndent(checks, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			printBaseChecks(checks)
+		}
+
+		var failed []string
+		for _, c := range checks {
+			if c.Status == "failed" {
+				failed = append(failed, c.Kind)
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("base %s of %q failed verification; upload it again with 'preview push'", strings.Join(failed, " and "), slug)
+		}
+		return nil
+	},
+}
+
+func printBaseChecks(checks []client.BaseFileCheck) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tSTATUS\tSIZE\tSHA256\tDETAILS")
+	for _, c := range checks {
+		status := c.Status
+		if status == "failed" {
+			status = "FAILED"
+		}
+		size, sum := "-", "-"
+		if c.SizeBytes > 0 {
+			size, sum = formatBytesShort(c.SizeBytes), shortSHA(c.SHA256)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Kind, status, size, sum, c.Message)
+	}
+	w.Flush()
+}
+
+func printBaseHistory(uploads []client.BaseFileUpload) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tUPLOADED\tBY\tSIZE\tSHA256\tSTATE")
+	for _, u := range uploads {
+		by := u.UploadedBy
+		if u.RestoredFrom != "" {
+			by += ", restoring " + formatUploadTime(u.RestoredFrom)
+		}
+		state := ""
+		switch {
+		case u.Current:
+			state = "in use"
+		case u.Restorable:
+			state = "restorable"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			u.Kind, formatUploadTime(u.UploadedAt), by, formatBytesShort(u.SizeBytes), shortSHA(u.SHA256), state)
+	}
+	w.Flush()
+}
+
+// formatUploadTime renders an RFC 3339 timestamp in local time with its age,
+// e.g. "2024-05-02 14:03 (12 days ago)".
+func formatUploadTime(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return fmt.Sprintf("%s (%s)", t.Local().Format("2006-01-02 15:04"), formatAge(time.Since(t)))
+}
+
+// localUploadTime renders an RFC 3339 timestamp in local time to the
+// second, e.g. "2024-05-02 14:03:27", or returns it as is if invalid.
+func localUploadTime(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return "just now"
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+}
+
+func shortSHA(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+func init() {
+	baseVerifyCmd.Flags().StringVarP(&baseVerifyOutput, "output", "o", "text", "Output format: text or json")
+	baseRestoreCmd.Flags().StringVar(&baseRestoreVersion, "version", "", "Local date (2024-11-03) or SHA-256 prefix of the upload to restore")
+	baseCmd.AddCommand(baseHistoryCmd, baseRestoreCmd, baseVerifyCmd)
+	rootCmd.AddCommand(baseCmd)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+)
+
+func TestFindBaseVersionLocalTime(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	uploads := []client.BaseFileUpload{
+		{Kind: "db", UploadedAt: "2024-11-04T08:00:00Z", SHA256: "cccc", Current: true},
+		// 2024-11-04 00:30 in local time, as 'base history' shows it
+		{Kind: "db", UploadedAt: "2024-11-03T22:30:00Z", SHA256: "bbbb", Restorable: true},
+		{Kind: "db", UploadedAt: "2024-11-02T10:00:00Z", SHA256: "aaaa", Restorable: true},
+	}
+
+	for _, version := range []string{"2024-11-04 00", "2024-11-04T00:30", "BBB"} {
+		u, err := findBaseVersion(uploads, "db", version)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if u.SHA256 != "bbbb" {
+			t.Fatalf("%s: got upload %s", version, u.SHA256)
+		}
+	}
+	if _, err := findBaseVersion(uploads, "db", "2024-11-03"); err == nil || !strings.Contains(err.Error(), "no base db upload matches") {
+		t.Fatalf("UTC date matched: %v", err)
+	}
+}
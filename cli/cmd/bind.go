@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var bindRepo bool
+var bindClear bool
+
+var bindCmd = &cobra.Command{
+	Use:         "bind [PROJECT/PREVIEW-NAME]",
+	Short:       "Bind the current branch or repository to a preview",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Bind the current git branch to a preview, so commands that detect the
+preview from the branch (drush, pull, url, daemon...) use it instead. For
+branches whose name doesn't match the branch of their preview, e.g. a local
+branch working on the stage preview.
+
+The binding is saved in the git config of the repository (.git/config), as
+branch.BRANCH.preview; --repo binds the whole repository instead, as
+preview.binding, for all branches without a binding of their own. Nothing
+is shared with other clones.
+
+Without arguments, shows the binding of the current branch. --clear
+removes it (with --repo, that of the repository).
+
+Examples:
+  preview bind drupal-test/branch-stage
+  preview bind drupal-test/mr-5 --repo
+  preview bind
+  preview bind --clear`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bindClear && len(args) > 0 {
+			return fmt.Errorf("--clear and PROJECT/PREVIEW-NAME can't be used together")
+		}
+		branch, err := detectGitBranch()
+		if err != nil {
+			return err
+		}
+		key, scope := "branch."+branch+".preview", "branch "+branch
+		if bindRepo {
+			key, scope = "preview.binding", "the repository"
+		}
+
+		switch {
+		case bindClear:
+			if _, ok := gitConfigGet(key); !ok {
+				return fmt.Errorf("%s isn't bound to a preview", scope)
+			}
+			if err := exec.Command("git", "config", "--local", "--unset", key).Run(); err != nil {
+				return fmt.Errorf("failed to remove the binding: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Binding of %s removed.\n", scope)
+		case len(args) == 1:
+			project, previewName, err := parsePreviewName(args[0])
+			if err != nil {
+				return err
+			}
+			value := project + "/" + previewName
+			if err := exec.Command("git", "config", "--local", key, value).Run(); err != nil {
+				return fmt.Errorf("failed to save the binding: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Bound %s to %s.\n", scope, value)
+		default:
+			project, previewName, ok := boundPreview(branch)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Branch %s isn't bound; its preview is detected from the branch name.\n", branch)
+				return nil
+			}
+			fmt.Printf("%s/%s\n", project, previewName)
+		}
+		return nil
+	},
+}
+
+// gitConfigGet returns the value of key in the git config, and false if it
+// isn't set.
+func gitConfigGet(key string) (string, bool) {
+	out, err := exec.Command("git", "config", "--get", key).Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// boundPreview returns the preview bound to branch with preview bind, or
+// else to the repository, and false if there is none.
+func boundPreview(branch string) (project, previewName string, ok bool) {
+	value, ok := gitConfigGet("branch." + branch + ".preview")
+	if !ok {
+		if value, ok = gitConfigGet("preview.binding"); !ok {
+			return "", "", false
+		}
+	}
+	project, previewName, err := parsePreviewName(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring invalid preview binding %q: %v\n", value, err)
+		return "", "", false
+	}
+	return project, previewName, true
+}
+
+func init() {
+	bindCmd.Flags().BoolVar(&bindRepo, "repo", false, "Bind the repository, for all branches without a binding of their own")
+	bindCmd.Flags().BoolVar(&bindClear, "clear", false, "Remove the binding")
+	rootCmd.AddCommand(bindCmd)
+}
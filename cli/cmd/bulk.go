@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+// bulkWorkers bounds how many previews a bulk operation acts on at once, so
+// sweeping a large project doesn't overload the server.
+const bulkWorkers = 4
+
+// previewTarget is one preview a bulk operation acts on.
+type previewTarget struct {
+	Project string
+	Name    string
+}
+
+func (t previewTarget) String() string { return t.Project + "/" + t.Name }
+
+// projectPreviews returns every preview of project.
+func projectPreviews(ctx context.Context, project string) ([]previewTarget, error) {
+	if strings.Contains(project, "/") {
+		return nil, fmt.Errorf("expected a PROJECT, got %q", project)
+	}
+	result, err := apiClient.ListPreviews(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var targets []previewTarget
+	for _, p := range result.Previews {
+		if p.Project == project {
+			targets = append(targets, previewTarget{Project: p.Project, Name: p.Name})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no previews found for project %q", project)
+	}
+	return targets, nil
+}
+
+// runBulk runs fn on every target with up to bulkWorkers in parallel. Each
+// target's output is printed as it finishes, followed by a summary. It
+// returns false if any target failed.
+func runBulk(ctx context.Context, targets []previewTarget, fn func(ctx context.Context, t previewTarget) (*client.ActionResult, error)) bool {
+	type outcome struct {
+		target previewTarget
+		err    string
+	}
+	outcomes := make([]outcome, len(targets))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkWorkers)
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t previewTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, err := fn(ctx, t)
+			o := outcome{target: t}
+			switch {
+			case err != nil:
+				o.err = err.Error()
+			case !result.Success:
+				o.err = firstLine(result.Error)
+				if o.err == "" {
+					o.err = "failed"
+				}
+			}
+			outcomes[i] = o
+
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(os.Stderr, "==> %s\n", t)
+			if result != nil {
+				printActionResult(result)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", o.err)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, o := range outcomes {
+		if o.err != "" {
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nSummary: %d succeeded, %d failed\n", len(outcomes)-failed, failed)
+	for _, o := range outcomes {
+		if o.err != "" {
+			fmt.Fprintf(os.Stderr, "  FAIL  %s: %s\n", o.target, o.err)
+		} else {
+			fmt.Fprintf(os.Stderr, "  OK    %s\n", o.target)
+		}
+	}
+	return failed == 0
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// newActionCommand builds start, stop and restart, which run action on one
+// or more MR previews, or with --all on every preview of a project.
+func newActionCommand(action, verb, short string) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   action + " PROJECT/mr-ID... | PROJECT --all",
+		Short: short,
+		Long: fmt.Sprintf(`%s
+
+Several previews can be given at once, or --all to act on every preview
+of a project. Bulk operations run %d at a time and end with a summary.
+
+Examples:
+  preview %s drupal-test/mr-5
+  preview %s drupal-test/mr-1 drupal-test/mr-2
+  preview %s drupal-test --all`, short, bulkWorkers, action, action, action),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !all && len(args) == 1 {
+				project, mrID, err := parsePreviewArg(args[0])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "%s %s/mr-%d...\n", verb, project, mrID)
+				result, err := apiClient.PostAction(cmd.Context(), project, mrID, action)
+				if err != nil {
+					return err
+				}
+				printActionResult(result)
+				if !result.Success {
+					os.Exit(1)
+				}
+				return nil
+			}
+
+			var targets []previewTarget
+			if all {
+				if len(args) != 1 {
+					return fmt.Errorf("--all takes a single PROJECT")
+				}
+				var err error
+				if targets, err = projectPreviews(cmd.Context(), args[0]); err != nil {
+					return err
+				}
+			} else {
+				for _, arg := range args {
+					project, mrID, err := parsePreviewArg(arg)
+					if err != nil {
+						return err
+					}
+					targets = append(targets, previewTarget{Project: project, Name: fmt.Sprintf("mr-%d", mrID)})
+				}
+			}
+
+			fmt.Fprintf(os.Stderr, "%s %d previews...\n", verb, len(targets))
+			ok := runBulk(cmd.Context(), targets, func(ctx context.Context, t previewTarget) (*client.ActionResult, error) {
+				return apiClient.PostActionByName(ctx, t.Project, t.Name, action)
+			})
+			if !ok {
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Act on every preview of PROJECT")
+	return cmd
+}
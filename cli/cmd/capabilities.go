@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// serverCaps are the cached capabilities of the configured server, nil if
+// they couldn't be fetched.
+var serverCaps *client.Capabilities
+
+// refreshCapabilitiesCache fetches the server capabilities if the cache is
+// older than 24h or belongs to another server (max 1.5s timeout).
+func refreshCapabilitiesCache(cfg *config) {
+	if cfg.CapabilitiesURL == cfg.APIURL && cfg.LastCapabilitiesCheck > 0 &&
+		time.Since(time.Unix(cfg.LastCapabilitiesCheck, 0)) < 24*time.Hour {
+		return
+	}
+	fetchCapabilities(cfg)
+}
+
+// fetchCapabilities caches the current capabilities of the server in cfg,
+// reporting whether it answered (max 1.5s timeout).
+func fetchCapabilities(cfg *config) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	caps, err := newPublicClient(cfg.APIURL).GetCapabilities(ctx)
+	if err != nil {
+		return false
+	}
+
+	cfg.Capabilities = caps
+	cfg.Help = nil
+	if caps.CLIHelp {
+		if help, err := newPublicClient(cfg.APIURL).GetCLIHelp(ctx); err == nil {
+			cfg.Help = help
+		}
+	}
+	cfg.CapabilitiesURL = cfg.APIURL
+	cfg.LastCapabilitiesCheck = time.Now().Unix()
+	saveConfig(*cfg)
+	return true
+}
+
+// capabilitiesRechecked is set once serverCaps has been fetched afresh in
+// this run, see recheckCapabilities.
+var capabilitiesRechecked bool
+
+// recheckCapabilities reports whether the server supports a feature the
+// cached serverCaps say it doesn't. The cache may predate an upgrade of the
+// server, so it is fetched afresh, once per run, before refusing.
+func recheckCapabilities(supported func(*client.Capabilities) bool) bool {
+	if !capabilitiesRechecked {
+		capabilitiesRechecked = true
+		cfg := loadConfig()
+		if cfg.APIURL != "" && fetchCapabilities(&cfg) {
+			serverCaps = cachedCapabilities(cfg)
+		}
+	}
+	return serverCaps == nil || supported(serverCaps)
+}
+
+// cachedCapabilities returns the cached capabilities of the configured
+// server, or nil if there are none.
+func cachedCapabilities(cfg config) *client.Capabilities {
+	if cfg.CapabilitiesURL != cfg.APIURL {
+		return nil
+	}
+	return cfg.Capabilities
+}
+
+// requireCapability fails early if the server is known not to support
+// feature, which it gained in release since. When the capabilities are
+// unknown the command goes ahead and the server has the last word.
+func requireCapability(feature, since string, supported func(*client.Capabilities) bool) error {
+	if serverCaps == nil || supported(serverCaps) || recheckCapabilities(supported) {
+		return nil
+	}
+	current := ""
+	if serverCaps.Version != "" {
+		current = fmt.Sprintf(" (it runs v%s)", serverCaps.Version)
+	}
+	return fmt.Errorf("server does not support %s%s, upgrade it to v%s or later", feature, current, since)
+}
+
+// requireStreamingDrush fails early if drush and composer can't run
+// interactively on the server.
+func requireStreamingDrush() error {
+	return requireCapability("interactive drush and composer", "1.8.0", func(c *client.Capabilities) bool {
+		return c.StreamingDrush
+	})
+}
+
+// requireScale fails early if the server can't change the resource limits
+// of a preview.
+func requireScale() error {
+	return requireCapability("scaling previews", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Scale
+	})
+}
+
+// requireDBSync fails early if the server can't sync base databases from
+// production.
+func requireDBSync() error {
+	return requireCapability("syncing databases from production", "1.8.0", func(c *client.Capabilities) bool {
+		return c.DBSync
+	})
+}
+
+// requireServerInfo fails early if the server doesn't report its health.
+func requireServerInfo() error {
+	return requireCapability("server info", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Info
+	})
+}
+
+// requireHeavyFilesManifest fails early if the server doesn't record the
+// files left out by push --strip-heavy-files.
+func requireHeavyFilesManifest() error {
+	return requireCapability("heavy files manifests", "1.8.0", func(c *client.Capabilities) bool {
+		return c.HeavyFilesManifest
+	})
+}
+
+// requireDeployRun fails early if the server can't re-run deploy scripts.
+func requireDeployRun() error {
+	return requireCapability("re-running deploy scripts", "1.8.0", func(c *client.Capabilities) bool {
+		return c.DeployRun
+	})
+}
+
+// requireMultisite fails early if the server can't take the files of
+// several Drupal sites in one base files archive.
+func requireMultisite() error {
+	return requireCapability("multisite files archives", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Multisite
+	})
+}
+
+// requireTests fails early if the server can't run preview.yml test suites.
+func requireTests() error {
+	return requireCapability("test suites", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Tests
+	})
+}
+
+// requirePipelines fails early if the server can't read GitLab pipelines.
+func requirePipelines() error {
+	return requireCapability("pipelines", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Pipelines
+	})
+}
+
+// requireBaseVerify fails early if the server can't verify base files.
+func requireBaseVerify() error {
+	return requireCapability("verifying base files", "1.8.0", func(c *client.Capabilities) bool {
+		return c.BaseVerify
+	})
+}
+
+// requireServices fails early if the server can't reach the redis and
+// solr services of previews.
+func requireServices() error {
+	return requireCapability("redis and solr helpers", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Services
+	})
+}
+
+// requireScaffoldTemplates fails early if the server can't host scaffold
+// templates.
+func requireScaffoldTemplates() error {
+	return requireCapability("scaffold templates", "1.8.0", func(c *client.Capabilities) bool {
+		return c.ScaffoldTemplates
+	})
+}
+
+// requireValidate fails early if the server can't validate preview.yml.
+func requireValidate() error {
+	return requireCapability("validating preview.yml", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Validate
+	})
+}
+
+// requireRetarget fails early if the server can't move previews to
+// another branch or MR.
+func requireRetarget() error {
+	return requireCapability("retargeting previews", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Retarget
+	})
+}
+
+// requireTableDumps fails early if the server can't limit database dumps
+// to some tables.
+func requireTableDumps() error {
+	return requireCapability("dumping some tables", "1.8.0", func(c *client.Capabilities) bool {
+		return c.TableDumps
+	})
+}
+
+// requireUploadSessions fails early if the server can't list and abort
+// chunked uploads.
+func requireUploadSessions() error {
+	return requireCapability("managing uploads", "1.8.0", func(c *client.Capabilities) bool {
+		return c.UploadSessions
+	})
+}
+
+// requirePreviewSize fails early if the server can't break down the disk
+// usage of previews.
+func requirePreviewSize() error {
+	return requireCapability("measuring preview sizes", "1.8.0", func(c *client.Capabilities) bool {
+		return c.PreviewSize
+	})
+}
+
+// requireFilesSync fails early if the server can't sync files into a
+// running preview.
+func requireFilesSync() error {
+	return requireCapability("syncing files", "1.8.0", func(c *client.Capabilities) bool {
+		return c.FilesSync
+	})
+}
+
+// requireCLIChannels fails early if the server doesn't publish beta CLI
+// releases.
+func requireCLIChannels() error {
+	return requireCapability("the beta update channel", "1.8.0", func(c *client.Capabilities) bool {
+		return c.CLIChannels
+	})
+}
+
+// requireDBTunnel fails early if the server can't tunnel connections to
+// the database of previews.
+func requireDBTunnel() error {
+	return requireCapability("database tunnels", "1.8.0", func(c *client.Capabilities) bool {
+		return c.DBTunnel
+	})
+}
+
+// requireXdebug fails early if the server can't turn Xdebug of previews
+// on.
+func requireXdebug() error {
+	return requireCapability("Xdebug", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Xdebug
+	})
+}
+
+// requireLogs fails early if the server can't follow the logs of
+// previews.
+func requireLogs() error {
+	return requireCapability("preview logs", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Logs
+	})
+}
+
+// requireMail fails early if previews of the server don't capture mail.
+func requireMail() error {
+	return requireCapability("mail capture", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Mail
+	})
+}
+
+// requireTriggers fails early if previews of the server can't have trigger
+// URLs.
+func requireTriggers() error {
+	return requireCapability("trigger URLs", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Triggers
+	})
+}
+
+// requireDatabases fails early if the server doesn't support secondary
+// databases.
+func requireDatabases() error {
+	return requireCapability("secondary databases", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Databases
+	})
+}
+
+// requireIPAllowlist fails early if previews of the server can't be
+// restricted by IP.
+func requireIPAllowlist() error {
+	return requireCapability("IP allowlists", "1.8.0", func(c *client.Capabilities) bool {
+		return c.IPAllowlist
+	})
+}
+
+// requireCustomDomains fails early if previews of the server can't be
+// served at custom domains.
+func requireCustomDomains() error {
+	return requireCapability("custom domains", "1.8.0", func(c *client.Capabilities) bool {
+		return c.CustomDomains
+	})
+}
+
+// requireBaseRestore fails early if the server keeps no prior versions of
+// the base files to restore.
+func requireBaseRestore() error {
+	return requireCapability("restoring base files", "1.8.0", func(c *client.Capabilities) bool {
+		return c.BaseRestore
+	})
+}
+
+// requireAnonymize fails early if the server can't anonymize the database
+// of a preview in place.
+func requireAnonymize() error {
+	return requireCapability("anonymizing preview databases", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Anonymize
+	})
+}
+
+// requirePlan fails early if the server can't plan preview.yml changes.
+func requirePlan() error {
+	return requireCapability("planning preview.yml changes", "1.8.0", func(c *client.Capabilities) bool {
+		return c.Plan
+	})
+}
+
+// requireOrgs fails early if the server has no organizations to log in to
+// or switch between. No release has them yet, so unlike other features
+// there is no version to upgrade to.
+func requireOrgs() error {
+	orgs := func(c *client.Capabilities) bool { return c.Orgs }
+	if serverCaps == nil || orgs(serverCaps) || recheckCapabilities(orgs) {
+		return nil
+	}
+	return fmt.Errorf("server does not support organizations: SSO login and org switch need a server with organizations")
+}
+
+// requireQuotedDrushArgs fails early if drush args need quoting and the
+// server would split them on spaces instead, quotes included. Args without
+// spaces or quotes work with any server.
+func requireQuotedDrushArgs(args []string) error {
+	if shellJoin(args) == strings.Join(args, " ") {
+		return nil
+	}
+	return requireCapability("drush arguments with spaces or quotes", "1.8.0", func(c *client.Capabilities) bool {
+		return c.QuotedDrushArgs
+	})
+}
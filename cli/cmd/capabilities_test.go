@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/capynet/preview-server/client/clienttest"
+)
+
+func TestRequireQuotedDrushArgs(t *testing.T) {
+	defer func(caps *client.Capabilities) { serverCaps = caps }(serverCaps)
+	t.Setenv("HOME", t.TempDir())
+	query := []string{"sql-query", "SELECT 1"}
+
+	serverCaps = &client.Capabilities{Version: "1.7.2"}
+	if err := requireQuotedDrushArgs([]string{"cr"}); err != nil {
+		t.Fatalf("args without quoting refused: %v", err)
+	}
+	if err := requireQuotedDrushArgs(query); err == nil {
+		t.Fatal("expected a server splitting args on spaces to be refused")
+	}
+	serverCaps = &client.Capabilities{QuotedDrushArgs: true}
+	if err := requireQuotedDrushArgs(query); err != nil {
+		t.Fatal(err)
+	}
+	serverCaps = nil
+	if err := requireQuotedDrushArgs(query); err != nil {
+		t.Fatalf("unknown capabilities refused: %v", err)
+	}
+}
+
+func TestRequireCapabilityRechecks(t *testing.T) {
+	defer func(caps *client.Capabilities, rechecked bool) {
+		serverCaps, capabilitiesRechecked = caps, rechecked
+	}(serverCaps, capabilitiesRechecked)
+	t.Setenv("HOME", t.TempDir())
+	srv := clienttest.NewServer(t)
+	srv.Capabilities = &client.Capabilities{Version: "1.9.0", Scale: true}
+	saveConfig(config{APIURL: srv.URL})
+
+	// Cached before the server was upgraded
+	serverCaps, capabilitiesRechecked = &client.Capabilities{Version: "1.7.2"}, false
+	if err := requireScale(); err != nil {
+		t.Fatalf("upgraded server refused: %v", err)
+	}
+	if cfg := loadConfig(); cfg.Capabilities == nil || !cfg.Capabilities.Scale {
+		t.Fatalf("cache not updated: %+v", cfg.Capabilities)
+	}
+	if err := requireStreamingDrush(); err == nil {
+		t.Fatal("expected a feature the server lacks to be refused")
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var checkPaths []string
+var checkTimeout time.Duration
+var checkOutput string
+
+// maxCheckRedirects is the longest redirect chain followed by check.
+const maxCheckRedirects = 10
+
+var checkCmd = &cobra.Command{
+	Use:   "check [PROJECT/PREVIEW-NAME]",
+	Short: "Smoke-check the pages of a preview",
+	Long: `Request paths of a preview's URL and report their status codes, redirects
+and response times. The preview's basic auth credentials are sent
+automatically. Exits with a non-zero status if any request fails or answers
+with a 4xx or 5xx status, which makes it a quick post-deploy gate for CI.
+
+If PROJECT/PREVIEW-NAME is given, checks that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview check drupal-test/mr-5
+  preview check drupal-test/mr-5 --paths /,/node/1,/admin
+  preview check --paths /user/login --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if checkOutput != "text" && checkOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", checkOutput)
+		}
+
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		preview, err := findPreview(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+		if preview.URL == "" {
+			return fmt.Errorf("preview %s/%s has no URL yet", project, previewName)
+		}
+		var user, pass string
+		if preview.BasicAuthUser != nil && preview.BasicAuthPass != nil {
+			user, pass = *preview.BasicAuthUser, *preview.BasicAuthPass
+		}
+
+		results := make([]checkResult, 0, len(checkPaths))
+		failed := 0
+		for _, p := range checkPaths {
+			r := checkPath(ctx, preview.URL, p, user, pass)
+			if !r.OK {
+				failed++
+			}
+			results = append(results, r)
+		}
+
+		if checkOutput == "json" {
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			printCheckResults(results)
+			fmt.Fprintf(os.Stderr, "\n%d of %d checks passed on %s\n", len(results)-failed, len(results), preview.URL)
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// checkResult is the outcome of requesting one path of a preview.
+type checkResult struct {
+	Path string `json:"path"`
+	// Status is the status code of the final response, 0 if the request
+	// failed.
+	Status int `json:"status"`
+	// Redirects are the URLs redirected to, in order.
+	Redirects  []string `json:"redirects"`
+	DurationMS int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+	OK         bool     `json:"ok"`
+}
+
+// checkPath requests p on baseURL, following redirects with the basic auth
+// credentials, and times the full response.
+func checkPath(ctx context.Context, baseURL, p, user, pass string) checkResult {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	result := checkResult{Path: p, Redirects: []string{}}
+
+	httpClient := &http.Client{
+		Transport: httpTransport,
+		Timeout:   checkTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxCheckRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxCheckRedirects)
+			}
+			result.Redirects = append(result.Redirects, req.URL.String())
+			if user != "" {
+				req.SetBasicAuth(user, pass)
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(baseURL, "/")+p, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		result.Status = resp.StatusCode
+	}
+	result.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = result.Status < 400
+	return result
+}
+
+func printCheckResults(results []checkResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSTATUS\tTIME\tRESULT")
+	for _, r := range results {
+		status := "-"
+		if r.Status != 0 {
+			status = fmt.Sprint(r.Status)
+		}
+		outcome := "ok"
+		switch {
+		case r.Error != "":
+			outcome = "error: " + r.Error
+		case !r.OK:
+			outcome = "FAILED"
+		}
+		if len(r.Redirects) > 0 {
+			outcome += fmt.Sprintf(" (redirected to %s", r.Redirects[len(r.Redirects)-1])
+			if len(r.Redirects) > 1 {
+				outcome += fmt.Sprintf(" after %d redirects", len(r.Redirects))
+			}
+			outcome += ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%dms\t%s\n", r.Path, status, r.DurationMS, outcome)
+	}
+	w.Flush()
+}
+
+func init() {
+	checkCmd.Flags().StringSliceVar(&checkPaths, "paths", []string{"/"}, "Comma-separated paths to request")
+	checkCmd.Flags().DurationVar(&checkTimeout, "timeout", 30*time.Second, "Timeout of each request, including redirects")
+	checkCmd.Flags().StringVarP(&checkOutput, "output", "o", "text", "Output format: text or json")
+	rootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// copyToClipboard copies text to the system clipboard with the platform's
+// clipboard tool.
+func copyToClipboard(text string) error {
+	var tools [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		tools = [][]string{{"pbcopy"}}
+	case "windows":
+		tools = [][]string{{"clip"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			tools = append(tools, []string{"wl-copy"})
+		}
+		// clip.exe is the Windows clipboard under WSL
+		tools = append(tools, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"}, []string{"clip.exe"})
+	}
+
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool[0]
+		path, err := exec.LookPath(tool[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		// Output isn't captured: xclip and wl-copy stay in the background
+		// to serve the selection, which would keep a pipe open.
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %w", tool[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install %s)", strings.Join(names, " or "))
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// composerPackages returns the versions of the packages composer.lock in
+// the current directory locks, dev packages included, by name. Versions
+// lose their "v" prefix.
+func composerPackages() (map[string]string, error) {
+	data, err := os.ReadFile("composer.lock")
+	if err != nil {
+		return nil, err
+	}
+	var lock struct {
+		Packages    []struct{ Name, Version string } `json:"packages"`
+		PackagesDev []struct{ Name, Version string } `json:"packages-dev"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid composer.lock: %w", err)
+	}
+	packages := make(map[string]string)
+	for _, p := range append(lock.Packages, lock.PackagesDev...) {
+		packages[p.Name] = strings.TrimPrefix(p.Version, "v")
+	}
+	return packages, nil
+}
+
+// parseMinorVersion returns the major and minor version of a composer or
+// PHP version such as "10.2.5", "8.3" or "10.3.x-dev", and false if it
+// doesn't start with a number (e.g. "dev-main").
+func parseMinorVersion(version string) (int, int, bool) {
+	parts := strings.SplitN(version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor, true
+}
+
+// previewYmlSettings returns the php_version previews run and the
+// services preview.yml in the current directory enables, with the
+// defaults of the server when it or they aren't set.
+func previewYmlSettings() (php string, redis, solr bool) {
+	yml := loadPreviewYml()
+	php = yml.PHPVersion
+	if php == "" {
+		php = serverHelp(loadConfig()).DefaultPHPVersion
+	}
+	return php, yml.Services.Redis, yml.Services.Solr
+}
+
+// drupalPHPRange returns the lowest and highest PHP versions, as major*100
+// + minor, that Drupal core major.minor supports.
+func drupalPHPRange(major, minor int) (int, int) {
+	switch {
+	case major <= 8:
+		return 700, 704
+	case major == 9 && minor <= 2:
+		return 703, 800
+	case major == 9:
+		return 703, 801
+	case major == 10 && minor <= 1:
+		return 801, 802
+	case major == 10 && minor <= 3:
+		return 801, 803
+	case major == 10:
+		return 801, 804
+	default:
+		return 803, 804
+	}
+}
+
+func formatPHPVersion(v int) string {
+	return fmt.Sprintf("%d.%d", v/100, v%100)
+}
+
+// compatCheck is the result of a compatibility check between the Drupal
+// project and what its previews run.
+type compatCheck struct {
+	// Status is ok, warning or error
+	Status  string
+	Message string
+	Hint    string
+}
+
+// checkDrupalCompatibility checks the Drupal core version and modules
+// composer.lock locks against the PHP version, database and services of
+// preview.yml (or the template defaults). It returns nil if there is no
+// composer.lock with drupal/core.
+func checkDrupalCompatibility() ([]compatCheck, string) {
+	packages, err := composerPackages()
+	if err != nil {
+		return nil, ""
+	}
+	core, ok := packages["drupal/core"]
+	if !ok {
+		return nil, ""
+	}
+	coreMajor, coreMinor, ok := parseMinorVersion(core)
+	if !ok {
+		return []compatCheck{{"warning", "drupal/core " + core + " is a development version; compatibility not checked", ""}}, core
+	}
+
+	var checks []compatCheck
+	php, redis, solr := previewYmlSettings()
+	if phpMajor, phpMinor, ok := parseMinorVersion(php); ok {
+		lowest, highest := drupalPHPRange(coreMajor, coreMinor)
+		v := phpMajor*100 + phpMinor
+		switch {
+		case v < lowest:
+			checks = append(checks, compatCheck{"error",
+				fmt.Sprintf("Drupal %d.%d needs PHP %s or later; previews run PHP %s", coreMajor, coreMinor, formatPHPVersion(lowest), php),
+				fmt.Sprintf(`Set php_version: "%s" in preview.yml`, formatPHPVersion(max(lowest, 801)))})
+		case v > highest && highest < 801:
+			checks = append(checks, compatCheck{"error",
+				fmt.Sprintf("Drupal %d.%d supports PHP up to %s, older than previews run (8.1 and later)", coreMajor, coreMinor, formatPHPVersion(highest)),
+				"Update Drupal core to 9.3 or later before creating previews"})
+		case v > highest:
+			checks = append(checks, compatCheck{"error",
+				fmt.Sprintf("Drupal %d.%d supports PHP up to %s; previews run PHP %s", coreMajor, coreMinor, formatPHPVersion(highest), php),
+				fmt.Sprintf(`Set php_version: "%s" in preview.yml, or update Drupal core`, formatPHPVersion(highest))})
+		default:
+			checks = append(checks, compatCheck{"ok", fmt.Sprintf("PHP %s supports Drupal %d.%d", php, coreMajor, coreMinor), ""})
+		}
+	}
+
+	db := previewYmlDatabase()
+	mysql, mariadb := [2]int{5, 7}, [2]int{10, 3}
+	if coreMajor >= 11 {
+		mysql, mariadb = [2]int{8, 0}, [2]int{10, 6}
+	}
+	wanted := mysql
+	if db.Type == "mariadb" {
+		wanted = mariadb
+	}
+	if db.atLeast(wanted[0], wanted[1]) {
+		checks = append(checks, compatCheck{"ok", fmt.Sprintf("%s supports Drupal %d", db, coreMajor), ""})
+	} else {
+		checks = append(checks, compatCheck{"error",
+			fmt.Sprintf("Drupal %d needs %s %d.%d or later; previews run %s", coreMajor, db.Type, wanted[0], wanted[1], db),
+			fmt.Sprintf("Set database: %s:%d.%d (or later) in preview.yml", db.Type, wanted[0], wanted[1])})
+	}
+
+	if redisModule, ok := packages["drupal/redis"]; redis && !ok {
+		checks = append(checks, compatCheck{"warning", "The redis service is enabled but drupal/redis isn't installed, so Drupal won't use it",
+			"ddev composer require drupal/redis, or set services.redis: false"})
+	} else if redis {
+		checks = append(checks, compatCheck{"ok", "drupal/redis " + redisModule + " for the redis service", ""})
+	}
+	if solrModule, ok := packages["drupal/search_api_solr"]; solr && !ok {
+		checks = append(checks, compatCheck{"warning", "The solr service is enabled but drupal/search_api_solr isn't installed",
+			"ddev composer require drupal/search_api_solr, or set services.solr: false"})
+	} else if solr {
+		// Previews run Solr 9, supported from search_api_solr 4.2
+		if major, minor, ok := parseMinorVersion(solrModule); ok && (major < 4 || major == 4 && minor < 2) {
+			checks = append(checks, compatCheck{"warning",
+				"drupal/search_api_solr " + solrModule + " predates Solr 9, which previews run",
+				"ddev composer require drupal/search_api_solr:^4.2"})
+		} else {
+			checks = append(checks, compatCheck{"ok", "drupal/search_api_solr " + solrModule + " for the solr service", ""})
+		}
+	}
+	return checks, core
+}
+
+// printDrupalCompatibility prints the compatibility checks of drupal/core
+// version core and returns the number of errors.
+func printDrupalCompatibility(checks []compatCheck, core string) int {
+	fmt.Printf("Drupal compatibility (drupal/core %s):\n", core)
+	errs := 0
+	for _, c := range checks {
+		mark := "✓"
+		switch c.Status {
+		case "error":
+			mark = "✗"
+			errs++
+		case "warning":
+			mark = "⚠"
+		}
+		fmt.Printf("  %s %s\n", mark, c.Message)
+		if c.Hint != "" {
+			fmt.Printf("      → %s\n", c.Hint)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:         "completion bash|zsh|fish|powershell",
+	Short:       "Generate the completion script of a shell",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Print the script completing preview commands, previews and flags in a shell.
+
+Bash (needs the bash-completion package):
+  source <(preview completion bash)
+  # for every session
+  preview completion bash > ~/.local/share/bash-completion/completions/preview
+
+Zsh:
+  preview completion zsh > "${fpath[1]}/_preview"
+
+Fish:
+  preview completion fish > ~/.config/fish/completions/preview.fish
+
+PowerShell:
+  preview completion powershell | Out-String | Invoke-Expression
+  # for every session
+  Add-Content $PROFILE 'preview completion powershell | Out-String | Invoke-Expression'`,
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		}
+		return fmt.Errorf("unsupported shell %q", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var composerRestart bool
+var composerDeploy bool
+
+var composerCmd = &cobra.Command{
+	Use:   "composer [PROJECT/PREVIEW-NAME] -- [args...]",
+	Short: "Run a composer command on a preview",
+	Long: `Run composer inside the preview's PHP container, streaming its output.
+
+Useful for quickly trying a module on a preview before changing the MR.
+Changes are lost on the next rebuild.
+
+If PROJECT/PREVIEW-NAME is given, runs composer on that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview composer drupal-test/mr-5 -- require drupal/pathauto
+  preview composer --deploy -- require drupal/pathauto
+  preview composer drupal-test/mr-5 -- show drupal/core`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if cmd.ArgsLenAtDash() == 0 {
+			// "preview composer -- require ...": everything is composer args
+			project, previewName, err = detectPreview(cmd.Context())
+		} else {
+			project, previewName, args, err = resolvePreviewAndArgs(cmd.Context(), args)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("no composer arguments provided")
+		}
+
+		if err := requireStreamingDrush(); err != nil {
+			return err
+		}
+
+		composerArgs := shellJoin(args)
+		fmt.Fprintf(os.Stderr, "Running composer %s on %s/%s...\n", composerArgs, project, previewName)
+
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.ComposerInteractive(cmd.Context(), project, previewName, composerArgs, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			os.Exit(code)
+		}
+
+		if composerDeploy {
+			fmt.Fprintf(os.Stderr, "Running drush deploy on %s/%s...\n", project, previewName)
+			result, err := apiClient.PostDrushByName(cmd.Context(), project, previewName, "deploy --yes")
+			if err != nil {
+				return err
+			}
+			printActionResult(result)
+			if !result.Success {
+				os.Exit(1)
+			}
+		}
+
+		if composerRestart {
+			fmt.Fprintf(os.Stderr, "Restarting %s/%s...\n", project, previewName)
+			result, err := apiClient.PostActionByName(cmd.Context(), project, previewName, "restart")
+			if err != nil {
+				return err
+			}
+			printActionResult(result)
+			if !result.Success {
+				os.Exit(1)
+			}
+		}
+		return nil
+	},
+}
+
+// shellJoin joins args into a single string that the server splits back
+// with shell rules, quoting args that contain spaces or quotes
+// (e.g. "drupal/core:^10.2 || ^11").
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$|&;<>*?") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+func init() {
+	composerCmd.Flags().BoolVar(&composerRestart, "restart", false, "Restart the preview after composer finishes")
+	composerCmd.Flags().BoolVar(&composerDeploy, "deploy", false, "Run drush deploy after composer finishes")
+	rootCmd.AddCommand(composerCmd)
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var configExportOutput string
+var configShareOrg string
+
+// configBundle is the part of the config that can be shared with a team:
+// no tokens or caches.
+type configBundle struct {
+	APIURL  string            `json:"api_url"`
+	Org     string            `json:"org,omitempty"`
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Share the CLI configuration with your team",
+}
+
+var configExportCmd = &cobra.Command{
+	Use:         "export",
+	Short:       "Export the CLI configuration without credentials",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Export the API URL, organization and aliases of this CLI as JSON, without
+tokens, so a new team member can import it with 'preview config import'.
+
+Examples:
+  preview config export > preview-config.json
+  preview config export -o preview-config.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadConfig()
+		if cfg.APIURL == "" {
+			return fmt.Errorf("API URL not configured, nothing to export")
+		}
+
+		data, err := json.MarshalIndent(configBundle{APIURL: cfg.APIURL, Org: cfg.Org, Aliases: cfg.Aliases}, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if configExportOutput == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(configExportOutput, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", configExportOutput, err)
+		}
+		fmt.Fprintf(os.Stderr, "Configuration exported to %s\n", configExportOutput)
+		return nil
+	},
+}
+
+var configImportCmd = &cobra.Command{
+	Use:         "import FILE",
+	Short:       "Import a CLI configuration exported by a teammate",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Import the API URL, organization and aliases from a file written by
+'preview config export' ("-" reads standard input). Imported aliases are
+added to yours. Switching to another server logs the CLI out.
+
+Examples:
+  preview config import preview-config.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var data []byte
+		var err error
+		if args[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+
+		var bundle configBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return fmt.Errorf("invalid config file: %w", err)
+		}
+		if bundle.APIURL == "" {
+			return fmt.Errorf("invalid config file: api_url is missing")
+		}
+		return applyConfigBundle(bundle)
+	},
+}
+
+var configShareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Create a team code for onboarding teammates",
+	Long: `Create a code on the server that sets up a new machine with a single
+command, 'preview setup team CODE'. The code carries the API URL and the
+organization (--org, default: the current one). Requires the admin role.
+
+Examples:
+  preview config share
+  preview config share --org acme`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		org := configShareOrg
+		if !cmd.Flags().Changed("org") {
+			org = loadConfig().Org
+		}
+
+		code, err := apiClient.CreateTeamCode(cmd.Context(), client.TeamDefaults{Org: org})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Share this command with your team:")
+		fmt.Printf("\n  preview setup team %s\n\n", code)
+		return nil
+	},
+}
+
+var setupTeamCmd = &cobra.Command{
+	Use:         "team CODE",
+	Short:       "Configure the CLI from a team code",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Configure the API URL and organization from a code created by an admin
+with 'preview config share', then log in with 'preview login'.
+
+Examples:
+  preview setup team aHR0cHM6Ly9hcGkuZXhhbXBsZS5jb20jYWJj`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apiURL, err := client.ParseTeamCode(args[0])
+		if err != nil {
+			return err
+		}
+		defaults, err := newPublicClient(apiURL).GetTeamDefaults(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		return applyConfigBundle(configBundle{APIURL: apiURL, Org: defaults.Org})
+	},
+}
+
+// applyConfigBundle saves bundle into the config. Tokens and caches of
+// another server are dropped.
+func applyConfigBundle(bundle configBundle) error {
+	cfg := loadConfig()
+	if cfg.APIURL != bundle.APIURL {
+		// Aliases aren't tied to a server
+		cfg = config{Aliases: cfg.Aliases}
+	}
+	cfg.APIURL = bundle.APIURL
+	cfg.Org = bundle.Org
+	for name, expansion := range bundle.Aliases {
+		if cfg.Aliases == nil {
+			cfg.Aliases = make(map[string]string)
+		}
+		cfg.Aliases[name] = expansion
+	}
+	if err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("API URL: %s\n", cfg.APIURL)
+	if cfg.Org != "" {
+		fmt.Printf("Organization: %s\n", cfg.Org)
+	}
+	if cfg.Token == "" {
+		fmt.Fprint(os.Stderr, "\nNow log in by running:\n\n  preview login\n\n")
+	}
+	return nil
+}
+
+func init() {
+	configExportCmd.Flags().StringVarP(&configExportOutput, "output", "o", "", "Write to a file instead of standard output")
+	configShareCmd.Flags().StringVar(&configShareOrg, "org", "", "Organization new members act on (default: the current one)")
+	configCmd.AddCommand(configExportCmd)
+	configCmd.AddCommand(configImportCmd)
+	configCmd.AddCommand(configShareCmd)
+	rootCmd.AddCommand(configCmd)
+	setupCmd.AddCommand(setupTeamCmd)
+}
@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var daemonRebuild bool
+var daemonSyncFiles bool
+var daemonFilesDir string
+var daemonInterval time.Duration
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [PROJECT/PREVIEW-NAME]",
+	Short: "Keep a preview in step with the local repository",
+	Long: `Watch the current git repository in the foreground and keep its preview
+in step with it until interrupted.
+
+With --rebuild, new commits on the branch trigger a rebuild of the
+preview once they are pushed, and only if the preview isn't already at
+them. Commits that aren't pushed yet are reported and rebuilt as soon as
+the push is seen.
+
+With --sync-files, files added, changed or deleted in the local public
+files directory (detected with ddev, or --files-dir) after the daemon
+started are copied to the preview. They go to the files overlay of the
+preview only: the base files and other previews are untouched. The css,
+js and php directories are skipped, as for push files.
+
+If PROJECT/PREVIEW-NAME is given, that preview is kept in step. If no
+argument is given, the project is detected from the git remote and the
+preview is the one of the current git branch (or bound to it with
+preview bind), followed across branch switches.
+
+Examples:
+  preview daemon --rebuild
+  preview daemon --sync-files
+  preview daemon drupal-test/mr-5 --rebuild --sync-files --interval 10s`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !daemonRebuild && !daemonSyncFiles {
+			return fmt.Errorf("nothing to do: pass --rebuild, --sync-files or both")
+		}
+		if daemonInterval < time.Second {
+			return fmt.Errorf("--interval must be at least 1s")
+		}
+		if daemonSyncFiles {
+			if err := requireFilesSync(); err != nil {
+				return err
+			}
+		}
+		if err := startMetrics(); err != nil {
+			return err
+		}
+
+		d := &previewDaemon{}
+		if len(args) == 1 {
+			project, previewName, err := parsePreviewName(args[0])
+			if err != nil {
+				return err
+			}
+			d.project, d.previewName, d.fixed = project, previewName, true
+		} else {
+			slug, err := detectProjectSlug()
+			if err != nil {
+				return err
+			}
+			d.project, d.slug = slug, slug
+		}
+
+		if daemonSyncFiles {
+			dir := daemonFilesDir
+			if dir == "" {
+				paths, err := getDrupalPaths()
+				if err != nil {
+					return fmt.Errorf("%w\nUse --files-dir to set the files directory", err)
+				}
+				dir = paths.Files
+			}
+			snapshot, err := snapshotFilesDir(dir)
+			if err != nil {
+				return fmt.Errorf("failed to read files directory: %w", err)
+			}
+			d.filesDir, d.files = dir, snapshot
+			fmt.Fprintf(os.Stderr, "Watching %s (%d files)\n", dir, len(snapshot))
+		}
+
+		fmt.Fprintln(os.Stderr, "Press Ctrl+C to stop.")
+		return d.run(cmd.Context())
+	},
+}
+
+// previewDaemon is the state of preview daemon between polls.
+type previewDaemon struct {
+	slug        string // project of the git remote
+	project     string // project of the preview, another one if bound
+	previewName string // "" while the branch has no preview
+	fixed       bool   // previewName was given, don't follow the branch
+
+	branch   string
+	sha      string // last commit rebuilt or found up to date
+	unpushed string // last commit reported as not pushed
+
+	filesDir string
+	files    map[string]fileStamp
+}
+
+// fileStamp is what tells a file changed between two polls.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (d *previewDaemon) run(ctx context.Context) error {
+	for {
+		d.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(daemonInterval):
+		}
+	}
+}
+
+// poll checks the repository once. Failures are reported and retried on
+// the next poll, so a network hiccup doesn't end the daemon.
+func (d *previewDaemon) poll(ctx context.Context) {
+	if !d.fixed {
+		branch, err := detectGitBranch()
+		if err != nil {
+			daemonLog("%v", err)
+			return
+		}
+		if branch != d.branch {
+			if project, previewName, ok := boundPreview(branch); ok {
+				daemonLog("branch %s: bound preview %s/%s", branch, project, previewName)
+				d.project, d.previewName = project, previewName
+			} else if preview, err := findPreviewByBranch(ctx, d.slug, branch); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				daemonLog("branch %s: %v", branch, err)
+				d.project, d.previewName = d.slug, ""
+			} else {
+				daemonLog("branch %s: preview %s/%s", branch, d.slug, preview.Name)
+				d.project, d.previewName = d.slug, preview.Name
+			}
+			d.branch, d.sha, d.unpushed = branch, "", ""
+		}
+	}
+	if d.previewName == "" {
+		return
+	}
+
+	if daemonRebuild {
+		d.rebuildIfNeeded(ctx)
+	}
+	if daemonSyncFiles {
+		d.syncFiles(ctx)
+	}
+}
+
+// rebuildIfNeeded rebuilds the preview when HEAD has moved to a pushed
+// commit the preview isn't at.
+func (d *previewDaemon) rebuildIfNeeded(ctx context.Context) {
+	sha, err := detectGitCommit()
+	if err != nil {
+		daemonLog("%v", err)
+		return
+	}
+	if sha == d.sha {
+		return
+	}
+	if !isPushed() {
+		if sha != d.unpushed {
+			daemonLog("%s is not pushed yet, rebuilding once it is", shortSHA(sha))
+			d.unpushed = sha
+		}
+		return
+	}
+
+	result, err := apiClient.RebuildIfChanged(ctx, d.project, d.previewName, sha)
+	if err != nil {
+		if ctx.Err() == nil {
+			daemonLog("rebuild of %s/%s failed: %v", d.project, d.previewName, err)
+		}
+		return
+	}
+	d.sha = sha
+	switch {
+	case result.UpToDate:
+		daemonLog("%s/%s is at %s", d.project, d.previewName, shortSHA(sha))
+	case !result.Success:
+		daemonLog("rebuild of %s/%s failed: %s", d.project, d.previewName, result.Error)
+	case result.PipelineURL != "":
+		daemonLog("rebuilding %s/%s at %s: %s", d.project, d.previewName, shortSHA(sha), result.PipelineURL)
+	default:
+		daemonLog("rebuilding %s/%s at %s", d.project, d.previewName, shortSHA(sha))
+	}
+	if !result.UpToDate && result.Success {
+		if err := runPostRebuildHooks(d.project, d.previewName, result); err != nil {
+			daemonLog("%v", err)
+		}
+	}
+}
+
+// isPushed reports whether HEAD is on the upstream of the current branch.
+func isPushed() bool {
+	return exec.Command("git", "merge-base", "--is-ancestor", "HEAD", "@{u}").Run() == nil
+}
+
+// syncFiles sends the files changed since the last poll to the preview.
+func (d *previewDaemon) syncFiles(ctx context.Context) {
+	snapshot, err := snapshotFilesDir(d.filesDir)
+	if err != nil {
+		daemonLog("failed to read %s: %v", d.filesDir, err)
+		return
+	}
+	changed, deleted := diffFilesSnapshots(d.files, snapshot)
+	if len(changed) == 0 && len(deleted) == 0 {
+		return
+	}
+
+	var archive io.ReaderAt // nil to only delete
+	var size int64
+	if len(changed) > 0 {
+		var buf bytes.Buffer
+		if err := writeFilesSyncArchive(&buf, d.filesDir, changed); err != nil {
+			daemonLog("failed to archive changed files: %v", err)
+			return
+		}
+		archive, size = bytes.NewReader(buf.Bytes()), int64(buf.Len())
+	}
+	result, err := apiClient.SyncPreviewFiles(ctx, d.project, d.previewName, archive, size, deleted)
+	if err != nil {
+		if ctx.Err() == nil {
+			daemonLog("file sync to %s/%s failed: %v", d.project, d.previewName, err)
+		}
+		return
+	}
+	d.files = snapshot
+	daemonLog("synced files to %s/%s: %d written, %d deleted", d.project, d.previewName, result.Written, result.Deleted)
+}
+
+// snapshotFilesDir stamps the regular files under root, skipping
+// filesArchiveExcludes.
+func snapshotFilesDir(root string) (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	err := walkFilesDir(root, func(rel string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // deleted while walking
+		}
+		if err != nil {
+			return err
+		}
+		files[rel] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// diffFilesSnapshots returns the files of after that are new or changed
+// since before, and those of before that are gone, both sorted.
+func diffFilesSnapshots(before, after map[string]fileStamp) (changed, deleted []string) {
+	for rel, stamp := range after {
+		if old, ok := before[rel]; !ok || old.size != stamp.size || !old.modTime.Equal(stamp.modTime) {
+			changed = append(changed, rel)
+		}
+	}
+	for rel := range before {
+		if _, ok := after[rel]; !ok {
+			deleted = append(deleted, rel)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+// writeFilesSyncArchive writes a tar.gz of the files rels (slash-separated,
+// relative to root) to w, for SyncPreviewFiles.
+func writeFilesSyncArchive(w io.Writer, root string, rels []string) error {
+	gz, err := newGzipWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+	for _, rel := range rels {
+		if err := addTarFile(tw, root, rel); err != nil {
+			gz.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// addTarFile adds the regular file rel under root to tw as "./rel". A file
+// deleted since it was listed is left out.
+func addTarFile(tw *tar.Writer, root, rel string) error {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = "./" + rel
+	// Whole seconds, as ListPreviewFiles reports them; tar would round
+	hdr.ModTime = info.ModTime().Truncate(time.Second)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// The header has the size at Stat; a file growing meanwhile is cut there
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
+
+func daemonLog(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "  %s  %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
+
+func init() {
+	daemonCmd.Flags().BoolVar(&daemonRebuild, "rebuild", false, "Rebuild the preview when new commits are pushed")
+	daemonCmd.Flags().BoolVar(&daemonSyncFiles, "sync-files", false, "Copy changes of the local files directory to the preview")
+	daemonCmd.Flags().StringVar(&daemonFilesDir, "files-dir", "", "Local public files directory (default: detected with ddev)")
+	daemonCmd.Flags().DurationVar(&daemonInterval, "interval", 5*time.Second, "Time between checks of the repository")
+	addMetricsFlag(daemonCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
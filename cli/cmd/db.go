@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var dbQueryOutput string
+var dbAnonymizeDryRun bool
+var dbAnonymizeLocal bool
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Work with a preview's database",
+}
+
+var dbQueryCmd = &cobra.Command{
+	Use:   "query [PROJECT/PREVIEW-NAME] SQL",
+	Short: "Run a SQL query on a preview and format the results",
+	Long: `Run a SQL query on a preview's database with drush sql-query and render
+the results as a table, CSV or JSON.
+
+If PROJECT/PREVIEW-NAME is given, queries that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview db query drupal-test/mr-5 "SELECT nid, title FROM node_field_data LIMIT 10"
+  preview db query "SELECT name FROM users_field_data" --output csv
+  preview db query drupal-test/mr-5 "SELECT * FROM config LIMIT 5" -o json`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		render, ok := queryRenderers[dbQueryOutput]
+		if !ok {
+			return fmt.Errorf("invalid --output %q: expected table, csv or json", dbQueryOutput)
+		}
+
+		var project, previewName string
+		var err error
+		if len(args) == 2 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+		query := args[len(args)-1]
+
+		// --column-names keeps the header row, which mysql drops in
+		// some silent modes.
+		drushArgs := []string{"sql-query", "--extra=--column-names", query}
+		if err := requireQuotedDrushArgs(drushArgs); err != nil {
+			return err
+		}
+		result, err := apiClient.PostDrushByName(cmd.Context(), project, previewName, shellJoin(drushArgs))
+		if err != nil {
+			return err
+		}
+		if !result.Success {
+			printActionResult(result)
+			os.Exit(1)
+		}
+
+		columns, rows := parseQueryOutput(result.Output)
+		if columns == nil {
+			fmt.Fprintln(os.Stderr, "Query returned no rows.")
+			return nil
+		}
+		return render(os.Stdout, columns, rows)
+	},
+}
+
+var dbSyncFromCmd = &cobra.Command{
+	Use:   "sync-from [PROJECT]",
+	Short: "Replace a project's base database with sanitized production data",
+	Long: `Replace the base database of a project with a fresh dump of its production
+database. The server dumps the database over SSH with the credentials
+configured for the project, sanitizes it (user emails and passwords,
+sessions, plus any project-specific statements) and sets it as the base
+database, streaming its progress here.
+
+The production source is configured server-side by an admin.
+
+If no project is given, it is detected from the git remote in the current directory.
+
+Examples:
+  preview db sync-from drupal-test
+  preview db sync-from --yes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		slug, err := resolveProjectArg(args)
+		if err != nil {
+			return err
+		}
+		if err := requireDBSync(); err != nil {
+			return err
+		}
+
+		ok, err := confirm(fmt.Sprintf("Do you want to replace the base database of %q with production data?", slug))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+
+		jobID, err := apiClient.SyncBaseDB(ctx, slug)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Syncing base database of %q from production (job %s)...\n", slug, jobID)
+		return apiClient.FollowDBSync(ctx, slug, jobID, os.Stderr)
+	},
+}
+
+var dbAnonymizeCmd = &cobra.Command{
+	Use:   "anonymize-preview [PROJECT/PREVIEW-NAME]",
+	Short: "Strip personal data from a preview's database in place",
+	Long: `Run the anonymization recipe of the "sanitize:" block of preview.yml against
+the database of an existing preview, e.g. one created before the recipe
+was added. New previews apply the recipe on creation.
+
+  sanitize:
+    truncate: [sessions, watchdog]
+    scrub:
+      users_field_data:
+        mail: email
+        init: email
+        name: name
+    sql:
+      - DELETE FROM webform_submission
+
+Tables are truncated first, then columns are scrubbed, then the custom SQL
+runs, stopping at the first statement that fails. Scrubbed values are
+derived from the original ones, so unique columns stay unique: email
+becomes user-<hash>@example.com, name user-<hash>, and null or empty clear
+the column.
+
+The recipe is that of the preview's own preview.yml; --local runs that of
+the local preview.yml instead. --dry-run prints the SQL without running it.
+
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview db anonymize-preview drupal-test/mr-5 --dry-run
+  preview db anonymize-preview --local --yes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+		if err := requireAnonymize(); err != nil {
+			return err
+		}
+
+		req := client.AnonymizeRequest{DryRun: true}
+		if dbAnonymizeLocal {
+			data, err := os.ReadFile("preview.yml")
+			if err != nil {
+				return fmt.Errorf("--local needs a preview.yml in the current directory: %w", err)
+			}
+			req.PreviewYml = string(data)
+		}
+
+		// Show the statements before running them, so the prompt says
+		// what it confirms
+		plan, err := apiClient.AnonymizePreviewDB(cmd.Context(), project, previewName, req)
+		if err != nil {
+			return err
+		}
+		for _, statement := range plan.Statements {
+			fmt.Println(statement)
+		}
+		if dbAnonymizeDryRun {
+			return nil
+		}
+
+		ok, err := confirm(fmt.Sprintf("Run these %d statements on the database of %s/%s? This can't be undone.", len(plan.Statements), project, previewName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+
+		req.DryRun = false
+		stop := startSpinner(fmt.Sprintf("Anonymizing the database of %s/%s...", project, previewName))
+		result, err := apiClient.AnonymizePreviewDB(cmd.Context(), project, previewName, req)
+		stop()
+		if err != nil {
+			return err
+		}
+		if !result.Success {
+			fmt.Fprintf(os.Stderr, "Failed after %d of %d statements: %s\n", result.Applied, len(result.Statements), result.Error)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Anonymized %s/%s: %d statements run. Clear its caches with 'preview drush %s/%s cr'.\n",
+			project, previewName, result.Applied, project, previewName)
+		return nil
+	},
+}
+
+// parseQueryOutput parses mysql batch output: a tab-separated header line
+// followed by one line per row, with tabs, newlines and backslashes in
+// values escaped as \t, \n and \\.
+func parseQueryOutput(output string) (columns []string, rows [][]string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return nil, nil
+	}
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		for j, f := range fields {
+			fields[j] = unescapeMySQLField(f)
+		}
+		if i == 0 {
+			columns = fields
+		} else {
+			rows = append(rows, fields)
+		}
+	}
+	return columns, rows
+}
+
+func unescapeMySQLField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+var queryRenderers = map[string]func(w io.Writer, columns []string, rows [][]string) error{
+	"table": renderQueryTable,
+	"csv":   renderQueryCSV,
+	"json":  renderQueryJSON,
+}
+
+func renderQueryTable(w io.Writer, columns []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			// Keep multi-line values on one table row.
+			cells[i] = strings.NewReplacer("\n", `\n`, "\t", " ").Replace(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(%d rows)\n", len(rows))
+	return nil
+}
+
+func renderQueryCSV(w io.Writer, columns []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// renderQueryJSON writes rows as an array of objects, keeping the column
+// order of the query. SQL NULLs become JSON null.
+func renderQueryJSON(w io.Writer, columns []string, rows [][]string) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for j, col := range columns {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			key, _ := json.Marshal(col)
+			buf.Write(key)
+			buf.WriteString(": ")
+			if j >= len(row) || row[j] == "NULL" {
+				buf.WriteString("null")
+				continue
+			}
+			value, _ := json.Marshal(row[j])
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	if len(rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func init() {
+	dbQueryCmd.Flags().StringVarP(&dbQueryOutput, "output", "o", "table", "Output format: table, csv or json")
+	dbCmd.AddCommand(dbQueryCmd)
+	dbAnonymizeCmd.Flags().BoolVar(&dbAnonymizeDryRun, "dry-run", false, "Print the SQL of the recipe without running it")
+	dbAnonymizeCmd.Flags().BoolVar(&dbAnonymizeLocal, "local", false, "Run the sanitize: recipe of the local preview.yml instead of the preview's")
+	dbCmd.AddCommand(dbSyncFromCmd)
+	dbCmd.AddCommand(dbAnonymizeCmd)
+	rootCmd.AddCommand(dbCmd)
+}
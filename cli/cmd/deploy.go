@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var deployRunPhase string
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Work on the deploy scripts of a preview",
+}
+
+var deployRunCmd = &cobra.Command{
+	Use:   "run [PROJECT/PREVIEW-NAME]",
+	Short: "Re-run the project deploy script without a rebuild",
+	Long: `Re-run the project deploy script of a phase (--phase new or update) on an
+existing preview, streaming its output. Only the script runs: no pipeline,
+image build, composer install or database import, so fixes to the script
+can be tried in seconds. The script runs as deployed on the preview; use
+push or rebuild to ship a changed script first.
+
+The script is the preview-specific override
+scripts/preview/PHASE/PREVIEW-NAME-deploy.sh if it exists, otherwise the
+one set in preview.yml deploy.PHASE. Exits with the script's exit code.
+
+If PROJECT/PREVIEW-NAME is given, runs on that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview deploy run drupal-test/mr-5 --phase update
+  preview deploy run --phase new`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if deployRunPhase != "new" && deployRunPhase != "update" {
+			return fmt.Errorf("invalid --phase %q: expected new or update", deployRunPhase)
+		}
+
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := requireDeployRun(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Running the %s deploy script on %s/%s...\n", deployRunPhase, project, previewName)
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.RunDeployScript(ctx, project, previewName, deployRunPhase, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			fmt.Fprintf(os.Stderr, "Deploy script failed (exit code %d)\n", code)
+			os.Exit(code)
+		}
+		fmt.Fprintln(os.Stderr, "Deploy script completed.")
+		return nil
+	},
+}
+
+func init() {
+	deployRunCmd.Flags().StringVar(&deployRunPhase, "phase", "update", "Deploy phase whose script to run: new or update")
+	deployCmd.AddCommand(deployRunCmd)
+	rootCmd.AddCommand(deployCmd)
+}
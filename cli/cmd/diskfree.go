@@ -0,0 +1,15 @@
+//go:build !windows
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to the user on the file system of
+// path.
+func freeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the bytes available to the user on the volume of path.
+func freeSpace(path string) (int64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(free), nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:         "doctor",
+	Short:       "Check that a Drupal project can run in previews",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Check the Drupal project in the current directory against what its previews
+run. composer.lock gives the
+versions of drupal/core and of the modules of the services preview.yml
+enables, which are compared with:
+
+  - the PHP version of preview.yml (php_version, {{default_php_version}} by
+    default; the server supports {{php_versions}})
+  - its database (mysql 8.0 by default)
+  - the redis service, which needs drupal/redis
+  - the solr service, which runs Solr 9 and needs drupal/search_api_solr 4.2
+    or later
+
+Each problem comes with a hint to fix it. Exits with a non-zero status if
+a combination can't work, e.g. Drupal 9 on PHP 8.3; warnings, like a
+service Drupal won't use, don't fail. 'preview setup project' runs the
+same checks.
+
+When logged in, the health of the server follows, as with
+'preview info server', so the output can go into bug reports as-is. A
+server that can't be reached is noted and doesn't fail the checks.
+
+Examples:
+  preview doctor`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if detectDocroot() == "" {
+			return fmt.Errorf("could not find web/ or docroot/ directory — are you in a Drupal project root?")
+		}
+		if _, err := os.Stat("composer.lock"); err != nil {
+			return fmt.Errorf("no composer.lock — run 'composer install' first")
+		}
+		if _, err := composerPackages(); err != nil {
+			return err
+		}
+		checks, core := checkDrupalCompatibility()
+		if checks == nil {
+			return fmt.Errorf("composer.lock doesn't require drupal/core")
+		}
+		if _, err := os.Stat("preview.yml"); err != nil {
+			fmt.Println("No preview.yml; checking the defaults of 'preview setup project'.")
+			fmt.Println()
+		}
+		errs := printDrupalCompatibility(checks, core)
+		if errs > 0 {
+			fmt.Println()
+			fmt.Printf("%d problem(s) will make deploys of this project fail.\n", errs)
+		}
+		fmt.Println()
+		printDoctorServerInfo(cmd.Context())
+		if errs > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// printDoctorServerInfo prints the health of the server for
+// doctor, or a note on why it can't. doctor runs without auth, so the
+// client is only created here, if there is a login.
+func printDoctorServerInfo(ctx context.Context) {
+	cfg := loadConfig()
+	if cfg.APIURL == "" || cfg.Token == "" {
+		fmt.Println("Server: not logged in; run 'preview login' to include its health.")
+		return
+	}
+	if err := requireServerInfo(); err != nil {
+		fmt.Printf("Server: %s (%v).\n", cfg.APIURL, err)
+		return
+	}
+	info, err := newClient(cfg).GetServerInfo(ctx)
+	if err != nil {
+		fmt.Printf("Server: %s can't be reached (%v).\n", cfg.APIURL, err)
+		return
+	}
+	printServerInfo(cfg.APIURL, info)
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
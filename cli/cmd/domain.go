@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var domainOutput string
+var domainWait bool
+var domainTimeout time.Duration
+
+// domainPollInterval is how often domain status --wait checks the
+// certificate.
+const domainPollInterval = 10 * time.Second
+
+var domainCmd = &cobra.Command{
+	Use:     "domain",
+	Aliases: []string{"domains"},
+	Short:   "Serve a preview at custom domains",
+	Long: `Attach custom hostnames to a preview, e.g. demo.client.com for a
+client-facing demo. Point the hostname at the preview with the DNS records
+'preview domain add' shows; the server gets its certificate once they are
+in place.
+
+Custom domains are served without the preview login: restrict them with
+'preview allowlist' if needed. Drupal trusts them through PREV_CUSTOM_DOMAINS
+in settings.preview.php (template v3 of 'preview setup project'). Requests
+to a custom domain don't wake a stopped preview. Requires the manager role.`,
+}
+
+var domainAddCmd = &cobra.Command{
+	Use:   "add [PROJECT/PREVIEW-NAME] HOSTNAME",
+	Short: "Attach a custom domain to a preview",
+	Long: `Attach HOSTNAME to a preview and show the DNS records it needs: a CNAME
+to the preview domain, or A/AAAA records for an apex domain. Follow the
+certificate with 'preview domain status HOSTNAME --wait'.
+
+If PROJECT/PREVIEW-NAME is given, attaches the domain to that specific
+preview. If no preview is specified, auto-detects the project from git
+remote and finds a preview matching the current git branch.
+
+Examples:
+  preview domain add drupal-test/mr-5 demo.client.com
+  preview domain add demo.client.com`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireCustomDomains(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveDomainTarget(cmd.Context(), args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		domain, err := apiClient.AddDomain(cmd.Context(), project, previewName, args[len(args)-1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Attached %s to %s/%s.\n\n", domain.Hostname, project, previewName)
+		printDomainStatus(domain)
+		if domain.Certificate.Status != client.CertificateIssued {
+			fmt.Printf("\nOnce the DNS is in place, follow the certificate with:\n  preview domain status %s/%s %s --wait\n", project, previewName, domain.Hostname)
+		}
+		return nil
+	},
+}
+
+var domainListCmd = &cobra.Command{
+	Use:   "list [PROJECT/PREVIEW-NAME]",
+	Short: "List the custom domains of a preview",
+	Long: `List the custom domains of a preview with their DNS and certificate
+status, checked now.
+
+Examples:
+  preview domain list drupal-test/mr-5
+  preview domain list -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if domainOutput != "text" && domainOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", domainOutput)
+		}
+		if err := requireCustomDomains(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveDomainTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		domains, err := apiClient.ListDomains(cmd.Context(), project, previewName)
+		if err != nil {
+			return err
+		}
+
+		if domainOutput == "json" {
+			data, err := json.MarshalIndent(domains, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(domains) == 0 {
+			fmt.Printf("%s/%s has no custom domains.\n", project, previewName)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOSTNAME\tDNS\tCERTIFICATE\tADDED BY")
+		for _, d := range domains {
+			dns := "not pointing here yet"
+			if d.DNS.OK {
+				dns = "ok"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Hostname, dns, certificateSummary(d.Certificate), d.CreatedBy)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var domainStatusCmd = &cobra.Command{
+	Use:   "status [PROJECT/PREVIEW-NAME] HOSTNAME",
+	Short: "Show the DNS records and certificate status of a custom domain",
+	Long: `Show the DNS records a custom domain needs, what it resolves to now and
+whether its certificate is issued. With --wait, checks again every 10
+seconds until the certificate is issued or --timeout passes.
+
+Examples:
+  preview domain status drupal-test/mr-5 demo.client.com
+  preview domain status demo.client.com --wait --timeout 30m`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireCustomDomains(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveDomainTarget(cmd.Context(), args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		hostname := strings.ToLower(args[len(args)-1])
+
+		deadline := time.Now().Add(domainTimeout)
+		for {
+			domain, err := findDomain(cmd.Context(), project, previewName, hostname)
+			if err != nil {
+				return err
+			}
+			if !domainWait || domain.Certificate.Status == client.CertificateIssued {
+				printDomainStatus(domain)
+				return nil
+			}
+			if time.Now().After(deadline) {
+				printDomainStatus(domain)
+				return fmt.Errorf("the certificate of %s wasn't issued within %s", hostname, domainTimeout)
+			}
+			stop := startSpinner(fmt.Sprintf("Waiting for the certificate of %s (DNS: %s)...", hostname, dnsSummary(domain.DNS)))
+			select {
+			case <-cmd.Context().Done():
+				stop()
+				return cmd.Context().Err()
+			case <-time.After(domainPollInterval):
+			}
+			stop()
+		}
+	},
+}
+
+var domainRemoveCmd = &cobra.Command{
+	Use:   "remove [PROJECT/PREVIEW-NAME] HOSTNAME",
+	Short: "Detach a custom domain from a preview",
+	Long: `Detach a custom domain from a preview: the server stops answering at it.
+Remove its DNS records afterwards.
+
+Examples:
+  preview domain remove drupal-test/mr-5 demo.client.com`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireCustomDomains(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveDomainTarget(cmd.Context(), args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		hostname := strings.ToLower(args[len(args)-1])
+		if err := apiClient.DeleteDomain(cmd.Context(), project, previewName, hostname); err != nil {
+			return err
+		}
+		fmt.Printf("Detached %s from %s/%s.\n", hostname, project, previewName)
+		return nil
+	},
+}
+
+// findDomain returns the custom domain hostname of a preview.
+func findDomain(ctx context.Context, project, previewName, hostname string) (*client.PreviewDomain, error) {
+	domains, err := apiClient.ListDomains(ctx, project, previewName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range domains {
+		if domains[i].Hostname == hostname {
+			return &domains[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not attached to %s/%s (see 'preview domain list')", hostname, project, previewName)
+}
+
+// printDomainStatus prints the DNS records and certificate of a domain.
+func printDomainStatus(d *client.PreviewDomain) {
+	fmt.Println("DNS records:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range d.DNS.Records {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", r.Type, r.Name, r.Value)
+	}
+	w.Flush()
+	fmt.Printf("DNS:          %s\n", dnsSummary(d.DNS))
+	fmt.Printf("Certificate:  %s\n", certificateSummary(d.Certificate))
+	if d.Certificate.Status == client.CertificateIssued {
+		fmt.Printf("URL:          https://%s\n", d.Hostname)
+	}
+}
+
+func dnsSummary(dns client.DomainDNS) string {
+	switch {
+	case dns.OK:
+		return "ok"
+	case len(dns.Resolved) == 0:
+		return "doesn't resolve yet"
+	default:
+		return "resolves to " + strings.Join(dns.Resolved, ", ") + ", not to the preview server"
+	}
+}
+
+func certificateSummary(c client.DomainCertificate) string {
+	if c.Status != client.CertificateIssued {
+		return c.Status
+	}
+	summary := "issued"
+	if c.Issuer != "" {
+		summary += " by " + c.Issuer
+	}
+	if c.ExpiresAt != "" {
+		summary += ", expires " + formatUploadTime(c.ExpiresAt)
+	}
+	return summary
+}
+
+func resolveDomainTarget(ctx context.Context, args []string) (string, string, error) {
+	if len(args) == 1 {
+		return parsePreviewName(args[0])
+	}
+	return detectPreview(ctx)
+}
+
+func init() {
+	domainListCmd.Flags().StringVarP(&domainOutput, "output", "o", "text", "Output format: text or json")
+	domainStatusCmd.Flags().BoolVar(&domainWait, "wait", false, "Wait until the certificate is issued")
+	domainStatusCmd.Flags().DurationVar(&domainTimeout, "timeout", 10*time.Minute, "With --wait, how long to wait for the certificate")
+	domainCmd.AddCommand(domainAddCmd, domainListCmd, domainStatusCmd, domainRemoveCmd)
+	rootCmd.AddCommand(domainCmd)
+}
@@ -1,15 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"strings"
 
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+var drushInteractive bool
+var drushYes bool
+var drushAllPreviews bool
+
 var drushCmd = &cobra.Command{
-	Use:   "drush [PROJECT/PREVIEW-NAME] [args...]",
+	Use:   "drush [PROJECT/PREVIEW-NAME | --all-previews PROJECT] [args...]",
 	Short: "Run a drush command on a preview",
 	Long: `Run a drush command on a preview.
 
@@ -17,51 +23,59 @@ If PROJECT/PREVIEW-NAME is given, runs drush on that specific preview.
 If no preview is specified, auto-detects the project from git remote
 and finds a preview matching the current git branch.
 
+Commands that ask for confirmation (e.g. "drush cim") need --interactive,
+which connects your terminal to drush so prompts can be answered, or --yes
+to auto-confirm them.
+
+With --all-previews PROJECT, runs the command on every preview of the
+project, a few at a time, and prints a summary.
+
+Flags for preview must come before the drush command; anything after it
+is passed to drush as-is.
+
 Examples:
   preview drush drupal-test/mr-5 cr
   preview drush drupal-test/branch-develop status
-  preview drush cr                  # auto-detect from current branch`,
+  preview drush cr                  # auto-detect from current branch
+  preview drush -i cim              # answer drush prompts in the terminal
+  preview drush --yes cim           # auto-confirm drush prompts
+  preview drush --all-previews drupal-test -- cr`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var project, previewName string
-
-		// Try to parse first arg as PROJECT/PREVIEW-NAME
-		if strings.Contains(args[0], "/") {
-			p, name, err := parsePreviewName(args[0])
-			if err != nil {
-				return err
-			}
-			project = p
-			previewName = name
-			args = args[1:]
-		} else {
-			// Auto-detect: all args are drush args
-			slug, err := detectProjectSlug()
-			if err != nil {
-				return err
-			}
-			branch, err := detectGitBranch()
-			if err != nil {
-				return err
-			}
-			fmt.Fprintf(os.Stderr, "Detected project: %s, branch: %s\n", slug, branch)
+		if drushAllPreviews {
+			return runDrushAllPreviews(cmd, args[0], dropDashDash(args[1:]))
+		}
 
-			preview, err := findPreviewByBranch(slug, branch)
-			if err != nil {
-				return err
-			}
-			project = slug
-			previewName = preview.Name
-			fmt.Fprintf(os.Stderr, "Found preview: %s/%s\n", project, previewName)
+		project, previewName, args, err := resolvePreviewAndArgs(cmd.Context(), args)
+		if err != nil {
+			return err
 		}
 
 		if len(args) == 0 {
 			return fmt.Errorf("no drush arguments provided")
 		}
 
-		drushArgs := strings.Join(args, " ")
+		if drushYes {
+			args = append(args, "--yes")
+		}
+		drushArgs := shellJoin(args)
 		fmt.Fprintf(os.Stderr, "Running drush %s on %s/%s...\n", drushArgs, project, previewName)
-		result, err := apiClient.PostDrushByName(project, previewName, drushArgs)
+
+		if drushInteractive {
+			code, err := runDrushInteractive(cmd, project, previewName, drushArgs)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		}
+
+		if err := requireQuotedDrushArgs(args); err != nil {
+			return err
+		}
+		result, err := apiClient.PostDrushByName(cmd.Context(), project, previewName, drushArgs)
 		if err != nil {
 			return err
 		}
@@ -73,6 +87,81 @@ Examples:
 	},
 }
 
+// runDrushAllPreviews runs drush on every preview of project.
+func runDrushAllPreviews(cmd *cobra.Command, project string, args []string) error {
+	if drushInteractive {
+		return fmt.Errorf("--interactive can't be combined with --all-previews")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no drush arguments provided")
+	}
+	if drushYes {
+		args = append(args, "--yes")
+	}
+	if err := requireQuotedDrushArgs(args); err != nil {
+		return err
+	}
+
+	targets, err := projectPreviews(cmd.Context(), project)
+	if err != nil {
+		return err
+	}
+
+	drushArgs := shellJoin(args)
+	fmt.Fprintf(os.Stderr, "Running drush %s on %d previews of %s...\n", drushArgs, len(targets), project)
+	ok := runBulk(cmd.Context(), targets, func(ctx context.Context, t previewTarget) (*client.ActionResult, error) {
+		return apiClient.PostDrushByName(ctx, t.Project, t.Name, drushArgs)
+	})
+	if !ok {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runDrushInteractive bridges the local terminal to drush running in a PTY
+// on the preview.
+func runDrushInteractive(cmd *cobra.Command, project, previewName, drushArgs string) (int, error) {
+	if noInput {
+		return -1, errNoInput("drop --interactive, and pass --yes to auto-confirm drush prompts")
+	}
+	if err := requireStreamingDrush(); err != nil {
+		return -1, err
+	}
+	session, restore, err := localTerminal()
+	if err != nil {
+		return -1, err
+	}
+	defer restore()
+
+	return apiClient.DrushInteractive(cmd.Context(), project, previewName, drushArgs, session)
+}
+
+// localTerminal returns the local side of an interactive session. When stdin
+// is a terminal it is put in raw mode so keystrokes (including Ctrl+C) go
+// straight to the remote PTY, which handles echo. Call restore when done.
+func localTerminal() (client.Terminal, func(), error) {
+	session := client.Terminal{Stdin: os.Stdin, Stdout: os.Stdout}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return session, func() {}, nil
+	}
+	if cols, rows, err := term.GetSize(fd); err == nil {
+		session.Cols, session.Rows = cols, rows
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return session, nil, fmt.Errorf("failed to set terminal raw mode: %w", err)
+	}
+	return session, func() { term.Restore(fd, state) }, nil
+}
+
 func init() {
+	drushCmd.Flags().BoolVarP(&drushInteractive, "interactive", "i", false, "Connect the terminal to drush so prompts can be answered")
+	drushCmd.Flags().BoolVarP(&drushYes, "yes", "y", false, "Auto-confirm drush prompts (passes --yes to drush)")
+	drushCmd.Flags().BoolVar(&drushAllPreviews, "all-previews", false, "Run on every preview of the PROJECT given as first argument")
+	// Stop parsing preview flags at the first argument so drush options
+	// like "--partial" are passed through.
+	drushCmd.Flags().SetInterspersed(false)
 	rootCmd.AddCommand(drushCmd)
 }
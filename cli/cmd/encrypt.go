@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// encryptKeyFile is the --encrypt-key-file flag shared by push and pull.
+var encryptKeyFile string
+
+// loadEncryptionKey reads the key from --encrypt-key-file, or returns nil if
+// the flag is not set.
+func loadEncryptionKey() ([]byte, error) {
+	if encryptKeyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(encryptKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read encryption key: %w", err)
+	}
+	key, err := client.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", encryptKeyFile, err)
+	}
+	return key, nil
+}
+
+// uploadBase uploads r as the base file of kind. With --encrypt-key-file the
+// stream is encrypted on the fly and stored as the encrypted kind, which the
+// server keeps as-is and never sees in plaintext.
+func uploadBase(ctx context.Context, slug, kind string, r io.Reader, filename string) error {
+	key, err := loadEncryptionKey()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return apiClient.UploadBaseFileChunked(ctx, slug, kind, r, filename)
+	}
+
+	fmt.Fprintf(os.Stderr, "Encrypting with key from %s.\n", encryptKeyFile)
+	pr, pw := io.Pipe()
+	go func() {
+		// The header is written to the pipe, so the writer must be created
+		// here while the upload reads the other end.
+		enc, err := client.NewEncryptWriter(pw, key)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(enc, r)
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = apiClient.UploadBaseFileChunked(ctx, slug, client.EncryptedKind(kind), pr, filename+".enc")
+	pr.CloseWithError(err)
+	return err
+}
+
+// baseToReplace returns the kind, label and current state of the base a
+// push of kind overwrites. With --encrypt-key-file that is the encrypted
+// base, which the base files status doesn't cover: whether it exists is
+// read from the upload history instead.
+func baseToReplace(ctx context.Context, slug, kind, label string, existing *client.BaseFileInfo) (string, string, *client.BaseFileInfo, error) {
+	if encryptKeyFile == "" {
+		return kind, label, existing, nil
+	}
+	kind, label = client.EncryptedKind(kind), "encrypted "+label
+	uploads, err := apiClient.GetBaseFilesHistory(ctx, slug)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to check base files history: %w", err)
+	}
+	// Newest first: the first entry of kind is the one in use
+	for _, u := range uploads {
+		if u.Kind == kind {
+			return kind, label, &client.BaseFileInfo{Exists: true, SizeBytes: u.SizeBytes, ModifiedAt: u.UploadedAt}, nil
+		}
+	}
+	return kind, label, &client.BaseFileInfo{}, nil
+}
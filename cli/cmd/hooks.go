@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// hooksFile sets, in the project root, the local hooks of the project.
+const hooksFile = ".preview-cli.yml"
+
+// hookEvents are the events hooks run on, in the order they are listed.
+var hookEvents = []string{"pre-push", "post-pull", "post-rebuild"}
+
+// hook is a command run on an event.
+type hook struct {
+	Event   string
+	Command string
+	// Source is where the hook is set: the hooksFile of the project or
+	// the config file
+	Source string
+	// Trusted is false for the hooks of a hooksFile not trusted with
+	// 'preview hooks trust', or changed since
+	Trusted bool
+}
+
+var hooksCmd = &cobra.Command{
+	Use:         "hooks",
+	Short:       "List the local hooks run on push, pull and rebuild",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `List the local hooks: commands the CLI runs on this machine when it pushes,
+pulls or rebuilds, to chain notifications, virus scans or local imports.
+
+Hooks are set for a project in .preview-cli.yml in its root:
+
+  hooks:
+    pre-push: ./scripts/scan-dump.sh
+    post-pull:
+      - ./scripts/import.sh
+      - notify-send "Pull done"
+
+and for all projects in the "hooks" of ~/.preview-manager.json, as
+{"post-rebuild": ["notify-send rebuilt"]}. The hooks of the project run
+first, and only once trusted: as .preview-cli.yml comes with the checkout,
+review it and run 'preview hooks trust'. Until then, and again whenever
+the file changes, its hooks are skipped with a warning. Each command runs
+through the shell in the current directory with:
+
+  PREVIEW_HOOK          the event
+  PREVIEW_PROJECT       the project slug
+  PREVIEW_NAME          the preview, empty for the base of the project
+  PREVIEW_KIND          db or files (push and pull), db-NAME for a secondary database (push)
+  PREVIEW_FILE          the file pushed, or the file or directory pulled to
+  PREVIEW_PIPELINE_URL  the pipeline of the rebuild, if any
+
+pre-push runs after the push is confirmed, before anything is uploaded;
+PREVIEW_FILE is only set when a file is given, as dumps and archives are
+generated while uploading. A pre-push hook failing aborts the push. post-pull
+runs once the download is saved (and imported with pull all --import), and
+post-rebuild once a rebuild is triggered; a failing post hook makes the
+command fail.
+
+Examples:
+  preview hooks
+  preview hooks trust
+  preview hooks run post-pull`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hooks, err := loadHooks()
+		if err != nil {
+			return err
+		}
+		if len(hooks) == 0 {
+			fmt.Fprintf(os.Stderr, "No hooks set. Add them to %s in the project root or to the \"hooks\" of %s.\n", hooksFile, configPath())
+			return nil
+		}
+		untrusted := false
+		for _, h := range hooks {
+			source := h.Source
+			if !h.Trusted {
+				source += ", not trusted"
+				untrusted = true
+			}
+			fmt.Printf("%-13s %s  (%s)\n", h.Event, h.Command, source)
+		}
+		if untrusted {
+			fmt.Fprintln(os.Stderr, "\nHooks not trusted are skipped. Review them and run 'preview hooks trust' to run them.")
+		}
+		return nil
+	},
+}
+
+var hooksTrustCmd = &cobra.Command{
+	Use:         "trust",
+	Short:       "Trust the hooks of the project's .preview-cli.yml",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Show the hooks of .preview-cli.yml in the project root and, once confirmed,
+trust them: the SHA-256 of the file is saved in the config, and its hooks
+run as long as the file stays the same. Trust it again after it changes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := projectHooksPath()
+		hooks, sum, err := loadProjectHooks(path)
+		if err != nil {
+			return err
+		}
+		if hooks == nil {
+			return fmt.Errorf("no %s in %s", hooksFile, filepath.Dir(path))
+		}
+		cfg := loadConfig()
+		if cfg.TrustedHooks[path] == sum {
+			fmt.Printf("The hooks of %s are already trusted.\n", path)
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "The hooks of %s run these commands on this machine:\n\n", path)
+		for _, event := range hookEvents {
+			for _, command := range hooks[event] {
+				fmt.Fprintf(os.Stderr, "  %-13s %s\n", event, command)
+			}
+		}
+		fmt.Fprintln(os.Stderr)
+		ok, err := confirmNo("Trust them?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+
+		if cfg.TrustedHooks == nil {
+			cfg.TrustedHooks = make(map[string]string)
+		}
+		cfg.TrustedHooks[path] = sum
+		if err := saveConfig(cfg); err != nil {
+			return err
+		}
+		fmt.Printf("Trusted the hooks of %s.\n", path)
+		return nil
+	},
+}
+
+var hooksRunCmd = &cobra.Command{
+	Use:         "run EVENT",
+	Short:       "Run the hooks of an event, to try them",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Run the hooks of EVENT (pre-push, post-pull or post-rebuild) as the CLI
+would, with PREVIEW_PROJECT detected from the git remote and the other
+variables empty.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !isHookEvent(args[0]) {
+			return fmt.Errorf("unknown event %q: expected %s", args[0], strings.Join(hookEvents, ", "))
+		}
+		slug, _ := detectProjectSlug()
+		return runHooks(args[0], "PREVIEW_PROJECT="+slug)
+	},
+}
+
+func isHookEvent(event string) bool {
+	for _, e := range hookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHooks returns the hooks of the hooksFile of the project and then
+// those of the config, by event.
+func loadHooks() ([]hook, error) {
+	path := projectHooksPath()
+	project, sum, err := loadProjectHooks(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := loadConfig()
+	trusted := project != nil && cfg.TrustedHooks[path] == sum
+	var hooks []hook
+	for _, event := range hookEvents {
+		for _, command := range project[event] {
+			hooks = append(hooks, hook{Event: event, Command: command, Source: path, Trusted: trusted})
+		}
+		for _, command := range cfg.Hooks[event] {
+			hooks = append(hooks, hook{Event: event, Command: command, Source: configPath(), Trusted: true})
+		}
+	}
+	for event := range cfg.Hooks {
+		if !isHookEvent(event) {
+			return nil, fmt.Errorf("%s: unknown hook event %q: expected %s", configPath(), event, strings.Join(hookEvents, ", "))
+		}
+	}
+	return hooks, nil
+}
+
+// projectHooksPath returns the path of the hooksFile of the project.
+func projectHooksPath() string {
+	return filepath.Join(projectRoot(), hooksFile)
+}
+
+// hookCommands are the commands of an event: one, or a list of them.
+type hookCommands []string
+
+func (c *hookCommands) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = hookCommands{value.Value}
+		return nil
+	}
+	var commands []string
+	if err := value.Decode(&commands); err != nil {
+		return err
+	}
+	*c = commands
+	return nil
+}
+
+// loadProjectHooks reads the "hooks" section of the hooksFile at path,
+// nil if there is none, and the SHA-256 of the file to check it is trusted.
+// An event takes a command or a list of them.
+func loadProjectHooks(path string) (map[string][]string, string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	var file struct {
+		Hooks map[string]hookCommands `yaml:"hooks"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	hooks := make(map[string][]string)
+	for event, commands := range file.Hooks {
+		if !isHookEvent(event) {
+			return nil, "", fmt.Errorf("%s: unknown hook event %q: expected %s", path, event, strings.Join(hookEvents, ", "))
+		}
+		hooks[event] = commands
+	}
+	return hooks, hex.EncodeToString(sum[:]), nil
+}
+
+// runHooks runs the hooks of event in order with PREVIEW_HOOK and env
+// ("NAME=value") added to the environment, stopping at the first failing.
+// Hooks not trusted are skipped with a warning.
+func runHooks(event string, env ...string) error {
+	hooks, err := loadHooks()
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if h.Event != event {
+			continue
+		}
+		if !h.Trusted {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s hook %q: %s is not trusted. Review it and run 'preview hooks trust'.\n", event, h.Command, h.Source)
+			continue
+		}
+		var c *exec.Cmd
+		if runtime.GOOS == "windows" {
+			c = exec.Command("cmd", "/C", h.Command)
+		} else {
+			c = exec.Command("sh", "-c", h.Command)
+		}
+		c.Env = append(append(os.Environ(), "PREVIEW_HOOK="+event), env...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stderr // keep stdout for the output of the command
+		c.Stderr = os.Stderr
+
+		fmt.Fprintf(os.Stderr, "Running %s hook: %s\n", event, h.Command)
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", event, h.Command, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	hooksCmd.AddCommand(hooksRunCmd)
+	hooksCmd.AddCommand(hooksTrustCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
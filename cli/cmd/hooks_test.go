@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadProjectHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string][]string
+		wantErr string
+	}{
+		{"command and list", `
+# the hooks of the project
+other: setting
+hooks:
+  pre-push: ./scripts/scan-dump.sh
+  post-pull:
+    - ./scripts/import.sh
+    - notify-send "Pull done"
+`, map[string][]string{
+			"pre-push":  {"./scripts/scan-dump.sh"},
+			"post-pull": {"./scripts/import.sh", `notify-send "Pull done"`},
+		}, ""},
+		{"quoted hash", `hooks:
+  post-rebuild: "echo '#1' done" # a comment
+`, map[string][]string{"post-rebuild": {"echo '#1' done"}}, ""},
+		{"no hooks", "other: setting\n", map[string][]string{}, ""},
+		{"unknown event", "hooks:\n  post-push: echo\n", nil, `unknown hook event "post-push"`},
+		{"not a command", "hooks:\n  pre-push:\n    script: echo\n", nil, "cannot unmarshal"},
+		{"malformed", "hooks:\n  pre-push: [echo\n", nil, hooksFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), hooksFile)
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			hooks, sum, err := loadProjectHooks(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hooks, tt.want) {
+				t.Fatalf("got %q, want %q", hooks, tt.want)
+			}
+			if len(sum) != 64 {
+				t.Fatalf("got SHA-256 %q", sum)
+			}
+		})
+	}
+
+	hooks, sum, err := loadProjectHooks(filepath.Join(t.TempDir(), hooksFile))
+	if hooks != nil || sum != "" || err != nil {
+		t.Fatalf("expected no hooks without a file, got %q, %q, %v", hooks, sum, err)
+	}
+}
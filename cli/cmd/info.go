@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var infoOutput string
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show information about the preview server",
+}
+
+var infoServerCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Show the server health",
+	Long: `Show the preview server uptime, number of previews, free disk space,
+deployments in progress and the CLI version it publishes. Include the
+output in bug reports.
+
+Examples:
+  preview info server
+  preview info server --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if infoOutput != "text" && infoOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", infoOutput)
+		}
+		if err := requireServerInfo(); err != nil {
+			return err
+		}
+
+		info, err := apiClient.GetServerInfo(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		if infoOutput == "json" {
+			data, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		printServerInfo(loadConfig().APIURL, info)
+		return nil
+	},
+}
+
+func printServerInfo(apiURL string, info *client.ServerInfo) {
+	statuses := make([]string, 0, len(info.PreviewsByStatus))
+	for status, n := range info.PreviewsByStatus {
+		statuses = append(statuses, fmt.Sprintf("%d %s", n, status))
+	}
+	sort.Strings(statuses)
+	previews := fmt.Sprint(info.PreviewsTotal)
+	if len(statuses) > 0 {
+		previews += " (" + strings.Join(statuses, ", ") + ")"
+	}
+
+	disk := "unknown"
+	if info.DiskTotalBytes > 0 {
+		disk = fmt.Sprintf("%s free of %s (%.0f%%)", formatBytesShort(info.DiskFreeBytes),
+			formatBytesShort(info.DiskTotalBytes), float64(info.DiskFreeBytes)*100/float64(info.DiskTotalBytes))
+	}
+
+	published := info.CLIVersion
+	if published == "" {
+		published = "none"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Server:\t%s\n", apiURL)
+	fmt.Fprintf(w, "CLI version:\t%s\n", Version)
+	fmt.Fprintf(w, "Published CLI:\t%s\n", published)
+	fmt.Fprintf(w, "Uptime:\t%s\n", formatDuration(float64(info.UptimeSeconds)))
+	fmt.Fprintf(w, "Previews:\t%s\n", previews)
+	fmt.Fprintf(w, "Disk:\t%s\n", disk)
+	fmt.Fprintf(w, "Running deployments:\t%d\n", info.RunningDeployments)
+	w.Flush()
+}
+
+func init() {
+	infoServerCmd.Flags().StringVarP(&infoOutput, "output", "o", "text", "Output format: text or json")
+	infoCmd.AddCommand(infoServerCmd)
+	rootCmd.AddCommand(infoCmd)
+}
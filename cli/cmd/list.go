@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"sort"
@@ -9,49 +10,125 @@ import (
 	"strings"
 	"text/tabwriter"
 
-	"github.com/preview-manager/cli/internal/client"
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
 )
 
 var listNoStatus bool
+var listAll bool
+var listLimit int
+var listPage int
 
 var listCmd = &cobra.Command{
-	Use:   "list [PROJECT]",
-	Short: "List previews, optionally filtered by project",
-	Long:  "List previews for a project. If no project is specified, shows a project selector.",
-	Args:  cobra.MaximumNArgs(1),
+	Use:     "list [PROJECT]",
+	Aliases: []string{"ls"},
+	Short:   "List previews, optionally filtered by project",
+	Long: `List previews for a project. If no project is specified, shows a project
+selector, or lists the previews of every project when not running in a
+terminal (or with --no-input).
+
+With --limit, only that many previews are listed per page (--page, starting
+at 1), so servers with hundreds of previews answer quickly. Without a
+PROJECT, paging lists the previews of every project.
+
+Examples:
+  preview list drupal-test
+  preview list drupal-test --limit 20 --page 2
+  preview list --all`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		result, err := apiClient.ListPreviews(!listNoStatus)
+		if listAll && len(args) == 1 {
+			return fmt.Errorf("--all can't be combined with a PROJECT")
+		}
+		if listLimit < 0 {
+			return fmt.Errorf("--limit must be positive")
+		}
+		if listPage < 1 {
+			return fmt.Errorf("--page must be 1 or greater")
+		}
+		if cmd.Flags().Changed("page") && listLimit == 0 {
+			return fmt.Errorf("--page requires --limit")
+		}
+
+		opts := client.ListOptions{
+			IncludeStatus: !listNoStatus,
+			Limit:         listLimit,
+			Offset:        (listPage - 1) * listLimit,
+		}
+		if len(args) == 1 {
+			opts.Project = args[0]
+		}
+		// The project selector only needs the names; the status of the
+		// chosen project's previews is checked once it is chosen
+		selecting := opts.Project == "" && listLimit == 0 && !listAll && isInteractive()
+		if selecting {
+			opts.IncludeStatus = false
+		}
+		result, err := listPreviewsPage(cmd.Context(), opts)
 		if err != nil {
 			return err
 		}
 
 		if result.Total == 0 {
+			if opts.Project != "" {
+				return fmt.Errorf("project %q not found", opts.Project)
+			}
 			fmt.Println("No previews found.")
 			return nil
 		}
+		if len(result.Previews) == 0 {
+			return fmt.Errorf("page %d is empty: there are %d previews", listPage, result.Total)
+		}
 
-		// Group by project
-		projects := groupByProject(result.Previews)
-
-		var project string
-		if len(args) == 1 {
-			project = args[0]
-			if _, ok := projects[project]; !ok {
-				return fmt.Errorf("project %q not found", project)
-			}
-		} else {
-			project, err = selectProject(projects)
+		switch {
+		case opts.Project != "":
+			printPreviews(result.Previews)
+		case listLimit > 0:
+			// A page is in server order, which spans projects
+			printAllPreviews(result.Previews)
+		case !selecting:
+			previews := result.Previews
+			sort.SliceStable(previews, func(i, j int) bool { return previews[i].Project < previews[j].Project })
+			printAllPreviews(previews)
+		default:
+			projects := groupByProject(result.Previews)
+			project, err := selectProject(projects)
 			if err != nil {
 				return err
 			}
+			previews := projects[project]
+			if !listNoStatus {
+				opts.Project, opts.IncludeStatus = project, true
+				if result, err = listPreviewsPage(cmd.Context(), opts); err != nil {
+					return err
+				}
+				previews = result.Previews
+			}
+			printPreviews(previews)
 		}
 
-		printPreviews(projects[project])
+		if listLimit > 0 {
+			first := opts.Offset + 1
+			last := opts.Offset + len(result.Previews)
+			fmt.Fprintf(os.Stderr, "\nShowing %d-%d of %d previews", first, last, result.Total)
+			if last < result.Total {
+				fmt.Fprintf(os.Stderr, " (next: --page %d)", listPage+1)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
 		return nil
 	},
 }
 
+// listPreviewsPage lists previews with a spinner while the server checks
+// their status, which takes a while on busy servers.
+func listPreviewsPage(ctx context.Context, opts client.ListOptions) (*client.PreviewListResult, error) {
+	if opts.IncludeStatus {
+		defer startSpinner("Checking preview status...")()
+	}
+	return apiClient.ListPreviewsPage(ctx, opts)
+}
+
 func groupByProject(previews []client.Preview) map[string][]client.Preview {
 	m := make(map[string][]client.Preview)
 	for _, p := range previews {
@@ -110,7 +187,21 @@ func printPreviews(previews []client.Preview) {
 	w.Flush()
 }
 
+// printAllPreviews prints previews of several projects, with a PROJECT column.
+func printAllPreviews(previews []client.Preview) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tMR\tSTATUS\tBRANCH\tURL")
+	for _, p := range previews {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			p.Project, p.Name, p.Status, p.Branch, p.URL)
+	}
+	w.Flush()
+}
+
 func init() {
+	listCmd.Flags().BoolVar(&listAll, "all", false, "List the previews of every project")
 	listCmd.Flags().BoolVar(&listNoStatus, "no-status", false, "Skip Docker status check (faster)")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "List at most this many previews per page (0 for all)")
+	listCmd.Flags().IntVar(&listPage, "page", 1, "Page to list, with --limit")
 	rootCmd.AddCommand(listCmd)
 }
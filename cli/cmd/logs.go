@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var logsPHP bool
+var logsWatchdog bool
+var logsSeverity string
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [PROJECT/PREVIEW-NAME]",
+	Short: "Follow the logs of a preview",
+	Long: `Follow the logs of a preview until Ctrl+C, starting with their last
+100 lines: by default the output of its PHP container (web server and
+startup), with --php its PHP error log and with --watchdog the Drupal log
+(drush watchdog:tail), the first places to look when a preview shows a
+white screen.
+
+--severity only shows PHP errors or watchdog entries of that severity or
+worse: emergency, alert, critical, error, warning, notice, info or debug.
+PHP errors are fatal errors, warnings or notices (deprecations included).
+
+If PROJECT/PREVIEW-NAME is given, follows the logs of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview logs drupal-test/mr-5
+  preview logs --php --severity error
+  preview logs drupal-test/mr-5 --watchdog --severity warning`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if logsPHP && logsWatchdog {
+			return fmt.Errorf("--php and --watchdog can't be combined")
+		}
+		log := "container"
+		switch {
+		case logsPHP:
+			log = "php"
+		case logsWatchdog:
+			log = "watchdog"
+		}
+		if logsSeverity != "" {
+			if log == "container" {
+				return fmt.Errorf("--severity filters the PHP error log or watchdog: add --php or --watchdog")
+			}
+			if !slices.Contains(client.LogSeverities, logsSeverity) {
+				return fmt.Errorf("invalid --severity %q: expected one of %s", logsSeverity, strings.Join(client.LogSeverities, ", "))
+			}
+		}
+		if err := requireLogs(); err != nil {
+			return err
+		}
+
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		names := map[string]string{"container": "container log", "php": "PHP error log", "watchdog": "watchdog"}
+		fmt.Fprintf(os.Stderr, "Following the %s of %s/%s (Ctrl+C to stop)...\n", names[log], project, previewName)
+
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.Logs(cmd.Context(), project, previewName, log, logsSeverity, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		// 130 is Ctrl+C stopping it
+		if code != 0 && code != 130 {
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+func init() {
+	logsCmd.Flags().BoolVar(&logsPHP, "php", false, "Follow the PHP error log")
+	logsCmd.Flags().BoolVar(&logsWatchdog, "watchdog", false, "Follow the Drupal log (drush watchdog:tail)")
+	logsCmd.Flags().StringVar(&logsSeverity, "severity", "", "With --php or --watchdog, only show entries of this severity or worse (e.g. error, warning)")
+	rootCmd.AddCommand(logsCmd)
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var mailLimit int
+var mailOutput string
+var mailHTML bool
+
+var mailCmd = &cobra.Command{
+	Use:   "mail",
+	Short: "Inspect the mail captured by a preview",
+	Long: `Previews don't send mail: everything their site sends is captured and
+can be read here or in the mail capture UI of the preview, to check
+notification emails without leaving the terminal. Requires the manager
+role.`,
+}
+
+var mailListCmd = &cobra.Command{
+	Use:   "list [PROJECT/PREVIEW-NAME]",
+	Short: "List the mail captured by a preview",
+	Long: `List the last --limit messages captured by a preview, newest first.
+
+If PROJECT/PREVIEW-NAME is given, lists the mail of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview mail list drupal-test/mr-5
+  preview mail list --limit 50 -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if mailOutput != "text" && mailOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", mailOutput)
+		}
+		if err := requireMail(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveMailTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		list, err := apiClient.ListMail(cmd.Context(), project, previewName, mailLimit)
+		if err != nil {
+			return err
+		}
+
+		if mailOutput == "json" {
+			data, err := json.MarshalIndent(list.Messages, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(list.Messages) == 0 {
+			fmt.Printf("No mail captured by %s/%s.\n", project, previewName)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tRECEIVED\tTO\tSUBJECT")
+		for _, m := range list.Messages {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.ID, formatUploadTime(m.Created), strings.Join(m.To, ", "), m.Subject)
+		}
+		w.Flush()
+		if list.Total > len(list.Messages) {
+			fmt.Fprintf(os.Stderr, "\n%d of %d messages shown (--limit).\n", len(list.Messages), list.Total)
+		}
+		fmt.Fprintf(os.Stderr, "\nRead one with 'preview mail show %s/%s ID'.\n", project, previewName)
+		return nil
+	},
+}
+
+var mailShowCmd = &cobra.Command{
+	Use:   "show [PROJECT/PREVIEW-NAME] [ID]",
+	Short: "Print a message captured by a preview",
+	Long: `Print a message captured by a preview: its headers, attachments and
+text, or with --html its HTML. Without ID, prints the newest message.
+
+If PROJECT/PREVIEW-NAME is given, reads the mail of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview mail show drupal-test/mr-5
+  preview mail show drupal-test/mr-5 aB3dE5fG7hJ9kL
+  preview mail show --html > mail.html`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMail(); err != nil {
+			return err
+		}
+		id := "latest"
+		if n := len(args); n == 2 || (n == 1 && !strings.Contains(args[0], "/")) {
+			id = args[n-1]
+			args = args[:n-1]
+		}
+		project, previewName, err := resolveMailTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		msg, err := apiClient.GetMail(cmd.Context(), project, previewName, id)
+		if err != nil {
+			return err
+		}
+
+		if mailHTML {
+			if msg.HTML == "" {
+				return fmt.Errorf("message %s has no HTML part", msg.ID)
+			}
+			fmt.Println(msg.HTML)
+			return nil
+		}
+		fmt.Printf("ID:       %s\n", msg.ID)
+		fmt.Printf("Date:     %s\n", formatUploadTime(msg.Date))
+		fmt.Printf("From:     %s\n", msg.From)
+		fmt.Printf("To:       %s\n", strings.Join(msg.To, ", "))
+		if len(msg.Cc) > 0 {
+			fmt.Printf("Cc:       %s\n", strings.Join(msg.Cc, ", "))
+		}
+		fmt.Printf("Subject:  %s\n", msg.Subject)
+		for _, a := range msg.Attachments {
+			fmt.Printf("Attached: %s (%s, %s)\n", a.Name, a.ContentType, formatBytesShort(a.Size))
+		}
+		fmt.Println()
+		switch {
+		case msg.Text != "":
+			fmt.Println(strings.TrimRight(msg.Text, "\n"))
+		case msg.HTML != "":
+			fmt.Fprintln(os.Stderr, "This message is HTML only: print it with --html, or see it in 'preview mail open'.")
+		}
+		return nil
+	},
+}
+
+var mailOpenCmd = &cobra.Command{
+	Use:   "open [PROJECT/PREVIEW-NAME]",
+	Short: "Open the mail capture UI of a preview",
+	Long: `Open the mail capture UI of a preview in the browser and print its URL
+and credentials (HTTP basic auth), which can be shared with testers.
+
+If PROJECT/PREVIEW-NAME is given, opens the mail of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview mail open drupal-test/mr-5`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireMail(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveMailTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		list, err := apiClient.ListMail(cmd.Context(), project, previewName, 1)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Mail captured by %s/%s (%d messages):\n\n", project, previewName, list.Total)
+		fmt.Fprintf(os.Stderr, "  URL:       ")
+		fmt.Println(list.URL)
+		fmt.Fprintf(os.Stderr, "  User:      %s\n", list.User)
+		fmt.Fprintf(os.Stderr, "  Password:  %s\n", list.Password)
+		openBrowser(list.URL)
+		return nil
+	},
+}
+
+func resolveMailTarget(ctx context.Context, args []string) (string, string, error) {
+	if len(args) == 1 {
+		return parsePreviewName(args[0])
+	}
+	return detectPreview(ctx)
+}
+
+func init() {
+	mailListCmd.Flags().IntVar(&mailLimit, "limit", 20, "Number of messages to list")
+	mailListCmd.Flags().StringVarP(&mailOutput, "output", "o", "text", "Output format: text or json")
+	mailShowCmd.Flags().BoolVar(&mailHTML, "html", false, "Print the HTML of the message instead")
+	mailCmd.AddCommand(mailListCmd, mailShowCmd, mailOpenCmd)
+	rootCmd.AddCommand(mailCmd)
+}
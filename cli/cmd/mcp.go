@@ -0,0 +1,403 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var mcpReadOnly bool
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serve the preview tools to AI assistants over MCP",
+	Long: `Speak the Model Context Protocol on stdin and stdout, so AI coding
+assistants can list previews, check their status, read the logs of their
+pipelines and run drush on them with the credentials of this CLI.
+
+Tools:
+  list_previews   List the previews, of a project if given
+  preview_status  Status, URL, branch and last deployment of a preview
+  get_logs        Log of a job of the latest pipeline of a preview
+  run_drush       Run a drush command on a preview (not with --read-only)
+
+Register it in the MCP configuration of the assistant, e.g.:
+  {"mcpServers": {"preview": {"command": "preview", "args": ["mcp"]}}}
+
+Examples:
+  preview mcp
+  preview mcp --read-only`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := startMetrics(); err != nil {
+			return err
+		}
+		return serveMCP(cmd.Context(), apiClient, os.Stdin, os.Stdout)
+	},
+}
+
+// mcpProtocolVersion is the MCP revision implemented.
+const mcpProtocolVersion = "2024-11-05"
+
+// mcpLogLimit is how much of the end of a job log get_logs returns.
+const mcpLogLimit = 64 * 1024
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// mcpToolResult is the result of tools/call. Tool failures are results
+// with IsError set, so the assistant sees them, not protocol errors.
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpTools returns the tools offered, without run_drush with --read-only.
+func mcpTools() []mcpTool {
+	preview := map[string]interface{}{
+		"type":        "string",
+		"description": "The preview as PROJECT/PREVIEW-NAME, e.g. drupal-test/mr-5",
+	}
+	tools := []mcpTool{
+		{
+			Name:        "list_previews",
+			Description: "List the preview environments with their status, URL and branch.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"project": map[string]interface{}{"type": "string", "description": "Only list the previews of this project"},
+				},
+			},
+		},
+		{
+			Name:        "preview_status",
+			Description: "Get the status, URL, branch, commit and last deployment of a preview.",
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"preview": preview},
+				"required":   []string{"preview"},
+			},
+		},
+		{
+			Name:        "get_logs",
+			Description: "Get the log of a job of the latest GitLab pipeline of a preview: the named job, or the first failed job, or the last job.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"preview": preview,
+					"job":     map[string]interface{}{"type": "string", "description": "Name of the job, e.g. deploy"},
+				},
+				"required": []string{"preview"},
+			},
+		},
+	}
+	if !mcpReadOnly {
+		tools = append(tools, mcpTool{
+			Name:        "run_drush",
+			Description: "Run a non-interactive drush command on a preview and return its output, e.g. args \"status\" or \"cr\".",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"preview": preview,
+					"args":    map[string]interface{}{"type": "string", "description": "The drush arguments, e.g. \"pm:list --status=enabled\""},
+				},
+				"required": []string{"preview", "args"},
+			},
+		})
+	}
+	return tools
+}
+
+// mcpAPI is the part of the API the tools use.
+type mcpAPI interface {
+	client.PreviewAPI
+	client.CommandAPI
+	client.PipelineAPI
+}
+
+// serveMCP answers the JSON-RPC messages of in on out until in ends,
+// running the tools with api. Requests are answered concurrently, so a
+// slow drush doesn't block a ping.
+func serveMCP(ctx context.Context, api mcpAPI, in io.Reader, out io.Writer) error {
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+	send := func(resp rpcResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(resp)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	dec := json.NewDecoder(in)
+	for {
+		var req rpcRequest
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			send(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			return err
+		}
+		// Notifications (e.g. notifications/initialized) get no answer
+		if len(req.ID) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, rerr := handleMCP(ctx, api, req)
+			send(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr})
+		}()
+	}
+}
+
+func handleMCP(ctx context.Context, api mcpAPI, req rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "preview", "version": Version},
+		}, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": mcpTools()}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		text, err := callMCPTool(ctx, api, params.Name, params.Arguments)
+		if errors.Is(err, errUnknownTool) || errors.Is(err, errInvalidArguments) {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		if err != nil {
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: text}}}, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+var (
+	errUnknownTool      = errors.New("unknown tool")
+	errInvalidArguments = errors.New("invalid arguments")
+)
+
+// mcpArg is a string argument of a tool. Numbers and booleans are taken
+// as written, e.g. the job 2 as "2".
+type mcpArg string
+
+func (a *mcpArg) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*a = ""
+	case string:
+		*a = mcpArg(v)
+	case float64, bool:
+		*a = mcpArg(data)
+	default:
+		return fmt.Errorf("expected a string, got %s", data)
+	}
+	return nil
+}
+
+// decodeMCPArguments decodes the arguments of a tool call into v, a
+// struct of the arguments of the tool.
+func decodeMCPArguments(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArguments, err)
+	}
+	return nil
+}
+
+// mcpPreview returns p as the tools show it to the assistant: without the
+// password of the preview login.
+func mcpPreview(p client.Preview) client.Preview {
+	p.BasicAuthPass = nil
+	return p
+}
+
+// callMCPTool runs a tool with the arguments of raw and returns its text
+// output.
+func callMCPTool(ctx context.Context, api mcpAPI, name string, raw json.RawMessage) (string, error) {
+	switch name {
+	case "list_previews":
+		var args struct {
+			Project mcpArg `json:"project"`
+		}
+		if err := decodeMCPArguments(raw, &args); err != nil {
+			return "", err
+		}
+		list, err := api.ListPreviewsPage(ctx, client.ListOptions{Project: string(args.Project), IncludeStatus: true})
+		if err != nil {
+			return "", err
+		}
+		previews := make([]client.Preview, len(list.Previews))
+		for i, p := range list.Previews {
+			previews[i] = mcpPreview(p)
+		}
+		return mcpJSON(previews)
+
+	case "preview_status":
+		var args struct {
+			Preview mcpArg `json:"preview"`
+		}
+		if err := decodeMCPArguments(raw, &args); err != nil {
+			return "", err
+		}
+		project, previewName, err := parsePreviewName(string(args.Preview))
+		if err != nil {
+			return "", err
+		}
+		list, err := api.ListPreviewsPage(ctx, client.ListOptions{Project: project, IncludeStatus: true})
+		if err != nil {
+			return "", err
+		}
+		for _, p := range list.Previews {
+			if p.Name == previewName {
+				return mcpJSON(mcpPreview(p))
+			}
+		}
+		return "", fmt.Errorf("preview %s/%s not found", project, previewName)
+
+	case "get_logs":
+		var args struct {
+			Preview mcpArg `json:"preview"`
+			Job     mcpArg `json:"job"`
+		}
+		if err := decodeMCPArguments(raw, &args); err != nil {
+			return "", err
+		}
+		project, previewName, err := parsePreviewName(string(args.Preview))
+		if err != nil {
+			return "", err
+		}
+		pipelines, err := api.ListPipelines(ctx, project, previewName)
+		if err != nil {
+			return "", err
+		}
+		if len(pipelines) == 0 {
+			return "", fmt.Errorf("no pipelines for %s/%s", project, previewName)
+		}
+		jobs, err := api.ListPipelineJobs(ctx, project, pipelines[0].ID)
+		if err != nil {
+			return "", err
+		}
+		jobs = latestJobAttempts(jobs)
+		if len(jobs) == 0 {
+			return "", fmt.Errorf("pipeline %d has no jobs", pipelines[0].ID)
+		}
+		job, err := selectPipelineJob(jobs, string(args.Job))
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := api.JobLog(ctx, project, job.ID, &buf); err != nil {
+			return "", err
+		}
+		log := jobLogControl.ReplaceAll(buf.Bytes(), nil)
+		header := fmt.Sprintf("Job %s (%d, %s) of pipeline %d:\n", job.Name, job.ID, job.Status, pipelines[0].ID)
+		if len(log) > mcpLogLimit {
+			header += fmt.Sprintf("[first %d bytes omitted]\n", len(log)-mcpLogLimit)
+			log = log[len(log)-mcpLogLimit:]
+		}
+		return header + string(log), nil
+
+	case "run_drush":
+		if mcpReadOnly {
+			break
+		}
+		var args struct {
+			Preview mcpArg `json:"preview"`
+			Args    mcpArg `json:"args"`
+		}
+		if err := decodeMCPArguments(raw, &args); err != nil {
+			return "", err
+		}
+		project, previewName, err := parsePreviewName(string(args.Preview))
+		if err != nil {
+			return "", err
+		}
+		if args.Args == "" {
+			return "", fmt.Errorf("args is required")
+		}
+		result, err := api.PostDrushByName(ctx, project, previewName, string(args.Args))
+		if err != nil {
+			return "", err
+		}
+		if !result.Success {
+			return "", fmt.Errorf("drush %s failed: %s\n%s", args.Args, result.Error, result.Output)
+		}
+		return result.Output, nil
+	}
+	return "", fmt.Errorf("%w %q", errUnknownTool, name)
+}
+
+func mcpJSON(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func init() {
+	mcpCmd.Flags().BoolVar(&mcpReadOnly, "read-only", false, "Leave out the tools that change previews (run_drush)")
+	addMetricsFlag(mcpCmd)
+	rootCmd.AddCommand(mcpCmd)
+}
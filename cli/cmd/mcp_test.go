@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/capynet/preview-server/client/clienttest"
+)
+
+// mcpResponse is a response of serveMCP with its result left encoded.
+type mcpResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// mcpExchange sends requests to serveMCP with api and returns its
+// responses by ID.
+func mcpExchange(t *testing.T, api mcpAPI, requests ...string) map[string]mcpResponse {
+	t.Helper()
+	out, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- serveMCP(context.Background(), api, strings.NewReader(strings.Join(requests, "\n")), w)
+		w.Close()
+	}()
+
+	responses := map[string]mcpResponse{}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var resp mcpResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", scanner.Text(), err)
+		}
+		responses[string(resp.ID)] = resp
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return responses
+}
+
+func TestServeMCP(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	user, pass := "preview", "s3cret-password"
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5", Status: "running", BasicAuthUser: &user, BasicAuthPass: &pass})
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-6", Status: "stopped", BasicAuthUser: &user, BasicAuthPass: &pass})
+	defer func(readOnly bool) { mcpReadOnly = readOnly }(mcpReadOnly)
+	mcpReadOnly = true
+
+	responses := mcpExchange(t, srv.Client(),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"list_previews","arguments":{"project":"drupal-test"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"preview_status","arguments":{"preview":"drupal-test/mr-5"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"run_drush","arguments":{"preview":"drupal-test/mr-5","args":"cr"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"no/such/method"}`,
+	)
+	if len(responses) != 6 {
+		t.Fatalf("expected 6 responses (none to the notification), got %d", len(responses))
+	}
+
+	var initialized struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	json.Unmarshal(responses["1"].Result, &initialized)
+	if initialized.ProtocolVersion != mcpProtocolVersion || initialized.ServerInfo.Name != "preview" {
+		t.Fatalf("unexpected initialize result %s", responses["1"].Result)
+	}
+
+	var listed struct {
+		Tools []mcpTool `json:"tools"`
+	}
+	json.Unmarshal(responses["2"].Result, &listed)
+	var names []string
+	for _, tool := range listed.Tools {
+		names = append(names, tool.Name)
+	}
+	if strings.Join(names, ",") != "list_previews,preview_status,get_logs" {
+		t.Fatalf("unexpected tools with --read-only: %v", names)
+	}
+
+	toolText := func(id string) (string, bool) {
+		t.Helper()
+		var result mcpToolResult
+		if err := json.Unmarshal(responses[id].Result, &result); err != nil || len(result.Content) != 1 {
+			t.Fatalf("unexpected tool result %s: %v", responses[id].Result, err)
+		}
+		return result.Content[0].Text, result.IsError
+	}
+
+	text, isErr := toolText("3")
+	var previews []client.Preview
+	if err := json.Unmarshal([]byte(text), &previews); isErr || err != nil {
+		t.Fatalf("list_previews failed: %s", text)
+	}
+	if len(previews) != 2 {
+		t.Fatalf("expected 2 previews, got %s", text)
+	}
+	text, isErr = toolText("4")
+	if isErr || !strings.Contains(text, `"name": "mr-5"`) {
+		t.Fatalf("preview_status failed: %s", text)
+	}
+	for _, id := range []string{"3", "4"} {
+		if text, _ := toolText(id); strings.Contains(text, pass) {
+			t.Fatalf("response %s exposes the preview password: %s", id, text)
+		}
+	}
+
+	if responses["5"].Error == nil || responses["5"].Error.Code != rpcInvalidParams {
+		t.Fatalf("expected run_drush to be unknown with --read-only, got %+v", responses["5"])
+	}
+	if responses["6"].Error == nil || responses["6"].Error.Code != rpcMethodNotFound {
+		t.Fatalf("expected method not found, got %+v", responses["6"])
+	}
+}
+
+func TestServeMCPArguments(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5", Status: "running"})
+	srv.AddPipeline("drupal-test", "mr-5", client.Pipeline{ID: 7, Status: "success"},
+		client.Job{ID: 10, Name: "build", Status: "success"},
+		client.Job{ID: 11, Name: "2", Status: "success"})
+	srv.SetJobLog("drupal-test", 11, "log of job 2\n")
+
+	responses := mcpExchange(t, srv.Client(),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"get_logs","arguments":{"preview":"drupal-test/mr-5","job":2}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"list_previews","arguments":{"project":"drupal-test","limit":10,"all":true}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"list_previews"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"preview_status","arguments":{"preview":{"project":"drupal-test"}}}}`,
+	)
+
+	for _, id := range []string{"1", "2", "3"} {
+		var result mcpToolResult
+		if err := json.Unmarshal(responses[id].Result, &result); err != nil || result.IsError || len(result.Content) != 1 {
+			t.Fatalf("call %s failed: %s %+v", id, responses[id].Result, responses[id].Error)
+		}
+		if id == "1" && !strings.Contains(result.Content[0].Text, "log of job 2") {
+			t.Fatalf("expected the log of job 2, got %q", result.Content[0].Text)
+		}
+	}
+	if responses["4"].Error == nil || responses["4"].Error.Code != rpcInvalidParams {
+		t.Fatalf("expected an object for preview to be invalid params, got %+v", responses["4"])
+	}
+}
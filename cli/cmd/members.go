@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var membersOutput string
+var membersProject string
+var membersRole string
+
+// memberRoles are the roles of the server, from most to least privileged.
+var memberRoles = []string{"admin", "manager", "viewer"}
+
+var membersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "Manage who can access the server and its projects",
+	Long: `Manage the users of the server: admins see every project, managers and
+viewers only the projects they are members of. Managing members requires
+the manager role; changing roles, removing users and listing all pending
+invitations require the admin role.
+
+Users are given by email or ID. With --output json, the commands print JSON
+to stdout for scripts.`,
+}
+
+var membersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the users, their roles and projects, and pending invitations",
+	Long: `List the users of the server with their role and the projects they are
+members of, followed by the pending invitations. With --project, lists the
+members of that project and the invitations to it instead.
+
+Examples:
+  preview members list
+  preview members list --project drupal-test --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateMembersOutput(); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+
+		var result client.ProjectMembers
+		if membersProject != "" {
+			project, err := apiClient.ListProjectMembers(ctx, membersProject)
+			if err != nil {
+				return err
+			}
+			result = *project
+		} else {
+			members, err := apiClient.ListMembers(ctx)
+			if err != nil {
+				return err
+			}
+			// Only admins see every invitation; managers get the members
+			invitations, err := apiClient.ListInvitations(ctx)
+			var httpErr *client.HTTPError
+			if err != nil && !(errors.As(err, &httpErr) && httpErr.StatusCode == 403) {
+				return err
+			}
+			result = client.ProjectMembers{Members: members, Invitations: invitations}
+		}
+		if result.Members == nil {
+			result.Members = []client.Member{}
+		}
+		if result.Invitations == nil {
+			result.Invitations = []client.Invitation{}
+		}
+
+		if membersOutput == "json" {
+			data, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		printMembers(result)
+		return nil
+	},
+}
+
+var membersInviteCmd = &cobra.Command{
+	Use:   "invite EMAIL",
+	Short: "Invite a user, or add an existing user to a project",
+	Long: `Email an invitation to join the server with --role (default viewer). With
+--project, the user becomes a member of that project on accepting; users
+that already exist are added to it right away.
+
+Examples:
+  preview members invite ana@example.com --project drupal-test
+  preview members invite ops@example.com --role manager`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateMembersOutput(); err != nil {
+			return err
+		}
+		if err := validateMemberRole(membersRole); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		email := args[0]
+
+		members, err := apiClient.ListMembers(ctx)
+		if err != nil {
+			return err
+		}
+		if member, err := findMember(members, email); err == nil {
+			if membersProject == "" {
+				return fmt.Errorf("%s is already a user; change their role with 'preview members role' or add them to a project with --project", member.Email)
+			}
+			if err := apiClient.AddProjectMember(ctx, membersProject, member.ID); err != nil {
+				return err
+			}
+			return printMemberChange(memberChange{Action: "added", Email: member.Email, Project: membersProject},
+				fmt.Sprintf("Added %s to %s.", member.Email, membersProject))
+		}
+
+		invitation, err := apiClient.Invite(ctx, email, membersRole, membersProject)
+		if err != nil {
+			return err
+		}
+		message := fmt.Sprintf("Invited %s as %s.", invitation.Email, invitation.Role)
+		if membersProject != "" {
+			message = fmt.Sprintf("Invited %s as %s of %s.", invitation.Email, invitation.Role, membersProject)
+		}
+		return printMemberChange(memberChange{Action: "invited", Email: invitation.Email, Role: invitation.Role, Project: membersProject}, message)
+	},
+}
+
+var membersRemoveCmd = &cobra.Command{
+	Use:   "remove USER",
+	Short: "Remove a user from a project or the server, or cancel an invitation",
+	Long: `Remove USER (an email or ID) from --project. Without --project, deletes
+the user from the server after confirmation, or cancels the pending
+invitation of that email.
+
+Examples:
+  preview members remove ana@example.com --project drupal-test
+  preview members remove ana@example.com --yes`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateMembersOutput(); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		members, err := apiClient.ListMembers(ctx)
+		if err != nil {
+			return err
+		}
+		member, err := findMember(members, args[0])
+
+		switch {
+		case err == nil && membersProject != "":
+			if err := apiClient.RemoveProjectMember(ctx, membersProject, member.ID); err != nil {
+				return err
+			}
+			return printMemberChange(memberChange{Action: "removed", Email: member.Email, Project: membersProject},
+				fmt.Sprintf("Removed %s from %s.", member.Email, membersProject))
+		case err == nil:
+			ok, err := confirm(fmt.Sprintf("Delete %s from the server? They lose access to every project.", member.Email))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("aborted")
+			}
+			if err := apiClient.RemoveMember(ctx, member.ID); err != nil {
+				return err
+			}
+			return printMemberChange(memberChange{Action: "removed", Email: member.Email},
+				fmt.Sprintf("Deleted %s.", member.Email))
+		case membersProject != "":
+			return err
+		}
+
+		invitation, ierr := findInvitation(ctx, args[0])
+		if ierr != nil {
+			return err
+		}
+		if err := apiClient.CancelInvitation(ctx, invitation.ID); err != nil {
+			return err
+		}
+		return printMemberChange(memberChange{Action: "cancelled", Email: invitation.Email},
+			fmt.Sprintf("Cancelled the invitation of %s.", invitation.Email))
+	},
+}
+
+var membersRoleCmd = &cobra.Command{
+	Use:   "role USER ROLE",
+	Short: "Change the role of a user",
+	Long: `Change the role of USER (an email or ID) to ROLE: admin, manager or viewer.
+
+Example:
+  preview members role ana@example.com manager`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateMembersOutput(); err != nil {
+			return err
+		}
+		role := args[1]
+		if err := validateMemberRole(role); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		members, err := apiClient.ListMembers(ctx)
+		if err != nil {
+			return err
+		}
+		member, err := findMember(members, args[0])
+		if err != nil {
+			return err
+		}
+		if err := apiClient.SetMemberRole(ctx, member.ID, role); err != nil {
+			return err
+		}
+		return printMemberChange(memberChange{Action: "role", Email: member.Email, Role: role},
+			fmt.Sprintf("%s is now %s.", member.Email, role))
+	},
+}
+
+// memberChange is the JSON output of the commands changing members.
+type memberChange struct {
+	Action  string `json:"action"` // invited, added, removed, cancelled or role
+	Email   string `json:"email"`
+	Role    string `json:"role,omitempty"`
+	Project string `json:"project,omitempty"`
+}
+
+// printMemberChange prints change as JSON with --output json, otherwise
+// message.
+func printMemberChange(change memberChange, message string) error {
+	if membersOutput != "json" {
+		fmt.Println(message)
+		return nil
+	}
+	data, err := json.MarshalIndent(change, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printMembers(result client.ProjectMembers) {
+	if len(result.Members) == 0 {
+		fmt.Println("No members.")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEMAIL\tNAME\tROLE\tPROJECTS")
+		for _, m := range result.Members {
+			role, projects := "-", strings.Join(m.Projects, ", ")
+			if m.Role != nil {
+				role = *m.Role
+			}
+			if role == "admin" {
+				projects = "(all)"
+			} else if projects == "" {
+				projects = "-"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.ID, m.Email, m.Name, role, projects)
+		}
+		w.Flush()
+	}
+
+	if len(result.Invitations) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Pending invitations:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tROLE\tPROJECT\tEXPIRES")
+	for _, inv := range result.Invitations {
+		project := "-"
+		if inv.ProjectSlug != nil {
+			project = *inv.ProjectSlug
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", inv.Email, inv.Role, project, formatUploadTime(inv.ExpiresAt))
+	}
+	w.Flush()
+}
+
+// findMember returns the member whose email (case-insensitively) or ID is
+// user.
+func findMember(members []client.Member, user string) (client.Member, error) {
+	id, _ := strconv.Atoi(user)
+	for _, m := range members {
+		if strings.EqualFold(m.Email, user) || id != 0 && m.ID == id {
+			return m, nil
+		}
+	}
+	return client.Member{}, fmt.Errorf("user %q not found (see 'preview members list')", user)
+}
+
+// findInvitation returns the pending invitation of email.
+func findInvitation(ctx context.Context, email string) (client.Invitation, error) {
+	invitations, err := apiClient.ListInvitations(ctx)
+	if err != nil {
+		return client.Invitation{}, err
+	}
+	for _, inv := range invitations {
+		if strings.EqualFold(inv.Email, email) {
+			return inv, nil
+		}
+	}
+	return client.Invitation{}, fmt.Errorf("no pending invitation for %q", email)
+}
+
+func validateMembersOutput() error {
+	if membersOutput != "text" && membersOutput != "json" {
+		return fmt.Errorf("invalid --output %q: expected text or json", membersOutput)
+	}
+	return nil
+}
+
+func validateMemberRole(role string) error {
+	for _, r := range memberRoles {
+		if r == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid role %q: expected %s", role, strings.Join(memberRoles, ", "))
+}
+
+func init() {
+	membersCmd.PersistentFlags().StringVarP(&membersOutput, "output", "o", "text", "Output format: text or json")
+	membersListCmd.Flags().StringVar(&membersProject, "project", "", "List the members of this project")
+	membersInviteCmd.Flags().StringVar(&membersProject, "project", "", "Project the user becomes a member of")
+	membersInviteCmd.Flags().StringVar(&membersRole, "role", "viewer", "Role of the invited user: admin, manager or viewer")
+	membersRemoveCmd.Flags().StringVar(&membersProject, "project", "", "Only remove the user from this project")
+	membersCmd.AddCommand(membersListCmd, membersInviteCmd, membersRemoveCmd, membersRoleCmd)
+	rootCmd.AddCommand(membersCmd)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+// metricsAddr is where long-running commands serve /metrics
+// (--metrics-addr), empty for none.
+var metricsAddr string
+
+// cliMetrics are the counters of the API traffic of the CLI, exposed in
+// the Prometheus text format.
+var cliMetrics = &apiMetrics{requests: make(map[string]int64)}
+
+type apiMetrics struct {
+	mu sync.Mutex
+	// requests counts API requests by "method code"; code is "error" for
+	// requests that got no response
+	requests map[string]int64
+	errors   int64
+
+	uploadsInProgress int64 // atomic
+	uploadBytes       int64 // atomic
+}
+
+// addMetricsFlag adds --metrics-addr to a long-running command, which
+// calls startMetrics when it starts.
+func addMetricsFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. 127.0.0.1:9464) at /metrics")
+}
+
+// startMetrics serves /metrics on --metrics-addr, if set, until the
+// process exits, and counts the requests of apiClient.
+func startMetrics() error {
+	if metricsAddr == "" {
+		return nil
+	}
+	if c, ok := apiClient.(*client.Client); ok {
+		next := c.HTTPClient.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		c.HTTPClient.Transport = &metricsTransport{next: next, metrics: cliMetrics}
+	}
+
+	listener, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		cliMetrics.write(w)
+	})
+	go http.Serve(listener, mux)
+	fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// isUpload reports whether req sends base files: a single upload or a
+// chunk.
+func isUpload(req *http.Request) bool {
+	return req.Method == "POST" && req.Body != nil && strings.Contains(req.URL.Path, "/base-files/")
+}
+
+// metricsTransport counts the requests it sends.
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *apiMetrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isUpload(req) {
+		atomic.AddInt64(&t.metrics.uploadsInProgress, 1)
+		defer atomic.AddInt64(&t.metrics.uploadsInProgress, -1)
+		req = req.Clone(req.Context())
+		req.Body = &countingBody{ReadCloser: req.Body, n: &t.metrics.uploadBytes}
+	}
+	resp, err := t.next.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = fmt.Sprint(resp.StatusCode)
+	}
+	t.metrics.mu.Lock()
+	t.metrics.requests[req.Method+" "+code]++
+	if err != nil || resp.StatusCode >= 400 {
+		t.metrics.errors++
+	}
+	t.metrics.mu.Unlock()
+	return resp, err
+}
+
+// countingBody adds the bytes read from a request body to n.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
+func (m *apiMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP preview_cli_info Version of the preview CLI.")
+	fmt.Fprintln(w, "# TYPE preview_cli_info gauge")
+	fmt.Fprintf(w, "preview_cli_info{version=%q} 1\n", Version)
+
+	fmt.Fprintln(w, "# HELP preview_cli_api_requests_total API requests sent, by method and status code.")
+	fmt.Fprintln(w, "# TYPE preview_cli_api_requests_total counter")
+	keys := make([]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		method, code, _ := strings.Cut(k, " ")
+		fmt.Fprintf(w, "preview_cli_api_requests_total{method=%q,code=%q} %d\n", method, code, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP preview_cli_api_errors_total API requests that failed or got an error status.")
+	fmt.Fprintln(w, "# TYPE preview_cli_api_errors_total counter")
+	fmt.Fprintf(w, "preview_cli_api_errors_total %d\n", m.errors)
+
+	fmt.Fprintln(w, "# HELP preview_cli_uploads_in_progress Base file uploads and chunks being sent.")
+	fmt.Fprintln(w, "# TYPE preview_cli_uploads_in_progress gauge")
+	fmt.Fprintf(w, "preview_cli_uploads_in_progress %d\n", atomic.LoadInt64(&m.uploadsInProgress))
+
+	fmt.Fprintln(w, "# HELP preview_cli_upload_bytes_total Bytes of base files uploaded.")
+	fmt.Fprintln(w, "# TYPE preview_cli_upload_bytes_total counter")
+	fmt.Fprintf(w, "preview_cli_upload_bytes_total %d\n", atomic.LoadInt64(&m.uploadBytes))
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+// firstRun walks a new user through setting up the CLI when cmd needs the
+// server but no API URL is configured: it asks for the API URL, logs in and
+// shows the previews of the project in the current directory, then lets
+// cmd run. It returns the resulting config; its API URL is still empty if
+// the user gave up.
+func firstRun(cmd *cobra.Command) config {
+	ctx := cmd.Context()
+	fmt.Fprintln(os.Stderr, "Welcome to Preview Manager! The CLI isn't set up yet; let's connect it to your preview server.")
+	fmt.Fprintf(os.Stderr, "(Settings are saved to %s. Run 'preview setup api URL' and 'preview login' to do this without prompts.)\n\n", configPath())
+
+	reader := bufio.NewReader(os.Stdin)
+	apiURL := ""
+	for attempt := 0; attempt < 3 && apiURL == ""; attempt++ {
+		fmt.Fprintf(os.Stderr, "API URL [%s]: ", defaultAPIURL)
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return loadConfig()
+		}
+		candidate := normalizeAPIURL(strings.TrimSpace(input))
+		if candidate == "" {
+			candidate = defaultAPIURL
+		}
+		if _, err := newPublicClient(candidate).CLIVersion(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not reach a preview server at %s: %v\n", candidate, err)
+			continue
+		}
+		apiURL = candidate
+	}
+	if apiURL == "" {
+		fmt.Fprintln(os.Stderr, "Giving up; ask your team lead for the API URL of your preview server.")
+		return loadConfig()
+	}
+
+	cfg := loadConfig()
+	cfg.APIURL = apiURL
+	if err := saveConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save config: %v\n", err)
+		return cfg
+	}
+	fmt.Fprintf(os.Stderr, "API URL saved: %s\n\n", apiURL)
+
+	ok, err := confirm("Log in now?")
+	if err != nil || !ok {
+		return cfg
+	}
+	if err := login(ctx, sshSession()); err != nil {
+		fmt.Fprintf(os.Stderr, "Login failed: %v\n", err)
+		return loadConfig()
+	}
+	cfg = loadConfig()
+
+	// 'preview list' shows the previews itself
+	if slug, err := detectProjectSlug(); err == nil && cmd.Name() != "list" {
+		fmt.Fprintf(os.Stderr, "\nPreviews of %s (detected from the git remote):\n", slug)
+		result, err := newClient(cfg).ListPreviewsPage(ctx, client.ListOptions{Project: slug, IncludeStatus: true})
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Could not list them: %v\n", err)
+		case len(result.Previews) == 0:
+			fmt.Fprintln(os.Stderr, "None yet; they are created for the merge requests of the project.")
+		default:
+			printPreviews(result.Previews)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nSetup complete. Run 'preview --help' to see what else the CLI can do.\n")
+	fmt.Fprintf(os.Stderr, "Continuing with '%s'...\n\n", cmd.CommandPath())
+	return cfg
+}
+
+// normalizeAPIURL adds https:// to a bare host name and drops trailing
+// slashes.
+func normalizeAPIURL(url string) string {
+	url = strings.TrimRight(url, "/")
+	if url != "" && !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
+	return url
+}
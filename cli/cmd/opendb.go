@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var openDBPort int
+var openDBOpen string
+
+// dbTools are the database GUIs open-db can launch.
+var dbTools = []string{"tableplus", "dbeaver", "sequel-ace"}
+
+var openDBCmd = &cobra.Command{
+	Use:   "open-db [PROJECT/PREVIEW-NAME]",
+	Short: "Connect a database GUI to a preview's database",
+	Long: `Open a tunnel from a local port to the database of a running preview,
+through the preview server, and print how to connect to it: host, port and
+credentials, a mysql:// URL (TablePlus, Sequel Ace) and a JDBC URL
+(DBeaver). The tunnel stays open until Ctrl+C.
+
+--open launches a GUI connected to it: tableplus, dbeaver or sequel-ace
+(macOS only). The local port is random unless --port is given; a fixed
+port lets a connection saved in the GUI be reused.
+
+If PROJECT/PREVIEW-NAME is given, connects to that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview open-db drupal-test/mr-5
+  preview open-db --open tableplus
+  preview open-db drupal-test/mr-5 --port 33060 --open dbeaver`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if openDBOpen != "" && !isDBTool(openDBOpen) {
+			return fmt.Errorf("invalid --open %q: expected tableplus, dbeaver or sequel-ace", openDBOpen)
+		}
+		if err := requireDBTunnel(); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Reach the database once first, to fail early if it isn't running
+		probe, info, err := apiClient.DialDB(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+		probe.Close()
+
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(openDBPort)))
+		if err != nil {
+			return fmt.Errorf("failed to listen on port %d: %w", openDBPort, err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		dsn := dbURL(info, port)
+		engine := ""
+		if info.Engine != "" {
+			engine = " (" + info.Engine + ")"
+		}
+		fmt.Fprintf(os.Stderr, "Tunnel to the database of %s/%s%s open on 127.0.0.1:%d\n\n", project, previewName, engine, port)
+		fmt.Fprintf(os.Stderr, "  Host:      127.0.0.1\n")
+		fmt.Fprintf(os.Stderr, "  Port:      %d\n", port)
+		fmt.Fprintf(os.Stderr, "  Database:  %s\n", info.Database)
+		fmt.Fprintf(os.Stderr, "  User:      %s\n", info.User)
+		fmt.Fprintf(os.Stderr, "  Password:  %s\n", info.Password)
+		fmt.Fprintf(os.Stderr, "  JDBC:      jdbc:mysql://127.0.0.1:%d/%s\n", port, info.Database)
+		fmt.Fprintf(os.Stderr, "  URL:       ")
+		fmt.Println(dsn)
+		fmt.Fprintln(os.Stderr)
+
+		if openDBOpen != "" {
+			if err := launchDBTool(openDBOpen, info, port, project+"/"+previewName); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to launch %s: %v\n", openDBOpen, err)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Press Ctrl+C to close the tunnel.")
+
+		serveDBTunnel(ctx, listener, project, previewName)
+		fmt.Fprintln(os.Stderr, "\nTunnel closed.")
+		return nil
+	},
+}
+
+func isDBTool(name string) bool {
+	for _, t := range dbTools {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dbURL returns the mysql:// URL of the tunneled database on port.
+func dbURL(info *client.DBInfo, port int) string {
+	return fmt.Sprintf("mysql://%s:%s@127.0.0.1:%d/%s", info.User, info.Password, port, info.Database)
+}
+
+// serveDBTunnel tunnels each connection accepted on listener to the
+// database of the preview until ctx is done, then closes them all.
+func serveDBTunnel(ctx context.Context, listener net.Listener, project, previewName string) {
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	track := func(c net.Conn, open bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if open {
+			conns[c] = true
+		} else {
+			delete(conns, c)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for c := range conns {
+			c.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer local.Close()
+			remote, _, err := apiClient.DialDB(ctx, project, previewName)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to open a database connection: %v\n", err)
+				}
+				return
+			}
+			defer remote.Close()
+			track(local, true)
+			track(remote, true)
+			defer track(local, false)
+			defer track(remote, false)
+
+			// Either side closing ends the connection
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(remote, local); done <- struct{}{} }()
+			go func() { io.Copy(local, remote); done <- struct{}{} }()
+			<-done
+		}()
+	}
+	wg.Wait()
+}
+
+// launchDBTool starts the GUI tool connected to the tunneled database on
+// port, naming the connection name where the tool allows it.
+func launchDBTool(tool string, info *client.DBInfo, port int, name string) error {
+	dsn := dbURL(info, port)
+	var c *exec.Cmd
+	switch tool {
+	case "tableplus":
+		if runtime.GOOS == "darwin" {
+			c = exec.Command("open", "-a", "TablePlus", dsn)
+		} else {
+			c = exec.Command("tableplus", dsn)
+		}
+	case "sequel-ace":
+		if runtime.GOOS != "darwin" {
+			return fmt.Errorf("Sequel Ace is only available on macOS")
+		}
+		c = exec.Command("open", "-a", "Sequel Ace", dsn)
+	case "dbeaver":
+		con := fmt.Sprintf("driver=mysql|host=127.0.0.1|port=%d|database=%s|user=%s|password=%s|name=%s|connect=true",
+			port, info.Database, info.User, info.Password, name)
+		if runtime.GOOS == "darwin" {
+			c = exec.Command("open", "-a", "DBeaver", "--args", "-con", con)
+		} else {
+			c = exec.Command("dbeaver", "-con", con)
+		}
+	}
+	return c.Start()
+}
+
+func init() {
+	openDBCmd.Flags().IntVar(&openDBPort, "port", 0, "Local port of the tunnel (default: a random free port)")
+	openDBCmd.Flags().StringVar(&openDBOpen, "open", "", "Launch a GUI connected to the tunnel: tableplus, dbeaver or sequel-ace")
+	rootCmd.AddCommand(openDBCmd)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var orgCmd = &cobra.Command{
+	Use:   "org",
+	Short: "Manage the organization the CLI acts on",
+}
+
+var orgSwitchCmd = &cobra.Command{
+	Use:   "switch [ORG]",
+	Short: "Switch to another organization",
+	Long: `Switch the organization used by all commands. ORG is an organization ID or
+name; if omitted, shows an organization selector.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireOrgs(); err != nil {
+			return err
+		}
+		orgs, err := apiClient.ListOrgs(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if len(orgs) == 0 {
+			return fmt.Errorf("this server has no organizations to switch between")
+		}
+
+		var org client.Org
+		if len(args) == 1 {
+			org, err = findOrg(orgs, args[0])
+		} else {
+			org, err = selectOrg(orgs)
+		}
+		if err != nil {
+			return err
+		}
+
+		cfg := loadConfig()
+		cfg.Org = org.ID
+		if err := saveConfig(cfg); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+		fmt.Printf("Switched to organization %s (%s).\n", org.Name, org.ID)
+		return nil
+	},
+}
+
+func findOrg(orgs []client.Org, idOrName string) (client.Org, error) {
+	for _, o := range orgs {
+		if o.ID == idOrName || strings.EqualFold(o.Name, idOrName) {
+			return o, nil
+		}
+	}
+	return client.Org{}, fmt.Errorf("organization %q not found", idOrName)
+}
+
+// selectOrg asks the user to pick one of orgs, skipping the prompt when
+// there is only one.
+func selectOrg(orgs []client.Org) (client.Org, error) {
+	if len(orgs) == 1 {
+		fmt.Printf("Using organization %s.\n", orgs[0].Name)
+		return orgs[0], nil
+	}
+	if noInput {
+		return client.Org{}, errNoInput("pass ORG")
+	}
+
+	fmt.Println("Select an organization:")
+	for i, o := range orgs {
+		fmt.Printf("  %d) %s (%s)\n", i+1, o.Name, o.ID)
+	}
+	fmt.Print("\n> ")
+
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return client.Org{}, fmt.Errorf("failed to read input: %w", err)
+	}
+	input = strings.TrimSpace(input)
+
+	// Accept number, ID or name
+	if idx, err := strconv.Atoi(input); err == nil {
+		if idx < 1 || idx > len(orgs) {
+			return client.Org{}, fmt.Errorf("invalid selection: %d", idx)
+		}
+		return orgs[idx-1], nil
+	}
+	return findOrg(orgs, input)
+}
+
+func init() {
+	orgCmd.AddCommand(orgSwitchCmd)
+	rootCmd.AddCommand(orgCmd)
+}
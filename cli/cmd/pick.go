@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+var pickQuery string
+var pickThen string
+
+// pickRows is the number of matches the picker shows.
+const pickRows = 10
+
+var pickCmd = &cobra.Command{
+	Use:   "pick [--then COMMAND [ARGS...]]",
+	Short: "Pick a preview with a fuzzy finder",
+	Long: `Pick a preview of any project by typing part of its project, name or
+branch, and print it as PROJECT/PREVIEW-NAME on stdout.
+
+Characters typed match in order anywhere in "PROJECT/PREVIEW-NAME BRANCH",
+as in fzf; matches at the start of words rank first, then running previews.
+Up and down (or Ctrl+P and Ctrl+N) move, Enter picks, Esc or Ctrl+C
+cancels. The finder draws on stderr, so $(preview pick) works.
+
+--then runs a preview command on the picked preview, with the remaining
+arguments after it, and the global flags given to pick (--ca-cert,
+--no-input...). --query starts with a query; without a terminal it must
+match exactly one preview.
+
+Examples:
+  preview pick
+  preview pick --then drush cr
+  preview pick --then url --query shop
+  preview logs $(preview pick)`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pickThen == "" && len(args) > 0 {
+			return fmt.Errorf("unexpected arguments %q: the command they go with must be given with --then", args)
+		}
+
+		result, err := apiClient.ListPreviews(cmd.Context(), false)
+		if err != nil {
+			return fmt.Errorf("failed to list previews: %w", err)
+		}
+		if len(result.Previews) == 0 {
+			return fmt.Errorf("no previews found")
+		}
+
+		var picked *client.Preview
+		if !noInput && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+			if picked, err = runPicker(result.Previews, pickQuery); err != nil {
+				return err
+			}
+		} else {
+			matches := fuzzyFilter(result.Previews, pickQuery)
+			if len(matches) != 1 {
+				return fmt.Errorf("can't prompt to pick: --query %q matches %d previews, it must match exactly one", pickQuery, len(matches))
+			}
+			picked = &matches[0]
+		}
+
+		target := picked.Project + "/" + picked.Name
+		if pickThen == "" {
+			fmt.Println(target)
+			return nil
+		}
+		return runPickedCommand(append([]string{pickThen, target}, args...))
+	},
+}
+
+// runPickedCommand runs the CLI again with args and exits with its status.
+// The global flags given to pick (--ca-cert, --no-input...) are passed on.
+func runPickedCommand(args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args = append(rootFlagArgs(), args...)
+	fmt.Fprintf(os.Stderr, "Running: preview %s\n", strings.Join(args, " "))
+	c := exec.Command(self, args...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
+
+// rootFlagArgs returns the global flags set on the command line, as
+// arguments to give another run of the CLI.
+func rootFlagArgs() []string {
+	var args []string
+	rootCmd.PersistentFlags().Visit(func(f *pflag.Flag) {
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+	})
+	return args
+}
+
+// pickText is what the query matches of p.
+func pickText(p client.Preview) string {
+	return p.Project + "/" + p.Name + " " + p.Branch
+}
+
+// fuzzyScore returns how well query matches text, its characters in order
+// and case-insensitively, and false if it doesn't. Consecutive characters
+// and characters at the start of words, the project first, score more.
+func fuzzyScore(query, text string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(text))
+	score, qi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 2
+		}
+		switch {
+		case ti == 0:
+			score += 4 // the project
+		case !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]):
+			score += 3
+		}
+		prev = ti
+		qi++
+	}
+	return score, qi == len(q)
+}
+
+// fuzzyFilter returns the previews matching query, best first, then running
+// ones, then by project and name.
+func fuzzyFilter(previews []client.Preview, query string) []client.Preview {
+	type match struct {
+		p     client.Preview
+		score int
+	}
+	var matches []match
+	for _, p := range previews {
+		if score, ok := fuzzyScore(query, pickText(p)); ok {
+			matches = append(matches, match{p, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if ra, rb := a.p.Status == "running", b.p.Status == "running"; ra != rb {
+			return ra
+		}
+		if a.p.Project != b.p.Project {
+			return a.p.Project < b.p.Project
+		}
+		return a.p.Name < b.p.Name
+	})
+	result := make([]client.Preview, len(matches))
+	for i, m := range matches {
+		result[i] = m.p
+	}
+	return result
+}
+
+// runPicker runs the interactive finder on the terminal of stdin, drawing
+// on stderr, and returns the preview picked.
+func runPicker(previews []client.Preview, query string) (*client.Preview, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	selected := 0
+	buf := make([]byte, 64)
+	for {
+		matches := fuzzyFilter(previews, query)
+		if selected >= len(matches) {
+			selected = len(matches) - 1
+		}
+		if selected < 0 {
+			selected = 0
+		}
+		drawPicker(query, matches, selected, len(previews))
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			clearPicker()
+			return nil, err
+		}
+		key := buf[:n]
+		switch {
+		case string(key) == "\r" || string(key) == "\n":
+			clearPicker()
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no preview matches %q", query)
+			}
+			return &matches[selected], nil
+		case string(key) == "\x1b" || string(key) == "\x03":
+			clearPicker()
+			return nil, fmt.Errorf("cancelled")
+		case string(key) == "\x1b[A" || string(key) == "\x10": // up, Ctrl+P
+			selected--
+		case string(key) == "\x1b[B" || string(key) == "\x0e": // down, Ctrl+N
+			selected++
+		case string(key) == "\x7f" || string(key) == "\b":
+			if r := []rune(query); len(r) > 0 {
+				query = string(r[:len(r)-1])
+			}
+			selected = 0
+		case string(key) == "\x15": // Ctrl+U
+			query, selected = "", 0
+		case key[0] >= 0x20 && key[0] != 0x7f:
+			query += string(key)
+			selected = 0
+		}
+	}
+}
+
+// drawPicker draws the query line and the first pickRows matches below it,
+// leaving the cursor at the end of the query.
+func drawPicker(query string, matches []client.Preview, selected, total int) {
+	var b strings.Builder
+	b.WriteString("\r\033[J")
+	fmt.Fprintf(&b, "> %s", query)
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width < 20 {
+		width = 80
+	}
+	rows := 0
+	start := 0
+	if selected >= pickRows {
+		start = selected - pickRows + 1
+	}
+	for i := start; i < len(matches) && i < start+pickRows; i++ {
+		p := matches[i]
+		marker := " "
+		if i == selected {
+			marker = ">"
+		}
+		line := []rune(fmt.Sprintf("%s %s/%s  %s  %s", marker, p.Project, p.Name, p.Status, p.Branch))
+		if len(line) >= width {
+			// A wrapped line would break moving back up
+			line = line[:width-1]
+		}
+		b.WriteString("\r\n" + string(line))
+		rows++
+	}
+	fmt.Fprintf(&b, "\r\n  %d/%d", len(matches), total)
+	rows++
+	fmt.Fprintf(&b, "\033[%dA\r\033[%dC", rows, len([]rune(query))+2)
+	os.Stderr.WriteString(b.String())
+}
+
+// clearPicker erases what drawPicker drew.
+func clearPicker() {
+	os.Stderr.WriteString("\r\033[J")
+}
+
+func init() {
+	pickCmd.Flags().StringVar(&pickThen, "then", "", "Command to run on the picked preview, e.g. --then drush cr")
+	// Flags after the --then command are its own
+	pickCmd.Flags().SetInterspersed(false)
+	pickCmd.Flags().StringVar(&pickQuery, "query", "", "Initial query; without a terminal it must match exactly one preview")
+	rootCmd.AddCommand(pickCmd)
+}
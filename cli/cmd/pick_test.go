@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRootFlagArgs(t *testing.T) {
+	flags := rootCmd.PersistentFlags()
+	set := map[string]string{"ca-cert": "corp.pem", "no-input": "true", "progress": "json"}
+	for name, value := range set {
+		f := flags.Lookup(name)
+		defer func(value string) {
+			f.Value.Set(value)
+			f.Changed = false
+		}(f.Value.String())
+		if err := flags.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"--ca-cert=corp.pem", "--no-input=true", "--progress=json"}
+	if got := rootFlagArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("rootFlagArgs() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var pipelineOutput string
+var pipelineProject string
+var pipelineJob string
+
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "Follow the GitLab pipelines that deploy previews",
+	Long: `Show the GitLab CI pipelines of previews and the logs of their jobs,
+read through the GitLab connection of the server, without switching to the
+GitLab UI. Requires the viewer role on the project.`,
+}
+
+var pipelineListCmd = &cobra.Command{
+	Use:   "list [PROJECT/PREVIEW-NAME]",
+	Short: "List the latest pipelines of a preview",
+	Long: `List the latest pipelines of a preview, newest first: those of its merge
+request for mr-ID previews, of its branch for branch previews.
+
+If PROJECT/PREVIEW-NAME is given, lists the pipelines of that preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview pipeline list drupal-test/mr-5
+  preview pipeline list --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validatePipelineOutput(); err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := requirePipelines(); err != nil {
+			return err
+		}
+		pipelines, err := apiClient.ListPipelines(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+
+		if pipelineOutput == "json" {
+			if pipelines == nil {
+				pipelines = []client.Pipeline{}
+			}
+			data, err := json.MarshalIndent(pipelines, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(pipelines) == 0 {
+			fmt.Printf("No pipelines for %s/%s.\n", project, previewName)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tREF\tCOMMIT\tCREATED\tURL")
+		for _, p := range pipelines {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Status, p.Ref, shortSHA(p.SHA), formatUploadTime(p.CreatedAt), p.WebURL)
+		}
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\nSee the logs of a pipeline with 'preview pipeline logs ID --project %s'.\n", project)
+		return nil
+	},
+}
+
+var pipelineLogsCmd = &cobra.Command{
+	Use:   "logs PIPELINE-ID",
+	Short: "Print the log of a pipeline job",
+	Long: `Print the log of a job of pipeline PIPELINE-ID (see 'preview pipeline
+list'). With --job, prints the log of the job with that name, its last
+attempt if it was retried. Otherwise prints the log of the first failed
+job, or of the last job if none failed; the other jobs are listed on stderr.
+
+The project is --project, or detected from the git remote. Colors are kept
+when printing to a terminal and stripped otherwise.
+
+Examples:
+  preview pipeline logs 1234 --job deploy
+  preview pipeline logs 1234 --project drupal-test > deploy.log`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipelineID, err := strconv.Atoi(args[0])
+		if err != nil || pipelineID <= 0 {
+			return fmt.Errorf("invalid pipeline ID %q", args[0])
+		}
+		project := pipelineProject
+		if project == "" {
+			if project, err = detectProjectSlug(); err != nil {
+				return err
+			}
+		}
+
+		if err := requirePipelines(); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		jobs, err := apiClient.ListPipelineJobs(ctx, project, pipelineID)
+		if err != nil {
+			return err
+		}
+		jobs = latestJobAttempts(jobs)
+		if len(jobs) == 0 {
+			return fmt.Errorf("pipeline %d has no jobs", pipelineID)
+		}
+
+		job, err := selectPipelineJob(jobs, pipelineJob)
+		if err != nil {
+			return err
+		}
+		if pipelineJob == "" && len(jobs) > 1 {
+			fmt.Fprintln(os.Stderr, "Jobs:")
+			for _, j := range jobs {
+				marker := " "
+				if j.ID == job.ID {
+					marker = "*"
+				}
+				fmt.Fprintf(os.Stderr, "  %s %-20s %-10s %s\n", marker, j.Name, j.Stage, j.Status)
+			}
+			fmt.Fprintln(os.Stderr, "Pick another job with --job NAME.")
+			fmt.Fprintln(os.Stderr)
+		}
+		fmt.Fprintf(os.Stderr, "Log of job %s (%d, %s):\n", job.Name, job.ID, job.Status)
+
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return apiClient.JobLog(ctx, project, job.ID, os.Stdout)
+		}
+		out := &plainLogWriter{w: os.Stdout}
+		if err := apiClient.JobLog(ctx, project, job.ID, out); err != nil {
+			return err
+		}
+		return out.Flush()
+	},
+}
+
+// latestJobAttempts drops the earlier attempts of retried jobs, keeping
+// the order of jobs.
+func latestJobAttempts(jobs []client.Job) []client.Job {
+	latest := make(map[string]int)
+	for _, j := range jobs {
+		if j.ID > latest[j.Name] {
+			latest[j.Name] = j.ID
+		}
+	}
+	var result []client.Job
+	for _, j := range jobs {
+		if latest[j.Name] == j.ID {
+			result = append(result, j)
+		}
+	}
+	return result
+}
+
+// selectPipelineJob returns the job named name, or without a name the
+// first failed job, or the last job if none failed.
+func selectPipelineJob(jobs []client.Job, name string) (client.Job, error) {
+	if name != "" {
+		for _, j := range jobs {
+			if j.Name == name {
+				return j, nil
+			}
+		}
+		names := make([]string, len(jobs))
+		for i, j := range jobs {
+			names[i] = j.Name
+		}
+		return client.Job{}, fmt.Errorf("no job %q in the pipeline (jobs: %v)", name, names)
+	}
+	for _, j := range jobs {
+		if j.Status == "failed" {
+			return j, nil
+		}
+	}
+	return jobs[len(jobs)-1], nil
+}
+
+// jobLogControl matches the ANSI escapes and the collapsible section
+// markers of GitLab job logs.
+var jobLogControl = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]|section_(start|end):[0-9]+:[^\r\n]*\r`)
+
+// plainLogWriter writes a GitLab job log without its control sequences.
+// Sequences are matched line by line, so no sequence is split across
+// writes.
+type plainLogWriter struct {
+	w       *os.File
+	pending []byte
+}
+
+func (p *plainLogWriter) Write(b []byte) (int, error) {
+	p.pending = append(p.pending, b...)
+	end := len(p.pending)
+	for end > 0 && p.pending[end-1] != '\n' {
+		end--
+	}
+	if end == 0 {
+		return len(b), nil
+	}
+	if _, err := p.w.Write(jobLogControl.ReplaceAll(p.pending[:end], nil)); err != nil {
+		return 0, err
+	}
+	p.pending = append(p.pending[:0], p.pending[end:]...)
+	return len(b), nil
+}
+
+// Flush writes what is left after the last newline.
+func (p *plainLogWriter) Flush() error {
+	_, err := p.w.Write(jobLogControl.ReplaceAll(p.pending, nil))
+	p.pending = nil
+	return err
+}
+
+func validatePipelineOutput() error {
+	if pipelineOutput != "text" && pipelineOutput != "json" {
+		return fmt.Errorf("invalid --output %q: expected text or json", pipelineOutput)
+	}
+	return nil
+}
+
+func init() {
+	pipelineListCmd.Flags().StringVarP(&pipelineOutput, "output", "o", "text", "Output format: text or json")
+	pipelineLogsCmd.Flags().StringVar(&pipelineProject, "project", "", "Project of the pipeline (default: detected from the git remote)")
+	pipelineLogsCmd.Flags().StringVar(&pipelineJob, "job", "", "Name of the job whose log to print (e.g. deploy)")
+	pipelineCmd.AddCommand(pipelineListCmd, pipelineLogsCmd)
+	rootCmd.AddCommand(pipelineCmd)
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+)
+
+var pullPlaceholders string
+var pullPlaceholderOrigin string
+
+// recordHeavyFiles saves the files --strip-heavy-files left out of the
+// archive just uploaded on the server, so pull --with-placeholders can stand
+// in for them.
+func recordHeavyFiles(ctx context.Context, slug string, plan *filesArchivePlan) {
+	if len(plan.Heavy) == 0 {
+		return
+	}
+	if encryptKeyFile != "" {
+		// The server only sees the encrypted archive; keep the names private too.
+		fmt.Fprintln(os.Stderr, "Heavy files manifest not recorded for encrypted uploads.")
+		return
+	}
+	if err := requireHeavyFilesManifest(); err != nil {
+		fmt.Fprintf(os.Stderr, "Heavy files manifest not recorded: %v\n", err)
+		return
+	}
+
+	files := make([]client.HeavyFile, 0, len(plan.Heavy))
+	for _, rel := range plan.Heavy {
+		var size int64
+		if info, err := os.Stat(filepath.Join(plan.Dir, filepath.FromSlash(rel))); err == nil {
+			size = info.Size()
+		}
+		files = append(files, client.HeavyFile{Path: rel, SizeBytes: size})
+	}
+	if err := apiClient.PutHeavyFilesManifest(ctx, slug, files); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record heavy files manifest: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d heavy files left out; pull files --with-placeholders stands in for them.\n", len(files))
+}
+
+func checkPlaceholderFlags() error {
+	switch pullPlaceholders {
+	case "", "empty":
+		if pullPlaceholderOrigin != "" {
+			return fmt.Errorf("--origin requires --with-placeholders=stage-file-proxy")
+		}
+	case "stage-file-proxy":
+		if pullPlaceholderOrigin == "" {
+			return fmt.Errorf("--with-placeholders=stage-file-proxy requires --origin, e.g. --origin https://www.example.com")
+		}
+	default:
+		return fmt.Errorf("invalid --with-placeholders %q: expected empty or stage-file-proxy", pullPlaceholders)
+	}
+	return nil
+}
+
+// applyPlaceholders stands in locally for the heavy files left out of the
+// base files archive of slug, as chosen with --with-placeholders.
+func applyPlaceholders(ctx context.Context, slug string) error {
+	if err := requireHeavyFilesManifest(); err != nil {
+		return err
+	}
+	manifest, err := apiClient.GetHeavyFilesManifest(ctx, slug)
+	if errors.Is(err, client.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "No heavy files were left out of the base files of %q; no placeholders needed.\n", slug)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if pullPlaceholders == "stage-file-proxy" {
+		printStageFileProxyConfig(len(manifest.Files))
+		return nil
+	}
+	return createPlaceholders(manifest.Files)
+}
+
+// createPlaceholders creates a zero-byte file in the local files directory
+// for each heavy file that doesn't exist there.
+func createPlaceholders(files []client.HeavyFile) error {
+	if err := ensureDdevRunning(); err != nil {
+		return err
+	}
+	paths, err := getDrupalPaths()
+	if err != nil {
+		return fmt.Errorf("could not detect files directory: %w", err)
+	}
+
+	created := 0
+	for _, f := range files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			fmt.Fprintf(os.Stderr, "Skipping %s: outside the files directory\n", f.Path)
+			continue
+		}
+		target := filepath.Join(paths.Files, filepath.FromSlash(f.Path))
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("cannot create directory: %w", err)
+		}
+		p, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("cannot create placeholder: %w", err)
+		}
+		p.Close()
+		created++
+	}
+	fmt.Fprintf(os.Stderr, "Created %d empty placeholders in %s (%d heavy files, %d already present).\n",
+		created, paths.Files, len(files), len(files)-created)
+	return nil
+}
+
+// printStageFileProxyConfig prints the local settings that make Stage File
+// Proxy fetch missing files from --origin.
+func printStageFileProxyConfig(heavy int) {
+	fmt.Fprintf(os.Stderr, "%d heavy files were left out of the base files. To fetch missing files from %s\n", heavy, pullPlaceholderOrigin)
+	fmt.Fprintln(os.Stderr, "on first request, install Stage File Proxy:")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "  ddev composer require drupal/stage_file_proxy")
+	fmt.Fprintln(os.Stderr, "  ddev drush en stage_file_proxy")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "and add to settings.local.php:")
+	fmt.Fprintln(os.Stderr)
+	fmt.Printf("$config['stage_file_proxy.settings']['origin'] = %s;\n", phpString(pullPlaceholderOrigin))
+	fmt.Println("$config['stage_file_proxy.settings']['hotlink'] = FALSE;")
+}
+
+// phpString quotes s as a single-quoted PHP string.
+func phpString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(s) + "'"
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var planOutput string
+
+// planSymbols mark the changes of a plan by action.
+var planSymbols = map[string]string{
+	client.PlanCreate:  "+",
+	client.PlanDestroy: "-",
+	client.PlanReplace: "-/+",
+	client.PlanUpdate:  "~",
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan [PROJECT/PREVIEW-NAME]",
+	Short: "Show what the next rebuild changes in a preview's containers",
+	Long: `Compare preview.yml at the HEAD commit with the one the preview was last
+deployed with, and show what its next rebuild would do about each
+difference, without changing anything:
+
+  +    a container is started, e.g. a new solr service
+  -    a container is removed
+  -/+  a container is recreated from another image, e.g. a PHP version bump
+  ~    a container or setting is updated in place
+
+Some settings (files, sites, sanitize, deploy.new) only apply to new
+previews; the plan says so. 'preview apply' rebuilds the preview to apply
+the plan.
+
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview plan
+  preview plan drupal-test/mr-5 --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if planOutput != "text" && planOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", planOutput)
+		}
+		project, previewName, sha, plan, err := planHead(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		if planOutput == "json" {
+			out, err := json.MarshalIndent(plan, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+		printPlan(project, previewName, sha, plan)
+		if len(plan.Changes) > 0 {
+			fmt.Println("Run 'preview apply' to rebuild the preview with them.")
+		}
+		return nil
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [PROJECT/PREVIEW-NAME]",
+	Short: "Rebuild a preview to apply the preview.yml changes of HEAD",
+	Long: `Show the plan of 'preview plan' and, once confirmed, rebuild the preview at
+the HEAD commit to apply it. The rebuild deploys the branch from GitLab,
+so HEAD must be pushed.
+
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview apply
+  preview apply drupal-test/mr-5 --yes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, previewName, sha, plan, err := planHead(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		printPlan(project, previewName, sha, plan)
+		if len(plan.Changes) == 0 {
+			return nil
+		}
+		if !isPushed() {
+			return fmt.Errorf("HEAD (%s) isn't pushed: the rebuild deploys the branch from GitLab, push it first", shortSHA(sha))
+		}
+
+		ok, err := confirm(fmt.Sprintf("Rebuild %s/%s at %s to apply these changes?", project, previewName, shortSHA(sha)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+
+		result, err := apiClient.RebuildIfChanged(cmd.Context(), project, previewName, sha)
+		if err != nil {
+			return err
+		}
+		if result.UpToDate {
+			fmt.Printf("%s/%s is already at %s.\n", project, previewName, shortSHA(sha))
+			return nil
+		}
+		printActionResult(result)
+		if !result.Success {
+			os.Exit(1)
+		}
+		return runPostRebuildHooks(project, previewName, result)
+	},
+}
+
+// planHead plans the preview.yml of the HEAD commit for the preview of
+// args, or that of the current branch. It returns the preview, the HEAD
+// commit and the plan.
+func planHead(ctx context.Context, args []string) (string, string, string, *client.Plan, error) {
+	var project, previewName string
+	var err error
+	if len(args) == 1 {
+		project, previewName, err = parsePreviewName(args[0])
+	} else {
+		project, previewName, err = detectPreview(ctx)
+	}
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	if err := requirePlan(); err != nil {
+		return "", "", "", nil, err
+	}
+
+	sha, err := detectGitCommit()
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	var req client.PlanRequest
+	// Relative to the current directory, like the preview.yml of the
+	// other commands
+	if out, err := exec.Command("git", "show", "HEAD:./preview.yml").Output(); err == nil {
+		req.PreviewYml = string(out)
+	} else {
+		fmt.Fprintln(os.Stderr, "No preview.yml at HEAD; planning the defaults.")
+	}
+
+	plan, err := apiClient.PlanPreview(ctx, project, previewName, req)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	return project, previewName, sha, plan, nil
+}
+
+// printPlan prints the changes of a plan of preview.yml at commit sha.
+func printPlan(project, previewName, sha string, plan *client.Plan) {
+	deployed := "an unknown commit"
+	if plan.CommitSHA != "" {
+		deployed = shortSHA(plan.CommitSHA)
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Printf("No changes: preview.yml at %s matches that of %s/%s, deployed at %s.\n", shortSHA(sha), project, previewName, deployed)
+		return
+	}
+
+	fmt.Printf("preview.yml at %s against %s/%s, deployed at %s:\n\n", shortSHA(sha), project, previewName, deployed)
+	counts := map[string]int{}
+	for _, c := range plan.Changes {
+		counts[c.Action]++
+		fmt.Printf("  %-3s %-22s %s → %s\n", planSymbols[c.Action], c.Key, planValue(c.From), planValue(c.To))
+		fmt.Printf("      %s\n", c.Effect)
+	}
+
+	fmt.Printf("\n%d changes:", len(plan.Changes))
+	sep := " "
+	for _, action := range []string{client.PlanCreate, client.PlanReplace, client.PlanUpdate, client.PlanDestroy} {
+		if counts[action] > 0 {
+			fmt.Printf("%s%d to %s", sep, counts[action], action)
+			sep = ", "
+		}
+	}
+	fmt.Println(".")
+}
+
+// planValue formats a value of a plan change.
+func planValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		return v
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
+
+func init() {
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", "text", "Output format: text or json")
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+}
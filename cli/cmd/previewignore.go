@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// previewIgnoreFile lists, in gitignore syntax, paths left out of the
+// files archive. It is read from the project root, and its patterns are
+// relative to it like those of a .gitignore there.
+const previewIgnoreFile = ".previewignore"
+
+// previewIgnore is a parsed .previewignore.
+type previewIgnore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool // "!pattern" re-includes
+	dirOnly bool // "pattern/" only matches directories
+}
+
+// loadPreviewIgnore reads .previewignore from the project root, returning
+// nil if there is none.
+func loadPreviewIgnore() (*previewIgnore, error) {
+	f, err := os.Open(previewIgnoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ignore, err := parsePreviewIgnore(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", previewIgnoreFile, err)
+	}
+	return ignore, nil
+}
+
+// parsePreviewIgnore parses gitignore syntax: one pattern per line, blank
+// lines and # comments skipped, ! to negate, a trailing / to match only
+// directories, a leading or middle / to anchor to the root, and *, ?,
+// [...] and ** globs.
+func parsePreviewIgnore(r io.Reader) (*previewIgnore, error) {
+	ignore := &previewIgnore{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		raw := strings.TrimRight(scanner.Text(), "\r")
+		line := strings.TrimRight(raw, " \t")
+		// "\ " keeps a trailing space, unlike "\\ ", an escaped backslash
+		// followed by one
+		if len(line) < len(raw) && raw[len(line)] == ' ' && trailingBackslashes(line)%2 == 1 {
+			line += " "
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		expr := "^"
+		if !anchored {
+			expr += "(?:.*/)?"
+		}
+		expr += globRegexp(line) + "$"
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", n, scanner.Text())
+		}
+		p.re = re
+		ignore.patterns = append(ignore.patterns, p)
+	}
+	return ignore, scanner.Err()
+}
+
+// trailingBackslashes returns how many backslashes s ends with.
+func trailingBackslashes(s string) int {
+	return len(s) - len(strings.TrimRight(s, "\\"))
+}
+
+// globRegexp translates a gitignore glob to a regular expression.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') && (i+2 == len(glob) || glob[i+2] == '/'):
+			if i+2 == len(glob) {
+				b.WriteString(".*") // "dir/**": everything inside
+				i++
+			} else {
+				b.WriteString("(?:.*/)?") // "**/": any number of directories
+				i += 2
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// match reports whether the slash-separated path p, relative to the
+// project root, is ignored: the last pattern matching it decides.
+func (ig *previewIgnore) match(p string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range ig.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.re.MatchString(p) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// ignoredPaths returns the entries of the files directory dir ignored by
+// ig, relative to dir. An ignored directory is returned without the
+// entries below it, which are left out with it, as in git.
+func (ig *previewIgnore) ignoredPaths(dir string) ([]string, error) {
+	base := dir
+	if filepath.IsAbs(base) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, base); err == nil {
+				base = rel
+			}
+		}
+	}
+	base = filepath.ToSlash(base)
+
+	var ignored []string
+	err := walkFilesDir(dir, func(rel string, d fs.DirEntry) error {
+		if rel == "." || !ig.match(path.Join(base, rel), d.IsDir()) {
+			return nil
+		}
+		ignored = append(ignored, rel)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return ignored, err
+}
+
+// isIgnoredPath reports whether rel or one of its parent directories is in
+// ignored.
+func isIgnoredPath(ignored map[string]bool, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if ignored[p] {
+			return true
+		}
+	}
+	return false
+}
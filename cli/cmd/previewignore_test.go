@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreviewIgnoreMatch(t *testing.T) {
+	type check struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}
+	tests := []struct {
+		name     string
+		patterns string
+		checks   []check
+	}{
+		{"unanchored name", "*.log", []check{
+			{"a.log", false, true},
+			{"deep/dir/a.log", false, true},
+			{"a.log.txt", false, false},
+		}},
+		{"leading slash anchors", "/a", []check{
+			{"a", false, true},
+			{"a", true, true},
+			{"sub/a", false, false},
+		}},
+		{"middle slash anchors", "a/b", []check{
+			{"a/b", false, true},
+			{"x/a/b", false, false},
+		}},
+		{"leading **", "**/x", []check{
+			{"x", false, true},
+			{"a/x", true, true},
+			{"a/b/x", false, true},
+			{"a/xx", false, false},
+		}},
+		{"trailing **", "x/**", []check{
+			{"x/a", false, true},
+			{"x/a/b", false, true},
+			{"x", true, false},
+			{"y/x/a", false, false},
+		}},
+		{"middle **", "a/**/b", []check{
+			{"a/b", false, true},
+			{"a/x/b", false, true},
+			{"a/x/y/b", false, true},
+			{"a/x/c", false, false},
+			{"z/a/b", false, false},
+		}},
+		{"negation", "*.bin\n!keep.bin", []check{
+			{"drop.bin", false, true},
+			{"keep.bin", false, false},
+			{"dir/keep.bin", false, false},
+		}},
+		{"last pattern wins", "!keep.bin\n*.bin", []check{
+			{"keep.bin", false, true},
+		}},
+		{"dir only", "x/", []check{
+			{"x", true, true},
+			{"sub/x", true, true},
+			{"x", false, false},
+		}},
+		{"character classes", "[!a]b\nc[0-9]", []check{
+			{"xb", false, true},
+			{"ab", false, false},
+			{"c5", false, true},
+			{"cx", false, false},
+		}},
+		{"single character", "?.txt", []check{
+			{"a.txt", false, true},
+			{"ab.txt", false, false},
+			{"/.txt", false, false},
+		}},
+		{"comments and blank lines", "# comment\n\n   \n", []check{
+			{"# comment", false, false},
+			{"comment", false, false},
+		}},
+		{"escaped hash", `\#notes`, []check{
+			{"#notes", false, true},
+		}},
+		{"escaped bang", `\!important`, []check{
+			{"!important", false, true},
+			{"important", false, false},
+		}},
+		{"escaped trailing space", `name\ `, []check{
+			{"name ", false, true},
+			{"name", false, false},
+		}},
+		{"unescaped trailing spaces", "name   ", []check{
+			{"name", false, true},
+			{"name ", false, false},
+		}},
+		{"escaped backslash before trailing space", `foo\\ `, []check{
+			{`foo\`, false, true},
+			{`foo\ `, false, false},
+		}},
+		{"escaped backslash at the end", `foo\\`, []check{
+			{`foo\`, false, true},
+			{`foo\ `, false, false},
+		}},
+		{"CRLF line endings", "*.tmp\r\n!keep.tmp\r\n", []check{
+			{"a.tmp", false, true},
+			{"keep.tmp", false, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ig, err := parsePreviewIgnore(strings.NewReader(tt.patterns))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range tt.checks {
+				if got := ig.match(c.path, c.isDir); got != c.ignored {
+					t.Errorf("match(%q, dir=%v) = %v, want %v", c.path, c.isDir, got, c.ignored)
+				}
+			}
+		})
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := map[string]string{
+		"*.log":   `[^/]*\.log`,
+		"a?c":     `a[^/]c`,
+		"[!a]b":   `[^a]b`,
+		"[ab":     `\[ab`,
+		"**/x":    `(?:.*/)?x`,
+		"x/**":    `x/.*`,
+		"a/**/b":  `a/(?:.*/)?b`,
+		"a**b":    `a[^/]*[^/]*b`,
+		`\*`:      `\*`,
+		`foo\\`:   `foo\\`,
+		"a.b(c)+": `a\.b\(c\)\+`,
+	}
+	for glob, want := range tests {
+		if got := globRegexp(glob); got != want {
+			t.Errorf("globRegexp(%q) = %q, want %q", glob, got, want)
+		}
+	}
+}
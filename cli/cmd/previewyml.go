@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// previewYml holds the settings of preview.yml the CLI reads; the server
+// reads them all, see parse_preview_yml.
+type previewYml struct {
+	PHPVersion string `yaml:"php_version"`
+	// Database is "mysql" or "mariadb", optionally with a version, as
+	// "mariadb:10.6"; MariaDB and MySQLVersion are its legacy forms
+	// "mariadb: 10.6" and "mysql_version: 8.0"
+	Database     string `yaml:"database"`
+	MariaDB      string `yaml:"mariadb"`
+	MySQLVersion string `yaml:"mysql_version"`
+	Services     struct {
+		Redis bool `yaml:"redis"`
+		Solr  bool `yaml:"solr"`
+	} `yaml:"services"`
+	// Files is "base" or "stage-file-proxy"
+	Files string `yaml:"files"`
+	// Sites are the sites of a multisite, Databases the secondary databases
+	Sites     []string      `yaml:"sites"`
+	Databases []string      `yaml:"databases"`
+	Deploy    previewDeploy `yaml:"deploy"`
+}
+
+// previewDeploy holds the deploy scripts of preview.yml by phase. Like
+// "deploy: false", a phase set to false or null has none.
+type previewDeploy struct {
+	New    string `yaml:"new"`
+	Update string `yaml:"update"`
+}
+
+func (d *previewDeploy) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode && value.Value == "false" {
+		*d = previewDeploy{}
+		return nil
+	}
+	type plain previewDeploy
+	if err := value.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	for _, script := range []*string{&d.New, &d.Update} {
+		if *script == "false" {
+			*script = ""
+		}
+	}
+	return nil
+}
+
+// previewYmlWarned is set once a preview.yml that doesn't decode was
+// warned about.
+var previewYmlWarned bool
+
+// loadPreviewYml reads preview.yml in the current directory, the zero
+// previewYml if there is none. Like the server, it skips the settings of
+// the wrong type, with a warning.
+func loadPreviewYml() previewYml {
+	var yml previewYml
+	data, err := os.ReadFile("preview.yml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return yml
+	}
+	if err == nil {
+		err = yaml.Unmarshal(data, &yml)
+	}
+	if err != nil && !previewYmlWarned {
+		previewYmlWarned = true
+		fmt.Fprintf(os.Stderr, "Warning: preview.yml: %v\n", err)
+	}
+	return yml
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// inPreviewYmlDir runs the test in a directory with a preview.yml of content.
+func inPreviewYmlDir(t *testing.T, content string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "preview.yml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLoadPreviewYml(t *testing.T) {
+	inPreviewYmlDir(t, `# preview.yml
+php_version: "8.3"
+database: "mariadb:10.6" # comment
+services:
+  redis: true
+  solr: false
+files: stage-file-proxy
+sites: [default, "site#2"]
+databases:
+  - legacy
+  - "reports"  # comment
+deploy:
+  new: "scripts/new.sh # not a comment"
+  update: false
+`)
+	yml := loadPreviewYml()
+	if yml.PHPVersion != "8.3" || yml.Database != "mariadb:10.6" || !yml.Services.Redis || yml.Services.Solr || yml.Files != "stage-file-proxy" {
+		t.Fatalf("got %+v", yml)
+	}
+	if !reflect.DeepEqual(yml.Sites, []string{"default", "site#2"}) || !reflect.DeepEqual(yml.Databases, []string{"legacy", "reports"}) {
+		t.Fatalf("got sites %q, databases %q", yml.Sites, yml.Databases)
+	}
+	if yml.Deploy.New != "scripts/new.sh # not a comment" || yml.Deploy.Update != "" {
+		t.Fatalf("got deploy %+v", yml.Deploy)
+	}
+}
+
+func TestPreviewYmlDeploy(t *testing.T) {
+	tests := map[string]previewDeploy{
+		"deploy: false\n":                       {},
+		"deploy:\n  new: ~\n  update: up.sh\n":  {Update: "up.sh"},
+		"deploy:\n  new: |\n    drush cim -y\n": {New: "drush cim -y\n"},
+	}
+	for content, want := range tests {
+		inPreviewYmlDir(t, content)
+		if got := loadPreviewYml().Deploy; got != want {
+			t.Errorf("%q: got %+v, want %+v", content, got, want)
+		}
+	}
+}
+
+func TestPreviewYmlDatabase(t *testing.T) {
+	tests := map[string]dbFlavor{
+		"":                        {Type: "mysql", Version: "8.0"},
+		"database: mariadb\n":     {Type: "mariadb"},
+		"mariadb: 10.11\n":        {Type: "mariadb", Version: "10.11"},
+		"mysql_version: 5.7\n":    {Type: "mysql", Version: "5.7"},
+		"database: postgres:16\n": {Type: "mysql", Version: "8.0"},
+	}
+	for content, want := range tests {
+		inPreviewYmlDir(t, content)
+		if got := previewYmlDatabase(); got != want {
+			t.Errorf("%q: got %+v, want %+v", content, got, want)
+		}
+	}
+}
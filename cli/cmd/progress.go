@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// progressFormat is how transfers report their progress on stderr
+// (--progress): text draws bars and spinners, json writes progressLine
+// events for wrappers (IDE plugins, CI log renderers) to render their own.
+var progressFormat string
+
+// progressInterval is the least time between two json events of a
+// transfer; the last one is always written.
+const progressInterval = 500 * time.Millisecond
+
+// progressLine is a json progress event. Other messages stay text lines,
+// so readers should skip lines that aren't JSON objects.
+type progressLine struct {
+	Event   string  `json:"event"` // always "progress"
+	Phase   string  `json:"phase"` // buffer, upload or download
+	Kind    string  `json:"kind"`
+	Bytes   int64   `json:"bytes"`
+	Total   int64   `json:"total,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	ETA     int64   `json:"eta_seconds,omitempty"`
+	Done    bool    `json:"done,omitempty"`
+}
+
+var (
+	progressMu   sync.Mutex
+	progressLast = map[string]time.Time{}
+)
+
+// jsonProgress reports whether --progress json was given.
+func jsonProgress() bool {
+	return progressFormat == "json"
+}
+
+// emitProgress writes e as a json line on stderr, at most every
+// progressInterval for each phase and kind of transfer.
+func emitProgress(e client.ProgressEvent) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	key := e.Phase + "/" + e.Kind
+	if !e.Done && time.Since(progressLast[key]) < progressInterval {
+		return
+	}
+	progressLast[key] = time.Now()
+
+	data, _ := json.Marshal(progressLine{
+		Event:   "progress",
+		Phase:   e.Phase,
+		Kind:    e.Kind,
+		Bytes:   e.Bytes,
+		Total:   e.Total,
+		Percent: math.Round(e.Percent*10) / 10,
+		ETA:     int64(e.ETA.Seconds()),
+		Done:    e.Done,
+	})
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+// downloadProgress is a writer emitting the download events of kind for
+// the bytes written to it.
+type downloadProgress struct {
+	kind  string
+	start time.Time
+	mu    sync.Mutex
+	bytes int64
+}
+
+func newDownloadProgress(kind string) *downloadProgress {
+	return &downloadProgress{kind: kind, start: time.Now()}
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.bytes += int64(len(b))
+	n := p.bytes
+	p.mu.Unlock()
+	emitProgress(client.NewProgressEvent(client.PhaseDownload, p.kind, n, 0, p.start))
+	return len(b), nil
+}
+
+// done emits the last event of the download.
+func (p *downloadProgress) done() {
+	p.mu.Lock()
+	e := client.NewProgressEvent(client.PhaseDownload, p.kind, p.bytes, 0, p.start)
+	p.mu.Unlock()
+	e.Done = true
+	emitProgress(e)
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var projectSettingsSet []string
+var projectSettingsOutput string
+var autoSleepAfter time.Duration
+var autoSleepOff bool
+var autoSleepInherit bool
+var autoSleepGlobal bool
+var autoSleepOutput string
+
+var projectCmd = &cobra.Command{
+	Use:   "project",
+	Short: "Manage a project's server-side configuration",
+}
+
+var projectSettingsCmd = &cobra.Command{
+	Use:   "settings [PROJECT]",
+	Short: "View or edit a project's server-side settings",
+	Long: `View or edit the server-side settings of a project, such as the auto-stop
+timeout and the resource limits of its previews.
+
+With --set, changes settings before showing them; an empty value resets a
+setting to its default. Changing settings requires the admin role, and
+resource limits apply to previews deployed or rebuilt afterwards.
+
+If no project is given, it is detected from the git remote in the current directory.
+
+Examples:
+  preview project settings drupal-test
+  preview project settings drupal-test --output json
+  preview project settings drupal-test --set memory_limit=2g --set cpu_limit=1.5
+  preview project settings drupal-test --set auto_stop_minutes=`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if projectSettingsOutput != "table" && projectSettingsOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected table or json", projectSettingsOutput)
+		}
+		changes := make(map[string]string)
+		for _, kv := range projectSettingsSet {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("invalid --set %q: expected key=value", kv)
+			}
+			changes[key] = value
+		}
+
+		project, err := resolveProjectArg(args)
+		if err != nil {
+			return err
+		}
+
+		var settings *client.ProjectSettings
+		if len(changes) > 0 {
+			settings, err = apiClient.UpdateProjectSettings(cmd.Context(), project, changes)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "Updated %d settings of %q.\n", len(changes), project)
+			}
+		} else {
+			settings, err = apiClient.GetProjectSettings(cmd.Context(), project)
+		}
+		if err != nil {
+			return err
+		}
+
+		if projectSettingsOutput == "json" {
+			data, err := json.MarshalIndent(settings.Settings, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		printProjectSettings(settings)
+		return nil
+	},
+}
+
+var projectAutoSleepCmd = &cobra.Command{
+	Use:     "auto-sleep [PROJECT]",
+	Aliases: []string{"auto-stop"},
+	Short:   "View or set when idle previews of a project are stopped",
+	Long: `View or set the auto-sleep policy of a project: previews neither visited
+nor deployed for a while are stopped to free host resources, and start
+again when visited.
+
+A project follows the global policy unless it has its own: --after sets
+the idle time after which its previews are stopped, --off never stops
+them and --inherit returns to the global policy. With --global, the
+global policy is viewed or set instead. Changes require the admin role.
+
+If no project is given, it is detected from the git remote in the current directory.
+
+Examples:
+  preview project auto-sleep drupal-test
+  preview project auto-sleep drupal-test --after 4h
+  preview project auto-sleep drupal-test --off
+  preview project auto-sleep drupal-test --inherit
+  preview project auto-sleep --global --after 1h`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if autoSleepOutput != "text" && autoSleepOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", autoSleepOutput)
+		}
+		changes := 0
+		for _, set := range []bool{cmd.Flags().Changed("after"), autoSleepOff, autoSleepInherit} {
+			if set {
+				changes++
+			}
+		}
+		if changes > 1 {
+			return fmt.Errorf("--after, --off and --inherit can't be used together")
+		}
+		if cmd.Flags().Changed("after") && autoSleepAfter < time.Minute {
+			return fmt.Errorf("invalid --after %s: expected at least 1m", autoSleepAfter)
+		}
+		if autoSleepGlobal && autoSleepInherit {
+			return fmt.Errorf("--inherit only applies to a project")
+		}
+
+		project := ""
+		if autoSleepGlobal {
+			if len(args) == 1 {
+				return fmt.Errorf("--global takes no PROJECT")
+			}
+		} else {
+			var err error
+			if project, err = resolveProjectArg(args); err != nil {
+				return err
+			}
+		}
+
+		ctx := cmd.Context()
+		if changes == 1 {
+			policy := client.AutoStopPolicy{Override: !autoSleepInherit, Enabled: !autoSleepOff && !autoSleepInherit}
+			if cmd.Flags().Changed("after") {
+				policy.Minutes = int((autoSleepAfter + time.Minute - 1) / time.Minute)
+			}
+			if err := apiClient.SetAutoStopPolicy(ctx, project, policy); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "Auto-sleep policy updated.")
+		}
+
+		global, err := apiClient.GetAutoStopPolicy(ctx, "")
+		if err != nil {
+			return err
+		}
+		policy := global
+		if project != "" {
+			if policy, err = apiClient.GetAutoStopPolicy(ctx, project); err != nil {
+				return err
+			}
+			if policy.Override && policy.Minutes == 0 {
+				policy.Minutes = global.Minutes
+			}
+		}
+
+		if autoSleepOutput == "json" {
+			data, err := json.MarshalIndent(policy, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		switch {
+		case project == "":
+			fmt.Printf("Global policy: %s.\n", describeAutoStop(global))
+		case policy.Override:
+			fmt.Printf("%s: %s (project policy).\n", project, describeAutoStop(policy))
+		default:
+			fmt.Printf("%s: %s (global policy).\n", project, describeAutoStop(global))
+		}
+		return nil
+	},
+}
+
+func describeAutoStop(policy *client.AutoStopPolicy) string {
+	if !policy.Enabled {
+		return "idle previews are never stopped"
+	}
+	return fmt.Sprintf("previews idle for %s are stopped", formatMinutes(policy.Minutes))
+}
+
+// formatMinutes formats minutes as a duration like "4h" or "1h30m".
+func formatMinutes(minutes int) string {
+	s := strings.TrimSuffix((time.Duration(minutes) * time.Minute).String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func printProjectSettings(settings *client.ProjectSettings) {
+	names := make([]string, 0, len(settings.Settings))
+	for name := range settings.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tDESCRIPTION")
+	for _, name := range names {
+		value := "(default)"
+		if v := settings.Settings[name]; v != nil {
+			value = fmt.Sprint(v)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, value, settings.Descriptions[name])
+	}
+	w.Flush()
+}
+
+func init() {
+	projectSettingsCmd.Flags().StringArrayVar(&projectSettingsSet, "set", nil, "Change a setting, as key=value (repeatable)")
+	projectSettingsCmd.Flags().StringVarP(&projectSettingsOutput, "output", "o", "table", "Output format: table or json")
+	projectAutoSleepCmd.Flags().DurationVar(&autoSleepAfter, "after", 0, "Stop previews idle for longer than this, e.g. 4h")
+	projectAutoSleepCmd.Flags().BoolVar(&autoSleepOff, "off", false, "Never stop idle previews")
+	projectAutoSleepCmd.Flags().BoolVar(&autoSleepInherit, "inherit", false, "Follow the global policy again")
+	projectAutoSleepCmd.Flags().BoolVar(&autoSleepGlobal, "global", false, "View or set the global policy instead of a project's")
+	projectAutoSleepCmd.Flags().StringVarP(&autoSleepOutput, "output", "o", "text", "Output format: text or json")
+	projectCmd.AddCommand(projectSettingsCmd, projectAutoSleepCmd)
+	rootCmd.AddCommand(projectCmd)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// maxProjectSuggestions is how many close matches of an unknown project
+// are suggested.
+const maxProjectSuggestions = 5
+
+// verifyProjectSlug checks that the server has the project slug detected
+// from the git remote, which a renamed repository no longer matches. An
+// unknown slug fails with the closest projects of the user, or in a
+// terminal asks which of them to use instead. It returns the slug to use.
+// Servers that can't list projects aren't checked.
+func verifyProjectSlug(ctx context.Context, slug string) (string, error) {
+	if serverCaps != nil && !serverCaps.ProjectList {
+		return slug, nil
+	}
+	projects, err := apiClient.ListProjects(ctx)
+	if err != nil {
+		// Not worth failing the command over: the server has the last word
+		return slug, nil
+	}
+	for _, p := range projects {
+		if p.Slug == slug {
+			return slug, nil
+		}
+	}
+	if len(projects) == 0 {
+		return "", fmt.Errorf("project %q not found on the server, which has no projects you can access", slug)
+	}
+
+	suggestions := closeProjects(slug, projects)
+	if !isInteractive() {
+		if len(suggestions) > 0 {
+			return "", fmt.Errorf("project %q not found on the server, did you mean %s?\nWas the repository renamed? Check 'git remote get-url origin'", slug, strings.Join(suggestions, " or "))
+		}
+		return "", fmt.Errorf("project %q not found on the server\nYour projects: %s", slug, strings.Join(projectSlugs(projects), ", "))
+	}
+
+	choices := suggestions
+	if len(choices) == 0 {
+		choices = projectSlugs(projects)
+		fmt.Fprintf(os.Stderr, "Project %q not found on the server. Your projects:\n", slug)
+	} else {
+		fmt.Fprintf(os.Stderr, "Project %q not found on the server. Did you mean:\n", slug)
+	}
+	for i, name := range choices {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, name)
+	}
+	fmt.Fprint(os.Stderr, "\nProject number or name (empty to abort)> ")
+
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil && input == "" {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("aborted: project %q not found on the server", slug)
+	}
+	if idx, err := strconv.Atoi(input); err == nil {
+		if idx < 1 || idx > len(choices) {
+			return "", fmt.Errorf("invalid selection: %d", idx)
+		}
+		input = choices[idx-1]
+	}
+	for _, p := range projects {
+		if p.Slug == input {
+			fmt.Fprintf(os.Stderr, "Using project: %s\n", input)
+			return input, nil
+		}
+	}
+	return "", fmt.Errorf("invalid selection: %q", input)
+}
+
+// closeProjects returns the slugs of projects close to slug, closest first:
+// those containing it or contained in it, and those a few edits away.
+func closeProjects(slug string, projects []client.Project) []string {
+	type match struct {
+		slug     string
+		distance int
+	}
+	maxDistance := len(slug) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	var matches []match
+	for _, p := range projects {
+		d := editDistance(slug, p.Slug)
+		if strings.Contains(p.Slug, slug) || strings.Contains(slug, p.Slug) || d <= maxDistance {
+			matches = append(matches, match{p.Slug, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].slug < matches[j].slug
+	})
+	if len(matches) > maxProjectSuggestions {
+		matches = matches[:maxProjectSuggestions]
+	}
+	slugs := make([]string, len(matches))
+	for i, m := range matches {
+		slugs[i] = m.slug
+	}
+	return slugs
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func projectSlugs(projects []client.Project) []string {
+	slugs := make([]string, len(projects))
+	for i, p := range projects {
+		slugs[i] = p.Slug
+	}
+	sort.Strings(slugs)
+	return slugs
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// autoYes answers yes to every confirmation prompt (--yes).
+var autoYes bool
+
+// noInput makes commands fail instead of prompting (--no-input), so CI runs
+// don't block waiting on stdin.
+var noInput bool
+
+// errNoInput returns the error of a command that would prompt under
+// --no-input; hint says how to avoid the prompt.
+func errNoInput(hint string) error {
+	return fmt.Errorf("input required but --no-input is set: %s", hint)
+}
+
+// isInteractive reports whether the user can be prompted: stdin and stdout
+// are terminals and --no-input is not set.
+func isInteractive() bool {
+	return !noInput && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// confirm asks a yes/no question, defaulting to yes when the user just
+// presses enter. Unless --yes is set, it fails under --no-input or when
+// stdin is not a terminal, so scripts don't confirm by accident, and
+// closing stdin answers no.
+func confirm(prompt string) (bool, error) {
+	return askYesNo(prompt, true)
+}
+
+// confirmNo asks a yes/no question like confirm, but defaulting to no, for
+// questions a reflexive enter shouldn't answer.
+func confirmNo(prompt string) (bool, error) {
+	return askYesNo(prompt, false)
+}
+
+func askYesNo(prompt string, defaultYes bool) (bool, error) {
+	if autoYes {
+		return true, nil
+	}
+	if noInput {
+		return false, errNoInput("pass --yes to confirm")
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("can't ask %q without a terminal: pass --yes to confirm", prompt)
+	}
+	choices := "[y/N]"
+	if defaultYes {
+		choices = "[Y/n]"
+	}
+	fmt.Fprintf(os.Stderr, "%s %s ", prompt, choices)
+	scanner := bufio.NewScanner(os.Stdin)
+	if scanner.Scan() {
+		switch strings.TrimSpace(strings.ToLower(scanner.Text())) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		return defaultYes, nil
+	}
+	fmt.Fprintln(os.Stderr)
+	return false, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&autoYes, "yes", "y", false, "Skip confirmation prompts")
+	rootCmd.PersistentFlags().BoolVar(&noInput, "no-input", false, "Fail instead of prompting for input")
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestConfirmWithoutTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("y\n")
+	w.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+
+	if ok, err := confirm("Delete everything?"); ok || err == nil {
+		t.Fatalf("expected piped stdin to be refused, got %v, %v", ok, err)
+	}
+	autoYes = true
+	defer func() { autoYes = false }()
+	if ok, err := confirm("Delete everything?"); !ok || err != nil {
+		t.Fatalf("expected --yes to confirm, got %v, %v", ok, err)
+	}
+}
@@ -1,13 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
 )
 
 var pullOutputFile string
+var pullBase bool
+var pullTables []string
+var pullDecompress bool
+var pullExtractDir string
 
 var pullCmd = &cobra.Command{
 	Use:   "pull",
@@ -16,30 +24,38 @@ var pullCmd = &cobra.Command{
 
 If PROJECT/PREVIEW-NAME is given, downloads from that specific preview.
 If no argument is given, auto-detects the project from git remote and
-finds a preview matching the current git branch.`,
+finds a preview matching the current git branch.
+
+With --base, downloads the project's base database or files instead; the
+argument is then PROJECT. Add --encrypt-key-file to download and decrypt a
+base pushed with the same key.`,
 }
 
 // resolvePullTarget resolves the project and preview name from args or auto-detection.
-func resolvePullTarget(args []string) (project, previewName string, err error) {
+func resolvePullTarget(ctx context.Context, args []string) (project, previewName string, err error) {
 	if len(args) == 1 {
 		return parsePreviewName(args[0])
 	}
 
-	// Auto-detect project from git remote
-	project, err = detectProjectSlug()
+	// Auto-detect branch
+	branch, err := detectGitBranch()
 	if err != nil {
 		return "", "", err
 	}
+	if project, previewName, ok := boundPreview(branch); ok {
+		fmt.Fprintf(os.Stderr, "Bound preview: %s/%s\n", project, previewName)
+		return project, previewName, nil
+	}
 
-	// Auto-detect branch
-	branch, err := detectGitBranch()
+	// Auto-detect project from git remote
+	project, err = detectProjectSlug()
 	if err != nil {
 		return "", "", err
 	}
 	fmt.Fprintf(os.Stderr, "Detected branch: %s\n", branch)
 
 	// Find preview matching this branch
-	preview, err := findPreviewByBranch(project, branch)
+	preview, err := findPreviewByBranch(ctx, project, branch)
 	if err != nil {
 		return "", "", err
 	}
@@ -54,34 +70,30 @@ var pullDBCmd = &cobra.Command{
 	Long: `Download a database dump from a preview environment.
 
 If PROJECT/PREVIEW-NAME is given, downloads from that specific preview.
-If no argument is given, auto-detects from git remote and current branch.`,
-	Args: cobra.MaximumNArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		project, previewName, err := resolvePullTarget(args)
-		if err != nil {
-			return err
-		}
-
-		output := pullOutputFile
-		if output == "" {
-			output = fmt.Sprintf("%s-%s.sql.gz", project, previewName)
-		}
+If no argument is given, auto-detects from git remote and current branch.
 
-		fmt.Fprintf(os.Stderr, "Downloading database from %s/%s to %s...\n", project, previewName, output)
+--tables dumps only the tables listed, to inspect some content without
+downloading the whole database. The dump can be imported on top of an
+existing database: it replaces those tables only.
 
-		f, err := os.Create(output)
-		if err != nil {
-			return fmt.Errorf("cannot create file: %w", err)
-		}
-		defer f.Close()
+--decompress saves the dump as plain SQL (PROJECT-PREVIEW.sql), gunzipped
+as it downloads.
 
-		if err := apiClient.DownloadStream(project, previewName, "db", f); err != nil {
-			os.Remove(output)
-			return err
+Examples:
+  preview pull db drupal-test/mr-5
+  preview pull db drupal-test/mr-5 --tables node,node_field_data,users
+  preview pull db drupal-test/mr-5 --decompress`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(pullTables) > 0 {
+			if pullBase {
+				return fmt.Errorf("--tables can't be used with --base: base databases are stored as a single dump")
+			}
+			if err := requireTableDumps(); err != nil {
+				return err
+			}
 		}
-
-		fmt.Fprintf(os.Stderr, "Saved to %s\n", output)
-		return nil
+		return runPull(cmd.Context(), args, "db")
 	},
 }
 
@@ -91,40 +103,265 @@ var pullFilesCmd = &cobra.Command{
 	Long: `Download a files archive from a preview environment.
 
 If PROJECT/PREVIEW-NAME is given, downloads from that specific preview.
-If no argument is given, auto-detects from git remote and current branch.`,
+If no argument is given, auto-detects from git remote and current branch.
+
+--extract DIR extracts the files into DIR as they download, instead of
+saving the archive; existing files are overwritten. Entries that would
+land outside DIR (absolute paths, "..", paths through symlinks) and links
+are skipped.
+
+When the base files were pushed with --strip-heavy-files, --with-placeholders
+creates a zero-byte file in the local files directory for each file left
+out, so the local site doesn't 404 on them. --with-placeholders=stage-file-proxy
+--origin URL prints Stage File Proxy settings to fetch them from production
+instead.
+
+Examples:
+  preview pull files drupal-test/mr-5
+  preview pull files drupal-test/mr-5 --extract web/sites/default/files
+  preview pull files --base drupal-test --with-placeholders
+  preview pull files --base drupal-test --with-placeholders=stage-file-proxy --origin https://www.example.com`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		project, previewName, err := resolvePullTarget(args)
-		if err != nil {
+		return runPull(cmd.Context(), args, "files")
+	},
+}
+
+// runPull downloads the db or files of a preview or, with --base, the base
+// of a project, decrypting it with --encrypt-key-file.
+func runPull(ctx context.Context, args []string, kind string) error {
+	key, err := loadEncryptionKey()
+	if err != nil {
+		return err
+	}
+	if err := checkPullKey(key); err != nil {
+		return err
+	}
+	if err := checkPlaceholderFlags(); err != nil {
+		return err
+	}
+	if pullExtractDir != "" && pullOutputFile != "" {
+		return fmt.Errorf("--extract and --output can't be used together")
+	}
+
+	slug, previewName, err := resolvePullSource(ctx, args)
+	if err != nil {
+		return err
+	}
+	t := newPullTarget(ctx, slug, previewName, kind, key)
+	t.decompress = pullDecompress
+	if pullDecompress {
+		t.output = strings.TrimSuffix(t.output, ".gz")
+	}
+	if pullOutputFile != "" {
+		t.output = pullOutputFile
+	}
+	if pullExtractDir != "" {
+		t.extract = true
+		t.output = pullExtractDir
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloading %s from %s to %s...\n", t.label(), t.source, t.output)
+	var progress io.Writer = io.Discard
+	var events *downloadProgress
+	if jsonProgress() {
+		events = newDownloadProgress(t.kind)
+		progress = events
+	}
+	if err := t.save(progress); err != nil {
+		return err
+	}
+	if events != nil {
+		events.done()
+	}
+	if !t.extract {
+		fmt.Fprintf(os.Stderr, "Saved to %s\n", t.output)
+	}
+
+	if pullPlaceholders != "" {
+		if err := applyPlaceholders(ctx, slug); err != nil {
 			return err
 		}
+	}
+	return t.runPostPullHooks()
+}
 
-		output := pullOutputFile
-		if output == "" {
-			output = fmt.Sprintf("%s-%s-files.tar.gz", project, previewName)
-		}
+// runPostPullHooks runs the post-pull hooks of the download.
+func (t *pullTarget) runPostPullHooks() error {
+	return runHooks("post-pull", "PREVIEW_PROJECT="+t.slug, "PREVIEW_NAME="+t.previewName, "PREVIEW_KIND="+t.kind, "PREVIEW_FILE="+t.output)
+}
+
+func checkPullKey(key []byte) error {
+	if key != nil && !pullBase {
+		return fmt.Errorf("--encrypt-key-file requires --base: preview dumps are generated by the server and never encrypted")
+	}
+	return nil
+}
+
+// resolvePullSource returns the project and preview to pull from args, or
+// with --base the project and an empty preview name.
+func resolvePullSource(ctx context.Context, args []string) (slug, previewName string, err error) {
+	if pullBase {
+		slug, err = resolveProjectArg(args)
+		return slug, "", err
+	}
+	return resolvePullTarget(ctx, args)
+}
 
-		fmt.Fprintf(os.Stderr, "Downloading files from %s/%s to %s...\n", project, previewName, output)
+// pullTarget is a db or files download and the local file it is saved to.
+type pullTarget struct {
+	kind        string
+	slug        string
+	previewName string // "" for the base
+	source      string
+	output      string
+	key         []byte
+	download    func(w io.Writer) error
+	// decompress saves the download gunzipped; extract extracts the tar.gz
+	// into the output directory instead of saving it.
+	decompress bool
+	extract    bool
+}
 
-		f, err := os.Create(output)
-		if err != nil {
-			return fmt.Errorf("cannot create file: %w", err)
+// newPullTarget returns the download of kind from a preview or, if
+// previewName is empty, from the base of slug.
+func newPullTarget(ctx context.Context, slug, previewName, kind string, key []byte) *pullTarget {
+	t := &pullTarget{kind: kind, slug: slug, previewName: previewName, key: key}
+	if previewName == "" {
+		remoteKind := kind
+		if key != nil {
+			remoteKind = client.EncryptedKind(kind)
 		}
-		defer f.Close()
+		t.source = "base of " + slug
+		t.output = fmt.Sprintf("%s-base.sql.gz", slug)
+		if kind == "files" {
+			t.output = fmt.Sprintf("%s-base-files.tar.gz", slug)
+		}
+		t.download = func(w io.Writer) error {
+			return apiClient.DownloadBaseFile(ctx, slug, remoteKind, w)
+		}
+		return t
+	}
 
-		if err := apiClient.DownloadStream(project, previewName, "files", f); err != nil {
-			os.Remove(output)
-			return err
+	t.source = slug + "/" + previewName
+	t.output = fmt.Sprintf("%s-%s.sql.gz", slug, previewName)
+	if kind == "files" {
+		t.output = fmt.Sprintf("%s-%s-files.tar.gz", slug, previewName)
+	}
+	t.download = func(w io.Writer) error {
+		return apiClient.DownloadStream(ctx, slug, previewName, kind, w)
+	}
+	if kind == "db" && len(pullTables) > 0 {
+		t.output = fmt.Sprintf("%s-%s-tables.sql.gz", slug, previewName)
+		t.download = func(w io.Writer) error {
+			return apiClient.DownloadTables(ctx, slug, previewName, pullTables, w)
 		}
+	}
+	return t
+}
 
-		fmt.Fprintf(os.Stderr, "Saved to %s\n", output)
-		return nil
-	},
+func (t *pullTarget) label() string {
+	if t.kind == "files" {
+		return "files"
+	}
+	if len(pullTables) > 0 {
+		return "tables " + strings.Join(pullTables, ", ")
+	}
+	return "database"
+}
+
+// save downloads into the output file, also writing what is downloaded to
+// progress. The file is removed if the download fails.
+func (t *pullTarget) save(progress io.Writer) error {
+	if t.extract {
+		return t.saveExtracted(progress)
+	}
+	f, err := os.Create(t.output)
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+	defer f.Close()
+
+	if t.decompress {
+		decoder := newDecodingWriter(gunzipTo(f))
+		err = decoder.Close(t.fetch(io.MultiWriter(decoder, progress)))
+	} else {
+		err = t.fetch(io.MultiWriter(f, progress))
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(t.output)
+		return err
+	}
+	return f.Close()
+}
+
+// saveExtracted extracts the download into the output directory as it
+// arrives. What was extracted before a failure is left in place.
+func (t *pullTarget) saveExtracted(progress io.Writer) error {
+	if err := os.MkdirAll(t.output, 0755); err != nil {
+		return fmt.Errorf("cannot create directory: %w", err)
+	}
+	var extracted, skipped int
+	decoder := newDecodingWriter(func(r io.Reader) (err error) {
+		extracted, skipped, err = extractTarGz(r, t.output)
+		return err
+	})
+	if err := decoder.Close(t.fetch(io.MultiWriter(decoder, progress))); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Extracted %d files", extracted)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, ", skipped %d", skipped)
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// fetch downloads into w, decrypting with the key if there is one.
+func (t *pullTarget) fetch(w io.Writer) error {
+	if t.key != nil {
+		return downloadDecrypted(w, t.key, t.download)
+	}
+	return t.download(w)
+}
+
+// downloadDecrypted runs download through a pipe, decrypting into w.
+func downloadDecrypted(w io.Writer, key []byte, download func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(download(pw))
+	}()
+	defer pr.Close()
+
+	dec, err := client.NewDecryptReader(pr, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, dec)
+	return err
+}
+
+// resolveProjectArg returns the project from args, or detects it from the
+// git remote.
+func resolveProjectArg(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return detectProjectSlug()
 }
 
 func init() {
 	pullDBCmd.Flags().StringVarP(&pullOutputFile, "output", "o", "", "Output file path")
+	pullDBCmd.Flags().StringSliceVar(&pullTables, "tables", nil, "Only dump these tables (comma-separated)")
+	pullDBCmd.Flags().BoolVar(&pullDecompress, "decompress", false, "Save the dump as plain SQL instead of gzipped")
 	pullFilesCmd.Flags().StringVarP(&pullOutputFile, "output", "o", "", "Output file path")
+	pullFilesCmd.Flags().StringVar(&pullExtractDir, "extract", "", "Extract the files into this directory instead of saving the archive")
+	pullFilesCmd.Flags().StringVar(&pullPlaceholders, "with-placeholders", "", "Stand in locally for heavy files left out at push: empty (zero-byte files) or stage-file-proxy")
+	pullFilesCmd.Flags().Lookup("with-placeholders").NoOptDefVal = "empty"
+	pullFilesCmd.Flags().StringVar(&pullPlaceholderOrigin, "origin", "", "Production URL for --with-placeholders=stage-file-proxy")
+	pullCmd.PersistentFlags().BoolVar(&pullBase, "base", false, "Download the project's base instead of a preview (argument is PROJECT)")
+	pullCmd.PersistentFlags().StringVar(&encryptKeyFile, "encrypt-key-file", "", "Decrypt a base pushed with --encrypt-key-file (requires --base)")
 	pullCmd.AddCommand(pullDBCmd)
 	pullCmd.AddCommand(pullFilesCmd)
 	rootCmd.AddCommand(pullCmd)
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var pullAllDir string
+var pullAllImport bool
+
+var pullAllCmd = &cobra.Command{
+	Use:   "all [PROJECT/PREVIEW-NAME]",
+	Short: "Download the database and files of a preview at once",
+	Long: `Download the database dump and the files archive of a preview
+concurrently, showing the progress of each.
+
+They are saved as PROJECT-PREVIEW.sql.gz and PROJECT-PREVIEW-files.tar.gz
+in --dir (PROJECT-base.sql.gz and PROJECT-base-files.tar.gz with --base).
+With --import, both are then imported into the local ddev project.
+
+If PROJECT/PREVIEW-NAME is given, downloads from that specific preview.
+If no argument is given, auto-detects from git remote and current branch.
+
+Examples:
+  preview pull all drupal-test/mr-5
+  preview pull all drupal-test/mr-5 --import
+  preview pull all --base drupal-test --dir backups/`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := loadEncryptionKey()
+		if err != nil {
+			return err
+		}
+		if err := checkPullKey(key); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		slug, previewName, err := resolvePullSource(ctx, args)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(pullAllDir, 0755); err != nil {
+			return fmt.Errorf("cannot create directory: %w", err)
+		}
+
+		targets := []*pullTarget{
+			newPullTarget(ctx, slug, previewName, "db", key),
+			newPullTarget(ctx, slug, previewName, "files", key),
+		}
+		for _, t := range targets {
+			t.output = filepath.Join(pullAllDir, t.output)
+		}
+		fmt.Fprintf(os.Stderr, "Downloading database and files from %s...\n", targets[0].source)
+
+		if err := saveConcurrently(cancel, targets); err != nil {
+			return err
+		}
+
+		if pullAllImport {
+			if err := importIntoDdev(targets[0].output, targets[1].output); err != nil {
+				return err
+			}
+		}
+		for _, t := range targets {
+			if err := t.runPostPullHooks(); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// atomicByteCounter is a byteCounter that can be read while written.
+type atomicByteCounter struct {
+	n atomic.Int64
+}
+
+func (c *atomicByteCounter) Write(p []byte) (int, error) {
+	c.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// saveConcurrently saves targets in parallel, rendering a progress line for
+// each. The first failure cancels the other downloads.
+func saveConcurrently(cancel context.CancelFunc, targets []*pullTarget) error {
+	counters := make([]*atomicByteCounter, len(targets))
+	errs := make([]error, len(targets))
+	done := make([]atomic.Bool, len(targets))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, t := range targets {
+		counters[i] = &atomicByteCounter{}
+		wg.Add(1)
+		go func(i int, t *pullTarget) {
+			defer wg.Done()
+			var progress io.Writer = counters[i]
+			var events *downloadProgress
+			if jsonProgress() {
+				events = newDownloadProgress(t.kind)
+				progress = io.MultiWriter(counters[i], events)
+			}
+			if errs[i] = t.save(progress); errs[i] != nil {
+				cancel()
+			} else if events != nil {
+				events.done()
+			}
+			done[i].Store(true)
+		}(i, t)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	interactive := term.IsTerminal(int(os.Stderr.Fd())) && !jsonProgress()
+	render := func(first bool) {
+		if !first {
+			fmt.Fprintf(os.Stderr, "\033[%dA", len(targets))
+		}
+		elapsed := time.Since(start).Seconds()
+		for i, t := range targets {
+			n := counters[i].n.Load()
+			status := fmt.Sprintf("%s/s", formatBytesShort(int64(float64(n)/max(elapsed, 0.001))))
+			if done[i].Load() {
+				status = "done"
+				if errs[i] != nil {
+					status = "failed"
+				}
+			}
+			fmt.Fprintf(os.Stderr, "\033[2K  %-8s  %10s  %s\n", t.label(), formatBytesShort(n), status)
+		}
+	}
+
+	if interactive {
+		render(true)
+		ticker := time.NewTicker(200 * time.Millisecond)
+	loop:
+		for {
+			select {
+			case <-ticker.C:
+				render(false)
+			case <-finished:
+				break loop
+			}
+		}
+		ticker.Stop()
+		render(false)
+	} else {
+		<-finished
+	}
+
+	// Report the failure that caused the cancellation, not its victims
+	var failure error
+	for i, t := range targets {
+		switch {
+		case errs[i] == nil:
+			fmt.Fprintf(os.Stderr, "Saved %s to %s (%s)\n", t.label(), t.output, formatBytesShort(counters[i].n.Load()))
+		case failure == nil || errors.Is(failure, context.Canceled) && !errors.Is(errs[i], context.Canceled):
+			failure = fmt.Errorf("%s download failed: %w", t.label(), errs[i])
+		}
+	}
+	return failure
+}
+
+// importIntoDdev replaces the database and files of the local ddev project
+// with the given dump and files archive.
+func importIntoDdev(dbFile, filesArchive string) error {
+	ok, err := confirm("Replace the database and files of the local ddev project?")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Import skipped.")
+		return nil
+	}
+	if err := ensureDdevRunning(); err != nil {
+		return err
+	}
+
+	for _, args := range [][]string{
+		{"import-db", "--file=" + dbFile},
+		{"import-files", "--source=" + filesArchive},
+	} {
+		fmt.Fprintf(os.Stderr, "Running ddev %s...\n", args[0])
+		c := exec.Command("ddev", args...)
+		c.Stdout = os.Stderr
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("ddev %s failed: %w", args[0], err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Imported into ddev.")
+	return nil
+}
+
+func init() {
+	pullAllCmd.Flags().StringVar(&pullAllDir, "dir", ".", "Directory to save the downloads in")
+	pullAllCmd.Flags().BoolVar(&pullAllImport, "import", false, "Import the database and files into the local ddev project")
+	pullCmd.AddCommand(pullAllCmd)
+}
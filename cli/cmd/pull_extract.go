@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/pgzip"
+)
+
+// decodingWriter passes what is written to it to a decoder running in
+// another goroutine, like a gunzip writing a file.
+type decodingWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newDecodingWriter(decode func(r io.Reader) error) *decodingWriter {
+	pr, pw := io.Pipe()
+	d := &decodingWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := decode(pr)
+		if err == nil {
+			// Drain what the decoder leaves, like padding after the gzip
+			// trailer, so writes never block
+			_, err = io.Copy(io.Discard, pr)
+		}
+		// Fails the writes still to come, which aborts the download
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *decodingWriter) Write(p []byte) (int, error) { return d.pw.Write(p) }
+
+// Close ends the input of the decoder, or aborts it with err if not nil,
+// and returns the decoder's error.
+func (d *decodingWriter) Close(err error) error {
+	d.pw.CloseWithError(err)
+	if decodeErr := <-d.done; decodeErr != nil && err == nil {
+		return decodeErr
+	}
+	return err
+}
+
+// gunzipTo returns a decoder writing the gunzipped input to w.
+func gunzipTo(w io.Writer) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		gz, err := pgzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("not a gzip stream: %w", err)
+		}
+		defer gz.Close()
+		_, err = io.Copy(w, gz)
+		return err
+	}
+}
+
+// extractTarGz extracts the gzipped tar archive read from r into dir.
+// Entries are kept inside dir: those with absolute paths or ".."
+// components, links, and entries under a symlink already in dir are
+// skipped with a warning. Existing files are overwritten.
+func extractTarGz(r io.Reader, dir string) (extracted, skipped int, err error) {
+	gz, err := pgzip.NewReader(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("not a gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return extracted, skipped, nil
+		}
+		if err != nil {
+			return extracted, skipped, fmt.Errorf("invalid archive: %w", err)
+		}
+
+		name := filepath.FromSlash(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "" || name == "." {
+			continue
+		}
+		target, err := safeExtractPath(dir, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", hdr.Name, err)
+			skipped++
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return extracted, skipped, fmt.Errorf("cannot create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return extracted, skipped, fmt.Errorf("cannot create directory: %w", err)
+			}
+			// A symlink in place of the file would be followed
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm()|0600)
+			if err != nil {
+				return extracted, skipped, fmt.Errorf("cannot create file: %w", err)
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return extracted, skipped, fmt.Errorf("cannot write %s: %w", target, err)
+			}
+			extracted++
+		default:
+			fmt.Fprintf(os.Stderr, "Skipping %s: links and special files aren't extracted\n", hdr.Name)
+			skipped++
+		}
+	}
+}
+
+// safeExtractPath returns where the archive entry name goes in dir, or an
+// error if it would end up outside of it.
+func safeExtractPath(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("outside the extraction directory")
+	}
+	// Parents that are symlinks could lead anywhere
+	parent := dir
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is a symlink", parent)
+		}
+	}
+	return filepath.Join(dir, name), nil
+}
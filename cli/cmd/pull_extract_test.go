@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// tarEntry is an entry of a test archive: a file with content, or a
+// symlink to link.
+type tarEntry struct {
+	name    string
+	content string
+	link    string
+}
+
+func buildTarGz(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.link}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// listFiles returns the paths of the files and symlinks under root.
+func listFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestExtractTarGzStaysInDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	root := t.TempDir()
+	dir, outside := filepath.Join(root, "dir"), filepath.Join(root, "outside")
+	for _, d := range []string{dir, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "victim"), []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Already in dir before the extraction: a directory and a file that
+	// are symlinks out of it
+	if err := os.Symlink(outside, filepath.Join(dir, "linked-dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "victim"), filepath.Join(dir, "linked-file")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buildTarGz(t,
+		tarEntry{name: "ok/file.txt", content: "fine"},
+		tarEntry{name: filepath.Join(outside, "absolute"), content: "evil"},
+		tarEntry{name: "../relative", content: "evil"},
+		tarEntry{name: "ok/../../nested", content: "evil"},
+		tarEntry{name: "escape", link: outside},
+		tarEntry{name: "escape/through-link", content: "evil"},
+		tarEntry{name: "linked-dir/under-link", content: "evil"},
+		tarEntry{name: "linked-file", content: "replaced"},
+	)
+	extracted, skipped, err := extractTarGz(archive, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file through the skipped symlink lands in a plain escape/ directory
+	if extracted != 3 || skipped != 5 {
+		t.Fatalf("extracted %d, skipped %d; want 3 and 5", extracted, skipped)
+	}
+
+	if got := listFiles(t, outside); len(got) != 1 || got[0] != "victim" {
+		t.Fatalf("files were written outside the directory: %v", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "victim")); string(data) != "original" {
+		t.Fatalf("file outside the directory was overwritten through a symlink: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "relative")); err == nil {
+		t.Fatal("../relative was written outside the directory")
+	}
+	if _, err := os.Stat(filepath.Join(root, "nested")); err == nil {
+		t.Fatal("ok/../../nested was written outside the directory")
+	}
+
+	info, err := os.Lstat(filepath.Join(dir, "escape"))
+	if err != nil || info.Mode()&os.ModeSymlink != 0 || !info.IsDir() {
+		t.Fatalf("escape should be a plain directory, got %v, %v", info, err)
+	}
+	info, err = os.Lstat(filepath.Join(dir, "linked-file"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("linked-file should be replaced by a regular file, got %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ok", "file.txt")); string(data) != "fine" {
+		t.Fatalf("ok/file.txt has %q", data)
+	}
+}
+
+func TestSafeExtractPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"file", true},
+		{filepath.Join("a", "b", "c"), true},
+		{filepath.Join("a", "..", "b"), true},
+		{filepath.Join("..", "x"), false},
+		{filepath.Join("a", "..", "..", "x"), false},
+		{string(filepath.Separator) + "x", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, err := safeExtractPath(dir, tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("safeExtractPath(%q) = %q, %v; want ok=%v", tt.name, got, err, tt.ok)
+		}
+	}
+}
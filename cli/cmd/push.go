@@ -1,25 +1,50 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var stripHeavyFiles string
-var autoYes bool
+var useSystemCompressor bool
+var includeTranslations bool
+var derefSymlinks bool
+var skipSymlinks bool
+var pushDBFlavor string
+var pushDBTarget string
 
 var pushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push base files to the preview server",
-	Long:  "Upload base database or files from your local project to the preview server.",
+	Long: `Upload base database or files from your local project to the preview server.
+
+With --encrypt-key-file the upload is encrypted on the fly (AES-256-GCM)
+with a key that never leaves your machine, e.g. one created with
+"openssl rand -hex 32". The server stores the encrypted base as-is, apart
+from the regular base, and cannot create previews from it; retrieve it
+with "preview pull db --base --encrypt-key-file KEY".
+
+With --dry-run nothing is uploaded: the dump or archive is planned as usual
+and the expected sizes, exclusions and commands are printed instead. Sizes
+are estimated by compressing the first 64 MB.
+
+Before replacing an existing base, the current one (size, upload date and
+uploader) is shown next to the new one, with the previews of the project
+that get it on their next rebuild.
+
+After an upload a summary shows the bytes sent, wall and upload time,
+throughput, compression ratio and chunk retries; --output json prints it
+as JSON on stdout.`,
 }
 
 var pushDBCmd = &cobra.Command{
@@ -28,43 +53,115 @@ var pushDBCmd = &cobra.Command{
 	Long: `Export the database using ddev drush sql-dump and upload it as the base
 database for previews.
 
+The dump is made for the database previews run: that of preview.yml
+("database: mariadb:10.6", MySQL 8.0 if unset), or --db-flavor (mysql or
+mariadb, optionally pinned to a version as mariadb:10.6). The ddev
+database is read from .ddev/config.yaml. The dump is made in utf8mb4,
+without the column statistics of MySQL 8, and collations the previews'
+database lacks (utf8mb4_0900_* of MySQL 8, utf8mb4_uca1400_* of MariaDB)
+are rewritten to utf8mb4_unicode_ci.
+
+With --target NAME the secondary database NAME is dumped instead (drush
+sql-dump --database=NAME, the key of $databases in settings.php) and
+uploaded as its own base dump. Previews import it into the database NAME
+listed under "databases:" in preview.yml (see 'preview setup project
+--databases'); the main base database is left unchanged.
+
+With --ssh [user@]host the dump is made on that host instead, e.g. the
+production server, and streamed back over SSH through the same
+compression, rewrites and upload, so the base database can be refreshed
+from production without the server reaching it. The remote command is
+--ssh-command, run in the login directory of the SSH user, where
+{{extra_dump}} is replaced by the mysqldump options above and
+{{database}} by the database dumped (default, or that of --target); the remote
+database is taken to be that of ddev unless --ssh-db-flavor is set. SSH
+options (port, key, jump host) come from ~/.ssh/config.
+
 If a file path is given, upload that file instead of generating a dump.
-The project is detected automatically from the git remote in the current directory.`,
+The project is detected automatically from the git remote in the current
+directory and checked against the server, which suggests close matches if
+it doesn't have it (e.g. after the repository was renamed).
+
+Examples:
+  preview push db
+  preview push db --ssh deploy@prod.example.com
+  preview push db --target migrate
+  preview push db --ssh prod --ssh-db-flavor mysql:8.0 \
+    --ssh-command "cd /var/www/site && vendor/bin/drush sql-dump --extra-dump='{{extra_dump}}'"`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkPushOutput(); err != nil {
+			return err
+		}
+		if err := checkSSHFlags(args); err != nil {
+			return err
+		}
+		if err := checkDBTarget(); err != nil {
+			return err
+		}
+		compat, err := dumpFlavors()
+		if err != nil {
+			return err
+		}
 		slug, err := detectProjectSlug()
 		if err != nil {
 			return err
 		}
+		kind := compat.kind()
+
+		if pushDryRun {
+			if len(args) == 1 {
+				return dryRunExistingFile(slug, kind, args[0])
+			}
+			return dryRunDB(slug, compat)
+		}
+
+		if slug, err = verifyProjectSlug(cmd.Context(), slug); err != nil {
+			return err
+		}
 
 		// Check current status on the server
-		status, err := apiClient.GetBaseFilesStatus(slug)
+		status, err := apiClient.GetBaseFilesStatus(cmd.Context(), slug)
 		if err != nil {
 			return fmt.Errorf("failed to check base files status: %w", err)
 		}
 
-		if status.DB != nil && status.DB.Exists {
-			fmt.Fprintf(os.Stderr, "A base database already exists for project %q (%d bytes).\n", slug, status.DB.SizeBytes)
-		} else {
-			fmt.Fprintf(os.Stderr, "No base database exists yet for project %q.\n", slug)
+		label, existing := "base database", status.DB
+		if pushDBTarget != "" {
+			label, existing = fmt.Sprintf("base dump of the %s database", pushDBTarget), status.Databases[pushDBTarget]
 		}
-
-		action := "overwrite the existing"
-		if status.DB == nil || !status.DB.Exists {
-			action = "upload a new"
+		replaced, label, existing, err := baseToReplace(cmd.Context(), slug, kind, label, existing)
+		if err != nil {
+			return err
 		}
-		if !confirm(fmt.Sprintf("Do you want to %s base database for %q?", action, slug)) {
+		newSize := ""
+		if existing != nil && existing.Exists {
+			if len(args) == 1 {
+				newSize = estimateFileSize(args[0])
+			} else {
+				newSize = estimateDumpSize(compat)
+			}
+		}
+		ok, err := confirmBasePush(cmd.Context(), slug, replaced, label, existing, newSize)
+		if err != nil {
+			return err
+		}
+		if !ok {
 			fmt.Fprintln(os.Stderr, "Aborted.")
 			return nil
 		}
 
+		if err := runPrePushHooks(slug, kind, args); err != nil {
+			return err
+		}
+
 		// If a file was provided, upload it directly
 		if len(args) == 1 {
-			return uploadExistingFile(slug, "db", args[0])
+			return uploadExistingFile(cmd.Context(), slug, kind, args[0])
 		}
 
 		// Generate dump with ddev drush sql-dump
-		return generateAndUploadDB(slug)
+		return generateAndUploadDB(cmd.Context(), slug, compat)
 	},
 }
 
@@ -74,43 +171,130 @@ var pushFilesCmd = &cobra.Command{
 	Long: `Package the Drupal files directory and upload it as the base files archive
 for previews.
 
+The css, js and php directories are regenerated on the preview and the
+temporary directory (if inside the files dir) holds transient files, so
+they are never packaged. Interface translations (locale.settings
+translation.path) are packaged when inside the files dir; with
+--include-translations they are also packaged from outside it, as
+translations/ (public://translations), and never stripped as heavy files.
+
+A .previewignore in the project root leaves out more paths. It has the
+syntax of a .gitignore there: "*.log" or "backup/" match at any depth,
+"/web/sites/default/files/private-exports" only that path, and "!" takes
+a path back. It applies after the rules above, and ignored files are not
+recorded as heavy files.
+
+Symlinks are archived as links, but a symlink pointing outside the files
+directory fails the push before anything is uploaded, as the previews
+couldn't follow it: --deref archives the files and directories symlinks
+point to instead, --skip-symlinks leaves symlinks out.
+
 If a file path is given, upload that file instead of packaging.
-The project is detected automatically from the git remote in the current directory.`,
+The project is detected automatically from the git remote in the current
+directory and checked against the server, which suggests close matches if
+it doesn't have it (e.g. after the repository was renamed).`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkPushOutput(); err != nil {
+			return err
+		}
+		if derefSymlinks && skipSymlinks {
+			return fmt.Errorf("--deref and --skip-symlinks can't be used together")
+		}
 		slug, err := detectProjectSlug()
 		if err != nil {
 			return err
 		}
+		if previewYmlProxiesFiles() {
+			fmt.Fprintln(os.Stderr, "Note: preview.yml sets \"files: stage-file-proxy\", so previews fetch files from production and don't use the base files archive.")
+		}
 
-		status, err := apiClient.GetBaseFilesStatus(slug)
+		if pushDryRun {
+			if len(args) == 1 {
+				return dryRunExistingFile(slug, "files", args[0])
+			}
+			return dryRunFiles(slug)
+		}
+
+		if slug, err = verifyProjectSlug(cmd.Context(), slug); err != nil {
+			return err
+		}
+
+		status, err := apiClient.GetBaseFilesStatus(cmd.Context(), slug)
 		if err != nil {
 			return fmt.Errorf("failed to check base files status: %w", err)
 		}
 
-		if status.Files != nil && status.Files.Exists {
-			fmt.Fprintf(os.Stderr, "A base files archive already exists for project %q (%d bytes).\n", slug, status.Files.SizeBytes)
-		} else {
-			fmt.Fprintf(os.Stderr, "No base files archive exists yet for project %q.\n", slug)
+		replaced, label, existing, err := baseToReplace(cmd.Context(), slug, "files", "base files archive", status.Files)
+		if err != nil {
+			return err
 		}
-
-		action := "overwrite the existing"
-		if status.Files == nil || !status.Files.Exists {
-			action = "upload a new"
+		newSize := ""
+		if existing != nil && existing.Exists {
+			if len(args) == 1 {
+				newSize = estimateFileSize(args[0])
+			} else {
+				newSize = estimateFilesSize()
+			}
+		}
+		ok, err := confirmBasePush(cmd.Context(), slug, replaced, label, existing, newSize)
+		if err != nil {
+			return err
 		}
-		if !confirm(fmt.Sprintf("Do you want to %s base files archive for %q?", action, slug)) {
+		if !ok {
 			fmt.Fprintln(os.Stderr, "Aborted.")
 			return nil
 		}
 
+		if err := runPrePushHooks(slug, "files", args); err != nil {
+			return err
+		}
+
 		if len(args) == 1 {
-			return uploadExistingFile(slug, "files", args[0])
+			return uploadExistingFile(cmd.Context(), slug, "files", args[0])
 		}
 
-		return generateAndUploadFiles(slug)
+		return generateAndUploadFiles(cmd.Context(), slug)
 	},
 }
 
+// checkDBTarget validates --target of push db.
+func checkDBTarget() error {
+	if pushDBTarget == "" {
+		return nil
+	}
+	if !validDatabaseName(pushDBTarget) {
+		return fmt.Errorf("invalid --target %q: expected a secondary database of preview.yml, e.g. migrate", pushDBTarget)
+	}
+	if encryptKeyFile != "" {
+		return fmt.Errorf("--encrypt-key-file can't be used with --target: only the main database can be encrypted")
+	}
+	if err := requireDatabases(); err != nil {
+		return err
+	}
+	listed := previewYmlDatabases()
+	for _, name := range listed {
+		if name == pushDBTarget {
+			return nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: preview.yml doesn't list %q under \"databases:\"; previews won't import it until it does.\n", pushDBTarget)
+	return nil
+}
+
+// runPrePushHooks runs the pre-push hooks of an upload of kind, with the
+// file given in args if any.
+func runPrePushHooks(slug, kind string, args []string) error {
+	file := ""
+	if len(args) == 1 {
+		file = args[0]
+	}
+	if err := runHooks("pre-push", "PREVIEW_PROJECT="+slug, "PREVIEW_NAME=", "PREVIEW_KIND="+kind, "PREVIEW_FILE="+file); err != nil {
+		return fmt.Errorf("%w; nothing was uploaded", err)
+	}
+	return nil
+}
+
 // detectProjectSlug reads the git remote "origin" URL in the current directory
 // and extracts the last path segment as the project slug.
 // e.g. git@gitlab.com:preview-tests/drupal-test.git -> "drupal-test"
@@ -143,20 +327,8 @@ func detectProjectSlug() (string, error) {
 	return slug, nil
 }
 
-func confirm(prompt string) bool {
-	if autoYes {
-		return true
-	}
-	fmt.Fprintf(os.Stderr, "%s [Y/n] ", prompt)
-	scanner := bufio.NewScanner(os.Stdin)
-	if scanner.Scan() {
-		answer := strings.TrimSpace(strings.ToLower(scanner.Text()))
-		return answer != "n" && answer != "no"
-	}
-	return true
-}
-
-func uploadExistingFile(slug, kind, filePath string) error {
+func uploadExistingFile(ctx context.Context, slug, kind, filePath string) error {
+	start := time.Now()
 	f, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("cannot open file: %w", err)
@@ -168,13 +340,19 @@ func uploadExistingFile(slug, kind, filePath string) error {
 		return fmt.Errorf("cannot stat file: %w", err)
 	}
 
+	if spoolsUploads() {
+		if err := checkSpoolSpace(uploadSpoolDir(), info.Size(), false); err != nil {
+			return err
+		}
+	}
 	fmt.Fprintf(os.Stderr, "Uploading %s (%d bytes)...\n", filePath, info.Size())
 
-	if err := apiClient.UploadBaseFileChunked(slug, kind, f, filepath.Base(filePath)); err != nil {
+	if err := uploadBase(ctx, slug, kind, f, filepath.Base(filePath)); err != nil {
 		return fmt.Errorf("upload failed: %w", err)
 	}
 
 	fmt.Fprintf(os.Stderr, "Done! Base %s for %q updated.\n", kind, slug)
+	printPushSummary(slug, kind, start, 0)
 	return nil
 }
 
@@ -197,59 +375,124 @@ func ensureDdevRunning() error {
 	return nil
 }
 
-// getDrupalFilesDir uses ddev drush status to detect the public files directory.
-// Returns a path relative to the project root (e.g. "docroot/sites/default/files").
-func getDrupalFilesDir() (string, error) {
+// drupalPaths are local paths relative to the project root.
+type drupalPaths struct {
+	Docroot string // e.g. "docroot", "" if Drupal is at the project root
+	Files   string // public files, e.g. "docroot/sites/default/files"
+	Temp    string // temporary files, "" if outside the project
+}
+
+// ddevMount is where DDEV mounts the project root inside the container.
+const ddevMount = "/var/www/html"
+
+// getDrupalPaths uses ddev drush status to detect the Drupal root, public
+// files directory and temporary directory.
+func getDrupalPaths() (drupalPaths, error) {
 	out, err := exec.Command("ddev", "drush", "status", "--format=json").Output()
 	if err != nil {
-		return "", fmt.Errorf("failed to run ddev drush status: %w", err)
+		return drupalPaths{}, fmt.Errorf("failed to run ddev drush status: %w", err)
 	}
 
 	var status map[string]interface{}
 	if err := json.Unmarshal(out, &status); err != nil {
-		return "", fmt.Errorf("failed to parse drush status: %w", err)
+		return drupalPaths{}, fmt.Errorf("failed to parse drush status: %w", err)
 	}
 
 	// "root" is the Drupal root inside the container, e.g. "/var/www/html/docroot"
 	// "files" is relative to root, e.g. "sites/default/files"
+	// "temp" is an absolute container path, e.g. "/tmp"
 	root, _ := status["root"].(string)
 	files, _ := status["files"].(string)
+	temp, _ := status["temp"].(string)
 
 	if files == "" {
-		return "", fmt.Errorf("drush status did not return a files path")
+		return drupalPaths{}, fmt.Errorf("drush status did not return a files path")
 	}
 
 	// Extract the docroot relative to /var/www/html (DDEV mount point)
 	// e.g. "/var/www/html/docroot" -> "docroot", "/var/www/html" -> ""
-	docroot := ""
-	ddevMount := "/var/www/html"
-	if root != "" && strings.HasPrefix(root, ddevMount) {
-		docroot = strings.TrimPrefix(root, ddevMount)
-		docroot = strings.TrimPrefix(docroot, "/")
+	paths := drupalPaths{Docroot: containerToLocal(root)}
+	paths.Files = drupalRelPath(paths.Docroot, files)
+	if temp != "" && strings.HasPrefix(temp, "/") {
+		paths.Temp = containerToLocal(temp)
+	} else if temp != "" {
+		paths.Temp = drupalRelPath(paths.Docroot, temp)
+	}
+	return paths, nil
+}
+
+// containerToLocal converts an absolute container path under the DDEV mount
+// to a path relative to the project root, or "" if it is outside it.
+func containerToLocal(p string) string {
+	if p == ddevMount || !strings.HasPrefix(p, ddevMount+"/") {
+		return ""
 	}
+	return filepath.FromSlash(strings.TrimPrefix(p, ddevMount+"/"))
+}
 
-	// Build the local path: docroot + files
-	var filesDir string
-	if docroot != "" {
-		filesDir = filepath.Join(docroot, files)
-	} else {
-		filesDir = files
+// drupalRelPath converts a path relative to the Drupal root to a path
+// relative to the project root.
+func drupalRelPath(docroot, p string) string {
+	if docroot == "" {
+		return filepath.Clean(filepath.FromSlash(p))
 	}
+	return filepath.Join(docroot, filepath.FromSlash(p))
+}
 
-	return filesDir, nil
+// getTranslationsDir returns the configured interface translations
+// directory (locale.settings translation.path) relative to the project root,
+// or "" if the locale module is not enabled or it is outside the project.
+func getTranslationsDir(docroot string) string {
+	out, err := exec.Command("ddev", "drush", "php:eval",
+		`if (\Drupal::moduleHandler()->moduleExists('locale')) { echo \Drupal::config('locale.settings')->get('translation.path'); }`).Output()
+	if err != nil {
+		return ""
+	}
+	p := strings.TrimSpace(string(out))
+	switch {
+	case p == "":
+		return ""
+	case strings.HasPrefix(p, "/"):
+		return containerToLocal(p)
+	default:
+		return drupalRelPath(docroot, p)
+	}
 }
 
-func generateAndUploadDB(slug string) error {
-	fmt.Fprintln(os.Stderr, "Generating database dump via ddev drush sql-dump...")
+// relInside returns target relative to dir (slash-separated) if target is
+// inside dir.
+func relInside(dir, target string) (string, bool) {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
 
-	// Ensure ddev is running before piping stdout, so startup messages
-	// don't get mixed into the SQL dump
-	if err := ensureDdevRunning(); err != nil {
-		return err
+func generateAndUploadDB(ctx context.Context, slug string, compat dumpCompat) error {
+	start := time.Now()
+	if compat.SSH != "" {
+		fmt.Fprintf(os.Stderr, "Generating database dump on %s...\n", compat.SSH)
+	} else {
+		fmt.Fprintln(os.Stderr, "Generating database dump via ddev drush sql-dump...")
+
+		// Ensure ddev is running before piping stdout, so startup messages
+		// don't get mixed into the SQL dump
+		if err := ensureDdevRunning(); err != nil {
+			return err
+		}
+		if spoolsUploads() {
+			if size, err := databaseDataSize(compat.Database); err == nil {
+				if err := checkSpoolSpace(uploadSpoolDir(), size, true); err != nil {
+					return err
+				}
+			}
+		}
 	}
+	compat.report()
 
-	// Create a pipe: drush sql-dump | pigz/gzip -> upload
-	drush := exec.Command("ddev", "drush", "sql-dump")
+	// Create a pipe: drush sql-dump | gzip -> upload
+	drush := compat.command()
 	drush.Stderr = os.Stderr
 
 	drushOut, err := drush.StdoutPipe()
@@ -257,46 +500,96 @@ func generateAndUploadDB(slug string) error {
 		return fmt.Errorf("failed to create pipe: %w", err)
 	}
 
-	// Use pigz if available, else gzip. Level 6 for good balance.
-	var compressor *exec.Cmd
-	compressorName := "gzip"
-	if hasPigz() {
-		compressorName = "pigz"
-		compressor = exec.Command("pigz", "-6", "-c")
-	} else {
-		compressor = exec.Command("gzip", "-6", "-c")
-	}
-	compressor.Stdin = drushOut
-	compressor.Stderr = os.Stderr
-
-	compressedOut, err := compressor.StdoutPipe()
+	pr, pw := io.Pipe()
+	gz, err := newGzipWriter(pw)
 	if err != nil {
-		return fmt.Errorf("failed to create %s pipe: %w", compressorName, err)
+		return err
 	}
 
 	if err := drush.Start(); err != nil {
-		return fmt.Errorf("failed to start drush: %w", err)
-	}
-	if err := compressor.Start(); err != nil {
-		return fmt.Errorf("failed to start %s: %w", compressorName, err)
-	}
+		return fmt.Errorf("failed to start %s: %w", compat.dumpName(), err)
+	}
+
+	// The upload reads the dump as it is produced, so drush failing must
+	// fail the upload before it completes, not leave a truncated base
+	// database behind
+	var dumpSize byteCounter
+	drushFailed := make(chan error, 1)
+	go func() {
+		dump := compat.writer(gz)
+		_, err := io.Copy(dump, io.TeeReader(drushOut, &dumpSize))
+		if cerr := dump.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// The upload failed; the rest of the dump isn't needed
+			drush.Process.Kill()
+		}
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+		var drushErr error
+		if werr := drush.Wait(); err == nil && werr != nil {
+			drushErr = fmt.Errorf("%s failed after %s: %w", compat.dumpName(), formatBytesShort(int64(dumpSize)), werr)
+			err = drushErr
+		}
+		pw.CloseWithError(err)
+		drushFailed <- drushErr
+	}()
 
-	fmt.Fprintf(os.Stderr, "Uploading database dump (compressor: %s -6)...\n", compressorName)
+	fmt.Fprintf(os.Stderr, "Uploading database dump (compressor: %s -6)...\n", gz.Name())
 
 	filename := fmt.Sprintf("%s-base.sql.gz", slug)
-	if err := apiClient.UploadBaseFileChunked(slug, "db", compressedOut, filename); err != nil {
+	label := "base database"
+	if compat.Database != "" {
+		filename = fmt.Sprintf("%s-base-db-%s.sql.gz", slug, compat.Database)
+		label = fmt.Sprintf("base dump of the %s database", compat.Database)
+	}
+	if err := uploadBase(ctx, slug, compat.kind(), verifyGzip(pr), filename); err != nil {
+		pr.CloseWithError(err)
+		if drushErr := <-drushFailed; drushErr != nil {
+			return fmt.Errorf("%w; the upload was aborted and the %s is unchanged", drushErr, label)
+		}
 		return fmt.Errorf("upload failed: %w", err)
 	}
 
-	if err := compressor.Wait(); err != nil {
-		return fmt.Errorf("%s failed: %w", compressorName, err)
-	}
-	if err := drush.Wait(); err != nil {
-		return fmt.Errorf("drush sql-dump failed: %w", err)
+	fmt.Fprintf(os.Stderr, "Done! %s for %q updated.\n", strings.ToUpper(label[:1])+label[1:], slug)
+	printPushSummary(slug, compat.kind(), start, int64(dumpSize))
+	return nil
+}
+
+// translationsArchiveDirs handles the interface translations directory.
+// Inside the files dir it is archived anyway; --include-translations keeps
+// its .po files even if --strip-heavy-files would drop them. Outside the
+// files dir it is only archived with --include-translations, as
+// "translations" (public://translations on the preview).
+func translationsArchiveDirs(paths drupalPaths, skip map[string]bool) ([]archiveDir, error) {
+	dir := getTranslationsDir(paths.Docroot)
+	if dir == "" {
+		return nil, nil
+	}
+
+	if rel, inside := relInside(paths.Files, dir); inside {
+		if includeTranslations {
+			for f := range skip {
+				if strings.HasPrefix(f, rel+"/") {
+					delete(skip, f)
+				}
+			}
+			delete(skip, rel)
+		}
+		return nil, nil
 	}
 
-	fmt.Fprintf(os.Stderr, "Done! Base database for %q updated.\n", slug)
-	return nil
+	if !includeTranslations {
+		fmt.Fprintf(os.Stderr, "Note: interface translations in %s are outside the files directory and not included (use --include-translations).\n", dir)
+		return nil, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("translations directory %q not found: %w", dir, err)
+	}
+	fmt.Fprintf(os.Stderr, "Including translations from %s as translations/\n", dir)
+	return []archiveDir{{Path: dir, Prefix: "translations"}}, nil
 }
 
 // parseSizeMB parses a size string like "10mb", "5MB", "10" into bytes.
@@ -312,25 +605,6 @@ func parseSizeMB(s string) (int64, error) {
 	return int64(mb * 1024 * 1024), nil
 }
 
-// hasPigz checks if pigz is available in PATH.
-func hasPigz() bool {
-	_, err := exec.LookPath("pigz")
-	return err == nil
-}
-
-// dirSize returns the total size in bytes of a directory using du -sb.
-func dirSize(path string) (int64, error) {
-	out, err := exec.Command("du", "-sb", path).Output()
-	if err != nil {
-		return 0, err
-	}
-	fields := strings.Fields(string(out))
-	if len(fields) == 0 {
-		return 0, fmt.Errorf("unexpected du output")
-	}
-	return strconv.ParseInt(fields[0], 10, 64)
-}
-
 // formatBytesShort formats bytes as a human-readable string (e.g. "1.2 GB").
 func formatBytesShort(b int64) string {
 	switch {
@@ -345,123 +619,286 @@ func formatBytesShort(b int64) string {
 	}
 }
 
-func generateAndUploadFiles(slug string) error {
+// filesArchivePlan is what packaging the files dir would include.
+type filesArchivePlan struct {
+	Dir        string
+	SourceSize int64
+	Temp       string // temporary dir inside Dir, relative to it
+	Skip       map[string]bool
+	Heavy      []string // heavy files left out by --strip-heavy-files
+	Extra      []archiveDir
+	// Sites are the multisite sites, whose files directories are all in
+	// Extra; nil for a single site, archived from Dir.
+	Sites []string
+	// Ignored are the entries left out by .previewignore, as paths from
+	// the project root
+	Ignored []string
+}
+
+// applyPreviewIgnore adds the entries of the archive matched by the
+// .previewignore of the project to the skips of their directory. It
+// returns those of Dir.
+func (p *filesArchivePlan) applyPreviewIgnore() (map[string]bool, error) {
+	ignore, err := loadPreviewIgnore()
+	if err != nil || ignore == nil {
+		return nil, err
+	}
+	var dirIgnored map[string]bool
+	if p.Sites == nil {
+		if dirIgnored, err = p.ignoreIn(ignore, p.Dir, p.Skip); err != nil {
+			return nil, err
+		}
+	}
+	for i := range p.Extra {
+		if p.Extra[i].Skip == nil {
+			p.Extra[i].Skip = map[string]bool{}
+		}
+		if _, err := p.ignoreIn(ignore, p.Extra[i].Path, p.Extra[i].Skip); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Ignored) > 0 {
+		fmt.Fprintf(os.Stderr, "Excluding %d paths matched by %s\n", len(p.Ignored), previewIgnoreFile)
+	}
+	return dirIgnored, nil
+}
+
+// ignoreIn skips the entries of dir matched by ignore and returns them.
+func (p *filesArchivePlan) ignoreIn(ignore *previewIgnore, dir string, skip map[string]bool) (map[string]bool, error) {
+	ignored, err := ignore.ignoredPaths(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+	rels := make(map[string]bool, len(ignored))
+	for _, rel := range ignored {
+		skip[rel] = true
+		rels[rel] = true
+		p.Ignored = append(p.Ignored, filepath.Join(dir, filepath.FromSlash(rel)))
+	}
+	return rels, nil
+}
+
+// checkSymlinks fails if the archive would hold symlinks pointing outside
+// their directory, unless --deref or --skip-symlinks says what to do.
+func (p *filesArchivePlan) checkSymlinks() error {
+	if derefSymlinks || skipSymlinks {
+		return nil
+	}
+	var escaping []string
+	for _, dir := range p.dirs() {
+		found, err := findEscapingSymlinks(dir.Path, dir.Skip)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", dir.Path, err)
+		}
+		escaping = append(escaping, found...)
+	}
+	if len(escaping) == 0 {
+		return nil
+	}
+	const shown = 10
+	list := escaping
+	if len(list) > shown {
+		list = append(list[:shown:shown], fmt.Sprintf("... and %d more", len(escaping)-shown))
+	}
+	return fmt.Errorf("%d symlinks point outside the files directory:\n  %s\nUse --deref to archive what they point to, or --skip-symlinks to leave symlinks out", len(escaping), strings.Join(list, "\n  "))
+}
+
+// dirs returns the directories the archive is made of.
+func (p *filesArchivePlan) dirs() []archiveDir {
+	if p.Sites != nil {
+		return p.Extra
+	}
+	return append([]archiveDir{{Path: p.Dir, Skip: p.Skip}}, p.Extra...)
+}
+
+// write writes the uncompressed archive to w.
+func (p *filesArchivePlan) write(w io.Writer) error {
+	if p.Sites != nil {
+		return writeSitesArchive(w, p.Sites, p.Extra)
+	}
+	return writeTarArchive(w, p.Dir, p.Skip, p.Extra...)
+}
+
+// planFilesArchive detects the files directory and decides what to leave
+// out of the archive.
+func planFilesArchive() (*filesArchivePlan, error) {
 	// Ensure ddev is running so we can query drush
 	if err := ensureDdevRunning(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Detect files directory via drush status
-	filesDir, err := getDrupalFilesDir()
+	paths, err := getDrupalPaths()
 	if err != nil {
-		return fmt.Errorf("could not detect files directory: %w", err)
+		return nil, fmt.Errorf("could not detect files directory: %w", err)
 	}
+	if sites := previewYmlSites(); isMultisite(sites) {
+		return planSitesArchive(paths, sites)
+	}
+	filesDir := paths.Files
 	if _, err := os.Stat(filesDir); os.IsNotExist(err) {
-		return fmt.Errorf("files directory %q not found — are you in the project root?", filesDir)
+		return nil, fmt.Errorf("files directory %q not found — are you in the project root?", filesDir)
 	}
 
 	// Calculate source size
-	sourceSize, _ := dirSize(filesDir)
-	if sourceSize > 0 {
-		fmt.Fprintf(os.Stderr, "Source: %s (%s)\n", filesDir, formatBytesShort(sourceSize))
-	}
-
-	// Determine compressor: pigz if available, else gzip
-	// Level 6 = good compression/speed balance (gzip default is 6, but being explicit)
-	usePigz := hasPigz()
-	compressorName := "gzip"
-	var compressorCmd *exec.Cmd
-	if usePigz {
-		compressorName = "pigz"
-		compressorCmd = exec.Command("pigz", "-6", "-c")
-	} else {
-		compressorCmd = exec.Command("gzip", "-6", "-c")
-		// Show hint for large packages (>500MB uncompressed)
-		if sourceSize > 500*1024*1024 {
-			fmt.Fprintln(os.Stderr, "HINT: Install pigz to speed up compression using multiple cores: sudo apt install pigz")
-		}
+	plan := &filesArchivePlan{Dir: filesDir, Skip: map[string]bool{}}
+	plan.SourceSize, _ = dirSize(filesDir)
+	if plan.SourceSize > 0 {
+		fmt.Fprintf(os.Stderr, "Source: %s (%s)\n", filesDir, formatBytesShort(plan.SourceSize))
 	}
 
-	// Build tar args (no compression — piped to external compressor)
-	tarArgs := []string{"cf", "-", "--exclude=./css", "--exclude=./js", "--exclude=./php"}
+	// The temporary directory only holds transient files
+	if rel, ok := relInside(filesDir, paths.Temp); ok {
+		plan.Temp = rel
+		plan.Skip[rel] = true
+		fmt.Fprintf(os.Stderr, "Excluding temporary directory %s\n", paths.Temp)
+	}
 
-	// If --strip-heavy-files is set, exclude large files
+	// Heavy files are left out of the archive when --strip-heavy-files is set
+	var heavyFiles []string
 	if stripHeavyFiles != "" {
 		maxBytes, err := parseSizeMB(stripHeavyFiles)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		findCmd := exec.Command("find", ".", "-type", "f", "-size", fmt.Sprintf("+%dc", maxBytes),
-			"-not", "-path", "./css/*", "-not", "-path", "./js/*", "-not", "-path", "./php/*")
-		findCmd.Dir = filesDir
-		findOut, err := findCmd.Output()
+		heavyFiles, err = findHeavyFiles(filesDir, maxBytes)
 		if err != nil {
-			return fmt.Errorf("find failed: %w", err)
+			return nil, fmt.Errorf("failed to scan files directory: %w", err)
 		}
-
-		heavyFiles := strings.Split(strings.TrimSpace(string(findOut)), "\n")
-		skipped := 0
 		for _, f := range heavyFiles {
-			f = strings.TrimSpace(f)
-			if f == "" {
-				continue
-			}
-			tarArgs = append(tarArgs, "--exclude="+f)
-			skipped++
-		}
-		if skipped > 0 {
-			fmt.Fprintf(os.Stderr, "Skipping %d files larger than %s\n", skipped, stripHeavyFiles)
+			plan.Skip[f] = true
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "Packaging %s (compressor: %s -6)...\n", filesDir, compressorName)
-
-	tarArgs = append(tarArgs, "-C", filesDir, ".")
-	tarCmd := exec.Command("tar", tarArgs...)
-	tarCmd.Stderr = os.Stderr
+	plan.Extra, err = translationsArchiveDirs(paths, plan.Skip)
+	if err != nil {
+		return nil, err
+	}
 
-	// Pipe: tar -> compressor -> upload
-	tarOut, err := tarCmd.StdoutPipe()
+	// .previewignore applies last, so --include-translations can't take
+	// its paths back
+	ignored, err := plan.applyPreviewIgnore()
 	if err != nil {
-		return fmt.Errorf("failed to create tar pipe: %w", err)
+		return nil, err
+	}
+
+	// --include-translations may have taken some back out of skip, and
+	// ignored files aren't stripped for their size
+	for _, f := range heavyFiles {
+		if plan.Skip[f] && !isIgnoredPath(ignored, f) {
+			plan.Heavy = append(plan.Heavy, f)
+		}
+	}
+	if len(plan.Heavy) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipping %d files larger than %s\n", len(plan.Heavy), stripHeavyFiles)
 	}
+	if err := plan.checkSymlinks(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
 
-	compressorCmd.Stdin = tarOut
-	compressorCmd.Stderr = os.Stderr
+// planSitesArchive plans the archive of the multisite sites of preview.yml,
+// with the files directory of each site under sites/SITE.
+func planSitesArchive(paths drupalPaths, sites []string) (*filesArchivePlan, error) {
+	if err := requireMultisite(); err != nil {
+		return nil, err
+	}
+	if stripHeavyFiles != "" || includeTranslations {
+		return nil, fmt.Errorf("--strip-heavy-files and --include-translations are not supported for multisites yet")
+	}
 
-	compressedOut, err := compressorCmd.StdoutPipe()
+	plan := &filesArchivePlan{Dir: filepath.Join(paths.Docroot, "sites"), Skip: map[string]bool{}, Sites: sites}
+	for _, site := range sites {
+		if !validSiteName(site) {
+			return nil, fmt.Errorf("invalid site %q in preview.yml: expected a directory name under sites/", site)
+		}
+		dir := filepath.Join(plan.Dir, site, "files")
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil, fmt.Errorf("files directory %q of site %s not found — are you in the project root?", dir, site)
+		}
+		size, _ := dirSize(dir)
+		plan.SourceSize += size
+		fmt.Fprintf(os.Stderr, "Source: %s (%s)\n", dir, formatBytesShort(size))
+
+		skip := map[string]bool{}
+		if rel, ok := relInside(dir, paths.Temp); ok {
+			skip[rel] = true
+			fmt.Fprintf(os.Stderr, "Excluding temporary directory %s\n", paths.Temp)
+		}
+		plan.Extra = append(plan.Extra, archiveDir{Path: dir, Prefix: "sites/" + site, Skip: skip})
+	}
+	if _, err := plan.applyPreviewIgnore(); err != nil {
+		return nil, err
+	}
+	if err := plan.checkSymlinks(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func generateAndUploadFiles(ctx context.Context, slug string) error {
+	start := time.Now()
+	plan, err := planFilesArchive()
 	if err != nil {
-		return fmt.Errorf("failed to create compressor pipe: %w", err)
+		return err
+	}
+	if spoolsUploads() {
+		if err := checkSpoolSpace(uploadSpoolDir(), plan.SourceSize, true); err != nil {
+			return err
+		}
 	}
 
-	if err := tarCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start tar: %w", err)
+	// Pipe: tar -> gzip -> upload
+	pr, pw := io.Pipe()
+	gz, err := newGzipWriter(pw)
+	if err != nil {
+		return err
 	}
-	if err := compressorCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start %s: %w", compressorName, err)
+
+	// Show hint for large packages (>500MB uncompressed)
+	if gz.Name() == "gzip" && plan.SourceSize > 500*1024*1024 {
+		fmt.Fprintln(os.Stderr, "HINT: Install pigz to speed up compression using multiple cores: sudo apt install pigz")
 	}
 
+	fmt.Fprintf(os.Stderr, "Packaging %s (compressor: %s -6)...\n", plan.Dir, gz.Name())
+
+	go func() {
+		err := plan.write(gz)
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+
 	fmt.Fprintln(os.Stderr, "Uploading files archive...")
 
 	filename := fmt.Sprintf("%s-files.tar.gz", slug)
-	if err := apiClient.UploadBaseFileChunked(slug, "files", compressedOut, filename); err != nil {
+	if err := uploadBase(ctx, slug, "files", pr, filename); err != nil {
+		pr.CloseWithError(err)
 		return fmt.Errorf("upload failed: %w", err)
 	}
 
-	if err := compressorCmd.Wait(); err != nil {
-		return fmt.Errorf("%s failed: %w", compressorName, err)
-	}
-	if err := tarCmd.Wait(); err != nil {
-		return fmt.Errorf("tar failed: %w", err)
-	}
-
 	fmt.Fprintf(os.Stderr, "Done! Base files for %q updated.\n", slug)
+	recordHeavyFiles(ctx, slug, plan)
+	printPushSummary(slug, "files", start, plan.SourceSize)
 	return nil
 }
 
 func init() {
-	pushCmd.PersistentFlags().BoolVarP(&autoYes, "yes", "y", false, "Skip confirmation prompts")
+	pushCmd.PersistentFlags().BoolVar(&useSystemCompressor, "use-system-compressor", false, "Compress with pigz/gzip from PATH instead of the built-in compressor")
+	pushCmd.PersistentFlags().StringVarP(&pushOutput, "output", "o", "text", "Upload summary format: text or json")
+	pushCmd.PersistentFlags().BoolVar(&pushDryRun, "dry-run", false, "Show what would be uploaded and its estimated size without uploading")
+	pushCmd.PersistentFlags().StringVar(&encryptKeyFile, "encrypt-key-file", "", "Encrypt the upload client-side with the 32-byte key in this file")
+	pushDBCmd.Flags().StringVar(&pushDBFlavor, "db-flavor", "", "Database the previews run, mysql or mariadb, optionally with a version (e.g. mariadb:10.6) (default: from preview.yml)")
+	pushDBCmd.Flags().StringVar(&pushDBTarget, "target", "", "Dump and upload this secondary database of preview.yml instead of the main one, e.g. migrate")
+	pushDBCmd.Flags().StringVar(&pushSSH, "ssh", "", "Dump the database on this [user@]host over SSH instead of ddev")
+	pushDBCmd.Flags().StringVar(&pushSSHCommand, "ssh-command", defaultSSHDumpCommand, "Dump command run on the --ssh host; {{extra_dump}} is replaced by the mysqldump options, {{database}} by the database")
+	pushDBCmd.Flags().StringVar(&pushSSHDBFlavor, "ssh-db-flavor", "", "Database of the --ssh host, mysql or mariadb, optionally with a version (default: that of ddev)")
+	pushFilesCmd.Flags().BoolVar(&includeTranslations, "include-translations", false, "Include interface translations (.po) even when outside the files dir or larger than --strip-heavy-files")
+	pushFilesCmd.Flags().BoolVar(&derefSymlinks, "deref", false, "Archive the files and directories symlinks point to instead of the links")
+	pushFilesCmd.Flags().BoolVar(&skipSymlinks, "skip-symlinks", false, "Leave symlinks out of the archive")
 	pushFilesCmd.Flags().StringVar(&stripHeavyFiles, "strip-heavy-files", "", "Exclude files larger than this size, e.g. --strip-heavy-files 10mb")
 	pushCmd.AddCommand(pushDBCmd)
 	pushCmd.AddCommand(pushFilesCmd)
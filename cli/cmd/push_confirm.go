@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// confirmBasePush asks before uploading the base of kind of a project, with
+// label naming it, e.g. "base database". When it replaces an existing base,
+// the current one (size, date, uploader) is shown next to the new one and
+// the previews that get it on their next rebuild, if any, so the wrong project
+// stands out before anything is overwritten. newSize describes the size of
+// the upload, "" if unknown.
+func confirmBasePush(ctx context.Context, slug, kind, label string, existing *client.BaseFileInfo, newSize string) (bool, error) {
+	if existing == nil || !existing.Exists {
+		fmt.Fprintf(os.Stderr, "No %s exists yet for project %q.\n", label, slug)
+		return confirm(fmt.Sprintf("Do you want to upload a new %s for %q?", label, slug))
+	}
+
+	fmt.Fprintf(os.Stderr, "\nThis replaces the %s of project %q:\n", label, slug)
+	fmt.Fprintf(os.Stderr, "  Current:   %s\n", describeCurrentBase(ctx, slug, kind, existing))
+	if newSize == "" {
+		newSize = "size unknown until uploaded"
+	}
+	fmt.Fprintf(os.Stderr, "  New:       %s\n", newSize)
+	fmt.Fprintf(os.Stderr, "  Previews:  %s\n\n", describeAffectedPreviews(ctx, slug, kind))
+	return confirm(fmt.Sprintf("Do you want to overwrite the existing %s for %q?", label, slug))
+}
+
+// describeCurrentBase returns the size, upload date and uploader of the base
+// of kind in use, from the upload history when the server has it.
+func describeCurrentBase(ctx context.Context, slug, kind string, existing *client.BaseFileInfo) string {
+	uploads, err := apiClient.GetBaseFilesHistory(ctx, slug)
+	if err == nil {
+		// Newest first: the first entry of kind is the one in use
+		for _, u := range uploads {
+			if u.Kind == kind {
+				return fmt.Sprintf("%s, uploaded %s by %s", formatBytesShort(u.SizeBytes), formatUploadTime(u.UploadedAt), u.UploadedBy)
+			}
+		}
+	}
+	if existing.ModifiedAt == "" {
+		return formatBytesShort(existing.SizeBytes)
+	}
+	return fmt.Sprintf("%s, modified %s", formatBytesShort(existing.SizeBytes), formatUploadTime(existing.ModifiedAt))
+}
+
+// describeAffectedPreviews lists the previews of a project that import the
+// base of kind on their next rebuild: all of them, unless preview.yml has
+// them fetch files from production. Encrypted bases are never used by
+// previews, the server can't read them.
+func describeAffectedPreviews(ctx context.Context, slug, kind string) string {
+	if kind == client.EncryptedKind("db") || kind == client.EncryptedKind("files") {
+		return "none, encrypted bases aren't used by previews"
+	}
+	if kind == "files" && previewYmlProxiesFiles() {
+		return "none, preview.yml sets \"files: stage-file-proxy\""
+	}
+	result, err := apiClient.ListPreviewsPage(ctx, client.ListOptions{Project: slug})
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	if len(result.Previews) == 0 {
+		return "none yet"
+	}
+	names := make([]string, len(result.Previews))
+	for i, p := range result.Previews {
+		names[i] = p.Name
+	}
+	return fmt.Sprintf("%d get it on their next rebuild: %s", len(names), strings.Join(names, ", "))
+}
+
+// estimateFileSize describes the size of a file given to push.
+func estimateFileSize(filePath string) string {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s)", formatBytesShort(info.Size()), filePath)
+}
+
+// estimateDumpSize describes the size of the database push db would dump,
+// from the table data of the ddev database. Remote dumps and stopped ddev
+// projects aren't measured.
+func estimateDumpSize(compat dumpCompat) string {
+	if compat.SSH != "" {
+		return fmt.Sprintf("dumped over SSH from %s, size unknown until uploaded", compat.SSH)
+	}
+	size, err := databaseDataSize(compat.Database)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("about %s of table data before compression", formatBytesShort(size))
+}
+
+// estimateFilesSize describes the size of the files directory push files
+// would package, if ddev is running to tell where it is.
+func estimateFilesSize() string {
+	paths, err := getDrupalPaths()
+	if err != nil {
+		return ""
+	}
+	size, err := dirSize(paths.Files)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("about %s before compression (%s)", formatBytesShort(size), paths.Files)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/capynet/preview-server/client/clienttest"
+)
+
+func TestDescribeAffectedPreviews(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5", Status: "running"})
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-6", Status: "stopped"})
+	defer func(c client.API) { apiClient = c }(apiClient)
+	apiClient = srv.Client()
+
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{"db", "2 get it on their next rebuild: mr-5, mr-6"},
+		{client.EncryptedKind("db"), "none, encrypted bases aren't used by previews"},
+		{client.EncryptedKind("files"), "none, encrypted bases aren't used by previews"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if got := describeAffectedPreviews(context.Background(), "drupal-test", tt.kind); got != tt.want {
+				t.Errorf("describeAffectedPreviews(%q) = %q, want %q", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+)
+
+// dbFlavor is a database server: mysql or mariadb, with its version if
+// known.
+type dbFlavor struct {
+	Type    string
+	Version string
+}
+
+func (f dbFlavor) String() string {
+	if f.Version == "" {
+		return f.Type
+	}
+	return f.Type + " " + f.Version
+}
+
+// atLeast reports whether f is version major.minor or later. An unknown
+// version is taken as the latest.
+func (f dbFlavor) atLeast(major, minor int) bool {
+	parts := strings.SplitN(f.Version, ".", 3)
+	maj, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return true
+	}
+	min := 0
+	if len(parts) > 1 {
+		min, _ = strconv.Atoi(parts[1])
+	}
+	return maj > major || maj == major && min >= minor
+}
+
+// parseDBFlavor parses "mysql", "mariadb" or either with a version, as
+// "mariadb:10.6" (the preview.yml "database" syntax).
+func parseDBFlavor(s string) (dbFlavor, error) {
+	typ, version, _ := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	if typ != "mysql" && typ != "mariadb" {
+		return dbFlavor{}, fmt.Errorf("invalid database %q: expected mysql or mariadb, optionally with a version (e.g. mariadb:10.6)", s)
+	}
+	return dbFlavor{Type: typ, Version: version}, nil
+}
+
+// previewYmlDatabase returns the database previews run as set in
+// preview.yml in the current directory, as "database: mariadb:10.6" or
+// the legacy "mariadb: 10.6" and "mysql_version: 8.0". Without it previews
+// run MySQL 8.0.
+func previewYmlDatabase() dbFlavor {
+	yml := loadPreviewYml()
+	spec := "mysql:8.0"
+	switch {
+	case yml.Database != "":
+		spec = yml.Database
+	case yml.MariaDB != "":
+		spec = "mariadb:" + yml.MariaDB
+	case yml.MySQLVersion != "":
+		spec = yml.MySQLVersion
+		if !strings.Contains(spec, ":") {
+			spec = "mysql:" + spec
+		}
+	}
+	flavor, err := parseDBFlavor(spec)
+	if err != nil {
+		return dbFlavor{Type: "mysql", Version: "8.0"}
+	}
+	return flavor
+}
+
+// ddevDatabase returns the database of the ddev project in the current
+// directory, from the "database" section of .ddev/config.yaml. ddev runs
+// MariaDB when it isn't set.
+func ddevDatabase() dbFlavor {
+	flavor := dbFlavor{Type: "mariadb"}
+	data, err := os.ReadFile(".ddev/config.yaml")
+	if err != nil {
+		return flavor
+	}
+	inDatabase := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if line[0] != ' ' && line[0] != '\t' {
+			inDatabase = key == "database"
+			continue
+		}
+		switch {
+		case inDatabase && key == "type" && (value == "mysql" || value == "mariadb"):
+			flavor.Type = value
+		case inDatabase && key == "version":
+			flavor.Version = value
+		}
+	}
+	return flavor
+}
+
+// dumpCompat is how a dump of one database is made importable into
+// another.
+type dumpCompat struct {
+	Source, Target dbFlavor
+	// ExtraDump are mysqldump/mariadb-dump options, passed with drush
+	// sql-dump --extra-dump
+	ExtraDump []string
+	// Rewrites describe the changes made to the dump for the target
+	Rewrites []string
+	// SSH is the [user@]host the dump is made on with --ssh-command, ""
+	// for the ddev database
+	SSH string
+	// Database is the secondary database dumped (push db --target), ""
+	// for the main one
+	Database string
+
+	collations  *regexp.Regexp
+	dropSandbox bool
+}
+
+// planDumpCompat returns how to dump source for previews running target.
+func planDumpCompat(source, target dbFlavor) dumpCompat {
+	c := dumpCompat{Source: source, Target: target}
+	c.ExtraDump = append(c.ExtraDump, "--default-character-set=utf8mb4")
+	if source.Type == "mysql" && source.atLeast(8, 0) {
+		// mysqldump 8 queries column statistics, which MariaDB and MySQL
+		// 5.7 don't have
+		c.ExtraDump = append(c.ExtraDump, "--column-statistics=0")
+	}
+
+	var collations []string
+	if source.Type == "mysql" && source.atLeast(8, 0) && (target.Type == "mariadb" || !target.atLeast(8, 0)) {
+		collations = append(collations, "0900")
+		c.Rewrites = append(c.Rewrites, "Rewriting MySQL 8 utf8mb4_0900 collations to utf8mb4_unicode_ci")
+	}
+	if source.Type == "mariadb" && source.atLeast(10, 10) && (target.Type == "mysql" || !target.atLeast(10, 10)) {
+		collations = append(collations, "uca1400")
+		c.Rewrites = append(c.Rewrites, "Rewriting MariaDB utf8mb4_uca1400 collations to utf8mb4_unicode_ci")
+	}
+	if collations != nil {
+		c.collations = regexp.MustCompile(`\butf8mb4_(` + strings.Join(collations, "|") + `)_[a-z0-9_]+`)
+	}
+	if source.Type == "mariadb" && target.Type == "mysql" {
+		// Recent mariadb-dump starts with a sandbox mode command the
+		// mysql client fails on
+		c.dropSandbox = true
+		c.Rewrites = append(c.Rewrites, "Dropping the MariaDB sandbox mode line")
+	}
+	return c
+}
+
+// dumpFlavors returns the compatibility plan for dumping the ddev
+// database, or with --ssh the remote one, for the previews: those of
+// --db-flavor, or of preview.yml. The remote database is taken to be that
+// of ddev unless --ssh-db-flavor is set.
+func dumpFlavors() (dumpCompat, error) {
+	target := previewYmlDatabase()
+	if pushDBFlavor != "" {
+		var err error
+		if target, err = parseDBFlavor(pushDBFlavor); err != nil {
+			return dumpCompat{}, fmt.Errorf("invalid --db-flavor: %w", err)
+		}
+	}
+	source := ddevDatabase()
+	if pushSSHDBFlavor != "" {
+		var err error
+		if source, err = parseDBFlavor(pushSSHDBFlavor); err != nil {
+			return dumpCompat{}, fmt.Errorf("invalid --ssh-db-flavor: %w", err)
+		}
+	}
+	c := planDumpCompat(source, target)
+	c.SSH = pushSSH
+	c.Database = pushDBTarget
+	return c, nil
+}
+
+// command returns the drush sql-dump command making the dump.
+func (c dumpCompat) command() *exec.Cmd {
+	if c.SSH != "" {
+		return c.sshCommand()
+	}
+	args := []string{"drush", "sql-dump"}
+	if c.Database != "" {
+		args = append(args, "--database="+c.Database)
+	}
+	return exec.Command("ddev", append(args, "--extra-dump="+strings.Join(c.ExtraDump, " "))...)
+}
+
+// describe returns the command line of the dump, for messages.
+func (c dumpCompat) describe() string {
+	if c.SSH != "" {
+		return fmt.Sprintf("ssh -T %s %s", c.SSH, shellQuote(c.sshDumpCommand()))
+	}
+	database := ""
+	if c.Database != "" {
+		database = " --database=" + c.Database
+	}
+	return fmt.Sprintf("ddev drush sql-dump%s --extra-dump='%s'", database, strings.Join(c.ExtraDump, " "))
+}
+
+// origin names where the dump is made, for messages: "ddev" or "ssh HOST".
+func (c dumpCompat) origin() string {
+	if c.SSH != "" {
+		return "ssh " + c.SSH
+	}
+	return "ddev"
+}
+
+// dumpName names the dump command, for errors.
+func (c dumpCompat) dumpName() string {
+	if c.SSH != "" {
+		return "the dump on " + c.SSH
+	}
+	return "drush sql-dump"
+}
+
+// kind returns the base file kind the dump is uploaded as.
+func (c dumpCompat) kind() string {
+	if c.Database != "" {
+		return client.DatabaseKind(c.Database)
+	}
+	return "db"
+}
+
+// report prints the databases and rewrites of the dump to stderr.
+func (c dumpCompat) report() {
+	if c.Database != "" {
+		fmt.Fprintf(os.Stderr, "Dumping the %s database %s (%s) for %s previews.\n", c.Database, c.Source, c.origin(), c.Target)
+	} else {
+		fmt.Fprintf(os.Stderr, "Dumping %s (%s) for %s previews.\n", c.Source, c.origin(), c.Target)
+	}
+	for _, r := range c.Rewrites {
+		fmt.Fprintf(os.Stderr, "  %s\n", r)
+	}
+}
+
+// writer returns w rewriting the dump written to it for the target, or w
+// itself when nothing needs rewriting. The result must be closed to write
+// the end of the dump.
+func (c dumpCompat) writer(w io.Writer) io.WriteCloser {
+	if c.collations == nil && !c.dropSandbox {
+		return nopWriteCloser{w}
+	}
+	return &dumpRewriter{w: w, compat: c, first: true}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// dumpSandboxLine is the first line of mariadb-dump output since 10.5.25.
+var dumpSandboxLine = []byte(`/*M!999999\- enable the sandbox mode */`)
+
+// dumpRewriter rewrites a dump line by line. Table data is passed through
+// unchanged: rows that mention a collation aren't rewritten.
+type dumpRewriter struct {
+	w       io.Writer
+	compat  dumpCompat
+	pending []byte
+	first   bool
+}
+
+func (d *dumpRewriter) Write(b []byte) (int, error) {
+	d.pending = append(d.pending, b...)
+	end := bytes.LastIndexByte(d.pending, '\n') + 1
+	if end == 0 {
+		return len(b), nil
+	}
+	if err := d.rewrite(d.pending[:end]); err != nil {
+		return 0, err
+	}
+	d.pending = append(d.pending[:0], d.pending[end:]...)
+	return len(b), nil
+}
+
+// Close writes what is left after the last newline.
+func (d *dumpRewriter) Close() error {
+	err := d.rewrite(d.pending)
+	d.pending = nil
+	return err
+}
+
+func (d *dumpRewriter) rewrite(lines []byte) error {
+	for len(lines) > 0 {
+		end := bytes.IndexByte(lines, '\n') + 1
+		if end == 0 {
+			end = len(lines)
+		}
+		line := lines[:end]
+		lines = lines[end:]
+
+		if d.first {
+			d.first = false
+			if d.compat.dropSandbox && bytes.HasPrefix(line, dumpSandboxLine) {
+				continue
+			}
+		}
+		if d.compat.collations != nil && !bytes.HasPrefix(line, []byte("INSERT INTO")) {
+			line = d.compat.collations.ReplaceAll(line, []byte("utf8mb4_unicode_ci"))
+		}
+		if _, err := d.w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
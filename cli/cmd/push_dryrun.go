@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var pushDryRun bool
+
+// dryRunSampleSize is how much of the archive or dump a dry run compresses
+// to estimate the compression ratio. Smaller inputs are compressed whole.
+const dryRunSampleSize = 64 * 1024 * 1024
+
+var errSampleFull = errors.New("sample full")
+
+// sampleWriter passes writes through to w and fails with errSampleFull
+// once limit bytes were written, so the producer stops early.
+type sampleWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+}
+
+func (s *sampleWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	if err == nil && s.n >= s.limit {
+		err = errSampleFull
+	}
+	return n, err
+}
+
+// byteCounter is an io.Writer that discards data and counts it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// compressionSample is the result of compressing (the start of) an upload.
+type compressionSample struct {
+	Compressor string
+	In, Out    int64
+	Complete   bool // the whole input fit in the sample
+}
+
+// sampleCompression compresses up to dryRunSampleSize bytes of what produce
+// writes with the compressor an upload would use.
+func sampleCompression(produce func(w io.Writer) error) (*compressionSample, error) {
+	var out byteCounter
+	gz, err := newGzipWriter(&out)
+	if err != nil {
+		return nil, err
+	}
+	sw := &sampleWriter{w: gz, limit: dryRunSampleSize}
+	perr := produce(sw)
+	cerr := gz.Close()
+	if perr != nil && !errors.Is(perr, errSampleFull) {
+		return nil, perr
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return &compressionSample{Compressor: gz.Name(), In: sw.n, Out: int64(out), Complete: perr == nil}, nil
+}
+
+// expected returns the compressed size of total input bytes, extrapolated
+// from the sample unless it covered everything.
+func (s *compressionSample) expected(total int64) int64 {
+	if s.Complete || s.In == 0 {
+		return s.Out
+	}
+	return int64(float64(total) * float64(s.Out) / float64(s.In))
+}
+
+func (s *compressionSample) describe() string {
+	if s.Complete {
+		return fmt.Sprintf("%s -6", s.Compressor)
+	}
+	return fmt.Sprintf("%s -6, estimated from the first %s", s.Compressor, formatBytesShort(s.In))
+}
+
+// dryRunHeader prints what is common to every dry run.
+func dryRunHeader(slug, kind string) error {
+	fmt.Printf("Dry run: nothing will be uploaded.\n\n")
+	fmt.Printf("Project:            %s\n", slug)
+	fmt.Printf("Destination:        base %s\n", kind)
+	if encryptKeyFile != "" {
+		if _, err := loadEncryptionKey(); err != nil {
+			return err
+		}
+		fmt.Printf("Encryption:         AES-256-GCM, key from %s\n", encryptKeyFile)
+	}
+	return nil
+}
+
+// dryRunExistingFile reports the upload of a file given on the command line.
+func dryRunExistingFile(slug, kind, filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("cannot open file: %w", err)
+	}
+	if err := dryRunHeader(slug, kind); err != nil {
+		return err
+	}
+	fmt.Printf("Upload:             %s (%s)\n", filePath, formatBytesShort(info.Size()))
+	return nil
+}
+
+// dryRunDB dumps and compresses the start of the database to estimate the
+// size of the base database upload.
+func dryRunDB(slug string, compat dumpCompat) error {
+	// The size of a remote database isn't queried: only the sample is known
+	var dataSize int64
+	if compat.SSH == "" {
+		if err := ensureDdevRunning(); err != nil {
+			return err
+		}
+		var err error
+		if dataSize, err = databaseDataSize(compat.Database); err != nil {
+			return err
+		}
+	}
+
+	compat.report()
+	fmt.Fprintln(os.Stderr, "Sampling database dump to estimate compression...")
+	drush := compat.command()
+	drush.Stderr = os.Stderr
+	drushOut, err := drush.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	if err := drush.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", compat.dumpName(), err)
+	}
+	sample, err := sampleCompression(func(w io.Writer) error {
+		_, err := io.Copy(w, drushOut)
+		return err
+	})
+	if sample == nil || !sample.Complete {
+		// The rest of the dump isn't needed.
+		drush.Process.Kill()
+	}
+	werr := drush.Wait()
+	if err != nil {
+		return err
+	}
+	if sample.Complete && werr != nil {
+		return fmt.Errorf("%s failed: %w", compat.dumpName(), werr)
+	}
+
+	uncompressed := dataSize
+	label := "table data, the dump is of similar size"
+	if compat.SSH != "" {
+		uncompressed = sample.In
+		label = "at least: only the start of the remote dump was sampled"
+	}
+	if sample.Complete {
+		uncompressed = sample.In
+		label = "dump"
+	}
+
+	if err := dryRunHeader(slug, compat.kind()); err != nil {
+		return err
+	}
+	fmt.Printf("Uncompressed size:  %s (%s)\n", formatBytesShort(uncompressed), label)
+	fmt.Printf("Expected upload:    %s (%s)\n", formatBytesShort(sample.expected(uncompressed)), sample.describe())
+	fmt.Printf("\nCommands:\n  %s | %s -6\n", compat.describe(), sample.Compressor)
+	return nil
+}
+
+// databaseDataSize returns the size of the table data of the ddev database,
+// or of its secondary database if given.
+func databaseDataSize(database string) (int64, error) {
+	args := []string{"drush", "sql-query"}
+	if database != "" {
+		args = append(args, "--database="+database)
+	}
+	out, err := exec.Command("ddev", append(args,
+		"SELECT COALESCE(SUM(data_length), 0) FROM information_schema.tables WHERE table_schema = DATABASE()")...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w", err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected database size %q", strings.TrimSpace(string(out)))
+	}
+	return size, nil
+}
+
+// dryRunFiles runs the files detection and exclusion logic and reports
+// what the base files archive would contain.
+func dryRunFiles(slug string) error {
+	plan, err := planFilesArchive()
+	if err != nil {
+		return err
+	}
+
+	var size, count int64
+	for _, dir := range plan.dirs() {
+		s, n, err := archiveContentSize(dir.Path, dir.Skip)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", dir.Path, err)
+		}
+		size += s
+		count += n
+	}
+
+	fmt.Fprintln(os.Stderr, "Sampling archive to estimate compression...")
+	sample, err := sampleCompression(func(w io.Writer) error {
+		return plan.write(w)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := dryRunHeader(slug, "files"); err != nil {
+		return err
+	}
+	fmt.Printf("Files directory:    %s (%s on disk)\n", plan.Dir, formatBytesShort(plan.SourceSize))
+	fmt.Printf("Uncompressed size:  %s (%d files)\n", formatBytesShort(size), count)
+	fmt.Printf("Expected archive:   %s (%s)\n", formatBytesShort(sample.expected(size)), sample.describe())
+	for _, dir := range plan.Extra {
+		fmt.Printf("Included:           %s as %s/\n", dir.Path, dir.Prefix)
+	}
+
+	fmt.Printf("Excluded:           %s (regenerated on the preview)\n", strings.Join(filesArchiveExcludes, "/, ")+"/")
+	if plan.Temp != "" {
+		fmt.Printf("                    %s/ (temporary directory)\n", plan.Temp)
+	}
+	for _, p := range plan.Ignored {
+		fmt.Printf("                    %s (%s)\n", p, previewIgnoreFile)
+	}
+	if stripHeavyFiles != "" {
+		var heavy []string
+		var heavySize int64
+		for _, rel := range plan.Heavy {
+			if info, err := os.Stat(filepath.Join(plan.Dir, filepath.FromSlash(rel))); err == nil {
+				heavySize += info.Size()
+				heavy = append(heavy, fmt.Sprintf("  %s (%s)", rel, formatBytesShort(info.Size())))
+			}
+		}
+		fmt.Printf("Heavy files:        %d larger than %s excluded (%s)\n", len(plan.Heavy), stripHeavyFiles, formatBytesShort(heavySize))
+		for _, line := range heavy {
+			fmt.Println(line)
+		}
+	}
+
+	if plan.Sites != nil {
+		// No single tar command packages several sites with the manifest
+		fmt.Printf("Multisite:          %s (listed in %s)\n", strings.Join(plan.Sites, ", "), sitesManifest)
+		return nil
+	}
+
+	// Excluded paths go in a file rather than on the command line, where
+	// they would have to survive both the shell and tar's own patterns
+	excluded := append([]string(nil), filesArchiveExcludes...)
+	for rel := range plan.Skip {
+		excluded = append(excluded, rel)
+	}
+	sort.Strings(excluded)
+	excludeFile, err := os.CreateTemp("", "preview-excludes-*.txt")
+	if err != nil {
+		return err
+	}
+	err = writeTarExcludes(excludeFile, excluded)
+	if cerr := excludeFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", excludeFile.Name(), err)
+	}
+
+	tarArgs := []string{"tar", "cf", "-", "-C", plan.Dir, "--exclude-from=" + excludeFile.Name(), "."}
+	fmt.Printf("\nCommands:\n  %s | %s -6\n", shellJoin(tarArgs), sample.Compressor)
+	fmt.Printf("  (%s lists the %d excluded paths)\n", excludeFile.Name(), len(excluded))
+	for _, dir := range plan.Extra {
+		fmt.Printf("  (plus %s, archived as ./%s/)\n", dir.Path, dir.Prefix)
+	}
+	return nil
+}
+
+// writeTarExcludes writes an exclude file for GNU tar's --exclude-from,
+// one pattern per line, matching exactly the entries "./rel" of rels
+// (slash-separated, relative to the directory archived) and what is below
+// them. Characters tar treats as wildcards are escaped; a newline, which
+// can't be written in a line, is matched by "?", so at worst the pattern
+// also matches a sibling differing only there: the command may leave out
+// more than the archive, never less.
+func writeTarExcludes(w io.Writer, rels []string) error {
+	escaper := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "\n", "?")
+	for _, rel := range rels {
+		if _, err := io.WriteString(w, "./"+escaper.Replace(rel)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveContentSize returns the total size and number of regular files
+// writeTarArchive would package from root.
+func archiveContentSize(root string, skip map[string]bool) (size, count int64, err error) {
+	err = walkFilesDir(root, func(rel string, d fs.DirEntry) error {
+		if skip[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		count++
+		return nil
+	})
+	return size, count, err
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var pushSSH string
+var pushSSHCommand string
+var pushSSHDBFlavor string
+
+// defaultSSHDumpCommand is the command push db --ssh runs on the remote
+// host, in the login directory of the SSH user.
+const defaultSSHDumpCommand = "drush sql-dump --database={{database}} --extra-dump='{{extra_dump}}'"
+
+// sshDumpCommand returns the command run on the remote host: the
+// --ssh-command template with {{extra_dump}} replaced by the dump options
+// of c, and {{database}} by the database dumped ("default" for the main
+// one).
+func (c dumpCompat) sshDumpCommand() string {
+	database := c.Database
+	if database == "" {
+		database = "default"
+	}
+	return strings.NewReplacer(
+		"{{extra_dump}}", strings.Join(c.ExtraDump, " "),
+		"{{database}}", database,
+	).Replace(pushSSHCommand)
+}
+
+// sshCommand returns the ssh command making the dump on the remote host.
+// ssh asks for passwords and host key confirmations on the terminal, not
+// on its output, so they don't end up in the dump.
+func (c dumpCompat) sshCommand() *exec.Cmd {
+	return exec.Command("ssh", "-T", c.SSH, c.sshDumpCommand())
+}
+
+// checkSSHFlags validates the --ssh flags of push db.
+func checkSSHFlags(args []string) error {
+	if pushSSH == "" {
+		if pushSSHDBFlavor != "" {
+			return fmt.Errorf("--ssh-db-flavor requires --ssh")
+		}
+		return nil
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("--ssh and a file to upload can't be used together")
+	}
+	if strings.HasPrefix(pushSSH, "-") {
+		return fmt.Errorf("invalid --ssh %q: expected [user@]host", pushSSH)
+	}
+	if strings.TrimSpace(pushSSHCommand) == "" {
+		return fmt.Errorf("--ssh-command can't be empty")
+	}
+	if pushDBTarget != "" && !strings.Contains(pushSSHCommand, "{{database}}") {
+		return fmt.Errorf("--ssh-command must dump the --target database: pass it {{database}}, e.g. drush sql-dump --database={{database}}")
+	}
+	return nil
+}
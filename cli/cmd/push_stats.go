@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+)
+
+var pushOutput string
+
+// lastUpload holds the statistics of the last completed upload, reported
+// by the client.
+var lastUpload *client.UploadStats
+
+// pushSummary is printed after a push completes, so upload settings can be
+// compared across runs.
+type pushSummary struct {
+	Project       string  `json:"project"`
+	Kind          string  `json:"kind"`
+	SourceBytes   int64   `json:"source_bytes,omitempty"`
+	Bytes         int64   `json:"bytes"`
+	WallSeconds   float64 `json:"wall_seconds"`
+	UploadSeconds float64 `json:"upload_seconds"`
+	MBPerSecond   float64 `json:"mb_per_second"`
+	Ratio         float64 `json:"compression_ratio,omitempty"`
+	Chunks        int     `json:"chunks"`
+	ChunkRetries  int     `json:"chunk_retries"`
+}
+
+func checkPushOutput() error {
+	if pushOutput != "text" && pushOutput != "json" {
+		return fmt.Errorf("invalid --output %q: expected text or json", pushOutput)
+	}
+	return nil
+}
+
+// printPushSummary reports the last upload of a push that started at start.
+// sourceBytes is the size before compression, or 0 if unknown.
+func printPushSummary(slug, kind string, start time.Time, sourceBytes int64) {
+	if lastUpload == nil {
+		return
+	}
+	s := pushSummary{
+		Project:       slug,
+		Kind:          kind,
+		SourceBytes:   sourceBytes,
+		Bytes:         lastUpload.Bytes,
+		WallSeconds:   time.Since(start).Seconds(),
+		UploadSeconds: lastUpload.Duration.Seconds(),
+		Chunks:        lastUpload.Chunks,
+		ChunkRetries:  lastUpload.Retries,
+	}
+	if s.UploadSeconds > 0 {
+		s.MBPerSecond = float64(s.Bytes) / (1024 * 1024) / s.UploadSeconds
+	}
+	if sourceBytes > 0 {
+		s.Ratio = float64(s.Bytes) / float64(sourceBytes)
+	}
+
+	if pushOutput == "json" {
+		data, _ := json.MarshalIndent(s, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	line := fmt.Sprintf("Uploaded %s in %s (%.1f MB/s over %s of upload)",
+		formatBytesShort(s.Bytes), formatDuration(s.WallSeconds), s.MBPerSecond, formatDuration(s.UploadSeconds))
+	if s.Ratio > 0 {
+		line += fmt.Sprintf(", %.0f%% of %s source", s.Ratio*100, formatBytesShort(sourceBytes))
+	}
+	line += fmt.Sprintf(", %d chunks, %d retries", s.Chunks, s.ChunkRetries)
+	fmt.Fprintln(os.Stderr, line)
+}
+
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
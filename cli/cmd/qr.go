@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrCode is a QR symbol of a preview URL, rendered in the terminal.
+type qrCode struct {
+	*qr.Code
+}
+
+// encodeQR returns the QR code of text at error correction level M, using
+// the smallest version that fits it.
+func encodeQR(text string) (*qrCode, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode a QR code of %d bytes: %w", len(text), err)
+	}
+	return &qrCode{code}, nil
+}
+
+// render writes the symbol with a quiet zone, two modules per character
+// cell using half blocks. Colors are set explicitly so the code scans on
+// dark and light terminal themes alike.
+func (q *qrCode) render(w io.Writer) {
+	const quiet = 4
+	dark := func(x, y int) bool {
+		return q.Black(x-quiet, y-quiet)
+	}
+	color := func(isDark bool, base int) int {
+		if isDark {
+			return base // black
+		}
+		return base + 67 // bright white
+	}
+
+	total := q.Size + 2*quiet
+	var sb strings.Builder
+	for y := 0; y < total; y += 2 {
+		for x := 0; x < total; x++ {
+			fmt.Fprintf(&sb, "\x1b[%d;%dm▀", color(dark(x, y), 30), color(dark(x, y+1), 40))
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	io.WriteString(w, sb.String())
+}
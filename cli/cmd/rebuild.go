@@ -3,24 +3,52 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"strings"
 
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
 )
 
+var rebuildChangedOnly bool
+var rebuildSHA string
+
 var rebuildCmd = &cobra.Command{
 	Use:   "rebuild PROJECT/mr-ID",
 	Short: "Trigger a GitLab pipeline rebuild",
-	Args:  cobra.ExactArgs(1),
+	Long: `Trigger a GitLab pipeline rebuild.
+
+With --changed-only, the preview is only rebuilt if its deployed commit
+differs from the current git HEAD (or --sha), so scheduled jobs can call
+rebuild without burning CI minutes.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		project, mrID, err := parsePreviewArg(args[0])
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(os.Stderr, "Triggering rebuild for %s/mr-%d...\n", project, mrID)
-		result, err := apiClient.PostAction(project, mrID, "rebuild")
+
+		var result *client.ActionResult
+		if rebuildChangedOnly || rebuildSHA != "" {
+			sha := rebuildSHA
+			if sha == "" {
+				if sha, err = detectGitCommit(); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Rebuilding %s/mr-%d if not at %s...\n", project, mrID, shortSHA(sha))
+			result, err = apiClient.RebuildIfChanged(cmd.Context(), project, fmt.Sprintf("mr-%d", mrID), sha)
+		} else {
+			fmt.Fprintf(os.Stderr, "Triggering rebuild for %s/mr-%d...\n", project, mrID)
+			result, err = apiClient.PostAction(cmd.Context(), project, mrID, "rebuild")
+		}
 		if err != nil {
 			return err
 		}
+		if result.UpToDate {
+			fmt.Printf("%s/mr-%d is already up to date.\n", project, mrID)
+			return nil
+		}
 		printActionResult(result)
 		if result.PipelineURL != "" {
 			fmt.Fprintf(os.Stderr, "Pipeline: %s\n", result.PipelineURL)
@@ -28,10 +56,27 @@ var rebuildCmd = &cobra.Command{
 		if !result.Success {
 			os.Exit(1)
 		}
-		return nil
+		return runPostRebuildHooks(project, fmt.Sprintf("mr-%d", mrID), result)
 	},
 }
 
+// runPostRebuildHooks runs the post-rebuild hooks of a rebuild triggered
+// with result.
+func runPostRebuildHooks(project, previewName string, result *client.ActionResult) error {
+	return runHooks("post-rebuild", "PREVIEW_PROJECT="+project, "PREVIEW_NAME="+previewName, "PREVIEW_PIPELINE_URL="+result.PipelineURL)
+}
+
+// detectGitCommit returns the commit SHA of HEAD in the current directory.
+func detectGitCommit() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not detect git commit: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 func init() {
+	rebuildCmd.Flags().BoolVar(&rebuildChangedOnly, "changed-only", false, "Only rebuild if the deployed commit differs from git HEAD")
+	rebuildCmd.Flags().StringVar(&rebuildSHA, "sha", "", "Commit to compare with instead of git HEAD (implies --changed-only)")
 	rootCmd.AddCommand(rebuildCmd)
 }
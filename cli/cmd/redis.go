@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var redisCmd = &cobra.Command{
+	Use:   "redis",
+	Short: "Use the Redis service of a preview",
+	Long: `Run redis-cli in the Redis container of a preview. The preview needs
+"services: redis: true" in preview.yml. Requires the manager role.`,
+}
+
+var redisCLICmd = &cobra.Command{
+	Use:   "cli [PROJECT/PREVIEW-NAME] [-- args...]",
+	Short: "Run redis-cli on a preview",
+	Long: `Run redis-cli in the preview's Redis container: interactively without
+arguments, or the given command.
+
+If PROJECT/PREVIEW-NAME is given, runs redis-cli on that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview redis cli drupal-test/mr-5
+  preview redis cli drupal-test/mr-5 -- INFO memory
+  preview redis cli -- KEYS 'drupal:*'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if cmd.ArgsLenAtDash() == 0 {
+			project, previewName, err = detectPreview(cmd.Context())
+		} else {
+			project, previewName, args, err = resolvePreviewAndArgs(cmd.Context(), args)
+		}
+		if err != nil {
+			return err
+		}
+		return runRedisCLI(cmd, project, previewName, shellJoin(args))
+	},
+}
+
+var redisFlushCmd = &cobra.Command{
+	Use:   "flush [PROJECT/PREVIEW-NAME]",
+	Short: "Empty the Redis cache of a preview",
+	Long: `Delete every key of the preview's Redis (FLUSHALL), e.g. after a
+database import left stale cache entries behind.
+
+Examples:
+  preview redis flush drupal-test/mr-5
+  preview redis flush --yes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		ok, err := confirm(fmt.Sprintf("Delete every Redis key of %s/%s?", project, previewName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return nil
+		}
+		return runRedisCLI(cmd, project, previewName, "FLUSHALL")
+	},
+}
+
+// runRedisCLI runs redis-cli with args on the preview, exiting with its
+// exit code if it fails.
+func runRedisCLI(cmd *cobra.Command, project, previewName, args string) error {
+	if err := requireServices(); err != nil {
+		return err
+	}
+	if args == "" {
+		fmt.Fprintf(os.Stderr, "Connecting to redis-cli on %s/%s...\n", project, previewName)
+	} else {
+		fmt.Fprintf(os.Stderr, "Running redis-cli %s on %s/%s...\n", args, project, previewName)
+	}
+
+	session, restore, err := localTerminal()
+	if err != nil {
+		return err
+	}
+	code, err := apiClient.RedisCLI(cmd.Context(), project, previewName, args, session)
+	restore()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+func init() {
+	redisCmd.AddCommand(redisCLICmd, redisFlushCmd)
+	rootCmd.AddCommand(redisCmd)
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var reportProject string
+var reportFormat string
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Export an inventory of previews for capacity reviews",
+	Long: `Print an inventory of the previews of every project, or of --project: their
+status, age, last deploy, disk usage and MR link. Measuring disk usage
+makes the server walk every preview, so the report takes longer than
+'preview list'.
+
+Formats:
+  markdown  a table with a summary, to paste into a review (default)
+  csv       one row per preview, for spreadsheets
+  json      one object per preview, for scripts
+
+Sizes are those of the preview directories, code and uploaded files; the
+databases live in Docker volumes and aren't counted.
+
+Examples:
+  preview report > previews.md
+  preview report --project drupal-test --format csv > previews.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportFormat != "markdown" && reportFormat != "csv" && reportFormat != "json" {
+			return fmt.Errorf("invalid --format %q: expected csv, json or markdown", reportFormat)
+		}
+		result, err := listPreviewsPage(cmd.Context(), client.ListOptions{
+			Project:       reportProject,
+			IncludeStatus: true,
+			IncludeSizes:  true,
+		})
+		if err != nil {
+			return err
+		}
+		if result.Total == 0 && reportProject != "" {
+			return fmt.Errorf("project %q not found", reportProject)
+		}
+
+		now := time.Now()
+		rows := make([]reportRow, 0, len(result.Previews))
+		for _, p := range result.Previews {
+			rows = append(rows, newReportRow(p, now))
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			if rows[i].Project != rows[j].Project {
+				return rows[i].Project < rows[j].Project
+			}
+			return rows[i].AgeDays > rows[j].AgeDays
+		})
+
+		switch reportFormat {
+		case "json":
+			out, err := json.MarshalIndent(rows, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		case "csv":
+			return writeReportCSV(rows)
+		default:
+			printReportMarkdown(rows, now)
+			return nil
+		}
+	},
+}
+
+// reportRow is a preview in the report. Times are RFC 3339, empty when
+// unknown; AgeDays and SizeBytes are -1 when unknown.
+type reportRow struct {
+	Project          string `json:"project"`
+	Preview          string `json:"preview"`
+	Status           string `json:"status"`
+	Branch           string `json:"branch"`
+	MrURL            string `json:"mr_url"`
+	URL              string `json:"url"`
+	CreatedAt        string `json:"created_at"`
+	AgeDays          int    `json:"age_days"`
+	LastDeployedAt   string `json:"last_deployed_at"`
+	LastDeployStatus string `json:"last_deploy_status"`
+	LastAccessedAt   string `json:"last_accessed_at"`
+	SizeBytes        int64  `json:"size_bytes"`
+}
+
+func newReportRow(p client.Preview, now time.Time) reportRow {
+	row := reportRow{
+		Project:   p.Project,
+		Preview:   p.Name,
+		Status:    p.Status,
+		Branch:    p.Branch,
+		MrURL:     p.MrURL,
+		URL:       p.URL,
+		CreatedAt: p.CreatedAt,
+		AgeDays:   -1,
+		SizeBytes: -1,
+	}
+	if t, err := time.Parse(time.RFC3339, p.CreatedAt); err == nil {
+		row.AgeDays = int(now.Sub(t).Hours() / 24)
+	}
+	if p.LastDeployedAt != nil {
+		row.LastDeployedAt = *p.LastDeployedAt
+	}
+	if p.LastDeployment != nil {
+		row.LastDeployStatus = p.LastDeployment.Status
+	}
+	if p.LastAccessedAt != nil {
+		row.LastAccessedAt = *p.LastAccessedAt
+	}
+	if p.SizeBytes != nil {
+		row.SizeBytes = *p.SizeBytes
+	}
+	return row
+}
+
+func writeReportCSV(rows []reportRow) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"project", "preview", "status", "branch", "mr_url", "url", "created_at", "age_days",
+		"last_deployed_at", "last_deploy_status", "last_accessed_at", "size_bytes"})
+	for _, r := range rows {
+		age, size := "", ""
+		if r.AgeDays >= 0 {
+			age = strconv.Itoa(r.AgeDays)
+		}
+		if r.SizeBytes >= 0 {
+			size = strconv.FormatInt(r.SizeBytes, 10)
+		}
+		w.Write([]string{r.Project, r.Preview, r.Status, r.Branch, r.MrURL, r.URL, r.CreatedAt, age,
+			r.LastDeployedAt, r.LastDeployStatus, r.LastAccessedAt, size})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printReportMarkdown(rows []reportRow, now time.Time) {
+	var total int64
+	running, sized := 0, 0
+	for _, r := range rows {
+		if r.SizeBytes >= 0 {
+			total += r.SizeBytes
+			sized++
+		}
+		if r.Status == "running" {
+			running++
+		}
+	}
+	fmt.Printf("## Preview inventory (%s)\n\n", now.Format("2006-01-02"))
+	fmt.Printf("%d previews, %d running", len(rows), running)
+	if sized > 0 {
+		fmt.Printf(", %s on disk", formatBytesShort(total))
+	}
+	fmt.Print(".\n\n")
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Println("| Project | Preview | Status | Branch | MR | Age | Last deploy | Size |")
+	fmt.Println("|---|---|---|---|---|---|---|---|")
+	for _, r := range rows {
+		mr := ""
+		if r.MrURL != "" {
+			mr = fmt.Sprintf("[!%s](%s)", strings.TrimPrefix(r.Preview, "mr-"), r.MrURL)
+		}
+		lastDeploy := "never"
+		if t, err := time.Parse(time.RFC3339, r.LastDeployedAt); err == nil {
+			lastDeploy = formatAge(now.Sub(t))
+			if r.LastDeployStatus != "" && r.LastDeployStatus != "success" {
+				lastDeploy += " (" + r.LastDeployStatus + ")"
+			}
+		}
+		age, size := "?", "?"
+		if r.AgeDays >= 0 {
+			age = fmt.Sprintf("%dd", r.AgeDays)
+		}
+		if r.SizeBytes >= 0 {
+			size = formatBytesShort(r.SizeBytes)
+		}
+		fmt.Printf("| %s | [%s](%s) | %s | %s | %s | %s | %s | %s |\n",
+			r.Project, r.Preview, r.URL, r.Status, markdownCell(r.Branch), mr, age, lastDeploy, size)
+	}
+}
+
+// markdownCell escapes s for a markdown table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportProject, "project", "", "Only report the previews of this project")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "markdown", "Output format: csv, json or markdown")
+	rootCmd.AddCommand(reportCmd)
+}
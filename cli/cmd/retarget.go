@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var retargetBranch string
+var retargetMR int
+
+var retargetCmd = &cobra.Command{
+	Use:     "retarget PROJECT/PREVIEW-NAME (--mr ID | --branch BRANCH)",
+	Aliases: []string{"rename"},
+	Short:   "Move a preview to another branch or MR, keeping its data",
+	Long: `Re-associate an existing preview with another merge request or branch,
+keeping its database and files, e.g. when an MR is replaced by one from a new
+branch and testers shouldn't lose the content they seeded.
+
+With --mr, the preview is renamed mr-ID and deploys the source branch of
+that MR; no preview of that MR may exist yet. With --branch, a branch
+preview is renamed after the new branch, while an MR preview keeps its name.
+The preview is then rebuilt from its new branch with the update deploy
+steps, as after a push. Renaming changes its URL.
+
+Examples:
+  preview retarget drupal-test/mr-5 --mr 9
+  preview retarget drupal-test/branch-feature-x --branch feature/x-v2`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (retargetBranch == "") == (retargetMR == 0) {
+			return fmt.Errorf("set either --mr or --branch")
+		}
+		if retargetMR < 0 {
+			return fmt.Errorf("--mr must be positive")
+		}
+		project, previewName, err := parsePreviewName(args[0])
+		if err != nil {
+			return err
+		}
+		if err := requireRetarget(); err != nil {
+			return err
+		}
+
+		target := "branch " + retargetBranch
+		if retargetMR != 0 {
+			target = fmt.Sprintf("MR !%d", retargetMR)
+		}
+		ok, err := confirm(fmt.Sprintf("Stop %s/%s and move it to %s?", project, previewName, target))
+		if err != nil || !ok {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Moving %s/%s to %s...\n", project, previewName, target)
+		result, err := apiClient.RetargetPreview(cmd.Context(), project, previewName, client.RetargetRequest{
+			Branch: retargetBranch,
+			MrIID:  retargetMR,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s/%s now deploys %s at %s; rebuilding.\n", project, result.PreviewName, result.Branch, shortSHA(result.CommitSHA))
+		if result.PreviewName != previewName {
+			fmt.Printf("New URL: %s\n", result.URL)
+		}
+		return nil
+	},
+}
+
+func init() {
+	retargetCmd.Flags().StringVar(&retargetBranch, "branch", "", "Branch to deploy from now on")
+	retargetCmd.Flags().IntVar(&retargetMR, "mr", 0, "Merge request to move the preview to, as mr-ID")
+	rootCmd.AddCommand(retargetCmd)
+}
@@ -1,22 +1,54 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/preview-manager/cli/internal/client"
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
 )
 
-var apiClient *client.Client
+var apiClient client.API
+
+// noAuthAnnotation set to "true" in the Annotations of a command runs it
+// without the API URL and the login other commands require.
+const noAuthAnnotation = "noAuth"
+
+// previewPrefer is the kind of preview, mr or branch, taken when the
+// current branch has several (--prefer).
+var previewPrefer string
+
+// serverLogLevel is sent with every request (--server-log-level); with
+// debug, the server adds the log of the operations an action runs to its
+// output.
+var serverLogLevel string
+
+// noRateLimitRetry makes requests the server rate-limits fail at once
+// instead of being retried after its Retry-After (--no-rate-limit-retry).
+var noRateLimitRetry bool
+
+// caCertFile is the CA certificate file of --ca-cert, which overrides the
+// ca_cert of the config.
+var caCertFile string
+
+// httpTransport sends every HTTP request of the CLI. It goes through the
+// proxy of HTTPS_PROXY and NO_PROXY and trusts the CA certificate of
+// --ca-cert or ca_cert; nil until setupTransport, which is the default
+// transport.
+var httpTransport http.RoundTripper
 
 // Version is set by main.go from the embedded VERSION file.
 var Version = "dev"
@@ -24,20 +56,51 @@ var Version = "dev"
 var rootCmd = &cobra.Command{
 	Use:     "preview",
 	Short:   "Preview Manager CLI",
-	Long:    "CLI tool to manage Drupal preview environments.\n\nRun 'preview login' to authenticate.",
+	Long:    "CLI tool to manage Drupal preview environments.\n\nRun 'preview login' to authenticate.\nDocumentation: {{docs_url}}",
 	Version: Version,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if serverLogLevel != "" && serverLogLevel != "info" && serverLogLevel != "debug" {
+			fmt.Fprintf(os.Stderr, "Error: invalid --server-log-level %q: expected info or debug\n", serverLogLevel)
+			os.Exit(1)
+		}
+		if progressFormat != "text" && progressFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid --progress %q: expected text or json\n", progressFormat)
+			os.Exit(1)
+		}
+		if previewPrefer != "" && previewPrefer != "mr" && previewPrefer != "branch" {
+			fmt.Fprintf(os.Stderr, "Error: invalid --prefer %q: expected mr or branch\n", previewPrefer)
+			os.Exit(1)
+		}
 		cfg := loadConfig()
+		if err := setupTransport(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := setupSpoolDir(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		noAuth := cmd.Annotations[noAuthAnnotation] == "true"
+
+		// Set up a new CLI interactively instead of failing
+		if !noAuth && cfg.APIURL == "" && isInteractive() {
+			cfg = firstRun(cmd)
+		}
 
 		// Refresh version cache if stale (every 24h, max 1.5s)
 		if cfg.APIURL != "" {
 			refreshVersionCache(&cfg)
-			printVersionWarning(cfg)
+			checkRequiredVersion(cmd, cfg)
+			if cmd != versionCmd {
+				printVersionWarning(cfg)
+			}
+			refreshCapabilitiesCache(&cfg)
+			serverCaps = cachedCapabilities(cfg)
 		}
+		pruneResponseCache(&cfg)
 
-		// Commands that don't require auth
-		name := cmd.Name()
-		if name == "setup" || name == "api" || name == "project" || name == "login" || name == "logout" || name == "help" || name == "completion" || name == "self-update" {
+		if noAuth {
 			return
 		}
 
@@ -46,23 +109,206 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 		if cfg.Token == "" {
-			fmt.Fprintln(os.Stderr, "Not authenticated. Register this CLI by running:\n")
-			fmt.Fprintln(os.Stderr, "  preview login\n")
+			fmt.Fprint(os.Stderr, "Not authenticated. Register this CLI by running:\n\n")
+			fmt.Fprint(os.Stderr, "  preview login\n\n")
 			fmt.Fprintln(os.Stderr, "This will open a browser to authorize the CLI with your preview server.")
 			os.Exit(1)
 		}
-		apiClient = client.New(cfg.APIURL, cfg.Token)
+		apiClient = newClient(cfg)
 	},
 }
 
+// newClient returns an API client for the configured server that reports
+// upload progress on stderr.
+func newClient(cfg config) *client.Client {
+	c := client.New(cfg.APIURL, cfg.Token)
+	c.HTTPClient.Transport = httpTransport
+	c.OnTokenRefresh = func(token, refreshToken string) {
+		// Reload in case the config changed since the client was created.
+		cfg := loadConfig()
+		cfg.Token, cfg.RefreshToken = token, refreshToken
+		if err := saveConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save refreshed token: %v\n", err)
+		}
+	}
+	c.LogLevel = serverLogLevel
+	c.Progress = os.Stderr
+	c.SpoolDir = spoolDir
+	if noRateLimitRetry {
+		c.RateLimitRetries = 0
+	}
+	if jsonProgress() {
+		c.OnProgress = emitProgress
+	}
+	if cache, ok := responseCache(); ok {
+		// Entries are per user, and only kept when the user is known
+		c.Cache, c.CacheUser = cache, cfg.User
+	}
+	c.OnUploadComplete = func(s client.UploadStats) { lastUpload = &s }
+	caps := cachedCapabilities(cfg)
+	if caps == nil || caps.Orgs {
+		// Servers without organizations would ignore it
+		c.Org = cfg.Org
+	}
+	if caps == nil || caps.TokenRefresh {
+		// Servers with non-expiring tokens have nothing to refresh them with
+		c.RefreshToken = cfg.RefreshToken
+	}
+	if caps != nil {
+		if caps.MaxChunkSize > 0 && caps.MaxChunkSize < c.ChunkSize {
+			c.ChunkSize = caps.MaxChunkSize
+		}
+		// Upload dumps and archives while they are generated
+		c.StreamChunks = caps.StreamingUpload
+		// Servers counting chunks on completion accept them of any size
+		c.AdaptChunkSize = caps.StreamingUpload
+		c.MinChunkSize, c.MaxChunkSize = caps.MinChunkSize, caps.MaxChunkSize
+	}
+	return c
+}
+
+// newPublicClient returns a client for the unauthenticated endpoints of
+// the server at apiURL (login, version, capabilities).
+func newPublicClient(apiURL string) *client.Client {
+	c := client.New(apiURL, "")
+	c.HTTPClient.Transport = httpTransport
+	if noRateLimitRetry {
+		c.RateLimitRetries = 0
+	}
+	return c
+}
+
+// setupTransport sets httpTransport up for the CA certificate and
+// insecure_skip_verify of cfg and --ca-cert.
+func setupTransport(cfg config) error {
+	caCert := caCertFile
+	if caCert == "" {
+		caCert = cfg.CACert
+	}
+	if caCert == "" && !cfg.InsecureSkipVerify {
+		return nil
+	}
+	t, err := client.NewTransport(client.TLSOptions{CACertFile: caCert, InsecureSkipVerify: cfg.InsecureSkipVerify})
+	if err != nil {
+		return err
+	}
+	if cfg.InsecureSkipVerify {
+		fmt.Fprintf(os.Stderr, "Warning: TLS certificates aren't verified (insecure_skip_verify in %s)\n", configPath())
+	}
+	httpTransport = t
+	return nil
+}
+
 // SetVersion sets the version for the CLI (called from main with embedded VERSION file).
 func SetVersion(v string) {
 	Version = v
 	rootCmd.Version = v
 }
 
+// printAPIVersionHelp explains how to get a CLI that speaks the server's
+// API. self-update installs the CLI published by the server, so it fixes
+// both a too old and a too new CLI.
+func printAPIVersionHelp(err *client.APIVersionError) {
+	age, fix := "old", "upgrade"
+	if !err.ClientTooOld() {
+		age, fix = "new", "downgrade"
+	}
+	fmt.Fprintf(os.Stderr, "Your preview CLI (v%s) is too %s for this server.\n", Version, age)
+	if err.CLIVersion != "" {
+		fmt.Fprintf(os.Stderr, "Run 'preview self-update' to %s to v%s, the version the server supports.\n", fix, err.CLIVersion)
+	} else {
+		fmt.Fprintf(os.Stderr, "Run 'preview self-update' to %s to the version the server supports.\n", fix)
+	}
+	if !err.ClientTooOld() {
+		fmt.Fprintln(os.Stderr, "Alternatively, ask your administrator to upgrade the preview server.")
+	}
+}
+
+// printTLSErrorHelp tells how to connect when the certificate of the
+// server isn't trusted.
+func printTLSErrorHelp(err *client.TLSError) {
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "If a proxy on your network intercepts TLS, pass its CA certificate with --ca-cert FILE")
+	fmt.Fprintf(os.Stderr, "or set \"ca_cert\": \"FILE\" in %s.\n", configPath())
+}
+
+// errorHints are the remediations of the server's error codes, for errors
+// that come without a hint.
+var errorHints = map[string]string{
+	"forbidden":      "Your role doesn't allow this; ask a server admin for access (see 'preview members').",
+	"not_found":      "Check the project and preview names; 'preview list' shows those you can access.",
+	"too_large":      "The upload is larger than the server accepts; ask a server admin to raise the limit.",
+	"rate_limited":   "Too many requests; wait a minute and try again.",
+	"internal_error": "This is a server bug; report it to the server admin with the request ID.",
+	"upstream_error": "The server couldn't reach GitLab or another service it depends on; try again later.",
+	"unavailable":    "The server is busy or restarting; try again in a minute.",
+}
+
+// statusErrorCodes are the error codes of servers that send none.
+var statusErrorCodes = map[int]string{
+	403: "forbidden",
+	404: "not_found",
+	413: "too_large",
+	429: "rate_limited",
+	500: "internal_error",
+	502: "upstream_error",
+	503: "unavailable",
+}
+
+// printHTTPErrorHelp tells how to fix err and prints its request ID for
+// support.
+func printHTTPErrorHelp(err *client.HTTPError) {
+	hint := err.Hint
+	if hint == "" {
+		code := err.Code
+		if code == "" {
+			code = statusErrorCodes[err.StatusCode]
+		}
+		hint = errorHints[code]
+		if code == "rate_limited" && err.RetryAfter > 0 {
+			hint = fmt.Sprintf("Too many requests; try again in %s.", err.RetryAfter.Round(time.Second))
+		}
+	}
+	if hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+		if cfg := loadConfig(); cfg.Help != nil && cfg.CapabilitiesURL == cfg.APIURL {
+			fmt.Fprintf(os.Stderr, "Documentation: %s\n", serverHelp(cfg).DocsURL)
+		}
+	}
+	if err.RequestID != "" {
+		fmt.Fprintf(os.Stderr, "Request ID: %s (include it when reporting this error)\n", err.RequestID)
+	}
+}
+
 func Execute() {
+	args, err := expandAliases(os.Args[1:], loadConfig().Aliases)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.SetArgs(args)
+	// Before running, as --help doesn't run PersistentPreRun
+	applyServerHelp(rootCmd, serverHelp(loadConfig()))
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, client.ErrNotAuthenticated) {
+			fmt.Fprintln(os.Stderr, "Your token may be expired or revoked. Re-authenticate by running:")
+			fmt.Fprint(os.Stderr, "\n  preview login\n\n")
+		}
+		var verr *client.APIVersionError
+		if errors.As(err, &verr) {
+			printAPIVersionHelp(verr)
+		}
+		var httpErr *client.HTTPError
+		if errors.As(err, &httpErr) {
+			printHTTPErrorHelp(httpErr)
+		}
+		var tlsErr *client.TLSError
+		if errors.As(err, &tlsErr) {
+			printTLSErrorHelp(tlsErr)
+		}
 		os.Exit(1)
 	}
 }
@@ -85,44 +331,99 @@ func refreshVersionCache(cfg *config) {
 		return
 	}
 
-	httpClient := &http.Client{Timeout: 1500 * time.Millisecond}
-	resp, err := httpClient.Get(strings.TrimSuffix(cfg.APIURL, "/") + "/api/cli/version")
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
 
-	if resp.StatusCode != http.StatusOK {
+	info, err := newPublicClient(cfg.APIURL).GetVersionInfo(ctx, cfg.Channel)
+	if err != nil || info.Version == "" {
 		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	cfg.LatestVersion = info.Version
+	cfg.RequiredCLIVersion = info.RequiredVersion
+	cfg.LastVersionCheck = time.Now().Unix()
+	saveConfig(*cfg)
+}
+
+// responseCache returns the cache of API responses, in $XDG_CACHE_HOME on
+// Linux and %LocalAppData% on Windows.
+func responseCache() (client.DirCache, bool) {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		return
+		return "", false
 	}
+	return client.DirCache(filepath.Join(dir, "preview-manager")), true
+}
 
-	var result struct {
-		Version string `json:"version"`
+// pruneResponseCache removes the API responses not used for 30 days, e.g.
+// those of a former login, once a day.
+func pruneResponseCache(cfg *config) {
+	if cfg.LastCachePrune > 0 && time.Since(time.Unix(cfg.LastCachePrune, 0)) < 24*time.Hour {
+		return
 	}
-	if err := json.Unmarshal(body, &result); err != nil || result.Version == "" {
+	cache, ok := responseCache()
+	if !ok || cache.Prune(30*24*time.Hour) != nil {
 		return
 	}
-
-	cfg.LatestVersion = result.Version
-	cfg.LastVersionCheck = time.Now().Unix()
+	cfg.LastCachePrune = time.Now().Unix()
 	saveConfig(*cfg)
 }
 
+// configPath returns the config file: ~/.preview-manager.json, or on
+// Windows preview-manager\config.json in %AppData%, unless a
+// ~/.preview-manager.json of an earlier version is there.
 func configPath() string {
 	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".preview-manager.json")
+	legacy := filepath.Join(home, ".preview-manager.json")
+	if runtime.GOOS != "windows" {
+		return legacy
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return legacy
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return filepath.Join(dir, "preview-manager", "config.json")
 }
 
 type config struct {
-	APIURL           string `json:"api_url"`
-	Token            string `json:"token,omitempty"`
+	APIURL       string `json:"api_url"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Org          string `json:"org,omitempty"`
+	// User is the email of the logged in user, which keys the cached API
+	// responses, see responseCache
+	User             string `json:"user,omitempty"`
 	LastVersionCheck int64  `json:"last_version_check,omitempty"`
 	LatestVersion    string `json:"latest_version,omitempty"`
+	// RequiredCLIVersion is the oldest CLI the server allows, see
+	// checkRequiredVersion
+	RequiredCLIVersion string `json:"required_cli_version,omitempty"`
+	// Channel is the release channel self-update follows: stable (empty)
+	// or beta
+	Channel string `json:"channel,omitempty"`
+	// Aliases are user-defined shortcuts, name to command line
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Hooks are local commands run on events for all projects, see hooksCmd
+	Hooks map[string][]string `json:"hooks,omitempty"`
+	// TrustedHooks are the SHA-256 of the hooks files of projects trusted
+	// to run, by path, see hooksTrustCmd
+	TrustedHooks map[string]string `json:"trusted_hooks,omitempty"`
+	// CACert is a PEM file of CA certificates to trust, for servers behind
+	// a proxy that intercepts TLS; InsecureSkipVerify trusts any certificate
+	CACert             string `json:"ca_cert,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	// SpoolDir is where large temporary files go, see spoolDir
+	SpoolDir string `json:"spool_dir,omitempty"`
+
+	Capabilities          *client.Capabilities `json:"capabilities,omitempty"`
+	CapabilitiesURL       string               `json:"capabilities_url,omitempty"`
+	LastCapabilitiesCheck int64                `json:"last_capabilities_check,omitempty"`
+	LastCachePrune        int64                `json:"last_cache_prune,omitempty"`
+	// Help holds the help values of the server, cached with its capabilities
+	Help *client.CLIHelp `json:"help,omitempty"`
 }
 
 func loadConfig() config {
@@ -140,10 +441,28 @@ func saveConfig(cfg config) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configPath(), data, 0600)
+	path := configPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&caCertFile, "ca-cert", "", "PEM file of CA certificates to trust, e.g. of a proxy intercepting TLS (default: ca_cert of the config)")
+	rootCmd.PersistentFlags().StringVar(&previewPrefer, "prefer", "", "Preview to use when the current branch has several: mr or branch")
+	rootCmd.PersistentFlags().BoolVar(&noRateLimitRetry, "no-rate-limit-retry", false, "Fail at once when the server rate-limits a request instead of waiting and retrying it")
+	rootCmd.PersistentFlags().StringVar(&progressFormat, "progress", "text", "Transfer progress on stderr: text (bars) or json (one event per line, for tooling)")
+	rootCmd.PersistentFlags().StringVar(&spoolDirFlag, "spool-dir", "", "Directory for large temporary files such as upload spool files (default: spool_dir of the config, else the current directory for uploads and the system temp directory)")
+	rootCmd.PersistentFlags().StringVar(&serverLogLevel, "server-log-level", "", "Include the server's log of the operations run (docker compose output...) in action output: info or debug")
+
+	// The help command of cobra, added here to annotate it
+	rootCmd.InitDefaultHelpCmd()
+	for _, c := range rootCmd.Commands() {
+		if c.Name() == "help" {
+			c.Annotations = map[string]string{noAuthAnnotation: "true"}
+		}
+	}
 }
 
 // detectGitBranch returns the current git branch name.
@@ -159,20 +478,169 @@ func detectGitBranch() (string, error) {
 	return branch, nil
 }
 
-// findPreviewByBranch searches for a preview matching the given project and branch.
-func findPreviewByBranch(project, branch string) (*client.Preview, error) {
-	result, err := apiClient.ListPreviews(false)
+// findPreviewByBranch searches for a preview matching the given project and
+// branch. A branch can back several previews (an mr- and a branch- one):
+// --prefer keeps those of one kind, then the user picks one, running
+// previews first. Without a terminal, the only running one is taken, else
+// it fails listing them.
+func findPreviewByBranch(ctx context.Context, project, branch string) (*client.Preview, error) {
+	result, err := apiClient.ListPreviews(ctx, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list previews: %w", err)
 	}
 
+	var matches []client.Preview
 	for _, p := range result.Previews {
 		if p.Project == project && p.Branch == branch {
+			matches = append(matches, p)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no preview found for project %q with branch %q", project, branch)
+	}
+	if preferred := preferredPreviews(matches); len(preferred) > 0 {
+		matches = preferred
+	}
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+
+	// Running previews first, then by name
+	sort.SliceStable(matches, func(i, j int) bool {
+		if ri, rj := matches[i].Status == "running", matches[j].Status == "running"; ri != rj {
+			return ri
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	if isInteractive() {
+		return pickPreview(branch, matches)
+	}
+	if matches[0].Status == "running" && matches[1].Status != "running" {
+		fmt.Fprintf(os.Stderr, "Branch %q has %d previews; using %s, the only running one.\n", branch, len(matches), matches[0].Name)
+		return &matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, p := range matches {
+		names[i] = fmt.Sprintf("%s (%s)", p.Name, p.Status)
+	}
+	return nil, fmt.Errorf("branch %q of %s has several previews: %s\nPass --prefer mr or --prefer branch, or PROJECT/PREVIEW-NAME", branch, project, strings.Join(names, ", "))
+}
+
+// preferredPreviews returns the previews of the kind of --prefer, nil if
+// it isn't set.
+func preferredPreviews(previews []client.Preview) []client.Preview {
+	if previewPrefer == "" {
+		return nil
+	}
+	var preferred []client.Preview
+	for _, p := range previews {
+		if strings.HasPrefix(p.Name, previewPrefer+"-") {
+			preferred = append(preferred, p)
+		}
+	}
+	return preferred
+}
+
+// pickPreview asks which of the previews of branch to use. Enter takes the
+// first.
+func pickPreview(branch string, previews []client.Preview) (*client.Preview, error) {
+	fmt.Fprintf(os.Stderr, "Branch %q has several previews:\n", branch)
+	for i, p := range previews {
+		fmt.Fprintf(os.Stderr, "  %d) %s (%s)\n", i+1, p.Name, p.Status)
+	}
+	fmt.Fprint(os.Stderr, "\nSelect a preview [1]: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil && input == "" {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return &previews[0], nil
+	}
+
+	// Accept number or preview name
+	if idx, err := strconv.Atoi(input); err == nil {
+		if idx < 1 || idx > len(previews) {
+			return nil, fmt.Errorf("invalid selection: %d", idx)
+		}
+		return &previews[idx-1], nil
+	}
+	for i := range previews {
+		if previews[i].Name == input {
+			return &previews[i], nil
+		}
+	}
+	return nil, fmt.Errorf("invalid selection: %q", input)
+}
+
+// findPreview returns the preview with the given project and name.
+func findPreview(ctx context.Context, project, previewName string) (*client.Preview, error) {
+	result, err := apiClient.ListPreviews(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list previews: %w", err)
+	}
+
+	for _, p := range result.Previews {
+		if p.Project == project && p.Name == previewName {
 			return &p, nil
 		}
 	}
 
-	return nil, fmt.Errorf("no preview found for project %q with branch %q", project, branch)
+	return nil, fmt.Errorf("preview %s/%s not found", project, previewName)
+}
+
+// resolvePreviewAndArgs splits "[PROJECT/PREVIEW-NAME] args..." into the
+// target preview and the remaining args. If the first arg is not a preview
+// name, the preview is auto-detected from the git remote and current branch
+// and all args are returned. A "--" separator before the args is dropped.
+func resolvePreviewAndArgs(ctx context.Context, args []string) (string, string, []string, error) {
+	if len(args) > 0 && strings.Contains(args[0], "/") {
+		project, previewName, err := parsePreviewName(args[0])
+		if err != nil {
+			return "", "", nil, err
+		}
+		return project, previewName, dropDashDash(args[1:]), nil
+	}
+
+	project, previewName, err := detectPreview(ctx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return project, previewName, dropDashDash(args), nil
+}
+
+func dropDashDash(args []string) []string {
+	if len(args) > 0 && args[0] == "--" {
+		return args[1:]
+	}
+	return args
+}
+
+// detectPreview finds the preview bound to the current branch with preview
+// bind, else that of the git remote and current branch.
+func detectPreview(ctx context.Context) (string, string, error) {
+	branch, err := detectGitBranch()
+	if err != nil {
+		return "", "", err
+	}
+	if project, previewName, ok := boundPreview(branch); ok {
+		fmt.Fprintf(os.Stderr, "Bound preview: %s/%s\n", project, previewName)
+		return project, previewName, nil
+	}
+	slug, err := detectProjectSlug()
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Fprintf(os.Stderr, "Detected project: %s, branch: %s\n", slug, branch)
+
+	preview, err := findPreviewByBranch(ctx, slug, branch)
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Fprintf(os.Stderr, "Found preview: %s/%s\n", slug, preview.Name)
+	return slug, preview.Name, nil
 }
 
 // parsePreviewName parses "project/preview-name" into (project, previewName).
@@ -220,4 +688,7 @@ func printActionResult(result *client.ActionResult) {
 	if !result.Success && result.Error != "" {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", result.Error)
 	}
+	if !result.Success && serverLogLevel != "debug" {
+		fmt.Fprintln(os.Stderr, "Run again with --server-log-level debug to see what the server did.")
+	}
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoAuthCommands(t *testing.T) {
+	tests := map[string]bool{
+		"setup":                 true,
+		"setup api":             true,
+		"setup project":         true,
+		"setup deploy-override": true,
+		"setup team":            true,
+		"config export":         true,
+		"config import":         true,
+		"config alias":          true,
+		"login":                 true,
+		"logout":                true,
+		"help":                  true,
+		"completion":            true,
+		"self-update":           true,
+		"doctor":                true,
+		"hooks":                 true,
+		"hooks run":             true,
+		"hooks trust":           true,
+		"bind":                  true,
+		"version":               true,
+		// Named like commands that run without auth
+		"project settings": false,
+		"config share":     false,
+		"list":             false,
+	}
+	for path, want := range tests {
+		cmd, _, err := rootCmd.Find(strings.Fields(path))
+		if err != nil || cmd.CommandPath() != "preview "+path {
+			t.Fatalf("%s: found %v, %v", path, cmd, err)
+		}
+		if got := cmd.Annotations[noAuthAnnotation] == "true"; got != want {
+			t.Errorf("%s: noAuth = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var scaleMemory string
+var scaleCPUs string
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale PROJECT/PREVIEW-NAME",
+	Short: "Change the resource limits of a preview",
+	Long: `Change the memory and CPU limits of a preview's PHP container, e.g. for
+merge requests whose migrations need more than the default allotment.
+
+The new limits apply to the running container immediately and are kept when
+the preview is rebuilt. An empty value resets a limit to the default from
+preview.yml ("resources:") or the project settings.
+
+Examples:
+  preview scale drupal-test/mr-5 --memory 2g --cpus 1.5
+  preview scale drupal-test/mr-5 --memory 4g
+  preview scale drupal-test/mr-5 --memory= --cpus=`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changes := make(map[string]string)
+		if cmd.Flags().Changed("memory") {
+			changes["memory"] = scaleMemory
+		}
+		if cmd.Flags().Changed("cpus") {
+			changes["cpus"] = scaleCPUs
+		}
+		if len(changes) == 0 {
+			return fmt.Errorf("nothing to change: set --memory and/or --cpus")
+		}
+
+		project, previewName, err := parsePreviewName(args[0])
+		if err != nil {
+			return err
+		}
+		if err := requireScale(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Scaling %s/%s...\n", project, previewName)
+		result, err := apiClient.ScalePreview(cmd.Context(), project, previewName, changes)
+		if err != nil {
+			return err
+		}
+		printActionResult(result)
+		if !result.Success {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	scaleCmd.Flags().StringVar(&scaleMemory, "memory", "", "Memory limit, e.g. 512m or 2g (empty resets to the default)")
+	scaleCmd.Flags().StringVar(&scaleCPUs, "cpus", "", "CPU limit, e.g. 1.5 (empty resets to the default)")
+	rootCmd.AddCommand(scaleCmd)
+}
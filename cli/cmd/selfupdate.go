@@ -1,93 +1,101 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"runtime"
 
 	"github.com/spf13/cobra"
 )
 
+var selfUpdateChannel string
+var selfUpdateSkipSignature bool
+
 var selfUpdateCmd = &cobra.Command{
-	Use:   "self-update",
-	Short: "Update the CLI to the latest version",
-	Args:  cobra.NoArgs,
+	Use:         "self-update",
+	Short:       "Update the CLI to the latest version",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Update the CLI to the latest version published by the server.
+
+Releases are published on two channels: stable, the default, and beta,
+prereleases matching the server's own, for early adopters to try new
+features. --channel switches channel and is remembered for later updates
+and update notices ("channel" in ~/.preview-manager.json); switching back
+to stable installs the latest stable release, even if older than the beta.
+
+The binary is downloaded from the server and replaces the running one only
+once its minisign signature checks out against the release key built into
+the CLI, so a compromised server or proxy can't push its own binary.
+Unsigned releases are refused unless --insecure-skip-signature is given.
+
+Examples:
+  preview self-update
+  preview self-update --channel beta
+  preview self-update --channel stable`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg := loadConfig()
 		if cfg.APIURL == "" {
 			cfg.APIURL = defaultAPIURL
 		}
 
-		// Check latest version
-		fmt.Println("Checking for updates...")
-		versionURL := fmt.Sprintf("%s/api/cli/version", cfg.APIURL)
-		resp, err := http.Get(versionURL)
-		if err != nil {
-			return fmt.Errorf("failed to check version: %w", err)
+		if selfUpdateSkipSignature {
+			fmt.Fprintln(os.Stderr, "Warning: --insecure-skip-signature installs the downloaded binary without verifying it was released by your server admins")
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("failed to check version (HTTP %d)", resp.StatusCode)
+		switching := false
+		if cmd.Flags().Changed("channel") {
+			if selfUpdateChannel != "stable" && selfUpdateChannel != "beta" {
+				return fmt.Errorf("invalid --channel %q: expected stable or beta", selfUpdateChannel)
+			}
+			if selfUpdateChannel == "beta" {
+				if err := requireCLIChannels(); err != nil {
+					return err
+				}
+			}
+			switching = selfUpdateChannel != updateChannel(cfg)
+			cfg.Channel = selfUpdateChannel
+			if cfg.Channel == "stable" {
+				cfg.Channel = ""
+			}
 		}
+		channel := updateChannel(cfg)
 
-		body, _ := io.ReadAll(resp.Body)
-		var versionInfo struct {
-			Version string `json:"version"`
-		}
-		if err := json.Unmarshal(body, &versionInfo); err != nil {
-			return fmt.Errorf("failed to parse version: %w", err)
+		// Check latest version
+		fmt.Printf("Checking for updates (%s)...\n", channel)
+		info, err := newPublicClient(cfg.APIURL).GetVersionInfo(cmd.Context(), channel)
+		if err != nil {
+			return err
 		}
-
-		if versionInfo.Version == Version {
+		latest := info.Version
+
+		if latest == Version {
+			if switching {
+				saveConfig(cfg)
+				fmt.Printf("Switched to the %s channel, already on its latest version (v%s).\n", channel, Version)
+				return nil
+			}
 			fmt.Printf("Already up to date (v%s).\n", Version)
 			return nil
 		}
 
-		fmt.Printf("Updating v%s -> v%s...\n", Version, versionInfo.Version)
-
-		// Download install script and exec it — this replaces the current process
-		installURL := fmt.Sprintf("%s/api/cli/install.sh", cfg.APIURL)
-		scriptResp, err := http.Get(installURL)
-		if err != nil {
-			return fmt.Errorf("failed to download install script: %w", err)
-		}
-		defer scriptResp.Body.Close()
-
-		if scriptResp.StatusCode != 200 {
-			return fmt.Errorf("failed to download install script (HTTP %d)", scriptResp.StatusCode)
-		}
-
-		script, err := io.ReadAll(scriptResp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read install script: %w", err)
-		}
+		fmt.Printf("Updating v%s -> v%s...\n", Version, latest)
 
-		// Write script to temp file
-		tmpFile, err := os.CreateTemp("", "preview-install-*.sh")
+		binary, err := downloadRelease(cmd.Context(), cfg.APIURL, channel, latest)
 		if err != nil {
-			return fmt.Errorf("failed to create temp file: %w", err)
+			return err
 		}
-		tmpPath := tmpFile.Name()
-		defer os.Remove(tmpPath)
-
-		tmpFile.Write(script)
-		tmpFile.Close()
-		os.Chmod(tmpPath, 0755)
-
-		// Execute the install script (it downloads and replaces the binary)
-		sh := exec.Command("sh", tmpPath)
-		sh.Stdout = os.Stdout
-		sh.Stderr = os.Stderr
-		if err := sh.Run(); err != nil {
-			return fmt.Errorf("update failed: %w", err)
+		if err := replaceExecutable(binary); err != nil {
+			return err
 		}
+		fmt.Printf("Updated to v%s.\n", latest)
 
 		// Update cache
-		cfg.LatestVersion = versionInfo.Version
+		cfg.LatestVersion = latest
 		cfg.LastVersionCheck = 0
 		saveConfig(cfg)
 
@@ -95,6 +103,107 @@ var selfUpdateCmd = &cobra.Command{
 	},
 }
 
+// downloadRelease downloads the CLI binary of channel for this platform
+// and verifies its signature, which must be for version.
+func downloadRelease(ctx context.Context, apiURL, channel, version string) ([]byte, error) {
+	name := "preview-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	binaryURL := fmt.Sprintf("%s/api/cli/download/%s/%s?channel=%s", apiURL, runtime.GOOS, runtime.GOARCH, channel)
+	binary, err := downloadFile(ctx, binaryURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+
+	if selfUpdateSkipSignature {
+		return binary, nil
+	}
+	sigURL := fmt.Sprintf("%s/api/cli/download/%s/%s/signature?channel=%s", apiURL, runtime.GOOS, runtime.GOARCH, channel)
+	sig, err := downloadFile(ctx, sigURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the signature of %s: %w\nThe release may be unsigned; refusing to install it (--insecure-skip-signature installs it anyway)", name, err)
+	}
+	if err := verifyRelease(binary, string(sig), name, version); err != nil {
+		return nil, fmt.Errorf("refusing to install %s v%s: %w", name, version, err)
+	}
+	return binary, nil
+}
+
+// downloadFile returns the body of a GET of u.
+func downloadFile(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// replaceExecutable replaces the running binary with binary, through a
+// temporary file in its directory so it is never left half written.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".preview-update-*")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %w (reinstall with install.sh, or update with the permissions of its owner)", filepath.Dir(exe), err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable can't be replaced, but it can be moved
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			// Put the running one back rather than leave no binary at all
+			if restoreErr := os.Rename(old, exe); restoreErr != nil {
+				return fmt.Errorf("failed to replace %s: %w; the previous version is left at %s (%v)", exe, err, old, restoreErr)
+			}
+			return fmt.Errorf("failed to replace %s: %w", exe, err)
+		}
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// updateChannel returns the release channel self-update follows.
+func updateChannel(cfg config) string {
+	if cfg.Channel == "" {
+		return "stable"
+	}
+	return cfg.Channel
+}
+
 func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateSkipSignature, "insecure-skip-signature", false, "Install the release without verifying its signature")
+	selfUpdateCmd.Flags().StringVar(&selfUpdateChannel, "channel", "", "Release channel to update from and follow: stable or beta (default: the configured one, stable)")
 	rootCmd.AddCommand(selfUpdateCmd)
 }
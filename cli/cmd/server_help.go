@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+// defaultCLIHelp are the help values of a stock server, shown until those
+// of the configured server are cached.
+var defaultCLIHelp = client.CLIHelp{
+	PreviewDomain:     "{preview}-{project}.mr.preview-mr.com",
+	DocsURL:           "https://app.preview-mr.com/docs",
+	PHPVersions:       []string{"8.1", "8.2", "8.3"},
+	DefaultPHPVersion: "8.3",
+}
+
+// serverHelp returns the help values of the configured server, cached with
+// its capabilities, completed with defaultCLIHelp.
+func serverHelp(cfg config) client.CLIHelp {
+	help := defaultCLIHelp
+	if cfg.Help == nil || cfg.CapabilitiesURL != cfg.APIURL {
+		return help
+	}
+	if cfg.Help.PreviewDomain != "" {
+		help.PreviewDomain = cfg.Help.PreviewDomain
+	}
+	if cfg.Help.DocsURL != "" {
+		help.DocsURL = cfg.Help.DocsURL
+	}
+	if len(cfg.Help.PHPVersions) > 0 {
+		help.PHPVersions = cfg.Help.PHPVersions
+	}
+	if cfg.Help.DefaultPHPVersion != "" {
+		help.DefaultPHPVersion = cfg.Help.DefaultPHPVersion
+	}
+	help.Notes = cfg.Help.Notes
+	return help
+}
+
+// applyServerHelp fills the {{preview_domain}}, {{docs_url}},
+// {{php_versions}} and {{default_php_version}} placeholders of the long
+// help of cmd and its subcommands with help, and appends the notes of the
+// server admins to the commands they are for.
+func applyServerHelp(cmd *cobra.Command, help client.CLIHelp) {
+	domain := strings.NewReplacer("{project}", "PROJECT", "{preview}", "PREVIEW-NAME").Replace(help.PreviewDomain)
+	r := strings.NewReplacer(
+		"{{preview_domain}}", domain,
+		"{{docs_url}}", help.DocsURL,
+		"{{php_versions}}", strings.Join(help.PHPVersions, ", "),
+		"{{default_php_version}}", help.DefaultPHPVersion,
+	)
+	cmd.Long = r.Replace(cmd.Long)
+	path := strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()), " ")
+	if note := help.Notes[path]; note != "" {
+		cmd.Long += "\n\nNote from your server admins:\n  " + strings.ReplaceAll(strings.TrimSpace(note), "\n", "\n  ")
+	}
+	for _, sub := range cmd.Commands() {
+		applyServerHelp(sub, help)
+	}
+}
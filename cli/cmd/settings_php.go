@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// phpLine is a line of PHP source.
+type phpLine struct {
+	text string
+	// code is the line without comments; strings are kept.
+	code string
+	// depth is the brace depth at the start of the line.
+	depth int
+}
+
+// scanPHP splits src into lines, tracking comments, strings and brace
+// depth well enough for settings.php. Heredocs are not supported.
+func scanPHP(src string) []phpLine {
+	var lines []phpLine
+	var code strings.Builder
+	depth, lineDepth := 0, 0
+	var quote byte
+	blockComment, lineComment := false, false
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if c == '\n' {
+			lines = append(lines, phpLine{code: code.String(), depth: lineDepth})
+			code.Reset()
+			lineDepth = depth
+			lineComment = false
+			continue
+		}
+		switch {
+		case lineComment:
+		case blockComment:
+			if c == '*' && i+1 < len(src) && src[i+1] == '/' {
+				blockComment = false
+				i++
+			}
+		case quote != 0:
+			code.WriteByte(c)
+			if c == '\\' && i+1 < len(src) && src[i+1] != '\n' {
+				code.WriteByte(src[i+1])
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '#' || c == '/' && i+1 < len(src) && src[i+1] == '/':
+			lineComment = true
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			blockComment = true
+			i++
+		default:
+			code.WriteByte(c)
+			switch c {
+			case '\'', '"':
+				quote = c
+			case '{':
+				depth++
+			case '}':
+				depth = max(depth-1, 0)
+			}
+		}
+	}
+	lines = append(lines, phpLine{code: code.String(), depth: lineDepth})
+
+	for i, text := range strings.Split(src, "\n") {
+		lines[i].text = text
+	}
+	return lines
+}
+
+// settingsLayout is what placing the preview include in settings.php
+// depends on. Line indexes are -1 when absent.
+type settingsLayout struct {
+	lines []phpLine
+	// local is the start of the statement including settings.local.php,
+	// with the comments right above it.
+	local int
+	// databasesEnd is the line after the last $databases statement.
+	databasesEnd int
+	// closeTag is the line of a final "?>".
+	closeTag int
+	// previews are the lines including settings.preview.php.
+	previews []int
+}
+
+func analyzeSettings(src string) settingsLayout {
+	l := settingsLayout{lines: scanPHP(src), local: -1, databasesEnd: -1, closeTag: -1}
+
+	for i, line := range l.lines {
+		code := strings.TrimSpace(line.code)
+		switch {
+		case strings.Contains(code, "settings.preview.php"):
+			l.previews = append(l.previews, i)
+		case strings.Contains(code, "settings.local.php") && l.local < 0:
+			l.local = l.statementStart(i)
+		case line.depth == 0 && strings.HasPrefix(code, "$databases"):
+			end := i
+			for end < len(l.lines)-1 && !(strings.HasSuffix(strings.TrimSpace(l.lines[end].code), ";") && l.lines[end+1].depth == 0) {
+				end++
+			}
+			l.databasesEnd = end + 1
+		case line.depth == 0 && code == "?>":
+			l.closeTag = i
+		}
+	}
+	return l
+}
+
+// statementStart returns the first line of the top-level statement line i
+// belongs to, including the comment lines right above it.
+func (l settingsLayout) statementStart(i int) int {
+	for i > 0 && l.lines[i].depth > 0 {
+		i--
+	}
+	for i > 0 && strings.TrimSpace(l.lines[i-1].code) == "" && strings.TrimSpace(l.lines[i-1].text) != "" {
+		i--
+	}
+	return i
+}
+
+// insertionLine returns the line the preview include goes before: before
+// the settings.local.php include, so local overrides keep the last word,
+// unless that would put it before the database settings it overrides;
+// otherwise at the end, before a closing "?>".
+func (l settingsLayout) insertionLine() int {
+	if l.local >= 0 && l.local >= l.databasesEnd {
+		return l.local
+	}
+	if l.closeTag >= 0 {
+		return l.closeTag
+	}
+	return len(l.lines)
+}
+
+// problems describes how the existing preview includes conflict with the
+// expected single, top-level, PREV_IS_PREVIEW-guarded block.
+func (l settingsLayout) problems() []string {
+	var problems []string
+	if len(l.previews) > 1 {
+		lines := make([]string, len(l.previews))
+		for i, p := range l.previews {
+			lines[i] = fmt.Sprint(p + 1)
+		}
+		problems = append(problems, fmt.Sprintf("settings.preview.php is included %d times (lines %s); keep a single preview block", len(l.previews), strings.Join(lines, ", ")))
+	}
+	for _, p := range l.previews {
+		line := l.lines[p]
+		guard := line.code
+		if line.depth > 0 {
+			open := p - 1
+			for open > 0 && l.lines[open].depth >= line.depth {
+				open--
+			}
+			guard = l.lines[open].code
+		}
+		switch {
+		case !strings.Contains(guard, "PREV_IS_PREVIEW"):
+			problems = append(problems, fmt.Sprintf("line %d includes settings.preview.php without checking PREV_IS_PREVIEW, so it also loads outside previews", p+1))
+		case line.depth > 1:
+			problems = append(problems, fmt.Sprintf("line %d includes settings.preview.php inside another conditional, so previews may skip it", p+1))
+		}
+		if l.local >= 0 && p > l.local && l.local >= l.databasesEnd {
+			problems = append(problems, fmt.Sprintf("line %d includes settings.preview.php after settings.local.php (line %d); move the preview block above it", p+1, l.local+1))
+		}
+	}
+	return problems
+}
+
+// addPreviewInclude adds the preview include snippet to settingsPath. It
+// returns "created", "inserted" or "exists", with the problems of an
+// existing preview include, which is never rewritten.
+func addPreviewInclude(settingsPath string) (string, []string, error) {
+	data, err := os.ReadFile(settingsPath)
+	if os.IsNotExist(err) {
+		// No settings.php — create one with just the include
+		content := "<?php\n\n" + strings.TrimLeft(previewIncludeSnippet, "\n")
+		if err := os.WriteFile(settingsPath, []byte(content), 0644); err != nil {
+			return "", nil, err
+		}
+		return "created", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	layout := analyzeSettings(string(data))
+	if len(layout.previews) > 0 {
+		return "exists", layout.problems(), nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	at := layout.insertionLine()
+	var content string
+	if at >= len(lines) {
+		content = strings.TrimRight(string(data), "\n") + "\n" + previewIncludeSnippet
+	} else {
+		before := strings.TrimRight(strings.Join(lines[:at], "\n"), "\n")
+		after := strings.Join(lines[at:], "\n")
+		content = before + "\n" + previewIncludeSnippet + "\n" + after
+	}
+	if err := os.WriteFile(settingsPath, []byte(content), 0644); err != nil {
+		return "", nil, err
+	}
+	return "inserted", nil, nil
+}
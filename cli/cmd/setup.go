@@ -7,16 +7,18 @@ import (
 )
 
 var setupCmd = &cobra.Command{
-	Use:   "setup",
-	Short: "Setup commands for CLI and project configuration",
-	Long:  "Configure the CLI or scaffold a Drupal project for preview environments.",
+	Use:         "setup",
+	Short:       "Setup commands for CLI and project configuration",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long:        "Configure the CLI or scaffold a Drupal project for preview environments.",
 }
 
 var setupAPICmd = &cobra.Command{
-	Use:   "api API_URL",
-	Short: "Configure the API URL",
-	Long:  "Save the API URL to ~/.preview-manager.json so you don't need --api-url every time.",
-	Args:  cobra.ExactArgs(1),
+	Use:         "api API_URL",
+	Short:       "Configure the API URL",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long:        "Save the API URL to the config file (~/.preview-manager.json, or preview-manager\\config.json in %AppData% on Windows) so you don't need --api-url every time.",
+	Args:        cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg := loadConfig()
 		cfg.APIURL = args[0]
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateVersion is the version of the setup project templates. Bump it
+// when a template changes, and have templateBase return the previous
+// rendering so files generated from it can still be merged.
+const templateVersion = 3
+
+// templateTag marks the files setup project generates with the template
+// version. Files without it predate template versioning (version 1).
+var templateTag = fmt.Sprintf("Generated by 'preview setup project' (template v%d).", templateVersion)
+
+var templateTagPattern = regexp.MustCompile(`Generated by 'preview setup project' \(template v(\d+)\)\.`)
+
+// fileTemplateVersion returns the template version content was generated
+// from, and false if it has none.
+func fileTemplateVersion(content string) (int, bool) {
+	m := templateTagPattern.FindStringSubmatch(content)
+	if m == nil {
+		return 0, false
+	}
+	version, err := strconv.Atoi(m[1])
+	return version, err == nil
+}
+
+// templateBase returns the rendering of template version of the file
+// whose latest rendering is latest, and false if this CLI doesn't know it.
+func templateBase(version int, latest string) (string, bool) {
+	switch version {
+	case templateVersion:
+		return latest, true
+	case 2:
+		// v3 added the secondary databases and custom domains
+		base := strings.Replace(latest, templateTag, "Generated by 'preview setup project' (template v2).", 1)
+		for _, added := range []string{settingsPreviewDatabasesDoc, settingsPreviewCustomDomainsDoc, settingsPreviewCustomDomains} {
+			base = strings.Replace(base, added, "", 1)
+		}
+		return secondaryDatabasesBlockPattern.ReplaceAllString(base, ""), true
+	}
+	return "", false
+}
+
+// secondaryDatabasesBlockPattern matches the blocks about secondary
+// databases of settings.preview.php and preview.yml, up to the blank line
+// ending them.
+var secondaryDatabasesBlockPattern = regexp.MustCompile(`(?s)(// Secondary databases \(preview\.yml "databases:"\)|# Secondary databases besides the main one).*?\n\n`)
+
+// templateFile is a file setup project generates, with its latest
+// template.
+type templateFile struct {
+	path, latest string
+}
+
+// setupTemplates returns the files setup project generates for sites, with
+// the templates they would be generated from now. Settings written for a
+// Stage File Proxy origin keep it unless --stage-file-proxy is given.
+func setupTemplates(docroot string, sites []string) []templateFile {
+	var files []templateFile
+	for _, site := range sites {
+		path := filepath.Join(docroot, "sites", site, "settings.preview.php")
+		origin := stageFileProxyOrigin
+		if origin == "" {
+			if data, err := os.ReadFile(path); err == nil {
+				origin = stageFileProxyOriginOf(string(data))
+			}
+		}
+		files = append(files, templateFile{path, settingsPreviewTemplate(origin, site)})
+	}
+	files = append(files, templateFile{"preview.yml", previewYmlTemplate(stageFileProxyOrigin != "" || previewYmlProxiesFiles(), sites)})
+	for _, phase := range []string{"new", "update"} {
+		files = append(files, templateFile{filepath.Join("scripts", "preview", phase, "deploy.sh"), deployScriptTemplate(phase, sites)})
+	}
+	return files
+}
+
+var stageFileProxyOriginPattern = regexp.MustCompile(`\['stage_file_proxy\.settings'\]\['origin'\] = '((?:[^'\\]|\\.)*)';`)
+
+// stageFileProxyOriginOf returns the Stage File Proxy origin
+// settings.preview.php content sets, or "".
+func stageFileProxyOriginOf(content string) string {
+	m := stageFileProxyOriginPattern.FindStringSubmatch(content)
+	if m == nil {
+		return ""
+	}
+	return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(m[1])
+}
+
+// diffSetupProject shows how the generated files differ from the latest
+// templates and, with merge, merges the template updates into them.
+func diffSetupProject(docroot string, sites []string, merge bool) error {
+	latestName := fmt.Sprintf("template v%d", templateVersion)
+	var outdated, merged, conflicted []string
+	for _, f := range setupTemplates(docroot, sites) {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			fmt.Printf("· %s — missing; 'preview setup project' creates it\n\n", f.path)
+			continue
+		}
+		current := string(data)
+		if current == f.latest {
+			fmt.Printf("✓ %s — same as the latest template\n\n", f.path)
+			continue
+		}
+
+		version, versioned := fileTemplateVersion(current)
+		if versioned && version > templateVersion {
+			fmt.Printf("⚠ %s — generated from template v%d, newer than this CLI knows; run 'preview self-update'\n\n", f.path, version)
+			continue
+		}
+		base, known := "", false
+		if versioned {
+			base, known = templateBase(version, f.latest)
+		}
+		if !known {
+			if versioned {
+				fmt.Printf("⚠ %s — generated from template v%d, which this CLI no longer has.\n", f.path, version)
+			} else {
+				fmt.Printf("⚠ %s — generated before templates were versioned.\n", f.path)
+			}
+			fmt.Println("  Your changes and the template updates can't be told apart; compared with the latest template:")
+			fmt.Println()
+			fmt.Print(unifiedDiff(f.path, latestName, current, f.latest))
+			fmt.Println()
+			continue
+		}
+		baseName := fmt.Sprintf("template v%d", version)
+		if base == f.latest {
+			fmt.Printf("✓ %s — up to date with the template; your changes:\n\n", f.path)
+			fmt.Print(unifiedDiff(baseName, f.path, base, current))
+			fmt.Println()
+			continue
+		}
+
+		fmt.Printf("⚠ %s — generated from template v%d. Template updates:\n\n", f.path, version)
+		fmt.Print(unifiedDiff(baseName, latestName, base, f.latest))
+		if mine := unifiedDiff(baseName, f.path, base, current); mine != "" {
+			fmt.Println()
+			fmt.Println("  Your changes:")
+			fmt.Println()
+			fmt.Print(mine)
+		}
+		fmt.Println()
+		outdated = append(outdated, f.path)
+		if !merge {
+			continue
+		}
+		result, conflicts, err := mergeTemplate(f.path, baseName, latestName, current, base, f.latest)
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", f.path, err)
+		}
+		if err := os.WriteFile(f.path, []byte(result), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+		if conflicts {
+			conflicted = append(conflicted, f.path)
+		} else {
+			merged = append(merged, f.path)
+		}
+	}
+
+	if len(merged) > 0 {
+		fmt.Printf("Merged the template updates into %s.\n", strings.Join(merged, ", "))
+	}
+	if len(conflicted) > 0 {
+		fmt.Printf("Merged with conflicts: %s. Resolve the <<<<<<< markers by hand.\n", strings.Join(conflicted, ", "))
+		os.Exit(1)
+	}
+	if !merge && len(outdated) > 0 {
+		fmt.Println("Run 'preview setup project --merge' to apply the template updates, or --override to replace the files.")
+	}
+	return nil
+}
+
+// mergeTemplate merges the changes from base to latest into current with
+// git merge-file, labelling the sides path, baseName and latestName. It
+// reports whether the result has conflict markers.
+func mergeTemplate(path, baseName, latestName, current, base, latest string) (string, bool, error) {
+	dir, err := os.MkdirTemp("", "preview-merge-*")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for i, content := range []string{current, base, latest} {
+		name := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			return "", false, err
+		}
+		files = append(files, name)
+	}
+	args := append([]string{"merge-file", "-p", "-L", path, "-L", baseName, "-L", latestName}, files...)
+	out, err := exec.Command("git", args...).Output()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return string(out), false, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128:
+		// The exit status is the number of conflicts
+		return string(out), true, nil
+	default:
+		return "", false, err
+	}
+}
+
+// unifiedDiff returns the lines changed from a to b in unified diff format
+// with three lines of context, or "" if they are the same.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	from := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	to := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type op struct {
+		kind byte
+		line string
+		i, j int
+	}
+	var ops []op
+	for i, j := 0, 0; i < len(from) || j < len(to); {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			ops = append(ops, op{' ', from[i], i, j})
+			i++
+			j++
+		case i < len(from) && (j == len(to) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', from[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', to[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// Extend the hunk over changes less than two contexts apart
+		end := start + 1
+		for k := end; k < len(ops) && k <= end+2*context; k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			}
+		}
+		first, last := max(start-context, 0), min(end+context, len(ops))
+		fromCount, toCount := 0, 0
+		for _, o := range ops[first:last] {
+			if o.kind != '+' {
+				fromCount++
+			}
+			if o.kind != '-' {
+				toCount++
+			}
+		}
+		fromStart, toStart := ops[first].i, ops[first].j
+		if fromCount > 0 {
+			fromStart++
+		}
+		if toCount > 0 {
+			toStart++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, o := range ops[first:last] {
+			fmt.Fprintf(&out, "%c%s\n", o.kind, o.line)
+		}
+		start = last
+	}
+	return out.String()
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var overrideMR int
+var overridePhase string
+
+var setupDeployOverrideCmd = &cobra.Command{
+	Use:         "deploy-override",
+	Short:       "Create a per-MR deploy script override",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Create scripts/preview/PHASE/mr-ID-deploy.sh, which runs instead of the
+deploy script of preview.yml for that merge request only, starting from a
+copy of the project script. It is then opened in $VISUAL or $EDITOR.
+
+Run this command from the root of your Drupal project. Commit the script to
+the MR branch for it to take effect; try it on the preview with
+'preview deploy run PROJECT/mr-ID --phase PHASE'. Remove it before merging.
+
+Examples:
+  preview setup deploy-override --mr 123 --phase new
+  preview setup deploy-override --mr 123`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if overrideMR <= 0 {
+			return fmt.Errorf("--mr is required, e.g. --mr 123")
+		}
+		if overridePhase != "new" && overridePhase != "update" {
+			return fmt.Errorf("invalid --phase %q: expected new or update", overridePhase)
+		}
+		if _, err := os.Stat("preview.yml"); err != nil {
+			return fmt.Errorf("preview.yml not found — run this command from the project root (see 'preview setup project')")
+		}
+
+		scriptDir := filepath.Join("scripts", "preview", overridePhase)
+		scriptPath := filepath.Join(scriptDir, fmt.Sprintf("mr-%d-deploy.sh", overrideMR))
+		if _, err := os.Stat(scriptPath); err == nil {
+			fmt.Printf("  · %s — already exists\n", scriptPath)
+		} else {
+			content := deployScriptContent(overridePhase)
+			source := previewYmlDeployScript(overridePhase)
+			if source != "" {
+				data, err := os.ReadFile(source)
+				if err != nil {
+					return fmt.Errorf("failed to read the %s deploy script: %w", overridePhase, err)
+				}
+				content = string(data)
+			}
+
+			if err := os.MkdirAll(scriptDir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", scriptDir, err)
+			}
+			if err := os.WriteFile(scriptPath, []byte(content), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", scriptPath, err)
+			}
+			if source != "" {
+				fmt.Printf("  ✓ %s — copied from %s\n", scriptPath, source)
+			} else {
+				fmt.Printf("  ✓ %s — created from the template (preview.yml sets no %s script)\n", scriptPath, overridePhase)
+			}
+		}
+
+		if err := openInEditor(scriptPath); err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Printf("Commit %s to the MR branch; it replaces the %s deploy script\n", scriptPath, overridePhase)
+		fmt.Printf("of mr-%d only. Remember to remove it before the MR is merged:\n", overrideMR)
+		fmt.Println()
+		fmt.Printf("  git rm %s\n", filepath.ToSlash(scriptPath))
+		return nil
+	},
+}
+
+// previewYmlDeployScript returns the deploy script preview.yml in the
+// current directory sets for phase, or "" if there is none.
+func previewYmlDeployScript(phase string) string {
+	deploy := loadPreviewYml().Deploy
+	if phase == "new" {
+		return deploy.New
+	}
+	return deploy.Update
+}
+
+// openInEditor opens path in $VISUAL or $EDITOR and waits for it to exit.
+// Nothing happens when neither is set or not running in a terminal.
+func openInEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" || !isInteractive() {
+		return nil
+	}
+
+	// The editor may come with arguments, e.g. "code --wait"
+	fields := strings.Fields(editor)
+	c := exec.Command(fields[0], append(fields[1:], path)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", fields[0], err)
+	}
+	return nil
+}
+
+func init() {
+	setupDeployOverrideCmd.Flags().IntVar(&overrideMR, "mr", 0, "Merge request ID the override applies to")
+	setupDeployOverrideCmd.Flags().StringVar(&overridePhase, "phase", "update", "Deploy phase to override: new or update")
+	setupCmd.AddCommand(setupDeployOverrideCmd)
+}
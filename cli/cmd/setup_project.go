@@ -4,40 +4,143 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var overrideFlag bool
+var checkFlag bool
+var diffFlag bool
+var mergeFlag bool
+var stageFileProxyOrigin string
+var setupSites []string
+var setupDatabases []string
 
 var setupProjectCmd = &cobra.Command{
-	Use:   "project",
-	Short: "Scaffold a Drupal project for preview environments",
+	Use:         "project",
+	Short:       "Scaffold a Drupal project for preview environments",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
 	Long: `Creates the necessary files for preview compatibility:
 
-  1. Adds a preview include snippet to web/sites/default/settings.php
-  2. Creates web/sites/default/settings.preview.php with DB config
+  1. Adds a preview include snippet to web/sites/SITE/settings.php
+  2. Creates web/sites/SITE/settings.preview.php with DB config
   3. Creates preview.yml template in the project root
   4. Creates deploy script templates in scripts/preview/
 
+The include goes after the database settings and before the
+settings.local.php include. An existing preview include is never rewritten;
+conflicts, like a second include or one missing the PREV_IS_PREVIEW check,
+are reported instead.
+
 Run this command from the root of your Drupal project.
-Use --override to overwrite existing files with the latest templates.`,
+Use --override to overwrite existing files with the latest templates.
+Use --check to report what differs from them without writing anything;
+it exits with a non-zero status if anything does.
+
+Both also check the Drupal core version and modules of composer.lock
+against the PHP version, database and services of preview.yml, and warn
+about combinations previews can't run (see 'preview doctor').
+
+Generated files record the version of their template. --diff shows how
+each differs from the latest template: your changes, and the template
+updates since the version it was generated from. --merge applies those
+updates to your files, keeping your changes; lines changed on both sides
+are left with conflict markers to resolve by hand. Files generated before
+templates were versioned can only be compared with the latest template.
+
+With --stage-file-proxy URL, previews fetch files from production on demand
+through the Stage File Proxy module instead of using a base files archive:
+settings.preview.php points the module at URL and preview.yml sets
+"files: stage-file-proxy", so 'preview push files' is not needed.
+
+With --template-source, the files are generated from the templates of your
+organization instead of the built-in ones: a template set hosted on the
+preview server (see 'preview setup templates'), a git repository URL,
+optionally with #BRANCH, or a local directory. Files the source has no
+template for use the built-in one.
+
+For a Drupal multisite, --sites lists the directories under sites/ to set
+up (default: the sites of preview.yml, or just "default"). Each site gets
+its own settings.preview.php, and preview.yml lists them under "sites:" so
+'preview push files' packages the files of every site.
+
+--databases lists secondary databases previews get besides the main one,
+such as the source database of a migration (default: those of preview.yml).
+preview.yml lists them under "databases:", and settings.preview.php
+connects $databases['NAME'] from the PREV_DB_<NAME>_* env vars. Upload
+their dumps with 'preview push db --target NAME'.
+
+Examples:
+  preview setup project
+  preview setup project --check
+  preview setup project --diff
+  preview setup project --sites default,intranet
+  preview setup project --databases migrate
+  preview setup project --stage-file-proxy https://www.example.com
+  preview setup project --template-source org-default
+  preview setup project --template-source https://gitlab.example.com/acme/preview-templates.git#main`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if templateSource != "" {
+			templates, err := loadTemplateSource(cmd.Context(), templateSource)
+			if err != nil {
+				return err
+			}
+			scaffoldTemplates = templates
+			fmt.Printf("Using the %s templates from %s.\n\n", strings.Join(sortedKeys(templates), ", "), templateSource)
+		}
 		return runSetupProject()
 	},
 }
 
 func runSetupProject() error {
+	if stageFileProxyOrigin != "" && !strings.HasPrefix(stageFileProxyOrigin, "https://") && !strings.HasPrefix(stageFileProxyOrigin, "http://") {
+		return fmt.Errorf("invalid --stage-file-proxy %q: expected the production URL, e.g. https://www.example.com", stageFileProxyOrigin)
+	}
+
 	// Verify we're in a Drupal project
 	docroot := detectDocroot()
 	if docroot == "" {
 		return fmt.Errorf("could not find web/ or docroot/ directory — are you in a Drupal project root?")
 	}
 
-	settingsDir := filepath.Join(docroot, "sites", "default")
-	if _, err := os.Stat(settingsDir); os.IsNotExist(err) {
-		return fmt.Errorf("directory %s not found — are you in a Drupal project root?", settingsDir)
+	sites := setupSites
+	if len(sites) == 0 {
+		sites = previewYmlSites()
+	}
+	for _, site := range sites {
+		if !validSiteName(site) {
+			return fmt.Errorf("invalid site %q: expected a directory name under sites/, e.g. default", site)
+		}
+		settingsDir := filepath.Join(docroot, "sites", site)
+		if _, err := os.Stat(settingsDir); os.IsNotExist(err) {
+			return fmt.Errorf("directory %s not found — are you in a Drupal project root?", settingsDir)
+		}
+	}
+	if len(setupDatabases) == 0 {
+		setupDatabases = previewYmlDatabases()
+	}
+	for _, name := range setupDatabases {
+		if !validDatabaseName(name) {
+			return fmt.Errorf("invalid database %q: expected lowercase letters, digits and underscores, e.g. migrate", name)
+		}
+	}
+
+	modes := 0
+	for _, set := range []bool{overrideFlag, checkFlag, diffFlag, mergeFlag} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("--override, --check, --diff and --merge can't be used together")
+	}
+	if checkFlag {
+		return checkSetupProject(docroot, sites)
+	}
+	if diffFlag || mergeFlag {
+		return diffSetupProject(docroot, sites, mergeFlag)
 	}
 
 	fmt.Println("Setting up preview environment files...")
@@ -50,47 +153,57 @@ func runSetupProject() error {
 		fmt.Println()
 	}
 
-	// 1. Add include snippet to settings.php
-	settingsPath := filepath.Join(settingsDir, "settings.php")
-	result, err := appendPreviewInclude(settingsPath)
-	if err != nil {
-		fmt.Printf("  ⚠ %s — could not write (permission denied)\n", settingsPath)
-		fmt.Println()
-		fmt.Println("  Add the following snippet manually to the end of your settings.php:")
-		fmt.Println()
-		for _, line := range strings.Split(strings.TrimSpace(previewIncludeSnippet), "\n") {
-			fmt.Printf("    %s\n", line)
+	// 1-2. Add the include snippet to settings.php and create
+	// settings.preview.php, for each site
+	for _, site := range sites {
+		settingsDir := filepath.Join(docroot, "sites", site)
+		settingsPath := filepath.Join(settingsDir, "settings.php")
+		result, problems, err := addPreviewInclude(settingsPath)
+		if err != nil {
+			fmt.Printf("  ⚠ %s — could not write (permission denied)\n", settingsPath)
+			fmt.Println()
+			fmt.Println("  Add the following snippet manually to your settings.php, after the")
+			fmt.Println("  database settings and before the settings.local.php include:")
+			fmt.Println()
+			for _, line := range strings.Split(strings.TrimSpace(previewIncludeSnippet), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+			fmt.Println()
+			skipped = append(skipped, settingsPath)
+		} else if result == "created" || result == "inserted" {
+			created = append(created, settingsPath)
+			fmt.Printf("  ✓ %s — preview include added\n", settingsPath)
+		} else if len(problems) > 0 {
+			skipped = append(skipped, settingsPath)
+			fmt.Printf("  ⚠ %s — has a preview include, left untouched:\n", settingsPath)
+			for _, problem := range problems {
+				fmt.Printf("      %s\n", problem)
+			}
+		} else {
+			skipped = append(skipped, settingsPath)
+			fmt.Printf("  · %s — already configured\n", settingsPath)
+		}
+
+		previewSettingsPath := filepath.Join(settingsDir, "settings.preview.php")
+		wrote, err := writeFile(previewSettingsPath, settingsPreviewTemplate(stageFileProxyOrigin, site))
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", previewSettingsPath, err)
+		}
+		switch wrote {
+		case "created":
+			created = append(created, previewSettingsPath)
+			fmt.Printf("  ✓ %s — created\n", previewSettingsPath)
+		case "overwritten":
+			overwritten = append(overwritten, previewSettingsPath)
+			fmt.Printf("  ✓ %s — overwritten\n", previewSettingsPath)
+		default:
+			skipped = append(skipped, previewSettingsPath)
+			fmt.Printf("  · %s — already exists\n", previewSettingsPath)
 		}
-		fmt.Println()
-		skipped = append(skipped, settingsPath)
-	} else if result == "created" || result == "appended" {
-		created = append(created, settingsPath)
-		fmt.Printf("  ✓ %s — preview include added\n", settingsPath)
-	} else {
-		skipped = append(skipped, settingsPath)
-		fmt.Printf("  · %s — already configured\n", settingsPath)
-	}
-
-	// 2. Create settings.preview.php
-	previewSettingsPath := filepath.Join(settingsDir, "settings.preview.php")
-	wrote, err := writeFile(previewSettingsPath, settingsPreviewContent())
-	if err != nil {
-		return fmt.Errorf("failed to create settings.preview.php: %w", err)
-	}
-	switch wrote {
-	case "created":
-		created = append(created, previewSettingsPath)
-		fmt.Printf("  ✓ %s — created\n", previewSettingsPath)
-	case "overwritten":
-		overwritten = append(overwritten, previewSettingsPath)
-		fmt.Printf("  ✓ %s — overwritten\n", previewSettingsPath)
-	default:
-		skipped = append(skipped, previewSettingsPath)
-		fmt.Printf("  · %s — already exists\n", previewSettingsPath)
 	}
 
 	// 3. Create preview.yml
-	wrote, err = writeFile("preview.yml", previewYmlContent())
+	wrote, err := writeFile("preview.yml", previewYmlTemplate(stageFileProxyOrigin != "", sites))
 	if err != nil {
 		return fmt.Errorf("failed to create preview.yml: %w", err)
 	}
@@ -104,6 +217,12 @@ func runSetupProject() error {
 	default:
 		skipped = append(skipped, "preview.yml")
 		fmt.Printf("  · preview.yml — already exists\n")
+		if listed := previewYmlSites(); strings.Join(listed, ",") != strings.Join(sites, ",") {
+			fmt.Printf("    It lists the sites %s; set \"sites: [%s]\" in it by hand\n", strings.Join(listed, ", "), strings.Join(sites, ", "))
+		}
+		if listed := previewYmlDatabases(); strings.Join(listed, ",") != strings.Join(setupDatabases, ",") {
+			fmt.Printf("    Set \"databases: [%s]\" in it by hand\n", strings.Join(setupDatabases, ", "))
+		}
 	}
 
 	// 4. Create deploy scripts
@@ -111,7 +230,7 @@ func runSetupProject() error {
 		scriptDir := filepath.Join("scripts", "preview", phase)
 		scriptPath := filepath.Join(scriptDir, "deploy.sh")
 		os.MkdirAll(scriptDir, 0755)
-		wrote, err = writeFile(scriptPath, deployScriptContent(phase))
+		wrote, err = writeFile(scriptPath, deployScriptTemplate(phase, sites))
 		if err != nil {
 			return fmt.Errorf("failed to create %s: %w", scriptPath, err)
 		}
@@ -141,15 +260,109 @@ func runSetupProject() error {
 	}
 
 	fmt.Println()
+	if checks, core := checkDrupalCompatibility(); checks != nil {
+		if printDrupalCompatibility(checks, core) > 0 {
+			fmt.Println("Deploys of this project will fail until the errors above are fixed.")
+		}
+		fmt.Println()
+	}
 	fmt.Println("Next steps:")
 	fmt.Println("  1. Review the generated files, especially settings.preview.php")
 	fmt.Println("  2. Edit preview.yml to match your project's needs")
 	fmt.Println("  3. Customize the deploy scripts in scripts/preview/")
 	fmt.Println("  4. Commit everything to your repository")
+	if stageFileProxyOrigin != "" {
+		fmt.Println()
+		fmt.Println("Previews fetch files from " + stageFileProxyOrigin + " with Stage File Proxy. Require and")
+		fmt.Println("enable the module (and export the configuration) if you haven't yet:")
+		fmt.Println()
+		fmt.Println("  ddev composer require drupal/stage_file_proxy")
+		fmt.Println("  ddev drush en stage_file_proxy && ddev drush cex")
+		if len(skipped) > 0 {
+			fmt.Println()
+			fmt.Println("Existing files were kept; rerun with --override to apply --stage-file-proxy to them.")
+		}
+	}
 
 	return nil
 }
 
+// checkSetupProject reports how the project files drift from what setup
+// project writes, and exits with status 1 if they do.
+func checkSetupProject(docroot string, sites []string) error {
+	drift := false
+	report := func(path string, problems ...string) {
+		switch len(problems) {
+		case 0:
+			fmt.Printf("  ✓ %s\n", path)
+		case 1:
+			fmt.Printf("  ✗ %s — %s\n", path, problems[0])
+		default:
+			fmt.Printf("  ✗ %s:\n", path)
+			for _, problem := range problems {
+				fmt.Printf("      %s\n", problem)
+			}
+		}
+		drift = drift || len(problems) > 0
+	}
+
+	for _, site := range sites {
+		settingsPath := filepath.Join(docroot, "sites", site, "settings.php")
+		if data, err := os.ReadFile(settingsPath); err != nil {
+			report(settingsPath, "missing")
+		} else if layout := analyzeSettings(string(data)); len(layout.previews) == 0 {
+			report(settingsPath, "no preview include")
+		} else {
+			report(settingsPath, layout.problems()...)
+		}
+	}
+
+	// settings.preview.php is compared with the template unless it was
+	// written for a Stage File Proxy origin this run doesn't know. Files
+	// meant to be customized only need to exist.
+	unknownOrigin := stageFileProxyOrigin == "" && previewYmlProxiesFiles()
+	type expectedFile struct{ path, template string }
+	var expected []expectedFile
+	for _, site := range sites {
+		previewSettings := settingsPreviewTemplate(stageFileProxyOrigin, site)
+		if unknownOrigin {
+			previewSettings = ""
+		}
+		expected = append(expected, expectedFile{filepath.Join(docroot, "sites", site, "settings.preview.php"), previewSettings})
+	}
+	expected = append(expected, expectedFile{"preview.yml", ""})
+	for _, phase := range []string{"new", "update"} {
+		if script := previewYmlDeployScript(phase); script != "" {
+			expected = append(expected, expectedFile{filepath.FromSlash(script), ""})
+		}
+	}
+	for _, e := range expected {
+		data, err := os.ReadFile(e.path)
+		switch {
+		case err != nil:
+			report(e.path, "missing")
+		case e.template != "" && string(data) != e.template:
+			report(e.path, "differs from the latest template (--override rewrites it)")
+		default:
+			report(e.path)
+		}
+	}
+
+	// Incompatibilities are reported but don't fail the check, which is
+	// about the files setup project writes
+	if checks, core := checkDrupalCompatibility(); checks != nil {
+		fmt.Println()
+		printDrupalCompatibility(checks, core)
+	}
+
+	if drift {
+		fmt.Println()
+		fmt.Println("Run 'preview setup project' to add missing files; settings.php conflicts must be fixed by hand.")
+		os.Exit(1)
+	}
+	return nil
+}
+
 // writeFile writes content to path. Returns "created", "overwritten", or "skipped".
 func writeFile(path string, content string) (string, error) {
 	_, err := os.Stat(path)
@@ -169,6 +382,55 @@ func writeFile(path string, content string) (string, error) {
 	return "created", nil
 }
 
+// previewYmlProxiesFiles reports whether preview.yml in the current
+// directory sets "files: stage-file-proxy".
+func previewYmlProxiesFiles() bool {
+	return loadPreviewYml().Files == "stage-file-proxy"
+}
+
+// previewYmlSites returns the multisite sites preview.yml in the current
+// directory lists, or just "default".
+func previewYmlSites() []string {
+	if sites := loadPreviewYml().Sites; len(sites) > 0 {
+		return sites
+	}
+	return []string{"default"}
+}
+
+// previewYmlDatabases returns the secondary databases preview.yml in the
+// current directory lists under "databases:".
+func previewYmlDatabases() []string {
+	return loadPreviewYml().Databases
+}
+
+// isMultisite reports whether sites is more than the default site alone.
+func isMultisite(sites []string) bool {
+	return len(sites) != 1 || sites[0] != "default"
+}
+
+// databaseNamePattern is what the server accepts as the name of a
+// secondary database: it names the database and its PREV_DB_<NAME>_* env
+// vars.
+var databaseNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// reservedDatabases can't name a secondary database: they are the main
+// database, base file kinds or MySQL's own.
+var reservedDatabases = map[string]bool{
+	"default": true, "encrypted": true, "drupal": true,
+	"mysql": true, "sys": true, "information_schema": true, "performance_schema": true,
+}
+
+// validDatabaseName reports whether name can name a secondary database.
+func validDatabaseName(name string) bool {
+	return databaseNamePattern.MatchString(name) && !reservedDatabases[name]
+}
+
+// validSiteName reports whether site is a plain directory name under
+// sites/, as the server requires.
+func validSiteName(site string) bool {
+	return site != "" && site != "." && site != ".." && !strings.ContainsAny(site, `/\`)
+}
+
 func detectDocroot() string {
 	for _, candidate := range []string{"web", "docroot"} {
 		info, err := os.Stat(candidate)
@@ -186,45 +448,18 @@ if (getenv('PREV_IS_PREVIEW')) {
 }
 `
 
-func appendPreviewInclude(settingsPath string) (string, error) {
-	data, err := os.ReadFile(settingsPath)
-	if os.IsNotExist(err) {
-		// No settings.php — create one with just the include
-		content := "<?php\n\n" + strings.TrimLeft(previewIncludeSnippet, "\n")
-		if err := os.WriteFile(settingsPath, []byte(content), 0644); err != nil {
-			return "", err
-		}
-		return "created", nil
-	}
-	if err != nil {
-		return "", err
-	}
-
-	// Check if already configured
-	if strings.Contains(string(data), "PREV_IS_PREVIEW") {
-		return "exists", nil
-	}
-
-	// Append the include snippet
-	f, err := os.OpenFile(settingsPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	if _, err := f.WriteString(previewIncludeSnippet); err != nil {
-		return "", err
-	}
-	return "appended", nil
-}
-
-func settingsPreviewContent() string {
-	return `<?php
+// settingsPreviewContent returns settings.preview.php for the multisite
+// site (e.g. "default"). The PREV_FILE_* paths are those of the default
+// site, so other sites set their own.
+func settingsPreviewContent(stageFileProxyOrigin, site string, databases []string) string {
+	content := `<?php
 
 /**
  * @file
  * Preview environment settings.
  *
+ * ` + templateTag + `
+ *
  * This file is automatically included when running in a preview environment
  * (when the PREV_IS_PREVIEW environment variable is set).
  *
@@ -234,13 +469,13 @@ func settingsPreviewContent() string {
  *   PREV_DB_NAME     - Database name
  *   PREV_DB_USER     - Database username
  *   PREV_DB_PASSWORD - Database password
- *   PREV_PROJECT_NAME - Project slug
+` + settingsPreviewDatabasesDoc + ` *   PREV_PROJECT_NAME - Project slug
  *   PREV_MR_IID      - Merge request IID
  *   PREV_BRANCH      - Git branch name
  *   PREV_COMMIT_SHA  - Git commit SHA
  *   PREV_URL         - Full preview URL (https://...)
  *   PREV_DOMAIN      - Preview domain (without protocol)
- *   PREV_FILE_PUBLIC_PATH - Public files path
+` + settingsPreviewCustomDomainsDoc + ` *   PREV_FILE_PUBLIC_PATH - Public files path
  *   PREV_FILE_PRIVATE_PATH - Private files path
  *   PREV_FILE_TEMP_PATH - Temp files path
  *   PREV_FILE_TRANSLATIONS_PATH - Translations path
@@ -249,7 +484,7 @@ func settingsPreviewContent() string {
  *   PREV_SOLR_CORE   - Solr core name (only if Solr is enabled)
  */
 
-// Database connection.
+` + settingsPreviewDatabaseNote(site) + `// Database connection.
 // MySQL 8.0+ enables SSL by default with a self-signed certificate.
 // Disable SSL verification to avoid "self-signed certificate in certificate
 // chain" errors when Drush or Drupal connects to the database container.
@@ -267,30 +502,126 @@ $databases['default']['default'] = [
   ],
 ];
 
-// Trusted host patterns — allow the preview domain.
+` + settingsPreviewSecondaryDatabases(databases) + `// Trusted host patterns — allow the preview domain.
 $settings['trusted_host_patterns'][] = '^' . preg_quote(getenv('PREV_DOMAIN')) . '$';
+` + settingsPreviewCustomDomains + `
+` + settingsPreviewFilePaths(site) + `
+// Hash salt — override if not already set upstream.
+if (empty($settings['hash_salt'])) {
+  $settings['hash_salt'] = getenv('PREV_PROJECT_NAME') . '-preview';
+}
+`
+	if stageFileProxyOrigin != "" {
+		content += `
+// Stage File Proxy — files are fetched from production on first request
+// instead of being copied to the preview (preview.yml: files: stage-file-proxy).
+$config['stage_file_proxy.settings']['origin'] = ` + phpString(stageFileProxyOrigin) + `;
+$config['stage_file_proxy.settings']['hotlink'] = FALSE;
+`
+	}
+	return content
+}
+
+// settingsPreviewDatabasesDoc documents the env vars of the secondary
+// databases in settings.preview.php. Added in template v3.
+const settingsPreviewDatabasesDoc = ` *   PREV_DB_<NAME>_HOST, _NAME, _USER, _PASSWORD - Secondary database NAME
+ *                    of preview.yml "databases:", e.g. PREV_DB_MIGRATE_HOST
+`
+
+// settingsPreviewCustomDomainsDoc and settingsPreviewCustomDomains let
+// Drupal answer at the custom domains of 'preview domain add'. Added in
+// template v3.
+const settingsPreviewCustomDomainsDoc = ` *   PREV_CUSTOM_DOMAINS - Custom domains of the preview, space-separated
+`
 
-// File system paths.
+const settingsPreviewCustomDomains = `foreach (array_filter(explode(' ', (string) getenv('PREV_CUSTOM_DOMAINS'))) as $prev_custom_domain) {
+  $settings['trusted_host_patterns'][] = '^' . preg_quote($prev_custom_domain) . '$';
+}
+`
+
+// settingsPreviewSecondaryDatabases connects the secondary databases of
+// preview.yml, each from its PREV_DB_<NAME>_* env vars.
+func settingsPreviewSecondaryDatabases(databases []string) string {
+	if len(databases) == 0 {
+		return ""
+	}
+	content := `// Secondary databases (preview.yml "databases:"), imported from the dumps
+// uploaded with 'preview push db --target NAME'.
+`
+	for _, name := range databases {
+		prefix := "PREV_DB_" + strings.ToUpper(name) + "_"
+		content += `$databases['` + name + `']['default'] = [
+  'database' => getenv('` + prefix + `NAME'),
+  'username' => getenv('` + prefix + `USER'),
+  'password' => getenv('` + prefix + `PASSWORD'),
+  'host' => getenv('` + prefix + `HOST'),
+  'port' => '3306',
+  'driver' => 'mysql',
+  'prefix' => '',
+  'collation' => 'utf8mb4_general_ci',
+  'pdo' => [
+    \PDO::MYSQL_ATTR_SSL_VERIFY_SERVER_CERT => FALSE,
+  ],
+];
+`
+	}
+	return content + "\n"
+}
+
+// settingsPreviewDatabaseNote warns sites other than default that they
+// share the preview database.
+func settingsPreviewDatabaseNote(site string) string {
+	if site == "default" {
+		return ""
+	}
+	return `// Previews provision a single database, imported from 'preview push db',
+// which all sites of a multisite share. Set a table prefix below if this
+// site keeps its tables in it.
+
+`
+}
+
+func settingsPreviewFilePaths(site string) string {
+	if site == "default" {
+		return `// File system paths.
 $settings['file_public_path'] = getenv('PREV_FILE_PUBLIC_PATH');
 $settings['file_private_path'] = getenv('PREV_FILE_PRIVATE_PATH');
 $settings['file_temp_path'] = getenv('PREV_FILE_TEMP_PATH');
 $config['locale.settings']['translation']['path'] = getenv('PREV_FILE_TRANSLATIONS_PATH');
-
-// Hash salt — override if not already set upstream.
-if (empty($settings['hash_salt'])) {
-  $settings['hash_salt'] = getenv('PREV_PROJECT_NAME') . '-preview';
-}
+`
+	}
+	files := "sites/" + site + "/files"
+	return `// File system paths. The PREV_FILE_* paths are those of sites/default;
+// the files of this site are mounted at ` + files + `.
+$settings['file_public_path'] = '` + files + `';
+$settings['file_private_path'] = '` + files + `/private';
+$settings['file_temp_path'] = getenv('PREV_FILE_TEMP_PATH');
+$config['locale.settings']['translation']['path'] = '` + files + `/translations';
 `
 }
 
-func previewYmlContent() string {
+func previewYmlContent(stageFileProxy bool, sites, databases []string) string {
+	files := "base"
+	if stageFileProxy {
+		files = "stage-file-proxy"
+	}
+	sitesLine := "# sites: [default, intranet]"
+	if isMultisite(sites) {
+		sitesLine = "sites: [" + strings.Join(sites, ", ") + "]"
+	}
+	databasesLine := "# databases: [migrate]"
+	if len(databases) > 0 {
+		databasesLine = "databases: [" + strings.Join(databases, ", ") + "]"
+	}
+	help := serverHelp(loadConfig())
 	return `# Preview Manager configuration
 # This file defines how preview environments are created for this project.
-# See: https://app.preview-mr.com/docs/configuration
+# See: ` + help.DocsURL + `/configuration
+# ` + templateTag + `
 
 # PHP version for the preview container.
-# Supported: 8.1, 8.2, 8.3
-php_version: "8.3"
+# Supported: ` + strings.Join(help.PHPVersions, ", ") + `
+php_version: "` + help.DefaultPHPVersion + `"
 
 # Database engine and version (same format as DDEV).
 # Examples:
@@ -317,6 +648,40 @@ services:
 #   APP_ENV: preview
 #   MY_CUSTOM_VAR: some-value
 
+# Where the files of a preview come from:
+#   base             - the base files archive uploaded with 'preview push files'
+#   stage-file-proxy - fetched from production on first request by the Stage
+#                      File Proxy module (see settings.preview.php); no files
+#                      archive is needed
+files: ` + files + `
+
+# Drupal multisite: the directories under sites/ of the sites. Each gets its
+# own files directory, packaged together by 'preview push files'. Only
+# "default" if not set.
+` + sitesLine + `
+
+# Secondary databases besides the main one, e.g. the source database of a
+# migration. Each is created in the preview, imported from the dump uploaded
+# with 'preview push db --target NAME', and reached from settings.preview.php
+# with the PREV_DB_<NAME>_HOST, _NAME, _USER and _PASSWORD env vars.
+` + databasesLine + `
+
+# Resource limits of the PHP container. Unlimited if not set.
+# Change them on a running preview with: preview scale PROJECT/mr-ID --memory 4g
+# resources:
+#   memory: 2g
+#   cpus: 1.5
+
+# Test suites, run with: preview test PROJECT/mr-ID [--suite NAME]
+# Commands run from the project root inside the PHP container. "junit" is the
+# path (or glob) of the JUnit reports a suite writes, relative to the project
+# root; they are downloaded after the run.
+# tests:
+#   phpunit:
+#     command: vendor/bin/phpunit --log-junit reports/phpunit.xml web/modules/custom
+#     junit: reports/phpunit.xml
+#   behat: vendor/bin/behat --format progress
+
 # Deploy scripts — executed inside the PHP container after setup.
 # Paths are relative to the project root.
 # If not defined or set to false, no deploy script runs for that phase.
@@ -325,6 +690,7 @@ services:
 # "update" runs when new commits are pushed to the MR.
 #
 # You can override per-MR by creating: scripts/preview/{phase}/mr-{id}-deploy.sh
+# (preview setup deploy-override --mr ID --phase PHASE scaffolds one)
 deploy:
   new: scripts/preview/new/deploy.sh
   update: scripts/preview/update/deploy.sh
@@ -342,6 +708,8 @@ set -euo pipefail
 # Available environment variables (PREV_ prefix):
 #   PREV_IS_PREVIEW, PREV_PROJECT_NAME, PREV_MR_IID, PREV_BRANCH,
 #   PREV_COMMIT_SHA, PREV_URL, PREV_DOMAIN, PREV_DB_HOST, etc.
+#
+# ` + templateTag + `
 
 DRUSH="vendor/bin/drush"
 
@@ -362,6 +730,8 @@ set -euo pipefail
 # Available environment variables (PREV_ prefix):
 #   PREV_IS_PREVIEW, PREV_PROJECT_NAME, PREV_MR_IID, PREV_BRANCH,
 #   PREV_COMMIT_SHA, PREV_URL, PREV_DOMAIN, PREV_DB_HOST, etc.
+#
+# ` + templateTag + `
 
 DRUSH="vendor/bin/drush"
 
@@ -375,5 +745,12 @@ echo "Update complete."
 
 func init() {
 	setupProjectCmd.Flags().BoolVar(&overrideFlag, "override", false, "Overwrite existing files with the latest templates")
+	setupProjectCmd.Flags().BoolVar(&checkFlag, "check", false, "Report files that are missing or differ from the templates, without writing")
+	setupProjectCmd.Flags().BoolVar(&diffFlag, "diff", false, "Show how the files differ from the latest templates, without writing")
+	setupProjectCmd.Flags().BoolVar(&mergeFlag, "merge", false, "Merge the template updates into the files, keeping your changes")
+	setupProjectCmd.Flags().StringVar(&stageFileProxyOrigin, "stage-file-proxy", "", "Fetch preview files from this production URL with Stage File Proxy instead of a base files archive")
+	setupProjectCmd.Flags().StringVar(&templateSource, "template-source", "", "Generate the files from these templates: a server template set, a git repository URL or a directory")
+	setupProjectCmd.Flags().StringSliceVar(&setupDatabases, "databases", nil, "Secondary databases previews get, e.g. migrate (default: those of preview.yml)")
+	setupProjectCmd.Flags().StringSliceVar(&setupSites, "sites", nil, "Multisite directories under sites/ to set up, e.g. default,intranet (default: those of preview.yml)")
 	setupCmd.AddCommand(setupProjectCmd)
 }
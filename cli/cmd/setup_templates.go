@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var templateSource string
+
+// scaffoldFiles are the files setup project generates from a template, as
+// paths relative to the project root. A template source provides some or
+// all of them.
+var scaffoldFiles = []string{
+	"settings.preview.php",
+	"preview.yml",
+	"scripts/preview/new/deploy.sh",
+	"scripts/preview/update/deploy.sh",
+}
+
+// scaffoldTemplates are the templates of --template-source by file; files
+// it doesn't provide use the built-in templates.
+var scaffoldTemplates map[string]string
+
+var setupTemplatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage the scaffold templates hosted on the preview server",
+	Long: `Organizations can host their own templates for the files 'preview setup
+project' generates, so agency-wide conventions don't require forking the
+CLI. A template set provides any of:
+
+  ` + strings.Join(scaffoldFiles, "\n  ") + `
+
+laid out as in a project; the files it leaves out keep the built-in
+templates. Templates may use these placeholders:
+
+  {{site}}                     the site of settings.preview.php, e.g. default
+  {{sites}}                    the sites set up, e.g. "default, intranet"
+  {{databases}}                the secondary databases, e.g. "migrate"
+  {{docroot}}                  the document root, e.g. web
+  {{files}}                    base, or stage-file-proxy with --stage-file-proxy
+  {{stage_file_proxy_origin}}  the --stage-file-proxy URL
+
+Use a set with 'preview setup project --template-source NAME'. The
+templates can also come from a git repository or a local directory with the
+same layout.`,
+}
+
+var setupTemplatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the template sets of the server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireScaffoldTemplates(); err != nil {
+			return err
+		}
+		sets, err := apiClient.ListTemplateSets(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if len(sets) == 0 {
+			fmt.Fprintln(os.Stderr, "No template sets; upload one with 'preview setup templates upload NAME DIR'.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tFILES")
+		for _, set := range sets {
+			fmt.Fprintf(w, "%s\t%s\n", set.Name, strings.Join(set.Files, ", "))
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var setupTemplatesUploadCmd = &cobra.Command{
+	Use:   "upload NAME [DIR]",
+	Short: "Upload the templates in DIR as the template set NAME",
+	Long: `Upload the templates found in DIR (default: the current directory) as the
+template set NAME, replacing it if it exists. DIR is laid out as a project:
+preview.yml at its root, scripts/preview/new/deploy.sh, and so on; a
+settings.preview.php template goes at its root. Requires the admin role.
+
+Examples:
+  preview setup templates upload org-default ./preview-templates`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireScaffoldTemplates(); err != nil {
+			return err
+		}
+		dir := "."
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		files, err := readTemplateDir(dir)
+		if err != nil {
+			return err
+		}
+		if err := apiClient.PutTemplateSet(cmd.Context(), client.TemplateSet{Name: args[0], Files: files}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Template set %s uploaded with %s.\n", args[0], strings.Join(sortedKeys(files), ", "))
+		fmt.Fprintf(os.Stderr, "Use it with: preview setup project --template-source %s\n", args[0])
+		return nil
+	},
+}
+
+var setupTemplatesDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete the template set NAME",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireScaffoldTemplates(); err != nil {
+			return err
+		}
+		ok, err := confirm(fmt.Sprintf("Delete the template set %s?", args[0]))
+		if err != nil || !ok {
+			return err
+		}
+		if err := apiClient.DeleteTemplateSet(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Template set %s deleted.\n", args[0])
+		return nil
+	},
+}
+
+// loadTemplateSource returns the templates of source: a local directory, a
+// git repository URL (with an optional #REF), or the name of a template
+// set on the preview server.
+func loadTemplateSource(ctx context.Context, source string) (map[string]string, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return readTemplateDir(source)
+	}
+	if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") || strings.HasSuffix(source, ".git") {
+		return cloneTemplates(ctx, source)
+	}
+
+	// setup project runs without logging in, so apiClient isn't set
+	cfg := loadConfig()
+	if cfg.APIURL == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("template set %q is on the preview server; run 'preview login' first", source)
+	}
+	if err := requireScaffoldTemplates(); err != nil {
+		return nil, err
+	}
+	set, err := newClient(cfg).GetTemplateSet(ctx, source)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil, fmt.Errorf("no template set %q on the server (see 'preview setup templates list')", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set.Files, nil
+}
+
+// cloneTemplates reads the templates of a shallow clone of the git
+// repository source, "URL" or "URL#REF".
+func cloneTemplates(ctx context.Context, source string) (map[string]string, error) {
+	repo, ref, _ := strings.Cut(source, "#")
+	dir, err := os.MkdirTemp("", "preview-templates-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	c := exec.CommandContext(ctx, "git", append(args, repo, dir)...)
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("failed to clone %s: %w", repo, err)
+	}
+	return readTemplateDir(dir)
+}
+
+// readTemplateDir reads the scaffold files found in dir.
+func readTemplateDir(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, path := range scaffoldFiles {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[path] = string(data)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no templates in %s: expected any of %s", dir, strings.Join(scaffoldFiles, ", "))
+	}
+	return files, nil
+}
+
+// scaffoldTemplate returns the --template-source template of path with
+// its placeholders replaced, and false if it provides none.
+func scaffoldTemplate(path, site string, sites []string, stageFileProxy bool, origin string) (string, bool) {
+	content, ok := scaffoldTemplates[path]
+	if !ok {
+		return "", false
+	}
+	files := "base"
+	if stageFileProxy {
+		files = "stage-file-proxy"
+	}
+	return strings.NewReplacer(
+		"{{site}}", site,
+		"{{sites}}", strings.Join(sites, ", "),
+		"{{databases}}", strings.Join(setupDatabases, ", "),
+		"{{docroot}}", detectDocroot(),
+		"{{files}}", files,
+		"{{stage_file_proxy_origin}}", origin,
+	).Replace(content), true
+}
+
+// settingsPreviewTemplate returns settings.preview.php for site from
+// --template-source, or the built-in template.
+func settingsPreviewTemplate(origin, site string) string {
+	if content, ok := scaffoldTemplate("settings.preview.php", site, []string{site}, origin != "", origin); ok {
+		return content
+	}
+	return settingsPreviewContent(origin, site, setupDatabases)
+}
+
+// previewYmlTemplate returns preview.yml from --template-source, or the
+// built-in template.
+func previewYmlTemplate(stageFileProxy bool, sites []string) string {
+	if content, ok := scaffoldTemplate("preview.yml", "", sites, stageFileProxy, stageFileProxyOrigin); ok {
+		return content
+	}
+	return previewYmlContent(stageFileProxy, sites, setupDatabases)
+}
+
+// deployScriptTemplate returns the deploy script of phase from
+// --template-source, or the built-in template.
+func deployScriptTemplate(phase string, sites []string) string {
+	if content, ok := scaffoldTemplate("scripts/preview/"+phase+"/deploy.sh", "", sites, stageFileProxyOrigin != "", stageFileProxyOrigin); ok {
+		return content
+	}
+	return deployScriptContent(phase)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	setupTemplatesCmd.AddCommand(setupTemplatesListCmd, setupTemplatesUploadCmd, setupTemplatesDeleteCmd)
+	setupCmd.AddCommand(setupTemplatesCmd)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var shellService string
+
+var shellCmd = &cobra.Command{
+	Use:   "shell [PROJECT/PREVIEW-NAME]",
+	Short: "Open a shell in a preview container",
+	Long: `Open an interactive bash shell in a container of a preview, the PHP
+container unless --service is given. Requires the manager role.
+
+If PROJECT/PREVIEW-NAME is given, opens the shell on that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview shell drupal-test/mr-5
+  preview shell --service db`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Connecting to the %s container of %s/%s...\n", shellService, project, previewName)
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.Shell(cmd.Context(), project, previewName, shellService, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+func init() {
+	shellCmd.Flags().StringVar(&shellService, "service", "php", "Service of the container, e.g. php, db or solr")
+	rootCmd.AddCommand(shellCmd)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// releaseKey is the minisign public key release binaries are signed with,
+// set by main from release.pub in release builds, empty in others.
+var releaseKey string
+
+// SetReleaseKey sets the public key self-update verifies releases with
+// (called from main with the embedded release.pub file).
+func SetReleaseKey(key string) {
+	releaseKey = key
+}
+
+// verifyRelease checks that sig is a minisign signature of binary by
+// releaseKey, and that its trusted comment is that of the release name at
+// version, so a validly signed binary of another platform or an older
+// release can't be swapped in. Releases are signed by build.sh with:
+//
+//	minisign -S -l -m FILE -t "file:NAME version:VERSION"
+func verifyRelease(binary []byte, sig, name, version string) error {
+	if strings.TrimSpace(releaseKey) == "" {
+		return errors.New("this build of the CLI has no release key to verify it with (only release builds of build.sh embed release.pub)")
+	}
+	keyID, pub, err := parseMinisignKey(releaseKey)
+	if err != nil {
+		return fmt.Errorf("invalid release key: %w", err)
+	}
+	comment, err := verifyMinisign(keyID, pub, binary, sig)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(comment)
+	want := []string{"file:" + name, "version:" + version}
+	for _, w := range want {
+		found := false
+		for _, f := range fields {
+			found = found || f == w
+		}
+		if !found {
+			return fmt.Errorf("signature is for %q, not %q", comment, strings.Join(want, " "))
+		}
+	}
+	return nil
+}
+
+// parseMinisignKey parses a minisign public key, as in a minisign.pub file
+// or its base64 line alone.
+func parseMinisignKey(key string) (keyID []byte, pub ed25519.PublicKey, err error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(key), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			line = l
+			break
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, nil, errors.New("not a minisign Ed25519 public key")
+	}
+	return raw[2:10], ed25519.PublicKey(raw[10:]), nil
+}
+
+// verifyMinisign verifies the minisign signature sig (the content of a
+// .minisig file) of data by the key keyID and pub, and returns its trusted
+// comment. Only legacy signatures of the data itself (minisign -l) are
+// supported, as prehashed ones need BLAKE2b.
+func verifyMinisign(keyID []byte, pub ed25519.PublicKey, data []byte, sig string) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return "", errors.New("malformed signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("malformed signature")
+	}
+	switch string(raw[:2]) {
+	case "Ed":
+	case "ED":
+		return "", errors.New("prehashed signatures aren't supported, sign releases with minisign -l")
+	default:
+		return "", errors.New("unknown signature algorithm")
+	}
+	if !bytes.Equal(raw[2:10], keyID) {
+		return "", fmt.Errorf("signed by another key (%X, expected %X)", raw[2:10], keyID)
+	}
+	signature := raw[10:]
+	if !ed25519.Verify(pub, data, signature) {
+		return "", errors.New("signature doesn't match the binary, it may have been tampered with")
+	}
+
+	comment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return "", errors.New("malformed signature: missing trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("malformed signature")
+	}
+	if !ed25519.Verify(pub, append(append([]byte{}, signature...), comment...), global) {
+		return "", errors.New("trusted comment of the signature has been tampered with")
+	}
+	return comment, nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testKeyID and testSeed make the minisign key pair of the tests.
+var (
+	testKeyID = []byte{0x8a, 0x1f, 0x2e, 0x3d, 0x4c, 0x5b, 0x6a, 0x79}
+	testSeed  = bytes.Repeat([]byte{0x42}, ed25519.SeedSize)
+)
+
+// testMinisignKey returns the private key of the tests and its public key
+// as a minisign.pub file.
+func testMinisignKey() (ed25519.PrivateKey, string) {
+	priv := ed25519.NewKeyFromSeed(testSeed)
+	raw := append(append([]byte("Ed"), testKeyID...), priv.Public().(ed25519.PublicKey)...)
+	return priv, fmt.Sprintf("untrusted comment: minisign public key %X\n%s\n", testKeyID, base64.StdEncoding.EncodeToString(raw))
+}
+
+// minisignSignature returns a .minisig file of data signed by priv, as
+// minisign writes it, with algorithm alg ("Ed" legacy, "ED" prehashed),
+// key ID keyID and trusted comment comment.
+func minisignSignature(priv ed25519.PrivateKey, alg string, keyID, data []byte, comment string) string {
+	sig := ed25519.Sign(priv, data)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+	raw := append(append([]byte(alg), keyID...), sig...)
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global))
+}
+
+func TestParseMinisignKey(t *testing.T) {
+	_, pubFile := testMinisignKey()
+	lines := strings.Split(strings.TrimSpace(pubFile), "\n")
+	for name, key := range map[string]string{"file": pubFile, "line": lines[1], "crlf": strings.ReplaceAll(pubFile, "\n", "\r\n")} {
+		keyID, pub, err := parseMinisignKey(key)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(keyID, testKeyID) || !pub.Equal(ed25519.NewKeyFromSeed(testSeed).Public()) {
+			t.Fatalf("%s: got key %X %X", name, keyID, pub)
+		}
+	}
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("Ed12345678short"))} {
+		if _, _, err := parseMinisignKey(key); err == nil {
+			t.Fatalf("expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	priv, pubFile := testMinisignKey()
+	keyID, pub, err := parseMinisignKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("\x7fELF release binary")
+	comment := "file:preview-linux-amd64 version:1.8.0"
+	valid := minisignSignature(priv, "Ed", testKeyID, binary, comment)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		sig     string
+		wantErr string
+	}{
+		{"valid", binary, valid, ""},
+		{"tampered binary", []byte("\x7fELF release binarz"), valid, "doesn't match the binary"},
+		{"tampered trusted comment", binary, strings.Replace(valid, "version:1.8.0", "version:1.9.0", 1), "trusted comment"},
+		{"wrong key ID", binary, minisignSignature(priv, "Ed", []byte("otherkey"), binary, comment), "another key"},
+		{"other key, same ID", binary, minisignSignature(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, 32)), "Ed", testKeyID, binary, comment), "doesn't match the binary"},
+		{"prehashed", binary, minisignSignature(priv, "ED", testKeyID, binary, comment), "prehashed"},
+		{"unknown algorithm", binary, minisignSignature(priv, "Xy", testKeyID, binary, comment), "unknown signature algorithm"},
+		{"truncated", binary, strings.Join(strings.Split(valid, "\n")[:2], "\n"), "malformed"},
+		{"crlf", binary, strings.ReplaceAll(valid, "\n", "\r\n"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyMinisign(keyID, pub, tt.data, tt.sig)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != comment {
+					t.Fatalf("got trusted comment %q, want %q", got, comment)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRelease(t *testing.T) {
+	priv, pubFile := testMinisignKey()
+	defer SetReleaseKey(releaseKey)
+	binary := []byte("release binary")
+	sig := minisignSignature(priv, "Ed", testKeyID, binary, "file:preview-linux-amd64 version:1.8.0")
+
+	SetReleaseKey("")
+	if err := verifyRelease(binary, sig, "preview-linux-amd64", "1.8.0"); err == nil || !strings.Contains(err.Error(), "no release key") {
+		t.Fatalf("expected a build without a key to refuse, got %v", err)
+	}
+
+	SetReleaseKey(pubFile)
+	if err := verifyRelease(binary, sig, "preview-linux-amd64", "1.8.0"); err != nil {
+		t.Fatal(err)
+	}
+	// A valid signature of another platform or release is refused
+	if err := verifyRelease(binary, sig, "preview-darwin-arm64", "1.8.0"); err == nil {
+		t.Fatal("expected the signature of another file to be refused")
+	}
+	if err := verifyRelease(binary, sig, "preview-linux-amd64", "1.7.0"); err == nil {
+		t.Fatal("expected the signature of another version to be refused")
+	}
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var sizeOutput string
+
+var sizeCmd = &cobra.Command{
+	Use:   "size [PROJECT/PREVIEW-NAME]",
+	Short: "Show what uses the disk space of a preview",
+	Long: `Show the disk usage of a preview on the server by component: the code
+checkout (with vendor and private files), the files added or changed on the
+preview, the database and solr volumes and the writable layers of the
+containers, with the largest directories of the checkout. The base files and
+docker images are shared with other previews and not counted in the total.
+
+The server walks the preview's directories, which can take a minute for
+large previews.
+
+If PROJECT/PREVIEW-NAME is given, measures that preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview size drupal-test/mr-5
+  preview size --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sizeOutput != "text" && sizeOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", sizeOutput)
+		}
+
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := requirePreviewSize(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Measuring %s/%s on the server...\n", project, previewName)
+		size, err := apiClient.GetPreviewSize(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+
+		if sizeOutput == "json" {
+			data, err := json.MarshalIndent(size, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		printPreviewSize(size)
+		return nil
+	},
+}
+
+func printPreviewSize(size *client.PreviewSize) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tSIZE\tDESCRIPTION")
+	printSizeComponents(w, size.Components, false)
+	fmt.Fprintf(w, "TOTAL\t%s\t\n", formatBytesShort(size.TotalBytes))
+	w.Flush()
+
+	shared := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Println("\nShared with other previews, not counted:")
+	printSizeComponents(shared, size.Components, true)
+	shared.Flush()
+}
+
+// printSizeComponents prints the components that are shared, or not, each
+// followed by its largest entries.
+func printSizeComponents(w *tabwriter.Writer, components []client.SizeComponent, shared bool) {
+	for _, c := range components {
+		if c.Shared != shared {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, formatComponentSize(c.SizeBytes), c.Description)
+		for _, e := range c.Largest {
+			fmt.Fprintf(w, "  %s\t%s\t\n", e.Path, formatBytesShort(e.SizeBytes))
+		}
+	}
+}
+
+func formatComponentSize(b *int64) string {
+	if b == nil {
+		return "unknown"
+	}
+	return formatBytesShort(*b)
+}
+
+func init() {
+	sizeCmd.Flags().StringVarP(&sizeOutput, "output", "o", "text", "Output format: text or json")
+	rootCmd.AddCommand(sizeCmd)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var solrRows int
+
+var solrCmd = &cobra.Command{
+	Use:   "solr",
+	Short: "Use the Solr service of a preview",
+	Long: `Reindex and query the Solr core of a preview. The preview needs
+"services: solr: true" in preview.yml. Requires the manager role.`,
+}
+
+var solrReindexCmd = &cobra.Command{
+	Use:   "reindex [PROJECT/PREVIEW-NAME]",
+	Short: "Reindex the Search API indexes of a preview",
+	Long: `Queue every item of the preview's Search API indexes for reindexing
+and index them (drush search-api:reindex and search-api:index), e.g. after
+a database import. Runs until indexing completes.
+
+If PROJECT/PREVIEW-NAME is given, reindexes that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview solr reindex drupal-test/mr-5
+  preview solr reindex`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := requireServices(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Reindexing Search API indexes on %s/%s...\n", project, previewName)
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.SolrReindex(cmd.Context(), project, previewName, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			fmt.Fprintf(os.Stderr, "Reindexing failed (exit code %d)\n", code)
+			os.Exit(code)
+		}
+		fmt.Fprintln(os.Stderr, "Reindexing completed.")
+		return nil
+	},
+}
+
+var solrQueryCmd = &cobra.Command{
+	Use:   "query [PROJECT/PREVIEW-NAME] QUERY",
+	Short: "Query the Solr core of a preview",
+	Long: `Run a Solr query (standard query syntax) on the preview's Solr core and
+print the JSON response, to check what was indexed.
+
+Examples:
+  preview solr query drupal-test/mr-5 "*:*" --rows 3
+  preview solr query 'tm_X3b_en_title:drupal'`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if solrRows < 1 || solrRows > 100 {
+			return fmt.Errorf("invalid --rows %d: expected 1 to 100", solrRows)
+		}
+		var project, previewName string
+		var err error
+		if len(args) == 2 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+		query := args[len(args)-1]
+
+		if err := requireServices(); err != nil {
+			return err
+		}
+		session, restore, err := localTerminal()
+		if err != nil {
+			return err
+		}
+		code, err := apiClient.SolrQuery(cmd.Context(), project, previewName, query, solrRows, session)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			fmt.Fprintf(os.Stderr, "Solr query failed (exit code %d)\n", code)
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+func init() {
+	solrQueryCmd.Flags().IntVar(&solrRows, "rows", 10, "Number of documents to return (1-100)")
+	solrCmd.AddCommand(solrReindexCmd, solrQueryCmd)
+	rootCmd.AddCommand(solrCmd)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// startSpinner shows message with a spinner on stderr until the returned
+// function is called, which erases it. Nothing is shown when stderr isn't
+// a terminal or with --progress json.
+func startSpinner(message string) func() {
+	if !term.IsTerminal(int(os.Stderr.Fd())) || jsonProgress() {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		frames := []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r\033[2K%c %s", frames[i%len(frames)], message)
+			select {
+			case <-ticker.C:
+			case <-done:
+				fmt.Fprint(os.Stderr, "\r\033[2K")
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
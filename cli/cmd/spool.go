@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// spoolDirFlag is the directory of --spool-dir, which overrides the
+// spool_dir of the config.
+var spoolDirFlag string
+
+// spoolDir is where large temporary files go: upload spool files and files
+// sync archives. Empty for the defaults, the current directory for uploads
+// (as /tmp may be a tmpfs) and the system temp directory for the rest.
+var spoolDir string
+
+// spoolMaxAge is the age past which spool files are taken as left behind
+// by an interrupted command. Files of commands still running are younger.
+const spoolMaxAge = 24 * time.Hour
+
+// spoolPatterns match the temporary files the CLI spools to.
+var spoolPatterns = []string{".preview-upload-*", "preview-sync-*"}
+
+// setupSpoolDir sets spoolDir from --spool-dir or spool_dir, creating the
+// directory if needed, and removes the spool files left behind there.
+func setupSpoolDir(cfg config) error {
+	spoolDir = spoolDirFlag
+	if spoolDir == "" {
+		spoolDir = cfg.SpoolDir
+	}
+	if spoolDir != "" {
+		if err := os.MkdirAll(spoolDir, 0700); err != nil {
+			return fmt.Errorf("invalid spool directory: %w", err)
+		}
+	}
+	cleanSpoolFiles()
+	return nil
+}
+
+// uploadSpoolDir returns the directory upload spool files go to.
+func uploadSpoolDir() string {
+	if spoolDir == "" {
+		return "."
+	}
+	return spoolDir
+}
+
+// tempSpoolDir returns the directory other large temporary files go to.
+func tempSpoolDir() string {
+	if spoolDir == "" {
+		return os.TempDir()
+	}
+	return spoolDir
+}
+
+// cleanSpoolFiles removes the spool files older than spoolMaxAge, which
+// interrupted commands leave behind.
+func cleanSpoolFiles() {
+	for _, dir := range []string{uploadSpoolDir(), tempSpoolDir()} {
+		for _, pattern := range spoolPatterns {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, path := range matches {
+				info, err := os.Lstat(path)
+				if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < spoolMaxAge {
+					continue
+				}
+				if os.Remove(path) == nil {
+					fmt.Fprintf(os.Stderr, "Removed %s (%s), left behind by an interrupted command.\n", path, formatBytesShort(info.Size()))
+				}
+			}
+		}
+	}
+}
+
+// spoolsUploads reports whether uploads are spooled to disk before being
+// sent, as they are to servers without streaming uploads.
+func spoolsUploads() bool {
+	return serverCaps == nil || !serverCaps.StreamingUpload
+}
+
+// checkSpoolSpace fails if dir lacks the free space for a spool file of
+// size bytes. An estimated size is an upper bound, e.g. the size before
+// compression, so too little space only warns. Free space that can't be
+// told isn't checked.
+func checkSpoolSpace(dir string, size int64, estimated bool) error {
+	free, err := freeSpace(dir)
+	if err != nil || free >= size {
+		return nil
+	}
+	abs, _ := filepath.Abs(dir)
+	if estimated {
+		fmt.Fprintf(os.Stderr, "Warning: %s has %s free and the temporary file may need up to %s; point --spool-dir (spool_dir in %s) at a larger disk if it runs out.\n",
+			abs, formatBytesShort(free), formatBytesShort(size), configPath())
+		return nil
+	}
+	return fmt.Errorf("not enough free space in %s: %s free, %s needed for the temporary file\nFree some space or point --spool-dir (spool_dir in %s) at a larger disk", abs, formatBytesShort(free), formatBytesShort(size), configPath())
+}
@@ -1,33 +1,6 @@
 package cmd
 
-import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
-)
-
-var startCmd = &cobra.Command{
-	Use:   "start PROJECT/mr-ID",
-	Short: "Start a preview (docker compose up)",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		project, mrID, err := parsePreviewArg(args[0])
-		if err != nil {
-			return err
-		}
-		fmt.Fprintf(os.Stderr, "Starting %s/mr-%d...\n", project, mrID)
-		result, err := apiClient.PostAction(project, mrID, "start")
-		if err != nil {
-			return err
-		}
-		printActionResult(result)
-		if !result.Success {
-			os.Exit(1)
-		}
-		return nil
-	},
-}
+var startCmd = newActionCommand("start", "Starting", "Start a preview (docker compose up)")
 
 func init() {
 	rootCmd.AddCommand(startCmd)
@@ -1,34 +1,99 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/capynet/preview-server/client"
 	"github.com/spf13/cobra"
 )
 
-var stopCmd = &cobra.Command{
-	Use:   "stop PROJECT/mr-ID",
-	Short: "Stop a preview (docker compose stop)",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		project, mrID, err := parsePreviewArg(args[0])
-		if err != nil {
-			return err
+var stopIdle time.Duration
+
+var stopCmd = newActionCommand("stop", "Stopping", "Stop a preview (docker compose stop)")
+
+// idlePreviews returns the running previews of project, or of every
+// project if it is empty, not visited or deployed for longer than idle.
+// Previews never visited nor deployed are left alone, as auto-stop does.
+func idlePreviews(ctx context.Context, project string, idle time.Duration) ([]previewTarget, error) {
+	if strings.Contains(project, "/") {
+		return nil, fmt.Errorf("expected a PROJECT, got %q", project)
+	}
+	// Only the status of the previews of project is checked
+	result, err := listPreviewsPage(ctx, client.ListOptions{Project: project, IncludeStatus: true})
+	if err != nil {
+		return nil, err
+	}
+	var targets []previewTarget
+	for _, p := range result.Previews {
+		if project != "" && p.Project != project || p.Status != "running" {
+			continue
+		}
+		last, ok := p.LastActivity()
+		if !ok || time.Since(last) < idle {
+			continue
+		}
+		targets = append(targets, previewTarget{Project: p.Project, Name: p.Name})
+		fmt.Fprintf(os.Stderr, "  %s/%s: last active %s\n", p.Project, p.Name, formatUploadTime(last.Format(time.RFC3339)))
+	}
+	return targets, nil
+}
+
+func init() {
+	stopCmd.Use = "stop PROJECT/mr-ID... | PROJECT --all | [PROJECT] --idle DURATION"
+	stopCmd.Long += `
+
+With --idle, stops the running previews of PROJECT, or of every project,
+that were neither visited nor deployed for longer than DURATION (e.g. 48h),
+the idle time auto-stop goes by. Stopped previews start again when visited.
+
+  preview stop drupal-test --idle 48h
+  preview stop --idle 72h`
+	stopCmd.Flags().DurationVar(&stopIdle, "idle", 0, "Stop the running previews idle for longer than this, e.g. 48h")
+
+	stopCmd.Args = func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("idle") {
+			return cobra.MaximumNArgs(1)(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	}
+	stopAction := stopCmd.RunE
+	stopCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("idle") {
+			return stopAction(cmd, args)
+		}
+		if stopIdle <= 0 {
+			return fmt.Errorf("invalid --idle %s: expected a positive duration, e.g. 48h", stopIdle)
 		}
-		fmt.Fprintf(os.Stderr, "Stopping %s/mr-%d...\n", project, mrID)
-		result, err := apiClient.PostAction(project, mrID, "stop")
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			return fmt.Errorf("--idle and --all can't be used together")
+		}
+		project := ""
+		if len(args) == 1 {
+			project = args[0]
+		}
+
+		fmt.Fprintf(os.Stderr, "Finding previews idle for more than %s...\n", stopIdle)
+		targets, err := idlePreviews(cmd.Context(), project, stopIdle)
 		if err != nil {
 			return err
 		}
-		printActionResult(result)
-		if !result.Success {
+		if len(targets) == 0 {
+			fmt.Fprintln(os.Stderr, "No running previews are idle for that long.")
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Stopping %d previews...\n", len(targets))
+		ok := runBulk(cmd.Context(), targets, func(ctx context.Context, t previewTarget) (*client.ActionResult, error) {
+			return apiClient.PostActionByName(ctx, t.Project, t.Name, "stop")
+		})
+		if !ok {
 			os.Exit(1)
 		}
 		return nil
-	},
-}
-
-func init() {
+	}
 	rootCmd.AddCommand(stopCmd)
 }
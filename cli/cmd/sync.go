@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var syncFilesDir string
+var syncDelete bool
+var syncDryRun bool
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync local changes to a running preview",
+}
+
+var syncFilesCmd = &cobra.Command{
+	Use:   "files PROJECT/PREVIEW-NAME PATH...",
+	Short: "Copy some local files to a preview",
+	Long: `Copy files and directories of the local public files directory to the
+running preview, like a scoped rsync: only files missing on the preview or
+whose size or modification time differ are sent. They go to the files
+overlay of the preview only: the base files and other previews are
+untouched, and nothing is re-archived.
+
+PATH is a file or directory inside the local files directory (detected
+with ddev, or --files-dir). The css, js and php directories are skipped,
+as for push files.
+
+--delete also deletes the files under PATH that are on the preview but not
+local. --dry-run lists what would be copied and deleted.
+
+Examples:
+  preview sync files drupal-test/mr-5 web/sites/default/files/inline-images
+  preview sync files drupal-test/mr-5 web/sites/default/files/styles --delete --dry-run`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, previewName, err := parsePreviewName(args[0])
+		if err != nil {
+			return err
+		}
+		if err := requireFilesSync(); err != nil {
+			return err
+		}
+
+		root := syncFilesDir
+		if root == "" {
+			paths, err := getDrupalPaths()
+			if err != nil {
+				return fmt.Errorf("%w\nUse --files-dir to set the files directory", err)
+			}
+			root = paths.Files
+		}
+		rels, err := filesDirRels(root, args[1:])
+		if err != nil {
+			return err
+		}
+
+		local, err := snapshotFilesPaths(root, rels, syncDelete)
+		if err != nil {
+			return err
+		}
+		remote, err := apiClient.ListPreviewFiles(cmd.Context(), project, previewName, rels)
+		if err != nil {
+			return fmt.Errorf("failed to list the files of %s/%s: %w", project, previewName, err)
+		}
+		upload, deletes := planFilesSync(local, remote, syncDelete)
+
+		var size int64
+		for _, rel := range upload {
+			size += local[rel].size
+			fmt.Printf("upload  %s (%s)\n", rel, formatBytesShort(local[rel].size))
+		}
+		for _, rel := range deletes {
+			fmt.Printf("delete  %s\n", rel)
+		}
+		if len(upload) == 0 && len(deletes) == 0 {
+			fmt.Fprintf(os.Stderr, "%s/%s is already in sync.\n", project, previewName)
+			return nil
+		}
+		if syncDryRun {
+			fmt.Fprintf(os.Stderr, "Dry run: %d files to upload (%s), %d to delete.\n", len(upload), formatBytesShort(size), len(deletes))
+			return nil
+		}
+
+		var archive io.ReaderAt // nil to only delete
+		var archiveSize int64
+		if len(upload) > 0 {
+			if err := checkSpoolSpace(tempSpoolDir(), size, true); err != nil {
+				return err
+			}
+			tmp, err := os.CreateTemp(tempSpoolDir(), "preview-sync-*.tar.gz")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			if err := writeFilesSyncArchive(tmp, root, upload); err != nil {
+				return fmt.Errorf("failed to archive files: %w", err)
+			}
+			info, err := tmp.Stat()
+			if err != nil {
+				return err
+			}
+			archive, archiveSize = tmp, info.Size()
+		}
+
+		fmt.Fprintf(os.Stderr, "Syncing %d files (%s) to %s/%s...\n", len(upload), formatBytesShort(archiveSize), project, previewName)
+		result, err := apiClient.SyncPreviewFiles(cmd.Context(), project, previewName, archive, archiveSize, deletes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Done: %d written, %d deleted.\n", result.Written, result.Deleted)
+		return nil
+	},
+}
+
+// filesDirRels returns paths relative to the files directory root
+// (slash-separated, "." for root itself), failing for paths outside it.
+func filesDirRels(root string, paths []string) ([]string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var rels []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if abs == absRoot {
+			rels = append(rels, ".")
+			continue
+		}
+		rel, ok := relInside(absRoot, abs)
+		if !ok {
+			return nil, fmt.Errorf("%s is not inside the files directory %s", p, root)
+		}
+		if isExcludedFilesPath(rel) {
+			return nil, fmt.Errorf("%s is regenerated on the preview and never synced", p)
+		}
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
+
+// snapshotFilesPaths stamps the regular files under each of rels (relative
+// to root), skipping filesArchiveExcludes. A missing path is an error
+// unless allowMissing: with --delete it means deleting it on the preview.
+func snapshotFilesPaths(root string, rels []string, allowMissing bool) (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	for _, rel := range rels {
+		top := filepath.Join(root, filepath.FromSlash(rel))
+		if _, err := os.Lstat(top); errors.Is(err, fs.ErrNotExist) && allowMissing {
+			continue
+		}
+		err := filepath.WalkDir(top, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			r, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			r = filepath.ToSlash(r)
+			if r != "." && isExcludedFilesPath(r) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files[r] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// planFilesSync returns the local files to upload, missing on the preview
+// or with another size or modification time, and with deleteExtraneous
+// the files of the preview to delete, both sorted.
+func planFilesSync(local map[string]fileStamp, remote []client.PreviewFile, deleteExtraneous bool) (upload, deletes []string) {
+	onPreview := make(map[string]client.PreviewFile, len(remote))
+	for _, f := range remote {
+		onPreview[f.Path] = f
+	}
+	for rel, stamp := range local {
+		f, ok := onPreview[rel]
+		if !ok || f.SizeBytes != stamp.size || f.ModTime != stamp.modTime.Unix() {
+			upload = append(upload, rel)
+		}
+	}
+	if deleteExtraneous {
+		for _, f := range remote {
+			if _, ok := local[f.Path]; !ok && !isExcludedFilesPath(f.Path) {
+				deletes = append(deletes, f.Path)
+			}
+		}
+	}
+	sort.Strings(upload)
+	sort.Strings(deletes)
+	return upload, deletes
+}
+
+func init() {
+	syncFilesCmd.Flags().StringVar(&syncFilesDir, "files-dir", "", "Local public files directory (default: detected with ddev)")
+	syncFilesCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete files under PATH that are on the preview but not local")
+	syncFilesCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "List what would be uploaded and deleted without changing the preview")
+	syncCmd.AddCommand(syncFilesCmd)
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var testSuites []string
+var testJUnitDir string
+
+var testCmd = &cobra.Command{
+	Use:   "test [PROJECT/PREVIEW-NAME]",
+	Short: "Run the test suites of a preview",
+	Long: `Run the test suites defined in the "tests:" block of preview.yml inside the
+preview's PHP container, streaming their output.
+
+After each suite, the JUnit reports it declares ("junit:") are downloaded
+into --junit-dir/SUITE. Exits with a non-zero status if any suite fails.
+
+If PROJECT/PREVIEW-NAME is given, runs the tests of that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview test drupal-test/mr-5
+  preview test drupal-test/mr-5 --suite behat
+  preview test --suite phpunit --junit-dir build/reports`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := requireTests(); err != nil {
+			return err
+		}
+
+		suites, err := apiClient.ListTestSuites(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+		suites, err = selectTestSuites(suites, testSuites)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", project, previewName, err)
+		}
+
+		var failed []string
+		for _, suite := range suites {
+			fmt.Fprintf(os.Stderr, "Running %s on %s/%s: %s\n", suite.Name, project, previewName, suite.Command)
+
+			session, restore, err := localTerminal()
+			if err != nil {
+				return err
+			}
+			code, err := apiClient.RunTestSuite(ctx, project, previewName, suite.Name, session)
+			restore()
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				failed = append(failed, suite.Name)
+				fmt.Fprintf(os.Stderr, "%s failed (exit code %d)\n", suite.Name, code)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s passed\n", suite.Name)
+			}
+
+			if suite.JUnit != "" && testJUnitDir != "" {
+				if err := downloadJUnitReports(ctx, project, previewName, suite); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(failed) > 0 {
+			fmt.Fprintf(os.Stderr, "\nFailed suites: %s\n", strings.Join(failed, ", "))
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// selectTestSuites returns the suites named in names, in the given order,
+// or every suite if names is empty.
+func selectTestSuites(suites []client.TestSuite, names []string) ([]client.TestSuite, error) {
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("no test suites defined in preview.yml (add a \"tests:\" block)")
+	}
+	if len(names) == 0 {
+		return suites, nil
+	}
+
+	byName := make(map[string]client.TestSuite, len(suites))
+	available := make([]string, len(suites))
+	for i, s := range suites {
+		byName[s.Name] = s
+		available[i] = s.Name
+	}
+	selected := make([]client.TestSuite, 0, len(names))
+	for _, name := range names {
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown test suite %q (available: %s)", name, strings.Join(available, ", "))
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// downloadJUnitReports saves the JUnit reports of suite into
+// testJUnitDir/SUITE.
+func downloadJUnitReports(ctx context.Context, project, previewName string, suite client.TestSuite) error {
+	reports, err := apiClient.ListArtifacts(ctx, project, previewName, suite.JUnit)
+	if err != nil {
+		return fmt.Errorf("failed to list JUnit reports of %s: %w", suite.Name, err)
+	}
+	if len(reports) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s wrote no JUnit reports matching %s\n", suite.Name, suite.JUnit)
+		return nil
+	}
+
+	dir := filepath.Join(testJUnitDir, suite.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory: %w", err)
+	}
+	for _, r := range reports {
+		if err := downloadArtifact(ctx, project, previewName, r.Path, filepath.Join(dir, path.Base(r.Path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	testCmd.Flags().StringSliceVar(&testSuites, "suite", nil, "Suite to run (repeatable; default: all suites)")
+	testCmd.Flags().StringVar(&testJUnitDir, "junit-dir", "junit", "Directory for the downloaded JUnit reports (empty skips them)")
+	rootCmd.AddCommand(testCmd)
+}
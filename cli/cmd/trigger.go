@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var triggerAction string
+var triggerArgs string
+var triggerName string
+var triggerExpires string
+var triggerOutput string
+
+var triggerCmd = &cobra.Command{
+	Use:   "trigger",
+	Short: "Manage the trigger URLs of a preview",
+	Long: `A trigger URL runs one action on one preview — rebuild it, run drush cron
+or a given drush command — without a user token, so external systems such
+as a cron service, the CI of another project or a monitoring hook can call
+it. Anyone with the URL can run its action until it expires or is revoked:
+share it like a password. Requires the manager role.`,
+}
+
+var triggerCreateCmd = &cobra.Command{
+	Use:   "create [PROJECT/PREVIEW-NAME]",
+	Short: "Create a trigger URL for a preview",
+	Long: `Create a trigger URL running --action on a preview until it expires
+(--expires, 30d by default, at most 365d):
+
+  rebuild  re-clone and redeploy the preview (like 'preview rebuild')
+  drush    run the drush command given with --args
+  cron     run drush cron (see also 'preview cron-url')
+
+The URL is only shown now. Call it with POST; cron triggers also answer GET.
+
+If PROJECT/PREVIEW-NAME is given, creates the trigger for that specific
+preview. If no preview is specified, auto-detects the project from git
+remote and finds a preview matching the current git branch.
+
+Examples:
+  preview trigger create drupal-test/mr-5 --action rebuild --name upstream-ci
+  preview trigger create --action drush --args "search-api:index" --expires 7d`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch triggerAction {
+		case client.TriggerRebuild, client.TriggerCron:
+			if triggerArgs != "" {
+				return fmt.Errorf("--args only applies to --action drush")
+			}
+		case client.TriggerDrush:
+			if triggerArgs == "" {
+				return fmt.Errorf("--action drush needs the drush command to run in --args")
+			}
+		default:
+			return fmt.Errorf("invalid --action %q: expected rebuild, drush or cron", triggerAction)
+		}
+		return createTrigger(cmd.Context(), args, client.TriggerRequest{Action: triggerAction, Args: triggerArgs, Name: triggerName})
+	},
+}
+
+var cronURLCmd = &cobra.Command{
+	Use:   "cron-url [PROJECT/PREVIEW-NAME]",
+	Short: "Create a URL that runs drush cron on a preview",
+	Long: `Create a trigger URL running drush cron on a preview, for an external cron
+service: previews don't run Drupal's cron on their own. The URL answers
+GET and POST, works without a user token until it expires (--expires, 30d
+by default, at most 365d) and is only shown now. Revoke it with
+'preview trigger revoke'. Requires the manager role.
+
+If PROJECT/PREVIEW-NAME is given, creates the URL for that specific
+preview. If no preview is specified, auto-detects the project from git
+remote and finds a preview matching the current git branch.
+
+Examples:
+  preview cron-url drupal-test/mr-5
+  preview cron-url --expires 7d`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := triggerName
+		if name == "" {
+			name = "cron"
+		}
+		return createTrigger(cmd.Context(), args, client.TriggerRequest{Action: client.TriggerCron, Name: name})
+	},
+}
+
+var triggerListCmd = &cobra.Command{
+	Use:   "list [PROJECT/PREVIEW-NAME]",
+	Short: "List the trigger URLs of a preview",
+	Long: `List the trigger URLs of a preview, expired ones included. The URLs
+themselves can't be shown again: triggers are told apart by the start of
+their token.
+
+If PROJECT/PREVIEW-NAME is given, lists the triggers of that specific
+preview. If no preview is specified, auto-detects the project from git
+remote and finds a preview matching the current git branch.
+
+Examples:
+  preview trigger list drupal-test/mr-5
+  preview trigger list -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if triggerOutput != "text" && triggerOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", triggerOutput)
+		}
+		if err := requireTriggers(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveTriggerTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		triggers, err := apiClient.ListTriggers(cmd.Context(), project, previewName)
+		if err != nil {
+			return err
+		}
+
+		if triggerOutput == "json" {
+			data, err := json.MarshalIndent(triggers, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(triggers) == 0 {
+			fmt.Printf("%s/%s has no trigger URLs.\n", project, previewName)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tACTION\tTOKEN\tEXPIRES\tLAST USED\tUSES")
+		for _, t := range triggers {
+			action := t.Action
+			if t.Args != "" {
+				action += " " + t.Args
+			}
+			expires := "expired"
+			if at, err := time.Parse(time.RFC3339, t.ExpiresAt); err == nil && time.Until(at) > 0 {
+				expires = "in " + formatTimeLeft(time.Until(at))
+			}
+			lastUsed := "never"
+			if t.LastUsedAt != nil {
+				lastUsed = formatUploadTime(*t.LastUsedAt)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s...\t%s\t%s\t%d\n", t.ID, t.Name, action, t.TokenPrefix, expires, lastUsed, t.UseCount)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var triggerRevokeCmd = &cobra.Command{
+	Use:   "revoke [PROJECT/PREVIEW-NAME] ID",
+	Short: "Revoke a trigger URL of a preview",
+	Long: `Revoke a trigger URL of a preview by its ID (see 'preview trigger list'):
+it stops working at once.
+
+Examples:
+  preview trigger revoke drupal-test/mr-5 3
+  preview trigger revoke 3`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.Atoi(args[len(args)-1])
+		if err != nil {
+			return fmt.Errorf("invalid trigger ID %q", args[len(args)-1])
+		}
+		if err := requireTriggers(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveTriggerTarget(cmd.Context(), args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		if err := apiClient.DeleteTrigger(cmd.Context(), project, previewName, id); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked trigger %d of %s/%s.\n", id, project, previewName)
+		return nil
+	},
+}
+
+// createTrigger creates a trigger from the flags and prints its URL, the
+// only time it can be seen.
+func createTrigger(ctx context.Context, args []string, req client.TriggerRequest) error {
+	expiresIn, err := parseExpiry(triggerExpires)
+	if err != nil {
+		return err
+	}
+	req.ExpiresIn = expiresIn
+	if err := requireTriggers(); err != nil {
+		return err
+	}
+	project, previewName, err := resolveTriggerTarget(ctx, args)
+	if err != nil {
+		return err
+	}
+	trigger, err := apiClient.CreateTrigger(ctx, project, previewName, req)
+	if err != nil {
+		return err
+	}
+
+	action := trigger.Action
+	if trigger.Args != "" {
+		action += " " + trigger.Args
+	}
+	fmt.Fprintf(os.Stderr, "Trigger %d runs %s on %s/%s until %s:\n\n", trigger.ID, action, project, previewName, formatUploadTime(trigger.ExpiresAt))
+	fmt.Println(trigger.URL)
+	fmt.Fprintln(os.Stderr, "\nAnyone with this URL can run it, and it won't be shown again. Call it with:")
+	if trigger.Action == client.TriggerCron {
+		fmt.Fprintf(os.Stderr, "  curl -fsS %s\n", trigger.URL)
+	} else {
+		fmt.Fprintf(os.Stderr, "  curl -fsS -X POST %s\n", trigger.URL)
+	}
+	fmt.Fprintf(os.Stderr, "Revoke it with 'preview trigger revoke %s/%s %d'.\n", project, previewName, trigger.ID)
+	return nil
+}
+
+// parseExpiry parses an --expires value: a number of days such as "30d",
+// or a duration such as "12h".
+func parseExpiry(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= time.Second {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid --expires %q: expected days such as 30d, or a duration such as 12h", s)
+}
+
+func resolveTriggerTarget(ctx context.Context, args []string) (string, string, error) {
+	if len(args) == 1 {
+		return parsePreviewName(args[0])
+	}
+	return detectPreview(ctx)
+}
+
+func init() {
+	for _, c := range []*cobra.Command{triggerCreateCmd, cronURLCmd} {
+		c.Flags().StringVar(&triggerName, "name", "", "Name telling what the trigger is for")
+		c.Flags().StringVar(&triggerExpires, "expires", "30d", "Time until the trigger expires, e.g. 30d or 12h (at most 365d)")
+	}
+	triggerCreateCmd.Flags().StringVar(&triggerAction, "action", "", "Action to run: rebuild, drush or cron")
+	triggerCreateCmd.Flags().StringVar(&triggerArgs, "args", "", "With --action drush, the drush command to run")
+	triggerCreateCmd.MarkFlagRequired("action")
+	triggerListCmd.Flags().StringVarP(&triggerOutput, "output", "o", "text", "Output format: text or json")
+	triggerCmd.AddCommand(triggerCreateCmd, triggerListCmd, triggerRevokeCmd)
+	rootCmd.AddCommand(triggerCmd, cronURLCmd)
+}
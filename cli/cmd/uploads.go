@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var uploadsOutput string
+var uploadsProject string
+var uploadsAbortStale bool
+
+var uploadsCmd = &cobra.Command{
+	Use:   "uploads",
+	Short: "Manage the chunked uploads held by the server",
+	Long: `List and abort the chunked uploads of base files the server holds the
+chunks of: uploads in progress, stale ones that stopped receiving chunks
+(e.g. an interrupted 'preview push'), and orphaned ones whose metadata is
+missing. Their chunks use disk space until they complete or expire.
+Requires the manager role.`,
+}
+
+var uploadsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the chunked uploads on the server",
+	Long: `List the chunked uploads on the server, oldest first, with the bytes
+received so far, their age and state: active, stale (no chunk received for
+a while) or orphaned.
+
+Examples:
+  preview uploads list
+  preview uploads list --project drupal-test --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if uploadsOutput != "text" && uploadsOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", uploadsOutput)
+		}
+		if err := requireUploadSessions(); err != nil {
+			return err
+		}
+		uploads, err := listUploads(cmd)
+		if err != nil {
+			return err
+		}
+
+		if uploadsOutput == "json" {
+			if uploads == nil {
+				uploads = []client.UploadSession{}
+			}
+			data, err := json.MarshalIndent(uploads, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if len(uploads) == 0 {
+			fmt.Println("No uploads in progress.")
+			return nil
+		}
+		var total int64
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "UPLOAD ID\tPROJECT\tKIND\tRECEIVED\tCHUNKS\tSTARTED\tBY\tSTATE")
+		for _, u := range uploads {
+			chunks := fmt.Sprintf("%d", u.ChunksReceived)
+			if u.TotalChunks > 0 {
+				chunks = fmt.Sprintf("%d/%d", u.ChunksReceived, u.TotalChunks)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", u.UploadID, orDash(u.Project), orDash(u.Kind),
+				formatBytesShort(u.BytesReceived), chunks, formatUploadTime(u.CreatedAt), orDash(u.StartedBy), u.State)
+			total += u.BytesReceived
+		}
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\n%s held by %d upload(s). Abort one with 'preview uploads abort UPLOAD-ID'.\n", formatBytesShort(total), len(uploads))
+		return nil
+	},
+}
+
+var uploadsAbortCmd = &cobra.Command{
+	Use:   "abort [UPLOAD-ID...]",
+	Short: "Abort chunked uploads and free their disk space",
+	Long: `Abort the uploads with the given IDs (see 'preview uploads list') and
+delete the chunks received. An upload still being sent by a 'preview push'
+then fails.
+
+With --stale, aborts every stale and orphaned upload (of --project, if
+given) after confirmation.
+
+Examples:
+  preview uploads abort 0f8c2d1e-5b7a-4c3e-9d2f-6a1b8e4c7d90
+  preview uploads abort --stale --yes`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if uploadsAbortStale == (len(args) > 0) {
+			return fmt.Errorf("give the upload IDs to abort, or --stale")
+		}
+		if err := requireUploadSessions(); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+
+		ids := args
+		if uploadsAbortStale {
+			uploads, err := listUploads(cmd)
+			if err != nil {
+				return err
+			}
+			var held int64
+			for _, u := range uploads {
+				if u.State == "stale" || u.State == "orphaned" {
+					ids = append(ids, u.UploadID)
+					held += u.BytesReceived
+				}
+			}
+			if len(ids) == 0 {
+				fmt.Println("No stale uploads.")
+				return nil
+			}
+			ok, err := confirm(fmt.Sprintf("Abort %d stale upload(s) holding %s?", len(ids), formatBytesShort(held)))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("aborted")
+			}
+		}
+
+		var total int64
+		var failed int
+		for _, id := range ids {
+			freed, err := apiClient.AbortUpload(ctx, id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("Aborted %s (%s freed).\n", id, formatBytesShort(freed))
+			total += freed
+		}
+		if len(ids) > 1 {
+			fmt.Printf("%s freed in total.\n", formatBytesShort(total))
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d upload(s) could not be aborted", failed, len(ids))
+		}
+		return nil
+	},
+}
+
+// listUploads returns the uploads of the server, of --project if given.
+func listUploads(cmd *cobra.Command) ([]client.UploadSession, error) {
+	uploads, err := apiClient.ListUploads(cmd.Context())
+	if err != nil || uploadsProject == "" {
+		return uploads, err
+	}
+	var filtered []client.UploadSession
+	for _, u := range uploads {
+		if u.Project == uploadsProject {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	uploadsCmd.PersistentFlags().StringVar(&uploadsProject, "project", "", "Only uploads of this project")
+	uploadsListCmd.Flags().StringVarP(&uploadsOutput, "output", "o", "text", "Output format: text or json")
+	uploadsAbortCmd.Flags().BoolVar(&uploadsAbortStale, "stale", false, "Abort every stale and orphaned upload")
+	uploadsCmd.AddCommand(uploadsListCmd, uploadsAbortCmd)
+	rootCmd.AddCommand(uploadsCmd)
+}
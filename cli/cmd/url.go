@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var urlULI bool
+var urlCopy bool
+var urlQR bool
+
+var urlCmd = &cobra.Command{
+	Use:   "url [PROJECT/PREVIEW-NAME]",
+	Short: "Print the URL of a preview",
+	Long: `Print the URL of a preview, or with --uli a one-time login link
+(drush uli).
+
+--copy copies it to the clipboard and --qr renders it as a QR code in the
+terminal, so testers can open the preview on a phone without typing it.
+Preview URLs have the form https://{{preview_domain}}.
+
+If PROJECT/PREVIEW-NAME is given, uses that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview url drupal-test/mr-5
+  preview url drupal-test/mr-5 --uli --copy
+  preview url --qr`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		var project, previewName string
+		var err error
+		if len(args) == 1 {
+			project, previewName, err = parsePreviewName(args[0])
+		} else {
+			project, previewName, err = detectPreview(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		preview, err := findPreview(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+		if preview.URL == "" {
+			return fmt.Errorf("preview %s/%s has no URL yet", project, previewName)
+		}
+
+		link := preview.URL
+		if urlULI {
+			result, err := apiClient.PostDrushByName(ctx, project, previewName, "uli --uri="+preview.URL)
+			if err != nil {
+				return err
+			}
+			if !result.Success {
+				printActionResult(result)
+				os.Exit(1)
+			}
+			link = lastURL(result.Output)
+			if link == "" {
+				return fmt.Errorf("drush uli printed no link: %s", strings.TrimSpace(result.Output))
+			}
+		}
+
+		fmt.Println(link)
+		if urlCopy {
+			if err := copyToClipboard(link); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "Copied to clipboard.")
+		}
+		if urlQR {
+			qr, err := encodeQR(link)
+			if err != nil {
+				return err
+			}
+			qr.render(os.Stderr)
+		}
+		return nil
+	},
+}
+
+// lastURL returns the last line of output that is a URL.
+func lastURL(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			return line
+		}
+	}
+	return ""
+}
+
+func init() {
+	urlCmd.Flags().BoolVar(&urlULI, "uli", false, "Print a one-time login link instead (drush uli)")
+	urlCmd.Flags().BoolVar(&urlCopy, "copy", false, "Copy the URL to the clipboard")
+	urlCmd.Flags().BoolVar(&urlQR, "qr", false, "Render the URL as a QR code in the terminal")
+	rootCmd.AddCommand(urlCmd)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var validateFile string
+var validatePreview string
+var validateRef string
+var validateOutput string
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check preview configuration before committing it",
+}
+
+var validateRemoteCmd = &cobra.Command{
+	Use:   "remote [PROJECT]",
+	Short: "Pre-flight check preview.yml on the preview server",
+	Long: `Send the local preview.yml, committed or not, to the preview server, which
+checks it against what a deploy needs, without deploying:
+
+  - the YAML parses and every key and value is understood
+  - the PHP version has an image on the server, and the database image exists
+  - the server has room for the services and resources it asks for
+  - the preview domain is valid and not taken by another preview
+  - the deploy scripts and docroot exist at the target commit
+
+Each problem comes with a hint to fix it. Exits with a non-zero status if
+any check fails, so CI can run it before pushing.
+
+PROJECT defaults to the project of the git remote. The checks are for the
+preview of the current branch (--preview to choose another, e.g. mr-5) at
+the HEAD commit (--ref); if the commit isn't pushed yet, the deploy scripts
+are checked at the branch.
+
+Examples:
+  preview validate remote
+  preview validate remote drupal-test --preview mr-5
+  preview validate remote --file preview.next.yml --output json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if validateOutput != "text" && validateOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", validateOutput)
+		}
+		if err := requireValidate(); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+
+		var project string
+		if len(args) == 1 {
+			project = args[0]
+		} else {
+			var err error
+			if project, err = detectProjectSlug(); err != nil {
+				return err
+			}
+		}
+
+		req := client.ValidateRequest{Preview: validatePreview, Ref: validateRef}
+		data, err := os.ReadFile(validateFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !cmd.Flags().Changed("file"):
+			fmt.Fprintln(os.Stderr, "No preview.yml; checking the defaults.")
+		case err != nil:
+			return err
+		default:
+			req.PreviewYml = string(data)
+		}
+		if branch, err := detectGitBranch(); err == nil {
+			req.Branch = branch
+			if req.Preview == "" {
+				// The preview bound to the branch or an existing preview
+				// of it, else the server names a branch preview after it
+				if bound, previewName, ok := boundPreview(branch); ok && bound == project {
+					req.Preview = previewName
+				} else if p, err := findPreviewByBranch(ctx, project, branch); err == nil {
+					req.Preview = p.Name
+				}
+			}
+		}
+		if req.Ref == "" {
+			req.Ref, _ = detectGitCommit()
+		}
+
+		fmt.Fprintf(os.Stderr, "Validating %s for %s...\n", validateFile, project)
+		result, err := apiClient.ValidatePreviewYml(ctx, project, req)
+		if err != nil {
+			return err
+		}
+
+		if validateOutput == "json" {
+			out, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		} else {
+			printValidation(result)
+		}
+		if !result.Valid {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// printValidation prints the checks of a validation, with the hints of
+// those that didn't pass.
+func printValidation(result *client.ValidationResult) {
+	errs, warnings := 0, 0
+	for _, c := range result.Checks {
+		mark := "✓"
+		switch c.Status {
+		case "error":
+			mark = "✗"
+			errs++
+		case "warning":
+			mark = "⚠"
+			warnings++
+		case "skipped":
+			mark = "·"
+		}
+		fmt.Printf("  %s %-10s %s\n", mark, c.Name, c.Message)
+		if c.Hint != "" && c.Status != "ok" {
+			fmt.Printf("    %-10s → %s\n", "", c.Hint)
+		}
+	}
+
+	fmt.Println()
+	target := result.Preview
+	if target == "" {
+		target = "the preview"
+	}
+	if ref := result.Ref; ref != "" {
+		if strings.Trim(ref, "0123456789abcdef") == "" {
+			ref = shortSHA(ref)
+		}
+		target += " at " + ref
+	}
+	switch {
+	case errs > 0:
+		fmt.Printf("%d errors, %d warnings: a deploy of %s would fail.\n", errs, warnings, target)
+	case warnings > 0:
+		fmt.Printf("No errors, %d warnings for %s.\n", warnings, target)
+	default:
+		fmt.Printf("All checks passed for %s.\n", target)
+	}
+}
+
+func init() {
+	validateRemoteCmd.Flags().StringVar(&validateFile, "file", "preview.yml", "preview.yml to check")
+	validateRemoteCmd.Flags().StringVar(&validatePreview, "preview", "", "Preview to check the domain and deploy script overrides of, e.g. mr-5 (default: that of the current branch)")
+	validateRemoteCmd.Flags().StringVar(&validateRef, "ref", "", "Commit or branch to check the deploy scripts at (default: HEAD)")
+	validateRemoteCmd.Flags().StringVarP(&validateOutput, "output", "o", "text", "Output format: text or json")
+	validateCmd.AddCommand(validateRemoteCmd)
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var versionCheck bool
+var versionOutput string
+
+var versionCmd = &cobra.Command{
+	Use:         "version",
+	Short:       "Show the CLI version and whether it is up to date",
+	Annotations: map[string]string{noAuthAnnotation: "true"},
+	Long: `Show the version of the CLI, the latest one the server publishes and the
+API versions both speak.
+
+--check exits with a non-zero status if the CLI is out of date: a newer
+version is published, the server requires a newer one or it speaks no API
+version of the CLI, and if the server can't be reached to tell. For CI jobs
+that should use the CLI the server publishes.
+
+Server admins can require a minimum CLI version (required_cli_version) to
+turn away releases known to be broken; older CLIs then refuse to run any
+command but self-update, version and help.
+
+Examples:
+  preview version
+  preview version --check
+  preview version --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if versionOutput != "text" && versionOutput != "json" {
+			return fmt.Errorf("invalid --output %q: expected text or json", versionOutput)
+		}
+		cfg := loadConfig()
+		// Not saved: only setup api and login set the server
+		apiURL := cfg.APIURL
+		if apiURL == "" {
+			apiURL = defaultAPIURL
+		}
+
+		info, err := newPublicClient(apiURL).GetVersionInfo(cmd.Context(), cfg.Channel)
+		var problems []string
+		if err != nil {
+			// The local version is still worth showing; --check fails as
+			// the CLI can't be known to be up to date
+			info = &client.VersionInfo{}
+			problems = []string{fmt.Sprintf("can't reach %s: %v", apiURL, err)}
+		} else {
+			cfg.LatestVersion = info.Version
+			cfg.RequiredCLIVersion = info.RequiredVersion
+			cfg.LastVersionCheck = time.Now().Unix()
+			saveConfig(cfg)
+			problems = versionProblems(info)
+		}
+
+		if versionOutput == "json" {
+			data, err := json.MarshalIndent(versionReport{
+				CLIVersion:        Version,
+				CLIAPIVersions:    [2]int{client.MinAPIVersion, client.MaxAPIVersion},
+				LatestVersion:     info.Version,
+				Channel:           updateChannel(cfg),
+				RequiredVersion:   info.RequiredVersion,
+				ServerAPIVersions: [2]int{info.MinAPIVersion, info.MaxAPIVersion},
+				Server:            apiURL,
+				UpToDate:          len(problems) == 0,
+				Problems:          problems,
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else if info.Version == "" {
+			fmt.Printf("CLI:         v%s (API %s)\n", Version, apiRange(client.MinAPIVersion, client.MaxAPIVersion))
+			fmt.Printf("Latest:      unknown (%s)\n", updateChannel(cfg))
+			fmt.Printf("Server:      %s\n", apiURL)
+			fmt.Fprintf(os.Stderr, "Can't check for updates: %s\n", problems[0])
+		} else {
+			fmt.Printf("CLI:         v%s (API %s)\n", Version, apiRange(client.MinAPIVersion, client.MaxAPIVersion))
+			fmt.Printf("Latest:      v%s (%s)\n", info.Version, updateChannel(cfg))
+			if info.RequiredVersion != "" {
+				fmt.Printf("Required:    v%s or later\n", info.RequiredVersion)
+			}
+			if info.MaxAPIVersion > 0 {
+				fmt.Printf("Server API:  %s\n", apiRange(info.MinAPIVersion, info.MaxAPIVersion))
+			} else {
+				fmt.Println("Server API:  unknown (the server predates reporting it)")
+			}
+			fmt.Printf("Server:      %s\n", apiURL)
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "Out of date: %s\n", p)
+			}
+			if len(problems) > 0 {
+				fmt.Fprintln(os.Stderr, "Run 'preview self-update' to update.")
+			}
+		}
+
+		if versionCheck && len(problems) > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// versionReport is the --output json of version.
+type versionReport struct {
+	CLIVersion     string `json:"cli_version"`
+	CLIAPIVersions [2]int `json:"cli_api_versions"`
+	LatestVersion  string `json:"latest_version"`
+	Channel        string `json:"channel"`
+	// RequiredVersion is the oldest CLI the server allows, empty if any
+	RequiredVersion string `json:"required_version"`
+	// ServerAPIVersions is [0, 0] if the server doesn't report them
+	ServerAPIVersions [2]int   `json:"server_api_versions"`
+	Server            string   `json:"server"`
+	UpToDate          bool     `json:"up_to_date"`
+	Problems          []string `json:"problems"`
+}
+
+// versionProblems returns why the CLI is out of date for the server
+// described by info, none if it isn't. Development builds never are.
+func versionProblems(info *client.VersionInfo) []string {
+	problems := []string{}
+	if newer, ok := compareVersions(info.Version, Version); ok && newer > 0 {
+		problems = append(problems, fmt.Sprintf("v%s is available", info.Version))
+	}
+	if older, ok := compareVersions(Version, info.RequiredVersion); ok && older < 0 {
+		problems = append(problems, fmt.Sprintf("the server requires v%s or later", info.RequiredVersion))
+	}
+	if info.MaxAPIVersion > 0 && (info.MaxAPIVersion < client.MinAPIVersion || info.MinAPIVersion > client.MaxAPIVersion) {
+		problems = append(problems, fmt.Sprintf("the server speaks API %s, the CLI %s",
+			apiRange(info.MinAPIVersion, info.MaxAPIVersion), apiRange(client.MinAPIVersion, client.MaxAPIVersion)))
+	}
+	return problems
+}
+
+// apiRange formats a range of API versions, e.g. "1-2" or "1".
+func apiRange(low, high int) string {
+	if low == high {
+		return strconv.Itoa(low)
+	}
+	return fmt.Sprintf("%d-%d", low, high)
+}
+
+// compareVersions compares the releases a and b ("1.8.0", "v1.8.0" or
+// "1.9.0-beta.1"), returning -1, 0 or 1, and false if either isn't a
+// release, like "dev". A pre-release comes before its release.
+func compareVersions(a, b string) (int, bool) {
+	pa, prea, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+	pb, preb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	switch {
+	case prea == preb:
+		return 0, true
+	case prea == "":
+		return 1, true
+	case preb == "":
+		return -1, true
+	case prea < preb:
+		return -1, true
+	default:
+		return 1, true
+	}
+}
+
+// parseVersion splits a release into its major, minor and patch numbers
+// and its pre-release suffix.
+func parseVersion(v string) (parts [3]int, pre string, ok bool) {
+	v, pre, _ = strings.Cut(strings.TrimPrefix(strings.TrimSpace(v), "v"), "-")
+	fields := strings.Split(v, ".")
+	if len(fields) > 3 {
+		return parts, "", false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, "", false
+		}
+		parts[i] = n
+	}
+	return parts, pre, true
+}
+
+// checkRequiredVersion stops the CLI if the server requires a newer one
+// (required_cli_version), except for the commands that fix or explain it.
+func checkRequiredVersion(cmd *cobra.Command, cfg config) {
+	switch cmd.Name() {
+	case "self-update", "version", "help", "completion":
+		return
+	}
+	if older, ok := compareVersions(Version, cfg.RequiredCLIVersion); !ok || older >= 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: this preview CLI (v%s) is no longer allowed by %s, which requires v%s or later\n", Version, cfg.APIURL, cfg.RequiredCLIVersion)
+	fmt.Fprintln(os.Stderr, "Older releases have known problems with this server. Run 'preview self-update' to update.")
+	os.Exit(1)
+}
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionCheck, "check", false, "Exit with a non-zero status if the CLI is out of date")
+	versionCmd.Flags().StringVarP(&versionOutput, "output", "o", "text", "Output format: text or json")
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var watchExec string
+var watchInterval time.Duration
+var watchTimeout time.Duration
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [PROJECT/PREVIEW-NAME]",
+	Short: "Wait until a preview is ready, then run a command",
+	Long: `Wait until a preview is running and its deployment has succeeded,
+printing its state changes. The preview doesn't need to exist yet, so
+watch can be started right after pushing a branch.
+
+With --exec, the command is then run through the shell. {{url}},
+{{branch}}, {{project}} and {{name}} in it are replaced with the
+preview's (shell-quoted), and the command also gets them in the
+PREVIEW_URL, PREVIEW_BRANCH, PREVIEW_PROJECT and PREVIEW_NAME environment
+variables, plus the basic auth credentials in PREVIEW_BASIC_AUTH_USER and
+PREVIEW_BASIC_AUTH_PASS. watch exits with the command's exit code.
+
+A failed deployment ends the watch with an error, unless it had already
+failed when watch started: a new pipeline may be on its way.
+
+If PROJECT/PREVIEW-NAME is given, watches that specific preview.
+If no argument is given, auto-detects the project from git remote and
+watches the preview of the current git branch.
+
+Examples:
+  preview watch drupal-test/mr-5
+  preview watch --exec "./scripts/smoke.sh {{url}}"
+  preview watch drupal-test/mr-5 --timeout 1h --exec 'npx playwright test'
+  preview watch drupal-test/mr-5 --metrics-addr 127.0.0.1:9464`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchInterval < time.Second {
+			return fmt.Errorf("--interval must be at least 1s")
+		}
+		if err := startMetrics(); err != nil {
+			return err
+		}
+
+		var project, label string
+		var match func(client.Preview) bool
+		if len(args) == 1 {
+			var previewName string
+			var err error
+			project, previewName, err = parsePreviewName(args[0])
+			if err != nil {
+				return err
+			}
+			label = project + "/" + previewName
+			match = func(p client.Preview) bool { return p.Name == previewName }
+		} else {
+			slug, err := detectProjectSlug()
+			if err != nil {
+				return err
+			}
+			branch, err := detectGitBranch()
+			if err != nil {
+				return err
+			}
+			project, label = slug, fmt.Sprintf("%s (branch %s)", slug, branch)
+			match = func(p client.Preview) bool { return p.Branch == branch }
+		}
+
+		ctx := cmd.Context()
+		if watchTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, watchTimeout)
+			defer cancel()
+		}
+
+		preview, err := waitForPreview(ctx, project, label, match)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%s not ready after %s", label, watchTimeout)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Preview ready: %s\n", preview.URL)
+
+		if watchExec == "" {
+			return nil
+		}
+		code, err := runReadyHook(watchExec, preview)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+// waitForPreview polls the previews of project until the one matching
+// match is running and its latest deployment succeeded.
+func waitForPreview(ctx context.Context, project, label string, match func(client.Preview) bool) (*client.Preview, error) {
+	fmt.Fprintf(os.Stderr, "Waiting for %s to be ready...\n", label)
+
+	failedAtStart := -1
+	lastState := ""
+	for first := true; ; first = false {
+		result, err := apiClient.ListPreviewsPage(ctx, client.ListOptions{Project: project, IncludeStatus: true})
+		if err != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("failed to list previews: %w", err)
+		}
+
+		var preview *client.Preview
+		if err == nil {
+			for i := range result.Previews {
+				if match(result.Previews[i]) {
+					preview = &result.Previews[i]
+					break
+				}
+			}
+		}
+
+		state := "not created yet"
+		if preview != nil {
+			state = preview.Status
+			if d := preview.LastDeployment; d != nil {
+				state += ", deployment " + d.Status
+				if d.Status == "failed" && first {
+					failedAtStart = d.ID
+				}
+				if d.Status == "failed" && d.ID != failedAtStart {
+					if d.Error != "" {
+						return nil, fmt.Errorf("deployment of %s/%s failed: %s", preview.Project, preview.Name, d.Error)
+					}
+					return nil, fmt.Errorf("deployment of %s/%s failed", preview.Project, preview.Name)
+				}
+			}
+			if preview.Status == "running" && preview.URL != "" && (preview.LastDeployment == nil || preview.LastDeployment.Status == "success") {
+				return preview, nil
+			}
+		}
+		if state != lastState && err == nil {
+			fmt.Fprintf(os.Stderr, "  %s  %s\n", time.Now().Format("15:04:05"), state)
+			lastState = state
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(watchInterval):
+		}
+	}
+}
+
+// runReadyHook runs command through the shell with the placeholders and
+// environment of preview, and returns its exit code.
+func runReadyHook(command string, preview *client.Preview) (int, error) {
+	values := []struct{ placeholder, env, value string }{
+		{"{{url}}", "PREVIEW_URL", preview.URL},
+		{"{{branch}}", "PREVIEW_BRANCH", preview.Branch},
+		{"{{project}}", "PREVIEW_PROJECT", preview.Project},
+		{"{{name}}", "PREVIEW_NAME", preview.Name},
+	}
+	env := os.Environ()
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v.placeholder, shellQuote(v.value))
+		env = append(env, v.env+"="+v.value)
+	}
+	if preview.BasicAuthUser != nil && preview.BasicAuthPass != nil {
+		env = append(env, "PREVIEW_BASIC_AUTH_USER="+*preview.BasicAuthUser, "PREVIEW_BASIC_AUTH_PASS="+*preview.BasicAuthPass)
+	}
+	command = strings.NewReplacer(pairs...).Replace(command)
+
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("sh", "-c", command)
+	}
+	c.Env = env
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	fmt.Fprintf(os.Stderr, "Running: %s\n", command)
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to run --exec command: %w", err)
+	}
+	return 0, nil
+}
+
+// shellQuote quotes s as a single word for sh. cmd.exe has no equivalent;
+// values are passed as is there.
+func shellQuote(s string) string {
+	// Trim leaves nothing only if every character is safe unquoted
+	if runtime.GOOS == "windows" || s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchExec, "exec", "", "Command to run once the preview is ready")
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 10*time.Second, "Time between status checks")
+	watchCmd.Flags().DurationVar(&watchTimeout, "timeout", 30*time.Minute, "Give up after this long (0 waits forever)")
+	addMetricsFlag(watchCmd)
+	rootCmd.AddCommand(watchCmd)
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/spf13/cobra"
+)
+
+var xdebugIDEPort int
+var xdebugClientHost string
+
+var xdebugCmd = &cobra.Command{
+	Use:   "xdebug",
+	Short: "Step-debug a preview with Xdebug",
+	Long: `Turn Xdebug of a preview's PHP container on or off, to step-debug it
+from an IDE on this machine. Xdebug is off by default, as it slows PHP down.`,
+}
+
+var xdebugOnCmd = &cobra.Command{
+	Use:   "on [PROJECT/PREVIEW-NAME]",
+	Short: "Turn Xdebug on and relay debug sessions to the local IDE",
+	Long: `Turn Xdebug of a preview on and print how to set up the IDE: the IDE key,
+the port to listen on and the path mapping of the preview's code.
+
+Debug sessions start on requests with XDEBUG_TRIGGER=PREVIEW in the query
+string or a cookie (the Xdebug browser extensions set it), and are relayed
+through the preview server to the IDE listening on --ide-port (9003) of
+this machine, so no port has to be reachable from the server. Xdebug stays
+on until Ctrl+C, which turns it off again.
+
+With --client-host, Xdebug connects straight to that host instead, which
+the preview must be able to reach (e.g. over a VPN); it stays on until
+preview xdebug off. Turning Xdebug on or off restarts PHP, aborting
+running requests.
+
+If PROJECT/PREVIEW-NAME is given, debugs that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview xdebug on drupal-test/mr-5
+  preview xdebug on --ide-port 9000
+  preview xdebug on drupal-test/mr-5 --client-host 10.8.0.12`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireXdebug(); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		project, previewName, err := resolveXdebugTarget(ctx, args)
+		if err != nil {
+			return err
+		}
+		previewURL := ""
+		if preview, err := findPreview(ctx, project, previewName); err == nil {
+			previewURL = preview.URL
+		}
+
+		if xdebugClientHost != "" {
+			info, err := apiClient.SetXdebug(ctx, project, previewName, client.XdebugRequest{
+				Enabled:    true,
+				ClientHost: xdebugClientHost,
+				ClientPort: xdebugIDEPort,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Xdebug on for %s/%s, connecting to %s:%d.\n\n", project, previewName, info.ClientHost, info.ClientPort)
+			printXdebugSetup(info, previewURL)
+			fmt.Fprintf(os.Stderr, "Turn it off with: preview xdebug off %s/%s\n", project, previewName)
+			return nil
+		}
+
+		relay, err := apiClient.RelayXdebug(ctx, project, previewName)
+		if err != nil {
+			return err
+		}
+		defer relay.Close()
+		info := relay.Info
+		info.ClientHost = "127.0.0.1"
+		info.ClientPort = xdebugIDEPort
+
+		fmt.Fprintf(os.Stderr, "Xdebug on for %s/%s, relaying debug sessions to 127.0.0.1:%d.\n\n", project, previewName, xdebugIDEPort)
+		printXdebugSetup(&info, previewURL)
+		if !ideListening(xdebugIDEPort) {
+			fmt.Fprintf(os.Stderr, "Warning: nothing listens on port %d yet; start listening for debug connections in your IDE.\n\n", xdebugIDEPort)
+		}
+		fmt.Fprintln(os.Stderr, "Press Ctrl+C to turn Xdebug off.")
+
+		go func() {
+			<-ctx.Done()
+			relay.Close()
+		}()
+		err = serveXdebugRelay(relay, xdebugIDEPort)
+		if ctx.Err() == nil && err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "\nXdebug off.")
+		return nil
+	},
+}
+
+var xdebugOffCmd = &cobra.Command{
+	Use:   "off [PROJECT/PREVIEW-NAME]",
+	Short: "Turn Xdebug off",
+	Long: `Turn Xdebug of a preview off, after preview xdebug on --client-host.
+Restarts PHP, aborting running requests.
+
+If PROJECT/PREVIEW-NAME is given, uses that specific preview.
+If no preview is specified, auto-detects the project from git remote
+and finds a preview matching the current git branch.
+
+Examples:
+  preview xdebug off drupal-test/mr-5`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireXdebug(); err != nil {
+			return err
+		}
+		project, previewName, err := resolveXdebugTarget(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		if _, err := apiClient.SetXdebug(cmd.Context(), project, previewName, client.XdebugRequest{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Xdebug off for %s/%s.\n", project, previewName)
+		return nil
+	},
+}
+
+func resolveXdebugTarget(ctx context.Context, args []string) (string, string, error) {
+	if len(args) == 1 {
+		return parsePreviewName(args[0])
+	}
+	return detectPreview(ctx)
+}
+
+// printXdebugSetup prints how to debug a preview with Xdebug set up as
+// info from PhpStorm and VS Code.
+func printXdebugSetup(info *client.XdebugInfo, previewURL string) {
+	localRoot := projectRoot()
+	trigger := "?XDEBUG_TRIGGER=" + info.IDEKey
+	if previewURL != "" {
+		trigger = strings.TrimRight(previewURL, "/") + "/" + trigger
+	}
+	serverName := strings.TrimPrefix(strings.TrimPrefix(previewURL, "https://"), "http://")
+	if serverName == "" {
+		serverName = "the preview's host name"
+	}
+
+	fmt.Fprintf(os.Stderr, "  IDE key:       %s\n", info.IDEKey)
+	fmt.Fprintf(os.Stderr, "  IDE port:      %d\n", info.ClientPort)
+	fmt.Fprintf(os.Stderr, "  Path mapping:  %s -> %s\n", info.DocumentRoot, localRoot)
+	fmt.Fprintf(os.Stderr, "  Trigger:       %s\n", trigger)
+	fmt.Fprintf(os.Stderr, "                 (or a cookie XDEBUG_TRIGGER=%s, as set by the Xdebug browser extensions)\n\n", info.IDEKey)
+
+	fmt.Fprintln(os.Stderr, "PhpStorm: in Settings > PHP > Debug listen on the IDE port, then in")
+	fmt.Fprintf(os.Stderr, "Settings > PHP > Servers add %s, with \"Use path mappings\"\n", serverName)
+	fmt.Fprintf(os.Stderr, "mapping %s to %s, and Start Listening for PHP Debug Connections.\n\n", localRoot, info.DocumentRoot)
+
+	fmt.Fprintln(os.Stderr, "VS Code (PHP Debug extension), in .vscode/launch.json:")
+	fmt.Fprintln(os.Stderr, "  {")
+	fmt.Fprintln(os.Stderr, "    \"name\": \"Listen for Xdebug (preview)\",")
+	fmt.Fprintln(os.Stderr, "    \"type\": \"php\",")
+	fmt.Fprintln(os.Stderr, "    \"request\": \"launch\",")
+	fmt.Fprintf(os.Stderr, "    \"port\": %d,\n", info.ClientPort)
+	fmt.Fprintf(os.Stderr, "    \"pathMappings\": {\"%s\": \"${workspaceFolder}\"}\n", info.DocumentRoot)
+	fmt.Fprintln(os.Stderr, "  }")
+	fmt.Fprintln(os.Stderr)
+}
+
+// projectRoot returns the root of the git checkout of the working
+// directory, or the working directory outside of one.
+func projectRoot() string {
+	if out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
+
+// ideListening reports whether something listens on port of this machine.
+func ideListening(port int) bool {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// serveXdebugRelay hands each debug session of relay to the IDE listening
+// on port until the relay closes, then closes them all.
+func serveXdebugRelay(relay *client.XdebugRelay, port int) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		session, err := relay.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer session.Close()
+			ide, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: dropped a debug session, nothing listens on port %d: start listening for debug connections in your IDE\n", port)
+				return
+			}
+			defer ide.Close()
+			fmt.Fprintln(os.Stderr, "Debug session started.")
+
+			// Either side closing ends the session
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(ide, session); done <- struct{}{} }()
+			go func() { io.Copy(session, ide); done <- struct{}{} }()
+			select {
+			case <-done:
+			case <-relay.Done():
+			}
+		}()
+	}
+}
+
+func init() {
+	xdebugOnCmd.Flags().IntVar(&xdebugIDEPort, "ide-port", 9003, "Port the IDE listens for debug connections on")
+	xdebugOnCmd.Flags().StringVar(&xdebugClientHost, "client-host", "", "Have Xdebug connect straight to this host, reachable from the preview, instead of relaying")
+	xdebugCmd.AddCommand(xdebugOnCmd, xdebugOffCmd)
+	rootCmd.AddCommand(xdebugCmd)
+}
@@ -2,9 +2,21 @@ module github.com/preview-manager/cli
 
 go 1.21
 
-require github.com/spf13/cobra v1.8.1
+require (
+	github.com/capynet/preview-server/client v0.0.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.20.0
+	golang.org/x/term v0.20.0
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
+)
 
 require (
+	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
 )
+
+replace github.com/capynet/preview-server/client => ../client
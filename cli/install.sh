@@ -2,6 +2,8 @@
 set -e
 
 BASE_URL="https://api.preview-mr.com/api/cli"
+# stable or beta, e.g. curl ... | PREVIEW_CLI_CHANNEL=beta sh
+CHANNEL="${PREVIEW_CLI_CHANNEL:-stable}"
 
 # Detect OS
 OS=$(uname -s | tr '[:upper:]' '[:lower:]')
@@ -25,12 +27,12 @@ case "$ARCH" in
     ;;
 esac
 
-DOWNLOAD_URL="${BASE_URL}/download/${OS}/${ARCH}"
+DOWNLOAD_URL="${BASE_URL}/download/${OS}/${ARCH}?channel=${CHANNEL}"
 INSTALL_DIR="${HOME}/.local/bin"
 BINARY_NAME="preview"
 TMP_FILE=$(mktemp)
 
-echo "Downloading preview CLI for ${OS}/${ARCH}..."
+echo "Downloading preview CLI (${CHANNEL}) for ${OS}/${ARCH}..."
 if ! curl -fsSL "$DOWNLOAD_URL" -o "$TMP_FILE"; then
   echo "Error: Failed to download binary from $DOWNLOAD_URL"
   rm -f "$TMP_FILE"
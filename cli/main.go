@@ -12,5 +12,6 @@ var version string
 
 func main() {
 	cmd.SetVersion(strings.TrimSpace(version))
+	cmd.SetReleaseKey(releaseKey)
 	cmd.Execute()
 }
@@ -0,0 +1,11 @@
+//go:build release
+
+package main
+
+import _ "embed"
+
+// releaseKey is the minisign public key of the releases, see build.sh.
+// Release builds fail if release.pub is missing.
+//
+//go:embed release.pub
+var releaseKey string
@@ -0,0 +1,7 @@
+//go:build !release
+
+package main
+
+// releaseKey is empty in development builds, whose self-update needs
+// --insecure-skip-signature.
+const releaseKey = ""
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// AllowlistRequest adds a network to the IP allowlist of a project, or of
+// one of its previews.
+type AllowlistRequest struct {
+	// CIDR is an address or a network, e.g. "203.0.113.0/24".
+	CIDR string `json:"cidr"`
+	// PreviewName restricts the entry to one preview; empty for every
+	// preview of the project.
+	PreviewName string `json:"preview_name,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// AllowlistEntry is a network allowed to reach the previews of a project.
+// Once a preview has any, through its project or its own, requests from
+// other addresses are refused even with a login.
+type AllowlistEntry struct {
+	ID      int    `json:"id"`
+	Project string `json:"project"`
+	// PreviewName is nil for entries of the whole project.
+	PreviewName *string `json:"preview_name"`
+	// CIDR is normalized by the server, e.g. "203.0.113.7/32" for a single
+	// address.
+	CIDR      string `json:"cidr"`
+	Note      string `json:"note"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Allowlist is the response of ListAllowlist.
+type Allowlist struct {
+	// ClientIP is the address the server saw the request come from.
+	ClientIP string           `json:"client_ip"`
+	Entries  []AllowlistEntry `json:"entries"`
+}
+
+// ListAllowlist returns the IP allowlist of a project and its previews.
+// Requires the manager role.
+func (c *Client) ListAllowlist(ctx context.Context, project string) (*Allowlist, error) {
+	var allowlist Allowlist
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/projects/%s/allowlist", c.BaseURL, project), nil, &allowlist); err != nil {
+		return nil, err
+	}
+	return &allowlist, nil
+}
+
+// AddAllowlistEntry adds a network to the IP allowlist of a project or
+// preview. Requires the manager role.
+func (c *Client) AddAllowlistEntry(ctx context.Context, project string, req AllowlistRequest) (*AllowlistEntry, error) {
+	var entry AllowlistEntry
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/projects/%s/allowlist", c.BaseURL, project), req, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// DeleteAllowlistEntry removes an entry of the IP allowlist of a project.
+// Removing the last one opens its previews to any address again. Requires
+// the manager role.
+func (c *Client) DeleteAllowlistEntry(ctx context.Context, project string, id int) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/projects/%s/allowlist/%d", c.BaseURL, project, id), nil, nil)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// AnonymizeRequest is the request of AnonymizePreviewDB.
+type AnonymizeRequest struct {
+	// DryRun only returns the statements of the recipe, without running
+	// them.
+	DryRun bool `json:"dry_run,omitempty"`
+	// PreviewYml is the content of a preview.yml whose "sanitize:" block
+	// (truncate, scrub, sql) runs instead of that of the preview, e.g. for
+	// previews of branches that predate it; empty for that of the preview.
+	PreviewYml string `json:"preview_yml,omitempty"`
+}
+
+// AnonymizeResult is the response of AnonymizePreviewDB.
+type AnonymizeResult struct {
+	Success bool `json:"success"`
+	// Statements are the SQL statements of the recipe, in the order they
+	// run.
+	Statements []string `json:"statements"`
+	// Applied is how many of them ran: all of them, unless one failed
+	// with Error.
+	Applied int    `json:"applied"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+}
+
+// AnonymizePreviewDB strips personal data from the database of an existing
+// preview with the "sanitize:" recipe of its preview.yml: tables are
+// truncated, columns scrubbed and custom SQL run, in place. Requires the
+// manager role.
+func (c *Client) AnonymizePreviewDB(ctx context.Context, project, previewName string, req AnonymizeRequest) (*AnonymizeResult, error) {
+	var result AnonymizeResult
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/previews/%s/%s/db/anonymize", c.BaseURL, project, previewName), req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
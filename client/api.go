@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// PreviewAPI lists the previews and runs their lifecycle actions.
+type PreviewAPI interface {
+	ListPreviews(ctx context.Context, includeStatus bool) (*PreviewListResult, error)
+	ListPreviewsPage(ctx context.Context, opts ListOptions) (*PreviewListResult, error)
+	PostAction(ctx context.Context, project string, mrID int, action string) (*ActionResult, error)
+	PostActionByName(ctx context.Context, project string, previewName string, action string) (*ActionResult, error)
+	RebuildIfChanged(ctx context.Context, project, previewName, commitSHA string) (*ActionResult, error)
+	ScalePreview(ctx context.Context, project, previewName string, changes map[string]string) (*ActionResult, error)
+	RetargetPreview(ctx context.Context, project, previewName string, req RetargetRequest) (*RetargetResult, error)
+	PlanPreview(ctx context.Context, project, previewName string, req PlanRequest) (*Plan, error)
+	GetPreviewSize(ctx context.Context, project, previewName string) (*PreviewSize, error)
+}
+
+// CommandAPI runs commands in the containers of a preview.
+type CommandAPI interface {
+	PostDrush(ctx context.Context, project string, mrID int, args string) (*ActionResult, error)
+	PostDrushByName(ctx context.Context, project string, previewName string, args string) (*ActionResult, error)
+	DrushInteractive(ctx context.Context, project, previewName, args string, term Terminal) (int, error)
+	ComposerInteractive(ctx context.Context, project, previewName, args string, term Terminal) (int, error)
+	RunDeployScript(ctx context.Context, project, previewName, phase string, term Terminal) (int, error)
+	RedisCLI(ctx context.Context, project, previewName, args string, term Terminal) (int, error)
+	SolrReindex(ctx context.Context, project, previewName string, term Terminal) (int, error)
+	SolrQuery(ctx context.Context, project, previewName, q string, rows int, term Terminal) (int, error)
+	Shell(ctx context.Context, project, previewName, service string, term Terminal) (int, error)
+	Logs(ctx context.Context, project, previewName, log, severity string, term Terminal) (int, error)
+	ListTestSuites(ctx context.Context, project, previewName string) ([]TestSuite, error)
+	RunTestSuite(ctx context.Context, project, previewName, suite string, term Terminal) (int, error)
+	AnonymizePreviewDB(ctx context.Context, project, previewName string, req AnonymizeRequest) (*AnonymizeResult, error)
+}
+
+// DebugAPI connects local tools to a preview: a database client or an IDE.
+type DebugAPI interface {
+	DialDB(ctx context.Context, project, previewName string) (net.Conn, *DBInfo, error)
+	SetXdebug(ctx context.Context, project, previewName string, req XdebugRequest) (*XdebugInfo, error)
+	RelayXdebug(ctx context.Context, project, previewName string) (*XdebugRelay, error)
+}
+
+// PreviewFilesAPI downloads and syncs the database, files and artifacts of a
+// preview.
+type PreviewFilesAPI interface {
+	DownloadStream(ctx context.Context, project string, previewName string, kind string, w io.Writer) error
+	DownloadTables(ctx context.Context, project, previewName string, tables []string, w io.Writer) error
+	ListArtifacts(ctx context.Context, project, previewName, pattern string) ([]Artifact, error)
+	DownloadArtifact(ctx context.Context, project, previewName, path string, w io.Writer) error
+	ListPreviewFiles(ctx context.Context, project, previewName string, paths []string) ([]PreviewFile, error)
+	SyncPreviewFiles(ctx context.Context, project, previewName string, archive io.ReaderAt, size int64, deletes []string) (*FilesSyncResult, error)
+}
+
+// MailAPI reads the mail a preview caught.
+type MailAPI interface {
+	ListMail(ctx context.Context, project, previewName string, limit int) (*MailList, error)
+	GetMail(ctx context.Context, project, previewName, id string) (*MailMessage, error)
+}
+
+// TriggerAPI manages the trigger URLs of a preview.
+type TriggerAPI interface {
+	CreateTrigger(ctx context.Context, project, previewName string, req TriggerRequest) (*Trigger, error)
+	ListTriggers(ctx context.Context, project, previewName string) ([]Trigger, error)
+	DeleteTrigger(ctx context.Context, project, previewName string, id int) error
+}
+
+// AllowlistAPI manages the IP allowlist of a project.
+type AllowlistAPI interface {
+	ListAllowlist(ctx context.Context, project string) (*Allowlist, error)
+	AddAllowlistEntry(ctx context.Context, project string, req AllowlistRequest) (*AllowlistEntry, error)
+	DeleteAllowlistEntry(ctx context.Context, project string, id int) error
+}
+
+// DomainAPI manages the custom domains of a preview.
+type DomainAPI interface {
+	AddDomain(ctx context.Context, project, previewName, hostname string) (*PreviewDomain, error)
+	ListDomains(ctx context.Context, project, previewName string) ([]PreviewDomain, error)
+	DeleteDomain(ctx context.Context, project, previewName, hostname string) error
+}
+
+// BaseFilesAPI reads, restores and syncs the base database and files of a
+// project.
+type BaseFilesAPI interface {
+	GetBaseFilesStatus(ctx context.Context, slug string) (*BaseFilesStatus, error)
+	GetBaseFilesHistory(ctx context.Context, slug string) ([]BaseFileUpload, error)
+	RestoreBaseFile(ctx context.Context, slug, kind, version string) (*BaseFileUpload, error)
+	DownloadBaseFile(ctx context.Context, slug, kind string, w io.Writer) error
+	GetHeavyFilesManifest(ctx context.Context, slug string) (*HeavyFilesManifest, error)
+	PutHeavyFilesManifest(ctx context.Context, slug string, files []HeavyFile) error
+	SyncBaseDB(ctx context.Context, slug string) (string, error)
+	GetDBSyncProgress(ctx context.Context, slug, jobID string, offset int) (*SyncProgress, error)
+	FollowDBSync(ctx context.Context, slug, jobID string, w io.Writer) error
+	VerifyBaseFiles(ctx context.Context, slug string) ([]BaseFileCheck, error)
+}
+
+// UploadAPI uploads base files and manages the uploads in progress.
+type UploadAPI interface {
+	UploadBaseFile(ctx context.Context, slug, kind string, reader io.Reader, filename string) error
+	UploadBaseFileChunked(ctx context.Context, slug, kind string, reader io.Reader, filename string) error
+	ListUploads(ctx context.Context) ([]UploadSession, error)
+	AbortUpload(ctx context.Context, uploadID string) (int64, error)
+}
+
+// ProjectAPI reads and changes the settings of projects.
+type ProjectAPI interface {
+	ListProjects(ctx context.Context) ([]Project, error)
+	GetProjectSettings(ctx context.Context, project string) (*ProjectSettings, error)
+	UpdateProjectSettings(ctx context.Context, project string, changes map[string]string) (*ProjectSettings, error)
+	GetAutoStopPolicy(ctx context.Context, project string) (*AutoStopPolicy, error)
+	SetAutoStopPolicy(ctx context.Context, project string, policy AutoStopPolicy) error
+	ValidatePreviewYml(ctx context.Context, project string, req ValidateRequest) (*ValidationResult, error)
+}
+
+// TemplateAPI manages the template sets of the organization.
+type TemplateAPI interface {
+	ListTemplateSets(ctx context.Context) ([]TemplateSetInfo, error)
+	GetTemplateSet(ctx context.Context, name string) (*TemplateSet, error)
+	PutTemplateSet(ctx context.Context, set TemplateSet) error
+	DeleteTemplateSet(ctx context.Context, name string) error
+}
+
+// AccountAPI logs in and tells who the client is authenticated as.
+type AccountAPI interface {
+	CurrentUser(ctx context.Context) (*User, error)
+	GetTokenInfo(ctx context.Context) (*TokenInfo, error)
+	RequestCLIAuth(ctx context.Context, code string) error
+	PollCLIAuth(ctx context.Context, code string) (*CLIAuth, error)
+	ListOrgs(ctx context.Context) ([]Org, error)
+}
+
+// ServerAPI tells what the server runs and supports.
+type ServerAPI interface {
+	CLIVersion(ctx context.Context) (string, error)
+	GetVersionInfo(ctx context.Context, channel string) (*VersionInfo, error)
+	GetCapabilities(ctx context.Context) (*Capabilities, error)
+	GetCLIHelp(ctx context.Context) (*CLIHelp, error)
+	GetServerInfo(ctx context.Context) (*ServerInfo, error)
+}
+
+// TeamAPI shares the settings of a team through codes.
+type TeamAPI interface {
+	CreateTeamCode(ctx context.Context, defaults TeamDefaults) (string, error)
+	GetTeamDefaults(ctx context.Context, code string) (*TeamDefaults, error)
+}
+
+// MemberAPI manages the members of the organization and of its projects.
+type MemberAPI interface {
+	ListMembers(ctx context.Context) ([]Member, error)
+	ListInvitations(ctx context.Context) ([]Invitation, error)
+	Invite(ctx context.Context, email, role, project string) (*Invitation, error)
+	CancelInvitation(ctx context.Context, id int) error
+	SetMemberRole(ctx context.Context, userID int, role string) error
+	RemoveMember(ctx context.Context, userID int) error
+	ListProjectMembers(ctx context.Context, project string) (*ProjectMembers, error)
+	AddProjectMember(ctx context.Context, project string, userID int) error
+	RemoveProjectMember(ctx context.Context, project string, userID int) error
+}
+
+// PipelineAPI reads the GitLab pipelines of a preview.
+type PipelineAPI interface {
+	ListPipelines(ctx context.Context, project, previewName string) ([]Pipeline, error)
+	ListPipelineJobs(ctx context.Context, project string, pipelineID int) ([]Job, error)
+	JobLog(ctx context.Context, project string, jobID int, w io.Writer) error
+}
+
+// API is the set of operations supported by the preview server, which
+// *Client implements. Code that uses a part of them should depend on the
+// interface of that part, e.g. PreviewAPI.
+type API interface {
+	PreviewAPI
+	CommandAPI
+	DebugAPI
+	PreviewFilesAPI
+	MailAPI
+	TriggerAPI
+	AllowlistAPI
+	DomainAPI
+	BaseFilesAPI
+	UploadAPI
+	ProjectAPI
+	TemplateAPI
+	AccountAPI
+	ServerAPI
+	TeamAPI
+	MemberAPI
+	PipelineAPI
+}
+
+var _ API = (*Client)(nil)
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// User is the account behind the current token.
+type User struct {
+	Email string  `json:"email"`
+	Name  string  `json:"name"`
+	Role  *string `json:"role"`
+}
+
+// CurrentUser returns the user that owns the client's token.
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/auth/me", c.BaseURL), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &user, nil
+}
+
+// TokenInfo describes the API token of the client.
+type TokenInfo struct {
+	Name        string  `json:"name"`
+	TokenPrefix string  `json:"token_prefix"`
+	CreatedAt   string  `json:"created_at"`
+	LastUsedAt  *string `json:"last_used_at"`
+	// ExpiresAt is nil for tokens that don't expire.
+	ExpiresAt *string `json:"expires_at"`
+	// Scopes are what the token allows: "read", "write" and "admin".
+	Scopes []string `json:"scopes"`
+	// Projects limits the token to these projects. Empty for all.
+	Projects []string `json:"projects"`
+}
+
+// GetTokenInfo describes the token of the client. It fails with
+// ErrNotFound if the client isn't authenticated with an API token.
+func (c *Client) GetTokenInfo(ctx context.Context) (*TokenInfo, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/auth/token", c.BaseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("API token %w", ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &info, nil
+}
+
+// Org is an organization the user belongs to.
+type Org struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CLIAuth is an approved CLI login.
+type CLIAuth struct {
+	Token string `json:"token"`
+	// RefreshToken gets a new token once Token expires. Empty if the
+	// server issues non-expiring tokens.
+	RefreshToken string `json:"refresh_token"`
+	// Orgs lists the organizations available to the user on servers with
+	// SSO. Empty on single-organization servers.
+	Orgs []Org `json:"orgs"`
+}
+
+// ListOrgs returns the organizations the current user belongs to, on
+// servers with Capabilities.Orgs.
+func (c *Client) ListOrgs(ctx context.Context) ([]Org, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/auth/orgs", c.BaseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Orgs []Org `json:"orgs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Orgs, nil
+}
+
+// RequestCLIAuth registers a login code. The user then approves it in the
+// browser and the token is retrieved with PollCLIAuth.
+func (c *Client) RequestCLIAuth(ctx context.Context, code string) error {
+	payload := fmt.Sprintf(`{"code": %q}`, code)
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("%s/api/auth/cli/request", c.BaseURL), strings.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to request auth: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("auth request failed (HTTP %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// PollCLIAuth checks whether a login code has been approved. It returns nil
+// while approval is still pending.
+func (c *Client) PollCLIAuth(ctx context.Context, code string) (*CLIAuth, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/auth/cli/poll/%s", c.BaseURL, code), nil)
+	if err != nil {
+		return nil, fmt.Errorf("poll failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("auth request expired or %w", ErrNotFound)
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		CLIAuth
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+
+	if result.Status == "approved" {
+		return &result.CLIAuth, nil
+	}
+	return nil, nil
+}
+
+// refresh exchanges RefreshToken for a new token, unless the token that
+// failed (sent) was already replaced by a concurrent refresh.
+func (c *Client) refresh(ctx context.Context, sent string) error {
+	auth, err := c.refreshLocked(ctx, sent)
+	if err != nil {
+		return err
+	}
+	if auth != nil && c.OnTokenRefresh != nil {
+		c.OnTokenRefresh(auth.Token, auth.RefreshToken)
+	}
+	return nil
+}
+
+// refreshLocked does the refresh under c.mu and returns the new tokens, or
+// nil if another request already refreshed them.
+func (c *Client) refreshLocked(ctx context.Context, sent string) (*CLIAuth, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Token != sent {
+		return nil, nil
+	}
+	if c.RefreshToken == "" {
+		return nil, errors.New("no refresh token")
+	}
+
+	payload := fmt.Sprintf(`{"refresh_token": %q}`, c.RefreshToken)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/auth/refresh", c.BaseURL), strings.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, tlsError(req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result CLIAuth
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Token == "" {
+		return nil, fmt.Errorf("invalid refresh response")
+	}
+	if result.RefreshToken == "" {
+		result.RefreshToken = c.RefreshToken
+	}
+	c.Token, c.RefreshToken = result.Token, result.RefreshToken
+	return &result, nil
+}
+
+// CLIVersion returns the latest stable CLI version published by the
+// server.
+func (c *Client) CLIVersion(ctx context.Context) (string, error) {
+	info, err := c.GetVersionInfo(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
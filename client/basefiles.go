@@ -0,0 +1,990 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"time"
+)
+
+// BaseFileInfo describes one base file (database dump or files archive).
+type BaseFileInfo struct {
+	Exists     bool   `json:"exists"`
+	SizeBytes  int64  `json:"size_bytes"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+// BaseFilesStatus is the response of GetBaseFilesStatus.
+type BaseFilesStatus struct {
+	DB    *BaseFileInfo `json:"db"`
+	Files *BaseFileInfo `json:"files"`
+	// Databases are the dumps of the secondary databases listed under
+	// "databases:" in preview.yml, by name.
+	Databases map[string]*BaseFileInfo `json:"databases,omitempty"`
+}
+
+// DatabaseKind returns the base file kind of the dump of a secondary
+// database, e.g. "db-migrate" for "migrate".
+func DatabaseKind(name string) string {
+	return "db-" + name
+}
+
+// GetBaseFilesStatus returns the base database and files info for a project.
+func (c *Client) GetBaseFilesStatus(ctx context.Context, slug string) (*BaseFilesStatus, error) {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files", c.BaseURL, slug)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result BaseFilesStatus
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// DownloadBaseFile streams a project's base file of kind ("db", "files" or
+// an EncryptedKind) into w.
+func (c *Client) DownloadBaseFile(ctx context.Context, slug, kind string, w io.Writer) error {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/%s", c.BaseURL, slug, kind)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return fmt.Errorf("base %s for %s %w", kind, slug, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// BaseFileUpload is one entry of a project's base files upload history.
+type BaseFileUpload struct {
+	Kind       string `json:"kind"`
+	UploadedAt string `json:"uploaded_at"`
+	UploadedBy string `json:"uploaded_by"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SHA256     string `json:"sha256"`
+	// RestoredFrom is the UploadedAt of the version a restore put back.
+	RestoredFrom string `json:"restored_from,omitempty"`
+	// Current is true for the latest entry of each kind, the one in use.
+	Current bool `json:"current"`
+	// Restorable is true for prior versions the server still keeps, see
+	// RestoreBaseFile. Servers without Capabilities.BaseRestore keep none.
+	Restorable bool `json:"restorable"`
+}
+
+// GetBaseFilesHistory returns the base database and files uploads of a
+// project, newest first.
+func (c *Client) GetBaseFilesHistory(ctx context.Context, slug string) ([]BaseFileUpload, error) {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/history", c.BaseURL, slug)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Uploads []BaseFileUpload `json:"uploads"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Uploads, nil
+}
+
+// RestoreBaseFile puts back in use a prior version of the base file of
+// kind ("db", "files", a DatabaseKind or an EncryptedKind). version is the
+// start of its UploadedAt, e.g. "2024-11-03", or of its SHA256; the
+// version it replaces is kept in turn. Returns the history entry of the
+// restore. Requires the manager role.
+func (c *Client) RestoreBaseFile(ctx context.Context, slug, kind, version string) (*BaseFileUpload, error) {
+	var restored BaseFileUpload
+	body := map[string]string{"version": version}
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/projects/%s/base-files/%s/restore", c.BaseURL, slug, kind), body, &restored); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// HeavyFile is a file left out of the base files archive as too heavy.
+type HeavyFile struct {
+	Path      string `json:"path"` // relative to the files directory
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// HeavyFilesManifest lists the files left out of a project's base files
+// archive by push --strip-heavy-files.
+type HeavyFilesManifest struct {
+	Files      []HeavyFile `json:"files"`
+	RecordedAt string      `json:"recorded_at"`
+}
+
+// GetHeavyFilesManifest returns the files left out of a project's base
+// files archive. It returns ErrNotFound if none were left out.
+func (c *Client) GetHeavyFilesManifest(ctx context.Context, slug string) (*HeavyFilesManifest, error) {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/files/heavy-manifest", c.BaseURL, slug)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("heavy files manifest of %s %w", slug, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var manifest HeavyFilesManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &manifest, nil
+}
+
+// PutHeavyFilesManifest records the files left out of the base files
+// archive just uploaded. Uploading a new archive discards the manifest.
+func (c *Client) PutHeavyFilesManifest(ctx context.Context, slug string, files []HeavyFile) error {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/files/heavy-manifest", c.BaseURL, slug)
+
+	body, err := json.Marshal(HeavyFilesManifest{Files: files})
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, "PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// UploadBaseFile uploads reader as a base file in a single streaming request.
+// kind is "db" or "files".
+func (c *Client) UploadBaseFile(ctx context.Context, slug, kind string, reader io.Reader, filename string) error {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/%s", c.BaseURL, slug, kind)
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, reader); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		writer.Close()
+		pw.Close()
+	}()
+
+	req, err := c.newRequest(ctx, "POST", url, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// DefaultChunkSize is the chunk size used by clients created with New.
+const DefaultChunkSize = 50 * 1024 * 1024 // 50MB
+
+// DefaultMinChunkSize is the smallest chunk size AdaptChunkSize goes down
+// to when Client.MinChunkSize is unset.
+const DefaultMinChunkSize = 1024 * 1024 // 1MB
+
+// UploadBaseFileChunked copies the reader to a spool file, then uploads using
+// single request (if < ChunkSize) or chunked upload (if >= ChunkSize) with a
+// progress bar. With StreamChunks, chunks are sent as the reader produces
+// them instead, without a spool file.
+func (c *Client) UploadBaseFileChunked(ctx context.Context, slug, kind string, reader io.Reader, filename string) error {
+	if c.StreamChunks {
+		return c.uploadStreaming(ctx, slug, kind, reader, filename)
+	}
+
+	// 1. Copy stream to a spool file to know size and allow chunking.
+	spoolDir := c.SpoolDir
+	if spoolDir == "" {
+		spoolDir = "."
+	}
+	tmpFile, err := os.CreateTemp(spoolDir, ".preview-upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	bw := &bufferProgressWriter{out: c.Progress, progress: c.startPhase(PhaseBuffer, kind, 0)}
+	written, err := io.Copy(tmpFile, io.TeeReader(reader, bw))
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	if !bw.progress.report(written, true) {
+		c.logf("\rBuffered %s to temp file.              \n", formatBytes(written))
+	}
+	defer tmpFile.Close()
+
+	// 2. Decide: single or chunked
+	stats := UploadStats{Bytes: written, Chunks: 1}
+	start := time.Now()
+	if written < c.chunkSize() {
+		err = c.uploadSingle(ctx, slug, kind, tmpFile, filename, written)
+	} else {
+		stats.Chunks, stats.Retries, err = c.uploadChunked(ctx, slug, kind, tmpFile, filename, written)
+	}
+	if err != nil {
+		return err
+	}
+	stats.Duration = time.Since(start)
+	c.reportUpload(stats)
+	return nil
+}
+
+// uploadSingle sends data in a single request, again with the new token if
+// it was refreshed.
+func (c *Client) uploadSingle(ctx context.Context, slug, kind string, data io.ReaderAt, filename string, totalSize int64) error {
+	err := c.uploadSingleWithProgress(ctx, slug, kind, data, filename, totalSize)
+	if errors.Is(err, errTokenRefreshed) {
+		err = c.uploadSingleWithProgress(ctx, slug, kind, data, filename, totalSize)
+	}
+	return err
+}
+
+// UploadStats describes a completed base file upload.
+type UploadStats struct {
+	Bytes    int64         // bytes sent, after any compression
+	Chunks   int           // 1 for single-request uploads
+	Retries  int           // chunk retries after failures
+	Duration time.Duration // time spent sending, excluding buffering; streamed uploads include waiting for the reader
+}
+
+func (c *Client) uploadSingleWithProgress(ctx context.Context, slug, kind string, data io.ReaderAt, filename string, totalSize int64) error {
+	f := io.NewSectionReader(data, 0, totalSize)
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		progressReader := &progressWriter{out: c.Progress, progress: c.startPhase(PhaseUpload, kind, totalSize), total: totalSize, label: "Uploading"}
+		if _, err := io.Copy(part, io.TeeReader(f, progressReader)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if c.OnProgress == nil {
+			c.logf("\n")
+		}
+		writer.Close()
+		pw.Close()
+	}()
+
+	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("%s/api/projects/%s/base-files/%s", c.BaseURL, slug, kind), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// uploadChunked sends data in chunks and returns the number of chunks
+// and chunk retries.
+func (c *Client) uploadChunked(ctx context.Context, slug, kind string, data io.ReaderAt, filename string, totalSize int64) (chunks, retries int, err error) {
+	sizer := c.newChunkSizer()
+	totalChunks := int((totalSize + sizer.size - 1) / sizer.size)
+
+	init := map[string]interface{}{
+		"total_size": totalSize,
+		"filename":   filename,
+	}
+	if !sizer.adapt {
+		// Adapted chunks are only counted once sent
+		init["total_chunks"] = totalChunks
+	}
+	uploadID, err := c.initChunkedUpload(ctx, slug, kind, init)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			c.abortChunkedUpload(slug, kind, uploadID)
+		}
+	}()
+
+	if sizer.adapt {
+		c.logf("Uploading %s in chunks of %s adapted to the connection...\n", formatBytes(totalSize), formatBytes(sizer.size))
+	} else {
+		c.logf("Uploading %s in %d chunks of %s...\n", formatBytes(totalSize), totalChunks, formatBytes(sizer.size))
+	}
+
+	var totalSent int64
+	var buf []byte
+	progress := c.startPhase(PhaseUpload, kind, totalSize)
+
+	for i := 0; totalSent < totalSize; i++ {
+		read := func(size int64) ([]byte, error) {
+			size = min(size, totalSize-totalSent)
+			if int64(cap(buf)) < size {
+				buf = make([]byte, size)
+			}
+			n, err := data.ReadAt(buf[:size], totalSent)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("read chunk %d: %w", i, err)
+			}
+			return buf[:n], nil
+		}
+		label := fmt.Sprint(i + 1)
+		if !sizer.adapt {
+			label = fmt.Sprintf("%d/%d", i+1, totalChunks)
+		}
+
+		sent, r, err := c.uploadChunkWithRetry(ctx, slug, kind, uploadID, i, label, sizer, read)
+		retries += r
+		if err != nil {
+			return 0, 0, err
+		}
+		chunks++
+
+		totalSent += sent
+		if progress.report(totalSent, totalSent == totalSize) {
+			continue
+		}
+		pct := float64(totalSent) / float64(totalSize) * 100
+		bar := progressBar(pct, 30)
+		c.logf("\r  %s / %s (%.0f%%) %s", formatBytes(totalSent), formatBytes(totalSize), pct, bar)
+	}
+	if c.OnProgress == nil {
+		c.logf("\n")
+	}
+
+	complete := map[string]interface{}{"upload_id": uploadID}
+	if sizer.adapt {
+		complete["total_chunks"] = chunks
+	}
+	if err := c.completeChunkedUpload(ctx, slug, kind, complete); err != nil {
+		return 0, 0, err
+	}
+	return chunks, retries, nil
+}
+
+// uploadStreaming sends reader in chunks as it produces them: the next
+// chunk is read while the previous one uploads, so producing the file and
+// sending it overlap. Up to three chunks of the largest size are held in
+// memory. Files smaller than a chunk are sent in a single request.
+func (c *Client) uploadStreaming(ctx context.Context, slug, kind string, reader io.Reader, filename string) (err error) {
+	sizer := c.newChunkSizer()
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	// Adapted chunks are made of blocks of the smallest size, read ahead
+	// up to a chunk of the largest one
+	blockSize, ahead := sizer.size, 1
+	if sizer.adapt {
+		blockSize, ahead = sizer.min, int(sizer.max/sizer.min)
+	}
+	next := make(chan chunk, ahead)
+	go func() {
+		defer close(next)
+		for {
+			buf := make([]byte, blockSize)
+			n, err := io.ReadFull(reader, buf)
+			if err == io.EOF {
+				return
+			}
+			read := chunk{data: buf[:n]}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				read = chunk{err: err}
+			}
+			select {
+			case next <- read:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// pending holds what was read and not sent yet; read returns its
+	// first size bytes, fewer at the end of reader
+	var pending []byte
+	read := func(size int64) ([]byte, error) {
+		for int64(len(pending)) < size {
+			block, ok := <-next
+			if !ok {
+				break
+			}
+			if block.err != nil {
+				return nil, block.err
+			}
+			if len(pending) == 0 {
+				pending = block.data
+			} else {
+				pending = append(pending, block.data...)
+			}
+		}
+		return pending[:min(size, int64(len(pending)))], nil
+	}
+
+	first, err := read(sizer.size)
+	if err != nil {
+		return fmt.Errorf("read chunk 0: %w", err)
+	}
+	if int64(len(first)) < sizer.size {
+		if err := c.uploadSingle(ctx, slug, kind, bytes.NewReader(first), filename, int64(len(first))); err != nil {
+			return err
+		}
+		c.reportUpload(UploadStats{Bytes: int64(len(first)), Chunks: 1, Duration: time.Since(start)})
+		return nil
+	}
+
+	uploadID, err := c.initChunkedUpload(ctx, slug, kind, map[string]interface{}{"filename": filename})
+	if err != nil {
+		return err
+	}
+	// A failing reader, e.g. a dump command exiting with an error, must
+	// not leave a truncated upload behind
+	defer func() {
+		if err != nil {
+			if c.OnProgress == nil {
+				// Ends the progress line
+				c.logf("\n")
+			}
+			c.abortChunkedUpload(slug, kind, uploadID)
+		}
+	}()
+	if sizer.adapt {
+		c.logf("Uploading in chunks of %s adapted to the connection as they are produced...\n", formatBytes(sizer.size))
+	} else {
+		c.logf("Uploading in chunks of %s as they are produced...\n", formatBytes(sizer.size))
+	}
+
+	stats := UploadStats{}
+	progress := c.startPhase(PhaseUpload, kind, 0)
+	for i := 0; ; i++ {
+		sent, retries, err := c.uploadChunkWithRetry(ctx, slug, kind, uploadID, i, fmt.Sprint(i+1), sizer, func(size int64) ([]byte, error) {
+			data, err := read(size)
+			if err != nil {
+				return nil, fmt.Errorf("read chunk %d: %w", i, err)
+			}
+			return data, nil
+		})
+		stats.Retries += retries
+		if err != nil {
+			return err
+		}
+		pending = pending[sent:]
+		stats.Chunks++
+		stats.Bytes += sent
+		if !progress.report(stats.Bytes, false) {
+			c.logf("\r  %s sent in %d chunks", formatBytes(stats.Bytes), stats.Chunks)
+		}
+
+		more, err := read(1)
+		if err != nil {
+			return fmt.Errorf("read chunk %d: %w", i+1, err)
+		}
+		if len(more) == 0 {
+			progress.report(stats.Bytes, true)
+			break
+		}
+	}
+	if c.OnProgress == nil {
+		c.logf("\n")
+	}
+
+	if err := c.completeChunkedUpload(ctx, slug, kind, map[string]interface{}{
+		"upload_id":    uploadID,
+		"total_chunks": stats.Chunks,
+	}); err != nil {
+		return err
+	}
+	stats.Duration = time.Since(start)
+	c.reportUpload(stats)
+	return nil
+}
+
+func (c *Client) reportUpload(stats UploadStats) {
+	if c.OnUploadComplete != nil {
+		c.OnUploadComplete(stats)
+	}
+}
+
+// initChunkedUpload starts a chunked upload and returns its ID.
+func (c *Client) initChunkedUpload(ctx context.Context, slug, kind string, body map[string]interface{}) (string, error) {
+	initBody, _ := json.Marshal(body)
+	resp, err := c.doRequest(ctx, "POST",
+		fmt.Sprintf("%s/api/projects/%s/base-files/%s/upload/init", c.BaseURL, slug, kind),
+		bytes.NewReader(initBody))
+	if err != nil {
+		return "", fmt.Errorf("chunked init failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("chunked init: %w", httpError(resp))
+	}
+	var initResult struct {
+		UploadID string `json:"upload_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&initResult)
+	return initResult.UploadID, nil
+}
+
+// completeChunkedUpload asks the server to reassemble and process the
+// chunks of an upload.
+func (c *Client) completeChunkedUpload(ctx context.Context, slug, kind string, body map[string]interface{}) error {
+	c.logf("Finalizing upload...\n")
+	completeBody, _ := json.Marshal(body)
+	resp, err := c.doRequest(ctx, "POST",
+		fmt.Sprintf("%s/api/projects/%s/base-files/%s/upload/complete", c.BaseURL, slug, kind),
+		bytes.NewReader(completeBody))
+	if err != nil {
+		return fmt.Errorf("chunked complete failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("chunked complete: %w", httpError(resp))
+	}
+	return nil
+}
+
+// abortChunkedUpload asks the server to discard the chunks of a failed
+// upload. It is best effort: servers that can't abort uploads clean them
+// up after a while.
+func (c *Client) abortChunkedUpload(slug, kind, uploadID string) {
+	// The upload may have failed because ctx was cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body, _ := json.Marshal(map[string]string{"upload_id": uploadID})
+	resp, err := c.doRequest(ctx, "POST",
+		fmt.Sprintf("%s/api/projects/%s/base-files/%s/upload/abort", c.BaseURL, slug, kind),
+		bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// UploadSession is a chunked upload the server holds the chunks of, in
+// progress or abandoned.
+type UploadSession struct {
+	UploadID string `json:"upload_id"`
+	// Project and Kind are empty for orphaned sessions, whose metadata
+	// is missing
+	Project        string `json:"project"`
+	Kind           string `json:"kind"`
+	Filename       string `json:"filename"`
+	StartedBy      string `json:"started_by"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+	ExpiresAt      string `json:"expires_at"`
+	ChunksReceived int    `json:"chunks_received"`
+	// TotalChunks is 0 for streaming uploads, which give it on completion
+	TotalChunks   int   `json:"total_chunks"`
+	BytesReceived int64 `json:"bytes_received"`
+	// State is active, stale (no chunk received for a while) or orphaned
+	State string `json:"state"`
+}
+
+// ListUploads returns the chunked upload sessions of the server, oldest
+// first.
+func (c *Client) ListUploads(ctx context.Context) ([]UploadSession, error) {
+	resp, err := c.doRequest(ctx, "GET", c.BaseURL+"/api/uploads", nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Uploads []UploadSession `json:"uploads"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Uploads, nil
+}
+
+// AbortUpload discards the chunks of an upload session and returns the
+// bytes freed.
+func (c *Client) AbortUpload(ctx context.Context, uploadID string) (int64, error) {
+	resp, err := c.doRequest(ctx, "DELETE", fmt.Sprintf("%s/api/uploads/%s", c.BaseURL, url.PathEscape(uploadID)), nil)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return 0, fmt.Errorf("upload %s %w", uploadID, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return 0, httpError(resp)
+	}
+
+	var result struct {
+		BytesFreed int64 `json:"bytes_freed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("decode error: %w", err)
+	}
+	return result.BytesFreed, nil
+}
+
+// uploadChunkWithRetry sends chunk index, read at the size of sizer, and
+// returns its size and the number of retries. Failures are retried twice;
+// an adapting sizer also halves the chunk on each of them, until it fails
+// three times at the smallest size. A chunk the server turned away for an
+// expired token is resent with the new one, which isn't a failure. label
+// names the chunk in messages, e.g. "2/5".
+func (c *Client) uploadChunkWithRetry(ctx context.Context, slug, kind, uploadID string, index int, label string, sizer *chunkSizer, read func(size int64) ([]byte, error)) (sent int64, retries int, err error) {
+	attempts := 0 // at the current size
+	for {
+		data, err := read(sizer.size)
+		if err != nil {
+			return 0, retries, err
+		}
+		attempts++
+		start := time.Now()
+		err = c.uploadChunkAttempt(ctx, slug, kind, uploadID, index, data, sizer.timeout())
+		if errors.Is(err, errTokenRefreshed) {
+			// Sent again with the new token: the chunk didn't fail
+			start = time.Now()
+			err = c.uploadChunkAttempt(ctx, slug, kind, uploadID, index, data, sizer.timeout())
+		}
+		if err == nil {
+			sizer.succeeded(time.Since(start), retries == 0)
+			return int64(len(data)), retries, nil
+		}
+		if err == ErrNotAuthenticated || ctx.Err() != nil {
+			return 0, retries, err
+		}
+
+		if sizer.failed() {
+			attempts = 0
+		} else if attempts >= 3 {
+			return 0, retries, fmt.Errorf("chunk %d failed after %d attempts: %w", index, retries+1, err)
+		}
+		retries++
+		wait := time.Duration(1<<uint(attempts)) * c.RetryWait
+		if attempts == 0 {
+			c.logf("  Retrying chunk %s in %v with chunks of %s...\n", label, wait, formatBytes(sizer.size))
+		} else {
+			c.logf("  Retrying chunk %s in %v...\n", label, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, retries, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// uploadChunkAttempt sends a chunk, giving up after timeout unless it is 0.
+func (c *Client) uploadChunkAttempt(ctx context.Context, slug, kind, uploadID string, index int, data []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return c.uploadOneChunk(ctx, slug, kind, uploadID, index, data)
+}
+
+func (c *Client) uploadOneChunk(ctx context.Context, slug, kind, uploadID string, index int, data []byte) error {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		writer.WriteField("upload_id", uploadID)
+		writer.WriteField("chunk_index", fmt.Sprintf("%d", index))
+		part, err := writer.CreateFormFile("file", fmt.Sprintf("chunk_%d", index))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		part.Write(data)
+		writer.Close()
+		pw.Close()
+	}()
+
+	req, err := c.newRequest(ctx, "POST",
+		fmt.Sprintf("%s/api/projects/%s/base-files/%s/upload/chunk", c.BaseURL, slug, kind),
+		pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	return nil
+}
+
+func (c *Client) chunkSize() int64 {
+	if c.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return c.ChunkSize
+}
+
+// Chunk durations AdaptChunkSize aims between: faster chunks double the
+// chunk size, slower ones halve it. Attempts above the smallest size give
+// up after chunkTimeout, to be retried smaller.
+const (
+	fastChunk    = 5 * time.Second
+	slowChunk    = time.Minute
+	chunkTimeout = 2 * time.Minute
+)
+
+// chunkSizer is the chunk size of an upload, adapted to the connection
+// with AdaptChunkSize.
+type chunkSizer struct {
+	size  int64
+	min   int64
+	max   int64
+	adapt bool
+}
+
+func (c *Client) newChunkSizer() *chunkSizer {
+	s := &chunkSizer{size: c.chunkSize(), min: c.MinChunkSize, max: c.MaxChunkSize, adapt: c.AdaptChunkSize}
+	if s.min <= 0 || s.min > s.size {
+		s.min = min(DefaultMinChunkSize, s.size)
+	}
+	if s.max < s.size {
+		s.max = s.size
+	}
+	return s
+}
+
+// failed halves the size after a failed attempt and reports whether it
+// did, which it doesn't at the smallest size or without adapting.
+func (s *chunkSizer) failed() bool {
+	if !s.adapt || s.size <= s.min {
+		return false
+	}
+	s.size = max(s.size/2, s.min)
+	return true
+}
+
+// succeeded adapts the size to the time a chunk took to send. Only chunks
+// sent at their first attempt grow it.
+func (s *chunkSizer) succeeded(took time.Duration, firstAttempt bool) {
+	if !s.adapt {
+		return
+	}
+	switch {
+	case took > slowChunk:
+		s.size = max(s.size/2, s.min)
+	case took < fastChunk && firstAttempt:
+		s.size = min(s.size*2, s.max)
+	}
+}
+
+// timeout is how long an attempt may take before it is retried smaller;
+// 0 for no limit.
+func (s *chunkSizer) timeout() time.Duration {
+	if !s.adapt || s.size <= s.min {
+		return 0
+	}
+	return chunkTimeout
+}
+
+// BaseFileCheck is the result of verifying one of a project's stored base
+// files.
+type BaseFileCheck struct {
+	Kind string `json:"kind"`
+	// Status is "ok", "warning", "failed" or "missing".
+	Status            string `json:"status"`
+	Message           string `json:"message"`
+	SizeBytes         int64  `json:"size_bytes"`
+	UncompressedBytes int64  `json:"uncompressed_bytes"`
+	// Tables is the number of tables a database dump creates.
+	Tables int `json:"tables"`
+	// Entries is the number of files and directories in a files archive.
+	Entries int    `json:"entries"`
+	SHA256  string `json:"sha256"`
+}
+
+// VerifyBaseFiles has the server check the integrity of a project's base
+// database and files: that they decompress completely, look like an SQL
+// dump and a files archive, and still match the checksum recorded on
+// upload. The server reads them in full, which can take minutes for large
+// bases.
+func (c *Client) VerifyBaseFiles(ctx context.Context, slug string) ([]BaseFileCheck, error) {
+	var result struct {
+		Checks []BaseFileCheck `json:"checks"`
+	}
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/projects/%s/base-files/verify", c.BaseURL, slug), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Checks, nil
+}
+
+// ErrSyncFailed is returned by FollowDBSync when the sync job fails.
+var ErrSyncFailed = errors.New("database sync failed")
+
+// SyncProgress is a page of a database sync job's log.
+type SyncProgress struct {
+	Lines    []string `json:"lines"`
+	Offset   int      `json:"offset"` // offset of the next page
+	Complete bool     `json:"complete"`
+	Status   string   `json:"status"` // running, success or failed
+}
+
+// syncPollInterval is how often FollowDBSync polls a running job.
+const syncPollInterval = 2 * time.Second
+
+// SyncBaseDB starts replacing a project's base database with a sanitized
+// dump of the production source configured on the server, and returns the
+// id of the sync job.
+func (c *Client) SyncBaseDB(ctx context.Context, slug string) (string, error) {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/db/sync", c.BaseURL, slug)
+
+	resp, err := c.doRequest(ctx, "POST", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", httpError(resp)
+	}
+
+	var result struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode error: %w", err)
+	}
+	return result.JobID, nil
+}
+
+// GetDBSyncProgress returns the log lines of a sync job from offset onward.
+func (c *Client) GetDBSyncProgress(ctx context.Context, slug, jobID string, offset int) (*SyncProgress, error) {
+	url := fmt.Sprintf("%s/api/projects/%s/base-files/db/sync/%s?offset=%d", c.BaseURL, slug, jobID, offset)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("sync job %s %w", jobID, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var progress SyncProgress
+	if err := json.NewDecoder(resp.Body).Decode(&progress); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &progress, nil
+}
+
+// FollowDBSync writes the log of a sync job to w as it runs, and returns
+// ErrSyncFailed if the job fails.
+func (c *Client) FollowDBSync(ctx context.Context, slug, jobID string, w io.Writer) error {
+	offset := 0
+	for {
+		progress, err := c.GetDBSyncProgress(ctx, slug, jobID, offset)
+		if err != nil {
+			return err
+		}
+		for _, line := range progress.Lines {
+			fmt.Fprintln(w, line)
+		}
+		offset = progress.Offset
+		if progress.Complete {
+			if progress.Status != "success" {
+				return ErrSyncFailed
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(syncPollInterval):
+		}
+	}
+}
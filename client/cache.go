@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cache stores GET responses by key with their ETag, so they are only
+// transferred again when they changed. Implementations must be safe for
+// concurrent use; failing to store an entry is not an error.
+type Cache interface {
+	Get(key string) (etag string, body []byte, ok bool)
+	Set(key, etag string, body []byte)
+}
+
+// DirCache is a Cache storing each response in a file of the directory,
+// which is created on the first Set. Entries are kept until Prune removes
+// them.
+type DirCache string
+
+// Get returns the entry stored under key, marking it as used for Prune.
+func (d DirCache) Get(key string) (string, []byte, bool) {
+	path := filepath.Join(string(d), key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, false
+	}
+	etag, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || len(etag) == 0 {
+		return "", nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return string(etag), body, true
+}
+
+// Prune removes the entries not used for maxAge, e.g. those of a former
+// login or of URLs no longer requested, and files left behind by
+// interrupted writes.
+func (d DirCache) Prune(maxAge time.Duration) error {
+	entries, err := os.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		os.Remove(filepath.Join(string(d), e.Name()))
+	}
+	return nil
+}
+
+// Set stores body and its etag under key. The file is replaced atomically,
+// so concurrent readers never see a partial entry.
+func (d DirCache) Set(key, etag string, body []byte) {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(string(d), key+".*.tmp")
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(etag + "\n")
+	w.Write(body)
+	if err := w.Flush(); err != nil || f.Close() != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), filepath.Join(string(d), key)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// cacheKey identifies the response to req for user: responses depend on
+// the URL, which includes the server, and on who asks, so the user and
+// organization are part of the key. The token isn't, so a new token of the
+// same user keeps using the entries.
+func cacheKey(req *http.Request, user string) string {
+	h := sha256.New()
+	for _, s := range []string{user, req.Header.Get("X-Preview-Org"), req.URL.String()} {
+		io.WriteString(h, s+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// doCached sends the GET req as a conditional request when its response
+// is cached. A 304 is answered from the cache as a 200; JSON responses
+// with an ETag are stored. Other responses, like downloads, are returned
+// untouched.
+func (c *Client) doCached(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req, c.CacheUser)
+	etag, cached, ok := c.Cache.Get(key)
+	if ok {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.ContentLength = int64(len(cached))
+		resp.Body = io.NopCloser(bytes.NewReader(cached))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "" &&
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"):
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.Cache.Set(key, resp.Header.Get("ETag"), body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Capabilities describes the features a server supports.
+type Capabilities struct {
+	// Version is the server release, empty if unknown.
+	Version string `json:"version"`
+	// Compressors are the archive compressions accepted for base files.
+	Compressors []string `json:"compressors"`
+	// MaxChunkSize is the largest chunk accepted by chunked uploads.
+	MaxChunkSize int64 `json:"max_chunk_size"`
+	// MinChunkSize is the smallest chunk size clients adapting their
+	// chunks to a slow connection should go down to; 0 if unset.
+	MinChunkSize int64 `json:"min_chunk_size"`
+	// StreamingDrush is true if drush and composer can run interactively
+	// over the terminal websocket.
+	StreamingDrush bool `json:"streaming_drush"`
+	// Snapshots is true if previews can be snapshotted and restored.
+	Snapshots bool `json:"snapshots"`
+	// Scale is true if the resource limits of a running preview can be
+	// changed.
+	Scale bool `json:"scale"`
+	// DBSync is true if a project's base database can be synced from a
+	// production source configured on the server.
+	DBSync bool `json:"db_sync"`
+	// Info is true if the server reports its health at /api/info.
+	Info bool `json:"info"`
+	// HeavyFilesManifest is true if the server records the files left out
+	// of base files archives by push --strip-heavy-files.
+	HeavyFilesManifest bool `json:"heavy_files_manifest"`
+	// Tests is true if the test suites of preview.yml can be listed and
+	// run over the terminal websocket.
+	Tests bool `json:"tests"`
+	// DeployRun is true if the project deploy scripts can be re-run over
+	// the terminal websocket.
+	DeployRun bool `json:"deploy_run"`
+	// Multisite is true if base files archives can hold the files of
+	// several Drupal sites, listed in a preview-sites.json manifest.
+	Multisite bool `json:"multisite"`
+	// Pipelines is true if the GitLab pipelines of projects, their jobs
+	// and job logs can be read through the server.
+	Pipelines bool `json:"pipelines"`
+	// StreamingUpload is true if chunked uploads can start before their
+	// size is known, giving the chunk count on completion.
+	StreamingUpload bool `json:"streaming_upload"`
+	// BaseVerify is true if the server can check the integrity of the
+	// stored base database and files.
+	BaseVerify bool `json:"base_verify"`
+	// Services is true if the redis and solr services of previews can be
+	// used over the terminal websocket.
+	Services bool `json:"services"`
+	// ScaffoldTemplates is true if organizations can host their own
+	// scaffold templates for "preview setup project".
+	ScaffoldTemplates bool `json:"scaffold_templates"`
+	// Validate is true if a preview.yml can be checked on the server
+	// before it is committed.
+	Validate bool `json:"validate"`
+	// Retarget is true if a preview can be moved to another branch or MR.
+	Retarget bool `json:"retarget"`
+	// TableDumps is true if database dumps of previews can be limited to
+	// some tables.
+	TableDumps bool `json:"table_dumps"`
+	// UploadSessions is true if the chunked uploads held by the server can
+	// be listed and aborted by ID.
+	UploadSessions bool `json:"upload_sessions"`
+	// PreviewSize is true if the disk usage of a preview can be broken
+	// down by component.
+	PreviewSize bool `json:"preview_size"`
+	// FilesSync is true if files can be written into the files directory
+	// of a running preview.
+	FilesSync bool `json:"files_sync"`
+	// TokenInfo is true if the expiry and scopes of the API token can be
+	// read at /api/auth/token.
+	TokenInfo bool `json:"token_info"`
+	// CLIHelp is true if the server has values for the help of the CLI at
+	// /api/cli/help.
+	CLIHelp bool `json:"cli_help"`
+	// CLIChannels is true if the server publishes CLI releases on a beta
+	// channel besides the stable one.
+	CLIChannels bool `json:"cli_channels"`
+	// DBTunnel is true if the database of a preview can be reached over
+	// the db-tunnel websocket, see DialDB.
+	DBTunnel bool `json:"db_tunnel"`
+	// Xdebug is true if Xdebug of a preview can be turned on, see
+	// SetXdebug and RelayXdebug.
+	Xdebug bool `json:"xdebug"`
+	// Logs is true if the logs of a preview can be followed, see Logs.
+	Logs bool `json:"logs"`
+	// Mail is true if previews capture the mail they send, see ListMail.
+	Mail bool `json:"mail"`
+	// Triggers is true if previews can have trigger URLs, see
+	// CreateTrigger.
+	Triggers bool `json:"triggers"`
+	// Databases is true if projects can have secondary databases, whose
+	// dumps are uploaded with the DatabaseKind of their name.
+	Databases bool `json:"databases"`
+	// IPAllowlist is true if previews can be restricted to given networks,
+	// see AddAllowlistEntry.
+	IPAllowlist bool `json:"ip_allowlist"`
+	// CustomDomains is true if previews can be served at custom domains,
+	// see AddDomain.
+	CustomDomains bool `json:"custom_domains"`
+	// BaseRestore is true if the server keeps prior versions of the base
+	// files to restore, see RestoreBaseFile.
+	BaseRestore bool `json:"base_restore"`
+	// ProjectList is true if the projects of the user can be listed, see
+	// ListProjects.
+	ProjectList bool `json:"project_list"`
+	// Anonymize is true if the database of a preview can be anonymized in
+	// place, see AnonymizePreviewDB.
+	Anonymize bool `json:"anonymize"`
+	// Plan is true if the server can compare a preview.yml with the one a
+	// preview was deployed with, see PlanPreview.
+	Plan bool `json:"plan"`
+	// QuotedDrushArgs is true if the args of PostDrush are split like a
+	// shell does, so quoted arguments keep their spaces. Older servers
+	// split them on spaces.
+	QuotedDrushArgs bool `json:"quoted_drush_args"`
+	// Orgs is true if users can belong to several organizations, logging
+	// in with SSO, see ListOrgs and Client.Org.
+	Orgs bool `json:"orgs"`
+	// TokenRefresh is true if the tokens of CLI logins expire and come
+	// with a refresh token, see Client.RefreshToken.
+	TokenRefresh bool `json:"token_refresh"`
+}
+
+// SupportsCompressor reports whether the server accepts archives compressed
+// with name (e.g. "gzip").
+func (c *Capabilities) SupportsCompressor(name string) bool {
+	for _, n := range c.Compressors {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyCapabilities are those of servers that predate /api/capabilities.
+func legacyCapabilities() *Capabilities {
+	return &Capabilities{
+		Compressors:  []string{"gzip"},
+		MaxChunkSize: DefaultChunkSize,
+	}
+}
+
+// GetCapabilities returns the features supported by the server. Servers
+// without the capabilities endpoint report the features every server has.
+func (c *Client) GetCapabilities(ctx context.Context) (*Capabilities, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/capabilities", c.BaseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return legacyCapabilities(), nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &caps, nil
+}
@@ -0,0 +1,407 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotAuthenticated is returned when the server rejects the token.
+var ErrNotAuthenticated = errors.New("authentication failed")
+
+// ErrNotFound is returned when the requested preview or resource does not exist.
+var ErrNotFound = errors.New("not found")
+
+// The range of API versions this client speaks, sent with every request in
+// the X-Preview-API-Version header. Servers that speak none of them answer
+// with an *APIVersionError.
+const (
+	MinAPIVersion = 1
+	MaxAPIVersion = 1
+)
+
+const apiVersionHeader = "X-Preview-API-Version"
+
+// APIVersionError is returned when the server speaks no API version this
+// client supports.
+type APIVersionError struct {
+	ServerMin int
+	ServerMax int
+	// CLIVersion is the CLI release published by the server, which speaks
+	// its API. Empty if the server doesn't publish one.
+	CLIVersion string
+}
+
+func (e *APIVersionError) Error() string {
+	return fmt.Sprintf("server speaks API versions %d-%d, client speaks %d-%d",
+		e.ServerMin, e.ServerMax, MinAPIVersion, MaxAPIVersion)
+}
+
+// ClientTooOld reports whether the server only speaks newer API versions
+// than the client.
+func (e *APIVersionError) ClientTooOld() bool {
+	return MaxAPIVersion < e.ServerMin
+}
+
+// apiVersionError returns an *APIVersionError if resp is the server
+// rejecting the client's API versions.
+func apiVersionError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		return nil
+	}
+	var body struct {
+		Code       string `json:"code"`
+		MinVersion int    `json:"min_version"`
+		MaxVersion int    `json:"max_version"`
+		CLIVersion string `json:"cli_version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code != "api_version_mismatch" {
+		return nil
+	}
+	return &APIVersionError{ServerMin: body.MinVersion, ServerMax: body.MaxVersion, CLIVersion: body.CLIVersion}
+}
+
+// HTTPError is returned when the server answers with an unexpected status code.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+
+	// Code, Message, Hint and RequestID come from the error envelope of the
+	// server. Servers predating it only give a Message.
+	Code    string // e.g. "forbidden"
+	Message string
+	Hint    string
+	// RequestID identifies the request in the server logs, for support.
+	RequestID string
+	// RetryAfter is how long the server asked to wait before trying again,
+	// from its Retry-After header, e.g. when rate limiting. 0 if unset.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Client talks to a Preview Manager server.
+type Client struct {
+	BaseURL string
+	Token   string
+	// HTTPClient sends the requests. Its default transport asks for gzip
+	// and transparently decompresses responses; a custom transport must do
+	// the same to keep large preview lists fast. NewTransport makes one
+	// that trusts additional CA certificates.
+	HTTPClient *http.Client
+
+	// RefreshToken, if set, is used to get a new token when the server
+	// rejects the current one; the failed request is then retried once.
+	// Servers with Capabilities.TokenRefresh return it with the token of
+	// a CLI login (CLIAuth.RefreshToken) and exchange it at POST
+	// /api/auth/refresh: {"refresh_token": "..."} answers {"token": "...",
+	// "refresh_token": "..."}, an empty refresh_token keeping this one.
+	RefreshToken string
+
+	// OnTokenRefresh is called with the new tokens after a refresh, so
+	// they can be persisted.
+	OnTokenRefresh func(token, refreshToken string)
+
+	// Org is the organization requests act on, sent as the X-Preview-Org
+	// header; only servers with Capabilities.Orgs use it. Empty uses the
+	// token's default organization.
+	Org string
+
+	// LogLevel, if "debug", asks the server to put the log of the
+	// operations an action runs (docker compose output, overlay mounts)
+	// before the Output of its ActionResult. Sent as the
+	// X-Preview-Log-Level header.
+	LogLevel string
+
+	// Cache, if set along with CacheUser, keeps JSON GET responses so that
+	// unchanged ones are answered with a 304 instead of being transferred
+	// again.
+	Cache Cache
+
+	// CacheUser identifies the user the token belongs to, e.g. their
+	// email, to keep the responses of each user apart in Cache.
+	CacheUser string
+
+	// Streamer runs the interactive sessions (drush, composer, shells...).
+	// Nil runs them over the terminal websocket of the server, see
+	// WebSocketStreamer.
+	Streamer Streamer
+
+	// Progress receives human-readable upload progress. Nil disables it.
+	Progress io.Writer
+
+	// OnProgress, if set, receives the progress of uploads as events, e.g.
+	// for a custom progress UI, instead of the bars written to Progress.
+	// Progress still gets the other messages.
+	OnProgress func(ProgressEvent)
+
+	// OnUploadComplete, if set, is called with the statistics of each
+	// successful UploadBaseFileChunked.
+	OnUploadComplete func(UploadStats)
+
+	// SpoolDir is where chunked uploads are buffered before sending,
+	// unless StreamChunks is set.
+	// Empty means the current directory, because /tmp may be a tmpfs
+	// (RAM-backed) on Linux, which can't handle large files.
+	SpoolDir string
+
+	// ChunkSize is the size of each part in a chunked upload. Uploads
+	// smaller than this are sent in a single request.
+	ChunkSize int64
+
+	// StreamChunks makes chunked uploads send each chunk as soon as the
+	// reader has produced it, instead of spooling the whole stream first.
+	// The server must support streaming uploads (Capabilities.StreamingUpload).
+	StreamChunks bool
+
+	// AdaptChunkSize makes chunked uploads adapt the size of their chunks
+	// to the connection, starting from ChunkSize: it halves, down to
+	// MinChunkSize, whenever a chunk fails or takes over a minute, so poor
+	// connections retry smaller chunks instead of failing the same large
+	// one, and doubles, up to MaxChunkSize, while chunks take under 5
+	// seconds. The server must support streaming uploads
+	// (Capabilities.StreamingUpload), as the chunks are counted on
+	// completion.
+	AdaptChunkSize bool
+	MinChunkSize   int64
+	MaxChunkSize   int64
+
+	// RetryWait is the base delay between chunk retries; it doubles on
+	// every attempt.
+	RetryWait time.Duration
+
+	// RateLimitRetries is how many times idempotent requests (GET, PUT,
+	// DELETE...) the server rate-limits with a 429 are retried, after the
+	// wait of its Retry-After header or RetryWait doubling without one.
+	// 0 fails at once with an *HTTPError.
+	RateLimitRetries int
+
+	// MaxRateLimitWait caps the wait before a rate-limited request is
+	// retried: requests asked to wait longer fail at once.
+	MaxRateLimitWait time.Duration
+
+	// mu guards Token and RefreshToken while they are refreshed.
+	mu sync.Mutex
+}
+
+// New returns a client for the server at baseURL authenticated with token.
+// The token may be empty for unauthenticated endpoints (CLI login, version).
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{},
+		ChunkSize:  DefaultChunkSize,
+		RetryWait:  2 * time.Second,
+
+		RateLimitRetries: 3,
+		MaxRateLimitWait: time.Minute,
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.Org != "" {
+		req.Header.Set("X-Preview-Org", c.Org)
+	}
+	if c.LogLevel != "" {
+		req.Header.Set("X-Preview-Log-Level", c.LogLevel)
+	}
+	req.Header.Set(apiVersionHeader, fmt.Sprintf("%d-%d", MinAPIVersion, MaxAPIVersion))
+	return req, nil
+}
+
+func (c *Client) token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Token
+}
+
+// errTokenRefreshed is returned by do when the token was refreshed but the
+// request body can't be replayed; the caller should build and send the
+// request again.
+var errTokenRefreshed = errors.New("token refreshed, request must be resent")
+
+// do sends req and converts a 401 into ErrNotAuthenticated and an API
+// version mismatch into *APIVersionError. With a RefreshToken, a 401 first
+// refreshes the token and retries req once. Idempotent requests the server
+// rate-limits are retried up to RateLimitRetries times.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doAuthenticated(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= c.RateLimitRetries || !replayable(req) {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header, time.Now())
+		if wait == 0 {
+			wait = time.Duration(1<<uint(attempt)) * c.RetryWait
+		}
+		if c.MaxRateLimitWait > 0 && wait > c.MaxRateLimitWait {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		c.logf("Rate limited by the server, retrying in %s...\n", wait.Round(time.Second))
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req = retry
+	}
+}
+
+// replayable reports whether req can be sent again without side effects:
+// its method is idempotent and its body, if any, can be read again.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryAfter returns the wait of a Retry-After header, given in seconds or
+// as an HTTP date. 0 if h has none.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(h.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// doAuthenticated sends req, refreshing the token on a 401, see do.
+func (c *Client) doAuthenticated(req *http.Request) (*http.Response, error) {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, tlsError(req.URL.Host, err)
+	}
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		defer resp.Body.Close()
+		if err := apiVersionError(resp); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("server requires an upgrade (HTTP %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	sent := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if err := c.refresh(req.Context(), sent); err != nil {
+		return nil, ErrNotAuthenticated
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, errTokenRefreshed
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+c.token())
+	resp, err = c.HTTPClient.Do(retry)
+	if err != nil {
+		return nil, tlsError(req.URL.Host, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, ErrNotAuthenticated
+	}
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method == "GET" && c.Cache != nil && c.CacheUser != "" {
+		return c.doCached(req)
+	}
+	return c.do(req)
+}
+
+// httpError reads the response body into an *HTTPError, parsing the error
+// envelope of the server.
+func httpError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+		RetryAfter: retryAfter(resp.Header, time.Now()),
+	}
+
+	var envelope struct {
+		Detail    json.RawMessage `json:"detail"`
+		Code      string          `json:"code"`
+		Message   string          `json:"message"`
+		Hint      string          `json:"hint"`
+		RequestID string          `json:"request_id"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		// Proxies in front of the server answer with HTML pages
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	e.Code, e.Message, e.Hint = envelope.Code, envelope.Message, envelope.Hint
+	if envelope.RequestID != "" {
+		e.RequestID = envelope.RequestID
+	}
+	if e.Message == "" {
+		// Older servers only send FastAPI's "detail"
+		json.Unmarshal(envelope.Detail, &e.Message)
+	}
+	return e
+}
+
+// logf writes a progress message if a Progress writer is configured.
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.Progress != nil {
+		fmt.Fprintf(c.Progress, format, args...)
+	}
+}
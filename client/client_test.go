@@ -0,0 +1,2027 @@
+package client_test
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/capynet/preview-server/client/clienttest"
+)
+
+func TestListPreviews(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5", Branch: "feature"})
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "branch-develop", Branch: "develop"})
+
+	result, err := srv.Client().ListPreviews(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || result.Previews[0].Name != "mr-5" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestListPreviewsPage(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	for i := 1; i <= 5; i++ {
+		srv.AddPreview(client.Preview{Project: "drupal-test", Name: fmt.Sprintf("mr-%d", i)})
+	}
+	srv.AddPreview(client.Preview{Project: "other", Name: "mr-1"})
+
+	result, err := srv.Client().ListPreviewsPage(context.Background(), client.ListOptions{Project: "drupal-test", Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 5 || len(result.Previews) != 2 || result.Previews[0].Name != "mr-3" || result.Previews[1].Name != "mr-4" {
+		t.Fatalf("unexpected page: %+v", result)
+	}
+
+	result, err = srv.Client().ListPreviewsPage(context.Background(), client.ListOptions{Limit: 4, Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 6 || len(result.Previews) != 2 || result.Previews[1].Project != "other" {
+		t.Fatalf("unexpected last page: %+v", result)
+	}
+}
+
+func TestListPreviewsSizes(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	size := int64(1 << 30)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-1", SizeBytes: &size})
+	c := srv.Client()
+	ctx := context.Background()
+
+	result, err := c.ListPreviewsPage(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Previews[0].SizeBytes != nil {
+		t.Fatalf("sizes listed without IncludeSizes: %d", *result.Previews[0].SizeBytes)
+	}
+	result, err = c.ListPreviewsPage(ctx, client.ListOptions{IncludeSizes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Previews[0].SizeBytes; got == nil || *got != size {
+		t.Fatalf("expected size %d, got %v", size, got)
+	}
+}
+
+// countingCache counts the entries stored in a DirCache.
+type countingCache struct {
+	client.DirCache
+	sets int
+}
+
+func (c *countingCache) Set(key, etag string, body []byte) {
+	c.sets++
+	c.DirCache.Set(key, etag, body)
+}
+
+func TestCache(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	cache := &countingCache{DirCache: client.DirCache(filepath.Join(t.TempDir(), "cache"))}
+	c := srv.Client()
+	c.Cache, c.CacheUser = cache, "dev@example.com"
+
+	for i := 0; i < 2; i++ {
+		result, err := c.ListPreviews(context.Background(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total != 1 || result.Previews[0].Name != "mr-5" {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+	}
+	if cache.sets != 1 {
+		t.Fatalf("unchanged response stored %d times, want 1", cache.sets)
+	}
+
+	// A new token of the same user keeps the entries
+	srv.Token, c.Token = "renewed", "renewed"
+	if _, err := c.ListPreviews(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if cache.sets != 1 {
+		t.Fatalf("response stored again for a new token of the same user")
+	}
+	c.CacheUser = "other@example.com"
+	if _, err := c.ListPreviews(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if cache.sets != 2 {
+		t.Fatalf("response of another user answered from the cache")
+	}
+
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-6"})
+	result, err := c.ListPreviews(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || cache.sets != 3 {
+		t.Fatalf("changed response not refreshed: %+v, %d stores", result, cache.sets)
+	}
+}
+
+func TestNotAuthenticated(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	c.Token = "revoked"
+
+	_, err := c.ListPreviews(context.Background(), false)
+	if !errors.Is(err, client.ErrNotAuthenticated) {
+		t.Fatalf("expected ErrNotAuthenticated, got %v", err)
+	}
+
+	err = c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", strings.NewReader("dump"), "db.sql.gz")
+	if !errors.Is(err, client.ErrNotAuthenticated) {
+		t.Fatalf("expected ErrNotAuthenticated on upload, got %v", err)
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.RefreshToken = "refresh-1"
+	c := srv.Client()
+	c.Token = "expired"
+	c.RefreshToken = "refresh-1"
+	var saved string
+	c.OnTokenRefresh = func(token, refreshToken string) { saved = token }
+
+	if _, err := c.ListPreviews(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != clienttest.Token || saved != clienttest.Token {
+		t.Fatalf("token not refreshed: client %q, saved %q", c.Token, saved)
+	}
+
+	// Streamed bodies can't be replayed; uploads resend the spooled file.
+	c.Token = "expired"
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", strings.NewReader("dump"), "db.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRequests(srv, "auth/refresh"); n != 2 {
+		t.Fatalf("expected 2 refreshes, got %d", n)
+	}
+}
+
+func TestTokenRefreshFails(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.RefreshToken = "refresh-1"
+	c := srv.Client()
+	c.Token = "expired"
+	c.RefreshToken = "revoked"
+
+	_, err := c.ListPreviews(context.Background(), false)
+	if !errors.Is(err, client.ErrNotAuthenticated) {
+		t.Fatalf("expected ErrNotAuthenticated, got %v", err)
+	}
+}
+
+func TestPostActionNotFound(t *testing.T) {
+	srv := clienttest.NewServer(t)
+
+	_, err := srv.Client().PostAction(context.Background(), "drupal-test", 99, "start")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPostDrush(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.SetActionResult("drupal-test", "mr-5", "drush", client.ActionResult{Success: true, Output: "Cache rebuild complete.\n"})
+
+	result, err := srv.Client().PostDrush(context.Background(), "drupal-test", 5, "cr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Output != "Cache rebuild complete.\n" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	c := srv.Client()
+	ctx := context.Background()
+	const path = "/api/previews/drupal-test/mr-5/restart"
+
+	if _, err := c.PostActionByName(ctx, "drupal-test", "mr-5", "restart"); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := srv.LastRequest("POST", path); req.Header.Get("X-Preview-Log-Level") != "" {
+		t.Fatalf("log level sent without LogLevel: %q", req.Header.Get("X-Preview-Log-Level"))
+	}
+	c.LogLevel = "debug"
+	if _, err := c.PostActionByName(ctx, "drupal-test", "mr-5", "restart"); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := srv.LastRequest("POST", path); req.Header.Get("X-Preview-Log-Level") != "debug" {
+		t.Fatalf("expected the debug log level to be sent, got %q", req.Header.Get("X-Preview-Log-Level"))
+	}
+}
+
+func TestRebuildIfChanged(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.SetActionResult("drupal-test", "mr-5", "rebuild", client.ActionResult{Success: true, UpToDate: true})
+	c := srv.Client()
+	ctx := context.Background()
+
+	result, err := c.RebuildIfChanged(ctx, "drupal-test", "mr-5", "abc123d")
+	if err != nil || !result.UpToDate {
+		t.Fatalf("expected up to date, got %+v, %v", result, err)
+	}
+	assertRequestJSON(t, srv, "POST", "/api/previews/drupal-test/mr-5/rebuild", `{"commit_sha": "abc123d"}`)
+	if _, err := c.RebuildIfChanged(ctx, "drupal-test", "mr-9", "abc123d"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDrushInteractive(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.Drush = func(args string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "drush %s\nImport the listed configuration changes? (y/n): ", args)
+		answer, _ := bufio.NewReader(stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "y" {
+			return 1
+		}
+		fmt.Fprint(stdout, "Imported.\n")
+		return 0
+	}
+
+	var out bytes.Buffer
+	term := client.Terminal{Stdin: strings.NewReader("y\n"), Stdout: &out}
+	code, err := srv.Client().DrushInteractive(context.Background(), "drupal-test", "mr-5", "cim", term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || !strings.Contains(out.String(), "drush cim") || !strings.Contains(out.String(), "Imported.") {
+		t.Fatalf("unexpected session: code=%d output=%q", code, out.String())
+	}
+}
+
+func TestShell(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.Shell = func(service string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "%s$ ", service)
+		line, _ := bufio.NewReader(stdin).ReadString('\n')
+		fmt.Fprint(stdout, line)
+		return 3
+	}
+
+	var out bytes.Buffer
+	term := client.Terminal{Stdin: strings.NewReader("exit 3\n"), Stdout: &out}
+	code, err := srv.Client().Shell(context.Background(), "drupal-test", "mr-5", "db", term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 || out.String() != "db$ exit 3\n" {
+		t.Fatalf("unexpected session: code=%d output=%q", code, out.String())
+	}
+}
+
+func TestLogs(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.Logs = func(log, severity string, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "%s %s\n", log, severity)
+		return 0
+	}
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	code, err := srv.Client().Logs(ctx, "drupal-test", "mr-5", "watchdog", "error", client.Terminal{Stdout: &out})
+	if err != nil || code != 0 || out.String() != "watchdog error\n" {
+		t.Fatalf("unexpected logs: code=%d err=%v output=%q", code, err, out.String())
+	}
+	req, _ := srv.LastRequest("GET", "/ws/previews/drupal-test/mr-5/terminal")
+	if req.Query.Get("logs") != "watchdog" || req.Query.Get("severity") != "error" {
+		t.Fatalf("unexpected terminal query %v", req.Query)
+	}
+}
+
+func TestMail(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.AddMail("drupal-test", "mr-5", client.MailMessage{Subject: "Welcome", To: []string{"a@example.com"}, Text: "Hi"})
+	srv.AddMail("drupal-test", "mr-5", client.MailMessage{Subject: "Password reset", To: []string{"b@example.com"}, Text: "Reset"})
+	ctx := context.Background()
+
+	list, err := srv.Client().ListMail(ctx, "drupal-test", "mr-5", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || len(list.Messages) != 1 || list.Messages[0].Subject != "Password reset" || list.Password == "" {
+		t.Fatalf("unexpected mail list %+v", list)
+	}
+
+	msg, err := srv.Client().GetMail(ctx, "drupal-test", "mr-5", "1")
+	if err != nil || msg.Subject != "Welcome" {
+		t.Fatalf("unexpected message %+v, %v", msg, err)
+	}
+	msg, err = srv.Client().GetMail(ctx, "drupal-test", "mr-5", "latest")
+	if err != nil || msg.Subject != "Password reset" {
+		t.Fatalf("unexpected latest message %+v, %v", msg, err)
+	}
+	if _, err := srv.Client().GetMail(ctx, "drupal-test", "mr-5", "9"); err == nil {
+		t.Fatal("expected an error for a missing message")
+	}
+	if _, err := srv.Client().ListMail(ctx, "drupal-test", "mr-404", 0); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	c := srv.Client()
+	ctx := context.Background()
+	const path = "/api/previews/drupal-test/mr-5/triggers"
+
+	cron, err := c.CreateTrigger(ctx, "drupal-test", "mr-5", client.TriggerRequest{Action: client.TriggerCron, Name: "cron", ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRequestJSON(t, srv, "POST", path, `{"action": "cron", "args": "", "name": "cron", "expires_in": 3600}`)
+	// The server answers the path of the URL
+	if !strings.HasPrefix(cron.URL, srv.URL+"/api/triggers/") {
+		t.Fatalf("unexpected trigger URL %q", cron.URL)
+	}
+	if _, err := c.CreateTrigger(ctx, "drupal-test", "mr-5", client.TriggerRequest{Action: client.TriggerDrush, Args: "cr"}); err != nil {
+		t.Fatal(err)
+	}
+	// Without ExpiresIn the server's default applies
+	assertRequestJSON(t, srv, "POST", path, `{"action": "drush", "args": "cr", "name": ""}`)
+
+	triggers, err := c.ListTriggers(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 2 || triggers[1].ID != cron.ID || triggers[1].Action != client.TriggerCron || triggers[1].URL != "" {
+		t.Fatalf("unexpected triggers %+v", triggers)
+	}
+
+	if err := c.DeleteTrigger(ctx, "drupal-test", "mr-5", cron.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.LastRequest("DELETE", fmt.Sprintf("%s/%d", path, cron.ID)); !ok {
+		t.Fatalf("trigger not deleted, requests: %v", srv.Requests())
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	c := srv.Client()
+	ctx := context.Background()
+
+	project, err := c.AddAllowlistEntry(ctx, "drupal-test", client.AllowlistRequest{CIDR: "203.0.113.0/24", Note: "office"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.PreviewName != nil || project.CIDR != "203.0.113.0/24" || project.CreatedBy != "dev@example.com" {
+		t.Fatalf("unexpected entry %+v", project)
+	}
+	assertRequestJSON(t, srv, "POST", "/api/projects/drupal-test/allowlist", `{"cidr": "203.0.113.0/24", "note": "office"}`)
+	preview, err := c.AddAllowlistEntry(ctx, "drupal-test", client.AllowlistRequest{CIDR: "198.51.100.7", PreviewName: "mr-5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.PreviewName == nil || *preview.PreviewName != "mr-5" {
+		t.Fatalf("expected an entry of mr-5, got %+v", preview)
+	}
+
+	list, err := c.ListAllowlist(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.ClientIP == "" || len(list.Entries) != 2 {
+		t.Fatalf("unexpected allowlist %+v", list)
+	}
+
+	if err := c.DeleteAllowlistEntry(ctx, "drupal-test", project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteAllowlistEntry(ctx, "drupal-test", project.ID); err == nil {
+		t.Fatal("expected deleting twice to fail")
+	}
+}
+
+func TestDomains(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-6"})
+	c := srv.Client()
+	ctx := context.Background()
+
+	domain, err := c.AddDomain(ctx, "drupal-test", "mr-5", "demo.client.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRequestJSON(t, srv, "POST", "/api/previews/drupal-test/mr-5/domains", `{"hostname": "demo.client.com"}`)
+	if domain.Hostname != "demo.client.com" || domain.Certificate.Status != client.CertificatePending || domain.DNS.OK || len(domain.DNS.Records) != 1 {
+		t.Fatalf("unexpected new domain %+v", domain)
+	}
+	if _, err := c.AddDomain(ctx, "drupal-test", "mr-6", "demo.client.com"); err == nil {
+		t.Fatal("expected a domain to be attached to one preview only")
+	}
+
+	srv.IssueCertificate("demo.client.com")
+	domains, err := c.ListDomains(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || !domains[0].DNS.OK || domains[0].Certificate.Status != client.CertificateIssued {
+		t.Fatalf("expected the issued domain, got %+v", domains)
+	}
+
+	if err := c.DeleteDomain(ctx, "drupal-test", "mr-5", "demo.client.com"); err != nil {
+		t.Fatal(err)
+	}
+	if domains, err = c.ListDomains(ctx, "drupal-test", "mr-5"); err != nil || len(domains) != 0 {
+		t.Fatalf("expected no domains left, got %+v, %v", domains, err)
+	}
+}
+
+// recordingStreamer records the sessions it is asked to run.
+type recordingStreamer struct {
+	requests []client.StreamRequest
+}
+
+func (s *recordingStreamer) Stream(ctx context.Context, req client.StreamRequest, term client.Terminal) (int, error) {
+	s.requests = append(s.requests, req)
+	return 7, nil
+}
+
+func TestCustomStreamer(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	streamer := &recordingStreamer{}
+	c := srv.Client()
+	c.Streamer = streamer
+	ctx := context.Background()
+
+	code, err := c.DrushInteractive(ctx, "drupal-test", "mr-5", "cim", client.Terminal{})
+	if err != nil || code != 7 {
+		t.Fatalf("expected exit code 7 from the streamer, got %d, %v", code, err)
+	}
+	if _, err := c.SolrQuery(ctx, "drupal-test", "mr-5", "title:foo", 5, client.Terminal{}); err != nil {
+		t.Fatal(err)
+	}
+	want := []client.StreamRequest{
+		{Project: "drupal-test", Preview: "mr-5", Program: "drush", Arg: "cim"},
+		{Project: "drupal-test", Preview: "mr-5", Program: "solr", Arg: "query", Options: map[string]string{"q": "title:foo", "rows": "5"}},
+	}
+	if !reflect.DeepEqual(streamer.requests, want) {
+		t.Fatalf("got %+v, want %+v", streamer.requests, want)
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/terminal") {
+			t.Fatalf("the websocket should not be used, requests: %v", srv.Requests())
+		}
+	}
+}
+
+func TestTestSuites(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.TestSuites = []client.TestSuite{
+		{Name: "phpunit", Command: "vendor/bin/phpunit", JUnit: "/tmp/junit/*.xml"},
+		{Name: "behat", Command: "vendor/bin/behat"},
+	}
+	srv.Test = func(suite string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "running %s\n", suite)
+		return 1
+	}
+	c := srv.Client()
+	ctx := context.Background()
+
+	suites, err := c.ListTestSuites(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(suites, srv.TestSuites) {
+		t.Fatalf("got %+v, want %+v", suites, srv.TestSuites)
+	}
+	if _, err := c.ListTestSuites(ctx, "drupal-test", "mr-9"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	var out bytes.Buffer
+	code, err := c.RunTestSuite(ctx, "drupal-test", "mr-5", "behat", client.Terminal{Stdin: strings.NewReader(""), Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 1 || out.String() != "running behat\n" {
+		t.Fatalf("unexpected run: code=%d output=%q", code, out.String())
+	}
+	if _, err := c.RunTestSuite(ctx, "drupal-test", "mr-5", "cypress", client.Terminal{Stdin: strings.NewReader(""), Stdout: &out}); err == nil {
+		t.Fatal("expected the error frame of an undefined suite to fail the run")
+	}
+}
+
+func TestRunDeployScript(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.Deploy = func(phase string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "deploying %s\n", phase)
+		return 0
+	}
+	c := srv.Client()
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	code, err := c.RunDeployScript(ctx, "drupal-test", "mr-5", "update", client.Terminal{Stdin: strings.NewReader(""), Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || out.String() != "deploying update\n" {
+		t.Fatalf("unexpected run: code=%d output=%q", code, out.String())
+	}
+	if req, _ := srv.LastRequest("GET", "/ws/previews/drupal-test/mr-5/terminal"); req.Query.Get("deploy") != "update" {
+		t.Fatalf("unexpected terminal query %v", req.Query)
+	}
+}
+
+func TestServiceHelpers(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.Services = []string{"redis"}
+	srv.Redis = func(args string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "redis-cli %s\n", args)
+		return 0
+	}
+	srv.Solr = func(op, q string, stdin io.Reader, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "%s %s\n", op, q)
+		return 0
+	}
+	c := srv.Client()
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	code, err := c.RedisCLI(ctx, "drupal-test", "mr-5", "FLUSHALL", client.Terminal{Stdin: strings.NewReader(""), Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || out.String() != "redis-cli FLUSHALL\n" {
+		t.Fatalf("unexpected run: code=%d output=%q", code, out.String())
+	}
+	if _, err := c.SolrReindex(ctx, "drupal-test", "mr-5", client.Terminal{Stdin: strings.NewReader(""), Stdout: &out}); err == nil {
+		t.Fatal("expected the error frame of a disabled service to fail the run")
+	}
+
+	srv.Services = append(srv.Services, "solr")
+	out.Reset()
+	if _, err := c.SolrQuery(ctx, "drupal-test", "mr-5", "title:drupal", 5, client.Terminal{Stdin: strings.NewReader(""), Stdout: &out}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "query title:drupal\n" {
+		t.Fatalf("unexpected query output %q", out.String())
+	}
+	if req, _ := srv.LastRequest("GET", "/ws/previews/drupal-test/mr-5/terminal"); req.Query.Get("solr") != "query" || req.Query.Get("q") != "title:drupal" || req.Query.Get("rows") != "5" {
+		t.Fatalf("unexpected terminal query %v", req.Query)
+	}
+}
+
+func TestAutoStopPolicy(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	policy, err := c.GetAutoStopPolicy(ctx, "drupal-test")
+	if err != nil || policy.Override {
+		t.Fatalf("GetAutoStopPolicy = %+v, %v", policy, err)
+	}
+	if err := c.SetAutoStopPolicy(ctx, "drupal-test", client.AutoStopPolicy{Override: true, Enabled: true, Minutes: 120}); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = c.GetAutoStopPolicy(ctx, "drupal-test")
+	if err != nil || *policy != (client.AutoStopPolicy{Override: true, Enabled: true, Minutes: 120}) {
+		t.Fatalf("GetAutoStopPolicy = %+v, %v", policy, err)
+	}
+	if err := c.SetAutoStopPolicy(ctx, "drupal-test", client.AutoStopPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = c.GetAutoStopPolicy(ctx, "drupal-test"); err != nil || policy.Override {
+		t.Fatalf("GetAutoStopPolicy after reset = %+v, %v", policy, err)
+	}
+	if policy, err = c.GetAutoStopPolicy(ctx, ""); err != nil || policy.Minutes != 60 {
+		t.Fatalf("global GetAutoStopPolicy = %+v, %v", policy, err)
+	}
+}
+
+func TestTemplateSets(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	if _, err := c.GetTemplateSet(ctx, "org-default"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	set := client.TemplateSet{Name: "org-default", Files: map[string]string{"preview.yml": "php_version: \"8.2\"\n"}}
+	if err := c.PutTemplateSet(ctx, set); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := c.ListTemplateSets(ctx)
+	if err != nil || len(sets) != 1 || sets[0].Name != "org-default" || len(sets[0].Files) != 1 || sets[0].Files[0] != "preview.yml" {
+		t.Fatalf("ListTemplateSets = %+v, %v", sets, err)
+	}
+	got, err := c.GetTemplateSet(ctx, "org-default")
+	if err != nil || got.Files["preview.yml"] != set.Files["preview.yml"] {
+		t.Fatalf("GetTemplateSet = %+v, %v", got, err)
+	}
+	if err := c.DeleteTemplateSet(ctx, "org-default"); err != nil {
+		t.Fatal(err)
+	}
+	if sets, err := c.ListTemplateSets(ctx); err != nil || len(sets) != 0 {
+		t.Fatalf("ListTemplateSets after delete = %+v, %v", sets, err)
+	}
+}
+
+func TestValidatePreviewYml(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	var got client.ValidateRequest
+	srv.Validate = func(project string, req client.ValidateRequest) *client.ValidationResult {
+		got = req
+		return &client.ValidationResult{Valid: false, Ref: req.Ref, Checks: []client.ValidationCheck{
+			{Name: "php", Status: "error", Message: "PHP 7.4 is not available on this server", Hint: "Set php_version to one of: 8.2, 8.3"},
+		}}
+	}
+	c := srv.Client()
+
+	req := client.ValidateRequest{PreviewYml: "php_version: \"7.4\"\n", Ref: "abc123", Branch: "feature/x"}
+	result, err := c.ValidatePreviewYml(context.Background(), "drupal-test", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != req {
+		t.Errorf("server got %+v, want %+v", got, req)
+	}
+	if result.Valid || result.Ref != "abc123" || len(result.Checks) != 1 || result.Checks[0].Hint == "" {
+		t.Errorf("ValidatePreviewYml = %+v", result)
+	}
+}
+
+func TestPreviewLastActivity(t *testing.T) {
+	deployed := "2026-01-02T10:00:00+00:00"
+	accessed := "2026-01-03T08:30:00.123456+00:00"
+	p := client.Preview{LastDeployedAt: &deployed, LastAccessedAt: &accessed}
+	last, ok := p.LastActivity()
+	if !ok || !last.Equal(time.Date(2026, 1, 3, 8, 30, 0, 123456000, time.UTC)) {
+		t.Fatalf("LastActivity = %v, %v", last, ok)
+	}
+	if _, ok := (client.Preview{}).LastActivity(); ok {
+		t.Fatal("expected no activity for a preview never visited or deployed")
+	}
+}
+
+func TestMembers(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	viewer := "viewer"
+	srv.AddMember(client.Member{Email: "ana@example.com", Name: "Ana", Role: &viewer})
+	c := srv.Client()
+	ctx := context.Background()
+
+	members, err := c.ListMembers(ctx)
+	if err != nil || len(members) != 1 {
+		t.Fatalf("ListMembers = %+v, %v", members, err)
+	}
+	ana := members[0].ID
+	if err := c.SetMemberRole(ctx, ana, "manager"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddProjectMember(ctx, "drupal-test", ana); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Invite(ctx, "ana@example.com", "viewer", ""); err == nil {
+		t.Fatal("expected inviting an existing user to fail")
+	}
+	inv, err := c.Invite(ctx, "bob@example.com", "viewer", "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := c.ListProjectMembers(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.Members) != 1 || *project.Members[0].Role != "manager" || len(project.Invitations) != 1 || project.Invitations[0].Email != "bob@example.com" {
+		t.Fatalf("unexpected project members: %+v", project)
+	}
+
+	if err := c.CancelInvitation(ctx, inv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveProjectMember(ctx, "drupal-test", ana); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveMember(ctx, ana); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveMember(ctx, ana); !errors.As(err, new(*client.HTTPError)) {
+		t.Fatalf("expected removing an unknown user to fail, got %v", err)
+	}
+	if members, _ := c.ListMembers(ctx); len(members) != 0 || len(srv.Invitations()) != 0 {
+		t.Fatalf("expected no members or invitations left, got %+v, %+v", members, srv.Invitations())
+	}
+}
+
+func TestPipelines(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPipeline("drupal-test", "branch-develop", client.Pipeline{ID: 10, Status: "success", Ref: "develop"})
+	srv.AddPipeline("drupal-test", "mr-5", client.Pipeline{ID: 11, Status: "failed", Ref: "feature"},
+		client.Job{ID: 100, Name: "build", Stage: "build", Status: "success"},
+		client.Job{ID: 101, Name: "deploy", Stage: "deploy", Status: "failed"})
+	srv.SetJobLog("drupal-test", 101, "Deploying...\nError: drush failed\n")
+	c := srv.Client()
+	ctx := context.Background()
+
+	all, err := c.ListPipelines(ctx, "drupal-test", "")
+	if err != nil || len(all) != 2 || all[0].ID != 11 {
+		t.Fatalf("ListPipelines = %+v, %v", all, err)
+	}
+	mr, err := c.ListPipelines(ctx, "drupal-test", "mr-5")
+	if err != nil || len(mr) != 1 || mr[0].Status != "failed" {
+		t.Fatalf("ListPipelines(mr-5) = %+v, %v", mr, err)
+	}
+
+	jobs, err := c.ListPipelineJobs(ctx, "drupal-test", 11)
+	if err != nil || len(jobs) != 2 || jobs[1].Name != "deploy" {
+		t.Fatalf("ListPipelineJobs = %+v, %v", jobs, err)
+	}
+	var log bytes.Buffer
+	if err := c.JobLog(ctx, "drupal-test", 101, &log); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "drush failed") {
+		t.Fatalf("unexpected job log %q", log.String())
+	}
+	if _, err := c.ListPipelineJobs(ctx, "drupal-test", 99); !errors.As(err, new(*client.HTTPError)) {
+		t.Fatalf("expected an unknown pipeline to fail, got %v", err)
+	}
+}
+
+func TestTeamCodes(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	ctx := context.Background()
+
+	code, err := srv.Client().CreateTeamCode(ctx, client.TeamDefaults{Org: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiURL, err := client.ParseTeamCode(code)
+	if err != nil || apiURL != srv.URL {
+		t.Fatalf("ParseTeamCode = %q, %v; want %q", apiURL, err, srv.URL)
+	}
+
+	// New machines have no token yet
+	c := client.New(apiURL, "")
+	defaults, err := c.GetTeamDefaults(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.Org != "acme" {
+		t.Fatalf("got %+v", defaults)
+	}
+
+	if _, err := client.ParseTeamCode("not a code"); err == nil {
+		t.Fatal("expected an invalid code error")
+	}
+	unknown := base64.RawURLEncoding.EncodeToString([]byte(srv.URL + "#nope"))
+	if _, err := c.GetTeamDefaults(ctx, unknown); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUploadSingleRequest(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	data := []byte("small dump")
+
+	if err := srv.Client().UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(data), "db.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := srv.BaseFile("drupal-test", "db")
+	if !ok || !bytes.Equal(got, data) {
+		t.Fatalf("server got %q, want %q", got, data)
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/upload/") {
+			t.Fatalf("small upload should not be chunked, saw %s", r)
+		}
+	}
+}
+
+func TestUploadChunked(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	c.ChunkSize = 1024
+	data := bytes.Repeat([]byte("0123456789"), 350) // 3500 bytes -> 4 chunks
+
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "files", bytes.NewReader(data), "files.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := srv.BaseFile("drupal-test", "files")
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled upload differs: got %d bytes, want %d", len(got), len(data))
+	}
+	if n := countRequests(srv, "/upload/chunk"); n != 4 {
+		t.Fatalf("expected 4 chunk requests, got %d", n)
+	}
+}
+
+func TestUploadChunkRetry(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.FailChunks = 2
+	c := srv.Client()
+	c.ChunkSize = 1024
+	var stats client.UploadStats
+	c.OnUploadComplete = func(s client.UploadStats) { stats = s }
+	data := bytes.Repeat([]byte("x"), 2048)
+
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(data), "db.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := srv.BaseFile("drupal-test", "db")
+	if !bytes.Equal(got, data) {
+		t.Fatal("upload differs after retries")
+	}
+	if n := countRequests(srv, "/upload/chunk"); n != 4 {
+		t.Fatalf("expected 4 chunk requests (2 failed + 2 ok), got %d", n)
+	}
+	if stats.Bytes != 2048 || stats.Chunks != 2 || stats.Retries != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.RateLimit = 2
+	srv.RetryAfter = "0"
+	c := srv.Client()
+	var progress bytes.Buffer
+	c.Progress = &progress
+
+	result, err := c.ListPreviews(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Previews) != 1 {
+		t.Fatalf("expected 1 preview, got %d", len(result.Previews))
+	}
+	if n := countRequests(srv, "GET /api/previews"); n != 3 {
+		t.Fatalf("expected 3 requests (2 rate-limited + 1 ok), got %d", n)
+	}
+	if !strings.Contains(progress.String(), "Rate limited by the server, retrying in") {
+		t.Fatalf("no retry message in %q", progress.String())
+	}
+
+	// POSTs aren't retried, they may not be idempotent
+	srv.RateLimit = 1
+	_, err = c.AddAllowlistEntry(context.Background(), "drupal-test", client.AllowlistRequest{CIDR: "203.0.113.7"})
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 HTTPError, got %v", err)
+	}
+	if n := countRequests(srv, "POST /api/projects/drupal-test/allowlist"); n != 1 {
+		t.Fatalf("expected 1 allowlist request, got %d", n)
+	}
+
+	// Waits longer than MaxRateLimitWait fail at once
+	srv.RateLimit = 1
+	srv.RetryAfter = time.Now().Add(2 * time.Hour).UTC().Format(http.TimeFormat)
+	_, err = c.ListPreviews(context.Background(), false)
+	if !errors.As(err, &httpErr) || httpErr.RetryAfter < time.Hour {
+		t.Fatalf("expected a 429 HTTPError with RetryAfter, got %v", err)
+	}
+
+	// As does a client that doesn't retry
+	srv.RateLimit = 1
+	srv.RetryAfter = "1"
+	c.RateLimitRetries = 0
+	_, err = c.ListPreviews(context.Background(), false)
+	if !errors.As(err, &httpErr) || httpErr.RetryAfter != time.Second {
+		t.Fatalf("expected a 429 HTTPError after 1s, got %v", err)
+	}
+}
+
+func TestUploadProgressEvents(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	c.ChunkSize = 1024
+	var progress bytes.Buffer
+	c.Progress = &progress
+	var events []client.ProgressEvent
+	c.OnProgress = func(e client.ProgressEvent) { events = append(events, e) }
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(data), "db.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buffered, uploads []client.ProgressEvent
+	for _, e := range events {
+		if e.Kind != "db" {
+			t.Fatalf("unexpected kind in %+v", e)
+		}
+		switch e.Phase {
+		case client.PhaseBuffer:
+			buffered = append(buffered, e)
+		case client.PhaseUpload:
+			uploads = append(uploads, e)
+		}
+	}
+	if len(buffered) != 1 || !buffered[0].Done || buffered[0].Bytes != 4096 {
+		t.Fatalf("unexpected buffer events %+v", buffered)
+	}
+	if len(uploads) != 4 {
+		t.Fatalf("expected an upload event per chunk, got %+v", uploads)
+	}
+	last := uploads[len(uploads)-1]
+	if !last.Done || last.Bytes != 4096 || last.Total != 4096 || last.Percent != 100 {
+		t.Fatalf("unexpected last upload event %+v", last)
+	}
+	if strings.Contains(progress.String(), "█") || strings.Contains(progress.String(), "\r") {
+		t.Fatalf("progress bars drawn besides events: %q", progress.String())
+	}
+}
+
+func TestUploadStreaming(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.FailChunks = 1
+	c := srv.Client()
+	c.ChunkSize = 1024
+	c.StreamChunks = true
+	var stats client.UploadStats
+	c.OnUploadComplete = func(s client.UploadStats) { stats = s }
+	data := bytes.Repeat([]byte("0123456789"), 250) // 2500 bytes -> 3 chunks
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", pr, "db.sql.gz")
+	}()
+	pw.Write(data[:2048])
+
+	// The first chunk is sent while the reader is still producing
+	deadline := time.Now().Add(5 * time.Second)
+	for countRequests(srv, "/upload/chunk") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no chunk sent before the end of the stream")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pw.Write(data[2048:])
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := srv.BaseFile("drupal-test", "db")
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled upload differs: got %d bytes, want %d", len(got), len(data))
+	}
+	if stats.Bytes != 2500 || stats.Chunks != 3 || stats.Retries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Streams shorter than a chunk are sent in a single request
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "files", strings.NewReader("tar"), "files.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := srv.BaseFile("drupal-test", "files"); string(got) != "tar" {
+		t.Fatalf("server got %q, want %q", got, "tar")
+	}
+	if n := countRequests(srv, "/upload/init"); n != 1 {
+		t.Fatalf("expected 1 chunked upload, got %d", n)
+	}
+}
+
+func TestUploadAdaptiveChunks(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		srv := clienttest.NewServer(t)
+		srv.FailChunks = 2
+		c := srv.Client()
+		c.ChunkSize = 1024
+		c.MinChunkSize = 256
+		c.MaxChunkSize = 4096
+		c.AdaptChunkSize = true
+		c.StreamChunks = stream
+		var stats client.UploadStats
+		c.OnUploadComplete = func(s client.UploadStats) { stats = s }
+		data := make([]byte, 12288)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+
+		if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(data), "db.sql.gz"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := srv.BaseFile("drupal-test", "db"); !bytes.Equal(got, data) {
+			t.Fatalf("stream=%v: reassembled upload differs: got %d bytes, want %d", stream, len(got), len(data))
+		}
+		// The first chunk fails at 1024 and 512 bytes and is sent at 256,
+		// then fast chunks double: 256, 512, 1024, 2048, 4096 and 4096
+		if stats.Chunks != 7 || stats.Retries != 2 {
+			t.Fatalf("stream=%v: unexpected stats %+v", stream, stats)
+		}
+	}
+}
+
+func TestUploadChunkTokenRefresh(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.RefreshToken = "refresh-1"
+	srv.ExpireTokenChunks = 1
+	c := srv.Client()
+	c.RefreshToken = "refresh-1"
+	c.ChunkSize = 1024
+	c.MinChunkSize = 256
+	c.AdaptChunkSize = true
+	c.StreamChunks = true
+	var stats client.UploadStats
+	c.OnUploadComplete = func(s client.UploadStats) { stats = s }
+	data := bytes.Repeat([]byte("d"), 4096)
+
+	if err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(data), "db.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := srv.BaseFile("drupal-test", "db"); !bytes.Equal(got, data) {
+		t.Fatalf("reassembled upload differs: got %d bytes, want %d", len(got), len(data))
+	}
+	// The chunk is resent at the same size, neither halved nor counted as
+	// a retry: four chunks of 1024 bytes
+	if stats.Chunks != 4 || stats.Retries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if n := countRequests(srv, "auth/refresh"); n != 1 {
+		t.Fatalf("expected 1 refresh, got %d", n)
+	}
+}
+
+func TestUploadStreamingReaderFails(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	c.ChunkSize = 1024
+	c.StreamChunks = true
+	dumpErr := errors.New("drush sql-dump failed: exit status 1")
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(make([]byte, 1500))
+		pw.CloseWithError(dumpErr)
+	}()
+	err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", pr, "db.sql.gz")
+	if !errors.Is(err, dumpErr) {
+		t.Fatalf("expected the reader error, got %v", err)
+	}
+	if _, ok := srv.BaseFile("drupal-test", "db"); ok {
+		t.Fatal("a truncated upload was completed")
+	}
+	if countRequests(srv, "/upload/abort") != 1 || srv.PendingUploads() != 0 {
+		t.Fatalf("expected the upload to be aborted, requests: %v", srv.Requests())
+	}
+}
+
+func TestVerifyBaseFiles(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	want := []client.BaseFileCheck{
+		{Kind: "db", Status: "ok", Message: "Complete", SizeBytes: 49, UncompressedBytes: 29, Tables: 1, SHA256: "abc"},
+		{Kind: "files", Status: "failed", Message: "Corrupt: unexpected EOF", SizeBytes: 45},
+	}
+	srv.Verify = func(slug string) []client.BaseFileCheck { return want }
+
+	checks, err := srv.Client().VerifyBaseFiles(context.Background(), "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Fatalf("got %+v, want %+v", checks, want)
+	}
+	if _, ok := srv.LastRequest("POST", "/api/projects/drupal-test/base-files/verify"); !ok {
+		t.Fatalf("verify not requested: %v", srv.Requests())
+	}
+}
+
+func TestUploadSessions(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	stale := srv.AddUpload("drupal-test", "db", "stale", make([]byte, 1024), make([]byte, 512))
+	srv.AddUpload("drupal-test", "files", "orphaned", make([]byte, 100))
+
+	uploads, err := c.ListUploads(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 || uploads[0].UploadID != stale || uploads[0].BytesReceived != 1536 || uploads[0].ChunksReceived != 2 {
+		t.Fatalf("unexpected uploads %+v", uploads)
+	}
+	if uploads[1].State != "orphaned" || uploads[1].Project != "" {
+		t.Fatalf("expected an orphaned upload without project, got %+v", uploads[1])
+	}
+
+	freed, err := c.AbortUpload(ctx, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 1536 {
+		t.Fatalf("expected 1536 bytes freed, got %d", freed)
+	}
+	if srv.PendingUploads() != 1 {
+		t.Fatalf("expected 1 pending upload, got %d", srv.PendingUploads())
+	}
+	if _, err := c.AbortUpload(ctx, stale); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound aborting twice, got %v", err)
+	}
+}
+
+func TestUploadChunkRetryExhausted(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.FailChunks = 3
+	c := srv.Client()
+	c.ChunkSize = 1024
+
+	err := c.UploadBaseFileChunked(context.Background(), "drupal-test", "db", bytes.NewReader(make([]byte, 2048)), "db.sql.gz")
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 500 {
+		t.Fatalf("expected HTTP 500 after 3 attempts, got %v", err)
+	}
+	if _, ok := srv.BaseFile("drupal-test", "db"); ok {
+		t.Fatal("failed upload should not be stored")
+	}
+}
+
+func TestBaseFilesHistory(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	for _, kind := range []string{"db", "files"} {
+		if err := c.UploadBaseFileChunked(ctx, "drupal-test", kind, strings.NewReader("data"), kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploads, err := c.GetBaseFilesHistory(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 || uploads[0].Kind != "files" || uploads[1].Kind != "db" {
+		t.Fatalf("expected files then db (newest first), got %+v", uploads)
+	}
+	// sha256("data")
+	if uploads[0].SHA256 != "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7" || uploads[0].UploadedBy != "dev@example.com" {
+		t.Fatalf("unexpected entry %+v", uploads[0])
+	}
+}
+
+func TestRestoreBaseFile(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	var sums []string
+	for _, dump := range []string{"good", "older", "botched"} {
+		if err := c.UploadBaseFileChunked(ctx, "drupal-test", "db", strings.NewReader(dump), "db.sql.gz"); err != nil {
+			t.Fatal(err)
+		}
+		uploads, err := c.GetBaseFilesHistory(ctx, "drupal-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums = append(sums, uploads[0].SHA256)
+	}
+
+	uploads, err := c.GetBaseFilesHistory(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uploads[0].Current || uploads[0].Restorable || !uploads[1].Restorable || !uploads[2].Restorable {
+		t.Fatalf("expected the latest upload in use and the others restorable, got %+v", uploads)
+	}
+
+	// The uploads share their date: a date matches several
+	if _, err := c.RestoreBaseFile(ctx, "drupal-test", "db", uploads[0].UploadedAt[:10]); err == nil {
+		t.Fatal("expected an ambiguous version to fail")
+	}
+	restored, err := c.RestoreBaseFile(ctx, "drupal-test", "db", sums[0][:12])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.SHA256 != sums[0] || !restored.Current || restored.RestoredFrom == "" {
+		t.Fatalf("unexpected restore entry %+v", restored)
+	}
+	if got, _ := srv.BaseFile("drupal-test", "db"); string(got) != "good" {
+		t.Fatalf("base db is %q after the restore, want %q", got, "good")
+	}
+
+	// The replaced upload can be restored in turn
+	uploads, err = c.GetBaseFilesHistory(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 4 || !uploads[1].Restorable || uploads[1].SHA256 != sums[2] {
+		t.Fatalf("expected the botched upload restorable, got %+v", uploads)
+	}
+	if _, err := c.RestoreBaseFile(ctx, "drupal-test", "db", sums[0]); err == nil {
+		t.Fatal("expected restoring the version in use to fail")
+	}
+}
+
+func TestSecondaryDatabaseDump(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	if err := c.UploadBaseFileChunked(ctx, "drupal-test", client.DatabaseKind("migrate"), strings.NewReader("dump"), "drupal-test-base-db-migrate.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.GetBaseFilesStatus(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.DB.Exists {
+		t.Fatal("the secondary dump must not count as the main one")
+	}
+	if info := status.Databases["migrate"]; info == nil || !info.Exists || info.SizeBytes != 4 {
+		t.Fatalf("expected the migrate dump, got %+v", status.Databases)
+	}
+}
+
+func TestHeavyFilesManifest(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	if _, err := c.GetHeavyFilesManifest(ctx, "drupal-test"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	files := []client.HeavyFile{{Path: "videos/intro.mp4", SizeBytes: 50 << 20}}
+	if err := c.PutHeavyFilesManifest(ctx, "drupal-test", files); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := c.GetHeavyFilesManifest(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest.Files, files) || manifest.RecordedAt == "" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	// A new archive without heavy files makes the manifest stale.
+	if err := c.UploadBaseFile(ctx, "drupal-test", "files", strings.NewReader("tar"), "files.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetHeavyFilesManifest(ctx, "drupal-test"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected the manifest to be discarded, got %v", err)
+	}
+}
+
+func TestDBSync(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	var httpErr *client.HTTPError
+	if _, err := c.SyncBaseDB(ctx, "drupal-test"); !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+		t.Fatalf("expected 400 without a source, got %v", err)
+	}
+
+	srv.DBSync = func(slug string, log func(string)) ([]byte, error) {
+		log("Dumping drupal from prod...")
+		log("Sanitizing...")
+		return []byte("sanitized dump"), nil
+	}
+	jobID, err := c.SyncBaseDB(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	if err := c.FollowDBSync(ctx, "drupal-test", jobID, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.String() != "Dumping drupal from prod...\nSanitizing...\n" {
+		t.Fatalf("unexpected log %q", log.String())
+	}
+	var buf bytes.Buffer
+	if err := c.DownloadBaseFile(ctx, "drupal-test", "db", &buf); err != nil || buf.String() != "sanitized dump" {
+		t.Fatalf("base db not replaced: %q, %v", buf.String(), err)
+	}
+
+	srv.DBSync = func(slug string, log func(string)) ([]byte, error) {
+		return nil, errors.New("ssh: connection refused")
+	}
+	jobID, err = c.SyncBaseDB(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Reset()
+	if err := c.FollowDBSync(ctx, "drupal-test", jobID, &log); !errors.Is(err, client.ErrSyncFailed) {
+		t.Fatalf("expected ErrSyncFailed, got %v", err)
+	}
+	if !strings.Contains(log.String(), "connection refused") {
+		t.Fatalf("error not logged: %q", log.String())
+	}
+}
+
+func TestAnonymizePreviewDB(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	if _, err := c.AnonymizePreviewDB(ctx, "drupal-test", "mr-5", client.AnonymizeRequest{}); err == nil {
+		t.Fatal("expected a preview without a recipe to fail")
+	}
+
+	var got client.AnonymizeRequest
+	srv.Anonymize = func(project, name string, req client.AnonymizeRequest) *client.AnonymizeResult {
+		got = req
+		return &client.AnonymizeResult{Success: true, Statements: []string{"TRUNCATE TABLE `sessions`"}}
+	}
+	recipe := "sanitize:\n  truncate: [sessions]\n"
+	result, err := c.AnonymizePreviewDB(ctx, "drupal-test", "mr-5", client.AnonymizeRequest{DryRun: true, PreviewYml: recipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.DryRun || got.PreviewYml != recipe {
+		t.Fatalf("server got %+v", got)
+	}
+	if !result.Success || len(result.Statements) != 1 || result.Applied != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestPlanPreview(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	plan, err := c.PlanPreview(ctx, "drupal-test", "mr-5", client.PlanRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", plan.Changes)
+	}
+
+	var got client.PlanRequest
+	srv.Plan = func(project, name string, req client.PlanRequest) *client.Plan {
+		got = req
+		return &client.Plan{Preview: name, CommitSHA: "abc123", Changes: []client.PlanChange{
+			{Key: "php_version", From: "8.2", To: "8.3", Action: client.PlanReplace, Container: "php"},
+			{Key: "services.solr", From: false, To: true, Action: client.PlanCreate, Container: "solr"},
+		}}
+	}
+	yml := "php_version: 8.3\nservices:\n  solr: true\n"
+	plan, err = c.PlanPreview(ctx, "drupal-test", "mr-5", client.PlanRequest{PreviewYml: yml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PreviewYml != yml {
+		t.Fatalf("server got %+v", got)
+	}
+	if len(plan.Changes) != 2 || plan.Changes[0].Action != client.PlanReplace || plan.Changes[1].To != true {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+func TestListProjects(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.Projects = []client.Project{{Slug: "drupal-test", Name: "Drupal test", PathWithNamespace: "preview-tests/drupal-test"}}
+
+	projects, err := srv.Client().ListProjects(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].Slug != "drupal-test" || projects[0].PathWithNamespace != "preview-tests/drupal-test" {
+		t.Fatalf("unexpected projects %+v", projects)
+	}
+}
+
+func TestProjectSettings(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	settings, err := c.UpdateProjectSettings(ctx, "drupal-test", map[string]string{"memory_limit": "2g", "cpu_limit": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Settings["memory_limit"] != "2g" || settings.Settings["cpu_limit"] != nil {
+		t.Fatalf("unexpected settings %+v", settings.Settings)
+	}
+
+	settings, err = c.GetProjectSettings(ctx, "drupal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Settings["memory_limit"] != "2g" {
+		t.Fatalf("settings not kept: %+v", settings.Settings)
+	}
+}
+
+func TestDownloadStream(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.SetDownload("drupal-test", "mr-5", "db", []byte("gzipped dump"))
+
+	var buf bytes.Buffer
+	if err := srv.Client().DownloadStream(context.Background(), "drupal-test", "mr-5", "db", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "gzipped dump" {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+func TestDownloadTables(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.SetDownload("drupal-test", "mr-5", "db", []byte("full dump"))
+	srv.SetTableDump("drupal-test", "mr-5", "node", []byte("node;"))
+	srv.SetTableDump("drupal-test", "mr-5", "users", []byte("users;"))
+	c := srv.Client()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := c.DownloadTables(ctx, "drupal-test", "mr-5", []string{"node", "users"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "node;users;" {
+		t.Fatalf("got %q", buf.String())
+	}
+	err := c.DownloadTables(ctx, "drupal-test", "mr-5", []string{"nodes", "users", "files"}, io.Discard)
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 404 || httpErr.Message != "Tables not found: nodes, files" {
+		t.Fatalf("expected a 404 naming the unknown tables, got %v", err)
+	}
+}
+
+func TestScalePreview(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.Scale = func(project, name string, changes map[string]string) *client.ActionResult {
+		return &client.ActionResult{Success: true, Output: "limits changed\n"}
+	}
+	c := srv.Client()
+	ctx := context.Background()
+
+	result, err := c.ScalePreview(ctx, "drupal-test", "mr-5", map[string]string{"memory": "2g", "cpus": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Output != "limits changed\n" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	// An empty value resets the limit, so it is sent as is
+	assertRequestJSON(t, srv, "POST", "/api/previews/drupal-test/mr-5/scale", `{"memory": "2g", "cpus": ""}`)
+
+	if _, err := c.ScalePreview(ctx, "drupal-test", "mr-9", map[string]string{"cpus": "1"}); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRetargetPreview(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	want := client.RetargetResult{PreviewName: "mr-9", Branch: "feature/new", CommitSHA: "abc123", URL: "https://mr-9.example.com", Message: "Moved"}
+	srv.Retarget = func(project, name string, req client.RetargetRequest) *client.RetargetResult {
+		return &want
+	}
+	c := srv.Client()
+	ctx := context.Background()
+	const path = "/api/previews/drupal-test/mr-5/retarget"
+
+	result, err := c.RetargetPreview(ctx, "drupal-test", "mr-5", client.RetargetRequest{MrIID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result != want {
+		t.Fatalf("got %+v, want %+v", result, want)
+	}
+	assertRequestJSON(t, srv, "POST", path, `{"mr_iid": 9}`)
+	if _, err := c.RetargetPreview(ctx, "drupal-test", "mr-5", client.RetargetRequest{Branch: "release/2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	assertRequestJSON(t, srv, "POST", path, `{"branch": "release/2.0"}`)
+
+	var httpErr *client.HTTPError
+	if _, err := c.RetargetPreview(ctx, "drupal-test", "mr-9", client.RetargetRequest{MrIID: 6}); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Fatalf("expected 404, got %v", err)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.SetArtifact("drupal-test", "mr-5", "/files/report.pdf", []byte("pdf"))
+	srv.SetArtifact("drupal-test", "mr-5", "/files/invoice.pdf", []byte("invoice"))
+	srv.SetArtifact("drupal-test", "mr-5", "/files/notes.txt", []byte("notes"))
+	c := srv.Client()
+	ctx := context.Background()
+
+	artifacts, err := c.ListArtifacts(ctx, "drupal-test", "mr-5", "/files/*.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []client.Artifact{{Path: "/files/invoice.pdf", SizeBytes: 7}, {Path: "/files/report.pdf", SizeBytes: 3}}
+	if !reflect.DeepEqual(artifacts, want) {
+		t.Fatalf("got %+v, want %+v", artifacts, want)
+	}
+
+	var buf bytes.Buffer
+	if err := c.DownloadArtifact(ctx, "drupal-test", "mr-5", "/files/report.pdf", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "pdf" {
+		t.Fatalf("got %q", buf.String())
+	}
+
+	err = c.DownloadArtifact(ctx, "drupal-test", "mr-5", "/files/missing.pdf", &buf)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPreviewSize(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	db := int64(2048)
+	srv.SetSize("drupal-test", "mr-5", client.PreviewSize{
+		TotalBytes: db,
+		Components: []client.SizeComponent{{Name: "database", SizeBytes: &db}, {Name: "solr"}},
+	})
+	c := srv.Client()
+	ctx := context.Background()
+
+	size, err := c.GetPreviewSize(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Preview != "mr-5" || size.TotalBytes != 2048 || len(size.Components) != 2 {
+		t.Fatalf("unexpected size %+v", size)
+	}
+	if *size.Components[0].SizeBytes != 2048 || size.Components[1].SizeBytes != nil {
+		t.Fatalf("expected a measured database and an unmeasured solr, got %+v", size.Components)
+	}
+
+	if _, err := c.GetPreviewSize(ctx, "drupal-test", "mr-6"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSyncPreviewFiles(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	srv.SetPreviewFile("drupal-test", "mr-5", "old/a.txt", []byte("a"))
+	srv.SetPreviewFile("drupal-test", "mr-5", "keep.txt", []byte("k"))
+	c := srv.Client()
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "new/logo.png", Mode: 0o644, Size: 4, ModTime: time.Unix(1700000000, 0)})
+	tw.Write([]byte("logo"))
+	tw.Close()
+	gz.Close()
+
+	result, err := c.SyncPreviewFiles(context.Background(), "drupal-test", "mr-5", bytes.NewReader(archive.Bytes()), int64(archive.Len()), []string{"old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Written != 1 || result.Deleted != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	files := srv.PreviewFiles("drupal-test", "mr-5")
+	want := map[string][]byte{"keep.txt": []byte("k"), "new/logo.png": []byte("logo")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("got files %q, want %q", files, want)
+	}
+
+	list, err := c.ListPreviewFiles(context.Background(), "drupal-test", "mr-5", []string{"new", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantList := []client.PreviewFile{{Path: "new/logo.png", SizeBytes: 4, ModTime: 1700000000}}
+	if !reflect.DeepEqual(list, wantList) {
+		t.Fatalf("got manifest %+v, want %+v", list, wantList)
+	}
+}
+
+func TestAPIVersionMismatch(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.LatestVersion = "3.0.0"
+	srv.APIVersions = [2]int{client.MaxAPIVersion + 1, client.MaxAPIVersion + 2}
+
+	_, err := srv.Client().ListPreviews(context.Background(), false)
+	var verr *client.APIVersionError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *APIVersionError, got %v", err)
+	}
+	if !verr.ClientTooOld() || verr.CLIVersion != "3.0.0" {
+		t.Fatalf("unexpected error %+v", verr)
+	}
+	// self-update must still be able to find the server's CLI version.
+	if v, err := srv.Client().CLIVersion(context.Background()); err != nil || v != "3.0.0" {
+		t.Fatalf("CLIVersion: %q, %v", v, err)
+	}
+
+	srv.APIVersions = [2]int{client.MinAPIVersion, client.MaxAPIVersion}
+	if _, err := srv.Client().ListPreviews(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.StreamingDrush || !caps.SupportsCompressor("gzip") || caps.MaxChunkSize != client.DefaultChunkSize {
+		t.Fatalf("unexpected legacy capabilities %+v", caps)
+	}
+
+	srv.Capabilities = &client.Capabilities{Version: "1.8.0", Compressors: []string{"gzip", "zstd"}, StreamingDrush: true}
+	caps, err = c.GetCapabilities(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(caps, srv.Capabilities) {
+		t.Fatalf("got %+v, want %+v", caps, srv.Capabilities)
+	}
+}
+
+func TestHTTPErrorEnvelope(t *testing.T) {
+	responses := map[string]func(w http.ResponseWriter){
+		"/api/auth/me": func(w http.ResponseWriter) {
+			w.Header().Set("X-Request-ID", "header-id")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"detail": "Not allowed", "code": "forbidden", "message": "Not allowed", "hint": "Ask an admin", "request_id": "abc123"}`)
+		},
+		"/api/auth/orgs": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"detail": "Preview is not running"}`)
+		},
+		"/api/info": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<html><body>nginx</body></html>")
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[r.URL.Path](w)
+	}))
+	defer srv.Close()
+	c := client.New(srv.URL, "token")
+	ctx := context.Background()
+
+	var httpErr *client.HTTPError
+	_, err := c.CurrentUser(ctx)
+	if !errors.As(err, &httpErr) || httpErr.Code != "forbidden" || httpErr.Hint != "Ask an admin" || httpErr.RequestID != "abc123" {
+		t.Fatalf("envelope not parsed: %#v", err)
+	}
+	if err.Error() != "Not allowed (HTTP 403)" {
+		t.Fatalf("unexpected message %q", err)
+	}
+	if _, err := c.ListOrgs(ctx); err == nil || err.Error() != "Preview is not running (HTTP 400)" {
+		t.Fatalf("expected the detail of older servers as message, got %v", err)
+	}
+	if _, err := c.GetServerInfo(ctx); err == nil || err.Error() != "Bad Gateway (HTTP 502)" {
+		t.Fatalf("expected HTML pages to be summarized, got %v", err)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+
+	var httpErr *client.HTTPError
+	if _, err := c.GetServerInfo(context.Background()); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Fatalf("expected 404, got %v", err)
+	}
+
+	srv.Info = &client.ServerInfo{
+		CLIVersion:         "1.8.0",
+		UptimeSeconds:      3600,
+		PreviewsTotal:      3,
+		PreviewsByStatus:   map[string]int{"active": 2, "failed": 1},
+		DiskTotalBytes:     100 << 30,
+		DiskFreeBytes:      40 << 30,
+		RunningDeployments: 1,
+	}
+	info, err := c.GetServerInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, srv.Info) {
+		t.Fatalf("got %+v, want %+v", info, srv.Info)
+	}
+}
+
+func TestCLIAuthFlow(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := client.New(srv.URL, "")
+	ctx := context.Background()
+
+	if err := c.RequestCLIAuth(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := c.PollCLIAuth(ctx, "abc")
+	if err != nil || auth != nil {
+		t.Fatalf("expected pending, got %+v, %v", auth, err)
+	}
+
+	srv.Orgs = []client.Org{{ID: "acme", Name: "Acme"}, {ID: "globex", Name: "Globex"}}
+	srv.Approve("abc", clienttest.Token)
+	auth, err = c.PollCLIAuth(ctx, "abc")
+	if err != nil || auth == nil || auth.Token != clienttest.Token {
+		t.Fatalf("expected approved token, got %+v, %v", auth, err)
+	}
+	if len(auth.Orgs) != 2 || auth.Orgs[1].ID != "globex" {
+		t.Fatalf("unexpected orgs %+v", auth.Orgs)
+	}
+
+	c.Token = auth.Token
+	orgs, err := c.ListOrgs(ctx)
+	if err != nil || len(orgs) != 2 {
+		t.Fatalf("unexpected orgs %+v, %v", orgs, err)
+	}
+	user, err := c.CurrentUser(ctx)
+	if err != nil || user.Email != srv.User.Email {
+		t.Fatalf("unexpected user %+v, %v", user, err)
+	}
+}
+
+func TestGetTokenInfo(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+
+	if _, err := c.GetTokenInfo(ctx); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	expires := "2026-01-31T00:00:00"
+	srv.TokenInfo = &client.TokenInfo{Name: "CLI (abcd1234)", TokenPrefix: "abcd1234", ExpiresAt: &expires, Scopes: []string{"read", "write"}}
+	info, err := c.GetTokenInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "CLI (abcd1234)" || info.ExpiresAt == nil || *info.ExpiresAt != expires || len(info.Scopes) != 2 {
+		t.Fatalf("unexpected token info %+v", info)
+	}
+
+	c.Token = "wrong"
+	if _, err := c.GetTokenInfo(ctx); err == nil || errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+}
+
+func countRequests(srv *clienttest.Server, substr string) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// assertRequestJSON checks that the last request of method to path had the
+// JSON body want.
+func assertRequestJSON(t *testing.T, srv *clienttest.Server, method, path, want string) {
+	t.Helper()
+	req, ok := srv.LastRequest(method, path)
+	if !ok {
+		t.Fatalf("no %s %s request, requests: %v", method, path, srv.Requests())
+	}
+	var got, wantValue interface{}
+	if err := json.Unmarshal(req.Body, &got); err != nil {
+		t.Fatalf("%s %s: invalid JSON body %q: %v", method, path, req.Body, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantValue) {
+		t.Fatalf("%s %s sent %s, want %s", method, path, req.Body, want)
+	}
+}
+
+func TestNewTransportCACert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "1.8.0"}`))
+	}))
+	defer srv.Close()
+
+	// The system CAs don't trust the test server: the error says why
+	_, err := client.New(srv.URL, "").CLIVersion(context.Background())
+	var tlsErr *client.TLSError
+	if !errors.As(err, &tlsErr) || !strings.Contains(err.Error(), "unknown authority") {
+		t.Fatalf("expected an unknown authority TLS error, got %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	transport, err := client.NewTransport(client.TLSOptions{CACertFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(srv.URL, "")
+	c.HTTPClient.Transport = transport
+	version, err := c.CLIVersion(context.Background())
+	if err != nil || version != "1.8.0" {
+		t.Fatalf("got %q, %v", version, err)
+	}
+
+	if _, err := client.NewTransport(client.TLSOptions{CACertFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Fatal("expected an error for a missing CA file")
+	}
+}
+
+func TestGetCLIHelp(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := client.New(srv.URL, "")
+	ctx := context.Background()
+
+	if _, err := c.GetCLIHelp(ctx); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	srv.Help = &client.CLIHelp{
+		PreviewDomain:     "{preview}-{project}.previews.example.com",
+		PHPVersions:       []string{"8.2", "8.3"},
+		DefaultPHPVersion: "8.3",
+		Notes:             map[string]string{"push db": "Dumps are anonymized on upload."},
+	}
+	help, err := c.GetCLIHelp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if help.PreviewDomain != srv.Help.PreviewDomain || len(help.PHPVersions) != 2 || help.Notes["push db"] == "" {
+		t.Fatalf("unexpected help %+v", help)
+	}
+}
+
+func TestGetVersionInfo(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.LatestVersion = "1.9.0"
+	srv.RequiredVersion = "1.8.1"
+	srv.APIVersions = [2]int{client.MaxAPIVersion + 1, client.MaxAPIVersion + 1}
+
+	// Answered whatever API version the client speaks
+	info, err := srv.Client().GetVersionInfo(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := client.VersionInfo{Version: "1.9.0", Channel: "stable", RequiredVersion: "1.8.1", MinAPIVersion: client.MaxAPIVersion + 1, MaxAPIVersion: client.MaxAPIVersion + 1}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+
+	srv.BetaVersion = "2.0.0-beta.1"
+	info, err = srv.Client().GetVersionInfo(context.Background(), "beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "2.0.0-beta.1" || info.Channel != "beta" {
+		t.Fatalf("unexpected beta version %+v", *info)
+	}
+}
+
+func TestDialDB(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	ctx := context.Background()
+
+	if _, _, err := srv.Client().DialDB(ctx, "drupal-test", "mr-5"); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Fatalf("expected a not running error, got %v", err)
+	}
+
+	// An echo server stands for the database
+	srv.DB = func(conn io.ReadWriter) { io.Copy(conn, conn) }
+	conn, info, err := srv.Client().DialDB(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if info.Database != "drupal" || info.User != "drupal" || info.Engine != "mysql:8.0" {
+		t.Fatalf("unexpected database info %+v", info)
+	}
+	if _, err := conn.Write([]byte("\x00\x01binary")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 8)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "\x00\x01binary" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestXdebug(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+	ctx := context.Background()
+
+	info, err := srv.Client().SetXdebug(ctx, "drupal-test", "mr-5", client.XdebugRequest{Enabled: true, ClientHost: "10.0.0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRequestJSON(t, srv, "POST", "/api/previews/drupal-test/mr-5/xdebug", `{"enabled": true, "client_host": "10.0.0.5"}`)
+	if !info.Enabled || info.ClientHost != "10.0.0.5" {
+		t.Fatalf("unexpected Xdebug info %+v", info)
+	}
+	if _, err := srv.Client().SetXdebug(ctx, "drupal-test", "mr-5", client.XdebugRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Xdebug sends its init packet and waits for a command
+	srv.XdebugSession = func(conn io.ReadWriter) {
+		conn.Write([]byte("<init/>"))
+		cmd := make([]byte, 3)
+		io.ReadFull(conn, cmd)
+		conn.Write(append([]byte("ack "), cmd...))
+	}
+	relay, err := srv.Client().RelayXdebug(ctx, "drupal-test", "mr-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Xdebug("drupal-test", "mr-5"); !got.Enabled {
+		t.Fatal("expected Xdebug on while relayed")
+	}
+	session, err := relay.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 7)
+	if _, err := io.ReadFull(session, got); err != nil || string(got) != "<init/>" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	session.Write([]byte("run"))
+	rest, err := io.ReadAll(session)
+	if err != nil || string(rest) != "ack run" {
+		t.Fatalf("got %q, %v", rest, err)
+	}
+	session.Close()
+
+	relay.Close()
+	<-relay.Done()
+	for i := 0; srv.Xdebug("drupal-test", "mr-5").Enabled; i++ {
+		if i == 100 {
+			t.Fatal("expected Xdebug off once the relay is closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDirCachePrune(t *testing.T) {
+	cache := client.DirCache(filepath.Join(t.TempDir(), "cache"))
+	if err := cache.Prune(time.Hour); err != nil {
+		t.Fatalf("pruning a missing cache: %v", err)
+	}
+	cache.Set("used", `"1"`, []byte("{}"))
+	cache.Set("stale", `"2"`, []byte("{}"))
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"used", "stale"} {
+		os.Chtimes(filepath.Join(string(cache), key), old, old)
+	}
+	cache.Get("used")
+
+	if err := cache.Prune(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Get("used"); !ok {
+		t.Fatal("entry in use pruned")
+	}
+	if _, _, ok := cache.Get("stale"); ok {
+		t.Fatal("stale entry kept")
+	}
+}
@@ -0,0 +1,2218 @@
+// Package clienttest provides an in-memory fake of the preview server for
+// tests. It implements the previews, artifacts, base-files (including
+// chunked upload and database sync), project settings, IP allowlists,
+// custom domains, database anonymization, preview.yml plans, interactive
+// terminal, CLI auth, member management and GitLab pipeline endpoints
+// closely enough to exercise client.Client end to end.
+//
+// It keeps the state clients read back (previews, base files, uploads...)
+// but not the server's validation or messages: endpoints reporting on what
+// the server did, like Scale, Retarget or Verify, answer what the test
+// sets. Every request is recorded, so tests can check what a client sent
+// with LastRequest.
+//
+//	srv := clienttest.NewServer(t)
+//	srv.AddPreview(client.Preview{Project: "drupal-test", Name: "mr-5"})
+//	c := srv.Client()
+//	list, err := c.ListPreviews(ctx, false)
+package clienttest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/gorilla/websocket"
+)
+
+// Token is the bearer token accepted by servers created with NewServer.
+const Token = "test-token"
+
+// Server is a fake preview server backed by httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	// Token is the only bearer token accepted. Requests with any other
+	// token get a 401.
+	Token string
+
+	// RefreshToken is the only refresh token accepted by /api/auth/refresh,
+	// which answers with Token. Empty disables refreshing.
+	RefreshToken string
+
+	// User is returned by /api/auth/me.
+	User client.User
+
+	// TokenInfo is returned by /api/auth/token. Nil answers 404, like a
+	// session that isn't an API token.
+	TokenInfo *client.TokenInfo
+
+	// Orgs is returned by /api/auth/orgs and with approved CLI logins.
+	Orgs []client.Org
+
+	// Projects is returned by /api/projects.
+	Projects []client.Project
+
+	// LatestVersion is returned by /api/cli/version.
+	LatestVersion string
+
+	// BetaVersion is returned by /api/cli/version on the beta channel.
+	// Empty returns LatestVersion, as no beta is published.
+	BetaVersion string
+
+	// RequiredVersion is the oldest CLI allowed, returned by
+	// /api/cli/version.
+	RequiredVersion string
+
+	// APIVersions is the range of API versions the server speaks. Clients
+	// speaking none of them get a 426 API version mismatch. The zero value
+	// accepts every client.
+	APIVersions [2]int
+
+	// Capabilities is returned by /api/capabilities. Nil answers 404, like
+	// servers that predate the endpoint.
+	Capabilities *client.Capabilities
+
+	// Help is returned by /api/cli/help. Nil answers 404, like servers
+	// that predate the endpoint.
+	Help *client.CLIHelp
+
+	// Info is returned by /api/info. Nil answers 404, like servers that
+	// predate the endpoint.
+	Info *client.ServerInfo
+
+	// FailChunks makes the next N chunk uploads fail with HTTP 500. Set it
+	// before issuing requests.
+	FailChunks int
+
+	// ExpireTokenChunks makes the next N chunk uploads answer 401, as if
+	// the token expired; a RefreshToken gets a valid one again. Set it
+	// before issuing requests.
+	ExpireTokenChunks int
+
+	// RateLimit makes the next N requests fail with HTTP 429, asking to
+	// retry after RetryAfter (a Retry-After header value) if set. Set it
+	// before issuing requests.
+	RateLimit  int
+	RetryAfter string
+
+	// DBSync emulates a database sync from production: it logs progress
+	// with log and returns the sanitized dump, which becomes the base
+	// database. It runs to completion before the sync is started. Nil
+	// answers 400, as for projects without a production source.
+	DBSync func(slug string, log func(string)) ([]byte, error)
+
+	// Drush emulates an interactive drush session on the terminal
+	// websocket: it reads user input from stdin, writes PTY output to
+	// stdout and returns the exit code. Nil sessions exit with code 0.
+	Drush func(args string, stdin io.Reader, stdout io.Writer) int
+
+	// Shell emulates a bash session on the terminal websocket, like Drush.
+	// It gets the service of the container the shell runs in.
+	Shell func(service string, stdin io.Reader, stdout io.Writer) int
+
+	// Composer emulates a composer session on the terminal websocket, like
+	// Drush.
+	Composer func(args string, stdin io.Reader, stdout io.Writer) int
+
+	// TestSuites are the preview.yml test suites of every preview.
+	TestSuites []client.TestSuite
+
+	// Test emulates running a test suite on the terminal websocket, like
+	// Drush. It gets the suite name; suites not in TestSuites get an error
+	// frame instead.
+	Test func(suite string, stdin io.Reader, stdout io.Writer) int
+
+	// Deploy emulates re-running a deploy script on the terminal
+	// websocket, like Drush. It gets the phase.
+	Deploy func(phase string, stdin io.Reader, stdout io.Writer) int
+
+	// Services are the preview.yml services ("redis", "solr") enabled on
+	// every preview. Terminal sessions of other services get an error
+	// frame.
+	Services []string
+
+	// Redis emulates redis-cli on the terminal websocket, like Drush.
+	Redis func(args string, stdin io.Reader, stdout io.Writer) int
+
+	// Solr emulates the Solr helpers on the terminal websocket, like
+	// Drush. It gets the operation, reindex or query, and the query.
+	Solr func(operation, q string, stdin io.Reader, stdout io.Writer) int
+
+	// Logs emulates following a log on the terminal websocket: it gets the
+	// log (container, php or watchdog) and severity, writes the log to
+	// stdout and returns the exit code. Nil logs are empty.
+	Logs func(log, severity string, stdout io.Writer) int
+
+	// DB emulates the database of a preview on the db-tunnel websocket: it
+	// serves one tunneled connection. Nil answers that the database isn't
+	// running.
+	DB func(conn io.ReadWriter)
+
+	// XdebugSession emulates a debug session Xdebug opens once the xdebug
+	// websocket relays it: conn is the debugger end of it. Nil opens none.
+	XdebugSession func(conn io.ReadWriter)
+
+	// Validate answers preview.yml validations. Nil reports every
+	// preview.yml valid.
+	Validate func(project string, req client.ValidateRequest) *client.ValidationResult
+
+	// Anonymize answers database anonymizations of previews. Nil answers
+	// 400, as for previews whose preview.yml has no sanitize: block.
+	Anonymize func(project, name string, req client.AnonymizeRequest) *client.AnonymizeResult
+
+	// Plan answers plans of preview.yml changes. Nil plans no changes.
+	Plan func(project, name string, req client.PlanRequest) *client.Plan
+
+	// Scale answers changes of the resource limits of previews. Nil
+	// answers a success without output.
+	Scale func(project, name string, changes map[string]string) *client.ActionResult
+
+	// Verify answers integrity checks of the base files of a project.
+	// Nil reports the base database and files ok if uploaded, missing if
+	// not.
+	Verify func(slug string) []client.BaseFileCheck
+
+	// Retarget answers moves of previews to another branch or MR. Nil
+	// answers that the preview kept its name and branch.
+	Retarget func(project, name string, req client.RetargetRequest) *client.RetargetResult
+
+	spoolDir  string
+	mu        sync.Mutex
+	previews  []client.Preview
+	results   map[string]*client.ActionResult
+	downloads map[string][]byte
+	tables    map[string][]byte
+	artifacts map[string]map[string][]byte
+	sizes     map[string]client.PreviewSize
+	files     map[string]map[string][]byte
+	mtimes    map[string]map[string]int64
+	baseFiles map[string][]byte
+	history   map[string][]client.BaseFileUpload
+	versions  map[string][]byte
+	settings  map[string]map[string]interface{}
+	xdebug    map[string]client.XdebugInfo
+	mail      map[string][]client.MailMessage
+	uploads   map[string]map[int][]byte
+	sessions  map[string]*client.UploadSession
+	syncJobs  map[string]*client.SyncProgress
+	heavy     map[string]*client.HeavyFilesManifest
+	teamCodes map[string]client.TeamDefaults
+	approved  map[string]string
+	autoStop  map[string]client.AutoStopPolicy
+	templates map[string]map[string]string
+	members   []client.Member
+	invites   []client.Invitation
+	pipelines []fakePipeline
+	triggers  []fakeTrigger
+	allowlist []client.AllowlistEntry
+	domains   map[string][]client.PreviewDomain
+	jobLogs   map[string][]byte
+	requests  []Request
+	nextID    int
+}
+
+// Request is a request received by a Server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is the body of the request. Multipart uploads aren't kept.
+	Body []byte
+}
+
+// NewServer starts a fake server and closes it when the test ends.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{
+		Token:         Token,
+		User:          client.User{Email: "dev@example.com", Name: "Dev"},
+		LatestVersion: "1.0.0",
+		spoolDir:      tb.TempDir(),
+		results:       make(map[string]*client.ActionResult),
+		downloads:     make(map[string][]byte),
+		tables:        make(map[string][]byte),
+		artifacts:     make(map[string]map[string][]byte),
+		sizes:         make(map[string]client.PreviewSize),
+		files:         make(map[string]map[string][]byte),
+		mtimes:        make(map[string]map[string]int64),
+		baseFiles:     make(map[string][]byte),
+		history:       make(map[string][]client.BaseFileUpload),
+		versions:      make(map[string][]byte),
+		settings:      make(map[string]map[string]interface{}),
+		xdebug:        make(map[string]client.XdebugInfo),
+		mail:          make(map[string][]client.MailMessage),
+		uploads:       make(map[string]map[int][]byte),
+		sessions:      make(map[string]*client.UploadSession),
+		syncJobs:      make(map[string]*client.SyncProgress),
+		heavy:         make(map[string]*client.HeavyFilesManifest),
+		jobLogs:       make(map[string][]byte),
+		teamCodes:     make(map[string]client.TeamDefaults),
+		approved:      make(map[string]string),
+		autoStop:      map[string]client.AutoStopPolicy{"": {Minutes: 60}},
+		templates:     make(map[string]map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a client pointed at the server, authenticated with Token
+// and with retries fast enough for tests.
+func (s *Server) Client() *client.Client {
+	c := client.New(s.URL, s.Token)
+	c.SpoolDir = s.spoolDir
+	c.RetryWait = time.Millisecond
+	return c
+}
+
+// AddPreview registers a preview returned by the list endpoint.
+func (s *Server) AddPreview(p client.Preview) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previews = append(s.previews, p)
+}
+
+// SetActionResult sets the response for an action ("start", "drush"...) on
+// a preview. Actions without a result succeed with empty output.
+func (s *Server) SetActionResult(project, previewName, action string, result client.ActionResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[project+"/"+previewName+"/"+action] = &result
+}
+
+// SetDownload sets the content served by the db or files download endpoint.
+func (s *Server) SetDownload(project, previewName, kind string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloads[project+"/"+previewName+"/"+kind] = data
+}
+
+// SetTableDump sets the dump of a table of a preview's database, served
+// by the db download endpoint for the tables asked for.
+func (s *Server) SetTableDump(project, previewName, table string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[project+"/"+previewName+"/"+table] = data
+}
+
+// SetArtifact sets a file served by the artifacts endpoints at path inside
+// a preview's container.
+func (s *Server) SetArtifact(project, previewName, path string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := project + "/" + previewName
+	if s.artifacts[key] == nil {
+		s.artifacts[key] = make(map[string][]byte)
+	}
+	s.artifacts[key][path] = data
+}
+
+// SetSize sets the disk usage served for a preview by the size endpoint.
+// Previews without one have no components.
+func (s *Server) SetSize(project, previewName string, size client.PreviewSize) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes[project+"/"+previewName] = size
+}
+
+// SetPreviewFile sets a file of the public files directory of a preview,
+// as written by the files sync endpoint, with a zero modification time.
+func (s *Server) SetPreviewFile(project, previewName, path string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPreviewFile(project+"/"+previewName, path, data, 0)
+}
+
+func (s *Server) setPreviewFile(key, path string, data []byte, mtime int64) {
+	if s.files[key] == nil {
+		s.files[key] = make(map[string][]byte)
+		s.mtimes[key] = make(map[string]int64)
+	}
+	s.files[key][path] = data
+	s.mtimes[key][path] = mtime
+}
+
+// PreviewFiles returns the files of the public files directory of a
+// preview by path.
+func (s *Server) PreviewFiles(project, previewName string) map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string][]byte)
+	for p, data := range s.files[project+"/"+previewName] {
+		files[p] = data
+	}
+	return files
+}
+
+// BaseFile returns the last uploaded base file of kind for a project.
+func (s *Server) BaseFile(slug, kind string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.baseFiles[slug+"/"+kind]
+	return data, ok
+}
+
+// AddMember registers a user returned by the member endpoints, with the
+// next free ID if m.ID is 0.
+func (s *Server) AddMember(m client.Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.ID == 0 {
+		s.nextID++
+		m.ID = s.nextID
+	}
+	s.members = append(s.members, m)
+}
+
+// Invitations returns the pending invitations.
+func (s *Server) Invitations() []client.Invitation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]client.Invitation(nil), s.invites...)
+}
+
+// fakeTrigger is a trigger of previewName of project.
+type fakeTrigger struct {
+	project     string
+	previewName string
+	token       string
+	trigger     client.Trigger
+}
+
+// fakePipeline is a pipeline of a project, run for previewName.
+type fakePipeline struct {
+	project     string
+	previewName string
+	pipeline    client.Pipeline
+	jobs        []client.Job
+}
+
+// AddPipeline registers a pipeline of project with its jobs, run for the
+// merge request or branch of previewName. Pipelines are listed newest
+// first, in the reverse order they are added.
+func (s *Server) AddPipeline(project, previewName string, p client.Pipeline, jobs ...client.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pipelines = append([]fakePipeline{{project, previewName, p, jobs}}, s.pipelines...)
+}
+
+// SetJobLog sets the log of a job of project.
+func (s *Server) SetJobLog(project string, jobID int, log string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobLogs[fmt.Sprintf("%s/%d", project, jobID)] = []byte(log)
+}
+
+// Approve marks a CLI login code as approved, so polling returns token.
+func (s *Server) Approve(code, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approved[code] = token
+}
+
+// Requests returns every request received so far as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]string, len(s.requests))
+	for i, r := range s.requests {
+		requests[i] = r.Method + " " + r.Path
+	}
+	return requests
+}
+
+// LastRequest returns the last request received with method and path, to
+// check what a client sent, and false if there was none.
+func (s *Server) LastRequest(method, path string) (Request, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.requests) - 1; i >= 0; i-- {
+		if r := s.requests[i]; r.Method == method && r.Path == path {
+			return r, true
+		}
+	}
+	return Request{}, false
+}
+
+// PendingUploads returns the number of chunked uploads started but
+// neither completed nor aborted.
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	req := Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone()}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		req.Body, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(req.Body))
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	limited := s.RateLimit > 0
+	if limited {
+		s.RateLimit--
+	}
+	s.mu.Unlock()
+
+	if limited {
+		if s.RetryAfter != "" {
+			w.Header().Set("Retry-After", s.RetryAfter)
+		}
+		http.Error(w, `{"detail": "Too many requests", "code": "rate_limited"}`, http.StatusTooManyRequests)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/ws/") && strings.HasSuffix(r.URL.Path, "/terminal") {
+		s.handleTerminal(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/ws/") && strings.HasSuffix(r.URL.Path, "/db-tunnel") {
+		s.handleDBTunnel(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/ws/") && strings.HasSuffix(r.URL.Path, "/xdebug") {
+		s.handleXdebugRelay(w, r)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	parts := strings.Split(path, "/")
+
+	// Like the real server, CLI distribution and capabilities stay
+	// reachable so a mismatched CLI can self-update.
+	exempt := strings.HasPrefix(path, "cli/") || path == "capabilities"
+	if s.APIVersions != [2]int{} && !exempt && !s.speaks(r.Header.Get("X-Preview-API-Version")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		writeJSON(w, map[string]interface{}{
+			"detail":      "API version not supported",
+			"code":        "api_version_mismatch",
+			"min_version": s.APIVersions[0],
+			"max_version": s.APIVersions[1],
+			"cli_version": s.LatestVersion,
+		})
+		return
+	}
+
+	// Unauthenticated endpoints
+	switch {
+	case path == "cli/version":
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			channel = "stable"
+		}
+		version := s.LatestVersion
+		if channel == "beta" && s.BetaVersion != "" {
+			version = s.BetaVersion
+		}
+		writeJSON(w, client.VersionInfo{
+			Version:         version,
+			Channel:         channel,
+			RequiredVersion: s.RequiredVersion,
+			MinAPIVersion:   s.APIVersions[0],
+			MaxAPIVersion:   s.APIVersions[1],
+		})
+		return
+	case parts[0] == "triggers" && len(parts) == 2:
+		s.handleRunTrigger(w, parts[1])
+		return
+	case strings.HasPrefix(path, "cli/team/"):
+		s.mu.Lock()
+		defaults, ok := s.teamCodes[parts[len(parts)-1]]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"detail": "Unknown team code"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, defaults)
+		return
+	case path == "cli/help":
+		if s.Help == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, s.Help)
+		return
+	case path == "capabilities":
+		if s.Capabilities == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, s.Capabilities)
+		return
+	case path == "auth/cli/request" && r.Method == "POST":
+		writeJSON(w, map[string]string{"status": "pending"})
+		return
+	case strings.HasPrefix(path, "auth/cli/poll/"):
+		s.handlePoll(w, parts[len(parts)-1])
+		return
+	case path == "auth/refresh" && r.Method == "POST":
+		s.handleRefresh(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		http.Error(w, `{"detail": "Not authenticated"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case path == "auth/me":
+		writeJSON(w, s.User)
+	case path == "auth/token":
+		if s.TokenInfo == nil {
+			http.Error(w, `{"detail": "Not authenticated with an API token"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s.TokenInfo)
+	case path == "auth/orgs":
+		writeJSON(w, map[string][]client.Org{"orgs": s.Orgs})
+	case path == "uploads" && r.Method == "GET":
+		s.handleListUploads(w)
+	case parts[0] == "uploads" && len(parts) == 2 && r.Method == "DELETE":
+		s.handleAbortUpload(w, parts[1])
+	case path == "projects" && r.Method == "GET":
+		writeJSON(w, map[string][]client.Project{"projects": s.Projects})
+	case path == "info" && s.Info != nil:
+		writeJSON(w, s.Info)
+	case path == "previews" && r.Method == "GET":
+		s.handleList(w, r)
+	case parts[0] == "previews" && len(parts) >= 4 && parts[3] == "artifacts" && r.Method == "GET":
+		s.handleArtifacts(w, r, parts[1], parts[2], parts[4:])
+	case parts[0] == "previews" && len(parts) == 5 && parts[3] == "db" && parts[4] == "anonymize" && r.Method == "POST":
+		var req client.AnonymizeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if s.Anonymize == nil {
+			http.Error(w, `{"detail": "The preview.yml of the preview has no valid sanitize: block"}`, http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, s.Anonymize(parts[1], parts[2], req))
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "plan" && r.Method == "POST":
+		var req client.PlanRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if s.Plan == nil {
+			writeJSON(w, &client.Plan{Preview: parts[2], Changes: []client.PlanChange{}})
+			return
+		}
+		writeJSON(w, s.Plan(parts[1], parts[2], req))
+	case parts[0] == "previews" && len(parts) == 5 && parts[4] == "download":
+		s.handleDownload(w, r, parts[1], parts[2], parts[3])
+	case parts[0] == "previews" && len(parts) == 5 && parts[3] == "files" && parts[4] == "sync" && r.Method == "POST":
+		s.handleFilesSync(w, r, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 5 && parts[3] == "files" && parts[4] == "manifest" && r.Method == "GET":
+		s.handleFilesManifest(w, r, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "size" && r.Method == "GET":
+		s.handleSize(w, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "tests" && r.Method == "GET":
+		s.handleTestSuites(w, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "scale" && r.Method == "POST":
+		s.handleScale(w, r, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) >= 4 && len(parts) <= 5 && parts[3] == "mail" && r.Method == "GET":
+		s.handleMail(w, r, parts[1], parts[2], parts[4:])
+	case parts[0] == "previews" && len(parts) >= 4 && len(parts) <= 5 && parts[3] == "domains":
+		s.handleDomains(w, r, parts[1], parts[2], parts[4:])
+	case parts[0] == "previews" && len(parts) >= 4 && len(parts) <= 5 && parts[3] == "triggers":
+		s.handleTriggers(w, r, parts[1], parts[2], parts[4:])
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "xdebug" && r.Method == "POST":
+		s.handleXdebug(w, r, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 4 && parts[3] == "retarget" && r.Method == "POST":
+		s.handleRetarget(w, r, parts[1], parts[2])
+	case parts[0] == "previews" && len(parts) == 4 && r.Method == "POST":
+		s.handleAction(w, parts[1], parts[2], parts[3])
+	case parts[0] == "auth" && len(parts) >= 2 && (parts[1] == "users" || parts[1] == "invitations"):
+		s.handleMembers(w, r, parts[1:])
+	case parts[0] == "auth" && len(parts) >= 4 && parts[1] == "projects" && parts[3] == "members":
+		s.handleProjectMembers(w, r, parts[2], parts[4:])
+	case parts[0] == "gitlab" && len(parts) >= 5 && parts[1] == "projects" && parts[2] == "by-slug" && r.Method == "GET":
+		s.handlePipelines(w, r, parts[3], parts[4:])
+	case path == "config/team-codes" && r.Method == "POST":
+		var defaults client.TeamDefaults
+		json.NewDecoder(r.Body).Decode(&defaults)
+		s.mu.Lock()
+		s.nextID++
+		code := fmt.Sprintf("team%d", s.nextID)
+		s.teamCodes[code] = defaults
+		s.mu.Unlock()
+		writeJSON(w, map[string]string{"code": code})
+	case parts[0] == "config" && len(parts) >= 2 && len(parts) <= 3 && parts[1] == "auto-stop":
+		project := ""
+		if len(parts) == 3 {
+			project = parts[2]
+		}
+		s.handleAutoStop(w, r, project)
+	case path == "config/templates" && r.Method == "GET":
+		s.mu.Lock()
+		sets := []client.TemplateSetInfo{}
+		for name, files := range s.templates {
+			info := client.TemplateSetInfo{Name: name}
+			for path := range files {
+				info.Files = append(info.Files, path)
+			}
+			sort.Strings(info.Files)
+			sets = append(sets, info)
+		}
+		s.mu.Unlock()
+		sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+		writeJSON(w, map[string]interface{}{"templates": sets})
+	case parts[0] == "config" && len(parts) == 3 && parts[1] == "templates":
+		s.handleTemplateSet(w, r, parts[2])
+	case parts[0] == "config" && len(parts) == 3 && parts[1] == "project-settings":
+		s.handleProjectSettings(w, r, parts[2])
+	case parts[0] == "projects" && len(parts) == 3 && parts[2] == "validate" && r.Method == "POST":
+		var req client.ValidateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		result := &client.ValidationResult{Valid: true, Ref: req.Ref, Preview: req.Preview,
+			Checks: []client.ValidationCheck{{Name: "syntax", Status: "ok", Message: "preview.yml parses"}}}
+		if s.Validate != nil {
+			result = s.Validate(parts[1], req)
+		}
+		writeJSON(w, result)
+	case parts[0] == "projects" && len(parts) >= 3 && parts[2] == "base-files":
+		s.handleBaseFiles(w, r, parts[1], parts[3:])
+	case parts[0] == "projects" && len(parts) >= 3 && len(parts) <= 4 && parts[2] == "allowlist":
+		s.handleAllowlist(w, r, parts[1], parts[3:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// speaks reports whether the "MIN-MAX" API version range of a client
+// overlaps APIVersions.
+func (s *Server) speaks(header string) bool {
+	var low, high int
+	if _, err := fmt.Sscanf(header, "%d-%d", &low, &high); err != nil {
+		return false
+	}
+	return high >= s.APIVersions[0] && low <= s.APIVersions[1]
+}
+
+func (s *Server) handlePoll(w http.ResponseWriter, code string) {
+	s.mu.Lock()
+	token, ok := s.approved[code]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, map[string]string{"status": "pending"})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "approved", "token": token, "orgs": s.Orgs})
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if s.RefreshToken == "" || body.RefreshToken != s.RefreshToken {
+		http.Error(w, `{"detail": "Invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, client.CLIAuth{Token: s.Token, RefreshToken: s.RefreshToken})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	s.mu.Lock()
+	previews := []client.Preview{}
+	for _, p := range s.previews {
+		if project := query.Get("project"); project == "" || p.Project == project {
+			previews = append(previews, p)
+		}
+	}
+	s.mu.Unlock()
+	if query.Get("sizes") != "true" {
+		for i := range previews {
+			previews[i].SizeBytes = nil
+		}
+	}
+
+	total := len(previews)
+	previews = previews[min(offset, total):]
+	if limit > 0 && len(previews) > limit {
+		previews = previews[:limit]
+	}
+
+	body, _ := json.Marshal(client.PreviewListResult{Previews: previews, Total: total})
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Compressed like the server does with large JSON responses
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(body)
+	gz.Close()
+}
+
+func (s *Server) findPreview(project, name string) *client.Preview {
+	for i, p := range s.previews {
+		if p.Project == project && p.Name == name {
+			return &s.previews[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleAction(w http.ResponseWriter, project, name, action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	result := client.ActionResult{Success: true}
+	if stored, ok := s.results[project+"/"+name+"/"+action]; ok {
+		result = *stored
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleScale(w http.ResponseWriter, r *http.Request, project, name string) {
+	s.mu.Lock()
+	preview := s.findPreview(project, name)
+	s.mu.Unlock()
+	if preview == nil {
+		writeDetail(w, http.StatusNotFound, "Preview not found")
+		return
+	}
+	var changes map[string]string
+	json.NewDecoder(r.Body).Decode(&changes)
+	result := &client.ActionResult{Success: true}
+	if s.Scale != nil {
+		result = s.Scale(project, name, changes)
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleRetarget(w http.ResponseWriter, r *http.Request, project, name string) {
+	s.mu.Lock()
+	preview := s.findPreview(project, name)
+	var result *client.RetargetResult
+	if preview != nil {
+		result = &client.RetargetResult{PreviewName: name, Branch: preview.Branch, CommitSHA: preview.CommitSHA, URL: preview.URL}
+	}
+	s.mu.Unlock()
+	if preview == nil {
+		writeDetail(w, http.StatusNotFound, "Preview not found")
+		return
+	}
+	var req client.RetargetRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if s.Retarget != nil {
+		result = s.Retarget(project, name, req)
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, project, name, kind string) {
+	s.mu.Lock()
+	if tables := r.URL.Query().Get("tables"); kind == "db" && tables != "" {
+		defer s.mu.Unlock()
+		var dump []byte
+		var missing []string
+		for _, table := range strings.Split(tables, ",") {
+			data, ok := s.tables[project+"/"+name+"/"+table]
+			if !ok {
+				missing = append(missing, table)
+			}
+			dump = append(dump, data...)
+		}
+		if len(missing) > 0 {
+			writeDetail(w, http.StatusNotFound, "Tables not found: "+strings.Join(missing, ", "))
+			return
+		}
+		w.Write(dump)
+		return
+	}
+	data, ok := s.downloads[project+"/"+name+"/"+kind]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"detail": "Not found"}`, http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func (s *Server) handleFilesSync(w http.ResponseWriter, r *http.Request, project, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	var deletes []string
+	if err := json.Unmarshal([]byte(r.FormValue("delete")), &deletes); err != nil {
+		http.Error(w, `{"detail": "delete must be a JSON list of paths"}`, http.StatusBadRequest)
+		return
+	}
+	key := project + "/" + name
+
+	result := client.FilesSyncResult{}
+	for _, d := range deletes {
+		for p := range s.files[key] {
+			if p == d || strings.HasPrefix(p, d+"/") {
+				delete(s.files[key], p)
+				delete(s.mtimes[key], p)
+				result.Deleted++
+			}
+		}
+	}
+	if f, _, err := r.FormFile("file"); err == nil {
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			http.Error(w, `{"detail": "Invalid archive"}`, http.StatusBadRequest)
+			return
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, `{"detail": "Invalid archive"}`, http.StatusBadRequest)
+				return
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			data, _ := io.ReadAll(tr)
+			s.setPreviewFile(key, path.Clean(hdr.Name), data, hdr.ModTime.Unix())
+			result.Written++
+		}
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleFilesManifest(w http.ResponseWriter, r *http.Request, project, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	key := project + "/" + name
+	files := []client.PreviewFile{}
+	for p, data := range s.files[key] {
+		for _, prefix := range r.URL.Query()["path"] {
+			prefix = path.Clean(prefix)
+			if prefix == "." || p == prefix || strings.HasPrefix(p, prefix+"/") {
+				files = append(files, client.PreviewFile{Path: p, SizeBytes: int64(len(data)), ModTime: s.mtimes[key][p]})
+				break
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	writeJSON(w, map[string]interface{}{"files": files})
+}
+
+func (s *Server) handleSize(w http.ResponseWriter, project, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	size, ok := s.sizes[project+"/"+name]
+	if !ok {
+		size = client.PreviewSize{Components: []client.SizeComponent{}}
+	}
+	size.Project, size.Preview = project, name
+	writeJSON(w, size)
+}
+
+func (s *Server) handleArtifacts(w http.ResponseWriter, r *http.Request, project, name string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	files := s.artifacts[project+"/"+name]
+
+	switch {
+	case len(rest) == 0:
+		pattern := r.URL.Query().Get("pattern")
+		artifacts := []client.Artifact{}
+		for p, data := range files {
+			if ok, _ := path.Match(pattern, p); ok {
+				artifacts = append(artifacts, client.Artifact{Path: p, SizeBytes: int64(len(data))})
+			}
+		}
+		sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Path < artifacts[j].Path })
+		writeJSON(w, map[string][]client.Artifact{"artifacts": artifacts})
+	case len(rest) == 1 && rest[0] == "download":
+		data, ok := files[r.URL.Query().Get("path")]
+		if !ok {
+			http.Error(w, `{"detail": "File not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleTestSuites(w http.ResponseWriter, project, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	suites := append([]client.TestSuite{}, s.TestSuites...)
+	writeJSON(w, map[string][]client.TestSuite{"suites": suites})
+}
+
+// handleMembers serves /api/auth/users and /api/auth/invitations. Unlike the
+// real server it doesn't check roles or send emails.
+func (s *Server) handleMembers(w http.ResponseWriter, r *http.Request, parts []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case parts[0] == "users" && len(parts) == 1:
+		writeJSON(w, map[string][]client.Member{"users": s.members})
+	case parts[0] == "invitations" && len(parts) == 1 && r.Method == "GET":
+		writeJSON(w, map[string][]client.Invitation{"invitations": s.invites})
+	case parts[0] == "invitations" && len(parts) == 1 && r.Method == "POST":
+		var inv client.Invitation
+		json.NewDecoder(r.Body).Decode(&inv)
+		for _, m := range s.members {
+			if m.Email == inv.Email {
+				http.Error(w, `{"detail": "A user with this email already exists"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		s.nextID++
+		inv.ID = s.nextID
+		s.invites = append(s.invites, inv)
+		writeJSON(w, inv)
+	case parts[0] == "invitations" && len(parts) == 2 && r.Method == "DELETE":
+		id, _ := strconv.Atoi(parts[1])
+		for i, inv := range s.invites {
+			if inv.ID == id {
+				s.invites = append(s.invites[:i], s.invites[i+1:]...)
+				break
+			}
+		}
+		writeJSON(w, map[string]bool{"success": true})
+	case parts[0] == "users" && (len(parts) == 2 && r.Method == "DELETE" || len(parts) == 3 && parts[2] == "role" && r.Method == "PUT"):
+		id, _ := strconv.Atoi(parts[1])
+		i := s.findMember(id)
+		if i < 0 {
+			http.Error(w, `{"detail": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == "DELETE" {
+			s.members = append(s.members[:i], s.members[i+1:]...)
+		} else {
+			var body struct {
+				Role string `json:"role"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			s.members[i].Role = &body.Role
+		}
+		writeJSON(w, map[string]bool{"success": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleProjectMembers serves /api/auth/projects/{project}/members.
+func (s *Server) handleProjectMembers(w http.ResponseWriter, r *http.Request, project string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id int
+	switch {
+	case len(rest) == 0 && r.Method == "GET":
+		result := client.ProjectMembers{Members: []client.Member{}, Invitations: []client.Invitation{}}
+		for _, m := range s.members {
+			for _, p := range m.Projects {
+				if p == project {
+					m.Projects = nil
+					result.Members = append(result.Members, m)
+				}
+			}
+		}
+		for _, inv := range s.invites {
+			if inv.ProjectSlug != nil && *inv.ProjectSlug == project {
+				result.Invitations = append(result.Invitations, inv)
+			}
+		}
+		writeJSON(w, result)
+		return
+	case len(rest) == 0 && r.Method == "POST":
+		var body struct {
+			UserID int `json:"user_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		id = body.UserID
+	case len(rest) == 1 && r.Method == "DELETE":
+		id, _ = strconv.Atoi(rest[0])
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	i := s.findMember(id)
+	if i < 0 {
+		http.Error(w, `{"detail": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	var projects []string
+	for _, p := range s.members[i].Projects {
+		if p != project {
+			projects = append(projects, p)
+		}
+	}
+	if r.Method == "POST" {
+		projects = append(projects, project)
+	}
+	s.members[i].Projects = projects
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// findMember returns the index of the member with id, or -1.
+func (s *Server) findMember(id int) int {
+	for i, m := range s.members {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handlePipelines(w http.ResponseWriter, r *http.Request, project string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(rest) == 1 && rest[0] == "pipelines":
+		preview := r.URL.Query().Get("preview")
+		pipelines := []client.Pipeline{}
+		for _, p := range s.pipelines {
+			if p.project == project && (preview == "" || p.previewName == preview) {
+				pipelines = append(pipelines, p.pipeline)
+			}
+		}
+		writeJSON(w, map[string][]client.Pipeline{"pipelines": pipelines})
+	case len(rest) == 3 && rest[0] == "pipelines" && rest[2] == "jobs":
+		id, _ := strconv.Atoi(rest[1])
+		for _, p := range s.pipelines {
+			if p.project == project && p.pipeline.ID == id {
+				jobs := append([]client.Job{}, p.jobs...)
+				writeJSON(w, map[string][]client.Job{"jobs": jobs})
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf(`{"detail": "Pipeline %d not found"}`, id), http.StatusNotFound)
+	case len(rest) == 3 && rest[0] == "jobs" && rest[2] == "trace":
+		log, ok := s.jobLogs[project+"/"+rest[1]]
+		if !ok {
+			http.Error(w, fmt.Sprintf(`{"detail": "Job %s not found"}`, rest[1]), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(log)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleProjectSettings stores settings as given; unlike the real server it
+// accepts any setting and doesn't parse values.
+func (s *Server) handleProjectSettings(w http.ResponseWriter, r *http.Request, project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := s.settings[project]
+	if settings == nil {
+		settings = make(map[string]interface{})
+		s.settings[project] = settings
+	}
+	if r.Method == "PATCH" {
+		var body struct {
+			Settings map[string]string `json:"settings"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for k, v := range body.Settings {
+			if v == "" {
+				settings[k] = nil
+			} else {
+				settings[k] = v
+			}
+		}
+	}
+	writeJSON(w, client.ProjectSettings{Settings: settings, Descriptions: map[string]string{}})
+}
+
+// handleAutoStop serves the auto-stop policy of project, the global one
+// if project is empty. Project policies without an override answer
+// enabled and minutes null, like the server.
+func (s *Server) handleAutoStop(w http.ResponseWriter, r *http.Request, project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.autoStop[project]
+	if r.Method == "PUT" {
+		var body struct {
+			Override *bool `json:"override"`
+			Enabled  bool  `json:"enabled"`
+			Minutes  *int  `json:"minutes"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if project != "" && body.Override != nil && !*body.Override {
+			delete(s.autoStop, project)
+		} else {
+			policy.Override = project != ""
+			policy.Enabled = body.Enabled
+			if body.Minutes != nil {
+				policy.Minutes = *body.Minutes
+			}
+			s.autoStop[project] = policy
+		}
+		writeJSON(w, map[string]bool{"success": true})
+		return
+	}
+	if project == "" {
+		writeJSON(w, map[string]interface{}{"enabled": policy.Enabled, "minutes": policy.Minutes})
+		return
+	}
+	if !ok {
+		writeJSON(w, map[string]interface{}{"override": false, "enabled": nil, "minutes": nil})
+		return
+	}
+	writeJSON(w, policy)
+}
+
+// handleTemplateSet serves the scaffold template set name.
+func (s *Server) handleTemplateSet(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, ok := s.templates[name]
+	switch r.Method {
+	case "PUT":
+		var body struct {
+			Files map[string]string `json:"files"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Files) == 0 {
+			http.Error(w, `{"detail": "files must map file paths to their contents"}`, http.StatusBadRequest)
+			return
+		}
+		s.templates[name] = body.Files
+		writeJSON(w, map[string]bool{"success": true})
+	case "DELETE", "GET":
+		if !ok {
+			http.Error(w, `{"detail": "Template set not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(s.templates, name)
+			writeJSON(w, map[string]bool{"success": true})
+			return
+		}
+		writeJSON(w, client.TemplateSet{Name: name, Files: files})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleBaseFiles(w http.ResponseWriter, r *http.Request, slug string, rest []string) {
+	if len(rest) == 1 && rest[0] == "history" && r.Method == "GET" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		uploads := make([]client.BaseFileUpload, 0, len(s.history[slug]))
+		seen := map[string]bool{}
+		for i := len(s.history[slug]) - 1; i >= 0; i-- {
+			u := s.history[slug][i]
+			u.Current = !seen[u.Kind]
+			seen[u.Kind] = true
+			_, kept := s.versions[slug+"/"+u.Kind+"/"+u.SHA256]
+			u.Restorable = !u.Current && kept
+			uploads = append(uploads, u)
+		}
+		writeJSON(w, map[string][]client.BaseFileUpload{"uploads": uploads})
+		return
+	}
+	if len(rest) == 1 && rest[0] == "verify" && r.Method == "POST" {
+		s.handleVerify(w, slug)
+		return
+	}
+	if len(rest) == 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		status := client.BaseFilesStatus{DB: &client.BaseFileInfo{}, Files: &client.BaseFileInfo{}}
+		for kind, info := range map[string]*client.BaseFileInfo{"db": status.DB, "files": status.Files} {
+			if data, ok := s.baseFiles[slug+"/"+kind]; ok {
+				info.Exists = true
+				info.SizeBytes = int64(len(data))
+			}
+		}
+		for key, data := range s.baseFiles {
+			name, ok := strings.CutPrefix(key, slug+"/db-")
+			if !ok || name == "encrypted" {
+				continue
+			}
+			if status.Databases == nil {
+				status.Databases = map[string]*client.BaseFileInfo{}
+			}
+			status.Databases[name] = &client.BaseFileInfo{Exists: true, SizeBytes: int64(len(data))}
+		}
+		writeJSON(w, status)
+		return
+	}
+
+	kind := rest[0]
+	switch {
+	case len(rest) == 1 && r.Method == "GET":
+		s.mu.Lock()
+		data, ok := s.baseFiles[slug+"/"+kind]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"detail": "Base file not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case len(rest) == 1 && r.Method == "POST":
+		data, err := readFormFile(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.storeBaseFile(slug, kind, data)
+		s.mu.Unlock()
+		writeJSON(w, map[string]bool{"success": true})
+	case len(rest) == 3 && rest[1] == "upload":
+		s.handleChunked(w, r, slug, kind, rest[2])
+	case len(rest) == 2 && rest[1] == "restore" && r.Method == "POST":
+		s.handleRestore(w, r, slug, kind)
+	case kind == "files" && len(rest) == 2 && rest[1] == "heavy-manifest":
+		s.handleHeavyManifest(w, r, slug)
+	case kind == "db" && len(rest) >= 2 && rest[1] == "sync":
+		s.handleDBSync(w, r, slug, rest[2:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, slug string) {
+	if s.Verify != nil {
+		writeJSON(w, map[string][]client.BaseFileCheck{"checks": s.Verify(slug)})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var checks []client.BaseFileCheck
+	for _, kind := range []string{"db", "files"} {
+		check := client.BaseFileCheck{Kind: kind, Status: "missing"}
+		if data, ok := s.baseFiles[slug+"/"+kind]; ok {
+			sum := sha256.Sum256(data)
+			check.Status, check.SizeBytes, check.SHA256 = "ok", int64(len(data)), hex.EncodeToString(sum[:])
+		}
+		checks = append(checks, check)
+	}
+	writeJSON(w, map[string][]client.BaseFileCheck{"checks": checks})
+}
+
+func (s *Server) handleHeavyManifest(w http.ResponseWriter, r *http.Request, slug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == "PUT" {
+		var manifest client.HeavyFilesManifest
+		if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifest.RecordedAt = time.Now().UTC().Format(time.RFC3339)
+		s.heavy[slug] = &manifest
+		writeJSON(w, manifest)
+		return
+	}
+	manifest, ok := s.heavy[slug]
+	if !ok {
+		http.Error(w, `{"detail": "No heavy files manifest"}`, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, manifest)
+}
+
+func (s *Server) handleDBSync(w http.ResponseWriter, r *http.Request, slug string, rest []string) {
+	if len(rest) == 0 && r.Method == "POST" {
+		if s.DBSync == nil {
+			http.Error(w, `{"detail": "No production database source configured"}`, http.StatusBadRequest)
+			return
+		}
+		job := &client.SyncProgress{Status: "success"}
+		dump, err := s.DBSync(slug, func(line string) { job.Lines = append(job.Lines, line) })
+		if err != nil {
+			job.Lines = append(job.Lines, "Error: "+err.Error())
+			job.Status = "failed"
+		}
+		job.Complete = true
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err == nil {
+			s.storeBaseFile(slug, "db", dump)
+		}
+		s.nextID++
+		id := fmt.Sprintf("sync-%d", s.nextID)
+		s.syncJobs[slug+"/"+id] = job
+		writeJSON(w, map[string]string{"job_id": id})
+		return
+	}
+	if len(rest) != 1 || r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.syncJobs[slug+"/"+rest[0]]
+	if !ok {
+		http.Error(w, `{"detail": "Sync job not found"}`, http.StatusNotFound)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset > len(job.Lines) {
+		offset = len(job.Lines)
+	}
+	writeJSON(w, client.SyncProgress{
+		Lines:    job.Lines[offset:],
+		Offset:   len(job.Lines),
+		Complete: job.Complete,
+		Status:   job.Status,
+	})
+}
+
+// handleRestore puts back a prior version of a base file, matching the
+// version like the real server but keeping every prior version.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request, slug, kind string) {
+	var body struct {
+		Version string `json:"version"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current string
+	matches := map[string]client.BaseFileUpload{}
+	for _, u := range s.history[slug] {
+		if u.Kind != kind {
+			continue
+		}
+		current = u.SHA256
+		if body.Version != "" && (strings.HasPrefix(u.UploadedAt, body.Version) || strings.HasPrefix(u.SHA256, body.Version)) {
+			matches[u.SHA256] = u
+		}
+	}
+	if len(matches) > 1 {
+		delete(matches, current)
+	}
+	if len(matches) != 1 {
+		http.Error(w, `{"detail": "No single upload matches the version"}`, http.StatusNotFound)
+		return
+	}
+	var restored client.BaseFileUpload
+	for _, u := range matches {
+		restored = u
+	}
+	data, ok := s.versions[slug+"/"+kind+"/"+restored.SHA256]
+	if restored.SHA256 == current || !ok {
+		http.Error(w, `{"detail": "Version in use or no longer kept"}`, http.StatusConflict)
+		return
+	}
+	delete(s.versions, slug+"/"+kind+"/"+restored.SHA256)
+	s.storeBaseFile(slug, kind, data)
+	entry := &s.history[slug][len(s.history[slug])-1]
+	entry.RestoredFrom = restored.UploadedAt
+	result := *entry
+	result.Current = true
+	writeJSON(w, result)
+}
+
+// storeBaseFile saves an uploaded base file, keeping the one it replaces
+// as a prior version, and records it in the history. s.mu must be held.
+func (s *Server) storeBaseFile(slug, kind string, data []byte) {
+	if old, ok := s.baseFiles[slug+"/"+kind]; ok {
+		oldSum := sha256.Sum256(old)
+		s.versions[slug+"/"+kind+"/"+hex.EncodeToString(oldSum[:])] = old
+	}
+	s.baseFiles[slug+"/"+kind] = data
+	if kind == "files" {
+		// The manifest described the previous archive.
+		delete(s.heavy, slug)
+	}
+	sum := sha256.Sum256(data)
+	s.history[slug] = append(s.history[slug], client.BaseFileUpload{
+		Kind:       kind,
+		UploadedAt: time.Now().UTC().Format(time.RFC3339),
+		UploadedBy: s.User.Email,
+		SizeBytes:  int64(len(data)),
+		SHA256:     hex.EncodeToString(sum[:]),
+	})
+}
+
+func (s *Server) handleChunked(w http.ResponseWriter, r *http.Request, slug, kind, step string) {
+	switch step {
+	case "init":
+		var body struct {
+			TotalChunks int    `json:"total_chunks"`
+			Filename    string `json:"filename"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = make(map[int][]byte)
+		now := time.Now().UTC().Format(time.RFC3339)
+		s.sessions[id] = &client.UploadSession{
+			UploadID:    id,
+			Project:     slug,
+			Kind:        kind,
+			Filename:    body.Filename,
+			StartedBy:   s.User.Email,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			TotalChunks: body.TotalChunks,
+			State:       "active",
+		}
+		s.mu.Unlock()
+		writeJSON(w, map[string]string{"upload_id": id})
+
+	case "chunk":
+		s.mu.Lock()
+		if s.FailChunks > 0 {
+			s.FailChunks--
+			s.mu.Unlock()
+			http.Error(w, `{"detail": "simulated failure"}`, http.StatusInternalServerError)
+			return
+		}
+		if s.ExpireTokenChunks > 0 {
+			s.ExpireTokenChunks--
+			s.mu.Unlock()
+			http.Error(w, `{"detail": "Token expired"}`, http.StatusUnauthorized)
+			return
+		}
+		s.mu.Unlock()
+
+		data, err := readFormFile(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		index, _ := strconv.Atoi(r.FormValue("chunk_index"))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		chunks, ok := s.uploads[r.FormValue("upload_id")]
+		if !ok {
+			http.Error(w, `{"detail": "Unknown upload"}`, http.StatusNotFound)
+			return
+		}
+		chunks[index] = data
+		s.sessions[r.FormValue("upload_id")].UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		writeJSON(w, map[string]bool{"success": true})
+
+	case "complete":
+		var body struct {
+			UploadID string `json:"upload_id"`
+			// TotalChunks is only sent by streaming uploads.
+			TotalChunks int `json:"total_chunks"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		chunks, ok := s.uploads[body.UploadID]
+		if !ok {
+			http.Error(w, `{"detail": "Unknown upload"}`, http.StatusNotFound)
+			return
+		}
+		if body.TotalChunks > 0 && len(chunks) != body.TotalChunks {
+			http.Error(w, fmt.Sprintf(`{"detail": "Missing chunks: got %d of %d"}`, len(chunks), body.TotalChunks), http.StatusBadRequest)
+			return
+		}
+		indexes := make([]int, 0, len(chunks))
+		for i := range chunks {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		var data []byte
+		for _, i := range indexes {
+			data = append(data, chunks[i]...)
+		}
+		s.storeBaseFile(slug, kind, data)
+		delete(s.uploads, body.UploadID)
+		delete(s.sessions, body.UploadID)
+		writeJSON(w, map[string]bool{"success": true})
+
+	case "abort":
+		var body struct {
+			UploadID string `json:"upload_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.uploads[body.UploadID]; !ok {
+			http.Error(w, `{"detail": "Unknown upload"}`, http.StatusNotFound)
+			return
+		}
+		delete(s.uploads, body.UploadID)
+		delete(s.sessions, body.UploadID)
+		writeJSON(w, map[string]bool{"success": true})
+
+	default:
+		http.Error(w, `{"detail": "Not found"}`, http.StatusNotFound)
+	}
+}
+
+// AddUpload adds a chunked upload holding chunks, as left by an
+// interrupted client, and returns its ID. state is active, stale or
+// orphaned; orphaned uploads have no project or kind.
+func (s *Server) AddUpload(slug, kind, state string, chunks ...[]byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := fmt.Sprintf("upload-%d", s.nextID)
+	s.uploads[id] = make(map[int][]byte)
+	for i, chunk := range chunks {
+		s.uploads[id][i] = chunk
+	}
+	if state == "orphaned" {
+		slug, kind = "", ""
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	s.sessions[id] = &client.UploadSession{
+		UploadID:  id,
+		Project:   slug,
+		Kind:      kind,
+		CreatedAt: now,
+		UpdatedAt: now,
+		State:     state,
+	}
+	return id
+}
+
+func (s *Server) handleListUploads(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]client.UploadSession, 0, len(s.sessions))
+	for id, session := range s.sessions {
+		u := *session
+		u.ChunksReceived = len(s.uploads[id])
+		for _, chunk := range s.uploads[id] {
+			u.BytesReceived += int64(len(chunk))
+		}
+		uploads = append(uploads, u)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].UploadID < uploads[j].UploadID })
+	writeJSON(w, map[string][]client.UploadSession{"uploads": uploads})
+}
+
+func (s *Server) handleAbortUpload(w http.ResponseWriter, uploadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chunks, ok := s.uploads[uploadID]
+	if !ok {
+		http.Error(w, `{"detail": "Upload not found"}`, http.StatusNotFound)
+		return
+	}
+	var freed int64
+	for _, chunk := range chunks {
+		freed += int64(len(chunk))
+	}
+	delete(s.uploads, uploadID)
+	delete(s.sessions, uploadID)
+	writeJSON(w, map[string]int64{"bytes_freed": freed})
+}
+
+var upgrader = websocket.Upgrader{}
+
+// hasProgram reports whether a terminal session runs a program instead of
+// a shell.
+func hasProgram(query url.Values) bool {
+	for _, program := range []string{"drush", "composer", "test", "deploy", "redis", "solr", "logs"} {
+		if query.Has(program) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleTerminal(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != s.Token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	inR, inW := io.Pipe()
+	defer inR.Close()
+	go func() {
+		for {
+			var msg struct {
+				Type string `json:"type"`
+				Data string `json:"data"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				inW.Close()
+				return
+			}
+			if msg.Type == "input" {
+				if _, err := inW.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	session := s.Drush
+	args := r.URL.Query().Get("drush")
+	if !hasProgram(r.URL.Query()) {
+		session = s.Shell
+		args = r.URL.Query().Get("container")
+		if args == "" {
+			args = "php"
+		}
+	}
+	if r.URL.Query().Has("composer") {
+		session = s.Composer
+		args = r.URL.Query().Get("composer")
+	}
+	if r.URL.Query().Has("test") {
+		session = s.Test
+		args = r.URL.Query().Get("test")
+		if !s.hasTestSuite(args) {
+			conn.WriteJSON(map[string]string{"type": "error", "message": fmt.Sprintf("Test suite '%s' is not defined in preview.yml", args)})
+			return
+		}
+	}
+	if r.URL.Query().Has("deploy") {
+		session = s.Deploy
+		args = r.URL.Query().Get("deploy")
+	}
+	for _, service := range []string{"redis", "solr"} {
+		if !r.URL.Query().Has(service) {
+			continue
+		}
+		if !s.hasService(service) {
+			conn.WriteJSON(map[string]string{"type": "error", "message": fmt.Sprintf("The %s service is not enabled in preview.yml of this preview", service)})
+			return
+		}
+		args = r.URL.Query().Get(service)
+		session = s.Redis
+		if service == "solr" {
+			session = nil
+			if s.Solr != nil {
+				q := r.URL.Query().Get("q")
+				session = func(op string, stdin io.Reader, stdout io.Writer) int {
+					return s.Solr(op, q, stdin, stdout)
+				}
+			}
+		}
+	}
+	if r.URL.Query().Has("logs") {
+		args = r.URL.Query().Get("logs")
+		severity := r.URL.Query().Get("severity")
+		session = nil
+		if s.Logs != nil {
+			session = func(log string, stdin io.Reader, stdout io.Writer) int {
+				return s.Logs(log, severity, stdout)
+			}
+		}
+	}
+	code := 0
+	if session != nil {
+		code = session(args, inR, terminalWriter{conn})
+	}
+	conn.WriteJSON(map[string]interface{}{"type": "exit", "code": code})
+}
+
+func (s *Server) handleDBTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != s.Token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if s.DB == nil {
+		conn.WriteJSON(map[string]string{"type": "error", "message": "The database of this preview is not running"})
+		return
+	}
+	conn.WriteJSON(map[string]string{"type": "ready", "engine": "mysql:8.0", "database": "drupal", "user": "drupal", "password": "drupal"})
+
+	// Relay binary messages to and from DB
+	inR, inW := io.Pipe()
+	defer inR.Close()
+	go func() {
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				inW.Close()
+				return
+			}
+			if kind == websocket.BinaryMessage {
+				if _, err := inW.Write(data); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	s.DB(struct {
+		io.Reader
+		io.Writer
+	}{inR, binaryWriter{conn}})
+}
+
+// AddMail adds a message captured by a preview, as the newest one. Its ID
+// defaults to its position.
+func (s *Server) AddMail(project, previewName string, m client.MailMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := project + "/" + previewName
+	if m.ID == "" {
+		m.ID = strconv.Itoa(len(s.mail[key]) + 1)
+	}
+	s.mail[key] = append(s.mail[key], m)
+}
+
+func (s *Server) handleMail(w http.ResponseWriter, r *http.Request, project, name string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	messages := s.mail[project+"/"+name]
+	if len(rest) == 1 {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if m := messages[i]; m.ID == rest[0] || rest[0] == "latest" {
+				writeJSON(w, m)
+				return
+			}
+		}
+		http.Error(w, `{"detail": "Message not found"}`, http.StatusNotFound)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 20
+	}
+	list := client.MailList{
+		URL:      fmt.Sprintf("https://mail-%s-%s.mr.preview-mr.com", name, project),
+		User:     "preview",
+		Password: "secret",
+		Total:    len(messages),
+		Messages: []client.MailSummary{},
+	}
+	for i := len(messages) - 1; i >= 0 && len(list.Messages) < limit; i-- {
+		m := messages[i]
+		list.Messages = append(list.Messages, client.MailSummary{
+			ID: m.ID, From: m.From, To: m.To, Subject: m.Subject, Created: m.Date,
+			Size: int64(len(m.Text) + len(m.HTML)), Snippet: m.Text,
+		})
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) handleTriggers(w http.ResponseWriter, r *http.Request, project, name string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(rest) == 1 && r.Method == "DELETE":
+		for i, t := range s.triggers {
+			if t.project == project && t.previewName == name && strconv.Itoa(t.trigger.ID) == rest[0] {
+				s.triggers = append(s.triggers[:i], s.triggers[i+1:]...)
+				writeJSON(w, map[string]bool{"success": true})
+				return
+			}
+		}
+		http.Error(w, `{"detail": "Trigger not found"}`, http.StatusNotFound)
+	case len(rest) == 0 && r.Method == "GET":
+		triggers := []client.Trigger{}
+		for i := len(s.triggers) - 1; i >= 0; i-- {
+			if t := s.triggers[i]; t.project == project && t.previewName == name {
+				triggers = append(triggers, t.trigger)
+			}
+		}
+		writeJSON(w, triggers)
+	case len(rest) == 0 && r.Method == "POST":
+		var req struct {
+			Action    string `json:"action"`
+			Args      string `json:"args"`
+			Name      string `json:"name"`
+			ExpiresIn int    `json:"expires_in"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ExpiresIn == 0 {
+			req.ExpiresIn = 30 * 24 * 3600
+		}
+		s.nextID++
+		now := time.Now().UTC()
+		token := fmt.Sprintf("trigger-token-%d", s.nextID)
+		t := fakeTrigger{project: project, previewName: name, token: token, trigger: client.Trigger{
+			ID: s.nextID, Name: req.Name, Action: req.Action, Args: req.Args, TokenPrefix: token[:8],
+			CreatedBy: s.User.Email, CreatedAt: now.Format(time.RFC3339),
+			ExpiresAt: now.Add(time.Duration(req.ExpiresIn) * time.Second).Format(time.RFC3339),
+		}}
+		s.triggers = append(s.triggers, t)
+		writeJSON(w, struct {
+			client.Trigger
+			Token string `json:"token"`
+			Path  string `json:"path"`
+		}{t.trigger, token, "/api/triggers/" + token})
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// IssueCertificate makes the custom domain hostname resolve to the server
+// and have its certificate issued.
+func (s *Server) IssueCertificate(hostname string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, domains := range s.domains {
+		for i := range domains {
+			if d := &s.domains[key][i]; d.Hostname == hostname {
+				d.DNS.OK, d.DNS.Resolved = true, []string{"192.0.2.1"}
+				d.Certificate = client.DomainCertificate{Status: client.CertificateIssued, Issuer: "Let's Encrypt",
+					ExpiresAt: time.Now().Add(90 * 24 * time.Hour).UTC().Format(time.RFC3339)}
+			}
+		}
+	}
+}
+
+// handleDomains manages the custom domains of a preview. New domains
+// don't resolve and wait for their certificate until IssueCertificate.
+func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request, project, name string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	key := project + "/" + name
+	switch {
+	case len(rest) == 1 && r.Method == "DELETE":
+		for i, d := range s.domains[key] {
+			if d.Hostname == rest[0] {
+				s.domains[key] = append(s.domains[key][:i], s.domains[key][i+1:]...)
+				writeJSON(w, client.ActionResult{Success: true})
+				return
+			}
+		}
+		http.Error(w, `{"detail": "Domain not attached"}`, http.StatusNotFound)
+	case len(rest) == 0 && r.Method == "GET":
+		domains := append([]client.PreviewDomain{}, s.domains[key]...)
+		writeJSON(w, domains)
+	case len(rest) == 0 && r.Method == "POST":
+		var req struct {
+			Hostname string `json:"hostname"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		hostname := req.Hostname
+		for _, domains := range s.domains {
+			for _, d := range domains {
+				if d.Hostname == hostname {
+					http.Error(w, `{"detail": "Already attached to a preview"}`, http.StatusConflict)
+					return
+				}
+			}
+		}
+		d := client.PreviewDomain{
+			Hostname: hostname, CreatedBy: s.User.Email, CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			DNS: client.DomainDNS{Records: []client.DNSRecord{
+				{Type: "CNAME", Name: hostname, Value: fmt.Sprintf("%s-%s.mr.preview-mr.com", name, project)},
+			}, Resolved: []string{}},
+			Certificate: client.DomainCertificate{Status: client.CertificatePending, Message: "No certificate yet"},
+		}
+		if s.domains == nil {
+			s.domains = make(map[string][]client.PreviewDomain)
+		}
+		s.domains[key] = append(s.domains[key], d)
+		writeJSON(w, d)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// handleAllowlist manages the IP allowlist of a project. Unlike the real
+// server it doesn't normalize networks, and sees every client as 127.0.0.1.
+func (s *Server) handleAllowlist(w http.ResponseWriter, r *http.Request, project string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(rest) == 1 && r.Method == "DELETE":
+		for i, e := range s.allowlist {
+			if e.Project == project && strconv.Itoa(e.ID) == rest[0] {
+				s.allowlist = append(s.allowlist[:i], s.allowlist[i+1:]...)
+				writeJSON(w, map[string]bool{"success": true})
+				return
+			}
+		}
+		http.Error(w, `{"detail": "Allowlist entry not found"}`, http.StatusNotFound)
+	case len(rest) == 0 && r.Method == "GET":
+		list := client.Allowlist{ClientIP: "127.0.0.1", Entries: []client.AllowlistEntry{}}
+		for _, e := range s.allowlist {
+			if e.Project == project {
+				list.Entries = append(list.Entries, e)
+			}
+		}
+		writeJSON(w, list)
+	case len(rest) == 0 && r.Method == "POST":
+		var req client.AllowlistRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		entry := client.AllowlistEntry{Project: project, CIDR: req.CIDR, Note: req.Note,
+			CreatedBy: s.User.Email, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+		if req.PreviewName != "" {
+			if s.findPreview(project, req.PreviewName) == nil {
+				http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+				return
+			}
+			entry.PreviewName = &req.PreviewName
+		}
+		s.nextID++
+		entry.ID = s.nextID
+		s.allowlist = append(s.allowlist, entry)
+		writeJSON(w, entry)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// handleRunTrigger runs a trigger by its token, without an API token. It
+// only counts the use.
+func (s *Server) handleRunTrigger(w http.ResponseWriter, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.triggers {
+		t := &s.triggers[i].trigger
+		if s.triggers[i].token != token {
+			continue
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		t.LastUsedAt = &now
+		t.UseCount++
+		writeJSON(w, client.ActionResult{Success: true})
+		return
+	}
+	http.Error(w, `{"detail": "Trigger not found"}`, http.StatusNotFound)
+}
+
+// Xdebug returns how Xdebug of a preview is set up.
+func (s *Server) Xdebug(project, previewName string) client.XdebugInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.xdebug[project+"/"+previewName]
+}
+
+func (s *Server) handleXdebug(w http.ResponseWriter, r *http.Request, project, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findPreview(project, name) == nil {
+		http.Error(w, `{"detail": "Preview not found"}`, http.StatusNotFound)
+		return
+	}
+	var req client.XdebugRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	key := project + "/" + name
+	if s.xdebug[key].ClientHost == "relay" {
+		http.Error(w, `{"detail": "Xdebug is relayed to a debugger by preview xdebug on: stop it first"}`, http.StatusConflict)
+		return
+	}
+	info := client.XdebugInfo{Enabled: req.Enabled, IDEKey: "PREVIEW", ClientPort: req.ClientPort, DocumentRoot: "/var/www/html"}
+	if req.Enabled {
+		info.ClientHost = req.ClientHost
+	}
+	s.xdebug[key] = info
+	writeJSON(w, info)
+}
+
+// handleXdebugRelay turns Xdebug on for the life of the websocket, and
+// relays one XdebugSession over it as connection 1.
+func (s *Server) handleXdebugRelay(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != s.Token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/ws/previews/"), "/")
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	key := parts[0] + "/" + parts[1]
+	s.mu.Lock()
+	if s.findPreview(parts[0], parts[1]) == nil {
+		s.mu.Unlock()
+		conn.WriteJSON(map[string]string{"type": "error", "message": fmt.Sprintf("Container '%s-%s-php' is not running", parts[1], parts[0])})
+		return
+	}
+	s.xdebug[key] = client.XdebugInfo{Enabled: true, IDEKey: "PREVIEW", ClientHost: "relay", ClientPort: 9003, DocumentRoot: "/var/www/html"}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.xdebug[key] = client.XdebugInfo{IDEKey: "PREVIEW", ClientPort: 9003, DocumentRoot: "/var/www/html"}
+		s.mu.Unlock()
+	}()
+	conn.WriteJSON(map[string]string{"type": "ready", "ide_key": "PREVIEW", "document_root": "/var/www/html"})
+
+	// Frames of connection 1 go to and from XdebugSession
+	var writeMu sync.Mutex
+	send := func(p []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{0, 0, 0, 1}, p...))
+	}
+	inR, inW := io.Pipe()
+	defer inR.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer inW.Close()
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind != websocket.BinaryMessage || len(data) < 4 || data[3] != 1 {
+				continue
+			}
+			if len(data) == 4 {
+				inW.Close()
+				continue
+			}
+			inW.Write(data[4:])
+		}
+	}()
+	if s.XdebugSession != nil {
+		s.XdebugSession(struct {
+			io.Reader
+			io.Writer
+		}{inR, writerFunc(func(p []byte) (int, error) {
+			if err := send(p); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		})})
+		send(nil)
+	}
+	<-done
+}
+
+// writerFunc is an io.Writer calling itself.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// binaryWriter writes to a websocket as binary messages.
+type binaryWriter struct{ conn *websocket.Conn }
+
+func (w binaryWriter) Write(p []byte) (int, error) {
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) hasTestSuite(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, suite := range s.TestSuites {
+		if suite.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) hasService(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, service := range s.Services {
+		if service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// terminalWriter sends writes as terminal "output" frames.
+type terminalWriter struct {
+	conn *websocket.Conn
+}
+
+func (tw terminalWriter) Write(p []byte) (int, error) {
+	if err := tw.conn.WriteJSON(map[string]string{"type": "output", "data": string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func readFormFile(r *http.Request) ([]byte, error) {
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeDetail answers with status and a JSON error body, as the server's
+// HTTPExceptions do.
+func writeDetail(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"detail": detail})
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DBInfo describes the database of a preview reached through DialDB.
+type DBInfo struct {
+	// Engine is the database of preview.yml, e.g. "mysql:8.0" or
+	// "mariadb:10.6".
+	Engine   string `json:"engine"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// DialDB opens a connection to the database server of a running preview,
+// tunneled through the server's db-tunnel websocket, for local database
+// clients. Each connection of a client needs its own DialDB.
+func (c *Client) DialDB(ctx context.Context, project, previewName string) (net.Conn, *DBInfo, error) {
+	conn, err := c.dialPreviewWebsocket(ctx, project, previewName, "db-tunnel", "database tunnel")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The server says whether the database can be reached, and how to
+	// log in, before relaying its bytes.
+	var ready struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		DBInfo
+	}
+	if err := conn.ReadJSON(&ready); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("database tunnel closed: %w", err)
+	}
+	if ready.Type == "error" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s", ready.Message)
+	}
+	info := ready.DBInfo
+	return &wsConn{ws: conn}, &info, nil
+}
+
+// dialPreviewWebsocket opens the websocket /ws/previews/PROJECT/PREVIEW/NAME
+// of the server, what it is for naming it in errors.
+func (c *Client) dialPreviewWebsocket(ctx context.Context, project, previewName, name, what string) (*websocket.Conn, error) {
+	query := url.Values{}
+	query.Set("token", c.token())
+	if c.Org != "" {
+		query.Set("org", c.Org)
+	}
+	wsURL := fmt.Sprintf("%s/ws/previews/%s/%s/%s?%s", websocketBase(c.BaseURL), project, previewName, name, query.Encode())
+
+	header := http.Header{}
+	header.Set(apiVersionHeader, fmt.Sprintf("%d-%d", MinAPIVersion, MaxAPIVersion))
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig, dialer.Proxy = c.tlsConfig()
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, ErrNotAuthenticated
+		}
+		if resp != nil {
+			if verr := apiVersionError(resp); verr != nil {
+				return nil, verr
+			}
+		}
+		if u, perr := url.Parse(wsURL); perr == nil {
+			err = tlsError(u.Host, err)
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", what, err)
+	}
+	return conn, nil
+}
+
+// wsConn is a net.Conn over the binary messages of a websocket.
+type wsConn struct {
+	ws      *websocket.Conn
+	reader  io.Reader
+	writeMu sync.Mutex
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			kind, r, err := c.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if kind != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.writeMu.Lock()
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
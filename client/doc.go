@@ -0,0 +1,22 @@
+// Package client is a Go SDK for the Preview Manager API.
+//
+// It is the same client used by the preview CLI, published so that other
+// tools (bots, dashboards, CI helpers) can talk to the preview server
+// without shelling out to the CLI.
+//
+// Basic usage:
+//
+//	c := client.New("https://api.preview-mr.com", token)
+//	list, err := c.ListPreviews(ctx, true)
+//	if errors.Is(err, client.ErrNotAuthenticated) {
+//		// token expired or revoked
+//	}
+//
+// Every method takes a context.Context so callers can cancel long uploads
+// and downloads. Code that only needs to depend on the API surface should
+// accept the API interface instead of *Client, which makes it easy to
+// substitute a fake in tests.
+//
+// The client never writes to stdout/stderr or exits the process. Progress
+// for uploads is reported to Client.Progress when it is set.
+package client
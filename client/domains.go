@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Certificate statuses of a custom domain.
+const (
+	CertificatePending = "pending"
+	CertificateIssued  = "issued"
+)
+
+// DNSRecord is a record a custom domain needs.
+type DNSRecord struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DomainDNS is the DNS status of a custom domain.
+type DomainDNS struct {
+	// Records are those to create: a CNAME to the preview domain, or A and
+	// AAAA records for an apex domain.
+	Records []DNSRecord `json:"records"`
+	// Resolved are the addresses the domain resolves to on the server.
+	Resolved []string `json:"resolved"`
+	// OK is true once the domain resolves to the preview server.
+	OK bool `json:"ok"`
+}
+
+// DomainCertificate is the TLS certificate status of a custom domain.
+type DomainCertificate struct {
+	// Status is CertificatePending until the server serves a trusted
+	// certificate for the domain, then CertificateIssued.
+	Status    string `json:"status"`
+	Issuer    string `json:"issuer,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// PreviewDomain is a custom hostname a preview is served at besides its
+// own domain, without the preview login: only its IP allowlist applies.
+type PreviewDomain struct {
+	Hostname    string            `json:"hostname"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   string            `json:"created_at"`
+	DNS         DomainDNS         `json:"dns"`
+	Certificate DomainCertificate `json:"certificate"`
+}
+
+// AddDomain attaches a custom domain to a preview. The returned domain
+// lists the DNS records it needs; the certificate is issued once they are
+// in place. Requires the manager role.
+func (c *Client) AddDomain(ctx context.Context, project, previewName, hostname string) (*PreviewDomain, error) {
+	var domain PreviewDomain
+	body := map[string]string{"hostname": hostname}
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/previews/%s/%s/domains", c.BaseURL, project, previewName), body, &domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// ListDomains returns the custom domains of a preview with their DNS and
+// certificate status, checked now. Requires the manager role.
+func (c *Client) ListDomains(ctx context.Context, project, previewName string) ([]PreviewDomain, error) {
+	var domains []PreviewDomain
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/previews/%s/%s/domains", c.BaseURL, project, previewName), nil, &domains); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// DeleteDomain detaches a custom domain from a preview. Requires the
+// manager role.
+func (c *Client) DeleteDomain(ctx context.Context, project, previewName, hostname string) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/previews/%s/%s/domains/%s", c.BaseURL, project, previewName, url.PathEscape(hostname)), nil, nil)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted base files use a streaming AES-256-GCM format so dumps of any
+// size can be encrypted on the fly while uploading:
+//
+//	magic "PVENC1" | 7-byte random nonce prefix | segment...
+//
+// Each segment is a 4-byte big-endian length followed by the sealed
+// plaintext (at most encryptSegmentSize bytes). The segment nonce is the
+// prefix, a 4-byte counter and a flag byte set to 1 on the last segment, so
+// reordered, dropped or truncated segments fail to decrypt.
+const (
+	encryptMagic       = "PVENC1"
+	encryptPrefixSize  = 7
+	encryptSegmentSize = 64 * 1024
+)
+
+// ErrDecrypt is returned when encrypted data is corrupt, truncated or was
+// encrypted with a different key.
+var ErrDecrypt = errors.New("decryption failed: wrong key or corrupted data")
+
+// EncryptedKind returns the base file kind under which the server stores
+// client-side encrypted uploads of kind ("db" or "files"). The server keeps
+// them as opaque blobs and never imports or extracts them.
+func EncryptedKind(kind string) string {
+	return kind + "-encrypted"
+}
+
+// ParseKey parses an encryption key file. The key is 32 bytes, given raw,
+// hex-encoded or base64-encoded (e.g. the output of "openssl rand -hex 32").
+func ParseKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid key: expected 32 bytes, raw, hex or base64 encoded")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptPrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// with key into w. Close must be called to write the final segment; it does
+// not close w.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// Keep at least one byte back: only Close knows which segment is last.
+	for len(e.buf) > encryptSegmentSize {
+		if err := e.seal(e.buf[:encryptSegmentSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[encryptSegmentSize:]
+	}
+	return len(p), nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+func (e *encryptWriter) seal(plain []byte, last bool) error {
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.counter, last), plain, nil)
+	e.counter++
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	done    bool
+}
+
+// NewDecryptReader returns a reader that decrypts data written by
+// NewEncryptWriter. Reads fail with ErrDecrypt if the key is wrong or the
+// data was tampered with, truncated or has data after its last segment.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptMagic)+encryptPrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, fmt.Errorf("not an encrypted base file")
+	}
+	return &decryptReader{r: r, aead: aead, prefix: header[len(encryptMagic):]}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrDecrypt
+		}
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > encryptSegmentSize+uint32(d.aead.Overhead()) {
+		return ErrDecrypt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrDecrypt
+		}
+		return err
+	}
+
+	plain, err := d.aead.Open(nil, segmentNonce(d.prefix, d.counter, false), sealed, nil)
+	if err != nil {
+		plain, err = d.aead.Open(nil, segmentNonce(d.prefix, d.counter, true), sealed, nil)
+		if err != nil {
+			return ErrDecrypt
+		}
+		// Nothing may follow the last segment
+		var extra [1]byte
+		if _, err := io.ReadFull(d.r, extra[:]); err == nil {
+			return ErrDecrypt
+		} else if err != io.EOF {
+			return err
+		}
+		d.done = true
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
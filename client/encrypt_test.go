@@ -0,0 +1,119 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/capynet/preview-server/client"
+	"github.com/capynet/preview-server/client/clienttest"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func encrypt(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := client.NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	r, err := client.NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 10, 64 * 1024, 200*1024 + 7} {
+		data := bytes.Repeat([]byte("x"), size)
+		sealed := encrypt(t, testKey, data)
+		if size > 0 && bytes.Contains(sealed, data) {
+			t.Fatalf("size %d: plaintext found in output", size)
+		}
+		got, err := decrypt(testKey, sealed)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestDecryptRejectsWrongKeyAndTruncation(t *testing.T) {
+	sealed := encrypt(t, testKey, bytes.Repeat([]byte("y"), 150*1024))
+
+	if _, err := decrypt(bytes.Repeat([]byte{1}, 32), sealed); !errors.Is(err, client.ErrDecrypt) {
+		t.Fatalf("wrong key: expected ErrDecrypt, got %v", err)
+	}
+	// Cut right after the first full segment, dropping the last ones.
+	cut := len("PVENC1") + 7 + 4 + 64*1024 + 16
+	if _, err := decrypt(testKey, sealed[:cut]); !errors.Is(err, client.ErrDecrypt) {
+		t.Fatalf("truncated: expected ErrDecrypt, got %v", err)
+	}
+}
+
+func TestDecryptRejectsTrailingData(t *testing.T) {
+	for _, size := range []int{0, 100, 150 * 1024} {
+		sealed := encrypt(t, testKey, bytes.Repeat([]byte("z"), size))
+		if _, err := decrypt(testKey, sealed); err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		for _, extra := range [][]byte{{0}, []byte("appended data")} {
+			if _, err := decrypt(testKey, append(append([]byte{}, sealed...), extra...)); !errors.Is(err, client.ErrDecrypt) {
+				t.Fatalf("%d bytes with %d appended: expected ErrDecrypt, got %v", size, len(extra), err)
+			}
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	hexKey := strings.Repeat("42", 32) + "\n"
+	key, err := client.ParseKey([]byte(hexKey))
+	if err != nil || !bytes.Equal(key, testKey) {
+		t.Fatalf("hex key: %x, %v", key, err)
+	}
+	if _, err := client.ParseKey([]byte("too short")); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
+
+func TestEncryptedBaseFileRoundTrip(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	c := srv.Client()
+	ctx := context.Background()
+	kind := client.EncryptedKind("db")
+	data := []byte("secret dump")
+
+	if err := c.UploadBaseFileChunked(ctx, "drupal-test", kind, bytes.NewReader(encrypt(t, testKey, data)), "db.sql.gz.enc"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.DownloadBaseFile(ctx, "drupal-test", kind, &buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decrypt(testKey, buf.Bytes())
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if err := c.DownloadBaseFile(ctx, "drupal-test", "files", &buf); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+)
+
+// FilesSyncResult is the response of SyncPreviewFiles.
+type FilesSyncResult struct {
+	Written int `json:"written"`
+	Deleted int `json:"deleted"`
+}
+
+// PreviewFile is a file of the public files directory of a preview.
+type PreviewFile struct {
+	// Path is relative to the files directory, slash-separated
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	// ModTime is the modification time in Unix seconds. SyncPreviewFiles
+	// keeps the modification times of the archive.
+	ModTime int64 `json:"mtime"`
+}
+
+// ListPreviewFiles lists the regular files of the public files directory of
+// a running preview under each of paths (relative to it, "." for all).
+// Paths that don't exist on the preview list nothing.
+func (c *Client) ListPreviewFiles(ctx context.Context, project, previewName string, paths []string) ([]PreviewFile, error) {
+	query := url.Values{"path": paths}
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/files/manifest?%s", c.BaseURL, project, previewName, query.Encode())
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s or its files directory %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Files []PreviewFile `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Files, nil
+}
+
+// SyncPreviewFiles writes files into the public files directory of a
+// running preview, over its overlay, without changing the base files.
+// archive is a tar.gz of size bytes with paths relative to the files
+// directory, or nil to only delete; deletes are paths relative to it,
+// deleted before the archive is extracted.
+func (c *Client) SyncPreviewFiles(ctx context.Context, project, previewName string, archive io.ReaderAt, size int64, deletes []string) (*FilesSyncResult, error) {
+	result, err := c.syncPreviewFiles(ctx, project, previewName, archive, size, deletes)
+	if errors.Is(err, errTokenRefreshed) {
+		result, err = c.syncPreviewFiles(ctx, project, previewName, archive, size, deletes)
+	}
+	return result, err
+}
+
+func (c *Client) syncPreviewFiles(ctx context.Context, project, previewName string, archive io.ReaderAt, size int64, deletes []string) (*FilesSyncResult, error) {
+	if deletes == nil {
+		deletes = []string{}
+	}
+	deleteJSON, err := json.Marshal(deletes)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		if err := writer.WriteField("delete", string(deleteJSON)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if archive != nil {
+			part, err := writer.CreateFormFile("file", "files.tar.gz")
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(part, io.NewSectionReader(archive, 0, size)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(writer.Close())
+	}()
+
+	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("%s/api/previews/%s/%s/files/sync", c.BaseURL, project, previewName), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s or its files directory %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result FilesSyncResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,5 @@
+module github.com/capynet/preview-server/client
+
+go 1.21
+
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// CLIHelp are the values of a server the CLI shows in its help and error
+// hints instead of generic placeholders.
+type CLIHelp struct {
+	// PreviewDomain is the domain previews are served at, with {project}
+	// and {preview} standing for their names.
+	PreviewDomain string `json:"preview_domain"`
+	// DocsURL is the documentation of the server.
+	DocsURL string `json:"docs_url"`
+	// PHPVersions are the PHP versions previews can run (php_version of
+	// preview.yml), and DefaultPHPVersion the one they run without it.
+	PHPVersions       []string `json:"php_versions"`
+	DefaultPHPVersion string   `json:"default_php_version"`
+	// Notes are notes of the server admins by command path, e.g. "push db".
+	Notes map[string]string `json:"notes"`
+}
+
+// GetCLIHelp returns the help values of the server. Servers that predate
+// the endpoint return ErrNotFound.
+func (c *Client) GetCLIHelp(ctx context.Context) (*CLIHelp, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/cli/help", c.BaseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("CLI help %w", ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var help CLIHelp
+	if err := json.NewDecoder(resp.Body).Decode(&help); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &help, nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ServerInfo summarizes the health of a server.
+type ServerInfo struct {
+	// CLIVersion is the version of the CLI release the server publishes
+	// for self-update, "" if none.
+	CLIVersion    string `json:"cli_version"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	PreviewsTotal int    `json:"previews_total"`
+	// PreviewsByStatus counts previews by status (active, failed...).
+	PreviewsByStatus map[string]int `json:"previews_by_status"`
+	// DiskTotalBytes and DiskFreeBytes describe the disk previews live on.
+	DiskTotalBytes int64 `json:"disk_total_bytes"`
+	DiskFreeBytes  int64 `json:"disk_free_bytes"`
+	// RunningDeployments is the number of deployments in progress.
+	RunningDeployments int `json:"running_deployments"`
+}
+
+// GetServerInfo returns the health of the server.
+func (c *Client) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/info", c.BaseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var info ServerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// MailList is the mail captured by a preview, and how to reach its mail
+// capture UI.
+type MailList struct {
+	// URL of the mail capture UI, behind HTTP basic auth with User and
+	// Password.
+	URL      string        `json:"url"`
+	User     string        `json:"user"`
+	Password string        `json:"password"`
+	Total    int           `json:"total"`
+	Unread   int           `json:"unread"`
+	Messages []MailSummary `json:"messages"`
+}
+
+// MailSummary is a message in a MailList.
+type MailSummary struct {
+	ID      string   `json:"id"`
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	// Created is when the message was received (RFC 3339).
+	Created string `json:"created"`
+	Size    int64  `json:"size"`
+	Read    bool   `json:"read"`
+	// Snippet is the start of the text of the message.
+	Snippet string `json:"snippet"`
+}
+
+// MailMessage is a message captured by a preview.
+type MailMessage struct {
+	ID          string           `json:"id"`
+	From        string           `json:"from"`
+	To          []string         `json:"to"`
+	Cc          []string         `json:"cc"`
+	Subject     string           `json:"subject"`
+	Date        string           `json:"date"`
+	Text        string           `json:"text"`
+	HTML        string           `json:"html"`
+	Attachments []MailAttachment `json:"attachments"`
+}
+
+// MailAttachment is a file attached to a MailMessage.
+type MailAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// ListMail returns the last limit messages captured by a preview, newest
+// first.
+func (c *Client) ListMail(ctx context.Context, project, previewName string, limit int) (*MailList, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/mail?%s", c.BaseURL, project, previewName, query.Encode())
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var list MailList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &list, nil
+}
+
+// GetMail returns a message captured by a preview by its ID, or the newest
+// one for ID "latest".
+func (c *Client) GetMail(ctx context.Context, project, previewName, id string) (*MailMessage, error) {
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/mail/%s", c.BaseURL, project, previewName, url.PathEscape(id))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var msg MailMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &msg, nil
+}
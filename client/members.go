@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Member is a user of the server, with the role that sets what they can
+// do. Managers and viewers only see the projects they are members of.
+type Member struct {
+	ID    int     `json:"id"`
+	Email string  `json:"email"`
+	Name  string  `json:"name"`
+	Role  *string `json:"role"`
+	// Projects are the projects the user is a member of. Only listed by
+	// ListMembers.
+	Projects  []string `json:"projects,omitempty"`
+	CreatedAt string   `json:"created_at,omitempty"`
+}
+
+// Invitation is a pending invitation to join the server.
+type Invitation struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// ProjectSlug is the project the user becomes a member of on
+	// accepting, nil for none.
+	ProjectSlug   *string `json:"project_slug"`
+	InvitedByName string  `json:"invited_by_name,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+	ExpiresAt     string  `json:"expires_at"`
+}
+
+// ProjectMembers are the members of a project and the pending invitations
+// to it.
+type ProjectMembers struct {
+	Members     []Member     `json:"members"`
+	Invitations []Invitation `json:"invitations"`
+}
+
+// ListMembers returns every user of the server with their projects.
+// Requires the manager role.
+func (c *Client) ListMembers(ctx context.Context) ([]Member, error) {
+	var result struct {
+		Users []Member `json:"users"`
+	}
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/auth/users", c.BaseURL), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Users, nil
+}
+
+// ListInvitations returns the pending invitations. Requires the admin role.
+func (c *Client) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	var result struct {
+		Invitations []Invitation `json:"invitations"`
+	}
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/auth/invitations", c.BaseURL), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Invitations, nil
+}
+
+// Invite emails an invitation to join the server with role ("admin",
+// "manager" or "viewer"), as a member of project unless it is empty.
+// Requires the manager role.
+func (c *Client) Invite(ctx context.Context, email, role, project string) (*Invitation, error) {
+	body := map[string]interface{}{"email": email, "role": role}
+	if project != "" {
+		body["project_slug"] = project
+	}
+	var invitation Invitation
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/auth/invitations", c.BaseURL), body, &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// CancelInvitation cancels a pending invitation. Requires the admin role.
+func (c *Client) CancelInvitation(ctx context.Context, id int) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/auth/invitations/%d", c.BaseURL, id), nil, nil)
+}
+
+// SetMemberRole changes the role of a user. Requires the admin role.
+func (c *Client) SetMemberRole(ctx context.Context, userID int, role string) error {
+	return c.doJSON(ctx, "PUT", fmt.Sprintf("%s/api/auth/users/%d/role", c.BaseURL, userID), map[string]string{"role": role}, nil)
+}
+
+// RemoveMember deletes a user from the server. Requires the admin role.
+func (c *Client) RemoveMember(ctx context.Context, userID int) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/auth/users/%d", c.BaseURL, userID), nil, nil)
+}
+
+// ListProjectMembers returns the members of project and the pending
+// invitations to it. Requires the manager role.
+func (c *Client) ListProjectMembers(ctx context.Context, project string) (*ProjectMembers, error) {
+	var result ProjectMembers
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/auth/projects/%s/members", c.BaseURL, url.PathEscape(project)), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AddProjectMember gives an existing user access to project. Requires the
+// manager role.
+func (c *Client) AddProjectMember(ctx context.Context, project string, userID int) error {
+	return c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/auth/projects/%s/members", c.BaseURL, url.PathEscape(project)), map[string]int{"user_id": userID}, nil)
+}
+
+// RemoveProjectMember takes the access to project away from a user.
+// Requires the manager role.
+func (c *Client) RemoveProjectMember(ctx context.Context, project string, userID int) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/auth/projects/%s/members/%d", c.BaseURL, url.PathEscape(project), userID), nil, nil)
+}
+
+// doJSON sends body, if not nil, as JSON and decodes the response into
+// result, if not nil.
+func (c *Client) doJSON(ctx context.Context, method, endpoint string, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := c.newRequest(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("decode error: %w", err)
+	}
+	return nil
+}
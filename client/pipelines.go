@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Pipeline is a GitLab CI pipeline of a project.
+type Pipeline struct {
+	ID int `json:"id"`
+	// Status is the GitLab status: created, pending, running, success,
+	// failed, canceled, skipped or manual.
+	Status    string `json:"status"`
+	Ref       string `json:"ref"`
+	SHA       string `json:"sha"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	WebURL    string `json:"web_url"`
+}
+
+// Job is a job of a GitLab CI pipeline.
+type Job struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Stage  string `json:"stage"`
+	Status string `json:"status"`
+	// Duration is in seconds, nil until the job starts.
+	Duration   *float64 `json:"duration"`
+	CreatedAt  string   `json:"created_at"`
+	StartedAt  *string  `json:"started_at"`
+	FinishedAt *string  `json:"finished_at"`
+	WebURL     string   `json:"web_url"`
+}
+
+// ListPipelines returns the latest pipelines of project, newest first.
+// With previewName, only those of the merge request or branch of that
+// preview.
+func (c *Client) ListPipelines(ctx context.Context, project, previewName string) ([]Pipeline, error) {
+	endpoint := fmt.Sprintf("%s/api/gitlab/projects/by-slug/%s/pipelines", c.BaseURL, url.PathEscape(project))
+	if previewName != "" {
+		endpoint += "?preview=" + url.QueryEscape(previewName)
+	}
+	var result struct {
+		Pipelines []Pipeline `json:"pipelines"`
+	}
+	if err := c.doJSON(ctx, "GET", endpoint, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Pipelines, nil
+}
+
+// ListPipelineJobs returns the jobs of a pipeline of project, oldest
+// first. Retried jobs are included, so a name may appear more than once.
+func (c *Client) ListPipelineJobs(ctx context.Context, project string, pipelineID int) ([]Job, error) {
+	var result struct {
+		Jobs []Job `json:"jobs"`
+	}
+	endpoint := fmt.Sprintf("%s/api/gitlab/projects/by-slug/%s/pipelines/%d/jobs", c.BaseURL, url.PathEscape(project), pipelineID)
+	if err := c.doJSON(ctx, "GET", endpoint, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Jobs, nil
+}
+
+// JobLog streams the log of a job of project into w, as GitLab keeps it:
+// with ANSI colors and collapsible section markers.
+func (c *Client) JobLog(ctx context.Context, project string, jobID int, w io.Writer) error {
+	endpoint := fmt.Sprintf("%s/api/gitlab/projects/by-slug/%s/jobs/%d/trace", c.BaseURL, url.PathEscape(project), jobID)
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
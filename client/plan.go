@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Actions of a PlanChange.
+const (
+	PlanCreate  = "create"
+	PlanDestroy = "destroy"
+	PlanReplace = "replace"
+	PlanUpdate  = "update"
+)
+
+// PlanRequest is the request of PlanPreview.
+type PlanRequest struct {
+	// PreviewYml is the content of the preview.yml to plan, e.g. that of
+	// the HEAD commit; empty for none, i.e. the defaults.
+	PreviewYml string `json:"preview_yml"`
+}
+
+// PlanChange is a difference between the preview.yml a preview was
+// deployed with and a planned one.
+type PlanChange struct {
+	// Key is the changed setting, e.g. php_version or services.solr.
+	Key  string      `json:"key"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+	// Action is what the next rebuild does about it: PlanCreate,
+	// PlanDestroy or PlanReplace a container, or PlanUpdate one or a
+	// setting in place.
+	Action string `json:"action"`
+	// Container is the container the change applies to, e.g. php or solr,
+	// empty for settings that touch none.
+	Container string `json:"container"`
+	// Effect describes the change in a sentence.
+	Effect string `json:"effect"`
+}
+
+// Plan is the response of PlanPreview.
+type Plan struct {
+	Preview string `json:"preview"`
+	// CommitSHA is the commit the preview was last deployed at.
+	CommitSHA string `json:"commit_sha"`
+	// Changes are the differences, most disruptive first; none if the
+	// preview.yml planned is equivalent to the deployed one.
+	Changes []PlanChange `json:"changes"`
+}
+
+// PlanPreview compares a preview.yml with the one a preview was last
+// deployed with and returns what its next rebuild would change, without
+// changing anything.
+func (c *Client) PlanPreview(ctx context.Context, project, previewName string, req PlanRequest) (*Plan, error) {
+	var plan Plan
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/previews/%s/%s/plan", c.BaseURL, project, previewName), req, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
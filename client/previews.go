@@ -0,0 +1,532 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ActionResult is the response to preview actions (start, stop, drush...).
+type ActionResult struct {
+	Success     bool   `json:"success"`
+	Output      string `json:"output"`
+	Error       string `json:"error"`
+	PipelineID  int    `json:"pipeline_id,omitempty"`
+	PipelineURL string `json:"pipeline_url,omitempty"`
+	// UpToDate is set by RebuildIfChanged when the preview already runs
+	// the requested commit and nothing was rebuilt.
+	UpToDate bool `json:"up_to_date,omitempty"`
+}
+
+// PreviewListResult is the response of ListPreviews.
+type PreviewListResult struct {
+	Previews []Preview `json:"previews"`
+	Total    int       `json:"total"`
+}
+
+// Preview describes a single preview environment.
+type Preview struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	MrID    int    `json:"mr_id"`
+	// MrURL is the GitLab page of the MR, empty for branch previews.
+	MrURL     string `json:"mr_url,omitempty"`
+	Status    string `json:"status"`
+	URL       string `json:"url"`
+	Branch    string `json:"branch"`
+	CommitSHA string `json:"commit_sha"`
+	CreatedAt string `json:"created_at,omitempty"`
+	// SizeBytes is the disk usage of the preview, set when listed with
+	// ListOptions.IncludeSizes; nil if unknown.
+	SizeBytes      *int64  `json:"size_bytes,omitempty"`
+	LastDeployedAt *string `json:"last_deployed_at"`
+	// LastAccessedAt is when the preview was last visited, nil if never.
+	LastAccessedAt *string `json:"last_accessed_at"`
+	BasicAuthUser  *string `json:"basic_auth_user"`
+	BasicAuthPass  *string `json:"basic_auth_pass"`
+	// LastDeployment is the latest deployment, nil if there was none.
+	LastDeployment *Deployment `json:"last_deployment"`
+}
+
+// LastActivity returns when the preview was last visited or deployed,
+// whichever is later, as the server's auto-stop counts idle time. It
+// returns false if the preview was neither.
+func (p Preview) LastActivity() (time.Time, bool) {
+	var last time.Time
+	for _, ts := range []*string{p.LastAccessedAt, p.LastDeployedAt} {
+		if ts == nil {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, *ts); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// Deployment summarizes a deployment of a preview.
+type Deployment struct {
+	ID int `json:"id"`
+	// Status is running, success or failed.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ListPreviews returns all previews visible to the token. When includeStatus
+// is false the server skips the Docker status check, which is faster.
+func (c *Client) ListPreviews(ctx context.Context, includeStatus bool) (*PreviewListResult, error) {
+	statusParam := "true"
+	if !includeStatus {
+		statusParam = "false"
+	}
+	url := fmt.Sprintf("%s/api/previews?status=%s", c.BaseURL, statusParam)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result PreviewListResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// ListOptions filter and paginate ListPreviewsPage.
+type ListOptions struct {
+	// Project, if set, only lists the previews of this project.
+	Project string
+	// IncludeStatus makes the server check the Docker status of the listed
+	// previews, which is slower.
+	IncludeStatus bool
+	// Limit is the maximum number of previews to return, 0 for all.
+	Limit int
+	// Offset is the number of previews to skip.
+	Offset int
+	// IncludeSizes makes the server measure the disk usage of the listed
+	// previews, which is slower.
+	IncludeSizes bool
+}
+
+// ListPreviewsPage returns a page of the previews visible to the token.
+// Total counts all matching previews, not only the returned page, so
+// Offset+len(Previews) < Total means there are more.
+//
+// Older servers ignore the options and return the full list; the page is
+// then cut on the client side.
+func (c *Client) ListPreviewsPage(ctx context.Context, opts ListOptions) (*PreviewListResult, error) {
+	query := url.Values{}
+	query.Set("status", fmt.Sprint(opts.IncludeStatus))
+	if opts.Project != "" {
+		query.Set("project", opts.Project)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", fmt.Sprint(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", fmt.Sprint(opts.Offset))
+	}
+	if opts.IncludeSizes {
+		query.Set("sizes", "true")
+	}
+
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/previews?%s", c.BaseURL, query.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result PreviewListResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	if ignoredListOptions(&result, opts) {
+		paginate(&result, opts)
+	}
+	return &result, nil
+}
+
+// ignoredListOptions reports whether the server returned the full list
+// instead of the page asked for by opts.
+func ignoredListOptions(result *PreviewListResult, opts ListOptions) bool {
+	n := len(result.Previews)
+	if opts.Limit > 0 && n > opts.Limit || n > max(result.Total-opts.Offset, 0) {
+		return true
+	}
+	for _, p := range result.Previews {
+		if opts.Project != "" && p.Project != opts.Project {
+			return true
+		}
+	}
+	return false
+}
+
+// paginate applies opts to a full preview list.
+func paginate(result *PreviewListResult, opts ListOptions) {
+	previews := result.Previews
+	if opts.Project != "" {
+		previews = nil
+		for _, p := range result.Previews {
+			if p.Project == opts.Project {
+				previews = append(previews, p)
+			}
+		}
+	}
+	result.Total = len(previews)
+	previews = previews[min(opts.Offset, len(previews)):]
+	if opts.Limit > 0 && len(previews) > opts.Limit {
+		previews = previews[:opts.Limit]
+	}
+	result.Previews = previews
+}
+
+// PostAction runs an action (start, stop, restart, rebuild) on an MR preview.
+func (c *Client) PostAction(ctx context.Context, project string, mrID int, action string) (*ActionResult, error) {
+	return c.PostActionByName(ctx, project, fmt.Sprintf("mr-%d", mrID), action)
+}
+
+// PostActionByName runs an action on any preview (mr-5, branch-develop...).
+func (c *Client) PostActionByName(ctx context.Context, project string, previewName string, action string) (*ActionResult, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/%s", c.BaseURL, project, previewName, action)
+
+	resp, err := c.doRequest(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+
+	var result ActionResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// RebuildIfChanged rebuilds a preview only if its deployed commit differs
+// from commitSHA (a full or abbreviated SHA). Otherwise the result has
+// UpToDate set.
+func (c *Client) RebuildIfChanged(ctx context.Context, project, previewName, commitSHA string) (*ActionResult, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/rebuild", c.BaseURL, project, previewName)
+
+	payload := fmt.Sprintf(`{"commit_sha": %q}`, commitSHA)
+	resp, err := c.doRequest(ctx, "POST", url, strings.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+
+	var result ActionResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// ScalePreview changes the resource limits of a preview's PHP container.
+// changes maps "memory" (e.g. "2g") and "cpus" (e.g. "1.5") to new limits;
+// omitted limits are unchanged and an empty value resets a limit to its
+// default.
+func (c *Client) ScalePreview(ctx context.Context, project, previewName string, changes map[string]string) (*ActionResult, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/scale", c.BaseURL, project, previewName)
+
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doRequest(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result ActionResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// RetargetRequest is the branch or MR a preview moves to; set one of them.
+type RetargetRequest struct {
+	Branch string `json:"branch,omitempty"`
+	MrIID  int    `json:"mr_iid,omitempty"`
+}
+
+// RetargetResult is where a retargeted preview went. It is rebuilt in the
+// background under its new name.
+type RetargetResult struct {
+	PreviewName string `json:"preview_name"`
+	Branch      string `json:"branch"`
+	CommitSHA   string `json:"commit_sha"`
+	URL         string `json:"url"`
+	Message     string `json:"message"`
+}
+
+// RetargetPreview moves a preview to another branch or MR, keeping its
+// database and files. A preview moved to MR n is renamed mr-n, and a
+// branch preview moved to another branch is renamed after it.
+func (c *Client) RetargetPreview(ctx context.Context, project, previewName string, req RetargetRequest) (*RetargetResult, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/retarget", c.BaseURL, project, previewName)
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doRequest(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result RetargetResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// PostDrush runs drush on an MR preview.
+func (c *Client) PostDrush(ctx context.Context, project string, mrID int, args string) (*ActionResult, error) {
+	return c.PostDrushByName(ctx, project, fmt.Sprintf("mr-%d", mrID), args)
+}
+
+// PostDrushByName runs drush on any preview (mr-5, branch-develop...).
+func (c *Client) PostDrushByName(ctx context.Context, project string, previewName string, args string) (*ActionResult, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/drush", c.BaseURL, project, previewName)
+
+	payload := fmt.Sprintf(`{"args": %q}`, args)
+	resp, err := c.doRequest(ctx, "POST", url, strings.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+
+	var result ActionResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// DownloadStream streams a preview's database dump (kind "db") or files
+// archive (kind "files") into w.
+func (c *Client) DownloadStream(ctx context.Context, project string, previewName string, kind string, w io.Writer) error {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/%s/download", c.BaseURL, project, previewName, kind)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// DownloadTables streams a gzipped dump of tables of a preview's database
+// to w.
+func (c *Client) DownloadTables(ctx context.Context, project, previewName string, tables []string, w io.Writer) error {
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/db/download?tables=%s", c.BaseURL, project, previewName, url.QueryEscape(strings.Join(tables, ",")))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Artifact is a file inside a preview's PHP container.
+type Artifact struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// ListArtifacts returns the files in a preview's PHP container matching
+// pattern, an absolute path that may contain glob wildcards
+// (e.g. "/var/www/html/web/sites/default/files/*.pdf").
+func (c *Client) ListArtifacts(ctx context.Context, project, previewName, pattern string) ([]Artifact, error) {
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/artifacts?pattern=%s", c.BaseURL, project, previewName, url.QueryEscape(pattern))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Artifacts []Artifact `json:"artifacts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Artifacts, nil
+}
+
+// PreviewSize is the disk usage of a preview by component.
+type PreviewSize struct {
+	Project string `json:"project"`
+	Preview string `json:"preview"`
+	// TotalBytes leaves out the shared components
+	TotalBytes int64           `json:"total_bytes"`
+	Components []SizeComponent `json:"components"`
+}
+
+// SizeComponent is a part of a preview using disk: code, files, database,
+// solr, containers, and the shared base-files and images.
+type SizeComponent struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// SizeBytes is nil if the server couldn't measure the component
+	SizeBytes *int64 `json:"size_bytes"`
+	// Shared is true for components shared with other previews
+	Shared bool `json:"shared"`
+	// Largest lists the largest entries of the component, e.g. the
+	// directories of the code checkout
+	Largest []SizeEntry `json:"largest"`
+}
+
+// SizeEntry is an entry of a SizeComponent.
+type SizeEntry struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// GetPreviewSize measures the disk usage of a preview on the server, which
+// can take a while for large previews.
+func (c *Client) GetPreviewSize(ctx context.Context, project, previewName string) (*PreviewSize, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/previews/%s/%s/size", c.BaseURL, project, previewName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var size PreviewSize
+	if err := json.NewDecoder(resp.Body).Decode(&size); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &size, nil
+}
+
+// TestSuite is a test suite defined in a preview's preview.yml.
+type TestSuite struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// JUnit is the path of the suite's JUnit reports inside the PHP
+	// container, which may contain glob wildcards. Empty if the suite
+	// writes none.
+	JUnit string `json:"junit"`
+}
+
+// ListTestSuites returns the test suites defined in a preview's preview.yml.
+func (c *Client) ListTestSuites(ctx context.Context, project, previewName string) ([]TestSuite, error) {
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/tests", c.BaseURL, project, previewName)
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result struct {
+		Suites []TestSuite `json:"suites"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return result.Suites, nil
+}
+
+// DownloadArtifact streams the file at path in a preview's PHP container
+// into w.
+func (c *Client) DownloadArtifact(ctx context.Context, project, previewName, path string, w io.Writer) error {
+	endpoint := fmt.Sprintf("%s/api/previews/%s/%s/artifacts/download?path=%s", c.BaseURL, project, previewName, url.QueryEscape(path))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return fmt.Errorf("%s on %s/%s %w", path, project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return httpError(resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Phases of ProgressEvent.
+const (
+	// PhaseBuffer is an upload being produced (dumped, packaged) into a
+	// temporary file before it is sent.
+	PhaseBuffer = "buffer"
+	// PhaseUpload is an upload being sent.
+	PhaseUpload = "upload"
+	// PhaseDownload is a download being received.
+	PhaseDownload = "download"
+)
+
+// ProgressEvent is the progress of a phase of a transfer, see
+// Client.OnProgress.
+type ProgressEvent struct {
+	Phase string
+	// Kind is what is transferred: "db", "files" or another base file kind.
+	Kind  string
+	Bytes int64
+	// Total is 0 when unknown, e.g. while an upload is still produced;
+	// Percent and ETA are then 0 too.
+	Total   int64
+	Percent float64
+	ETA     time.Duration
+	// Done is set on the last event of the phase.
+	Done bool
+}
+
+// NewProgressEvent returns the event of bytes of total transferred since
+// start, estimating the time left from the rate so far.
+func NewProgressEvent(phase, kind string, bytes, total int64, start time.Time) ProgressEvent {
+	e := ProgressEvent{Phase: phase, Kind: kind, Bytes: bytes, Total: total}
+	if total <= 0 {
+		return e
+	}
+	e.Percent = float64(bytes) / float64(total) * 100
+	if elapsed := time.Since(start); bytes > 0 && bytes < total {
+		e.ETA = time.Duration(float64(elapsed) * float64(total-bytes) / float64(bytes)).Round(time.Second)
+	}
+	return e
+}
+
+// phaseProgress reports the progress of a phase of an upload to
+// OnProgress, if set.
+type phaseProgress struct {
+	c     *Client
+	phase string
+	kind  string
+	total int64
+	start time.Time
+}
+
+func (c *Client) startPhase(phase, kind string, total int64) *phaseProgress {
+	return &phaseProgress{c: c, phase: phase, kind: kind, total: total, start: time.Now()}
+}
+
+// report sends an event to OnProgress and reports whether it did; if not,
+// the caller draws its bar on Progress instead.
+func (p *phaseProgress) report(bytes int64, done bool) bool {
+	if p.c.OnProgress == nil {
+		return false
+	}
+	e := NewProgressEvent(p.phase, p.kind, bytes, p.total, p.start)
+	e.Done = done
+	p.c.OnProgress(e)
+	return true
+}
+
+// bufferProgressWriter shows bytes written during buffering (unknown total).
+type bufferProgressWriter struct {
+	out      io.Writer
+	progress *phaseProgress
+	written  int64
+	lastLog  int64
+}
+
+func (bw *bufferProgressWriter) Write(p []byte) (int, error) {
+	bw.written += int64(len(p))
+	// Update every 1MB to avoid excessive output
+	if bw.written-bw.lastLog >= 1024*1024 {
+		bw.lastLog = bw.written
+		if bw.progress.report(bw.written, false) || bw.out == nil {
+			return len(p), nil
+		}
+		frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+		frame := frames[(bw.written/(1024*1024))%int64(len(frames))]
+		fmt.Fprintf(bw.out, "\r%s Packaging... %s", frame, formatBytes(bw.written))
+	}
+	return len(p), nil
+}
+
+// progressWriter counts bytes written and prints a progress bar.
+type progressWriter struct {
+	out      io.Writer
+	progress *phaseProgress
+	total    int64
+	written  int64
+	label    string
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.written += int64(len(p))
+	if pw.progress.report(pw.written, pw.written == pw.total) || pw.out == nil {
+		return len(p), nil
+	}
+	pct := float64(pw.written) / float64(pw.total) * 100
+	bar := progressBar(pct, 30)
+	fmt.Fprintf(pw.out, "\r%s... %s / %s (%.0f%%) %s",
+		pw.label, formatBytes(pw.written), formatBytes(pw.total), pct, bar)
+	return len(p), nil
+}
+
+func progressBar(pct float64, width int) string {
+	filled := int(pct / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+func formatBytes(b int64) string {
+	switch {
+	case b >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(b)/(1024*1024*1024))
+	case b >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(b)/(1024*1024))
+	case b >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(b)/1024)
+	default:
+		return fmt.Sprintf("%d B", b)
+	}
+}
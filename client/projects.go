@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Project is a project previews can be made of: a GitLab project enabled
+// on the server.
+type Project struct {
+	// Slug is the last segment of its GitLab path, the project argument of
+	// the other calls.
+	Slug              string `json:"slug"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+}
+
+// ListProjects returns the projects the user has access to, by slug.
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	var result struct {
+		Projects []Project `json:"projects"`
+	}
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/projects", c.BaseURL), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Projects, nil
+}
+
+// ProjectSettings are the server-side settings of a project.
+type ProjectSettings struct {
+	// Settings maps each setting to its JSON value, nil if it is at its
+	// default.
+	Settings map[string]interface{} `json:"settings"`
+	// Descriptions explains each setting.
+	Descriptions map[string]string `json:"descriptions"`
+}
+
+// GetProjectSettings returns the settings of a project.
+func (c *Client) GetProjectSettings(ctx context.Context, project string) (*ProjectSettings, error) {
+	return c.projectSettings(ctx, "GET", project, nil)
+}
+
+// UpdateProjectSettings changes some settings of a project and returns all
+// of them. Values are parsed by the server; an empty value resets a setting
+// to its default.
+func (c *Client) UpdateProjectSettings(ctx context.Context, project string, changes map[string]string) (*ProjectSettings, error) {
+	body, err := json.Marshal(map[string]map[string]string{"settings": changes})
+	if err != nil {
+		return nil, err
+	}
+	return c.projectSettings(ctx, "PATCH", project, body)
+}
+
+func (c *Client) projectSettings(ctx context.Context, method, project string, body []byte) (*ProjectSettings, error) {
+	url := fmt.Sprintf("%s/api/config/project-settings/%s", c.BaseURL, project)
+
+	req, err := c.newRequest(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result ProjectSettings
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// AutoStopPolicy is when the server stops idle previews to free host
+// resources. Stopped previews start again when visited.
+type AutoStopPolicy struct {
+	// Override is true if a project has its own policy rather than the
+	// global one. Always false for the global policy.
+	Override bool `json:"override"`
+	// Enabled is whether idle previews are stopped.
+	Enabled bool `json:"enabled"`
+	// Minutes is how long a preview must be idle to be stopped, 0 if a
+	// project policy keeps the global timeout.
+	Minutes int `json:"minutes,omitempty"`
+}
+
+// GetAutoStopPolicy returns the auto-stop policy of project, or the global
+// one if project is empty.
+func (c *Client) GetAutoStopPolicy(ctx context.Context, project string) (*AutoStopPolicy, error) {
+	var policy struct {
+		Override bool  `json:"override"`
+		Enabled  *bool `json:"enabled"`
+		Minutes  *int  `json:"minutes"`
+	}
+	if err := c.doJSON(ctx, "GET", c.autoStopURL(project), nil, &policy); err != nil {
+		return nil, err
+	}
+	result := &AutoStopPolicy{Override: policy.Override}
+	if policy.Enabled != nil {
+		result.Enabled = *policy.Enabled
+	}
+	if policy.Minutes != nil {
+		result.Minutes = *policy.Minutes
+	}
+	return result, nil
+}
+
+// SetAutoStopPolicy changes the auto-stop policy of project, or the global
+// one if project is empty. A project policy without Override reverts the
+// project to the global policy. Requires the admin role.
+func (c *Client) SetAutoStopPolicy(ctx context.Context, project string, policy AutoStopPolicy) error {
+	body := map[string]interface{}{"enabled": policy.Enabled}
+	if project != "" {
+		body["override"] = policy.Override
+	}
+	if policy.Minutes > 0 {
+		body["minutes"] = policy.Minutes
+	}
+	return c.doJSON(ctx, "PUT", c.autoStopURL(project), body, nil)
+}
+
+func (c *Client) autoStopURL(project string) string {
+	if project == "" {
+		return c.BaseURL + "/api/config/auto-stop"
+	}
+	return fmt.Sprintf("%s/api/config/auto-stop/%s", c.BaseURL, url.PathEscape(project))
+}
+
+// ValidateRequest is a preview.yml to check with ValidatePreviewYml.
+type ValidateRequest struct {
+	// PreviewYml is the content of preview.yml, empty for none.
+	PreviewYml string `json:"preview_yml"`
+	// Preview is the preview the file is for, e.g. mr-5. The server
+	// derives a branch preview name from Branch if empty.
+	Preview string `json:"preview,omitempty"`
+	// Ref and Branch are the commit and branch the deploy scripts are
+	// checked at; Branch is used when Ref isn't pushed.
+	Ref    string `json:"ref,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// ValidationCheck is the outcome of one check of a preview.yml validation.
+type ValidationCheck struct {
+	Name string `json:"name"`
+	// Status is ok, warning, error or skipped.
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Hint suggests how to fix a warning or error, if anything.
+	Hint string `json:"hint"`
+}
+
+// ValidationResult is the outcome of a preview.yml validation.
+type ValidationResult struct {
+	// Valid is false if any check failed with an error.
+	Valid bool `json:"valid"`
+	// Ref is the commit or branch the repository was checked at, empty if
+	// it wasn't.
+	Ref string `json:"ref"`
+	// Preview is the preview the domain was checked for.
+	Preview string            `json:"preview"`
+	Checks  []ValidationCheck `json:"checks"`
+}
+
+// ValidatePreviewYml checks a preview.yml for project on the server without
+// deploying it: its PHP and database images, services and resources, the
+// preview domain and the deploy scripts at the target commit.
+func (c *Client) ValidatePreviewYml(ctx context.Context, project string, req ValidateRequest) (*ValidationResult, error) {
+	var result ValidationResult
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/projects/%s/validate", c.BaseURL, url.PathEscape(project)), req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TeamDefaults are the CLI defaults shared with a team code.
+type TeamDefaults struct {
+	// Org is the organization new members act on, empty for the default.
+	Org string `json:"org"`
+}
+
+// CreateTeamCode creates a team code sharing defaults, for onboarding team
+// members with "preview setup team". The returned code also carries the
+// server URL, so it is all a new machine needs. Requires the admin role.
+func (c *Client) CreateTeamCode(ctx context.Context, defaults TeamDefaults) (string, error) {
+	payload, err := json.Marshal(defaults)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("%s/api/config/team-codes", c.BaseURL), bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", httpError(resp)
+	}
+
+	var result struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode error: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(c.BaseURL + "#" + result.Code)), nil
+}
+
+// ParseTeamCode returns the server URL carried by a team code.
+func ParseTeamCode(code string) (apiURL string, err error) {
+	apiURL, _, err = splitTeamCode(code)
+	return apiURL, err
+}
+
+// splitTeamCode decodes a team code into the server URL and the code the
+// server knows.
+func splitTeamCode(code string) (apiURL, id string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(code))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid team code")
+	}
+	apiURL, id, ok := strings.Cut(string(raw), "#")
+	if !ok || id == "" || !(strings.HasPrefix(apiURL, "http://") || strings.HasPrefix(apiURL, "https://")) {
+		return "", "", fmt.Errorf("invalid team code")
+	}
+	return apiURL, id, nil
+}
+
+// GetTeamDefaults returns the defaults shared with a team code. The client
+// must point at the server the code carries (see ParseTeamCode); no token
+// is needed. Unknown codes return ErrNotFound.
+func (c *Client) GetTeamDefaults(ctx context.Context, code string) (*TeamDefaults, error) {
+	_, id, err := splitTeamCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("%s/api/cli/team/%s", c.BaseURL, id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("team code %w", ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var defaults TeamDefaults
+	if err := json.NewDecoder(resp.Body).Decode(&defaults); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &defaults, nil
+}
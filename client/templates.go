@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// TemplateSet is a set of scaffold templates an organization hosts on the
+// server, used by "preview setup project --template-source NAME" in place
+// of the built-in templates.
+type TemplateSet struct {
+	Name string `json:"name"`
+	// Files maps the paths of the templates, relative to the project root
+	// (e.g. "preview.yml"), to their content. Files left out keep the
+	// built-in template.
+	Files map[string]string `json:"files"`
+}
+
+// TemplateSetInfo describes a template set without the file contents.
+type TemplateSetInfo struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// ListTemplateSets returns the scaffold template sets of the server.
+func (c *Client) ListTemplateSets(ctx context.Context) ([]TemplateSetInfo, error) {
+	var result struct {
+		Templates []TemplateSetInfo `json:"templates"`
+	}
+	if err := c.doJSON(ctx, "GET", c.BaseURL+"/api/config/templates", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Templates, nil
+}
+
+// GetTemplateSet returns the scaffold template set name, or ErrNotFound.
+func (c *Client) GetTemplateSet(ctx context.Context, name string) (*TemplateSet, error) {
+	resp, err := c.doRequest(ctx, "GET", c.templateSetURL(name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("template set %s %w", name, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var set TemplateSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &set, nil
+}
+
+// PutTemplateSet creates or replaces the scaffold template set set.Name.
+// Requires the admin role.
+func (c *Client) PutTemplateSet(ctx context.Context, set TemplateSet) error {
+	return c.doJSON(ctx, "PUT", c.templateSetURL(set.Name), map[string]interface{}{"files": set.Files}, nil)
+}
+
+// DeleteTemplateSet removes the scaffold template set name. Requires the
+// admin role.
+func (c *Client) DeleteTemplateSet(ctx context.Context, name string) error {
+	return c.doJSON(ctx, "DELETE", c.templateSetURL(name), nil, nil)
+}
+
+func (c *Client) templateSetURL(name string) string {
+	return fmt.Sprintf("%s/api/config/templates/%s", c.BaseURL, url.PathEscape(name))
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Terminal is the local side of an interactive session. Stdin is forwarded
+// to the remote PTY as typed and the PTY output is written to Stdout.
+type Terminal struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Cols   int
+	Rows   int
+}
+
+// terminalMessage is the JSON frame used by the terminal websocket in both
+// directions.
+type terminalMessage struct {
+	Type    string `json:"type"`
+	Data    string `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+	Code    *int   `json:"code,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+}
+
+// DrushInteractive runs drush in a PTY inside the preview's PHP container and
+// bridges it to term, so prompts like "Import the listed configuration
+// changes? (y/n)" reach the user. It returns drush's exit code.
+func (c *Client) DrushInteractive(ctx context.Context, project, previewName, args string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "drush", Arg: args}, term)
+}
+
+// ComposerInteractive runs composer in a PTY inside the preview's PHP
+// container, streaming its output to term. It returns composer's exit code.
+func (c *Client) ComposerInteractive(ctx context.Context, project, previewName, args string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "composer", Arg: args}, term)
+}
+
+// RunTestSuite runs the command of the preview.yml test suite named suite
+// in a PTY inside the preview's PHP container, streaming its output to term.
+// It returns the command's exit code.
+func (c *Client) RunTestSuite(ctx context.Context, project, previewName, suite string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "test", Arg: suite}, term)
+}
+
+// RunDeployScript re-runs the project deploy script of phase ("new" or
+// "update") in a PTY inside the preview's PHP container, streaming its
+// output to term. Nothing else of a deployment runs. It returns the
+// script's exit code.
+func (c *Client) RunDeployScript(ctx context.Context, project, previewName, phase string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "deploy", Arg: phase}, term)
+}
+
+// RedisCLI runs redis-cli with args in a PTY inside the preview's redis
+// container, interactively when args is empty. The preview needs the
+// redis service enabled in preview.yml. It returns redis-cli's exit code.
+func (c *Client) RedisCLI(ctx context.Context, project, previewName, args string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "redis", Arg: args}, term)
+}
+
+// SolrReindex queues every Search API item of the preview for reindexing
+// and indexes them with drush, streaming the output to term. The preview
+// needs the solr service enabled in preview.yml. It returns drush's exit
+// code.
+func (c *Client) SolrReindex(ctx context.Context, project, previewName string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Program: "solr", Arg: "reindex"}, term)
+}
+
+// SolrQuery runs the Solr query q on the preview's Solr core, writing the
+// JSON response with up to rows documents to term. The preview needs the
+// solr service enabled in preview.yml. It returns the exit code of the
+// request in the solr container.
+func (c *Client) SolrQuery(ctx context.Context, project, previewName, q string, rows int, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{
+		Project: project, Preview: previewName, Program: "solr", Arg: "query",
+		Options: map[string]string{"q": q, "rows": strconv.Itoa(rows)},
+	}, term)
+}
+
+// LogSeverities are the severities of log entries, worst first (RFC 5424,
+// as drush watchdog uses).
+var LogSeverities = []string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
+
+// Logs follows a log of a preview, writing it to term until ctx is done:
+// "container" (the output of its PHP container: web server and startup),
+// "php" (the PHP error log) or "watchdog" (drush watchdog:tail). With
+// severity, one of LogSeverities, the php and watchdog logs only show
+// entries of that severity or worse.
+func (c *Client) Logs(ctx context.Context, project, previewName, log, severity string, term Terminal) (int, error) {
+	req := StreamRequest{Project: project, Preview: previewName, Program: "logs", Arg: log}
+	if severity != "" {
+		req.Options = map[string]string{"severity": severity}
+	}
+	return c.stream(ctx, req, term)
+}
+
+// Shell opens an interactive bash shell in the container of service
+// ("php" when empty) of a preview. It returns the shell's exit code.
+func (c *Client) Shell(ctx context.Context, project, previewName, service string, term Terminal) (int, error) {
+	return c.stream(ctx, StreamRequest{Project: project, Preview: previewName, Container: service}, term)
+}
+
+// stream runs req through c.Streamer, or the terminal websocket.
+func (c *Client) stream(ctx context.Context, req StreamRequest, term Terminal) (int, error) {
+	if c.Streamer != nil {
+		return c.Streamer.Stream(ctx, req, term)
+	}
+	return WebSocketStreamer{Client: c}.Stream(ctx, req, term)
+}
+
+// StreamRequest selects what an interactive session runs in a preview.
+type StreamRequest struct {
+	Project string
+	Preview string
+	// Program is drush, composer, test, deploy, redis, solr or logs. Empty
+	// opens a bash shell.
+	Program string
+	// Arg is the argument of Program: the drush, composer or redis-cli
+	// arguments, the test suite, the deploy phase, the solr operation or
+	// the log.
+	Arg string
+	// Container is the service the session runs in, "php" when empty.
+	// Ignored by programs that pick theirs, like redis.
+	Container string
+	// Options are extra parameters of Program, e.g. the q and rows of a
+	// solr query or the severity of logs.
+	Options map[string]string
+}
+
+// Streamer runs interactive sessions in previews, bridging them to a local
+// terminal until the remote program exits, and returns its exit code.
+// One-shot operations don't go through it; they are plain HTTP requests.
+// WebSocketStreamer is the only transport the server speaks; other
+// implementations wrap it, e.g. to record or proxy sessions.
+type Streamer interface {
+	Stream(ctx context.Context, req StreamRequest, term Terminal) (int, error)
+}
+
+// WebSocketStreamer is the default Streamer: it runs sessions over the
+// terminal websocket of the server, authenticated like Client.
+type WebSocketStreamer struct {
+	Client *Client
+}
+
+// Stream opens the terminal websocket running the program of req and
+// bridges it to term until the program exits.
+func (s WebSocketStreamer) Stream(ctx context.Context, req StreamRequest, term Terminal) (int, error) {
+	c := s.Client
+	query := url.Values{}
+	if req.Program != "" {
+		query.Set(req.Program, req.Arg)
+	}
+	if req.Container != "" {
+		query.Set("container", req.Container)
+	}
+	for k, v := range req.Options {
+		query.Set(k, v)
+	}
+	query.Set("token", c.token())
+	if c.Org != "" {
+		query.Set("org", c.Org)
+	}
+	wsURL := fmt.Sprintf("%s/ws/previews/%s/%s/terminal?%s", websocketBase(c.BaseURL), req.Project, req.Preview, query.Encode())
+
+	header := http.Header{}
+	header.Set(apiVersionHeader, fmt.Sprintf("%d-%d", MinAPIVersion, MaxAPIVersion))
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig, dialer.Proxy = c.tlsConfig()
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return -1, ErrNotAuthenticated
+		}
+		if resp != nil {
+			if verr := apiVersionError(resp); verr != nil {
+				return -1, verr
+			}
+		}
+		if u, perr := url.Parse(wsURL); perr == nil {
+			err = tlsError(u.Host, err)
+		}
+		return -1, fmt.Errorf("failed to open terminal: %w", err)
+	}
+	defer conn.Close()
+
+	if term.Cols > 0 && term.Rows > 0 {
+		conn.WriteJSON(terminalMessage{Type: "resize", Cols: term.Cols, Rows: term.Rows})
+	}
+
+	// Forward local input. The goroutine may outlive the session while
+	// blocked on Stdin; writes after close just fail silently.
+	if term.Stdin != nil {
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, err := term.Stdin.Read(buf)
+				if n > 0 {
+					if conn.WriteJSON(terminalMessage{Type: "input", Data: string(buf[:n])}) != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var msg terminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			return -1, fmt.Errorf("terminal connection closed: %w", err)
+		}
+		switch msg.Type {
+		case "output":
+			io.WriteString(term.Stdout, msg.Data)
+		case "exit":
+			if msg.Code == nil {
+				return -1, nil
+			}
+			return *msg.Code, nil
+		case "error":
+			return -1, fmt.Errorf("%s", msg.Message)
+		}
+	}
+}
+
+// websocketBase converts the API base URL (http/https) to ws/wss.
+func websocketBase(baseURL string) string {
+	switch {
+	case strings.HasPrefix(baseURL, "https://"):
+		return "wss://" + strings.TrimPrefix(baseURL, "https://")
+	case strings.HasPrefix(baseURL, "http://"):
+		return "ws://" + strings.TrimPrefix(baseURL, "http://")
+	}
+	return baseURL
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// TLSOptions configure how a transport made by NewTransport verifies the
+// certificate of the server, e.g. behind a corporate proxy that intercepts
+// TLS with its own CA.
+type TLSOptions struct {
+	// CACertFile is a PEM file of CA certificates trusted in addition to
+	// the system ones.
+	CACertFile string
+	// InsecureSkipVerify accepts any certificate. It defeats TLS and is
+	// only meant to diagnose certificate problems.
+	InsecureSkipVerify bool
+}
+
+// NewTransport returns a transport like http.DefaultTransport, which goes
+// through the proxy of HTTPS_PROXY, HTTP_PROXY and NO_PROXY and asks for
+// gzip, that verifies certificates as set by opts. Set it as the
+// Transport of Client.HTTPClient; the terminal websocket uses its proxy
+// and TLS configuration too.
+func NewTransport(opts TLSOptions) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", opts.CACertFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	return t, nil
+}
+
+// TLSError is returned when the TLS connection to the server fails, e.g.
+// because a proxy intercepting TLS presents a certificate signed by a CA
+// the client doesn't trust. Err is the error of the TLS handshake, which
+// errors.As finds the x509 errors in.
+type TLSError struct {
+	Host string
+	Err  error
+}
+
+func (e *TLSError) Error() string {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(e.Err, &unknownAuthority):
+		return fmt.Sprintf("TLS error: the certificate of %s is signed by an unknown authority", e.Host)
+	case errors.As(e.Err, &hostname):
+		return fmt.Sprintf("TLS error: the certificate of %s isn't valid for that name: %v", e.Host, e.Err)
+	case errors.As(e.Err, &invalid) && invalid.Reason == x509.Expired:
+		return fmt.Sprintf("TLS error: the certificate of %s has expired or isn't valid yet (check the system clock)", e.Host)
+	case errors.As(e.Err, &recordHeader):
+		return fmt.Sprintf("TLS error: %s doesn't speak TLS (check whether the URL should use http)", e.Host)
+	}
+	return fmt.Sprintf("TLS error connecting to %s: %v", e.Host, e.Err)
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// tlsError wraps err in a *TLSError if it is a failed TLS handshake with
+// host, and returns it unchanged otherwise.
+func tlsError(host string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var (
+		verification *tls.CertificateVerificationError
+		unknownAuth  x509.UnknownAuthorityError
+		hostname     x509.HostnameError
+		invalid      x509.CertificateInvalidError
+		recordHeader tls.RecordHeaderError
+	)
+	if !errors.As(err, &verification) && !errors.As(err, &unknownAuth) && !errors.As(err, &hostname) &&
+		!errors.As(err, &invalid) && !errors.As(err, &recordHeader) {
+		return err
+	}
+	return &TLSError{Host: host, Err: err}
+}
+
+// tlsConfig returns the TLS configuration and proxy of the transport of
+// c.HTTPClient, for connections made outside of it.
+func (c *Client) tlsConfig() (*tls.Config, func(*http.Request) (*url.URL, error)) {
+	if t, ok := c.HTTPClient.Transport.(*http.Transport); ok {
+		return t.TLSClientConfig, t.Proxy
+	}
+	return nil, http.ProxyFromEnvironment
+}
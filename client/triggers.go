@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Trigger actions.
+const (
+	TriggerRebuild = "rebuild"
+	TriggerDrush   = "drush"
+	TriggerCron    = "cron"
+)
+
+// TriggerRequest creates a trigger of a preview.
+type TriggerRequest struct {
+	// Action is TriggerRebuild, TriggerDrush or TriggerCron.
+	Action string `json:"action"`
+	// Args is the drush command run by a TriggerDrush trigger, e.g.
+	// "search-api:index".
+	Args string `json:"args,omitempty"`
+	Name string `json:"name,omitempty"`
+	// ExpiresIn defaults to 30 days on the server, and is at most 365 days.
+	ExpiresIn time.Duration `json:"-"`
+}
+
+// Trigger is a secret URL that runs one action on one preview without a
+// user token, for external systems such as cron services or the CI of
+// another project.
+type Trigger struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Args   string `json:"args"`
+	// TokenPrefix is the start of the token in its URL, to tell triggers
+	// apart.
+	TokenPrefix string  `json:"token_prefix"`
+	CreatedBy   string  `json:"created_by"`
+	CreatedAt   string  `json:"created_at"`
+	ExpiresAt   string  `json:"expires_at"`
+	LastUsedAt  *string `json:"last_used_at"`
+	UseCount    int     `json:"use_count"`
+	// URL is only set by CreateTrigger: the server only keeps a hash of
+	// its token. Cron triggers can be called with GET, the others need
+	// POST.
+	URL string `json:"-"`
+}
+
+// CreateTrigger creates a trigger of a preview. Requires the manager role.
+func (c *Client) CreateTrigger(ctx context.Context, project, previewName string, req TriggerRequest) (*Trigger, error) {
+	body := map[string]interface{}{"action": req.Action, "args": req.Args, "name": req.Name}
+	if req.ExpiresIn > 0 {
+		body["expires_in"] = int(req.ExpiresIn.Seconds())
+	}
+	var result struct {
+		Trigger
+		Path string `json:"path"`
+	}
+	if err := c.doJSON(ctx, "POST", fmt.Sprintf("%s/api/previews/%s/%s/triggers", c.BaseURL, project, previewName), body, &result); err != nil {
+		return nil, err
+	}
+	result.Trigger.URL = c.BaseURL + result.Path
+	return &result.Trigger, nil
+}
+
+// ListTriggers returns the triggers of a preview, newest first, expired
+// ones included. Requires the manager role.
+func (c *Client) ListTriggers(ctx context.Context, project, previewName string) ([]Trigger, error) {
+	var triggers []Trigger
+	if err := c.doJSON(ctx, "GET", fmt.Sprintf("%s/api/previews/%s/%s/triggers", c.BaseURL, project, previewName), nil, &triggers); err != nil {
+		return nil, err
+	}
+	return triggers, nil
+}
+
+// DeleteTrigger revokes a trigger of a preview: its URL stops working at
+// once. Requires the manager role.
+func (c *Client) DeleteTrigger(ctx context.Context, project, previewName string, id int) error {
+	return c.doJSON(ctx, "DELETE", fmt.Sprintf("%s/api/previews/%s/%s/triggers/%d", c.BaseURL, project, previewName, id), nil, nil)
+}
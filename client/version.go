@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// VersionInfo describes the CLI releases a server publishes and the API it
+// speaks.
+type VersionInfo struct {
+	// Version is the latest CLI release published by the server on
+	// Channel.
+	Version string `json:"version"`
+	// Channel is the release channel, "stable" or "beta". Servers that
+	// predate channels leave it empty.
+	Channel string `json:"channel"`
+	// RequiredVersion is the oldest CLI release the server admins allow,
+	// to turn away releases known to be broken. Empty allows all.
+	RequiredVersion string `json:"required_version"`
+	// MinAPIVersion and MaxAPIVersion are the range of API versions the
+	// server speaks, zero if it doesn't say.
+	MinAPIVersion int `json:"min_api_version"`
+	MaxAPIVersion int `json:"max_api_version"`
+}
+
+// GetVersionInfo returns the CLI versions published by the server on
+// channel ("stable" or "beta", empty for stable) and the API versions it
+// speaks. It works whatever API version the client speaks.
+func (c *Client) GetVersionInfo(ctx context.Context, channel string) (*VersionInfo, error) {
+	u := fmt.Sprintf("%s/api/cli/version", c.BaseURL)
+	if channel != "" {
+		u += "?channel=" + url.QueryEscape(channel)
+	}
+	resp, err := c.doRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check version: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to check version (HTTP %d)", resp.StatusCode)
+	}
+
+	var info VersionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to parse version: %w", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// XdebugRequest turns Xdebug of a preview on or off. Turned on, debug
+// sessions connect to ClientHost:ClientPort, which the preview's PHP
+// container must be able to reach; RelayXdebug works from anywhere.
+type XdebugRequest struct {
+	Enabled    bool   `json:"enabled"`
+	ClientHost string `json:"client_host,omitempty"`
+	// ClientPort defaults to 9003.
+	ClientPort int `json:"client_port,omitempty"`
+}
+
+// XdebugInfo is how Xdebug of a preview is set up.
+type XdebugInfo struct {
+	Enabled    bool   `json:"enabled"`
+	IDEKey     string `json:"ide_key"`
+	ClientHost string `json:"client_host"`
+	ClientPort int    `json:"client_port"`
+	// DocumentRoot is where the code of the preview is in its PHP
+	// container, the server side of IDE path mappings.
+	DocumentRoot string `json:"document_root"`
+}
+
+// SetXdebug turns Xdebug of the PHP container of a preview on or off.
+// PHP restarts, aborting running requests.
+func (c *Client) SetXdebug(ctx context.Context, project, previewName string, req XdebugRequest) (*XdebugInfo, error) {
+	url := fmt.Sprintf("%s/api/previews/%s/%s/xdebug", c.BaseURL, project, previewName)
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doRequest(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("preview %s/%s %w", project, previewName, ErrNotFound)
+	}
+	if resp.StatusCode != 200 {
+		return nil, httpError(resp)
+	}
+
+	var result XdebugInfo
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	return &result, nil
+}
+
+// RelayXdebug turns Xdebug of a preview on, with its debug sessions relayed
+// through the server's xdebug websocket: each session is a connection
+// accepted from the returned relay. Closing the relay turns Xdebug off.
+func (c *Client) RelayXdebug(ctx context.Context, project, previewName string) (*XdebugRelay, error) {
+	conn, err := c.dialPreviewWebsocket(ctx, project, previewName, "xdebug", "Xdebug relay")
+	if err != nil {
+		return nil, err
+	}
+
+	var ready struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		XdebugInfo
+	}
+	if err := conn.ReadJSON(&ready); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Xdebug relay closed: %w", err)
+	}
+	if ready.Type == "error" {
+		conn.Close()
+		return nil, fmt.Errorf("%s", ready.Message)
+	}
+	info := ready.XdebugInfo
+	info.Enabled = true
+
+	r := &XdebugRelay{
+		Info:    info,
+		ws:      conn,
+		conns:   make(map[uint32]*relayConn),
+		accept:  make(chan *relayConn, 16),
+		closed:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go r.readLoop()
+	return r, nil
+}
+
+// XdebugRelay is a net.Listener of the debug sessions of a preview,
+// relayed by the server (see RelayXdebug).
+type XdebugRelay struct {
+	Info XdebugInfo
+
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	conns map[uint32]*relayConn
+
+	accept    chan *relayConn
+	closed    chan struct{}
+	closeOnce sync.Once
+	// stopped is closed when the websocket ends, err saying why
+	stopped chan struct{}
+	err     error
+}
+
+// Accept waits for the next debug session.
+func (r *XdebugRelay) Accept() (net.Conn, error) {
+	select {
+	case c := <-r.accept:
+		return c, nil
+	case <-r.closed:
+		return nil, net.ErrClosed
+	case <-r.stopped:
+		if r.err != nil {
+			return nil, fmt.Errorf("Xdebug relay closed: %w", r.err)
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close ends the relay, its sessions, and turns Xdebug of the preview off.
+func (r *XdebugRelay) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.writeMu.Lock()
+		r.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		r.writeMu.Unlock()
+		r.ws.Close()
+	})
+	return nil
+}
+
+// Addr returns the address of the server end of the websocket.
+func (r *XdebugRelay) Addr() net.Addr { return r.ws.RemoteAddr() }
+
+// Done is closed when the relay ends, closed or because the server went
+// away.
+func (r *XdebugRelay) Done() <-chan struct{} { return r.stopped }
+
+func (r *XdebugRelay) readLoop() {
+	defer func() {
+		r.mu.Lock()
+		for _, c := range r.conns {
+			c.pw.Close()
+		}
+		r.conns = nil
+		r.mu.Unlock()
+		close(r.stopped)
+	}()
+	for {
+		kind, data, err := r.ws.ReadMessage()
+		if err != nil {
+			select {
+			case <-r.closed:
+			default:
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					r.err = err
+				}
+			}
+			return
+		}
+		if kind != websocket.BinaryMessage || len(data) < 4 {
+			continue
+		}
+		id := binary.BigEndian.Uint32(data)
+		payload := data[4:]
+
+		r.mu.Lock()
+		c, ok := r.conns[id]
+		if !ok && len(payload) > 0 {
+			c = r.newConn(id)
+			r.conns[id] = c
+		}
+		r.mu.Unlock()
+		switch {
+		case c == nil:
+		case len(payload) == 0:
+			// Closed by Xdebug
+			r.mu.Lock()
+			delete(r.conns, id)
+			r.mu.Unlock()
+			c.pw.Close()
+		case !ok:
+			select {
+			case r.accept <- c:
+			case <-r.closed:
+				return
+			}
+			c.pw.Write(payload)
+		default:
+			c.pw.Write(payload)
+		}
+	}
+}
+
+func (r *XdebugRelay) newConn(id uint32) *relayConn {
+	pr, pw := io.Pipe()
+	return &relayConn{relay: r, id: id, pr: pr, pw: pw}
+}
+
+func (r *XdebugRelay) send(id uint32, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, id)
+	copy(frame[4:], payload)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	return r.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// relayConn is a debug session relayed by an XdebugRelay.
+type relayConn struct {
+	relay     *XdebugRelay
+	id        uint32
+	pr        *io.PipeReader
+	pw        *io.PipeWriter
+	closeOnce sync.Once
+}
+
+func (c *relayConn) Read(p []byte) (int, error) { return c.pr.Read(p) }
+
+func (c *relayConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := c.relay.send(c.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *relayConn) Close() error {
+	// Kept in the relay's connections until the server confirms, so
+	// bytes still on their way aren't taken for a new session
+	c.closeOnce.Do(func() {
+		c.pr.Close()
+		c.relay.send(c.id, nil)
+	})
+	return nil
+}
+
+func (c *relayConn) LocalAddr() net.Addr  { return c.relay.ws.LocalAddr() }
+func (c *relayConn) RemoteAddr() net.Addr { return c.relay.ws.RemoteAddr() }
+
+var errRelayDeadline = errors.New("deadlines aren't supported on relayed Xdebug sessions")
+
+func (c *relayConn) SetDeadline(t time.Time) error      { return errRelayDeadline }
+func (c *relayConn) SetReadDeadline(t time.Time) error  { return errRelayDeadline }
+func (c *relayConn) SetWriteDeadline(t time.Time) error { return errRelayDeadline }
@@ -6,16 +6,25 @@ Router aggregator - imports all sub-routers and re-exports a single `router`.
 
 from fastapi import APIRouter
 
-from app.routes import auth, base_files, cli, config, gitlab, previews, webhooks
+from app.routes import allowlist, auth, base_files, capabilities, cli, config, domains, gitlab, info, mail, plan, previews, projects, triggers, validate, webhooks
 from app import websockets
 
 router = APIRouter()
 
+router.include_router(allowlist.router)
 router.include_router(auth.router)
 router.include_router(base_files.router)
+router.include_router(capabilities.router)
 router.include_router(cli.router)
 router.include_router(config.router)
+router.include_router(domains.router)
 router.include_router(gitlab.router)
+router.include_router(info.router)
+router.include_router(mail.router)
+router.include_router(plan.router)
 router.include_router(previews.router)
+router.include_router(projects.router)
+router.include_router(triggers.router)
+router.include_router(validate.router)
 router.include_router(webhooks.router)
 router.include_router(websockets.router)
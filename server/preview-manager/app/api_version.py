@@ -0,0 +1,74 @@
+"""API version negotiation.
+
+Clients send the range of API versions they speak in the
+X-Preview-API-Version header (e.g. "1-2"). When it doesn't overlap the
+versions this server speaks, the request is answered with 426 and the
+supported range, so clients can tell users to upgrade instead of failing
+to decode responses they don't understand. Requests without the header
+(the web UI, older CLIs) are always let through, and so are the CLI
+distribution and capabilities endpoints, which a mismatched CLI needs to
+fix itself with self-update.
+"""
+
+import logging
+
+from starlette.middleware.base import BaseHTTPMiddleware
+from starlette.requests import Request
+from starlette.responses import JSONResponse
+
+from app.routes.cli import VERSION_FILE
+
+logger = logging.getLogger(__name__)
+
+API_VERSION_HEADER = "X-Preview-API-Version"
+MIN_API_VERSION = 1
+MAX_API_VERSION = 1
+
+EXEMPT_PREFIXES = ("/api/cli/", "/api/capabilities")
+
+
+def parse_version_range(value: str) -> tuple[int, int] | None:
+    """Parse "N" or "MIN-MAX" into a (min, max) tuple."""
+    low, _, high = value.strip().partition("-")
+    try:
+        low_v = int(low)
+        high_v = int(high) if high else low_v
+    except ValueError:
+        return None
+    if low_v > high_v:
+        return None
+    return low_v, high_v
+
+
+class APIVersionMiddleware(BaseHTTPMiddleware):
+    """Reject API requests from clients that speak no supported version."""
+
+    async def dispatch(self, request: Request, call_next):
+        value = request.headers.get(API_VERSION_HEADER)
+        path = request.url.path
+        if value is None or not path.startswith("/api/") or path.startswith(EXEMPT_PREFIXES):
+            return await call_next(request)
+
+        client_range = parse_version_range(value)
+        if client_range is None:
+            return JSONResponse(
+                {"detail": f"Invalid {API_VERSION_HEADER} header: {value!r}"},
+                status_code=400,
+            )
+
+        client_min, client_max = client_range
+        if client_max >= MIN_API_VERSION and client_min <= MAX_API_VERSION:
+            return await call_next(request)
+
+        logger.info(f"Rejecting client speaking API {value} (server speaks {MIN_API_VERSION}-{MAX_API_VERSION})")
+        cli_version = VERSION_FILE.read_text().strip() if VERSION_FILE.exists() else ""
+        return JSONResponse(
+            {
+                "detail": f"API version {value} is not supported; this server speaks {MIN_API_VERSION}-{MAX_API_VERSION}",
+                "code": "api_version_mismatch",
+                "min_version": MIN_API_VERSION,
+                "max_version": MAX_API_VERSION,
+                "cli_version": cli_version,
+            },
+            status_code=426,
+        )
@@ -71,10 +71,19 @@ async def list_users() -> list[dict]:
     db = await get_db()
     try:
         cur = await db.execute(
-            "SELECT u.*, r.role FROM users u LEFT JOIN roles r ON u.id = r.user_id ORDER BY u.id"
+            "SELECT u.id, u.email, u.name, u.avatar_url, u.created_at, u.updated_at, r.role "
+            "FROM users u LEFT JOIN roles r ON u.id = r.user_id ORDER BY u.id"
         )
-        rows = await cur.fetchall()
-        return [dict(r) for r in rows]
+        users = [dict(r) for r in await cur.fetchall()]
+
+        # Projects each user was given access to (admins see all anyway)
+        cur = await db.execute("SELECT user_id, project_slug FROM project_members ORDER BY project_slug")
+        projects: dict[int, list[str]] = {}
+        for row in await cur.fetchall():
+            projects.setdefault(row["user_id"], []).append(row["project_slug"])
+        for user in users:
+            user["projects"] = projects.get(user["id"], [])
+        return users
     finally:
         await db.close()
 
@@ -277,6 +286,20 @@ async def validate_api_token(raw_token: str) -> Optional[dict]:
         await db.close()
 
 
+async def get_api_token(raw_token: str) -> Optional[dict]:
+    """Return the token row of raw_token without marking it used."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            "SELECT id, user_id, name, token_prefix, created_at, last_used_at FROM api_tokens WHERE token_hash = ?",
+            (_hash_token(raw_token),),
+        )
+        row = await cur.fetchone()
+        return dict(row) if row else None
+    finally:
+        await db.close()
+
+
 async def list_api_tokens(user_id: int) -> list[dict]:
     db = await get_db()
     try:
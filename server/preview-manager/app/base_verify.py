@@ -0,0 +1,178 @@
+"""Integrity checks of the stored base database and files.
+
+Corruption (a truncated upload, a disk error) otherwise only shows when a
+preview is created from the base. Each check reads the whole file once:
+the gzip stream is decompressed to its trailer, which checks its CRC, and
+the SHA-256 is compared with the one recorded when it was uploaded.
+"""
+
+import gzip
+import hashlib
+import tarfile
+import zlib
+from pathlib import Path
+
+from pydantic import BaseModel
+
+# How a dump may start: mysqldump/mariadb-dump comments and conditional
+# comments, or the statements of dumps made without them
+SQL_PREFIXES = (b"--", b"/*", b"SET ", b"DROP ", b"CREATE ", b"LOCK ", b"INSERT ", b"USE ", b"START ")
+
+
+class BaseFileCheck(BaseModel):
+    kind: str
+    # ok, warning, failed or missing
+    status: str
+    message: str
+    size_bytes: int = 0
+    uncompressed_bytes: int = 0
+    # Tables created by a database dump
+    tables: int = 0
+    # Files and directories in a files archive
+    entries: int = 0
+    sha256: str = ""
+
+
+class _HashingReader:
+    """Reads a file, hashing what is read."""
+
+    def __init__(self, f):
+        self.f = f
+        self.hash = hashlib.sha256()
+        self.size = 0
+
+    def read(self, size=-1):
+        data = self.f.read(size)
+        self.hash.update(data)
+        self.size += len(data)
+        return data
+
+
+def _checksum_problem(check: BaseFileCheck, recorded_sha: str | None) -> str | None:
+    if recorded_sha and check.sha256 != recorded_sha:
+        return (f"SHA-256 {check.sha256[:12]} differs from the last upload ({recorded_sha[:12]}): "
+                "the file changed on disk after it was uploaded")
+    return None
+
+
+def _sentence(problems: list[str]) -> str:
+    message = "; ".join(problems)
+    return message[0].upper() + message[1:]
+
+
+def verify_db(path: Path, recorded_sha: str | None, kind: str = "db") -> BaseFileCheck:
+    """Check that a base database dump is a complete gzip stream of SQL."""
+    check = BaseFileCheck(kind=kind, status="ok", message="")
+    if not path.exists():
+        check.status, check.message = "missing", "No base database uploaded"
+        return check
+
+    head = b""
+    tail = b""
+    with open(path, "rb") as f:
+        raw = _HashingReader(f)
+        try:
+            with gzip.GzipFile(fileobj=raw) as gz:
+                while chunk := gz.read(1024 * 1024):
+                    if len(head) < 4096:
+                        head += chunk[:4096 - len(head)]
+                    # Keep the end of the previous chunk so statements
+                    # split across chunks are counted
+                    data = tail + chunk
+                    check.tables += data.count(b"CREATE TABLE")
+                    tail = data[-16:]
+                    check.tables -= tail.count(b"CREATE TABLE")
+                    check.uncompressed_bytes += len(chunk)
+                check.tables += tail.count(b"CREATE TABLE")
+        except (OSError, EOFError, zlib.error) as e:
+            check.status = "failed"
+            check.message = f"Corrupt gzip stream after {check.uncompressed_bytes} bytes: {e}"
+    check.size_bytes = raw.size
+    check.sha256 = raw.hash.hexdigest()
+    if check.status == "failed":
+        return check
+
+    problems = []
+    if not head.lstrip().startswith(SQL_PREFIXES):
+        problems.append("it does not start like an SQL dump")
+    if check.tables == 0:
+        problems.append("it creates no tables")
+    if checksum := _checksum_problem(check, recorded_sha):
+        problems.append(checksum)
+    if problems:
+        check.status = "failed"
+        check.message = _sentence(problems)
+    else:
+        check.message = f"Complete gzip stream of SQL creating {check.tables} tables"
+    return check
+
+
+def verify_files(archive: Path, extracted: Path, recorded_sha: str | None) -> BaseFileCheck:
+    """Check that the base files archive lists completely and was extracted."""
+    check = BaseFileCheck(kind="files", status="ok", message="")
+    if not archive.exists():
+        if extracted.exists():
+            check.status = "warning"
+            check.message = "Archive not kept on the server (uploaded by an older version); only the extracted files exist"
+        else:
+            check.status, check.message = "missing", "No base files uploaded"
+        return check
+
+    unsafe = []
+    with open(archive, "rb") as f:
+        raw = _HashingReader(f)
+        try:
+            with gzip.GzipFile(fileobj=raw) as gz:
+                with tarfile.open(fileobj=gz, mode="r|") as tar:
+                    for member in tar:
+                        check.entries += 1
+                        check.uncompressed_bytes += member.size
+                        if member.name.startswith("/") or ".." in Path(member.name).parts:
+                            unsafe.append(member.name)
+                # Read up to the gzip trailer, which checks the CRC
+                while gz.read(1024 * 1024):
+                    pass
+        except (OSError, EOFError, zlib.error, tarfile.TarError) as e:
+            check.status = "failed"
+            check.message = f"Corrupt archive after {check.entries} entries: {e}"
+    check.size_bytes = raw.size
+    check.sha256 = raw.hash.hexdigest()
+    if check.status == "failed":
+        return check
+
+    problems = []
+    if unsafe:
+        problems.append(f"{len(unsafe)} entries point outside the files directory, e.g. {unsafe[0]}")
+    if not extracted.exists():
+        problems.append("it was never extracted, so previews get no files")
+    if checksum := _checksum_problem(check, recorded_sha):
+        problems.append(checksum)
+    if problems:
+        check.status = "failed"
+        check.message = _sentence(problems)
+    else:
+        check.message = f"Complete archive of {check.entries} entries"
+    return check
+
+
+def verify_encrypted(kind: str, path: Path, recorded_sha: str | None) -> BaseFileCheck:
+    """Encrypted bases can't be read without the key: only their checksum
+    is checked."""
+    check = BaseFileCheck(kind=kind, status="ok", message="")
+    if not path.exists():
+        check.status, check.message = "missing", "Not uploaded"
+        return check
+
+    with open(path, "rb") as f:
+        raw = _HashingReader(f)
+        while raw.read(1024 * 1024):
+            pass
+    check.size_bytes = raw.size
+    check.sha256 = raw.hash.hexdigest()
+    if problem := _checksum_problem(check, recorded_sha):
+        check.status, check.message = "failed", _sentence([problem])
+    elif recorded_sha:
+        check.message = "Checksum matches the last upload; the content is encrypted and can't be inspected"
+    else:
+        check.message = "No upload recorded to compare with; the content is encrypted and can't be inspected"
+    return check
@@ -133,6 +133,48 @@ CREATE TABLE IF NOT EXISTS deployments (
 );
 """
 
+TRIGGERS_SCHEMA = """
+CREATE TABLE IF NOT EXISTS triggers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    preview_id INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    args TEXT NOT NULL DEFAULT '',
+    token_hash TEXT UNIQUE NOT NULL,
+    token_prefix TEXT NOT NULL,
+    created_by TEXT,
+    created_at TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    last_used_at TEXT,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (preview_id) REFERENCES previews(id) ON DELETE CASCADE
+);
+"""
+
+IP_ALLOWLIST_SCHEMA = """
+CREATE TABLE IF NOT EXISTS ip_allowlist (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project TEXT NOT NULL,
+    preview_id INTEGER,
+    cidr TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_by TEXT,
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (preview_id) REFERENCES previews(id) ON DELETE CASCADE
+);
+"""
+
+PREVIEW_DOMAINS_SCHEMA = """
+CREATE TABLE IF NOT EXISTS preview_domains (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    preview_id INTEGER NOT NULL,
+    hostname TEXT UNIQUE NOT NULL,
+    created_by TEXT,
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (preview_id) REFERENCES previews(id) ON DELETE CASCADE
+);
+"""
+
 
 def _now() -> str:
     return datetime.now(timezone.utc).isoformat()
@@ -158,6 +200,9 @@ async def init_db():
         await db.executescript(AUTH_SCHEMA)
         await db.executescript(PREVIEWS_SCHEMA)
         await db.executescript(DEPLOYMENTS_SCHEMA)
+        await db.executescript(TRIGGERS_SCHEMA)
+        await db.executescript(IP_ALLOWLIST_SCHEMA)
+        await db.executescript(PREVIEW_DOMAINS_SCHEMA)
         await db.executescript(PROJECT_MEMBERS_SCHEMA)
         await db.executescript(CONFIG_SCHEMA)
 
@@ -341,6 +386,22 @@ async def upsert_preview(project: str, preview_name: str, **fields) -> dict:
         await db.close()
 
 
+async def rename_preview_in_db(project: str, preview_name: str, new_name: str, **fields):
+    """Rename a preview, updating the given fields too. Its deployments
+    follow it, as they reference the preview by id."""
+    sets = ["preview_name = ?"] + [f"{k} = ?" for k in fields]
+    vals = [new_name, *fields.values(), project, preview_name]
+    db = await get_db()
+    try:
+        await db.execute(
+            f"UPDATE previews SET {', '.join(sets)} WHERE project = ? AND preview_name = ?",
+            vals,
+        )
+        await db.commit()
+    finally:
+        await db.close()
+
+
 async def delete_preview_from_db(project: str, preview_name: str):
     db = await get_db()
     try:
@@ -394,6 +455,17 @@ async def has_running_deployment(preview_id: int) -> bool:
         await db.close()
 
 
+async def count_running_deployments() -> int:
+    """Count deployments in progress across all previews."""
+    db = await get_db()
+    try:
+        cur = await db.execute("SELECT COUNT(*) FROM deployments WHERE status = 'running'")
+        row = await cur.fetchone()
+        return row[0]
+    finally:
+        await db.close()
+
+
 # ---- Deployment CRUD ----
 
 async def create_deployment(preview_id: int, triggered_by: str | None = None) -> int:
@@ -460,3 +532,230 @@ async def list_deployments(preview_id: int, limit: int = 50) -> list[dict]:
         return [dict(r) for r in rows]
     finally:
         await db.close()
+
+
+# ---- Trigger CRUD ----
+# Triggers reference their preview by id, so they follow it when it's
+# renamed and go away with it.
+
+async def create_trigger(
+    preview_id: int,
+    action: str,
+    args: str,
+    name: str,
+    token_hash: str,
+    token_prefix: str,
+    created_by: str | None,
+    expires_at: str,
+) -> int:
+    """Create a trigger. Returns the trigger id."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """INSERT INTO triggers
+                   (preview_id, name, action, args, token_hash, token_prefix, created_by, created_at, expires_at)
+               VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)""",
+            (preview_id, name, action, args, token_hash, token_prefix, created_by, _now(), expires_at),
+        )
+        await db.commit()
+        return cur.lastrowid
+    finally:
+        await db.close()
+
+
+async def get_trigger_by_hash(token_hash: str) -> Optional[dict]:
+    """Get a trigger by the hash of its token, with the project and name of
+    its preview."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """SELECT t.*, p.project, p.preview_name
+               FROM triggers t JOIN previews p ON p.id = t.preview_id
+               WHERE t.token_hash = ?""",
+            (token_hash,),
+        )
+        row = await cur.fetchone()
+        return dict(row) if row else None
+    finally:
+        await db.close()
+
+
+async def list_triggers(preview_id: int) -> list[dict]:
+    """List the triggers of a preview (without their token hash)."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """SELECT id, name, action, args, token_prefix, created_by, created_at,
+                      expires_at, last_used_at, use_count
+               FROM triggers
+               WHERE preview_id = ?
+               ORDER BY created_at DESC""",
+            (preview_id,),
+        )
+        rows = await cur.fetchall()
+        return [dict(r) for r in rows]
+    finally:
+        await db.close()
+
+
+async def mark_trigger_used(trigger_id: int):
+    db = await get_db()
+    try:
+        await db.execute(
+            "UPDATE triggers SET last_used_at = ?, use_count = use_count + 1 WHERE id = ?",
+            (_now(), trigger_id),
+        )
+        await db.commit()
+    finally:
+        await db.close()
+
+
+async def delete_trigger(preview_id: int, trigger_id: int) -> bool:
+    """Delete a trigger of a preview. Returns False if it doesn't exist."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            "DELETE FROM triggers WHERE id = ? AND preview_id = ?",
+            (trigger_id, preview_id),
+        )
+        await db.commit()
+        return cur.rowcount > 0
+    finally:
+        await db.close()
+
+
+# ---- IP allowlist CRUD ----
+# Entries without a preview_id apply to every preview of the project; the
+# others reference their preview by id, so they follow it when it's renamed
+# and go away with it.
+
+async def add_allowlist_entry(
+    project: str,
+    preview_id: int | None,
+    cidr: str,
+    note: str,
+    created_by: str | None,
+) -> int:
+    """Add a network to the allowlist of a project, or of one of its
+    previews. Returns the entry id."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """INSERT INTO ip_allowlist (project, preview_id, cidr, note, created_by, created_at)
+               VALUES (?, ?, ?, ?, ?, ?)""",
+            (project, preview_id, cidr, note, created_by, _now()),
+        )
+        await db.commit()
+        return cur.lastrowid
+    finally:
+        await db.close()
+
+
+async def list_allowlist(project: str) -> list[dict]:
+    """List the allowlist entries of a project and of its previews, with the
+    name of their preview (None for the whole project)."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """SELECT a.id, a.project, p.preview_name, a.cidr, a.note, a.created_by, a.created_at
+               FROM ip_allowlist a LEFT JOIN previews p ON p.id = a.preview_id
+               WHERE a.project = ?
+               ORDER BY p.preview_name IS NOT NULL, p.preview_name, a.id""",
+            (project,),
+        )
+        rows = await cur.fetchall()
+        return [dict(r) for r in rows]
+    finally:
+        await db.close()
+
+
+async def get_allowed_networks(project: str, preview_name: str) -> list[str]:
+    """Return the networks allowed to reach a preview: those of its project
+    and its own. Empty if access isn't restricted by IP."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """SELECT a.cidr
+               FROM ip_allowlist a LEFT JOIN previews p ON p.id = a.preview_id
+               WHERE a.project = ? AND (a.preview_id IS NULL OR p.preview_name = ?)""",
+            (project, preview_name),
+        )
+        rows = await cur.fetchall()
+        return [r["cidr"] for r in rows]
+    finally:
+        await db.close()
+
+
+async def delete_allowlist_entry(project: str, entry_id: int) -> bool:
+    """Delete an allowlist entry of a project. Returns False if it doesn't
+    exist."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            "DELETE FROM ip_allowlist WHERE id = ? AND project = ?",
+            (entry_id, project),
+        )
+        await db.commit()
+        return cur.rowcount > 0
+    finally:
+        await db.close()
+
+
+# ---- Preview domain CRUD ----
+# Custom domains reference their preview by id, so they follow it when it's
+# renamed and go away with it.
+
+async def add_preview_domain(preview_id: int, hostname: str, created_by: str | None):
+    db = await get_db()
+    try:
+        await db.execute(
+            "INSERT INTO preview_domains (preview_id, hostname, created_by, created_at) VALUES (?, ?, ?, ?)",
+            (preview_id, hostname, created_by, _now()),
+        )
+        await db.commit()
+    finally:
+        await db.close()
+
+
+async def list_preview_domains(preview_id: int) -> list[dict]:
+    """List the custom domains of a preview, oldest first."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            "SELECT hostname, created_by, created_at FROM preview_domains WHERE preview_id = ? ORDER BY id",
+            (preview_id,),
+        )
+        rows = await cur.fetchall()
+        return [dict(r) for r in rows]
+    finally:
+        await db.close()
+
+
+async def get_preview_by_custom_domain(hostname: str) -> Optional[dict]:
+    """Find the preview a custom domain is attached to."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            """SELECT p.* FROM previews p JOIN preview_domains d ON d.preview_id = p.id
+               WHERE d.hostname = ?""",
+            (hostname,),
+        )
+        row = await cur.fetchone()
+        return dict(row) if row else None
+    finally:
+        await db.close()
+
+
+async def delete_preview_domain(preview_id: int, hostname: str) -> bool:
+    """Detach a custom domain from a preview. Returns False if it wasn't
+    attached to it."""
+    db = await get_db()
+    try:
+        cur = await db.execute(
+            "DELETE FROM preview_domains WHERE preview_id = ? AND hostname = ?",
+            (preview_id, hostname),
+        )
+        await db.commit()
+        return cur.rowcount > 0
+    finally:
+        await db.close()
@@ -0,0 +1,268 @@
+"""Sync a project's base database from its production source.
+
+The source (SSH host and MySQL credentials) is stored server-side per
+project. A sync dumps the source database over SSH into a scratch MariaDB
+container, sanitizes it there, and dumps it again as the project's base DB.
+Jobs run in the background; their log is kept in memory for polling.
+"""
+
+import asyncio
+import json
+import logging
+import shlex
+import tempfile
+import time
+import uuid
+import zlib
+from pathlib import Path
+
+from app import config_store
+
+logger = logging.getLogger(__name__)
+
+SCRATCH_IMAGE = "mariadb:10.11"
+
+# Progress is logged every this many bytes of uncompressed dump.
+PROGRESS_STEP = 100 * 1024 * 1024
+
+# Drupal sanitization, as drush sql-sanitize does. Failures are logged and
+# skipped, since not every site has these tables.
+DEFAULT_SANITIZE_SQL = [
+    "UPDATE users_field_data SET mail = CONCAT('user+', uid, '@localhost'), "
+    "init = CONCAT('user+', uid, '@localhost'), pass = NULL WHERE uid > 0",
+    "TRUNCATE TABLE sessions",
+]
+
+# name -> (required, default)
+SOURCE_FIELDS = {
+    "ssh_host": (True, None),
+    "ssh_user": (False, "root"),
+    "ssh_port": (False, 22),
+    "db_host": (False, "127.0.0.1"),
+    "db_port": (False, 3306),
+    "db_name": (True, None),
+    "db_user": (False, "root"),
+    "db_password": (False, ""),
+    "sanitize_sql": (False, []),
+}
+
+# job_id -> {"project", "logs", "complete", "status", "finished_at"}
+_jobs: dict[str, dict] = {}
+
+# Finished jobs are kept this long for clients still polling.
+JOB_RETENTION_SECONDS = 3600
+
+
+def _source_key(project: str) -> str:
+    return f"db_sync_{project}"
+
+
+async def load_source(project: str) -> dict | None:
+    """Return the production source of a project, or None if not configured."""
+    raw = await config_store.get_config(_source_key(project))
+    if not raw:
+        return None
+    try:
+        return json.loads(raw)
+    except (json.JSONDecodeError, TypeError):
+        return None
+
+
+def public_source(source: dict) -> dict:
+    """Return source without the database password."""
+    return {**source, "db_password": "********" if source.get("db_password") else ""}
+
+
+async def save_source(project: str, body: dict) -> dict:
+    """Validate and save the production source of a project. A masked
+    password keeps the stored one. Raises ValueError on invalid fields."""
+    unknown = set(body) - set(SOURCE_FIELDS)
+    if unknown:
+        raise ValueError(f"unknown fields: {', '.join(sorted(unknown))}")
+
+    current = await load_source(project) or {}
+    source = {}
+    for name, (required, default) in SOURCE_FIELDS.items():
+        value = body.get(name, default)
+        if required and not value:
+            raise ValueError(f"{name} is required")
+        source[name] = value
+    for name in ("ssh_port", "db_port"):
+        try:
+            source[name] = int(source[name])
+        except (TypeError, ValueError):
+            raise ValueError(f"{name} must be a number")
+    if not isinstance(source["sanitize_sql"], list) or not all(isinstance(s, str) for s in source["sanitize_sql"]):
+        raise ValueError("sanitize_sql must be a list of SQL statements")
+    if source["db_password"] == "********":
+        source["db_password"] = current.get("db_password", "")
+
+    await config_store.set_config(_source_key(project), json.dumps(source))
+    return source
+
+
+async def delete_source(project: str):
+    await config_store.delete_config(_source_key(project))
+
+
+def get_job(job_id: str) -> dict | None:
+    return _jobs.get(job_id)
+
+
+def running_job(project: str) -> str | None:
+    """Return the id of the project's running sync, if any."""
+    for job_id, job in _jobs.items():
+        if job["project"] == project and not job["complete"]:
+            return job_id
+    return None
+
+
+def start_sync(project: str, source: dict, user) -> str:
+    """Start a sync in the background and return its job id."""
+    now = time.time()
+    for job_id in [j for j, job in _jobs.items()
+                   if job["complete"] and now - job["finished_at"] > JOB_RETENTION_SECONDS]:
+        del _jobs[job_id]
+
+    job_id = uuid.uuid4().hex[:12]
+    _jobs[job_id] = {"project": project, "logs": [], "complete": False, "status": "running", "finished_at": 0}
+    asyncio.create_task(_run_sync(job_id, project, source, user))
+    return job_id
+
+
+def _log(job_id: str, line: str):
+    _jobs[job_id]["logs"].append(line)
+    logger.info(f"[db sync {job_id}] {line}")
+
+
+async def _run(*command: str) -> bytes:
+    """Run a command, raising RuntimeError with its output on failure."""
+    process = await asyncio.create_subprocess_exec(
+        *command,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE,
+    )
+    stdout, stderr = await process.communicate()
+    if process.returncode != 0:
+        raise RuntimeError(stderr.decode().strip() or f"{command[0]} exited with {process.returncode}")
+    return stdout
+
+
+async def _run_sync(job_id: str, project: str, source: dict, user):
+    container = f"db-sync-{project}"
+    success = False
+    try:
+        await _run("docker", "rm", "-f", container)
+        _log(job_id, f"Starting scratch database ({SCRATCH_IMAGE})...")
+        await _run(
+            "docker", "run", "-d", "--rm", "--name", container,
+            "-e", "MARIADB_ALLOW_EMPTY_ROOT_PASSWORD=1", "-e", "MARIADB_DATABASE=sync",
+            SCRATCH_IMAGE,
+        )
+        await _wait_for_database(container)
+
+        _log(job_id, f"Dumping {source['db_name']} from {source['ssh_host']}...")
+        await _copy_source(job_id, container, source)
+
+        _log(job_id, "Sanitizing...")
+        await _sanitize(job_id, container, source)
+
+        _log(job_id, "Compressing sanitized dump...")
+        with tempfile.NamedTemporaryFile(dir="/backups", suffix=".sql.gz", delete=False) as tmp:
+            tmp_path = Path(tmp.name)
+        try:
+            await _run(
+                "bash", "-o", "pipefail", "-c",
+                f"docker exec {shlex.quote(container)} mariadb-dump --single-transaction sync "
+                f"| gzip -6 > {shlex.quote(str(tmp_path))}",
+            )
+            from app.routes.base_files import _process_db
+            result = await _process_db(project, tmp_path, user)
+        finally:
+            tmp_path.unlink(missing_ok=True)
+
+        _log(job_id, f"Base database of {project} updated ({result['size_bytes']} bytes).")
+        success = True
+    except Exception as e:
+        logger.error(f"DB sync of {project} failed: {e}", exc_info=True)
+        _log(job_id, f"Error: {e}")
+    finally:
+        try:
+            await _run("docker", "rm", "-f", container)
+        except Exception as e:
+            logger.warning(f"Failed to remove {container}: {e}")
+        job = _jobs[job_id]
+        job["complete"] = True
+        job["status"] = "success" if success else "failed"
+        job["finished_at"] = time.time()
+
+
+async def _wait_for_database(container: str, timeout: int = 60):
+    deadline = time.monotonic() + timeout
+    while True:
+        process = await asyncio.create_subprocess_exec(
+            "docker", "exec", container, "mariadb-admin", "ping", "--silent",
+            stdout=asyncio.subprocess.DEVNULL,
+            stderr=asyncio.subprocess.DEVNULL,
+        )
+        if await process.wait() == 0:
+            return
+        if time.monotonic() > deadline:
+            raise RuntimeError(f"scratch database did not start within {timeout}s")
+        await asyncio.sleep(1)
+
+
+async def _copy_source(job_id: str, container: str, source: dict):
+    """Stream a gzipped mysqldump from the source into the scratch database."""
+    remote = (
+        f"MYSQL_PWD={shlex.quote(source['db_password'])} mysqldump --single-transaction --quick "
+        f"-h {shlex.quote(source['db_host'])} -P {int(source['db_port'])} "
+        f"-u {shlex.quote(source['db_user'])} {shlex.quote(source['db_name'])} | gzip -1"
+    )
+    dump = await asyncio.create_subprocess_exec(
+        "ssh", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=accept-new",
+        "-p", str(source["ssh_port"]), f"{source['ssh_user']}@{source['ssh_host']}", remote,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE,
+    )
+    load = await asyncio.create_subprocess_exec(
+        "docker", "exec", "-i", container, "mariadb", "sync",
+        stdin=asyncio.subprocess.PIPE,
+        stdout=asyncio.subprocess.DEVNULL,
+        stderr=asyncio.subprocess.PIPE,
+    )
+
+    gunzip = zlib.decompressobj(wbits=31)
+    total = 0
+    next_report = PROGRESS_STEP
+    try:
+        while chunk := await dump.stdout.read(1024 * 1024):
+            data = gunzip.decompress(chunk)
+            load.stdin.write(data)
+            await load.stdin.drain()
+            total += len(data)
+            if total >= next_report:
+                _log(job_id, f"  {total // (1024 * 1024)} MB imported")
+                next_report += PROGRESS_STEP
+        load.stdin.close()
+    except (BrokenPipeError, ConnectionResetError):
+        dump.kill()
+
+    dump_err = (await dump.stderr.read()).decode().strip()
+    load_err = (await load.stderr.read()).decode().strip()
+    if await dump.wait() != 0:
+        raise RuntimeError(f"dump failed: {dump_err or 'ssh exited with an error'}")
+    if await load.wait() != 0:
+        raise RuntimeError(f"import failed: {load_err}")
+    _log(job_id, f"Imported {total // (1024 * 1024)} MB.")
+
+
+async def _sanitize(job_id: str, container: str, source: dict):
+    for statement in DEFAULT_SANITIZE_SQL:
+        try:
+            await _run("docker", "exec", container, "mariadb", "sync", "-e", statement)
+        except RuntimeError as e:
+            _log(job_id, f"  Skipped default sanitization: {e}")
+    for statement in source.get("sanitize_sql", []):
+        _log(job_id, f"  {statement}")
+        await _run("docker", "exec", container, "mariadb", "sync", "-e", statement)
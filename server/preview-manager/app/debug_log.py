@@ -0,0 +1,62 @@
+"""Per-request operation logs
+
+A client sends "X-Preview-Log-Level: debug" to get the log of the
+operations a request runs (docker compose output, overlay mounts) in the
+output of its result, so users can diagnose a failed action without
+access to the server logs. Other levels, or no header, leave the output
+as is.
+"""
+
+import time
+from contextvars import ContextVar
+
+from starlette.datastructures import Headers
+from starlette.types import ASGIApp, Receive, Scope, Send
+
+LOG_LEVEL_HEADER = "X-Preview-Log-Level"
+
+# The lines logged so far by the request, None unless it asked for them.
+# Middlewares run the routes in tasks copying the context, which share
+# the list.
+_lines: ContextVar[list[str] | None] = ContextVar("debug_log_lines", default=None)
+
+
+class DebugLogMiddleware:
+    """Collect the operation log of requests sending the debug log level."""
+
+    def __init__(self, app: ASGIApp):
+        self.app = app
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] != "http" or Headers(scope=scope).get(LOG_LEVEL_HEADER, "").lower() != "debug":
+            await self.app(scope, receive, send)
+            return
+        token = _lines.set([])
+        try:
+            await self.app(scope, receive, send)
+        finally:
+            _lines.reset(token)
+
+
+def enabled() -> bool:
+    """Whether the current request asked for its operation log."""
+    return _lines.get() is not None
+
+
+def log(line: str):
+    """Add a line to the operation log of the current request, if it
+    asked for one."""
+    lines = _lines.get()
+    if lines is not None:
+        lines.append(f"[{time.strftime('%H:%M:%S')}] {line}")
+
+
+def attach(output: str) -> str:
+    """Return output preceded by the operation log logged so far, which is
+    then cleared, or output alone if the request didn't ask for it."""
+    lines = _lines.get()
+    if not lines:
+        return output
+    log_text = "\n".join(["--- server log (debug) ---", *lines, "--- end of server log ---", ""])
+    lines.clear()
+    return log_text + output
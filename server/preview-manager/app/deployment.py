@@ -13,9 +13,11 @@ from app.docker_compose import (
     write_docker_compose,
 )
 from app.state import PreviewStateManager
-from app.database import get_preview, create_deployment, finish_deployment
+from app.database import get_preview, create_deployment, finish_deployment, list_preview_domains
 from app.overlay import get_base_files_dir, mount_overlay
 from app import config_store
+from app.project_settings import load_preview_resources, load_project_settings
+from app.sanitize import sanitize_statements
 from config.settings import settings
 
 logger = logging.getLogger(__name__)
@@ -51,6 +53,33 @@ def _fmt_duration(seconds: float) -> str:
     return f"{m}m {s}s"
 
 
+def resolve_deploy_script(preview_path: Path, preview_name: str, phase: str, config: dict | None) -> str | None:
+    """Return the project deploy script of a phase (new/update), relative to
+    the project root, or None if there is none.
+
+    Priority:
+    1. Preview-specific override: scripts/preview/{phase}/{preview_name}-deploy.sh
+    2. Script path defined in preview.yml deploy.{phase}
+    3. Nothing — if no script is configured
+
+    Raises RuntimeError if preview.yml names a script that doesn't exist.
+    """
+    override = f"scripts/preview/{phase}/{preview_name}-deploy.sh"
+    if (preview_path / override).exists():
+        return override
+
+    deploy_path = config["deploy"][phase] if config else None
+    if not deploy_path:
+        return None
+
+    if not (preview_path / deploy_path).exists():
+        raise RuntimeError(
+            f"Deploy script not found: {deploy_path} "
+            f"(configured in preview.yml deploy.{phase})"
+        )
+    return deploy_path
+
+
 class PreviewDeployer:
     """Deploy a preview environment using Docker Compose.
 
@@ -194,6 +223,8 @@ class PreviewDeployer:
         await self._wait_for_db()
         await self._composer_install()
         await self._import_db()
+        await self._import_secondary_dbs()
+        await self._sanitize_db()
         await self._import_files()
         await self._run_deploy_steps("new")
         await self._run_project_deploy_script("new")
@@ -206,6 +237,7 @@ class PreviewDeployer:
         await self._generate_compose()
         await self._docker_up()
         await self._composer_install()
+        await self._import_secondary_dbs(only_missing=True)
         await self._run_deploy_steps("update")
         await self._run_project_deploy_script("update")
 
@@ -252,11 +284,23 @@ class PreviewDeployer:
         except Exception as e:
             logger.warning(f"Error loading extra env vars: {e}")
 
+        # Resource limits: preview scale > preview.yml > project settings
+        project_settings = await load_project_settings(self.project_name)
+        scaled = await load_preview_resources(self.project_name, self.preview_name)
+        memory_limit = scaled["memory"] or config["resources"]["memory"] or project_settings["memory_limit"]
+        cpu_limit = scaled["cpus"] or config["resources"]["cpus"] or project_settings["cpu_limit"]
+
+        preview_row = await get_preview(self.project_name, self.preview_name)
+        custom_domains = [d["hostname"] for d in await list_preview_domains(preview_row["id"])] if preview_row else []
+
         compose = generate_docker_compose(
             self.project_name, self.preview_name, config,
             branch=self.branch, commit_sha=self.commit_sha,
             mr_iid=self.mr_iid,
             extra_env=extra_env if extra_env else None,
+            memory_limit=memory_limit,
+            cpu_limit=cpu_limit,
+            custom_domains=custom_domains,
         )
         write_docker_compose(self.preview_path, compose)
 
@@ -323,10 +367,63 @@ class PreviewDeployer:
         )
         await self._run_shell(cmd, step="import-db", timeout=TIMEOUT_IMPORT_DB)
 
+    async def _import_secondary_dbs(self, only_missing: bool = False):
+        """Create the secondary databases of preview.yml and import their
+        base dumps (pushed with `push db --target NAME`).
+
+        On updates only databases added to preview.yml since the preview was
+        created are set up, so the data of the others is kept.
+        """
+        databases = self._preview_config.get("databases", []) if self._preview_config else []
+        if not databases:
+            return
+        db_container = f"{self.container_prefix}-db"
+        root = ("docker", "exec", db_container, "mysql", "-u", "root", "-proot")
+
+        existing: set[str] = set()
+        if only_missing:
+            output = await self._run(*root, "-N", "-e", "SHOW DATABASES", step="list-databases")
+            existing = set(output.split())
+
+        for name in databases:
+            if name in existing:
+                continue
+            await self._run(
+                *root, "-e",
+                f"CREATE DATABASE IF NOT EXISTS `{name}`; GRANT ALL ON `{name}`.* TO 'drupal'@'%';",
+                step=f"create-db-{name}",
+            )
+            db_path = Path(f"/backups/{self.project_name}-base-db-{name}.sql.gz")
+            if not db_path.exists():
+                await self._log_raw(
+                    f"{YELLOW}No base dump for database '{name}': it is left empty. "
+                    f"Upload one with 'preview push db --target {name}'.{RESET}\n"
+                )
+                continue
+            cmd = (
+                f"gunzip -c {db_path} | docker exec -i {db_container} "
+                f"mysql -u drupal -pdrupal {name}"
+            )
+            await self._run_shell(cmd, step=f"import-db-{name}", timeout=TIMEOUT_IMPORT_DB)
+
+    async def _sanitize_db(self):
+        """Anonymize the imported database with the sanitize: recipe of
+        preview.yml, if it has one."""
+        recipe = self._preview_config.get("sanitize") if self._preview_config else None
+        if not recipe:
+            return
+        db_container = f"{self.container_prefix}-db"
+        await self._run(
+            "docker", "exec", db_container, "mysql", "-u", "drupal", "-pdrupal", "drupal",
+            "-e", ";\n".join(sanitize_statements(recipe)),
+            step="sanitize-db",
+        )
+
     async def _import_files(self):
         """Mount overlay filesystem for shared base files (skipped if none uploaded)."""
         base_dir = get_base_files_dir(self.project_name)
-        if not base_dir.exists():
+        proxied = bool(self._preview_config) and self._preview_config.get("files") == "stage-file-proxy"
+        if proxied or not base_dir.exists():
             # Create an empty files directory so Drupal can still function
             public_path = self._preview_config["env"].get(
                 "PREV_FILE_PUBLIC_PATH", "sites/default/files"
@@ -334,7 +431,13 @@ class PreviewDeployer:
             docroot = self._preview_config.get("docroot", "web") if self._preview_config else "web"
             files_dir = self.preview_path / docroot / public_path
             files_dir.mkdir(parents=True, exist_ok=True)
-            await self._log(f"{DIM}No base files found — created empty {docroot}/{public_path}{RESET}")
+            sites = self._preview_config["sites"] if self._preview_config else ["default"]
+            for site in sites:
+                (self.preview_path / docroot / "sites" / site / "files").mkdir(parents=True, exist_ok=True)
+            if proxied:
+                await self._log(f"{DIM}Files are fetched from production (stage-file-proxy) — created empty {docroot}/{public_path}{RESET}")
+            else:
+                await self._log(f"{DIM}No base files found — created empty {docroot}/{public_path}{RESET}")
             return
 
         step = "import-files"
@@ -365,46 +468,21 @@ class PreviewDeployer:
         )
 
     async def _run_project_deploy_script(self, phase: str):
-        """Run the project deploy script for a phase (new/update).
-
-        Priority:
-        1. Preview-specific override: scripts/preview/{phase}/{preview_name}-deploy.sh
-        2. Script path defined in preview.yml deploy.{phase}
-        3. Nothing — if no script is configured, skip entirely
-        """
-        # Check for preview-specific override first
-        scripts_dir = self.preview_path / "scripts" / "preview" / phase
-        preview_script = scripts_dir / f"{self.preview_name}-deploy.sh"
-
-        if preview_script.exists():
-            logger.info(f"Running preview-specific deploy script: {preview_script.name}")
-            await self._docker_exec(
-                "bash", f"/var/www/html/scripts/preview/{phase}/{preview_script.name}",
-                step=f"project-deploy-script-preview-{phase}",
-                timeout=TIMEOUT_DEPLOY_SCRIPT,
-            )
-            return
-
-        # Use preview.yml config
-        config = getattr(self, "_preview_config", None)
-        deploy_path = config["deploy"][phase] if config else None
-
-        if not deploy_path:
+        """Run the project deploy script for a phase (new/update), if any."""
+        script = resolve_deploy_script(
+            self.preview_path, self.preview_name, phase, getattr(self, "_preview_config", None),
+        )
+        if not script:
             logger.info(f"No deploy script configured for phase '{phase}', skipping")
             return
 
-        # Verify the script exists in the project
-        full_path = self.preview_path / deploy_path
-        if not full_path.exists():
-            raise RuntimeError(
-                f"Deploy script not found: {deploy_path} "
-                f"(configured in preview.yml deploy.{phase})"
-            )
-
-        logger.info(f"Running deploy script ({phase}): {deploy_path}")
+        step = f"project-deploy-script-{phase}"
+        if script == f"scripts/preview/{phase}/{self.preview_name}-deploy.sh":
+            step = f"project-deploy-script-preview-{phase}"
+        logger.info(f"Running deploy script ({phase}): {script}")
         await self._docker_exec(
-            "bash", f"/var/www/html/{deploy_path}",
-            step=f"project-deploy-script-{phase}",
+            "bash", f"/var/www/html/{script}",
+            step=step,
             timeout=TIMEOUT_DEPLOY_SCRIPT,
         )
 
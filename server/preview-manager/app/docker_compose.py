@@ -1,16 +1,37 @@
 """Docker Compose generator for preview environments."""
 
+import hashlib
+import hmac
 import logging
+import re
 from pathlib import Path
 from typing import Any
 
 import yaml
 
 from config.settings import settings
+from app.project_settings import parse_cpus, parse_memory
+from app.sanitize import parse_sanitize
 
 logger = logging.getLogger(__name__)
 
 # Defaults when preview.yml is missing or incomplete
+# Database and account of the db service of every preview
+DB_NAME = "drupal"
+DB_USER = "drupal"
+DB_PASSWORD = "drupal"
+
+# Mail capture (mailpit) of every preview: SMTP port PHP sends to, web UI
+# and API port, and the user of its UI (see mail_password)
+MAIL_SMTP_PORT = 1025
+MAIL_UI_PORT = 8025
+MAIL_USER = "preview"
+
+# Secondary databases of preview.yml "databases:" are named like this, and
+# can't take the name of the main one or of MySQL's own
+DATABASE_NAME_PATTERN = re.compile(r"^[a-z][a-z0-9_]{0,31}$")
+RESERVED_DATABASES = {"default", "encrypted", DB_NAME, "mysql", "sys", "information_schema", "performance_schema"}
+
 DEFAULTS = {
     "php_version": "8.3",
     "database": "mysql:8.0",
@@ -20,10 +41,27 @@ DEFAULTS = {
         "solr": False,
     },
     "env": {},
+    # "base": mount the base files archive; "stage-file-proxy": the site
+    # fetches files from production on demand, base files are not used
+    "files": "base",
+    "resources": {
+        "memory": None,
+        "cpus": None,
+    },
     "deploy": {
         "new": None,
         "update": None,
     },
+    # Test suites run by "preview test": name -> {"command", "junit"}
+    "tests": {},
+    # Drupal multisite directories under sites/, each with its own files
+    "sites": ["default"],
+    # Secondary databases (e.g. a migrate source), in the db service
+    # beside the main one, each imported from its own base dump
+    "databases": [],
+    # Anonymization recipe of the database (see app.sanitize), None if
+    # the data needs none
+    "sanitize": None,
 }
 
 
@@ -32,7 +70,11 @@ def parse_preview_yml(preview_path: Path) -> dict:
     config = dict(DEFAULTS)
     config["services"] = dict(DEFAULTS["services"])
     config["env"] = dict(DEFAULTS["env"])
+    config["resources"] = dict(DEFAULTS["resources"])
     config["deploy"] = dict(DEFAULTS["deploy"])
+    config["tests"] = dict(DEFAULTS["tests"])
+    config["sites"] = list(DEFAULTS["sites"])
+    config["databases"] = list(DEFAULTS["databases"])
 
     yml_file = preview_path / "preview.yml"
     if not yml_file.exists():
@@ -68,6 +110,21 @@ def parse_preview_yml(preview_path: Path) -> dict:
     if "env" in raw and isinstance(raw["env"], dict):
         config["env"].update({str(k): str(v) for k, v in raw["env"].items()})
 
+    if "files" in raw:
+        if raw["files"] in ("base", "stage-file-proxy"):
+            config["files"] = raw["files"]
+        else:
+            logger.warning(f"Ignoring preview.yml files: {raw['files']!r} (expected base or stage-file-proxy)")
+
+    # Resource limits of the PHP container; invalid values are ignored
+    if "resources" in raw and isinstance(raw["resources"], dict):
+        for key, parse in (("memory", parse_memory), ("cpus", parse_cpus)):
+            if raw["resources"].get(key) is not None:
+                try:
+                    config["resources"][key] = parse(str(raw["resources"][key]))
+                except ValueError as e:
+                    logger.warning(f"Ignoring preview.yml resources.{key}: {e}")
+
     # Deploy scripts — optional paths, None means no script
     if "deploy" in raw and isinstance(raw["deploy"], dict):
         for phase in ("new", "update"):
@@ -80,16 +137,110 @@ def parse_preview_yml(preview_path: Path) -> dict:
         # deploy: false — explicitly disable all deploy scripts
         config["deploy"] = {"new": None, "update": None}
 
+    # Test suites: a command string, or {command, junit} where junit is the
+    # path (or glob) of the JUnit reports, relative to the project root
+    if "tests" in raw and isinstance(raw["tests"], dict):
+        for name, suite in raw["tests"].items():
+            if isinstance(suite, str):
+                suite = {"command": suite}
+            if not isinstance(suite, dict) or not suite.get("command"):
+                logger.warning(f"Ignoring preview.yml tests.{name}: a command is required")
+                continue
+            junit = suite.get("junit")
+            if junit and not str(junit).startswith("/"):
+                junit = f"/var/www/html/{junit}"
+            config["tests"][str(name)] = {
+                "command": str(suite["command"]),
+                "junit": str(junit) if junit else None,
+            }
+
+    if "sites" in raw:
+        sites = raw["sites"]
+        if isinstance(sites, list) and sites and all(_valid_site(s) for s in sites):
+            config["sites"] = [str(s) for s in sites]
+        else:
+            logger.warning(f"Ignoring preview.yml sites: {sites!r} (expected a list of sites/ directory names)")
+
+    if "databases" in raw:
+        databases = raw["databases"]
+        if isinstance(databases, list) and all(valid_database_name(d) for d in databases):
+            config["databases"] = list(dict.fromkeys(databases))
+        else:
+            logger.warning(f"Ignoring preview.yml databases: {databases!r} (expected a list of lowercase names, e.g. [migrate])")
+
+    if "sanitize" in raw:
+        try:
+            config["sanitize"] = parse_sanitize(raw["sanitize"])
+        except ValueError as e:
+            logger.warning(f"Ignoring preview.yml {e}")
+
     logger.info(f"Parsed preview.yml: php={config['php_version']}, database={config['database']}, "
                 f"redis={config['services']['redis']}, solr={config['services']['solr']}, "
                 f"deploy.new={config['deploy']['new']}, deploy.update={config['deploy']['update']}")
     return config
 
 
+def _valid_site(site) -> bool:
+    """Whether site is a plain directory name under sites/."""
+    return isinstance(site, str) and site not in ("", ".", "..") and "/" not in site and "\\" not in site
+
+
+def valid_database_name(name) -> bool:
+    """Whether name can be a secondary database of preview.yml."""
+    return isinstance(name, str) and bool(DATABASE_NAME_PATTERN.match(name)) and name not in RESERVED_DATABASES
+
+
+def database_env_prefix(name: str) -> str:
+    """Return the prefix of the env vars of a secondary database, e.g.
+    PREV_DB_MIGRATE_ for "migrate"."""
+    return f"PREV_DB_{name.upper()}_"
+
+
 def _container_prefix(project_name: str, preview_name: str) -> str:
     return f"{preview_name}-{project_name}"
 
 
+def preview_domain(project_name: str, preview_name: str) -> str:
+    """Return the domain a preview is served at."""
+    return f"{_container_prefix(project_name, preview_name)}.mr.preview-mr.com"
+
+
+def mail_domain(project_name: str, preview_name: str) -> str:
+    """Return the domain the mail capture UI of a preview is served at."""
+    return f"mail-{preview_domain(project_name, preview_name)}"
+
+
+def mail_password(project_name: str, preview_name: str) -> str:
+    """Return the password of the mail capture UI of a preview, derived from
+    the secret key so it survives rebuilds without being stored."""
+    key = settings.secret_key.encode()
+    msg = f"mail:{_container_prefix(project_name, preview_name)}".encode()
+    return hmac.new(key, msg, hashlib.sha256).hexdigest()[:20]
+
+
+def apply_custom_domains(compose: dict, domains: list[str]) -> None:
+    """Serve the php service of a compose dict at the custom domains of its
+    preview as well, replacing those it had.
+
+    Custom domains are a second Caddy site, checked by verify-domain (the
+    IP allowlist, no login: preview sessions are cookies of the preview
+    domain). PREV_CUSTOM_DOMAINS lets Drupal trust the hosts.
+    """
+    php = compose["services"]["php"]
+    php["labels"] = {k: v for k, v in php["labels"].items() if not k.startswith("caddy_1")}
+    php["environment"].pop("PREV_CUSTOM_DOMAINS", None)
+    if not domains:
+        return
+    php["labels"].update({
+        "caddy_1": ", ".join(domains),
+        "caddy_1.reverse_proxy": "{{upstreams 80}}",
+        "caddy_1.forward_auth": "host.docker.internal:8000",
+        "caddy_1.forward_auth.uri": "/api/auth/verify-domain",
+        "caddy_1.forward_auth.header_up": "Host {http.request.host}",
+    })
+    php["environment"]["PREV_CUSTOM_DOMAINS"] = " ".join(domains)
+
+
 def generate_docker_compose(
     project_name: str,
     preview_name: str,
@@ -98,10 +249,13 @@ def generate_docker_compose(
     commit_sha: str = "",
     mr_iid: int | None = None,
     extra_env: dict[str, str] | None = None,
+    memory_limit: str | None = None,
+    cpu_limit: float | None = None,
+    custom_domains: list[str] | None = None,
 ) -> dict:
     """Generate a docker-compose.yml dict for a preview environment."""
     prefix = _container_prefix(project_name, preview_name)
-    domain = f"{prefix}.mr.preview-mr.com"
+    domain = preview_domain(project_name, preview_name)
     url = f"https://{domain}"
     network_name = settings.docker_network
 
@@ -131,13 +285,14 @@ def generate_docker_compose(
         "PREV_URL": url,
         "PREV_DOMAIN": domain,
         "PREV_DB_HOST": f"{prefix}-db",
-        "PREV_DB_NAME": "drupal",
-        "PREV_DB_USER": "drupal",
-        "PREV_DB_PASSWORD": "drupal",
+        "PREV_DB_NAME": DB_NAME,
+        "PREV_DB_USER": DB_USER,
+        "PREV_DB_PASSWORD": DB_PASSWORD,
         "PREV_FILE_PUBLIC_PATH": "sites/default/files",
         "PREV_FILE_PRIVATE_PATH": "sites/default/files/private",
         "PREV_FILE_TEMP_PATH": "/tmp",
         "PREV_FILE_TRANSLATIONS_PATH": "sites/default/files/translations",
+        "PREV_MAIL_HOST": f"{prefix}-mail",
         "DOCUMENT_ROOT": f"/var/www/html/{config['docroot']}",
     }
 
@@ -148,6 +303,14 @@ def generate_docker_compose(
         php_env["PREV_SOLR_HOST"] = f"{prefix}-solr"
         php_env["PREV_SOLR_CORE"] = "drupal"
 
+    # Secondary databases live in the db service beside the main one
+    for name in config["databases"]:
+        env = database_env_prefix(name)
+        php_env[env + "HOST"] = f"{prefix}-db"
+        php_env[env + "NAME"] = name
+        php_env[env + "USER"] = DB_USER
+        php_env[env + "PASSWORD"] = DB_PASSWORD
+
     # Merge user env vars from preview.yml
     php_env.update(config["env"])
 
@@ -181,14 +344,32 @@ def generate_docker_compose(
                 "container_name": f"{prefix}-db",
                 "environment": {
                     "MYSQL_ROOT_PASSWORD": "root",
-                    "MYSQL_DATABASE": "drupal",
-                    "MYSQL_USER": "drupal",
-                    "MYSQL_PASSWORD": "drupal",
+                    "MYSQL_DATABASE": DB_NAME,
+                    "MYSQL_USER": DB_USER,
+                    "MYSQL_PASSWORD": DB_PASSWORD,
                 },
                 "volumes": ["db_data:/var/lib/mysql"],
                 "networks": [network_name],
                 "restart": "unless-stopped",
             },
+            # Captures the mail the site sends (PHP's sendmail_path sends
+            # to it), browsable at mail_domain with basic auth
+            "mail": {
+                "image": "axllent/mailpit:v1.21",
+                "container_name": f"{prefix}-mail",
+                "environment": {
+                    "MP_SMTP_AUTH_ACCEPT_ANY": "true",
+                    "MP_SMTP_AUTH_ALLOW_INSECURE": "true",
+                    "MP_MAX_MESSAGES": "500",
+                    "MP_UI_AUTH": f"{MAIL_USER}:{mail_password(project_name, preview_name)}",
+                },
+                "labels": {
+                    "caddy": mail_domain(project_name, preview_name),
+                    "caddy.reverse_proxy": f"{{{{upstreams {MAIL_UI_PORT}}}}}",
+                },
+                "networks": [network_name],
+                "restart": "unless-stopped",
+            },
         },
         "volumes": {
             "db_data": None,
@@ -198,6 +379,12 @@ def generate_docker_compose(
         },
     }
 
+    # Resource limits from the project settings
+    if memory_limit:
+        compose["services"]["php"]["mem_limit"] = memory_limit
+    if cpu_limit:
+        compose["services"]["php"]["cpus"] = cpu_limit
+
     # Optional services
     if config["services"]["redis"]:
         compose["services"]["redis"] = {
@@ -218,6 +405,8 @@ def generate_docker_compose(
         }
         compose["volumes"]["solr_data"] = None
 
+    apply_custom_domains(compose, custom_domains or [])
+
     return compose
 
 
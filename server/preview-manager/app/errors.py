@@ -0,0 +1,138 @@
+"""Structured error responses.
+
+Every request gets an ID, returned in the X-Request-ID header (a request ID
+sent by a proxy is kept) and logged with server errors, so a user reporting
+a failure can point at the log lines. Errors are answered with an envelope:
+
+    {"detail": "...", "code": "not_found", "message": "...",
+     "hint": "...", "request_id": "..."}
+
+"detail" is kept as FastAPI sends it for the web UI and older CLIs;
+"message" is always a sentence. Routes raise APIError to set a specific
+code or hint; other errors get a code from their status.
+"""
+
+import logging
+import uuid
+
+from fastapi import HTTPException, Request
+from fastapi.encoders import jsonable_encoder
+from fastapi.exceptions import RequestValidationError
+from fastapi.responses import JSONResponse
+from starlette.datastructures import Headers, MutableHeaders
+from starlette.exceptions import HTTPException as StarletteHTTPException
+from starlette.types import ASGIApp, Message, Receive, Scope, Send
+
+logger = logging.getLogger(__name__)
+
+REQUEST_ID_HEADER = "X-Request-ID"
+
+STATUS_CODES = {
+    400: "bad_request",
+    401: "not_authenticated",
+    403: "forbidden",
+    404: "not_found",
+    405: "method_not_allowed",
+    409: "conflict",
+    413: "too_large",
+    422: "invalid_request",
+    429: "rate_limited",
+    500: "internal_error",
+    502: "upstream_error",
+    503: "unavailable",
+}
+
+
+class APIError(HTTPException):
+    """An HTTPException with a machine-readable code and a remediation hint."""
+
+    def __init__(self, status_code: int, message: str, code: str | None = None, hint: str | None = None):
+        super().__init__(status_code=status_code, detail=message)
+        self.code = code
+        self.hint = hint
+
+
+class RequestIDMiddleware:
+    """Assign every request an ID, in request.state.request_id and the response."""
+
+    def __init__(self, app: ASGIApp):
+        self.app = app
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        request_id = Headers(scope=scope).get(REQUEST_ID_HEADER, "")[:64] or uuid.uuid4().hex[:16]
+        # The scope is shared with the error handlers, even the outer one
+        # handling unexpected exceptions
+        scope.setdefault("state", {})["request_id"] = request_id
+
+        async def send_with_id(message: Message):
+            if message["type"] == "http.response.start":
+                MutableHeaders(scope=message)[REQUEST_ID_HEADER] = request_id
+            await send(message)
+
+        await self.app(scope, receive, send_with_id)
+
+
+def request_id(request: Request) -> str:
+    return getattr(request.state, "request_id", "")
+
+
+def error_response(request: Request, status_code: int, message: str, code: str | None = None,
+                   hint: str | None = None, detail=None, headers: dict | None = None) -> JSONResponse:
+    """Build the error envelope."""
+    rid = request_id(request)
+    headers = dict(headers or {})
+    if rid:
+        headers[REQUEST_ID_HEADER] = rid
+    return JSONResponse(
+        {
+            "detail": message if detail is None else detail,
+            "code": code or STATUS_CODES.get(status_code, "error"),
+            "message": message,
+            "hint": hint,
+            "request_id": rid,
+        },
+        status_code=status_code,
+        headers=headers,
+    )
+
+
+async def http_exception_handler(request: Request, exc: StarletteHTTPException) -> JSONResponse:
+    detail = exc.detail
+    message = detail if isinstance(detail, str) else str(detail)
+    return error_response(
+        request, exc.status_code, message,
+        code=getattr(exc, "code", None),
+        hint=getattr(exc, "hint", None),
+        detail=detail,
+        headers=getattr(exc, "headers", None),
+    )
+
+
+async def validation_exception_handler(request: Request, exc: RequestValidationError) -> JSONResponse:
+    problems = []
+    for error in exc.errors():
+        location = ".".join(str(part) for part in error.get("loc", ()) if part != "body")
+        problems.append(f"{location}: {error.get('msg')}" if location else str(error.get("msg")))
+    return error_response(
+        request, 422, "Invalid request: " + "; ".join(problems),
+        detail=jsonable_encoder(exc.errors()),
+    )
+
+
+async def unhandled_exception_handler(request: Request, exc: Exception) -> JSONResponse:
+    rid = request_id(request)
+    logger.exception("Unhandled error in %s %s [request %s]", request.method, request.url.path, rid)
+    return error_response(
+        request, 500, "Internal server error",
+        hint="This is a server bug; report it to the server admin with the request ID.",
+    )
+
+
+def register_error_handlers(app) -> None:
+    app.add_exception_handler(StarletteHTTPException, http_exception_handler)
+    app.add_exception_handler(RequestValidationError, validation_exception_handler)
+    app.add_exception_handler(Exception, unhandled_exception_handler)
@@ -0,0 +1,130 @@
+"""Sync of local files into the files directory of a running preview.
+
+Changed files come as a tar.gz extracted over the public files directory,
+on top of its overlay, so only the preview sees them; the base files are
+untouched. Files keep the modification times of the archive, so clients
+can compare the manifest with their copy to send only what changed. Entries that would land outside the directory (absolute paths,
+".." components, paths through symlinks) and links are rejected before
+anything is written.
+"""
+
+import os
+import shutil
+import stat
+import tarfile
+from pathlib import Path, PurePosixPath
+
+from app.docker_compose import parse_preview_yml
+from app.overlay import DEFAULT_PUBLIC_PATH
+
+
+class SyncError(ValueError):
+    """The archive or the paths to delete are invalid."""
+
+
+def files_dir(preview_path: Path) -> Path:
+    """Return the public files directory of a preview."""
+    config = parse_preview_yml(preview_path)
+    public_path = config["env"].get("PREV_FILE_PUBLIC_PATH", DEFAULT_PUBLIC_PATH)
+    return preview_path / config["docroot"] / public_path
+
+
+def _target(root: Path, name: str) -> Path:
+    """Resolve the relative path name inside root, rejecting any that
+    could reach outside it."""
+    path = PurePosixPath(name)
+    if path.is_absolute() or ".." in path.parts:
+        raise SyncError(f"Invalid path {name!r}")
+    target = root
+    for part in path.parts:
+        if part == ".":
+            continue
+        target = target / part
+        if target.is_symlink():
+            raise SyncError(f"Path {name!r} goes through a symlink")
+    return target
+
+
+def _own_like(path: Path, st: os.stat_result) -> None:
+    """Give path the owner of the files directory, so the PHP container
+    can change it."""
+    try:
+        os.chown(path, st.st_uid, st.st_gid)
+    except OSError:
+        pass
+
+
+def manifest(root: Path, paths: list[str]) -> list[dict]:
+    """List the regular files under each of paths, relative to root, with
+    their size and modification time. Symlinks aren't followed."""
+    if not root.is_dir():
+        raise FileNotFoundError(root)
+    files = {}
+    for name in paths:
+        top = _target(root, name)
+        if top.is_file():
+            candidates = [top]
+        elif top.is_dir():
+            candidates = []
+            for dirpath, _, filenames in os.walk(top):
+                candidates += [Path(dirpath) / f for f in filenames]
+        else:
+            continue
+        for path in candidates:
+            st = path.lstat()
+            if not stat.S_ISREG(st.st_mode):
+                continue
+            rel = path.relative_to(root).as_posix()
+            files[rel] = {"path": rel, "size_bytes": st.st_size, "mtime": int(st.st_mtime)}
+    return [files[rel] for rel in sorted(files)]
+
+
+def apply(root: Path, archive: Path | None, deletes: list[str]) -> dict:
+    """Delete the paths of deletes under root, then extract archive over
+    it. Returns the number of files written and paths deleted."""
+    if not root.is_dir():
+        raise FileNotFoundError(root)
+    owner = root.stat()
+
+    members = []
+    if archive is not None:
+        with tarfile.open(archive, "r:*") as tar:
+            for member in tar.getmembers():
+                if not (member.isfile() or member.isdir()):
+                    raise SyncError(f"{member.name!r} is not a regular file or directory")
+                members.append((member, _target(root, member.name)))
+    targets = [_target(root, name) for name in deletes]
+
+    deleted = 0
+    for target in targets:
+        if target == root:
+            raise SyncError("Refusing to delete the files directory")
+        if target.is_dir():
+            shutil.rmtree(target)
+            deleted += 1
+        elif target.exists():
+            target.unlink()
+            deleted += 1
+
+    written = 0
+    if archive is not None:
+        with tarfile.open(archive, "r:*") as tar:
+            for member, target in members:
+                if member.isdir():
+                    target.mkdir(parents=True, exist_ok=True)
+                    _own_like(target, owner)
+                    continue
+                for parent in reversed(target.relative_to(root).parents):
+                    d = root / parent
+                    if not d.exists():
+                        d.mkdir()
+                        _own_like(d, owner)
+                tmp = target.with_name(f".{target.name}.sync-tmp")
+                with tar.extractfile(member) as src, open(tmp, "wb") as dst:
+                    shutil.copyfileobj(src, dst)
+                os.chmod(tmp, stat.S_IMODE(member.mode) | 0o644)
+                os.utime(tmp, (member.mtime, member.mtime))
+                _own_like(tmp, owner)
+                os.replace(tmp, target)
+                written += 1
+    return {"written": written, "deleted": deleted}
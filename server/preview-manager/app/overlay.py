@@ -3,9 +3,14 @@
 Base files are extracted once per project into .base-files/{project}/files/.
 Each preview mounts an overlay with its own upper (writable) layer,
 so changes are per-preview and the base files are shared read-only.
+
+Archives of Drupal multisites hold the files of each site under
+sites/{site}/, listed in a preview-sites.json manifest; each site gets its
+own overlay on {docroot}/sites/{site}/files.
 """
 
 import asyncio
+import json
 import logging
 from pathlib import Path
 
@@ -19,12 +24,28 @@ BASE_FILES_ROOT = Path(settings.previews_base_path) / ".base-files"
 DEFAULT_DOCROOT = "web"
 DEFAULT_PUBLIC_PATH = "sites/default/files"
 
+# Written by "preview push files" for multisites: {"sites": ["default", ...]}
+SITES_MANIFEST = "preview-sites.json"
+
 
 def get_base_files_dir(project: str) -> Path:
     """Get the shared base files directory for a project."""
     return BASE_FILES_ROOT / project / "files"
 
 
+def get_base_sites(project: str) -> list[str] | None:
+    """Get the sites of a multisite base files archive, None for a single site."""
+    manifest = get_base_files_dir(project) / SITES_MANIFEST
+    if not manifest.exists():
+        return None
+    try:
+        sites = json.loads(manifest.read_text())["sites"]
+    except (ValueError, KeyError, TypeError) as e:
+        logger.warning("Ignoring invalid %s of %s: %s", SITES_MANIFEST, project, e)
+        return None
+    return [s for s in sites if isinstance(s, str) and s and "/" not in s and s not in (".", "..")]
+
+
 def get_overlay_dir(preview_path: Path) -> Path:
     """Get the .overlay directory for a preview."""
     return preview_path / ".overlay"
@@ -61,6 +82,9 @@ async def mount_overlay(
     Lower (read-only):  .base-files/{project}/files/
     Upper (read-write):  {preview}/.overlay/upper/
     Merged (visible):    {preview}/{docroot}/{public_path}/
+
+    Multisite archives get one overlay per site, from sites/{site}/ onto
+    {docroot}/sites/{site}/files/ with .overlay/{site}/ as the upper layer.
     """
     base = get_base_files_dir(project)
     if not base.exists():
@@ -70,10 +94,23 @@ async def mount_overlay(
         )
 
     overlay = get_overlay_dir(preview_path)
+    sites = get_base_sites(project)
+    if sites is None:
+        await _mount(base, overlay, get_files_mount_point(preview_path, docroot, public_path))
+        return
+    for site in sites:
+        await _mount(
+            base / "sites" / site, overlay / site,
+            get_files_mount_point(preview_path, docroot, f"sites/{site}/files"),
+        )
+
+
+async def _mount(lower: Path, overlay: Path, mount_point: Path) -> None:
+    """Mount lower at mount_point with its upper and work dirs in overlay."""
     upper = overlay / "upper"
     work = overlay / "work"
-    mount_point = get_files_mount_point(preview_path, docroot, public_path)
 
+    lower.mkdir(parents=True, exist_ok=True)
     upper.mkdir(parents=True, exist_ok=True)
     work.mkdir(parents=True, exist_ok=True)
     mount_point.mkdir(parents=True, exist_ok=True)
@@ -83,7 +120,7 @@ async def mount_overlay(
 
     proc = await asyncio.create_subprocess_exec(
         "sudo", "mount", "-t", "overlay", "overlay",
-        "-o", f"lowerdir={base},upperdir={upper},workdir={work}",
+        "-o", f"lowerdir={lower},upperdir={upper},workdir={work}",
         str(mount_point),
         stdout=asyncio.subprocess.PIPE,
         stderr=asyncio.subprocess.PIPE,
@@ -93,7 +130,7 @@ async def mount_overlay(
         error = (stdout.decode() + stderr.decode()).strip()
         raise RuntimeError(f"Failed to mount overlay at {mount_point}: {error}")
 
-    logger.info("Mounted overlay: %s (lower=%s)", mount_point, base)
+    logger.info("Mounted overlay: %s (lower=%s)", mount_point, lower)
 
 
 async def umount_overlay(
@@ -101,11 +138,15 @@ async def umount_overlay(
     docroot: str = DEFAULT_DOCROOT,
     public_path: str = DEFAULT_PUBLIC_PATH,
 ) -> None:
-    """Unmount the overlay filesystem for a preview."""
-    mount_point = get_files_mount_point(preview_path, docroot, public_path)
-    if not await is_mounted(mount_point):
-        return
+    """Unmount the overlay filesystems for a preview, one per multisite site."""
+    mount_points = {get_files_mount_point(preview_path, docroot, public_path)}
+    mount_points.update((preview_path / docroot / "sites").glob("*/files"))
+    for mount_point in sorted(mount_points):
+        if await is_mounted(mount_point):
+            await _umount(mount_point)
+
 
+async def _umount(mount_point: Path) -> None:
     proc = await asyncio.create_subprocess_exec(
         "sudo", "umount", str(mount_point),
         stdout=asyncio.subprocess.PIPE,
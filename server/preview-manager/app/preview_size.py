@@ -0,0 +1,164 @@
+"""Disk usage of a preview, broken down by component.
+
+A preview uses disk in its code checkout, in the upper layers of its files
+overlays (files added or changed on the preview), in the docker volumes of
+its database and solr index and in the writable layers of its containers.
+The base files and docker images are shared with other previews: they are
+reported but not counted in the total of the preview.
+"""
+
+import asyncio
+import logging
+from pathlib import Path
+
+from pydantic import BaseModel
+
+from app.overlay import get_base_files_dir, get_overlay_dir
+
+logger = logging.getLogger(__name__)
+
+# Entries listed under a component, largest first
+LARGEST_ENTRIES = 5
+
+# Volumes of the compose file, see docker_compose.generate_docker_compose
+VOLUMES = {"database": "db_data", "solr": "solr_data"}
+
+
+class SizeEntry(BaseModel):
+    path: str
+    size_bytes: int
+
+
+class SizeComponent(BaseModel):
+    name: str
+    description: str
+    # None if it couldn't be measured
+    size_bytes: int | None
+    # Shared with other previews, not counted in the total
+    shared: bool = False
+    largest: list[SizeEntry] = []
+
+
+async def _output(*command: str, timeout: int = 60) -> str | None:
+    """Return the stdout of command, or None if it fails."""
+    try:
+        process = await asyncio.create_subprocess_exec(
+            *command,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.DEVNULL,
+        )
+        try:
+            stdout, _ = await asyncio.wait_for(process.communicate(), timeout=timeout)
+        except asyncio.TimeoutError:
+            process.kill()
+            logger.warning("Timeout running %s", command[0])
+            return None
+    except Exception as e:
+        logger.warning("Error running %s: %s", command[0], e)
+        return None
+    if process.returncode != 0:
+        return None
+    return stdout.decode()
+
+
+async def _du(path: Path, *extra: str) -> tuple[int | None, list[SizeEntry]]:
+    """Return the bytes used by path and its largest direct entries. Stays
+    on the filesystem of path, so mounted overlays aren't counted."""
+    if not path.exists():
+        return None, []
+    out = await _output("du", "-bx", "--max-depth=1", *extra, str(path), timeout=120)
+    if out is None:
+        return None, []
+    total = None
+    entries = []
+    for line in out.splitlines():
+        size, _, entry = line.partition("\t")
+        if not size.isdigit():
+            continue
+        if Path(entry) == path:
+            total = int(size)
+        else:
+            entries.append(SizeEntry(path=str(Path(entry).relative_to(path)), size_bytes=int(size)))
+    entries.sort(key=lambda e: e.size_bytes, reverse=True)
+    return total, entries[:LARGEST_ENTRIES]
+
+
+async def _volume_size(volume: str) -> int | None:
+    mountpoint = await _output("docker", "volume", "inspect", "-f", "{{.Mountpoint}}", volume)
+    if not mountpoint:
+        return None
+    size, _ = await _du(Path(mountpoint.strip()))
+    return size
+
+
+async def _containers(prefix: str) -> tuple[int | None, list[SizeEntry], list[str]]:
+    """Return the size of the writable layers of the containers of the
+    compose project prefix, one entry per container, and their images."""
+    ids = await _output("docker", "ps", "-aq", "--filter", f"label=com.docker.compose.project={prefix}")
+    if ids is None:
+        return None, [], []
+    if not ids.split():
+        return 0, [], []
+    out = await _output("docker", "inspect", "--size", "-f", "{{.Name}}\t{{.SizeRw}}\t{{.Config.Image}}", *ids.split())
+    if out is None:
+        return None, [], []
+    total = 0
+    entries = []
+    images = []
+    for line in out.splitlines():
+        name, size, image = (line.split("\t") + ["", ""])[:3]
+        if not size.isdigit():
+            continue
+        total += int(size)
+        entries.append(SizeEntry(path=name.lstrip("/"), size_bytes=int(size)))
+        if image and image not in images:
+            images.append(image)
+    entries.sort(key=lambda e: e.size_bytes, reverse=True)
+    return total, entries, images
+
+
+async def _images(images: list[str]) -> tuple[int | None, list[SizeEntry]]:
+    if not images:
+        return None, []
+    out = await _output("docker", "image", "inspect", "-f", "{{.Size}}", *images)
+    if out is None:
+        return None, []
+    sizes = [int(s) for s in out.split() if s.isdigit()]
+    entries = [SizeEntry(path=image, size_bytes=size) for image, size in zip(images, sizes)]
+    entries.sort(key=lambda e: e.size_bytes, reverse=True)
+    return sum(sizes), entries
+
+
+async def measure(project: str, preview_path: Path, prefix: str) -> list[SizeComponent]:
+    """Measure the components of a preview. Slow on large previews: du
+    walks the checkout, the files and the database volume."""
+    overlay = get_overlay_dir(preview_path)
+    (code, code_largest), (files, _), (base, base_largest), containers = await asyncio.gather(
+        _du(preview_path, f"--exclude={overlay.name}"),
+        _du(overlay),
+        _du(get_base_files_dir(project)),
+        _containers(prefix),
+    )
+    volumes = await asyncio.gather(*(_volume_size(f"{prefix}_{v}") for v in VOLUMES.values()))
+    container_size, container_entries, images = containers
+    image_size, image_entries = await _images(images)
+
+    components = [
+        SizeComponent(name="code", description="Code checkout, vendor and private files",
+                      size_bytes=code, largest=code_largest),
+        SizeComponent(name="files", description="Files added or changed on the preview",
+                      size_bytes=files or 0),
+    ]
+    for (name, volume), size in zip(VOLUMES.items(), volumes):
+        if size is None and name != "database":
+            continue
+        components.append(SizeComponent(name=name, description=f"Docker volume {prefix}_{volume}", size_bytes=size))
+    components += [
+        SizeComponent(name="containers", description="Writable layers of the containers",
+                      size_bytes=container_size, largest=container_entries),
+        SizeComponent(name="base-files", description=f"Base files of {project}",
+                      size_bytes=base, shared=True, largest=base_largest),
+        SizeComponent(name="images", description="Docker images",
+                      size_bytes=image_size, shared=True, largest=image_entries),
+    ]
+    return components
@@ -0,0 +1,123 @@
+"""Per-project settings editable from the web UI and the CLI.
+
+Each setting is stored as a string and parsed on read. An empty value
+resets a setting to its default.
+"""
+
+import json
+import re
+
+from app import config_store
+
+# Docker memory limit, e.g. "512m" or "2g"
+_MEMORY_RE = re.compile(r"^\d+[kmg]$")
+
+
+def _parse_minutes(value: str) -> int:
+    minutes = int(value)
+    if minutes <= 0:
+        raise ValueError("must be a positive number of minutes")
+    return minutes
+
+
+def parse_memory(value: str) -> str:
+    value = value.lower()
+    if not _MEMORY_RE.match(value):
+        raise ValueError('must be a size like "512m" or "2g"')
+    return value
+
+
+def parse_cpus(value: str) -> float:
+    cpus = float(value)
+    if cpus <= 0:
+        raise ValueError("must be a positive number of CPUs")
+    return cpus
+
+
+# name -> (parser, description)
+SETTINGS = {
+    "auto_stop_minutes": (_parse_minutes, "Stop idle previews after this many minutes (default: global auto-stop)"),
+    "memory_limit": (parse_memory, "Memory limit of the PHP container, e.g. 2g (default: unlimited)"),
+    "cpu_limit": (parse_cpus, "CPU limit of the PHP container, e.g. 1.5 (default: unlimited)"),
+}
+
+
+async def _load_stored(project: str) -> dict[str, str]:
+    raw = await config_store.get_config(f"project_settings_{project}")
+    try:
+        return json.loads(raw) if raw else {}
+    except (json.JSONDecodeError, TypeError):
+        return {}
+
+
+async def load_project_settings(project: str) -> dict:
+    """Return every setting of a project, None for those at their default."""
+    stored = await _load_stored(project)
+
+    # auto-stop keeps its own keys, shared with the auto-stop endpoints
+    if await config_store.get_config(f"auto_stop_{project}_enabled") == "true":
+        minutes = await config_store.get_config(f"auto_stop_{project}_minutes")
+        if minutes:
+            stored["auto_stop_minutes"] = minutes
+
+    settings = {}
+    for name, (parse, _) in SETTINGS.items():
+        value = stored.get(name)
+        settings[name] = parse(value) if value else None
+    return settings
+
+
+def _resource_key(project: str, preview_name: str) -> str:
+    return f"resources_{project}_{preview_name}"
+
+
+async def load_preview_resources(project: str, preview_name: str) -> dict:
+    """Return the resource limits set on a preview with `preview scale`."""
+    raw = await config_store.get_config(_resource_key(project, preview_name))
+    try:
+        stored = json.loads(raw) if raw else {}
+    except (json.JSONDecodeError, TypeError):
+        stored = {}
+    return {
+        "memory": stored.get("memory"),
+        "cpus": stored.get("cpus"),
+    }
+
+
+async def save_preview_resources(project: str, preview_name: str, resources: dict):
+    """Save the resource limits of a preview; None values are unset."""
+    resources = {k: v for k, v in resources.items() if v is not None}
+    if resources:
+        await config_store.set_config(_resource_key(project, preview_name), json.dumps(resources))
+    else:
+        await config_store.delete_config(_resource_key(project, preview_name))
+
+
+async def update_project_settings(project: str, changes: dict[str, str]):
+    """Validate and save changes. Raises ValueError on unknown settings or
+    invalid values."""
+    for name, value in changes.items():
+        if name not in SETTINGS:
+            raise ValueError(f"unknown setting {name!r}; valid settings: {', '.join(SETTINGS)}")
+        if not isinstance(value, str):
+            raise ValueError(f"{name}: value must be a string")
+        if value:
+            try:
+                SETTINGS[name][0](value)
+            except ValueError as e:
+                raise ValueError(f"{name}: {e}") from None
+
+    stored = await _load_stored(project)
+    for name, value in changes.items():
+        if name == "auto_stop_minutes":
+            if value:
+                await config_store.set_config(f"auto_stop_{project}_enabled", "true")
+                await config_store.set_config(f"auto_stop_{project}_minutes", value)
+            else:
+                await config_store.delete_config(f"auto_stop_{project}_enabled")
+                await config_store.delete_config(f"auto_stop_{project}_minutes")
+        elif value:
+            stored[name] = value
+        else:
+            stored.pop(name, None)
+    await config_store.set_config(f"project_settings_{project}", json.dumps(stored))
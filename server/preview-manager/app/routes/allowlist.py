@@ -0,0 +1,96 @@
+"""IP allowlists of projects and previews
+
+Client projects with strict access requirements can restrict their previews
+to given networks, on top of the login: once a project or a preview has an
+allowlist, verify-preview refuses requests from any other address. Entries
+of the project apply to all its previews; those of a preview add to them.
+"""
+
+import ipaddress
+
+from fastapi import APIRouter, Depends, HTTPException, Request
+from pydantic import BaseModel
+
+from app import database
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+
+router = APIRouter(tags=["allowlist"])
+
+
+class AllowlistRequest(BaseModel):
+    # An address or a network in CIDR notation, e.g. 203.0.113.0/24
+    cidr: str
+    # Restrict this preview only; the whole project if empty
+    preview_name: str = ""
+    note: str = ""
+
+
+def client_ip(request: Request) -> str:
+    """Return the address of the client of a request proxied by Caddy, which
+    puts it first in X-Forwarded-For."""
+    forwarded = request.headers.get("x-forwarded-for", "")
+    if forwarded:
+        return forwarded.split(",")[0].strip()
+    return request.client.host if request.client else ""
+
+
+def ip_allowed(ip: str, networks: list[str]) -> bool:
+    """Whether ip is in one of networks. An unparsable ip never is."""
+    try:
+        addr = ipaddress.ip_address(ip)
+    except ValueError:
+        return False
+    return any(addr in ipaddress.ip_network(n) for n in networks)
+
+
+@router.get("/api/projects/{project}/allowlist")
+async def list_allowlist(
+    project: str,
+    request: Request,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """List the allowlist of a project and its previews, with the address
+    the request came from so clients can warn before locking themselves
+    out."""
+    return {"client_ip": client_ip(request), "entries": await database.list_allowlist(project)}
+
+
+@router.post("/api/projects/{project}/allowlist")
+async def add_allowlist_entry(
+    project: str,
+    body: AllowlistRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Allow a network to reach the previews of a project, or one preview."""
+    try:
+        cidr = str(ipaddress.ip_network(body.cidr.strip(), strict=False))
+    except ValueError:
+        raise HTTPException(status_code=400, detail=f"Invalid network '{body.cidr}': expected an address or CIDR, e.g. 203.0.113.0/24")
+
+    preview_id = None
+    if body.preview_name:
+        preview = await database.get_preview(project, body.preview_name)
+        if not preview:
+            raise HTTPException(status_code=404, detail=f"Preview {project}/{body.preview_name} not found")
+        preview_id = preview["id"]
+
+    entries = await database.list_allowlist(project)
+    if any(e["cidr"] == cidr and (e["preview_name"] or "") == body.preview_name for e in entries):
+        raise HTTPException(status_code=409, detail=f"{cidr} is already in the allowlist")
+
+    entry_id = await database.add_allowlist_entry(project, preview_id, cidr, body.note.strip(), user.email)
+    return next(e for e in await database.list_allowlist(project) if e["id"] == entry_id)
+
+
+@router.delete("/api/projects/{project}/allowlist/{entry_id}")
+async def delete_allowlist_entry(
+    project: str,
+    entry_id: int,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Remove an allowlist entry. Removing the last one opens the previews
+    to any address again."""
+    if not await database.delete_allowlist_entry(project, entry_id):
+        raise HTTPException(status_code=404, detail=f"Allowlist entry {entry_id} not found")
+    return {"success": True}
@@ -5,14 +5,15 @@ import re
 import secrets
 import time
 from pathlib import Path
+from typing import Optional
 
-from fastapi import APIRouter, Depends, HTTPException, Request, Response
+from fastapi import APIRouter, Depends, Header, HTTPException, Request, Response
 from fastapi.responses import RedirectResponse
 
 from config.settings import settings
 from app.auth import database as db
 from app.auth.dependencies import SESSION_COOKIE, get_current_user, require_role
-from app.database import update_last_accessed
+from app.database import get_allowed_networks, get_preview_by_custom_domain, update_last_accessed
 from app.auth.models import (
     AcceptInviteBody,
     AddProjectMemberBody,
@@ -31,6 +32,7 @@ from app.auth.models import (
 from app.auth.email import send_invitation_email
 from app.auth.oauth import get_provider
 from app import config_store
+from app.routes.allowlist import client_ip, ip_allowed
 
 logger = logging.getLogger(__name__)
 
@@ -200,9 +202,17 @@ async def oauth_callback(provider: str, code: str, state: str = ""):
 async def verify_preview(request: Request):
     """Caddy forward_auth: validate session for preview URLs.
 
-    Returns 200 if authenticated, 302 redirect to login if not.
+    Returns 200 if authenticated, 302 redirect to login if not, and 403 if
+    the preview has an IP allowlist the client isn't in.
     Also updates last_accessed_at for the preview.
     """
+    project, preview_name = _preview_from_host(request.headers.get("x-forwarded-host", ""))
+
+    if project and preview_name:
+        networks = await get_allowed_networks(project, preview_name)
+        if networks and not ip_allowed(client_ip(request), networks):
+            return Response(status_code=403, content="Your IP address is not allowed to access this preview")
+
     session_id = request.cookies.get(SESSION_COOKIE)
     if not session_id:
         return _redirect_to_login(request)
@@ -211,43 +221,67 @@ async def verify_preview(request: Request):
     if not session:
         return _redirect_to_login(request)
 
-    # Update last_accessed_at for the preview
-    # The subdomain format is {preview_name}-{project}.mr.preview-mr.com
-    # For MR previews: mr-123-drupal-test.mr.preview-mr.com
-    # For branch previews: branch-develop-drupal-test.mr.preview-mr.com
-    host = request.headers.get("x-forwarded-host", "")
-    match = re.match(r"(.+?)\.mr\.preview-mr\.com", host)
-    if match:
-        subdomain = match.group(1)  # e.g. "mr-123-drupal-test" or "branch-develop-drupal-test"
-        # Try MR pattern first (unambiguous)
-        mr_match = re.match(r"(mr-\d+)-(.+)", subdomain)
-        if mr_match:
-            preview_name = mr_match.group(1)
-            project = mr_match.group(2)
-        else:
-            # For branch previews, find the split point by checking project dirs
-            preview_name = None
-            project = None
-            parts = subdomain.split("-")
-            # Try splitting from the end — project name is the last segment(s)
-            for i in range(len(parts) - 1, 0, -1):
-                candidate_project = "-".join(parts[i:])
-                candidate_preview = "-".join(parts[:i])
-                preview_dir = Path(settings.previews_base_path) / candidate_project / candidate_preview
-                if preview_dir.exists():
-                    preview_name = candidate_preview
-                    project = candidate_project
-                    break
-
-        if preview_name and project:
-            try:
-                await update_last_accessed(project, preview_name)
-            except Exception as e:
-                logger.warning(f"Failed to update last_accessed_at for {project}/{preview_name}: {e}")
+    if project and preview_name:
+        try:
+            await update_last_accessed(project, preview_name)
+        except Exception as e:
+            logger.warning(f"Failed to update last_accessed_at for {project}/{preview_name}: {e}")
+
+    return Response(status_code=200)
+
 
+@router.get("/verify-domain")
+async def verify_domain(request: Request):
+    """Caddy forward_auth for the custom domains of previews.
+
+    There's no login on custom domains (the session cookie belongs to the
+    preview domain): returns 403 if the preview has an IP allowlist the
+    client isn't in, 200 otherwise.
+    """
+    host = request.headers.get("x-forwarded-host", "").split(":")[0].lower()
+    preview = await get_preview_by_custom_domain(host)
+    if not preview:
+        return Response(status_code=404, content="No preview is attached to this domain")
+    project, preview_name = preview["project"], preview["preview_name"]
+
+    networks = await get_allowed_networks(project, preview_name)
+    if networks and not ip_allowed(client_ip(request), networks):
+        return Response(status_code=403, content="Your IP address is not allowed to access this preview")
+
+    try:
+        await update_last_accessed(project, preview_name)
+    except Exception as e:
+        logger.warning(f"Failed to update last_accessed_at for {project}/{preview_name}: {e}")
     return Response(status_code=200)
 
 
+def _preview_from_host(host: str) -> tuple[str | None, str | None]:
+    """Return the project and preview name of a preview host, or Nones.
+
+    The subdomain format is {preview_name}-{project}.mr.preview-mr.com
+    For MR previews: mr-123-drupal-test.mr.preview-mr.com
+    For branch previews: branch-develop-drupal-test.mr.preview-mr.com
+    """
+    match = re.match(r"(.+?)\.mr\.preview-mr\.com", host)
+    if not match:
+        return None, None
+    subdomain = match.group(1)  # e.g. "mr-123-drupal-test" or "branch-develop-drupal-test"
+    # Try MR pattern first (unambiguous)
+    mr_match = re.match(r"(mr-\d+)-(.+)", subdomain)
+    if mr_match:
+        return mr_match.group(2), mr_match.group(1)
+    # For branch previews, find the split point by checking project dirs
+    parts = subdomain.split("-")
+    # Try splitting from the end — project name is the last segment(s)
+    for i in range(len(parts) - 1, 0, -1):
+        candidate_project = "-".join(parts[i:])
+        candidate_preview = "-".join(parts[:i])
+        preview_dir = Path(settings.previews_base_path) / candidate_project / candidate_preview
+        if preview_dir.exists():
+            return candidate_project, candidate_preview
+    return None, None
+
+
 def _redirect_to_login(request: Request) -> RedirectResponse:
     """Build a redirect to the login page with the original URL as redirect_to."""
     original_host = request.headers.get("x-forwarded-host", "")
@@ -280,6 +314,38 @@ async def list_tokens(user: UserWithRole = Depends(require_role(Role.manager))):
     return {"tokens": tokens}
 
 
+# What each role allows, reported as the scopes of its tokens
+ROLE_SCOPES = {
+    Role.viewer: ["read"],
+    Role.manager: ["read", "write"],
+    Role.admin: ["read", "write", "admin"],
+}
+
+
+@router.get("/token")
+async def get_token_info(
+    user: UserWithRole = Depends(get_current_user),
+    authorization: Optional[str] = Header(None),
+):
+    """Describe the API token the request authenticates with. Tokens of
+    this server don't expire; their scopes follow the role of the user."""
+    token = None
+    if authorization and authorization.startswith("Bearer "):
+        token = await db.get_api_token(authorization[7:])
+    if not token or token["user_id"] != user.id:
+        raise HTTPException(status_code=404, detail="Not authenticated with an API token")
+    projects = [] if user.role == Role.admin else await db.get_user_project_slugs(user.id)
+    return {
+        "name": token["name"],
+        "token_prefix": token["token_prefix"],
+        "created_at": token["created_at"],
+        "last_used_at": token["last_used_at"],
+        "expires_at": None,
+        "scopes": ROLE_SCOPES.get(user.role, []),
+        "projects": projects,
+    }
+
+
 @router.post("/tokens")
 async def create_token(body: CreateTokenRequest, user: UserWithRole = Depends(require_role(Role.manager))):
     token_id, raw_token = await db.create_api_token(user.id, body.name)
@@ -3,19 +3,26 @@
 Files are extracted on upload into .base-files/{project}/files/ and shared
 across previews via OverlayFS. The tar.gz is not kept on disk.
 
+The base files an upload replaces are kept as prior versions, the last
+settings.base_versions_kept of each kind, so a botched upload can be rolled back
+with the restore endpoint.
+
 Supports chunked uploads for large files (>50MB) via init/chunk/complete flow.
 """
 
 import asyncio
+import hashlib
 import json
 import logging
 import os
+import re
 import shutil
 import tempfile
 import time
 import uuid
 from datetime import datetime, timezone
 from pathlib import Path
+from typing import Optional
 
 from fastapi import APIRouter, Depends, Form, HTTPException, UploadFile
 from fastapi.responses import StreamingResponse
@@ -23,6 +30,9 @@ from pydantic import BaseModel
 
 from app.auth.dependencies import require_role
 from app.auth.models import Role, UserWithRole
+from app import base_verify, db_sync
+from app.docker_compose import valid_database_name
+from config.settings import settings
 from app.overlay import (
     get_base_files_dir,
     umount_all_for_project,
@@ -45,6 +55,28 @@ class BaseFileInfo(BaseModel):
 class BaseFilesStatus(BaseModel):
     db: BaseFileInfo | None = None
     files: BaseFileInfo | None = None
+    # Dumps of the secondary databases of preview.yml, by name
+    databases: dict[str, BaseFileInfo] = {}
+
+
+class BaseFileUpload(BaseModel):
+    kind: str
+    uploaded_at: str
+    uploaded_by: str
+    size_bytes: int
+    sha256: str
+    # Set on restores to the uploaded_at of the version restored
+    restored_from: str | None = None
+    # Set by the history endpoint: whether this is the base file in use,
+    # and whether it is a prior version kept on the server
+    current: bool = False
+    restorable: bool = False
+
+
+class RestoreRequest(BaseModel):
+    # The start of the uploaded_at (e.g. "2024-11-03") or of the sha256 of
+    # the upload to restore
+    version: str
 
 
 def _file_info(path: Path) -> BaseFileInfo | None:
@@ -67,10 +99,144 @@ def _dir_info(path: Path) -> BaseFileInfo | None:
     return BaseFileInfo(exists=True, size_bytes=total, modified_at=mtime)
 
 
-def _db_path(slug: str) -> Path:
+def _db_path(slug: str, database: str | None = None) -> Path:
+    """Path of the base dump of the main database, or of a secondary one."""
+    if database:
+        return BACKUPS_DIR / f"{slug}-base-db-{database}.sql.gz"
     return BACKUPS_DIR / f"{slug}-base.sql.gz"
 
 
+# Dumps of the secondary databases of preview.yml ("databases:") are
+# uploaded as kind "db-NAME", e.g. "db-migrate".
+SECONDARY_DB_PREFIX = "db-"
+
+
+def _secondary_database(kind: str) -> str | None:
+    """Return the secondary database a kind uploads, or None."""
+    name = kind.removeprefix(SECONDARY_DB_PREFIX)
+    if name != kind and valid_database_name(name):
+        return name
+    return None
+
+
+def _secondary_databases(slug: str) -> list[str]:
+    """Return the secondary databases with a base dump."""
+    prefix = f"{slug}-base-{SECONDARY_DB_PREFIX}"
+    names = (p.name[len(prefix):-len(".sql.gz")] for p in BACKUPS_DIR.glob(f"{prefix}*.sql.gz"))
+    return sorted(n for n in names if valid_database_name(n))
+
+
+# Client-side encrypted bases are opaque blobs: stored and served as-is,
+# never imported or extracted. The key never reaches the server.
+ENCRYPTED_KINDS = {"db-encrypted", "files-encrypted"}
+
+
+def _encrypted_path(slug: str, kind: str) -> Path:
+    return BACKUPS_DIR / f"{slug}-base-{kind}.bin"
+
+
+def _heavy_manifest_path(slug: str) -> Path:
+    return BACKUPS_DIR / f"{slug}-base-heavy-files.json"
+
+
+def _history_path(slug: str) -> Path:
+    return BACKUPS_DIR / f"{slug}-base-history.json"
+
+
+def _versions_dir(slug: str) -> Path:
+    return BACKUPS_DIR / ".versions" / slug
+
+
+def _version_path(slug: str, kind: str, sha256: str) -> Path:
+    if kind in ENCRYPTED_KINDS:
+        suffix = ".bin"
+    elif kind == "files":
+        suffix = ".tar.gz"
+    else:
+        suffix = ".sql.gz"
+    return _versions_dir(slug) / f"{kind}-{sha256}{suffix}"
+
+
+def _versions(slug: str, kind: str) -> list[Path]:
+    """Return the prior versions kept of kind, newest first."""
+    pattern = re.compile(rf"^{re.escape(kind)}-[0-9a-f]{{64}}\.")
+    directory = _versions_dir(slug)
+    if not directory.exists():
+        return []
+    paths = [p for p in directory.iterdir() if pattern.match(p.name)]
+    return sorted(paths, key=lambda p: p.stat().st_mtime, reverse=True)
+
+
+def _current_path(slug: str, kind: str) -> Path:
+    """Path of the base file of kind in use."""
+    if kind == "files":
+        return get_base_files_dir(slug).parent / "files.tar.gz"
+    if kind in ENCRYPTED_KINDS:
+        return _encrypted_path(slug, kind)
+    return _db_path(slug, _secondary_database(kind))
+
+
+def _keep_version(slug: str, kind: str, restored_from: str | None = None) -> None:
+    """Move the base file of kind in use aside before it is replaced, and
+    drop the oldest versions beyond base_versions_kept. The version being
+    restored, if any, is dropped by the restore once in use: it doesn't
+    count."""
+    current = _current_path(slug, kind)
+    if settings.base_versions_kept <= 0 or not current.exists():
+        return
+    recorded = [e for e in _load_history(slug) if e["kind"] == kind]
+    sha256 = recorded[-1]["sha256"] if recorded else _sha256(current)
+    restoring = {
+        _version_path(slug, kind, e["sha256"]) for e in recorded
+        if restored_from and e["uploaded_at"] == restored_from
+    }
+
+    dest = _version_path(slug, kind, sha256)
+    dest.parent.mkdir(parents=True, exist_ok=True)
+    shutil.move(str(current), str(dest))
+    # Versions are ordered by when they were replaced
+    os.utime(dest)
+    kept = [v for v in _versions(slug, kind) if v not in restoring]
+    for old in kept[settings.base_versions_kept:]:
+        old.unlink()
+        logger.info("Dropped base %s version %s", kind, old.name)
+
+
+def _sha256(path: Path) -> str:
+    h = hashlib.sha256()
+    with open(path, "rb") as f:
+        while chunk := f.read(1024 * 1024):
+            h.update(chunk)
+    return h.hexdigest()
+
+
+def _load_history(slug: str) -> list[dict]:
+    path = _history_path(slug)
+    if not path.exists():
+        return []
+    try:
+        return json.loads(path.read_text())
+    except Exception as e:
+        logger.warning("Unreadable base files history %s: %s", path, e)
+        return []
+
+
+def _record_upload(slug: str, kind: str, file_path: Path, user: UserWithRole, restored_from: str | None = None) -> None:
+    """Append an upload to the project's base files history (newest last)."""
+    entry = BaseFileUpload(
+        kind=kind,
+        uploaded_at=datetime.now(timezone.utc).isoformat(),
+        uploaded_by=user.email,
+        size_bytes=file_path.stat().st_size,
+        sha256=_sha256(file_path),
+        restored_from=restored_from,
+    )
+    history = _load_history(slug)
+    history.append(entry.model_dump())
+    BACKUPS_DIR.mkdir(parents=True, exist_ok=True)
+    _history_path(slug).write_text(json.dumps(history, indent=2))
+
+
 @router.get("/api/projects/{slug}/base-files")
 async def get_base_files_status(
     slug: str,
@@ -80,9 +246,83 @@ async def get_base_files_status(
     return BaseFilesStatus(
         db=_file_info(_db_path(slug)),
         files=_dir_info(base_dir),
+        databases={name: _file_info(_db_path(slug, name)) for name in _secondary_databases(slug)},
     )
 
 
+@router.get("/api/projects/{slug}/base-files/history")
+async def get_base_files_history(
+    slug: str,
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
+    """List base DB/files uploads for a project, newest first, telling
+    which are in use and which can be restored."""
+    history = [BaseFileUpload(**e) for e in reversed(_load_history(slug))]
+    seen = set()
+    for entry in history:
+        entry.current = entry.kind not in seen
+        seen.add(entry.kind)
+        entry.restorable = not entry.current and _version_path(slug, entry.kind, entry.sha256).exists()
+    return {"uploads": history}
+
+
+@router.post("/api/projects/{slug}/base-files/{kind}/restore")
+async def restore_base_file(
+    slug: str,
+    kind: str,
+    body: RestoreRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Put a prior version of a base file back in use. The version it
+    replaces is kept in turn, so a restore can be undone the same way."""
+    version = body.version.strip().lower()
+    if not version:
+        raise HTTPException(status_code=400, detail="version required: the date or SHA-256 of an upload")
+    history = [BaseFileUpload(**e) for e in _load_history(slug) if e["kind"] == kind]
+    if not history:
+        raise HTTPException(status_code=404, detail=f"No base {kind} has been uploaded for {slug}")
+    current = history[-1]
+
+    matches = {
+        e.sha256: e for e in history
+        if e.uploaded_at.lower().startswith(version) or e.sha256.startswith(version)
+    }
+    if len(matches) > 1:
+        # e.g. the date of both the upload in use and an earlier one
+        matches.pop(current.sha256, None)
+    if not matches:
+        raise HTTPException(status_code=404, detail=f"No base {kind} upload matches version '{body.version}' (see 'preview base history')")
+    if len(matches) > 1:
+        found = ", ".join(f"{e.uploaded_at} ({e.sha256[:12]})" for e in matches.values())
+        raise HTTPException(status_code=409, detail=f"Version '{body.version}' matches several uploads: {found}; give more of the date or SHA-256")
+    entry = next(iter(matches.values()))
+    if entry.sha256 == current.sha256:
+        raise HTTPException(status_code=409, detail=f"The base {kind} uploaded {entry.uploaded_at} is the one in use")
+    version_path = _version_path(slug, kind, entry.sha256)
+    if not version_path.exists():
+        raise HTTPException(status_code=410, detail=f"The base {kind} uploaded {entry.uploaded_at} is no longer kept on the server")
+
+    # Restored from a copy: the version stays kept until it is in use
+    fd, tmp_path = tempfile.mkstemp(dir=str(BACKUPS_DIR), suffix=".tmp")
+    os.close(fd)
+    try:
+        shutil.copyfile(version_path, tmp_path)
+        logger.info("Restoring base %s of %s uploaded %s by %s", kind, slug, entry.uploaded_at, user.email)
+        if kind in ENCRYPTED_KINDS:
+            await _process_encrypted(slug, kind, Path(tmp_path), user, restored_from=entry.uploaded_at)
+        elif kind == "files":
+            await _process_files(slug, Path(tmp_path), user, restored_from=entry.uploaded_at)
+        else:
+            await _process_db(slug, Path(tmp_path), user, _secondary_database(kind), restored_from=entry.uploaded_at)
+    finally:
+        Path(tmp_path).unlink(missing_ok=True)
+    # Now in use, it is kept no more as a prior version
+    version_path.unlink(missing_ok=True)
+    restored = BaseFileUpload(**_load_history(slug)[-1])
+    restored.current = True
+    return restored
+
+
 @router.get("/api/projects/{slug}/base-files/db")
 async def download_base_db(
     slug: str,
@@ -132,13 +372,106 @@ async def download_base_files(
     )
 
 
+class HeavyFile(BaseModel):
+    path: str
+    size_bytes: int
+
+
+class HeavyFilesManifest(BaseModel):
+    files: list[HeavyFile]
+    recorded_at: str = ""
+
+
+@router.get("/api/projects/{slug}/base-files/files/heavy-manifest", response_model=HeavyFilesManifest)
+async def get_heavy_files_manifest(
+    slug: str,
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
+    """Return the files left out of the base files archive as too heavy."""
+    path = _heavy_manifest_path(slug)
+    if not path.exists():
+        raise HTTPException(status_code=404, detail="No heavy files manifest")
+    return HeavyFilesManifest.model_validate_json(path.read_text())
+
+
+@router.put("/api/projects/{slug}/base-files/files/heavy-manifest", response_model=HeavyFilesManifest)
+async def save_heavy_files_manifest(
+    slug: str,
+    manifest: HeavyFilesManifest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Record the files left out of the base files archive just uploaded.
+    Uploading a new archive discards the manifest."""
+    manifest.recorded_at = datetime.now(timezone.utc).isoformat()
+    BACKUPS_DIR.mkdir(parents=True, exist_ok=True)
+    _heavy_manifest_path(slug).write_text(manifest.model_dump_json(indent=2))
+    return manifest
+
+
+@router.post("/api/projects/{slug}/base-files/verify")
+async def verify_base_files(
+    slug: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Check the integrity of the stored base database and files. Reads them
+    in full, so it takes about as long as downloading them on the server."""
+    recorded = {}
+    for entry in _load_history(slug):
+        recorded[entry["kind"]] = entry["sha256"]
+
+    base_dir = get_base_files_dir(slug)
+    checks = [
+        await asyncio.to_thread(base_verify.verify_db, _db_path(slug), recorded.get("db")),
+        await asyncio.to_thread(base_verify.verify_files, base_dir.parent / "files.tar.gz", base_dir, recorded.get("files")),
+    ]
+    for name in _secondary_databases(slug):
+        kind = SECONDARY_DB_PREFIX + name
+        checks.append(await asyncio.to_thread(base_verify.verify_db, _db_path(slug, name), recorded.get(kind), kind))
+    for kind in sorted(ENCRYPTED_KINDS):
+        path = _encrypted_path(slug, kind)
+        if path.exists():
+            checks.append(await asyncio.to_thread(base_verify.verify_encrypted, kind, path, recorded.get(kind)))
+
+    for check in checks:
+        if check.status == "failed":
+            logger.warning("Base %s of %s failed verification: %s", check.kind, slug, check.message)
+    return {"checks": checks}
+
+
+@router.get("/api/projects/{slug}/base-files/{kind}")
+async def download_encrypted_base_file(
+    slug: str,
+    kind: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    if kind not in ENCRYPTED_KINDS:
+        raise HTTPException(status_code=404, detail="Unknown base file kind")
+    path = _encrypted_path(slug, kind)
+    if not path.exists():
+        raise HTTPException(status_code=404, detail="Encrypted base file not found")
+
+    async def _stream():
+        with open(path, "rb") as f:
+            while chunk := f.read(64 * 1024):
+                yield chunk
+
+    return StreamingResponse(
+        _stream(),
+        media_type="application/octet-stream",
+        headers={
+            "Content-Disposition": f'attachment; filename="{path.name}"',
+            "Content-Length": str(path.stat().st_size),
+        },
+    )
+
+
 @router.post("/api/projects/{slug}/base-files/db")
 async def upload_base_db(
     slug: str,
     file: UploadFile,
     user: UserWithRole = Depends(require_role(Role.manager)),
 ):
-    return await _upload_db(slug, file)
+    return await _upload_db(slug, file, user)
 
 
 @router.post("/api/projects/{slug}/base-files/files")
@@ -147,7 +480,59 @@ async def upload_base_files(
     file: UploadFile,
     user: UserWithRole = Depends(require_role(Role.manager)),
 ):
-    return await _upload_and_extract_files(slug, file)
+    return await _upload_and_extract_files(slug, file, user)
+
+
+@router.post("/api/projects/{slug}/base-files/{kind}")
+async def upload_encrypted_base_file(
+    slug: str,
+    kind: str,
+    file: UploadFile,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    if kind not in ENCRYPTED_KINDS:
+        raise HTTPException(status_code=404, detail="Unknown base file kind")
+    tmp_path = await _save_upload_to_temp(file)
+    return await _process_encrypted(slug, kind, Path(tmp_path), user)
+
+
+# ---------------------------------------------------------------------------
+# Sync from production
+# ---------------------------------------------------------------------------
+
+@router.post("/api/projects/{slug}/base-files/db/sync")
+async def sync_base_db(
+    slug: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Start replacing the base DB with a sanitized dump of the production
+    source configured for the project. Poll the returned job for progress."""
+    source = await db_sync.load_source(slug)
+    if not source:
+        raise HTTPException(status_code=400, detail=f"No production database source configured for {slug}")
+    if db_sync.running_job(slug):
+        raise HTTPException(status_code=409, detail=f"A database sync is already running for {slug}")
+    return {"job_id": db_sync.start_sync(slug, source, user)}
+
+
+@router.get("/api/projects/{slug}/base-files/db/sync/{job_id}")
+async def get_sync_base_db_progress(
+    slug: str,
+    job_id: str,
+    offset: int = 0,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Poll a database sync. Returns log lines from offset onward."""
+    job = db_sync.get_job(job_id)
+    if not job or job["project"] != slug:
+        raise HTTPException(status_code=404, detail="Sync job not found")
+    lines = job["logs"][offset:]
+    return {
+        "lines": lines,
+        "offset": offset + len(lines),
+        "complete": job["complete"],
+        "status": job["status"],
+    }
 
 
 async def _save_upload_to_temp(upload: UploadFile) -> str:
@@ -165,33 +550,49 @@ async def _save_upload_to_temp(upload: UploadFile) -> str:
     return tmp_path
 
 
-async def _process_db(slug: str, file_path: Path) -> dict:
+async def _process_db(slug: str, file_path: Path, user: UserWithRole, database: str | None = None,
+                      restored_from: str | None = None) -> dict:
     """Process a database dump file: move to final destination."""
-    dest = _db_path(slug)
+    kind = SECONDARY_DB_PREFIX + database if database else "db"
+    _keep_version(slug, kind, restored_from)
+    _record_upload(slug, kind, file_path, user, restored_from)
+    dest = _db_path(slug, database)
     BACKUPS_DIR.mkdir(parents=True, exist_ok=True)
     shutil.move(str(file_path), str(dest))
     logger.info("Uploaded base DB %s (%d bytes)", dest, dest.stat().st_size)
     return {"success": True, "path": str(dest), "size_bytes": dest.stat().st_size}
 
 
-async def _process_files(slug: str, file_path: Path) -> dict:
-    """Process a files tar.gz: extract, chown, remount overlays."""
+async def _process_encrypted(slug: str, kind: str, file_path: Path, user: UserWithRole,
+                             restored_from: str | None = None) -> dict:
+    """Store an encrypted base file as-is."""
+    _keep_version(slug, kind, restored_from)
+    _record_upload(slug, kind, file_path, user, restored_from)
+    dest = _encrypted_path(slug, kind)
+    shutil.move(str(file_path), str(dest))
+    logger.info("Uploaded encrypted base %s (%d bytes)", dest, dest.stat().st_size)
+    return {"success": True, "path": str(dest), "size_bytes": dest.stat().st_size}
+
+
+async def _process_files(slug: str, file_path: Path, user: UserWithRole, restored_from: str | None = None) -> dict:
+    """Process a files tar.gz: extract, chown, remount overlays.
+
+    The archive is extracted beside the base files in use, which are only
+    replaced (and kept as a prior version) once that succeeded: a broken
+    upload leaves the base files, their history and their versions as they
+    were. file_path is consumed either way."""
     tar_size = file_path.stat().st_size
     logger.info("Processing files tar.gz for %s (%d bytes)", slug, tar_size)
+    base_dir = get_base_files_dir(slug)
+    staging_dir = base_dir.with_name(base_dir.name + ".new")
 
     try:
-        # 1. Unmount all overlays for this project
-        await umount_all_for_project(slug)
-
-        # 2. Replace base-files directory
-        base_dir = get_base_files_dir(slug)
-        if base_dir.exists():
-            shutil.rmtree(base_dir)
-        base_dir.mkdir(parents=True)
-
-        # 3. Extract tar.gz
+        # 1. Extract tar.gz beside the base files in use
+        if staging_dir.exists():
+            shutil.rmtree(staging_dir)
+        staging_dir.mkdir(parents=True)
         proc = await asyncio.create_subprocess_exec(
-            "tar", "xzf", str(file_path), "-C", str(base_dir),
+            "tar", "xzf", str(file_path), "-C", str(staging_dir),
             stdout=asyncio.subprocess.PIPE,
             stderr=asyncio.subprocess.PIPE,
         )
@@ -200,29 +601,42 @@ async def _process_files(slug: str, file_path: Path) -> dict:
             error = (stdout.decode() + stderr.decode()).strip()
             raise RuntimeError(f"Failed to extract files: {error}")
 
-        # 4. Fix ownership (www-data:www-data, UID/GID 33)
+        # 2. Fix ownership (www-data:www-data, UID/GID 33)
         proc = await asyncio.create_subprocess_exec(
-            "chown", "-R", "33:33", str(base_dir),
+            "chown", "-R", "33:33", str(staging_dir),
             stdout=asyncio.subprocess.PIPE,
             stderr=asyncio.subprocess.PIPE,
         )
         await proc.communicate()
+    except BaseException:
+        shutil.rmtree(staging_dir, ignore_errors=True)
+        file_path.unlink(missing_ok=True)
+        raise
 
-        # 5. Touch directory so mtime reflects upload time (tar preserves original dates)
-        os.utime(base_dir)
+    # 3. Unmount all overlays for this project and swap the directories
+    await umount_all_for_project(slug)
+    try:
+        if base_dir.exists():
+            shutil.rmtree(base_dir)
+        staging_dir.rename(base_dir)
 
+        # 4. Touch directory so mtime reflects upload time (tar preserves original dates)
+        os.utime(base_dir)
         logger.info("Extracted base files to %s", base_dir)
 
+        # 5. Keep the tar.gz alongside extracted files for fast downloads,
+        # the one it replaces as a prior version
+        _keep_version(slug, "files", restored_from)
+        _record_upload(slug, "files", file_path, user, restored_from)
+        tar_dest = base_dir.parent / "files.tar.gz"
+        shutil.move(str(file_path), str(tar_dest))
+        logger.info("Saved tar.gz at %s", tar_dest)
+        # The manifest described the previous archive
+        _heavy_manifest_path(slug).unlink(missing_ok=True)
+    finally:
         # 6. Remount overlays for all active previews
         await remount_all_for_project(slug)
 
-    finally:
-        # 7. Keep the tar.gz alongside extracted files for fast downloads
-        tar_dest = get_base_files_dir(slug).parent / "files.tar.gz"
-        if file_path.exists():
-            shutil.move(str(file_path), str(tar_dest))
-            logger.info("Saved tar.gz at %s", tar_dest)
-
     # Remove legacy tar.gz from /backups/ if it exists
     legacy_tar = BACKUPS_DIR / f"{slug}-files.tar.gz"
     if legacy_tar.exists():
@@ -232,16 +646,16 @@ async def _process_files(slug: str, file_path: Path) -> dict:
     return {"success": True, "path": str(base_dir)}
 
 
-async def _upload_db(slug: str, upload: UploadFile) -> dict:
+async def _upload_db(slug: str, upload: UploadFile, user: UserWithRole) -> dict:
     """Upload database dump (kept as .sql.gz)."""
     tmp_path = await _save_upload_to_temp(upload)
-    return await _process_db(slug, Path(tmp_path))
+    return await _process_db(slug, Path(tmp_path), user)
 
 
-async def _upload_and_extract_files(slug: str, upload: UploadFile) -> dict:
+async def _upload_and_extract_files(slug: str, upload: UploadFile, user: UserWithRole) -> dict:
     """Upload files tar.gz, extract to .base-files/{project}/files/."""
     tmp_path = await _save_upload_to_temp(upload)
-    return await _process_files(slug, Path(tmp_path))
+    return await _process_files(slug, Path(tmp_path), user)
 
 
 # ---------------------------------------------------------------------------
@@ -250,11 +664,16 @@ async def _upload_and_extract_files(slug: str, upload: UploadFile) -> dict:
 
 UPLOAD_TMP = Path("/backups/.uploads")
 CHUNK_EXPIRY_SECONDS = 2 * 3600  # 2 hours
+# Uploads that received no chunk for this long are reported as stale
+UPLOAD_STALE_SECONDS = 10 * 60
 
 
 class ChunkedInitRequest(BaseModel):
-    total_chunks: int
-    total_size: int
+    # Streaming uploads leave the totals out: chunks are sent while the
+    # file is still being produced, and the count is given on complete
+    total_chunks: Optional[int] = None
+    total_size: Optional[int] = None
+    filename: Optional[str] = None
 
 
 @router.post("/api/projects/{slug}/base-files/{kind}/upload/init")
@@ -264,9 +683,12 @@ async def chunked_upload_init(
     body: ChunkedInitRequest,
     user: UserWithRole = Depends(require_role(Role.manager)),
 ):
-    if kind not in ("db", "files"):
-        raise HTTPException(status_code=400, detail="kind must be 'db' or 'files'")
-    if body.total_chunks < 1:
+    if kind not in ("db", "files") and kind not in ENCRYPTED_KINDS and not _secondary_database(kind):
+        raise HTTPException(
+            status_code=400,
+            detail="kind must be 'db', 'files', 'db-NAME' for a secondary database or an encrypted kind",
+        )
+    if body.total_chunks is not None and body.total_chunks < 1:
         raise HTTPException(status_code=400, detail="total_chunks must be >= 1")
 
     upload_id = str(uuid.uuid4())
@@ -278,12 +700,18 @@ async def chunked_upload_init(
         "kind": kind,
         "total_chunks": body.total_chunks,
         "total_size": body.total_size,
+        "filename": body.filename or "",
+        "started_by": user.email,
         "created_at": time.time(),
+        "updated_at": time.time(),
         "received_chunks": [],
     }
     (upload_dir / "meta.json").write_text(json.dumps(meta))
 
-    logger.info("Chunked upload init: %s, %d chunks, %d bytes", upload_id, body.total_chunks, body.total_size)
+    if body.total_chunks is None:
+        logger.info("Chunked upload init: %s, streaming", upload_id)
+    else:
+        logger.info("Chunked upload init: %s, %d chunks, %d bytes", upload_id, body.total_chunks, body.total_size or 0)
     return {"upload_id": upload_id}
 
 
@@ -305,7 +733,9 @@ async def chunked_upload_chunk(
     meta = json.loads(meta_path.read_text())
     if meta["slug"] != slug or meta["kind"] != kind:
         raise HTTPException(status_code=400, detail="slug/kind mismatch")
-    if chunk_index < 0 or chunk_index >= meta["total_chunks"]:
+    if chunk_index < 0:
+        raise HTTPException(status_code=400, detail="chunk_index must be >= 0")
+    if meta["total_chunks"] is not None and chunk_index >= meta["total_chunks"]:
         raise HTTPException(status_code=400, detail=f"chunk_index out of range (0..{meta['total_chunks']-1})")
 
     # Write chunk to disk
@@ -324,10 +754,11 @@ async def chunked_upload_chunk(
     # Track received chunks
     if chunk_index not in meta["received_chunks"]:
         meta["received_chunks"].append(chunk_index)
-        meta_path.write_text(json.dumps(meta))
+    meta["updated_at"] = time.time()
+    meta_path.write_text(json.dumps(meta))
 
-    logger.info("Chunk %d/%d received for upload %s (%d bytes)",
-                chunk_index + 1, meta["total_chunks"], upload_id, chunk_path.stat().st_size)
+    logger.info("Chunk %d/%s received for upload %s (%d bytes)",
+                chunk_index + 1, meta["total_chunks"] or "?", upload_id, chunk_path.stat().st_size)
     return {"received": chunk_index}
 
 
@@ -352,6 +783,12 @@ async def chunked_upload_complete(
     if meta["slug"] != slug or meta["kind"] != kind:
         raise HTTPException(status_code=400, detail="slug/kind mismatch")
 
+    if meta["total_chunks"] is None:
+        total_chunks = body.get("total_chunks")
+        if not isinstance(total_chunks, int) or total_chunks < 1:
+            raise HTTPException(status_code=400, detail="total_chunks required to complete a streaming upload")
+        meta["total_chunks"] = total_chunks
+
     # Verify all chunks received
     expected = set(range(meta["total_chunks"]))
     received = set(meta["received_chunks"])
@@ -371,12 +808,23 @@ async def chunked_upload_complete(
 
         final_size = os.path.getsize(final_path)
         logger.info("Reassembled %d chunks into %s (%d bytes)", meta["total_chunks"], final_path, final_size)
+        # Clients adapting their chunk size resend failed chunks smaller,
+        # so check the chunks add up
+        if meta.get("total_size") and final_size != meta["total_size"]:
+            raise HTTPException(
+                status_code=400,
+                detail=f"Reassembled upload is {final_size} bytes, expected {meta['total_size']}",
+            )
 
         # Process the reassembled file
-        if kind == "db":
-            result = await _process_db(slug, Path(final_path))
+        if kind in ENCRYPTED_KINDS:
+            result = await _process_encrypted(slug, kind, Path(final_path), user)
+        elif kind == "db":
+            result = await _process_db(slug, Path(final_path), user)
+        elif database := _secondary_database(kind):
+            result = await _process_db(slug, Path(final_path), user, database)
         else:
-            result = await _process_files(slug, Path(final_path))
+            result = await _process_files(slug, Path(final_path), user)
 
     except Exception:
         if os.path.exists(final_path):
@@ -389,6 +837,130 @@ async def chunked_upload_complete(
     return result
 
 
+@router.post("/api/projects/{slug}/base-files/{kind}/upload/abort")
+async def chunked_upload_abort(
+    slug: str,
+    kind: str,
+    body: dict,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Discard the chunks of an upload the client gave up on, e.g. because
+    the dump being streamed failed, instead of waiting for the cleanup."""
+    upload_id = body.get("upload_id")
+    if not upload_id:
+        raise HTTPException(status_code=400, detail="upload_id required")
+    try:
+        # The directory is deleted: never let the ID reach outside UPLOAD_TMP
+        upload_id = str(uuid.UUID(upload_id))
+    except (TypeError, ValueError):
+        raise HTTPException(status_code=400, detail="Invalid upload_id")
+
+    upload_dir = UPLOAD_TMP / upload_id
+    meta_path = upload_dir / "meta.json"
+    if not meta_path.exists():
+        raise HTTPException(status_code=404, detail="Upload not found")
+
+    meta = json.loads(meta_path.read_text())
+    if meta["slug"] != slug or meta["kind"] != kind:
+        raise HTTPException(status_code=400, detail="slug/kind mismatch")
+
+    shutil.rmtree(upload_dir, ignore_errors=True)
+    logger.info("Chunked upload aborted: %s (%d chunks received)", upload_id, len(meta["received_chunks"]))
+    return {"success": True}
+
+
+def _iso(ts: float) -> str:
+    return datetime.fromtimestamp(ts, tz=timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _upload_session(entry: Path, now: float) -> dict:
+    """Describe the upload directory entry. Directories without a readable
+    meta.json are orphaned: left by a crash, or by an older server."""
+    bytes_received = 0
+    chunks = 0
+    for part in entry.iterdir():
+        if part.name == "meta.json" or not part.is_file():
+            continue
+        bytes_received += part.stat().st_size
+        if part.suffix == ".part":
+            chunks += 1
+
+    try:
+        meta = json.loads((entry / "meta.json").read_text())
+    except (OSError, ValueError):
+        meta = None
+    if not isinstance(meta, dict):
+        meta = {}
+        created = updated = entry.stat().st_mtime
+        state = "orphaned"
+    else:
+        created = meta.get("created_at", 0)
+        updated = meta.get("updated_at", created)
+        state = "stale" if now - updated > UPLOAD_STALE_SECONDS else "active"
+
+    return {
+        "upload_id": entry.name,
+        "project": meta.get("slug", ""),
+        "kind": meta.get("kind", ""),
+        "filename": meta.get("filename", ""),
+        "started_by": meta.get("started_by", ""),
+        "created_at": _iso(created),
+        "updated_at": _iso(updated),
+        # Matches what cleanup_stale_uploads_loop goes by
+        "expires_at": _iso(created + CHUNK_EXPIRY_SECONDS),
+        "chunks_received": chunks,
+        "total_chunks": meta.get("total_chunks") or 0,
+        "bytes_received": bytes_received,
+        "state": state,
+    }
+
+
+def _upload_entry(upload_id: str) -> Path:
+    """Return the directory of an upload, which must be a direct child of
+    UPLOAD_TMP: it is deleted on abort."""
+    if UPLOAD_TMP.exists():
+        for entry in UPLOAD_TMP.iterdir():
+            if entry.name == upload_id and entry.is_dir() and not entry.is_symlink():
+                return entry
+    raise HTTPException(status_code=404, detail="Upload not found")
+
+
+@router.get("/api/uploads")
+async def list_uploads(
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """List the chunked uploads held on disk, oldest first, so stale and
+    orphaned ones can be found and aborted."""
+    if not UPLOAD_TMP.exists():
+        return {"uploads": []}
+    now = time.time()
+    uploads = []
+    for entry in UPLOAD_TMP.iterdir():
+        if not entry.is_dir() or entry.is_symlink():
+            continue
+        try:
+            uploads.append(_upload_session(entry, now))
+        except FileNotFoundError:
+            # Completed or aborted while listing
+            continue
+    uploads.sort(key=lambda u: u["created_at"])
+    return {"uploads": uploads}
+
+
+@router.delete("/api/uploads/{upload_id}")
+async def abort_upload(
+    upload_id: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Discard an upload by ID, whatever its project, including orphaned
+    ones, and return the bytes freed."""
+    entry = _upload_entry(upload_id)
+    freed = sum(p.stat().st_size for p in entry.rglob("*") if p.is_file())
+    shutil.rmtree(entry, ignore_errors=True)
+    logger.info("Upload %s aborted by %s (%d bytes freed)", upload_id, user.email, freed)
+    return {"bytes_freed": freed}
+
+
 async def cleanup_stale_uploads_loop():
     """Background task that removes stale chunked upload directories."""
     logger.info("Starting stale uploads cleanup loop")
@@ -0,0 +1,70 @@
+"""Server capability discovery
+
+Lets clients check what this server supports before relying on it, instead
+of failing on endpoints an older server doesn't have.
+"""
+
+from fastapi import APIRouter
+
+from app.routes.cli import VERSION_FILE
+
+router = APIRouter(tags=["capabilities"])
+
+# Largest chunk accepted by /base-files/{kind}/upload/chunk.
+MAX_CHUNK_SIZE = 100 * 1024 * 1024
+# Smallest chunk clients shrink their chunks to on slow connections; the
+# last chunk of an upload may be smaller.
+MIN_CHUNK_SIZE = 1024 * 1024
+
+
+@router.get("/api/capabilities")
+async def get_capabilities():
+    """Return the features supported by this server."""
+    version = VERSION_FILE.read_text().strip() if VERSION_FILE.exists() else ""
+    return {
+        "version": version,
+        "compressors": ["gzip"],
+        "max_chunk_size": MAX_CHUNK_SIZE,
+        "min_chunk_size": MIN_CHUNK_SIZE,
+        "streaming_drush": True,
+        "snapshots": False,
+        "scale": True,
+        "db_sync": True,
+        "info": True,
+        "heavy_files_manifest": True,
+        "tests": True,
+        "deploy_run": True,
+        "multisite": True,
+        "pipelines": True,
+        "streaming_upload": True,
+        "base_verify": True,
+        "services": True,
+        "scaffold_templates": True,
+        "validate": True,
+        "retarget": True,
+        "table_dumps": True,
+        "upload_sessions": True,
+        "preview_size": True,
+        "files_sync": True,
+        "token_info": True,
+        "cli_help": True,
+        "cli_channels": True,
+        "db_tunnel": True,
+        "xdebug": True,
+        "logs": True,
+        "mail": True,
+        "triggers": True,
+        "databases": True,
+        "ip_allowlist": True,
+        "custom_domains": True,
+        "base_restore": True,
+        "project_list": True,
+        "anonymize": True,
+        "plan": True,
+        "quoted_drush_args": True,
+        # Organizations, SSO login and X-Preview-Org come with OIDC; until
+        # then every user belongs to the one organization of the server
+        "orgs": False,
+        # API tokens don't expire, so there is no /api/auth/refresh
+        "token_refresh": False,
+    }
@@ -1,11 +1,16 @@
 """CLI distribution endpoints"""
 
+import json
 import logging
 from pathlib import Path
 
 from fastapi import APIRouter
 from fastapi.responses import FileResponse, JSONResponse, PlainTextResponse
 
+from config.settings import settings
+from app import config_store
+from app.docker_compose import DEFAULTS, preview_domain
+
 logger = logging.getLogger(__name__)
 
 router = APIRouter(prefix="/api/cli", tags=["cli"])
@@ -14,17 +19,74 @@ CLI_DIR = Path("/var/www/preview-manager/cli")
 INSTALL_SCRIPT = CLI_DIR / "install.sh"
 VERSION_FILE = CLI_DIR / "VERSION"
 
-VALID_OS = {"linux", "darwin"}
+# Beta builds (cli/build.sh --beta) are published in their own directory
+BETA_DIR = CLI_DIR / "beta"
+CHANNELS = ("stable", "beta")
+
+VALID_OS = {"linux", "darwin", "windows"}
 VALID_ARCH = {"amd64", "arm64"}
 
 
+def channel_dir(channel: str) -> Path:
+    """Return the directory of the CLI release of channel. The beta channel
+    gets the stable release while no beta is published."""
+    if channel == "beta" and (BETA_DIR / "VERSION").exists():
+        return BETA_DIR
+    return CLI_DIR
+
+
 @router.get("/version")
-async def get_cli_version():
-    """Return the latest published CLI version."""
-    if not VERSION_FILE.exists():
+async def get_cli_version(channel: str = "stable"):
+    """Return the latest published CLI version of channel, the oldest one
+    allowed (required_cli_version, to turn away releases known to be
+    broken) and the API versions this server speaks."""
+    # Imported here as app.api_version imports this module
+    from app.api_version import MAX_API_VERSION, MIN_API_VERSION
+
+    if channel not in CHANNELS:
+        return JSONResponse({"error": f"Unknown channel: {channel}"}, status_code=400)
+    version_file = channel_dir(channel) / "VERSION"
+    if not version_file.exists():
         return JSONResponse({"error": "Version file not found"}, status_code=404)
-    version = VERSION_FILE.read_text().strip()
-    return JSONResponse({"version": version})
+    version = version_file.read_text().strip()
+    return JSONResponse({
+        "version": version,
+        "channel": channel,
+        "required_version": settings.required_cli_version,
+        "min_api_version": MIN_API_VERSION,
+        "max_api_version": MAX_API_VERSION,
+    })
+
+
+@router.get("/help")
+async def get_cli_help():
+    """Return the values of this server the CLI shows in its help: the domain
+    previews are served at, the documentation, the PHP versions previews can
+    run and notes of the admins by command (the cli_help_notes config, JSON
+    such as {"push db": "..."})."""
+    notes = {}
+    raw = await config_store.get_config("cli_help_notes")
+    if raw:
+        try:
+            notes = {str(k): str(v) for k, v in json.loads(raw).items()}
+        except (ValueError, AttributeError):
+            logger.warning("Ignoring invalid cli_help_notes config")
+    return JSONResponse({
+        "preview_domain": preview_domain("{project}", "{preview}"),
+        "docs_url": await config_store.get_config("cli_docs_url") or f"{settings.frontend_url}/docs",
+        "php_versions": [v.strip() for v in settings.supported_php_versions.split(",") if v.strip()],
+        "default_php_version": DEFAULTS["php_version"],
+        "notes": notes,
+    })
+
+
+@router.get("/team/{code}")
+async def get_team_defaults(code: str):
+    """Return the CLI defaults of a team code created by an admin."""
+    raw = await config_store.get_config(f"team_code_{code}")
+    if not raw:
+        return JSONResponse({"detail": "Unknown team code"}, status_code=404)
+    return JSONResponse(json.loads(raw))
 
 
 @router.get("/install.sh")
@@ -40,14 +102,17 @@ async def get_install_script():
 
 
 @router.get("/download/{os}/{arch}")
-async def download_binary(os: str, arch: str):
-    """Download the CLI binary for a given OS and architecture."""
+async def download_binary(os: str, arch: str, channel: str = "stable"):
+    """Download the CLI binary of channel for a given OS and architecture."""
+    if channel not in CHANNELS:
+        return PlainTextResponse(f"Unknown channel: {channel}", status_code=400)
     if os not in VALID_OS:
         return PlainTextResponse(f"Unsupported OS: {os}", status_code=400)
     if arch not in VALID_ARCH:
         return PlainTextResponse(f"Unsupported architecture: {arch}", status_code=400)
 
-    binary_path = CLI_DIR / f"preview-{os}-{arch}"
+    suffix = ".exe" if os == "windows" else ""
+    binary_path = channel_dir(channel) / f"preview-{os}-{arch}{suffix}"
     if not binary_path.exists():
         return PlainTextResponse(
             f"Binary not available for {os}/{arch}", status_code=404
@@ -56,5 +121,24 @@ async def download_binary(os: str, arch: str):
     return FileResponse(
         binary_path,
         media_type="application/octet-stream",
-        filename="preview",
+        filename=f"preview{suffix}",
     )
+
+
+@router.get("/download/{os}/{arch}/signature")
+async def download_signature(os: str, arch: str, channel: str = "stable"):
+    """Download the minisign signature of the CLI binary of channel for a
+    given OS and architecture, which self-update verifies before installing
+    it."""
+    if channel not in CHANNELS:
+        return PlainTextResponse(f"Unknown channel: {channel}", status_code=400)
+    if os not in VALID_OS or arch not in VALID_ARCH:
+        return PlainTextResponse(f"Unsupported platform: {os}/{arch}", status_code=400)
+
+    suffix = ".exe" if os == "windows" else ""
+    signature_path = channel_dir(channel) / f"preview-{os}-{arch}{suffix}.minisig"
+    if not signature_path.exists():
+        return PlainTextResponse(
+            f"No signature published for {os}/{arch}", status_code=404
+        )
+    return FileResponse(signature_path, media_type="text/plain")
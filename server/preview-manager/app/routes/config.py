@@ -2,13 +2,16 @@
 
 import json
 import logging
+import re
+import secrets
 
 from fastapi import APIRouter, Depends, HTTPException, Request
 
 from config.settings import settings
 from app.auth.dependencies import require_role
 from app.auth.models import Role, UserWithRole
-from app import config_store
+from app import config_store, db_sync
+from app.project_settings import SETTINGS, load_project_settings, update_project_settings
 
 logger = logging.getLogger(__name__)
 
@@ -48,6 +51,19 @@ async def save_app_config(request: Request, user: UserWithRole = Depends(require
 
 # ---- Auto-stop configuration ----
 
+def _auto_stop_minutes(body: dict) -> int | None:
+    """Return the idle minutes of an auto-stop policy, None if not given."""
+    if body.get("minutes") is None:
+        return None
+    try:
+        minutes = int(body["minutes"])
+    except (TypeError, ValueError):
+        minutes = 0
+    if minutes <= 0:
+        raise HTTPException(status_code=400, detail="minutes must be a positive number")
+    return minutes
+
+
 @router.get("/api/config/auto-stop")
 async def get_auto_stop_config(user: UserWithRole = Depends(require_role(Role.viewer))):
     """Get global auto-stop configuration."""
@@ -63,9 +79,10 @@ async def get_auto_stop_config(user: UserWithRole = Depends(require_role(Role.vi
 async def save_auto_stop_config(request: Request, user: UserWithRole = Depends(require_role(Role.admin))):
     """Save global auto-stop configuration."""
     body = await request.json()
+    minutes = _auto_stop_minutes(body)
     await config_store.set_config("auto_stop_enabled", "true" if body.get("enabled") else "false")
-    if "minutes" in body:
-        await config_store.set_config("auto_stop_minutes", str(int(body["minutes"])))
+    if minutes:
+        await config_store.set_config("auto_stop_minutes", str(minutes))
     return {"success": True}
 
 
@@ -90,9 +107,10 @@ async def save_project_auto_stop_config(project: str, request: Request, user: Us
         await config_store.delete_config(f"auto_stop_{project}_enabled")
         await config_store.delete_config(f"auto_stop_{project}_minutes")
     else:
+        minutes = _auto_stop_minutes(body)
         await config_store.set_config(f"auto_stop_{project}_enabled", "true" if body.get("enabled") else "false")
-        if "minutes" in body:
-            await config_store.set_config(f"auto_stop_{project}_minutes", str(int(body["minutes"])))
+        if minutes:
+            await config_store.set_config(f"auto_stop_{project}_minutes", str(minutes))
     return {"success": True}
 
 
@@ -158,6 +176,150 @@ async def save_project_env_vars(project: str, request: Request, user: UserWithRo
     }
 
 
+# ---- Project settings ----
+
+@router.get("/api/config/project-settings/{project}")
+async def get_project_settings(project: str, user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Get the settings of a project with their descriptions."""
+    return {
+        "settings": await load_project_settings(project),
+        "descriptions": {name: desc for name, (_, desc) in SETTINGS.items()},
+    }
+
+
+@router.patch("/api/config/project-settings/{project}")
+async def patch_project_settings(project: str, request: Request, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Update some settings of a project. Empty values reset to the default."""
+    body = await request.json()
+    changes = body.get("settings", {})
+    if not isinstance(changes, dict):
+        raise HTTPException(status_code=400, detail="settings must be an object")
+    try:
+        await update_project_settings(project, changes)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    return {
+        "settings": await load_project_settings(project),
+        "descriptions": {name: desc for name, (_, desc) in SETTINGS.items()},
+    }
+
+
+# ---- Production database source (db sync) ----
+
+@router.get("/api/config/db-sync/{project}")
+async def get_db_sync_source(project: str, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Get the production database source of a project, without its password."""
+    source = await db_sync.load_source(project)
+    return {"source": db_sync.public_source(source) if source else None}
+
+
+@router.put("/api/config/db-sync/{project}")
+async def save_db_sync_source(project: str, request: Request, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Save the production database source of a project."""
+    body = await request.json()
+    if not isinstance(body, dict):
+        raise HTTPException(status_code=400, detail="Body must be an object")
+    try:
+        source = await db_sync.save_source(project, body)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    return {"source": db_sync.public_source(source)}
+
+
+@router.delete("/api/config/db-sync/{project}")
+async def delete_db_sync_source(project: str, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Remove the production database source of a project."""
+    await db_sync.delete_source(project)
+    return {"success": True}
+
+
+# ---- Scaffold templates ----
+
+# Files an organization can provide its own template of, for
+# 'preview setup project --template-source NAME'.
+SCAFFOLD_FILES = (
+    "settings.preview.php",
+    "preview.yml",
+    "scripts/preview/new/deploy.sh",
+    "scripts/preview/update/deploy.sh",
+)
+SCAFFOLD_NAME = re.compile(r"^[a-z0-9][a-z0-9_-]{0,63}$")
+MAX_SCAFFOLD_FILE_SIZE = 256 * 1024
+
+
+def _scaffold_key(name: str) -> str:
+    if not SCAFFOLD_NAME.match(name):
+        raise HTTPException(status_code=400, detail="Template set names are lowercase letters, digits, '-' and '_'")
+    return f"scaffold_templates_{name}"
+
+
+@router.get("/api/config/templates")
+async def list_scaffold_templates(user: UserWithRole = Depends(require_role(Role.viewer))):
+    """List the scaffold template sets and the files each provides."""
+    cfg = await config_store.get_all_config()
+    sets = []
+    for key, value in sorted(cfg.items()):
+        if key.startswith("scaffold_templates_"):
+            files = json.loads(value)
+            sets.append({"name": key[len("scaffold_templates_"):], "files": sorted(files)})
+    return {"templates": sets}
+
+
+@router.get("/api/config/templates/{name}")
+async def get_scaffold_templates(name: str, user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Return the files of a scaffold template set."""
+    value = await config_store.get_config(_scaffold_key(name))
+    if value is None:
+        raise HTTPException(status_code=404, detail=f"Template set {name} not found")
+    return {"name": name, "files": json.loads(value)}
+
+
+@router.put("/api/config/templates/{name}")
+async def save_scaffold_templates(name: str, request: Request, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Create or replace a scaffold template set. Files it leaves out use
+    the CLI's built-in templates."""
+    key = _scaffold_key(name)
+    body = await request.json()
+    files = body.get("files")
+    if not isinstance(files, dict) or not files:
+        raise HTTPException(status_code=400, detail="files must map file paths to their contents")
+    for path, content in files.items():
+        if path not in SCAFFOLD_FILES:
+            raise HTTPException(status_code=400, detail=f"Unknown template file {path}; expected one of {', '.join(SCAFFOLD_FILES)}")
+        if not isinstance(content, str) or len(content.encode()) > MAX_SCAFFOLD_FILE_SIZE:
+            raise HTTPException(status_code=400, detail=f"{path} must be text of at most {MAX_SCAFFOLD_FILE_SIZE // 1024} KB")
+    await config_store.set_config(key, json.dumps(files))
+    logger.info(f"Scaffold template set {name} saved by {user.email} ({', '.join(sorted(files))})")
+    return {"success": True}
+
+
+@router.delete("/api/config/templates/{name}")
+async def delete_scaffold_templates(name: str, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Remove a scaffold template set."""
+    key = _scaffold_key(name)
+    if await config_store.get_config(key) is None:
+        raise HTTPException(status_code=404, detail=f"Template set {name} not found")
+    await config_store.delete_config(key)
+    logger.info(f"Scaffold template set {name} removed by {user.email}")
+    return {"success": True}
+
+
+# ---- CLI team codes ----
+
+@router.post("/api/config/team-codes")
+async def create_team_code(request: Request, user: UserWithRole = Depends(require_role(Role.admin))):
+    """Create a code that new team members pass to 'preview setup team' to
+    get the CLI defaults (currently the organization)."""
+    body = await request.json()
+    org = body.get("org") or ""
+    if not isinstance(org, str):
+        raise HTTPException(status_code=400, detail="org must be a string")
+    code = secrets.token_urlsafe(9)
+    await config_store.set_config(f"team_code_{code}", json.dumps({"org": org}))
+    logger.info(f"Team code created by {user.email} (org={org!r})")
+    return {"code": code}
+
+
 # ---- Allowed email domains ----
 
 @router.get("/api/config/allowed-domains")
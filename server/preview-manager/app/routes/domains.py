@@ -0,0 +1,179 @@
+"""Custom domains of previews
+
+A preview can be served at custom hostnames besides its own domain, for
+client-facing demos: demo.client.com pointing (CNAME) at the preview
+domain. Caddy gets their certificates on its own once the DNS points here;
+these endpoints report the DNS and certificate status so the CLI can tell
+when a domain is ready.
+
+Custom domains are served without the preview login, whose session cookie
+belongs to the preview domain: only the IP allowlist of the preview
+applies to them.
+"""
+
+import asyncio
+import logging
+import re
+import socket
+import ssl
+from datetime import datetime, timezone
+
+import yaml
+from fastapi import APIRouter, Depends, HTTPException
+from pydantic import BaseModel
+
+from app import database
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.docker_compose import apply_custom_domains, preview_domain
+from app.routes.previews import _get_preview_dir, _run_docker_command, _with_debug_log
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter(tags=["domains"])
+
+# Caddy, which terminates TLS for every preview
+CADDY_TLS_ADDRESS = ("127.0.0.1", 443)
+
+_HOSTNAME_RE = re.compile(r"^(?=.{1,253}$)([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$")
+
+
+class DomainRequest(BaseModel):
+    hostname: str
+
+
+def _resolve(hostname: str) -> list[str]:
+    try:
+        return sorted({info[4][0] for info in socket.getaddrinfo(hostname, 443, proto=socket.IPPROTO_TCP)})
+    except OSError:
+        return []
+
+
+def _dns_status(hostname: str, target: str) -> dict:
+    """Return the records hostname needs to reach target, and whether it
+    resolves to the same addresses already."""
+    server_ips = _resolve(target)
+    if hostname.count(".") == 1:
+        # Apex domains can't be CNAMEs
+        records = [
+            {"type": "AAAA" if ":" in ip else "A", "name": hostname, "value": ip}
+            for ip in server_ips
+        ]
+    else:
+        records = [{"type": "CNAME", "name": hostname, "value": target}]
+    resolved = _resolve(hostname)
+    return {
+        "records": records,
+        "resolved": resolved,
+        "ok": bool(resolved) and bool(set(resolved) & set(server_ips)),
+    }
+
+
+def _certificate_status(hostname: str) -> dict:
+    """Return whether Caddy serves a trusted certificate for hostname yet."""
+    context = ssl.create_default_context()
+    try:
+        with socket.create_connection(CADDY_TLS_ADDRESS, timeout=5) as sock:
+            with context.wrap_socket(sock, server_hostname=hostname) as tls:
+                cert = tls.getpeercert()
+    except ssl.SSLCertVerificationError as e:
+        return {"status": "pending", "message": f"No trusted certificate yet: {e.verify_message}"}
+    except (ssl.SSLError, OSError) as e:
+        return {"status": "pending", "message": f"No certificate yet: {e}"}
+
+    issuer = dict(item[0] for item in cert.get("issuer", ()))
+    expires = datetime.fromtimestamp(ssl.cert_time_to_seconds(cert["notAfter"]), timezone.utc)
+    return {
+        "status": "issued",
+        "issuer": issuer.get("organizationName") or issuer.get("commonName", ""),
+        "expires_at": expires.isoformat(),
+        "message": "",
+    }
+
+
+async def _domain_status(domain: dict, target: str) -> dict:
+    dns, certificate = await asyncio.gather(
+        asyncio.to_thread(_dns_status, domain["hostname"], target),
+        asyncio.to_thread(_certificate_status, domain["hostname"]),
+    )
+    return {**domain, "dns": dns, "certificate": certificate}
+
+
+async def _preview_id(project: str, preview_name: str) -> int:
+    preview = await database.get_preview(project, preview_name)
+    if not preview:
+        raise HTTPException(status_code=404, detail=f"Preview {project}/{preview_name} not found")
+    return preview["id"]
+
+
+async def _apply(project: str, preview_name: str, preview_id: int) -> dict:
+    """Rewrite the custom domains of the preview's compose file and recreate
+    its php container, so Caddy picks them up."""
+    preview_path = _get_preview_dir(project, preview_name)
+    compose_file = preview_path / "docker-compose.yml"
+    if not compose_file.exists():
+        # Not deployed yet: the deploy generates it with the domains
+        return {"success": True, "output": "", "error": ""}
+    compose = yaml.safe_load(compose_file.read_text())
+    apply_custom_domains(compose, [d["hostname"] for d in await database.list_preview_domains(preview_id)])
+    compose_file.write_text(yaml.dump(compose, default_flow_style=False, sort_keys=False))
+    return await _run_docker_command(["docker", "compose", "up", "-d", "php"], preview_path, timeout=120)
+
+
+@router.get("/api/previews/{project}/{preview_name}/domains")
+async def list_domains(
+    project: str,
+    preview_name: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """List the custom domains of a preview with their DNS and certificate
+    status."""
+    domains = await database.list_preview_domains(await _preview_id(project, preview_name))
+    target = preview_domain(project, preview_name)
+    return await asyncio.gather(*(_domain_status(d, target) for d in domains))
+
+
+@router.post("/api/previews/{project}/{preview_name}/domains")
+async def add_domain(
+    project: str,
+    preview_name: str,
+    body: DomainRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Attach a custom domain to a preview. Returns it with the DNS records
+    it needs."""
+    hostname = body.hostname.strip().lower().rstrip(".")
+    if not _HOSTNAME_RE.match(hostname):
+        raise HTTPException(status_code=400, detail=f"Invalid hostname '{body.hostname}': expected e.g. demo.client.com")
+    if hostname == "preview-mr.com" or hostname.endswith(".preview-mr.com"):
+        raise HTTPException(status_code=400, detail="Previews are already served under preview-mr.com")
+
+    preview_id = await _preview_id(project, preview_name)
+    if await database.get_preview_by_custom_domain(hostname):
+        raise HTTPException(status_code=409, detail=f"{hostname} is already attached to a preview")
+    await database.add_preview_domain(preview_id, hostname, user.email)
+
+    result = await _apply(project, preview_name, preview_id)
+    if not result["success"]:
+        await database.delete_preview_domain(preview_id, hostname)
+        await _apply(project, preview_name, preview_id)
+        raise HTTPException(status_code=500, detail=f"Failed to serve {hostname}: {result['error']}")
+
+    logger.info(f"Attached {hostname} to {project}/{preview_name} by {user.email}")
+    domain = next(d for d in await database.list_preview_domains(preview_id) if d["hostname"] == hostname)
+    return await _domain_status(domain, preview_domain(project, preview_name))
+
+
+@router.delete("/api/previews/{project}/{preview_name}/domains/{hostname}")
+async def delete_domain(
+    project: str,
+    preview_name: str,
+    hostname: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Detach a custom domain from a preview."""
+    preview_id = await _preview_id(project, preview_name)
+    if not await database.delete_preview_domain(preview_id, hostname.lower()):
+        raise HTTPException(status_code=404, detail=f"{hostname} is not attached to {project}/{preview_name}")
+    logger.info(f"Detached {hostname} from {project}/{preview_name} by {user.email}")
+    return _with_debug_log(await _apply(project, preview_name, preview_id))
@@ -5,18 +5,22 @@ import logging
 import secrets
 import time
 
+from typing import Optional
+
 import httpx
-from fastapi import APIRouter, Depends, HTTPException
-from fastapi.responses import RedirectResponse
+from fastapi import APIRouter, Depends, HTTPException, Query
+from fastapi.responses import PlainTextResponse, RedirectResponse
 from pydantic import BaseModel
 
 from config.settings import settings
 from app.auth import database as db
 from app.auth.dependencies import SESSION_COOKIE, get_current_user, require_role
-from app.auth.models import Role, UserWithRole
+from app.auth.models import Role, UserWithRole, has_min_role
 from app.auth.oauth import GitLabOAuth
 from app import config_store
 from app.config_store import load_project_details
+from app.errors import APIError
+from app.state import PreviewStateManager
 
 logger = logging.getLogger(__name__)
 
@@ -595,6 +599,155 @@ async def list_project_branches_by_slug(project_slug: str, user: UserWithRole =
         raise HTTPException(status_code=502, detail=f"GitLab API error: {e}")
 
 
+# ---- Pipelines (deploy feedback for previews) ----
+
+async def _require_project_access(user: UserWithRole, project_slug: str):
+    """Non-admin users only see the pipelines of projects they are assigned to."""
+    if not has_min_role(user.role, Role.admin):
+        if project_slug not in await db.get_user_project_slugs(user.id):
+            raise HTTPException(status_code=404, detail=f"Project '{project_slug}' not found")
+
+
+async def _project_api_url(project_slug: str) -> str:
+    project_path = await config_store.get_project_path_by_slug(project_slug)
+    if not project_path:
+        raise HTTPException(status_code=404, detail=f"Project '{project_slug}' not found in enabled projects")
+    return f"{settings.gitlab_url}/api/v4/projects/{project_path.replace('/', '%2F')}"
+
+
+def _gitlab_error(e: httpx.HTTPStatusError, not_found: str) -> HTTPException:
+    # A GitLab 401 is the server's token, not the user's: answering 401
+    # would make clients log the user out
+    if e.response.status_code == 401:
+        return APIError(502, "GitLab token expired or revoked", code="gitlab_unauthorized",
+                        hint="A server admin must reconnect GitLab in the dashboard.")
+    if e.response.status_code in (403, 404):
+        return HTTPException(status_code=404, detail=not_found)
+    return HTTPException(status_code=502, detail=f"GitLab API error: {e.response.status_code}")
+
+
+@router.get("/projects/by-slug/{project_slug}/pipelines")
+async def list_project_pipelines(
+    project_slug: str,
+    preview: Optional[str] = None,
+    limit: int = Query(20, ge=1, le=100),
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
+    """List the latest pipelines of a project, newest first.
+
+    With preview, only the pipelines of its merge request (mr-ID previews) or
+    branch (branch-NAME previews).
+    """
+    await _require_project_access(user, project_slug)
+    token = await _get_gitlab_token()
+    api_url = await _project_api_url(project_slug)
+
+    url, params = f"{api_url}/pipelines", {"per_page": limit}
+    if preview:
+        state = await PreviewStateManager.load_state(project_slug, preview)
+        if not state:
+            raise HTTPException(status_code=404, detail=f"Preview {project_slug}/{preview} not found")
+        if state.get("mr_id"):
+            url = f"{api_url}/merge_requests/{state['mr_id']}/pipelines"
+        else:
+            params["ref"] = state["branch"]
+
+    try:
+        async with httpx.AsyncClient() as client:
+            resp = await client.get(url, headers={"PRIVATE-TOKEN": token}, params=params, timeout=15)
+            resp.raise_for_status()
+            pipelines = resp.json()[:limit]
+    except httpx.HTTPStatusError as e:
+        raise _gitlab_error(e, f"Project '{project_slug}' not found in GitLab")
+    except Exception as e:
+        logger.error(f"Error listing pipelines: {e}", exc_info=True)
+        raise HTTPException(status_code=502, detail=f"GitLab API error: {e}")
+
+    return {
+        "pipelines": [
+            {
+                "id": p["id"],
+                "status": p["status"],
+                "ref": p.get("ref", ""),
+                "sha": p.get("sha", ""),
+                "source": p.get("source", ""),
+                "created_at": p.get("created_at"),
+                "updated_at": p.get("updated_at"),
+                "web_url": p.get("web_url", ""),
+            }
+            for p in pipelines
+        ]
+    }
+
+
+@router.get("/projects/by-slug/{project_slug}/pipelines/{pipeline_id}/jobs")
+async def list_pipeline_jobs(project_slug: str, pipeline_id: int, user: UserWithRole = Depends(require_role(Role.viewer))):
+    """List the jobs of a pipeline, retried jobs included."""
+    await _require_project_access(user, project_slug)
+    token = await _get_gitlab_token()
+    api_url = await _project_api_url(project_slug)
+
+    try:
+        jobs = []
+        page = 1
+        async with httpx.AsyncClient() as client:
+            while True:
+                resp = await client.get(
+                    f"{api_url}/pipelines/{pipeline_id}/jobs",
+                    headers={"PRIVATE-TOKEN": token},
+                    params={"include_retried": "true", "per_page": 100, "page": page},
+                    timeout=15,
+                )
+                resp.raise_for_status()
+                jobs_page = resp.json()
+                jobs.extend(jobs_page)
+                if len(jobs_page) < 100:
+                    break
+                page += 1
+    except httpx.HTTPStatusError as e:
+        raise _gitlab_error(e, f"Pipeline {pipeline_id} not found in '{project_slug}'")
+    except Exception as e:
+        logger.error(f"Error listing pipeline jobs: {e}", exc_info=True)
+        raise HTTPException(status_code=502, detail=f"GitLab API error: {e}")
+
+    return {
+        "jobs": [
+            {
+                "id": j["id"],
+                "name": j["name"],
+                "stage": j.get("stage", ""),
+                "status": j["status"],
+                "duration": j.get("duration"),
+                "created_at": j.get("created_at"),
+                "started_at": j.get("started_at"),
+                "finished_at": j.get("finished_at"),
+                "web_url": j.get("web_url", ""),
+            }
+            for j in sorted(jobs, key=lambda j: j["id"])
+        ]
+    }
+
+
+@router.get("/projects/by-slug/{project_slug}/jobs/{job_id}/trace")
+async def get_job_trace(project_slug: str, job_id: int, user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Return the log of a job as plain text, as GitLab keeps it (ANSI colors included)."""
+    await _require_project_access(user, project_slug)
+    token = await _get_gitlab_token()
+    api_url = await _project_api_url(project_slug)
+
+    try:
+        async with httpx.AsyncClient() as client:
+            resp = await client.get(f"{api_url}/jobs/{job_id}/trace", headers={"PRIVATE-TOKEN": token}, timeout=60)
+            resp.raise_for_status()
+    except httpx.HTTPStatusError as e:
+        raise _gitlab_error(e, f"Job {job_id} not found in '{project_slug}'")
+    except Exception as e:
+        logger.error(f"Error fetching job trace: {e}", exc_info=True)
+        raise HTTPException(status_code=502, detail=f"GitLab API error: {e}")
+
+    return PlainTextResponse(resp.content, media_type="text/plain; charset=utf-8")
+
+
 @router.post("/disconnect")
 async def gitlab_disconnect(user: UserWithRole = Depends(require_role(Role.admin))):
     """Remove webhooks from enabled projects, clear config, and remove OAuth tokens."""
@@ -0,0 +1,41 @@
+"""Server health summary
+
+Lets admins check a server from the terminal (`preview info server`) and
+attach its state to bug reports.
+"""
+
+import time
+from collections import Counter
+from pathlib import Path
+
+import psutil
+from fastapi import APIRouter, Depends
+
+from config.settings import settings
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.database import count_running_deployments, get_all_previews
+from app.routes.cli import VERSION_FILE
+
+router = APIRouter(tags=["info"])
+
+# Close enough to the process start: routes are imported at startup.
+STARTED_AT = time.time()
+
+
+@router.get("/api/info")
+async def get_server_info(user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Return the version of the CLI the server publishes, uptime, preview
+    counts, disk headroom and the number of deployments in progress."""
+    cli_version = VERSION_FILE.read_text().strip() if VERSION_FILE.exists() else ""
+    previews = await get_all_previews()
+    disk = psutil.disk_usage(str(Path(settings.previews_base_path).resolve()))
+    return {
+        "cli_version": cli_version,
+        "uptime_seconds": int(time.time() - STARTED_AT),
+        "previews_total": len(previews),
+        "previews_by_status": dict(Counter(p["status"] for p in previews)),
+        "disk_total_bytes": disk.total,
+        "disk_free_bytes": disk.free,
+        "running_deployments": await count_running_deployments(),
+    }
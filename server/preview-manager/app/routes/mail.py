@@ -0,0 +1,122 @@
+"""Mail captured by previews
+
+Every preview has a mailpit container catching the mail its site sends
+(PHP's sendmail_path sends to it), so notification emails can be checked
+without reaching real inboxes. These endpoints read it through mailpit's
+API, for `preview mail`; its web UI is served at the preview's mail domain.
+"""
+
+import asyncio
+
+import httpx
+from fastapi import APIRouter, Depends, HTTPException, Query
+
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.docker_compose import MAIL_UI_PORT, MAIL_USER, mail_domain, mail_password
+from app.state import PreviewStateManager
+
+router = APIRouter(tags=["mail"])
+
+
+def _address(addr: dict | None) -> str:
+    """Format a mailpit address as "Name <address>"."""
+    if not addr:
+        return ""
+    name, address = addr.get("Name", ""), addr.get("Address", "")
+    return f"{name} <{address}>" if name else address
+
+
+async def _mailpit(project: str, preview_name: str, path: str, params: dict | None = None) -> dict:
+    """GET path of the mailpit API of a preview."""
+    if not PreviewStateManager.get_preview_path(project, preview_name).exists():
+        raise HTTPException(status_code=404, detail=f"Preview {project}/{preview_name} not found")
+
+    container = f"{preview_name}-{project}-mail"
+    proc = await asyncio.create_subprocess_exec(
+        "docker", "inspect", "-f",
+        "{{.State.Running}} {{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}",
+        container,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE,
+    )
+    stdout, _ = await asyncio.wait_for(proc.communicate(), timeout=5)
+    fields = stdout.decode().split()
+    if proc.returncode != 0:
+        raise HTTPException(
+            status_code=409,
+            detail=f"{project}/{preview_name} has no mail capture: rebuild the preview to get it",
+        )
+    if len(fields) < 2 or fields[0] != "true":
+        raise HTTPException(status_code=409, detail=f"Container '{container}' is not running")
+
+    auth = (MAIL_USER, mail_password(project, preview_name))
+    url = f"http://{fields[1]}:{MAIL_UI_PORT}/api/v1/{path}"
+    try:
+        async with httpx.AsyncClient(timeout=10) as client:
+            resp = await client.get(url, params=params, auth=auth)
+    except httpx.HTTPError as e:
+        raise HTTPException(status_code=502, detail=f"Failed to reach the mail capture of {project}/{preview_name}: {e}")
+    if resp.status_code == 404:
+        raise HTTPException(status_code=404, detail="Message not found")
+    if resp.status_code != 200:
+        raise HTTPException(status_code=502, detail=f"Mail capture answered HTTP {resp.status_code}")
+    return resp.json()
+
+
+@router.get("/api/previews/{project}/{preview_name}/mail")
+async def list_mail(
+    project: str,
+    preview_name: str,
+    limit: int = Query(default=20, ge=1, le=500),
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """List the mail captured by a preview, newest first, with the URL and
+    credentials of its mail capture UI."""
+    result = await _mailpit(project, preview_name, "messages", {"limit": limit})
+    return {
+        "url": f"https://{mail_domain(project, preview_name)}",
+        "user": MAIL_USER,
+        "password": mail_password(project, preview_name),
+        "total": result.get("total", 0),
+        "unread": result.get("unread", 0),
+        "messages": [
+            {
+                "id": m["ID"],
+                "from": _address(m.get("From")),
+                "to": [_address(a) for a in m.get("To") or []],
+                "subject": m.get("Subject", ""),
+                "created": m.get("Created", ""),
+                "size": m.get("Size", 0),
+                "read": m.get("Read", False),
+                "snippet": m.get("Snippet", ""),
+            }
+            for m in result.get("messages") or []
+        ],
+    }
+
+
+@router.get("/api/previews/{project}/{preview_name}/mail/{message_id}")
+async def get_mail(
+    project: str,
+    preview_name: str,
+    message_id: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Return a message captured by a preview; message_id "latest" is the
+    newest one."""
+    m = await _mailpit(project, preview_name, f"message/{message_id}")
+    return {
+        "id": m["ID"],
+        "from": _address(m.get("From")),
+        "to": [_address(a) for a in m.get("To") or []],
+        "cc": [_address(a) for a in m.get("Cc") or []],
+        "subject": m.get("Subject", ""),
+        "date": m.get("Date", ""),
+        "text": m.get("Text", ""),
+        "html": m.get("HTML", ""),
+        "attachments": [
+            {"name": a.get("FileName", ""), "content_type": a.get("ContentType", ""), "size": a.get("Size", 0)}
+            for a in m.get("Attachments") or []
+        ],
+    }
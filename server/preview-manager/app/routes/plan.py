@@ -0,0 +1,147 @@
+"""Plans of preview.yml changes
+
+Compares a preview.yml, e.g. that of the HEAD commit of a branch, with the
+one a preview was last deployed with, and says what the next rebuild would
+do about each difference: which containers are created, replaced or
+removed, and which settings only apply to new previews.
+"""
+
+import logging
+import tempfile
+from pathlib import Path
+
+from fastapi import APIRouter, Depends
+from pydantic import BaseModel
+
+from config.settings import settings
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.docker_compose import parse_preview_yml
+from app.routes.previews import _get_preview_dir
+from app.state import PreviewStateManager
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter(tags=["plan"])
+
+# Actions of a change, worst first
+ACTIONS = ("destroy", "replace", "create", "update")
+
+
+class PlanRequest(BaseModel):
+    # Content of the preview.yml to plan, empty for none
+    preview_yml: str = ""
+
+
+def _change(key: str, old, new, action: str, container: str | None, effect: str) -> dict:
+    return {"key": key, "from": old, "to": new, "action": action, "container": container, "effect": effect}
+
+
+def _plan(old: dict, new: dict) -> list[dict]:
+    """Return the changes from config old to config new, both of
+    parse_preview_yml, in the order of ACTIONS."""
+    changes = []
+
+    if old["php_version"] != new["php_version"]:
+        changes.append(_change(
+            "php_version", old["php_version"], new["php_version"], "replace", "php",
+            f"The php container is recreated from {settings.drupal_base_image}:php{new['php_version']}",
+        ))
+    if old["database"] != new["database"]:
+        changes.append(_change(
+            "database", old["database"], new["database"], "replace", "db",
+            f"The db container is recreated from {new['database']}; the data volume is kept, "
+            "which another engine or major version may fail to read",
+        ))
+    for svc in ("redis", "solr"):
+        was, now = old["services"][svc], new["services"][svc]
+        if was and not now:
+            changes.append(_change(f"services.{svc}", was, now, "destroy", svc, f"The {svc} container is removed"))
+        elif now and not was:
+            changes.append(_change(f"services.{svc}", was, now, "create", svc, f"A {svc} container is started"))
+    for name in new["databases"]:
+        if name not in old["databases"]:
+            changes.append(_change(
+                f"databases.{name}", None, name, "create", "db",
+                f"Database {name} is created and imported from its base dump",
+            ))
+
+    if old["docroot"] != new["docroot"]:
+        changes.append(_change(
+            "docroot", old["docroot"], new["docroot"], "update", "php",
+            f"The php container is recreated to serve /var/www/html/{new['docroot']}",
+        ))
+    for key in sorted(set(old["env"]) | set(new["env"])):
+        was, now = old["env"].get(key), new["env"].get(key)
+        if was != now:
+            changes.append(_change(
+                f"env.{key}", was, now, "update", "php",
+                "The php container is recreated with the new environment",
+            ))
+    for key in ("memory", "cpus"):
+        was, now = old["resources"][key], new["resources"][key]
+        if was != now:
+            changes.append(_change(
+                f"resources.{key}", was, now, "update", "php",
+                "The php container is recreated with the new limit, unless the preview was scaled",
+            ))
+    for name in old["databases"]:
+        if name not in new["databases"]:
+            changes.append(_change(
+                f"databases.{name}", name, None, "update", None,
+                f"Database {name} is no longer imported by new previews; its data here is kept",
+            ))
+    for phase in ("new", "update"):
+        was, now = old["deploy"][phase], new["deploy"][phase]
+        if was != now:
+            effect = ("The rebuild runs the new script" if phase == "update"
+                      else "Only new previews run it; the rebuild runs deploy.update")
+            changes.append(_change(f"deploy.{phase}", was, now, "update", None, effect))
+    if old["files"] != new["files"]:
+        changes.append(_change(
+            "files", old["files"], new["files"], "update", None,
+            "Only new previews import files accordingly; the files of this preview are kept",
+        ))
+    if old["sites"] != new["sites"]:
+        changes.append(_change(
+            "sites", old["sites"], new["sites"], "update", None,
+            "Only new previews import the files of these sites",
+        ))
+    if old["sanitize"] != new["sanitize"]:
+        changes.append(_change(
+            "sanitize", old["sanitize"] is not None, new["sanitize"] is not None, "update", None,
+            "Only new previews are anonymized on creation; run 'preview db anonymize-preview' for this one",
+        ))
+    for name in sorted(set(old["tests"]) | set(new["tests"])):
+        if old["tests"].get(name) != new["tests"].get(name):
+            changes.append(_change(
+                f"tests.{name}", old["tests"].get(name), new["tests"].get(name), "update", None,
+                "'preview test' runs the new suite",
+            ))
+
+    changes.sort(key=lambda c: ACTIONS.index(c["action"]))
+    return changes
+
+
+@router.post("/api/previews/{project}/{preview_name}/plan")
+async def plan_preview(
+    project: str,
+    preview_name: str,
+    body: PlanRequest,
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
+    """Compare a preview.yml with the one the preview was last deployed with,
+    without changing anything."""
+    preview_path = _get_preview_dir(project, preview_name)
+    state = await PreviewStateManager.load_state(project, preview_name) or {}
+
+    current = parse_preview_yml(preview_path)
+    with tempfile.TemporaryDirectory() as tmp:
+        (Path(tmp) / "preview.yml").write_text(body.preview_yml)
+        planned = parse_preview_yml(Path(tmp))
+
+    return {
+        "preview": preview_name,
+        "commit_sha": state.get("commit_sha") or "",
+        "changes": _plan(current, planned),
+    }
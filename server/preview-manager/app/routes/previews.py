@@ -3,11 +3,17 @@
 import asyncio
 import json
 import logging
+import os
 import re
+import shlex
+import tarfile
+import tempfile
 import time
 from pathlib import Path
+from urllib.parse import quote
 
-from fastapi import APIRouter, BackgroundTasks, Depends, HTTPException, Request
+import yaml
+from fastapi import APIRouter, BackgroundTasks, Depends, Form, HTTPException, Query, Request, UploadFile
 from fastapi.responses import StreamingResponse
 from typing import Optional
 from pydantic import BaseModel
@@ -17,14 +23,20 @@ from app.models import PreviewInfo
 from app.state import PreviewStateManager
 from app.database import (
     get_all_previews, get_preview, delete_preview_from_db,
+    has_running_deployment, rename_preview_in_db,
     list_deployments as db_list_deployments,
     get_deployment as db_get_deployment,
 )
 from app.auth.dependencies import require_role
 from app.auth.models import Role, UserWithRole, has_min_role
 from app.auth import database as auth_db
-from app import config_store
+from app import config_store, debug_log, files_sync, preview_size, xdebug
 from app.overlay import umount_overlay, mount_overlay, get_overlay_dir
+from app.docker_compose import parse_preview_yml, preview_domain, _container_prefix
+from app.project_settings import (
+    load_preview_resources, load_project_settings, parse_cpus, parse_memory, save_preview_resources,
+)
+from app.sanitize import parse_sanitize, sanitize_statements
 
 logger = logging.getLogger(__name__)
 
@@ -132,7 +144,7 @@ async def create_branch_preview(
     try:
         async with httpx.AsyncClient() as client:
             resp = await client.get(
-                f"{settings.gitlab_url}/api/v4/projects/{encoded_path}/repository/branches/{body.branch}",
+                f"{settings.gitlab_url}/api/v4/projects/{encoded_path}/repository/branches/{quote(body.branch, safe='')}",
                 headers={"PRIVATE-TOKEN": token},
                 timeout=15,
             )
@@ -287,16 +299,94 @@ async def get_docker_status(preview_path: Path) -> str:
         return "unknown"
 
 
-async def get_preview_list_base(include_docker_status: bool = True) -> dict:
+# Most "docker compose ps" run at once when "docker ps" can't be used
+STATUS_CHECK_CONCURRENCY = 8
+
+# Services of a preview's compose file, named {preview}-{project}-{service}
+PREVIEW_SERVICES = ("php", "db", "redis", "solr", "mail")
+
+
+async def get_container_states() -> dict[str, str] | None:
+    """Return the state of every container on the host by name, with a
+    single "docker ps", or None if it failed."""
+    try:
+        process = await asyncio.create_subprocess_exec(
+            "docker", "ps", "--all", "--format", "{{.Names}}\t{{.State}}",
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE,
+        )
+        try:
+            stdout, _ = await asyncio.wait_for(process.communicate(), timeout=10)
+        except asyncio.TimeoutError:
+            process.kill()
+            logger.warning("Timeout listing Docker containers")
+            return None
+    except Exception as e:
+        logger.warning(f"Error listing Docker containers: {e}")
+        return None
+    if process.returncode != 0:
+        return None
+    states = {}
+    for line in stdout.decode().splitlines():
+        name, _, state = line.partition("\t")
+        states[name] = state.strip().lower()
+    return states
+
+
+def status_from_containers(states: dict[str, str], project: str, preview_name: str) -> str:
+    """Status of a preview from get_container_states: running if any of
+    its containers runs, as "docker compose ps" reports it."""
+    prefix = f"{preview_name}-{project}"
+    running = any(states.get(f"{prefix}-{service}") == "running" for service in PREVIEW_SERVICES)
+    return "running" if running else "stopped"
+
+
+async def get_disk_usage(path: Path) -> int | None:
+    """Return the bytes used by path with "du", or None if it doesn't
+    exist. Files du can't read (e.g. root-owned files of the database
+    import) are left out."""
+    if not path.exists():
+        return None
+    try:
+        process = await asyncio.create_subprocess_exec(
+            "du", "-sb", str(path),
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.DEVNULL,
+        )
+        try:
+            stdout, _ = await asyncio.wait_for(process.communicate(), timeout=60)
+        except asyncio.TimeoutError:
+            process.kill()
+            logger.warning(f"Timeout measuring disk usage of {path}")
+            return None
+        return int(stdout.split()[0])
+    except Exception as e:
+        logger.warning(f"Error measuring disk usage of {path}: {e}")
+        return None
+
+
+async def get_preview_list_base(
+    include_docker_status: bool = True,
+    projects: Optional[set[str]] = None,
+    offset: int = 0,
+    limit: Optional[int] = None,
+    include_sizes: bool = False,
+) -> dict:
     """
     Core logic to list all previews (query DB + optionally Docker status).
 
     Args:
         include_docker_status: If True, run docker compose ps for each preview.
                                If False, return previews with status from DB (fast).
+        projects: If set, only list previews of these projects.
+        offset: Number of matching previews to skip.
+        limit: If set, return at most this many previews. Docker status is
+               only checked for the returned page.
+        include_sizes: If True, add the disk usage of each returned preview
+                       (its directory, with uploaded files) as size_bytes.
 
     Returns:
-        dict with "previews" list and "total" count
+        dict with "previews" list and "total" count of matching previews
     """
     t_total = time.monotonic()
 
@@ -304,8 +394,23 @@ async def get_preview_list_base(include_docker_status: bool = True) -> dict:
     t_db = time.monotonic()
     logger.info(f"[TIMING] DB query: {t_db - t_total:.3f}s ({len(rows)} previews found)")
 
+    if projects is not None:
+        rows = [row for row in rows if row["project"] in projects]
+    total = len(rows)
+    rows = rows[offset:offset + limit] if limit is not None else rows[offset:]
+
+    # MR links need the GitLab path of each project
+    paths_by_slug = {
+        path.rsplit("/", 1)[-1]: path
+        for path in (await config_store.load_project_paths()).values()
+    }
+
     previews = []
     for row in rows:
+        mr_url = None
+        if row.get("mr_id") and row["project"] in paths_by_slug:
+            mr_url = f"{settings.gitlab_url}/{paths_by_slug[row['project']]}/-/merge_requests/{row['mr_id']}"
+
         last_deployment = None
         latest_dep_id = row.get("latest_deployment_id")
         if row.get("last_deployment_status"):
@@ -326,34 +431,51 @@ async def get_preview_list_base(include_docker_status: bool = True) -> dict:
             "name": row["preview_name"],
             "project": row["project"],
             "mr_id": row.get("mr_id"),
+            "mr_url": mr_url,
             "status": row["status"] if not include_docker_status else "unknown",
             "url": row["url"],
             "branch": row["branch"],
             "commit_sha": row["commit_sha"],
+            "created_at": row["created_at"],
             "last_deployed_at": row.get("last_deployed_at"),
+            "last_accessed_at": row.get("last_accessed_at"),
             "last_deployment": last_deployment,
             "auto_update": bool(row.get("auto_update", 1)),
             "pinned": bool(row.get("pinned", 0)),
             "_path": row["path"],
         })
 
-    async def update_preview_status(preview):
-        if "_path" in preview:
+    if include_docker_status and previews:
+        t_docker_all = time.monotonic()
+        states = await get_container_states()
+        semaphore = asyncio.Semaphore(STATUS_CHECK_CONCURRENCY)
+
+        async def update_preview_status(preview):
             preview_path = Path(preview["_path"])
-            if preview_path.exists() and (preview_path / "docker-compose.yml").exists():
-                t_docker = time.monotonic()
-                status = await get_docker_status(preview_path)
-                logger.info(f"[TIMING] Docker status {preview['name']}: {time.monotonic() - t_docker:.3f}s -> {status}")
-                preview["status"] = status
-            elif not preview_path.exists():
+            if not preview_path.exists():
                 preview["status"] = "missing"
-            else:
+            elif not (preview_path / "docker-compose.yml").exists():
                 preview["status"] = "stopped"
+            elif states is not None:
+                preview["status"] = status_from_containers(states, preview["project"], preview["name"])
+            else:
+                async with semaphore:
+                    preview["status"] = await get_docker_status(preview_path)
 
-    if include_docker_status and previews:
-        t_docker_all = time.monotonic()
         await asyncio.gather(*[update_preview_status(p) for p in previews])
-        logger.info(f"[TIMING] Docker status (all {len(previews)} parallel): {time.monotonic() - t_docker_all:.3f}s")
+        how = "one docker ps" if states is not None else "docker compose ps each"
+        logger.info(f"[TIMING] Docker status ({len(previews)} previews, {how}): {time.monotonic() - t_docker_all:.3f}s")
+
+    if include_sizes and previews:
+        t_sizes = time.monotonic()
+        semaphore = asyncio.Semaphore(STATUS_CHECK_CONCURRENCY)
+
+        async def update_preview_size(preview):
+            async with semaphore:
+                preview["size_bytes"] = await get_disk_usage(Path(preview["_path"]))
+
+        await asyncio.gather(*[update_preview_size(p) for p in previews])
+        logger.info(f"[TIMING] Disk usage ({len(previews)} previews): {time.monotonic() - t_sizes:.3f}s")
 
     # Strip _path for external consumers
     for preview in previews:
@@ -363,7 +485,7 @@ async def get_preview_list_base(include_docker_status: bool = True) -> dict:
 
     return {
         "previews": previews,
-        "total": len(previews)
+        "total": total
     }
 
 
@@ -396,6 +518,7 @@ async def delete_preview_internal(project: str, preview_name: str):
 
     # Delete from DB
     await PreviewStateManager.delete_state(project, preview_name)
+    await save_preview_resources(project, preview_name, {})
 
     # Delete directory — files created by Docker (root-owned) can't be removed
     # by preview-user directly, so we use a throwaway container to rm -rf.
@@ -449,22 +572,35 @@ async def delete_preview(project: str, preview_name: str, user: UserWithRole = D
 
 
 @router.get("/api/previews")
-async def list_previews(status: bool = True, user: UserWithRole = Depends(require_role(Role.viewer))):
+async def list_previews(
+    status: bool = True,
+    project: Optional[str] = None,
+    limit: Optional[int] = Query(None, ge=1),
+    offset: int = Query(0, ge=0),
+    sizes: bool = False,
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
     """
     List all previews (REST endpoint).
 
     Query params:
         status: If true (default), include Docker container status (slower).
+        sizes: If true, include the disk usage of each preview (slower).
+        project: Only list previews of this project.
+        limit: Return at most this many previews ("total" counts all of them).
+        offset: Number of previews to skip.
     """
-    result = await get_preview_list_base(include_docker_status=status)
+    projects = {project} if project else None
 
     # Non-admin users only see previews for projects they are assigned to
     if not has_min_role(user.role, Role.admin):
         allowed_slugs = set(await auth_db.get_user_project_slugs(user.id))
-        result["previews"] = [p for p in result["previews"] if p["project"] in allowed_slugs]
-        result["total"] = len(result["previews"])
+        projects = projects & allowed_slugs if projects is not None else allowed_slugs
 
-    return result
+    return await get_preview_list_base(
+        include_docker_status=status, projects=projects, offset=offset, limit=limit,
+        include_sizes=sizes,
+    )
 
 
 def _get_preview_dir(project: str, preview_name: str) -> Path:
@@ -480,7 +616,12 @@ async def _run_docker_command(
     cwd: Path,
     timeout: int = 120,
 ) -> dict:
-    """Run a docker compose command and return {success, output, error}."""
+    """Run a docker compose command and return {success, output, error}.
+
+    The command, its stderr (where docker compose reports progress) and
+    its exit status go to the debug log of the request."""
+    debug_log.log(f"$ {shlex.join(command)}  (in {cwd})")
+    started = time.monotonic()
     try:
         process = await asyncio.create_subprocess_exec(
             *command,
@@ -492,11 +633,15 @@ async def _run_docker_command(
             stdout, stderr = await asyncio.wait_for(process.communicate(), timeout=timeout)
         except asyncio.TimeoutError:
             process.kill()
+            debug_log.log(f"killed after {timeout}s")
             return {"success": False, "output": "", "error": f"Timeout after {timeout}s"}
 
         stdout_str = stdout.decode()
         stderr_str = stderr.decode()
         success = process.returncode == 0
+        for line in stderr_str.splitlines():
+            debug_log.log(f"  {line}")
+        debug_log.log(f"exit status {process.returncode} after {time.monotonic() - started:.1f}s")
 
         return {
             "success": success,
@@ -505,14 +650,22 @@ async def _run_docker_command(
         }
     except Exception as e:
         logger.error(f"Error running command {command}: {e}", exc_info=True)
+        debug_log.log(f"failed to run: {e}")
         return {"success": False, "output": "", "error": str(e)}
 
 
+def _with_debug_log(result: dict) -> dict:
+    """Put the debug log of the request, if it asked for one, before the
+    output of result."""
+    result["output"] = debug_log.attach(result["output"])
+    return result
+
+
 @router.post("/api/previews/{project}/{preview_name}/stop")
 async def stop_preview(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.manager))):
     """Stop a preview (docker compose stop)."""
     preview_path = _get_preview_dir(project, preview_name)
-    return await _run_docker_command(["docker", "compose", "stop"], preview_path, timeout=60)
+    return _with_debug_log(await _run_docker_command(["docker", "compose", "stop"], preview_path, timeout=60))
 
 
 @router.post("/api/previews/{project}/{preview_name}/start")
@@ -523,16 +676,132 @@ async def start_preview(project: str, preview_name: str, user: UserWithRole = De
     if get_overlay_dir(preview_path).exists():
         try:
             await mount_overlay(project, preview_path)
+            debug_log.log("Mounted the overlay of the base files")
         except Exception as e:
             logger.warning(f"Failed to ensure overlay mount on start: {e}")
-    return await _run_docker_command(["docker", "compose", "up", "-d"], preview_path, timeout=120)
+            debug_log.log(f"Failed to mount the overlay of the base files: {e}")
+    return _with_debug_log(await _run_docker_command(["docker", "compose", "up", "-d"], preview_path, timeout=120))
 
 
 @router.post("/api/previews/{project}/{preview_name}/restart")
 async def restart_preview(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.manager))):
     """Restart a preview (docker compose restart)."""
     preview_path = _get_preview_dir(project, preview_name)
-    return await _run_docker_command(["docker", "compose", "restart"], preview_path, timeout=120)
+    return _with_debug_log(await _run_docker_command(["docker", "compose", "restart"], preview_path, timeout=120))
+
+
+class ScalePreviewRequest(BaseModel):
+    memory: Optional[str] = None
+    cpus: Optional[str] = None
+
+
+@router.post("/api/previews/{project}/{preview_name}/scale")
+async def scale_preview(
+    project: str, preview_name: str, body: ScalePreviewRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """
+    Change the resource limits of a preview's PHP container.
+
+    Body: {"memory": "2g", "cpus": "1.5"}. Omitted limits are unchanged and
+    an empty value resets a limit to the preview.yml or project default.
+    New limits apply to the running container immediately and are kept
+    across rebuilds; they are only kept if the container took them.
+    """
+    preview_path = _get_preview_dir(project, preview_name)
+    if body.memory is None and body.cpus is None:
+        raise HTTPException(status_code=400, detail="Nothing to change: set memory or cpus")
+
+    try:
+        memory = parse_memory(body.memory) if body.memory else None
+        cpus = parse_cpus(body.cpus) if body.cpus else None
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+    resources = await load_preview_resources(project, preview_name)
+    # The limits of a rebuild without scaled ones: preview.yml > project settings
+    project_settings = await load_project_settings(project)
+    defaults = parse_preview_yml(preview_path)["resources"]
+    default_memory = defaults["memory"] or project_settings["memory_limit"]
+    default_cpus = defaults["cpus"] or project_settings["cpu_limit"]
+
+    update_args = []
+    reset = []
+    if body.memory is not None:
+        resources["memory"] = memory
+        if memory or default_memory:
+            # Unlimited swap, so raising the limit above the previous swap limit works
+            update_args += ["--memory", memory or default_memory, "--memory-swap", "-1"]
+        else:
+            # docker update can't remove a memory limit
+            reset.append("memory")
+    if body.cpus is not None:
+        resources["cpus"] = cpus
+        # 0 removes the limit
+        update_args += ["--cpus", str(cpus or default_cpus or 0)]
+
+    if update_args:
+        php_container = f"{preview_name}-{project}-php"
+        result = await _run_docker_command(
+            ["docker", "update"] + update_args + [php_container], preview_path, timeout=30,
+        )
+        if not result["success"]:
+            return _with_debug_log(result)
+    else:
+        result = {"success": True, "output": "", "error": ""}
+
+    await save_preview_resources(project, preview_name, resources)
+
+    limits = ", ".join(
+        f"{key}={resources[key] if resources[key] is not None else 'default'}"
+        for key in ("memory", "cpus")
+    )
+    result["output"] = f"Resource limits of {project}/{preview_name}: {limits}\n"
+    if reset:
+        result["output"] += "Without a default, the memory limit is removed from the next rebuild.\n"
+    logger.info(f"Scaled {project}/{preview_name} ({limits}) by {user.email}")
+    return _with_debug_log(result)
+
+
+class XdebugRequest(BaseModel):
+    enabled: bool
+    client_host: str = ""
+    client_port: int = xdebug.DEFAULT_PORT
+
+
+@router.post("/api/previews/{project}/{preview_name}/xdebug")
+async def set_preview_xdebug(
+    project: str, preview_name: str, body: XdebugRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """
+    Turn Xdebug of a preview's PHP container on or off.
+
+    Body: {"enabled": true, "client_host": "10.0.0.5", "client_port": 9003}.
+    Debug sessions connect to client_host:client_port, which the container
+    must be able to reach; the CLI's relay (the xdebug websocket) works from
+    anywhere instead. PHP restarts, aborting running requests.
+    """
+    _get_preview_dir(project, preview_name)
+    if body.enabled and not body.client_host:
+        raise HTTPException(status_code=400, detail="client_host is required to turn Xdebug on")
+    if f"{project}/{preview_name}" in xdebug.relays:
+        raise HTTPException(
+            status_code=409,
+            detail=f"Xdebug of {project}/{preview_name} is relayed to a debugger by preview xdebug on: stop it first",
+        )
+    try:
+        await xdebug.set_xdebug(project, preview_name, body.enabled, body.client_host, body.client_port)
+    except xdebug.XdebugError as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    logger.info(f"Xdebug {'on' if body.enabled else 'off'} for {project}/{preview_name} by {user.email}")
+    return {
+        "enabled": body.enabled,
+        "ide_key": xdebug.IDE_KEY,
+        "client_host": body.client_host if body.enabled else "",
+        "client_port": body.client_port,
+        "document_root": xdebug.DOCUMENT_ROOT,
+    }
 
 
 @router.post("/api/previews/{project}/{preview_name}/drush-uli")
@@ -560,9 +829,19 @@ async def drush_command(project: str, preview_name: str, request: Request, user:
     if not args_str:
         raise HTTPException(status_code=400, detail="Missing 'args' in request body")
 
+    try:
+        args = shlex.split(args_str)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=f"Invalid args: {e}")
+
+    return await run_drush(project, preview_name, args)
+
+
+async def run_drush(project: str, preview_name: str, args: list[str]) -> dict:
+    """Run drush with args in the PHP container of a preview."""
     preview_path = _get_preview_dir(project, preview_name)
     php_container = f"{preview_name}-{project}-php"
-    command = ["docker", "exec", php_container, "vendor/bin/drush"] + args_str.split()
+    command = ["docker", "exec", php_container, "vendor/bin/drush"] + args
     return await _run_docker_command(command, preview_path, timeout=120)
 
 
@@ -570,16 +849,44 @@ async def drush_command(project: str, preview_name: str, request: Request, user:
 async def rebuild_preview(
     project: str,
     preview_name: str,
+    request: Request,
     background_tasks: BackgroundTasks,
     user: UserWithRole = Depends(require_role(Role.manager)),
 ):
-    """Re-clone the preview from GitLab (internal rebuild, no pipeline)."""
+    """Re-clone the preview from GitLab (internal rebuild, no pipeline).
+
+    Optional body: {"commit_sha": "..."} — only rebuild if the deployed
+    commit differs (short SHAs are matched by prefix).
+    """
+    body = await request.json() if await request.body() else {}
+    return await start_rebuild(project, preview_name, background_tasks, body.get("commit_sha") or "")
+
+
+async def start_rebuild(
+    project: str,
+    preview_name: str,
+    background_tasks: BackgroundTasks,
+    commit_sha: str = "",
+    triggered_by: str = "rebuild",
+) -> dict:
+    """Start re-cloning a preview from GitLab in the background, unless
+    commit_sha is given and already deployed."""
     _get_preview_dir(project, preview_name)
 
     state = await PreviewStateManager.load_state(project, preview_name)
     if not state or not state.get("branch"):
         raise HTTPException(status_code=400, detail="Cannot determine branch for this preview")
 
+    wanted_sha = commit_sha.strip().lower()
+    deployed_sha = (state.get("commit_sha") or "").lower()
+    if wanted_sha and deployed_sha and deployed_sha.startswith(wanted_sha):
+        return {
+            "success": True,
+            "output": f"{project}/{preview_name} is already up to date ({deployed_sha[:8]})",
+            "error": "",
+            "up_to_date": True,
+        }
+
     project_path = await config_store.get_project_path_by_slug(project)
     if not project_path:
         raise HTTPException(status_code=400, detail=f"Project '{project}' not found in enabled projects")
@@ -592,8 +899,8 @@ async def rebuild_preview(
         project,
         preview_name,
         state["branch"],
-        state.get("commit_sha", ""),
-        "rebuild",
+        wanted_sha or state.get("commit_sha", ""),
+        triggered_by,
         state.get("mr_id"),
     )
 
@@ -604,6 +911,174 @@ async def rebuild_preview(
     }
 
 
+class RetargetPreviewRequest(BaseModel):
+    branch: Optional[str] = None
+    mr_iid: Optional[int] = None
+
+
+# Named volumes of a preview's compose file, {preview}-{project}_{volume}
+PREVIEW_VOLUMES = ("db_data", "solr_data")
+
+
+@router.post("/api/previews/{project}/{preview_name}/retarget")
+async def retarget_preview(
+    project: str,
+    preview_name: str,
+    body: RetargetPreviewRequest,
+    background_tasks: BackgroundTasks,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Move a preview to another branch or MR, keeping its database and files.
+
+    With mr_iid the preview becomes mr-{mr_iid} and deploys the MR's source
+    branch. With branch, a branch preview becomes branch-{branch}, while an
+    MR preview keeps its name. The preview is then rebuilt like a push to
+    its new branch would, running the update deploy steps.
+    """
+    import httpx
+    from app.routes.gitlab import _get_gitlab_token
+
+    state = await PreviewStateManager.load_state(project, preview_name)
+    if not state:
+        raise HTTPException(status_code=404, detail=f"Preview {project}/{preview_name} not found")
+    if bool(body.branch) == bool(body.mr_iid):
+        raise HTTPException(status_code=400, detail="Give either a branch or an mr_iid")
+    if state["status"] == "creating" or await has_running_deployment(state["id"]):
+        raise HTTPException(status_code=409, detail=f"A deploy of {project}/{preview_name} is in progress; retry when it ends")
+
+    project_path = await config_store.get_project_path_by_slug(project)
+    if not project_path:
+        raise HTTPException(status_code=400, detail=f"Project '{project}' not found in enabled projects")
+    encoded_path = project_path.replace("/", "%2F")
+    api_url = f"{settings.gitlab_url}/api/v4/projects/{encoded_path}"
+    token = await _get_gitlab_token()
+
+    mr_iid = state.get("mr_id")
+    try:
+        async with httpx.AsyncClient(headers={"PRIVATE-TOKEN": token}, timeout=15) as client:
+            if body.mr_iid:
+                resp = await client.get(f"{api_url}/merge_requests/{body.mr_iid}")
+                if resp.status_code == 404:
+                    raise HTTPException(status_code=404, detail=f"Merge request !{body.mr_iid} not found")
+                resp.raise_for_status()
+                mr = resp.json()
+                if mr["state"] != "opened":
+                    raise HTTPException(status_code=400, detail=f"Merge request !{body.mr_iid} is {mr['state']}")
+                mr_iid = body.mr_iid
+                branch, commit_sha = mr["source_branch"], mr["sha"]
+                new_name = f"mr-{mr_iid}"
+            else:
+                branch = body.branch
+                resp = await client.get(f"{api_url}/repository/branches/{quote(branch, safe='')}")
+                if resp.status_code == 404:
+                    raise HTTPException(status_code=404, detail=f"Branch '{branch}' not found")
+                resp.raise_for_status()
+                commit_sha = resp.json()["commit"]["id"]
+                new_name = preview_name
+                if preview_name.startswith("branch-"):
+                    sanitized = _sanitize_branch_name(branch)
+                    if not sanitized:
+                        raise HTTPException(status_code=400, detail="Invalid branch name")
+                    new_name = f"branch-{sanitized}"
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error resolving the retarget of {project}/{preview_name}: {e}", exc_info=True)
+        raise HTTPException(status_code=502, detail=f"GitLab API error: {e}")
+
+    if new_name == preview_name and branch == state["branch"]:
+        raise HTTPException(status_code=400, detail=f"{project}/{preview_name} already deploys {branch}")
+    if new_name != preview_name:
+        if await get_preview(project, new_name) or PreviewStateManager.get_preview_path(project, new_name).exists():
+            raise HTTPException(
+                status_code=409,
+                detail=f"Preview {project}/{new_name} already exists; delete it first to move {preview_name} there",
+            )
+        await _rename_preview(project, preview_name, new_name)
+
+    await PreviewStateManager.save_state(project, new_name, branch=branch, mr_id=mr_iid)
+
+    from app.routes.webhooks import _clone_and_deploy
+    background_tasks.add_task(
+        _clone_and_deploy,
+        project_path,
+        project,
+        new_name,
+        branch,
+        commit_sha,
+        "retarget",
+        mr_iid,
+    )
+
+    return {
+        "success": True,
+        "preview_name": new_name,
+        "branch": branch,
+        "commit_sha": commit_sha,
+        "url": f"https://{preview_domain(project, new_name)}",
+        "message": f"Moved {project}/{preview_name} to {new_name} (branch: {branch}); rebuilding",
+    }
+
+
+async def _rename_preview(project: str, preview_name: str, new_name: str):
+    """Rename a stopped preview: its directory, with the overlay of its
+    files, its Docker volumes, its record and its resource limits. Its
+    containers are recreated with the new name on the next deploy."""
+    old_path = PreviewStateManager.get_preview_path(project, preview_name)
+    new_path = PreviewStateManager.get_preview_path(project, new_name)
+
+    # Volumes are kept; only the containers go
+    if (old_path / "docker-compose.yml").exists():
+        result = await _run_docker_command(["docker", "compose", "down", "--timeout", "5"], old_path, timeout=120)
+        if not result["success"]:
+            raise HTTPException(status_code=500, detail=f"Failed to stop {project}/{preview_name}: {result['error']}")
+    try:
+        await umount_overlay(old_path)
+    except Exception as e:
+        logger.warning(f"Error unmounting overlay for {project}/{preview_name}: {e}")
+
+    old_prefix = _container_prefix(project, preview_name)
+    new_prefix = _container_prefix(project, new_name)
+    for volume in PREVIEW_VOLUMES:
+        await _copy_volume(f"{old_prefix}_{volume}", f"{new_prefix}_{volume}", new_prefix, volume)
+
+    if old_path.exists():
+        old_path.rename(new_path)
+    await rename_preview_in_db(
+        project, preview_name, new_name,
+        path=str(new_path),
+        url=f"https://{preview_domain(project, new_name)}",
+    )
+    await save_preview_resources(project, new_name, await load_preview_resources(project, preview_name))
+    await save_preview_resources(project, preview_name, {})
+    for volume in PREVIEW_VOLUMES:
+        await _run_docker_command(["docker", "volume", "rm", f"{old_prefix}_{volume}"], new_path.parent, timeout=60)
+    logger.info(f"Renamed preview {project}/{preview_name} to {new_name}")
+
+
+async def _copy_volume(source: str, dest: str, compose_project: str, volume: str):
+    """Copy the Docker volume source to dest, created as the volume of the
+    compose project so compose adopts it. A missing source is skipped."""
+    cwd = Path(settings.previews_base_path)
+    if not (await _run_docker_command(["docker", "volume", "inspect", source], cwd, timeout=30))["success"]:
+        return
+    result = await _run_docker_command([
+        "docker", "volume", "create",
+        "--label", f"com.docker.compose.project={compose_project}",
+        "--label", f"com.docker.compose.volume={volume}",
+        dest,
+    ], cwd, timeout=30)
+    if result["success"]:
+        result = await _run_docker_command([
+            "docker", "run", "--rm",
+            "-v", f"{source}:/from:ro",
+            "-v", f"{dest}:/to",
+            "alpine:3.20", "cp", "-a", "/from/.", "/to/",
+        ], cwd, timeout=600)
+    if not result["success"]:
+        raise HTTPException(status_code=500, detail=f"Failed to copy volume {source} to {dest}: {result['error']}")
+
+
 @router.get("/api/previews/{project}/{preview_name}/deployments")
 async def list_preview_deployments(
     project: str, preview_name: str,
@@ -678,15 +1153,59 @@ async def get_deployment_live_logs(
     }
 
 
+# MySQL table names as drush sql-dump --tables-list accepts them (no
+# wildcards, which could match more than was asked for).
+TABLE_NAME_RE = re.compile(r"^[A-Za-z0-9_$]{1,64}$")
+
+
+async def _list_tables(php_container: str, preview_path: Path) -> set[str]:
+    """Return the tables of a preview's database."""
+    process = await asyncio.create_subprocess_exec(
+        "docker", "exec", php_container, "vendor/bin/drush", "sql:query", "SHOW TABLES",
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE,
+        cwd=str(preview_path),
+    )
+    stdout, stderr = await process.communicate()
+    if process.returncode != 0:
+        raise HTTPException(status_code=500, detail=f"Could not list tables: {stderr.decode().strip()}")
+    return {line.strip() for line in stdout.decode().splitlines() if line.strip()}
+
+
 @router.get("/api/previews/{project}/{preview_name}/db/download")
-async def download_db(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.manager))):
-    """Stream a gzipped SQL dump of the preview database."""
+async def download_db(
+    project: str,
+    preview_name: str,
+    tables: Optional[str] = Query(None),
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Stream a gzipped SQL dump of the preview database.
+
+    Query params:
+        tables: comma-separated tables to dump instead of the whole database
+    """
     preview_path = _get_preview_dir(project, preview_name)
     php_container = f"{preview_name}-{project}-php"
 
+    dump_args = []
+    if tables is not None:
+        names = [t.strip() for t in tables.split(",") if t.strip()]
+        if not names:
+            raise HTTPException(status_code=400, detail="tables is empty")
+        invalid = [t for t in names if not TABLE_NAME_RE.match(t)]
+        if invalid:
+            raise HTTPException(status_code=400, detail=f"Invalid table names: {', '.join(invalid)}")
+        # Unknown tables would only make mysqldump fail once the response
+        # has started, so check them first
+        existing = await _list_tables(php_container, preview_path)
+        missing = [t for t in names if t not in existing]
+        if missing:
+            raise HTTPException(status_code=404, detail=f"Tables not found: {', '.join(missing)}")
+        dump_args = [f"--tables-list={','.join(names)}"]
+
     async def generate():
         process = await asyncio.create_subprocess_exec(
-            "docker", "exec", php_container, "vendor/bin/drush", "sql-dump",
+            "docker", "exec", php_container, "vendor/bin/drush", "sql-dump", *dump_args,
             stdout=asyncio.subprocess.PIPE,
             stderr=asyncio.subprocess.PIPE,
             cwd=str(preview_path),
@@ -711,6 +1230,64 @@ async def download_db(project: str, preview_name: str, user: UserWithRole = Depe
     )
 
 
+class AnonymizeRequest(BaseModel):
+    # Only return the statements, without running them
+    dry_run: bool = False
+    # Content of a preview.yml whose sanitize: block runs instead of that
+    # of the preview, e.g. for previews of branches that predate it
+    preview_yml: Optional[str] = None
+
+
+@router.post("/api/previews/{project}/{preview_name}/db/anonymize")
+async def anonymize_db(
+    project: str,
+    preview_name: str,
+    body: AnonymizeRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Run the sanitize: recipe of preview.yml against the database of an
+    existing preview, one statement at a time, stopping at the first that
+    fails."""
+    preview_path = _get_preview_dir(project, preview_name)
+    if body.preview_yml is not None:
+        try:
+            raw = yaml.safe_load(body.preview_yml) or {}
+        except yaml.YAMLError as e:
+            raise HTTPException(status_code=400, detail=f"preview.yml is not valid YAML: {e}")
+        if not isinstance(raw, dict) or "sanitize" not in raw:
+            raise HTTPException(status_code=400, detail="The preview.yml given has no sanitize: block")
+        try:
+            recipe = parse_sanitize(raw["sanitize"])
+        except ValueError as e:
+            raise HTTPException(status_code=400, detail=str(e))
+    else:
+        recipe = parse_preview_yml(preview_path)["sanitize"]
+        if not recipe:
+            raise HTTPException(status_code=400, detail=f"The preview.yml of {project}/{preview_name} has no valid sanitize: block")
+
+    statements = sanitize_statements(recipe)
+    result = {"success": True, "statements": statements, "applied": 0, "output": "", "error": ""}
+    if body.dry_run:
+        return result
+
+    db_container = f"{_container_prefix(project, preview_name)}-db"
+    output = []
+    for statement in statements:
+        run = await _run_docker_command(
+            ["docker", "exec", db_container, "mysql", "-u", "drupal", "-pdrupal", "drupal", "-e", statement],
+            preview_path,
+            timeout=600,
+        )
+        if not run["success"]:
+            result.update(success=False, error=f"{statement}: {run['error'].strip()}")
+            break
+        output.append(statement)
+        result["applied"] += 1
+    result["output"] = "\n".join(output)
+    logger.info(f"Anonymized {project}/{preview_name} ({result['applied']}/{len(statements)} statements) by {user.email}")
+    return _with_debug_log(result)
+
+
 @router.get("/api/previews/{project}/{preview_name}/files/download")
 async def download_files(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.manager))):
     """Stream a tar.gz of the preview's Drupal files directory."""
@@ -746,3 +1323,155 @@ async def download_files(project: str, preview_name: str, user: UserWithRole = D
     )
 
 
+@router.get("/api/previews/{project}/{preview_name}/files/manifest")
+async def files_manifest(
+    project: str,
+    preview_name: str,
+    path: list[str] = Query(["."]),
+    user: UserWithRole = Depends(require_role(Role.viewer)),
+):
+    """List the files of the preview's public files directory under each
+    path, with their size and modification time."""
+    preview_path = _get_preview_dir(project, preview_name)
+    root = files_sync.files_dir(preview_path)
+    if not root.is_dir():
+        raise HTTPException(status_code=404, detail="Files directory not found")
+    try:
+        files = await asyncio.to_thread(files_sync.manifest, root, path)
+    except files_sync.SyncError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    return {"files": files}
+
+
+@router.post("/api/previews/{project}/{preview_name}/files/sync")
+async def sync_files(
+    project: str,
+    preview_name: str,
+    file: Optional[UploadFile] = None,
+    delete: str = Form("[]"),
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Write files into the preview's public files directory, over its
+    overlay, without touching the base files.
+
+    Form fields:
+        file: tar.gz of the files to write, relative to the files directory
+        delete: JSON list of paths to delete first, relative to it
+    """
+    preview_path = _get_preview_dir(project, preview_name)
+    root = files_sync.files_dir(preview_path)
+    if not root.is_dir():
+        raise HTTPException(status_code=404, detail="Files directory not found")
+    try:
+        deletes = json.loads(delete)
+    except ValueError:
+        raise HTTPException(status_code=400, detail="delete must be a JSON list of paths")
+    if not isinstance(deletes, list) or not all(isinstance(d, str) for d in deletes):
+        raise HTTPException(status_code=400, detail="delete must be a JSON list of paths")
+
+    archive = None
+    try:
+        if file is not None:
+            fd, tmp = tempfile.mkstemp(suffix=".tar.gz")
+            archive = Path(tmp)
+            with os.fdopen(fd, "wb") as f:
+                while chunk := await file.read(64 * 1024):
+                    f.write(chunk)
+        result = await asyncio.to_thread(files_sync.apply, root, archive, deletes)
+    except (files_sync.SyncError, tarfile.TarError) as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    finally:
+        if archive is not None:
+            archive.unlink(missing_ok=True)
+
+    logger.info("Synced files of %s/%s for %s: %d written, %d deleted",
+                project, preview_name, user.email, result["written"], result["deleted"])
+    return result
+
+
+# Lists files matching a glob inside the PHP container. PHP's glob() is used
+# so the pattern is never interpreted by a shell.
+_ARTIFACT_LIST_PHP = (
+    'foreach (glob($argv[1], GLOB_BRACE) ?: [] as $f) '
+    'if (is_file($f)) echo filesize($f), "\\t", $f, "\\n";'
+)
+
+
+@router.get("/api/previews/{project}/{preview_name}/artifacts")
+async def list_artifacts(project: str, preview_name: str, pattern: str, user: UserWithRole = Depends(require_role(Role.manager))):
+    """List files in the preview's PHP container matching a glob pattern."""
+    _get_preview_dir(project, preview_name)
+    if not pattern.startswith("/"):
+        raise HTTPException(status_code=400, detail="Pattern must be an absolute path")
+    php_container = f"{preview_name}-{project}-php"
+
+    process = await asyncio.create_subprocess_exec(
+        "docker", "exec", php_container, "php", "-r", _ARTIFACT_LIST_PHP, "--", pattern,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE,
+    )
+    stdout, stderr = await process.communicate()
+    if process.returncode != 0:
+        raise HTTPException(status_code=500, detail=stderr.decode(errors="replace").strip() or "Listing failed")
+
+    artifacts = []
+    for line in stdout.decode(errors="replace").splitlines():
+        size, _, path = line.partition("\t")
+        if path:
+            artifacts.append({"path": path, "size_bytes": int(size)})
+    return {"artifacts": artifacts}
+
+
+@router.get("/api/previews/{project}/{preview_name}/artifacts/download")
+async def download_artifact(project: str, preview_name: str, path: str, user: UserWithRole = Depends(require_role(Role.manager))):
+    """Stream a single file from the preview's PHP container."""
+    _get_preview_dir(project, preview_name)
+    if not path.startswith("/"):
+        raise HTTPException(status_code=400, detail="Path must be an absolute path")
+    php_container = f"{preview_name}-{project}-php"
+
+    check = await asyncio.create_subprocess_exec(
+        "docker", "exec", php_container, "test", "-f", path,
+        stdout=asyncio.subprocess.DEVNULL,
+        stderr=asyncio.subprocess.DEVNULL,
+    )
+    if await check.wait() != 0:
+        raise HTTPException(status_code=404, detail="File not found")
+
+    async def generate():
+        process = await asyncio.create_subprocess_exec(
+            "docker", "exec", php_container, "cat", "--", path,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE,
+        )
+        while True:
+            chunk = await process.stdout.read(64 * 1024)
+            if not chunk:
+                break
+            yield chunk
+        await process.wait()
+
+    filename = Path(path).name
+    return StreamingResponse(
+        generate(),
+        media_type="application/octet-stream",
+        headers={"Content-Disposition": f'attachment; filename="{filename}"'},
+    )
+
+
+@router.get("/api/previews/{project}/{preview_name}/size")
+async def get_preview_size(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Return the disk usage of the preview by component. Shared components
+    (base files, docker images) are not counted in total_bytes."""
+    preview_path = _get_preview_dir(project, preview_name)
+    components = await preview_size.measure(project, preview_path, _container_prefix(project, preview_name))
+    total = sum(c.size_bytes or 0 for c in components if not c.shared)
+    return {"project": project, "preview": preview_name, "total_bytes": total, "components": components}
+
+
+@router.get("/api/previews/{project}/{preview_name}/tests")
+async def list_test_suites(project: str, preview_name: str, user: UserWithRole = Depends(require_role(Role.manager))):
+    """List the test suites defined in the preview's preview.yml."""
+    preview_path = _get_preview_dir(project, preview_name)
+    tests = parse_preview_yml(preview_path)["tests"]
+    return {"suites": [{"name": name, **suite} for name, suite in tests.items()]}
@@ -0,0 +1,42 @@
+"""Projects of the preview server
+
+The projects previews can be made of are the GitLab projects enabled on
+the server, known by their slug: the last segment of their GitLab path.
+Clients list them to check the project they detected from a git remote
+before acting on it, as a renamed repository would otherwise fail with a
+404 or upload base files nobody uses.
+"""
+
+from fastapi import APIRouter, Depends
+
+from app import config_store
+from app.auth import database as db
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole, has_min_role
+
+router = APIRouter(tags=["projects"])
+
+
+@router.get("/api/projects")
+async def list_projects(user: UserWithRole = Depends(require_role(Role.viewer))):
+    """List the enabled projects the user has access to, by slug. Non-admin
+    users only see the projects they are assigned to."""
+    enabled = await config_store.load_enabled_project_ids()
+    details = await config_store.load_project_details()
+    projects = []
+    for pid, path in (await config_store.load_project_paths()).items():
+        if pid not in enabled:
+            continue
+        slug = path.rsplit("/", 1)[-1]
+        info = details.get(pid, {})
+        projects.append({
+            "slug": slug,
+            "name": info.get("name", slug),
+            "path_with_namespace": path,
+            "web_url": info.get("web_url", ""),
+        })
+
+    if not has_min_role(user.role, Role.admin):
+        allowed = set(await db.get_user_project_slugs(user.id))
+        projects = [p for p in projects if p["slug"] in allowed]
+    return {"projects": sorted(projects, key=lambda p: p["slug"])}
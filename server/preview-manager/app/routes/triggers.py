@@ -0,0 +1,133 @@
+"""Trigger URLs of previews
+
+A trigger is a secret URL that runs one fixed action on one preview —
+rebuild it, run drush cron or a given drush command — so external systems
+(a cron service, another project's CI, a monitoring hook) can do it
+without a user token. Triggers expire, and only their hash is stored: the
+URL is shown once, when it's created.
+"""
+
+import secrets
+import shlex
+from datetime import datetime, timedelta, timezone
+
+from fastapi import APIRouter, BackgroundTasks, Depends, HTTPException, Request
+from pydantic import BaseModel
+
+from app import database
+from app.auth.database import _hash_token
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.routes.previews import run_drush, start_rebuild
+
+router = APIRouter(tags=["triggers"])
+
+ACTIONS = ("rebuild", "drush", "cron")
+
+DEFAULT_EXPIRY = 30 * 24 * 3600
+MAX_EXPIRY = 365 * 24 * 3600
+
+
+class TriggerRequest(BaseModel):
+    action: str
+    # Drush command run by a "drush" trigger, e.g. "search-api:index"
+    args: str = ""
+    name: str = ""
+    # Seconds until the trigger expires
+    expires_in: int = DEFAULT_EXPIRY
+
+
+def _trigger_path(token: str) -> str:
+    return f"/api/triggers/{token}"
+
+
+async def _preview_id(project: str, preview_name: str) -> int:
+    preview = await database.get_preview(project, preview_name)
+    if not preview:
+        raise HTTPException(status_code=404, detail=f"Preview {project}/{preview_name} not found")
+    return preview["id"]
+
+
+@router.post("/api/previews/{project}/{preview_name}/triggers")
+async def create_trigger(
+    project: str,
+    preview_name: str,
+    body: TriggerRequest,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Create a trigger URL for a preview. The token in it is only returned
+    here."""
+    if body.action not in ACTIONS:
+        raise HTTPException(status_code=400, detail=f"Invalid action '{body.action}': expected one of {', '.join(ACTIONS)}")
+    args = body.args.strip()
+    if body.action == "drush":
+        if not args:
+            raise HTTPException(status_code=400, detail="A drush trigger needs the drush command to run in 'args'")
+        try:
+            shlex.split(args)
+        except ValueError as e:
+            raise HTTPException(status_code=400, detail=f"Invalid args: {e}")
+    elif args:
+        raise HTTPException(status_code=400, detail=f"A {body.action} trigger takes no args")
+    if not 0 < body.expires_in <= MAX_EXPIRY:
+        raise HTTPException(status_code=400, detail=f"expires_in must be between 1 and {MAX_EXPIRY} seconds (365 days)")
+
+    preview_id = await _preview_id(project, preview_name)
+    raw_token = secrets.token_urlsafe(32)
+    expires_at = (datetime.now(timezone.utc) + timedelta(seconds=body.expires_in)).isoformat()
+    trigger_id = await database.create_trigger(
+        preview_id, body.action, args, body.name.strip(),
+        _hash_token(raw_token), raw_token[:8], user.email, expires_at,
+    )
+    trigger = next(t for t in await database.list_triggers(preview_id) if t["id"] == trigger_id)
+    return {**trigger, "token": raw_token, "path": _trigger_path(raw_token)}
+
+
+@router.get("/api/previews/{project}/{preview_name}/triggers")
+async def list_triggers(
+    project: str,
+    preview_name: str,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """List the triggers of a preview, expired ones included."""
+    return await database.list_triggers(await _preview_id(project, preview_name))
+
+
+@router.delete("/api/previews/{project}/{preview_name}/triggers/{trigger_id}")
+async def delete_trigger(
+    project: str,
+    preview_name: str,
+    trigger_id: int,
+    user: UserWithRole = Depends(require_role(Role.manager)),
+):
+    """Revoke a trigger: its URL stops working at once."""
+    if not await database.delete_trigger(await _preview_id(project, preview_name), trigger_id):
+        raise HTTPException(status_code=404, detail=f"Trigger {trigger_id} not found")
+    return {"success": True}
+
+
+@router.api_route("/api/triggers/{token}", methods=["GET", "POST"])
+async def run_trigger(token: str, request: Request, background_tasks: BackgroundTasks):
+    """Run the action of a trigger. Unauthenticated: the token is the
+    credential.
+
+    Only cron triggers answer GET, for cron services that can't POST; the
+    others need POST so that a link preview fetching the URL doesn't rebuild
+    the preview.
+    """
+    trigger = await database.get_trigger_by_hash(_hash_token(token))
+    if not trigger:
+        raise HTTPException(status_code=404, detail="Trigger not found")
+    if datetime.fromisoformat(trigger["expires_at"]) <= datetime.now(timezone.utc):
+        raise HTTPException(status_code=410, detail="Trigger expired")
+    if request.method == "GET" and trigger["action"] != "cron":
+        raise HTTPException(status_code=405, detail=f"A {trigger['action']} trigger must be called with POST")
+
+    await database.mark_trigger_used(trigger["id"])
+    project, preview_name = trigger["project"], trigger["preview_name"]
+    triggered_by = f"trigger:{trigger['name'] or trigger['token_prefix']}"
+    if trigger["action"] == "rebuild":
+        return await start_rebuild(project, preview_name, background_tasks, triggered_by=triggered_by)
+    if trigger["action"] == "cron":
+        return await run_drush(project, preview_name, ["cron"])
+    return await run_drush(project, preview_name, shlex.split(trigger["args"]))
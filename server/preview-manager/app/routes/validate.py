@@ -0,0 +1,346 @@
+"""Pre-flight validation of preview.yml
+
+Checks a preview.yml that isn't committed yet against what a deploy would
+need: the PHP and database images, the services and resources the host can
+give, the preview domain and the deploy scripts at the target commit. Each
+problem comes with a hint, so it can be fixed before pushing instead of
+after a failed deploy.
+"""
+
+import asyncio
+import logging
+import re
+import tempfile
+from pathlib import Path
+from typing import Optional
+from urllib.parse import quote
+
+import httpx
+import psutil
+import yaml
+from fastapi import APIRouter, Depends
+from pydantic import BaseModel
+
+from config.settings import settings
+from app.auth.dependencies import require_role
+from app.auth.models import Role, UserWithRole
+from app.database import get_preview_by_domain
+from app.docker_compose import DEFAULTS, parse_preview_yml, preview_domain
+from app.project_settings import parse_cpus, parse_memory
+from app.routes.previews import _sanitize_branch_name
+from app.sanitize import parse_sanitize
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter(tags=["validate"])
+
+# Keys preview.yml may set: those with a default, and the legacy database keys
+KNOWN_KEYS = set(DEFAULTS) | {"mariadb", "mysql_version"}
+
+SERVICE_IMAGES = {"redis": "redis:7-alpine", "solr": "solr:9"}
+
+# Memory a service needs on the host, roughly, to start
+SERVICE_MEMORY = {"redis": 64 * 1024 ** 2, "solr": 1024 ** 3}
+
+# Longest DNS label: the preview subdomain is a single label
+MAX_LABEL = 63
+
+_UNITS = {"b": 1, "k": 1024, "m": 1024 ** 2, "g": 1024 ** 3}
+
+
+class ValidateRequest(BaseModel):
+    preview_yml: str = ""
+    # Preview the file is for, e.g. mr-5; derived from branch if not given
+    preview: Optional[str] = None
+    # Commit and branch the deploy scripts are checked at
+    ref: Optional[str] = None
+    branch: Optional[str] = None
+
+
+class _Report:
+    def __init__(self):
+        self.checks: list[dict] = []
+
+    def add(self, name: str, status: str, message: str, hint: str | None = None):
+        self.checks.append({"name": name, "status": status, "message": message, "hint": hint})
+
+    @property
+    def valid(self) -> bool:
+        return all(c["status"] != "error" for c in self.checks)
+
+
+def _memory_bytes(value: str) -> int:
+    number, unit = value[:-1], value[-1]
+    if unit.isdigit():
+        number, unit = value, "b"
+    return int(float(number) * _UNITS[unit])
+
+
+async def _image_status(image: str, local_only: bool = False) -> bool | None:
+    """Whether image is on the host or in its registry; None if the
+    registry couldn't tell."""
+    proc = await asyncio.create_subprocess_exec(
+        "docker", "image", "inspect", image,
+        stdout=asyncio.subprocess.DEVNULL, stderr=asyncio.subprocess.DEVNULL,
+    )
+    if await proc.wait() == 0:
+        return True
+    if local_only:
+        return False
+    proc = await asyncio.create_subprocess_exec(
+        "docker", "manifest", "inspect", image,
+        stdout=asyncio.subprocess.DEVNULL, stderr=asyncio.subprocess.PIPE,
+    )
+    try:
+        _, stderr = await asyncio.wait_for(proc.communicate(), timeout=20)
+    except asyncio.TimeoutError:
+        proc.kill()
+        return None
+    if proc.returncode == 0:
+        return True
+    if b"no such manifest" in stderr.lower() or b"not found" in stderr.lower():
+        return False
+    return None
+
+
+async def _local_tags(repository: str) -> list[str]:
+    proc = await asyncio.create_subprocess_exec(
+        "docker", "image", "ls", repository, "--format", "{{.Tag}}",
+        stdout=asyncio.subprocess.PIPE, stderr=asyncio.subprocess.DEVNULL,
+    )
+    stdout, _ = await proc.communicate()
+    return sorted(t for t in stdout.decode().split() if t != "<none>")
+
+
+def _check_keys(raw: dict, report: _Report):
+    """Report the values parse_preview_yml would silently ignore."""
+    unknown = sorted(str(k) for k in raw if k not in KNOWN_KEYS)
+    if unknown:
+        report.add("keys", "warning", f"Unknown keys are ignored: {', '.join(unknown)}",
+                   f"Known keys: {', '.join(sorted(DEFAULTS))}")
+    if "files" in raw and raw["files"] not in ("base", "stage-file-proxy"):
+        report.add("files", "error", f"files: {raw['files']!r} is not supported",
+                   "Use files: base or files: stage-file-proxy")
+    if "sites" in raw:
+        sites = raw["sites"]
+        if not isinstance(sites, list) or not sites or not all(
+                isinstance(s, str) and s not in ("", ".", "..") and "/" not in s for s in sites):
+            report.add("sites", "error", f"sites: {sites!r} is not a list of directory names under sites/",
+                       "Use e.g. sites: [default, intranet]")
+    if isinstance(raw.get("resources"), dict):
+        for key, parse in (("memory", parse_memory), ("cpus", parse_cpus)):
+            if raw["resources"].get(key) is not None:
+                try:
+                    parse(str(raw["resources"][key]))
+                except ValueError as e:
+                    report.add("resources", "error", f"resources.{key}: {e}")
+    if isinstance(raw.get("tests"), dict):
+        for name, suite in raw["tests"].items():
+            if not isinstance(suite, str) and not (isinstance(suite, dict) and suite.get("command")):
+                report.add("tests", "error", f"tests.{name} has no command",
+                           f"Set tests.{name}: COMMAND or tests.{name}.command")
+    if "sanitize" in raw:
+        try:
+            parse_sanitize(raw["sanitize"])
+        except ValueError as e:
+            report.add("sanitize", "error", str(e),
+                       "See 'preview db anonymize-preview --help' for the format")
+    if isinstance(raw.get("deploy"), dict):
+        for phase, value in raw["deploy"].items():
+            if phase not in ("new", "update"):
+                report.add("deploy", "warning", f"deploy.{phase} is ignored: the phases are new and update")
+            elif value not in (None, False) and not (isinstance(value, str) and value):
+                report.add("deploy", "error", f"deploy.{phase}: expected a script path or false")
+
+
+async def _check_images(config: dict, report: _Report):
+    php = config["php_version"]
+    php_image = f"{settings.drupal_base_image}:php{php}"
+    if await _image_status(php_image, local_only=True):
+        report.add("php", "ok", f"PHP {php} ({php_image})")
+    else:
+        versions = [t.removeprefix("php") for t in await _local_tags(settings.drupal_base_image) if t.startswith("php")]
+        report.add("php", "error", f"PHP {php} is not available on this server",
+                   f"Set php_version to one of: {', '.join(versions)}" if versions else None)
+
+    db_spec = config["database"]
+    db_type, _, db_version = db_spec.partition(":")
+    if db_type not in ("mysql", "mariadb") or not db_version:
+        report.add("database", "error", f"database: {db_spec!r} is not a database image",
+                   "Use the DDEV format, e.g. database: mysql:8.0 or database: mariadb:10.6")
+    else:
+        available = await _image_status(db_spec)
+        if available is None:
+            report.add("database", "warning", f"Could not check that {db_spec} exists; it is pulled on the first deploy")
+        elif available:
+            report.add("database", "ok", db_spec)
+        else:
+            report.add("database", "error", f"{db_spec} is not a published image",
+                       f"Check the {db_type} versions on Docker Hub")
+
+    for service, enabled in config["services"].items():
+        if not enabled:
+            continue
+        image = SERVICE_IMAGES[service]
+        available = await _image_status(image)
+        if available is False:
+            report.add("services", "error", f"The {service} image {image} is not available")
+        elif available is None:
+            report.add("services", "warning", f"Could not check that {image} exists; it is pulled on the first deploy")
+
+
+def _check_capacity(config: dict, report: _Report):
+    """Check the resources and services preview.yml asks for against the
+    host, which all previews share."""
+    mem = psutil.virtual_memory()
+    limit = config["resources"]["memory"]
+    if limit and _memory_bytes(limit) > mem.total:
+        report.add("resources", "error", f"resources.memory {limit} is more than the host has "
+                   f"({mem.total // 1024 ** 2} MB)", "Lower resources.memory or leave it unset")
+    cpus = config["resources"]["cpus"]
+    if cpus and cpus > (psutil.cpu_count() or 1):
+        report.add("resources", "error", f"resources.cpus {cpus:g} is more than the host has ({psutil.cpu_count()})",
+                   "Lower resources.cpus or leave it unset")
+
+    enabled = [s for s, on in config["services"].items() if on]
+    needed = sum(SERVICE_MEMORY[s] for s in enabled)
+    if needed and mem.percent >= settings.max_memory_percent:
+        report.add("services", "warning", f"The host is at {mem.percent:.0f}% memory; {', '.join(enabled)} "
+                   f"may put previews to sleep", "Stop idle previews or disable services you don't need")
+    elif needed and mem.available < needed:
+        report.add("services", "error", f"{', '.join(enabled)} need about {needed // 1024 ** 2} MB; "
+                   f"the host has {mem.available // 1024 ** 2} MB available",
+                   "Disable services you don't need, or stop other previews")
+    elif enabled and not any(c["name"] == "services" for c in report.checks):
+        report.add("services", "ok", ", ".join(enabled))
+
+
+async def _check_domain(project: str, preview: str | None, report: _Report):
+    if not preview:
+        report.add("domain", "skipped", "No preview name to check the domain of",
+                   "Pass the preview, or run from the branch of the preview")
+        return
+    domain = preview_domain(project, preview)
+    label = domain.split(".")[0]
+    if len(label) > MAX_LABEL:
+        report.add("domain", "error", f"{domain} is too long: {label} has {len(label)} characters, "
+                   f"at most {MAX_LABEL} fit a domain name", "Use a shorter branch name")
+        return
+    if not re.fullmatch(r"[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?", label):
+        report.add("domain", "error", f"{domain} is not a valid domain name")
+        return
+    owner = await get_preview_by_domain(domain)
+    if owner and (owner["project"], owner["preview_name"]) != (project, preview):
+        report.add("domain", "error", f"{domain} is taken by {owner['project']}/{owner['preview_name']}",
+                   "Rename the branch, or delete the other preview")
+    elif owner:
+        report.add("domain", "ok", f"{domain} (this preview)")
+    else:
+        report.add("domain", "ok", f"{domain} is available")
+
+
+async def _check_repository(project: str, preview: str | None, config: dict, ref: str | None,
+                            branch: str | None, report: _Report) -> str | None:
+    """Check the deploy scripts and docroot at the target commit, and return
+    the ref they were checked at."""
+    from app.routes.gitlab import _get_gitlab_token, _project_api_url
+
+    if not settings.gitlab_oauth_access_token:
+        report.add("deploy", "skipped", "GitLab is not connected; deploy scripts were not checked")
+        return None
+    token = await _get_gitlab_token()
+    api_url = await _project_api_url(project)
+    headers = {"PRIVATE-TOKEN": token}
+
+    async with httpx.AsyncClient(timeout=15) as client:
+        async def exists(path: str, at: str) -> bool:
+            resp = await client.head(f"{api_url}/repository/files/{quote(path, safe='')}",
+                                     headers=headers, params={"ref": at})
+            if resp.status_code == 404:
+                return False
+            resp.raise_for_status()
+            return True
+
+        async def commit_exists(at: str) -> bool:
+            resp = await client.get(f"{api_url}/repository/commits/{quote(at, safe='')}", headers=headers)
+            if resp.status_code == 404:
+                return False
+            resp.raise_for_status()
+            return True
+
+        try:
+            target = None
+            if ref and await commit_exists(ref):
+                target = ref
+            elif branch and await commit_exists(branch):
+                target = branch
+                if ref:
+                    report.add("ref", "warning", f"Commit {ref[:8]} is not pushed; checked the scripts at {branch}",
+                               "Push your commits for an exact check")
+            if target is None:
+                report.add("deploy", "skipped", "The commit and branch are not pushed; deploy scripts were not checked",
+                           "Push the branch first")
+                return None
+
+            for phase in ("new", "update"):
+                override = f"scripts/preview/{phase}/{preview}-deploy.sh" if preview else None
+                if override and await exists(override, target):
+                    report.add("deploy", "ok", f"{phase}: {override} (override of {preview})")
+                    continue
+                script = config["deploy"][phase]
+                if not script:
+                    report.add("deploy", "ok", f"{phase}: no deploy script")
+                elif await exists(script, target):
+                    report.add("deploy", "ok", f"{phase}: {script}")
+                else:
+                    report.add("deploy", "error", f"{phase}: {script} does not exist at {target[:12]}",
+                               "Commit the script, or fix deploy." + phase + " in preview.yml")
+
+            docroot = config["docroot"]
+            if not await exists(f"{docroot}/index.php", target):
+                report.add("docroot", "error", f"{docroot}/index.php does not exist at {target[:12]}",
+                           "Set docroot to the directory of Drupal's index.php")
+        except httpx.HTTPError as e:
+            logger.warning(f"Validating {project} at {ref or branch}: {e}")
+            report.add("deploy", "warning", f"Could not check the repository: {e}")
+            return None
+    return target
+
+
+@router.post("/api/projects/{project}/validate")
+async def validate_preview_yml(project: str, body: ValidateRequest,
+                               user: UserWithRole = Depends(require_role(Role.viewer))):
+    """Check a preview.yml before it is committed, without deploying."""
+    from app.routes.gitlab import _require_project_access
+
+    await _require_project_access(user, project)
+    report = _Report()
+
+    try:
+        raw = yaml.safe_load(body.preview_yml) or {}
+    except yaml.YAMLError as e:
+        mark = getattr(e, "problem_mark", None)
+        where = f" at line {mark.line + 1}, column {mark.column + 1}" if mark else ""
+        report.add("syntax", "error", f"preview.yml is not valid YAML{where}: {getattr(e, 'problem', e)}")
+        return {"valid": False, "ref": None, "preview": body.preview, "checks": report.checks}
+    if not isinstance(raw, dict):
+        report.add("syntax", "error", "preview.yml must be a mapping of keys to values")
+        return {"valid": False, "ref": None, "preview": body.preview, "checks": report.checks}
+    report.add("syntax", "ok", "preview.yml parses" if body.preview_yml.strip() else "No preview.yml; using the defaults")
+    _check_keys(raw, report)
+
+    with tempfile.TemporaryDirectory() as tmp:
+        (Path(tmp) / "preview.yml").write_text(body.preview_yml)
+        config = parse_preview_yml(Path(tmp))
+
+    preview = body.preview
+    if not preview and body.branch:
+        sanitized = _sanitize_branch_name(body.branch)
+        preview = f"branch-{sanitized}" if sanitized else None
+
+    await _check_images(config, report)
+    _check_capacity(config, report)
+    await _check_domain(project, preview, report)
+    ref = await _check_repository(project, preview, config, body.ref, body.branch, report)
+
+    return {"valid": report.valid, "ref": ref, "preview": preview, "checks": report.checks}
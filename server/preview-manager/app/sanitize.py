@@ -0,0 +1,95 @@
+"""Anonymization recipes of preview.yml
+
+The "sanitize:" block of preview.yml says how to strip personal data from
+the database of a preview, for projects whose base database comes from
+production:
+
+    sanitize:
+      truncate: [sessions, watchdog]
+      scrub:
+        users_field_data:
+          mail: email
+          init: email
+          name: name
+      sql:
+        - DELETE FROM webform_submission
+
+Tables are truncated first, then columns are scrubbed, then the custom SQL
+runs. Scrubbed values are derived from the original ones, so unique
+columns stay unique: "email" becomes user-<hash>@example.com, "name"
+user-<hash>, and "null" or "empty" clear the column. NULL and empty
+values are kept.
+"""
+
+import re
+
+# Kinds of scrubbed columns -> SQL of the new value of column {c}
+SCRUBBERS = {
+    "email": "CONCAT('user-', LEFT(SHA2({c}, 256), 12), '@example.com')",
+    "name": "CONCAT('user-', LEFT(SHA2({c}, 256), 12))",
+    "null": "NULL",
+    "empty": "''",
+}
+
+_IDENTIFIER_RE = re.compile(r"^[A-Za-z0-9_$]{1,64}$")
+
+
+def _identifier(name, what: str) -> str:
+    if not isinstance(name, str) or not _IDENTIFIER_RE.match(name):
+        raise ValueError(f"{what} {name!r} is not a table or column name")
+    return f"`{name}`"
+
+
+def parse_sanitize(raw) -> dict:
+    """Validate the sanitize: block of preview.yml and return it as
+    {"truncate": [...], "scrub": {table: {column: kind}}, "sql": [...]}.
+    Raises ValueError on an invalid block."""
+    if not isinstance(raw, dict):
+        raise ValueError("sanitize: expected truncate, scrub and sql keys")
+    unknown = sorted(str(k) for k in raw if k not in ("truncate", "scrub", "sql"))
+    if unknown:
+        raise ValueError(f"sanitize: unknown keys {', '.join(unknown)} (expected truncate, scrub and sql)")
+
+    truncate = raw.get("truncate") or []
+    if not isinstance(truncate, list):
+        raise ValueError("sanitize.truncate: expected a list of tables")
+    for table in truncate:
+        _identifier(table, "sanitize.truncate:")
+
+    scrub = raw.get("scrub") or {}
+    if not isinstance(scrub, dict):
+        raise ValueError("sanitize.scrub: expected tables mapping columns to email, name, null or empty")
+    for table, columns in scrub.items():
+        _identifier(table, "sanitize.scrub:")
+        if not isinstance(columns, dict) or not columns:
+            raise ValueError(f"sanitize.scrub.{table}: expected columns mapped to email, name, null or empty")
+        for column, kind in columns.items():
+            _identifier(column, f"sanitize.scrub.{table}:")
+            if kind not in SCRUBBERS:
+                raise ValueError(f"sanitize.scrub.{table}.{column}: {kind!r} is not one of {', '.join(SCRUBBERS)}")
+
+    sql = raw.get("sql") or []
+    if isinstance(sql, str):
+        sql = [sql]
+    if not isinstance(sql, list) or not all(isinstance(s, str) and s.strip() for s in sql):
+        raise ValueError("sanitize.sql: expected a list of SQL statements")
+
+    return {
+        "truncate": [str(t) for t in truncate],
+        "scrub": {str(t): {str(c): k for c, k in cols.items()} for t, cols in scrub.items()},
+        "sql": [s.strip().rstrip(";") for s in sql],
+    }
+
+
+def sanitize_statements(recipe: dict) -> list[str]:
+    """Return the SQL statements of a recipe of parse_sanitize, in the
+    order they run."""
+    statements = [f"TRUNCATE TABLE {_identifier(t, 'table')}" for t in recipe["truncate"]]
+    for table, columns in recipe["scrub"].items():
+        for column, kind in columns.items():
+            c = _identifier(column, "column")
+            statements.append(
+                f"UPDATE {_identifier(table, 'table')} SET {c} = {SCRUBBERS[kind].format(c=c)} WHERE {c} IS NOT NULL AND {c} <> ''"
+            )
+    statements.extend(recipe["sql"])
+    return statements
@@ -30,11 +30,9 @@ async def auto_stop_loop():
 
 async def _check_and_stop():
     """Check all previews and stop those that exceed their inactivity threshold."""
-    # Load global config
-    global_enabled = await config_store.get_config("auto_stop_enabled")
-    if global_enabled != "true":
-        return
-
+    # Load global config; projects with their own policy are checked even
+    # when it is disabled
+    global_enabled = await config_store.get_config("auto_stop_enabled") == "true"
     global_minutes_str = await config_store.get_config("auto_stop_minutes")
     global_minutes = int(global_minutes_str) if global_minutes_str else 60
 
@@ -60,8 +58,10 @@ async def _check_and_stop():
                 continue
             proj_minutes_str = await config_store.get_config(f"auto_stop_{project}_minutes")
             threshold_minutes = int(proj_minutes_str) if proj_minutes_str else global_minutes
-        else:
+        elif global_enabled:
             threshold_minutes = global_minutes
+        else:
+            continue
 
         # Determine last activity
         last_accessed = p.get("last_accessed_at")
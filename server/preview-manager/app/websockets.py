@@ -4,7 +4,9 @@ import asyncio
 import json
 import logging
 import os
+import shlex
 import time
+import urllib.parse
 from dataclasses import dataclass, field
 from datetime import datetime
 from pathlib import Path
@@ -17,11 +19,46 @@ from fastapi import APIRouter, WebSocket, WebSocketException, status
 from config.settings import settings
 from app.auth import database as auth_db
 from app.auth.models import Role, has_min_role
+from app.docker_compose import DB_NAME, DB_PASSWORD, DB_USER, parse_preview_yml
+from app import xdebug
 
 logger = logging.getLogger(__name__)
 
 router = APIRouter()
 
+# Lines of a log shown before following it
+LOG_TAIL_LINES = 100
+
+# PHP error log of the Drupal image (error_log of its php.ini)
+PHP_ERROR_LOG = "/var/log/php/error.log"
+
+# Severities of log entries, worst first (RFC 5424, as drush watchdog uses)
+LOG_SEVERITIES = ["emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"]
+
+# PHP error log entries of each severity, by their "PHP <level>:" prefix.
+# PHP has no levels worse than fatal errors nor below notices.
+_PHP_LOG_LEVELS = [
+    ("error", "PHP (Fatal|Parse|Recoverable fatal|Catchable fatal) error"),
+    ("warning", "PHP Warning"),
+    ("notice", "PHP (Notice|Deprecated|Strict Standards)"),
+]
+
+
+def _php_log_command(severity: str) -> str:
+    """Return the bash command following the PHP error log, keeping only
+    entries of severity or worse."""
+    command = (
+        f"if [ ! -d {os.path.dirname(PHP_ERROR_LOG)} ]; then "
+        "echo 'The image of this preview predates the PHP error log: rebuild the preview to get it' >&2; exit 1; fi; "
+        f"[ -e {PHP_ERROR_LOG} ] || install -o www-data -g www-data -m 644 /dev/null {PHP_ERROR_LOG}; "
+        f"tail -n {LOG_TAIL_LINES} -F {PHP_ERROR_LOG}"
+    )
+    if severity in ("", "info", "debug"):
+        return command
+    rank = max(LOG_SEVERITIES.index(severity), LOG_SEVERITIES.index("error"))
+    patterns = [p for level, p in _PHP_LOG_LEVELS if LOG_SEVERITIES.index(level) <= rank]
+    return f"{command} | grep --line-buffered -E {shlex.quote('|'.join(patterns))}"
+
 
 async def _authenticate_ws(websocket: WebSocket, min_role: Role = Role.viewer) -> int:
     """Authenticate a WebSocket connection via token query param or cookie. Returns user_id."""
@@ -635,6 +672,16 @@ async def websocket_terminal(
     project_name: str,
     preview_name: str,
     container: str = "php",
+    drush: Optional[str] = None,
+    composer: Optional[str] = None,
+    test: Optional[str] = None,
+    deploy: Optional[str] = None,
+    redis: Optional[str] = None,
+    solr: Optional[str] = None,
+    q: str = "*:*",
+    rows: int = 10,
+    logs: Optional[str] = None,
+    severity: str = "",
 ):
     """
     Interactive terminal WebSocket endpoint.
@@ -642,6 +689,22 @@ async def websocket_terminal(
 
     Query params:
         container: service name suffix (default: "php")
+        drush: run 'vendor/bin/drush <args>' instead of bash (used by the CLI
+               for commands that prompt, e.g. "cim")
+        composer: run 'composer <args>' instead of bash
+        test: run the command of this preview.yml test suite instead of bash
+        deploy: run the project deploy script of this phase ("new" or
+                "update") instead of bash
+        redis: run 'redis-cli <args>' in the redis container (interactive
+               when empty)
+        solr: "reindex" queues and indexes all Search API items with drush,
+              "query" runs query q (returning rows documents) on the Solr
+              core of the solr container
+        logs: follow a log instead of running bash: "container" (the output of
+              the container: web server and startup), "php" (the PHP
+              error log) or "watchdog" (drush watchdog:tail)
+        severity: with logs=php or watchdog, only show entries of this
+                  severity or worse (an RFC 5424 level such as "error")
 
     Client → Server messages:
         {"type": "input", "data": "..."}
@@ -655,6 +718,21 @@ async def websocket_terminal(
     await _authenticate_ws(websocket, Role.manager)
     await websocket.accept()
 
+    # Service helpers need the service enabled in preview.yml
+    service = "redis" if redis is not None else "solr" if solr is not None else None
+    if service:
+        if solr is not None and solr not in ("reindex", "query"):
+            await websocket.send_json({"type": "error", "message": f"Invalid solr operation '{solr}': expected reindex or query"})
+            await websocket.close()
+            return
+        preview_path = Path(settings.previews_base_path) / project_name / preview_name
+        if not parse_preview_yml(preview_path)["services"][service]:
+            await websocket.send_json({"type": "error", "message": f"The {service} service is not enabled in preview.yml of this preview"})
+            await websocket.close()
+            return
+        if redis is not None or solr == "query":
+            container = service
+
     container_name = f"{preview_name}-{project_name}-{container}"
 
     # Verify container is running
@@ -674,14 +752,76 @@ async def websocket_terminal(
         await websocket.close()
         return
 
+    if logs is not None:
+        if logs not in ("container", "php", "watchdog"):
+            await websocket.send_json({"type": "error", "message": f"Invalid log '{logs}': expected container, php or watchdog"})
+            await websocket.close()
+            return
+        if severity and severity not in LOG_SEVERITIES:
+            await websocket.send_json({"type": "error", "message": f"Invalid severity '{severity}': expected one of {', '.join(LOG_SEVERITIES)}"})
+            await websocket.close()
+            return
+
+    test_command = None
+    if test:
+        preview_path = Path(settings.previews_base_path) / project_name / preview_name
+        suite = parse_preview_yml(preview_path)["tests"].get(test)
+        if not suite:
+            await websocket.send_json({"type": "error", "message": f"Test suite '{test}' is not defined in preview.yml"})
+            await websocket.close()
+            return
+        test_command = suite["command"]
+
+    deploy_script = None
+    if deploy:
+        if deploy not in ("new", "update"):
+            await websocket.send_json({"type": "error", "message": f"Invalid deploy phase '{deploy}': expected new or update"})
+            await websocket.close()
+            return
+        from app.deployment import resolve_deploy_script
+        preview_path = Path(settings.previews_base_path) / project_name / preview_name
+        try:
+            deploy_script = resolve_deploy_script(preview_path, preview_name, deploy, parse_preview_yml(preview_path))
+        except RuntimeError as e:
+            await websocket.send_json({"type": "error", "message": str(e)})
+            await websocket.close()
+            return
+        if not deploy_script:
+            await websocket.send_json({"type": "error", "message": f"No deploy script configured for phase '{deploy}'"})
+            await websocket.close()
+            return
+
     # Spawn PTY with docker exec
     pty = None
     try:
-        logger.info(f"Spawning terminal PTY for container {container_name}")
-        pty = ptyprocess.PtyProcess.spawn(
-            ["docker", "exec", "-it", container_name, "bash"],
-            dimensions=(24, 80),
-        )
+        command = ["docker", "exec", "-it", container_name, "bash"]
+        if drush:
+            command = ["docker", "exec", "-it", container_name, "vendor/bin/drush"] + shlex.split(drush)
+        elif composer:
+            command = ["docker", "exec", "-it", container_name, "composer"] + shlex.split(composer)
+        elif test_command:
+            command = ["docker", "exec", "-it", container_name, "bash", "-c", test_command]
+        elif deploy_script:
+            command = ["docker", "exec", "-it", container_name, "bash", f"/var/www/html/{deploy_script}"]
+        elif redis is not None:
+            command = ["docker", "exec", "-it", container_name, "redis-cli"] + shlex.split(redis)
+        elif logs == "container":
+            command = ["docker", "logs", "-f", "--tail", str(LOG_TAIL_LINES), container_name]
+        elif logs == "php":
+            command = ["docker", "exec", "-it", container_name, "bash", "-c", _php_log_command(severity)]
+        elif logs == "watchdog":
+            command = ["docker", "exec", "-it", container_name, "vendor/bin/drush", "watchdog:tail"]
+            if severity:
+                command.append(f"--severity-min={severity}")
+        elif solr == "reindex":
+            command = ["docker", "exec", "-it", container_name, "bash", "-c",
+                       "vendor/bin/drush search-api:reindex --yes && vendor/bin/drush search-api:index"]
+        elif solr == "query":
+            params = urllib.parse.urlencode({"q": q, "rows": max(1, min(rows, 100)), "wt": "json", "indent": "true"})
+            command = ["docker", "exec", "-it", container_name,
+                       "wget", "-qO-", f"http://localhost:8983/solr/drupal/select?{params}"]
+        logger.info(f"Spawning terminal PTY for container {container_name}: {command[4:]}")
+        pty = ptyprocess.PtyProcess.spawn(command, dimensions=(24, 80))
         logger.info(f"PTY spawned, pid={pty.pid}, alive={pty.isalive()}")
 
         INACTIVITY_TIMEOUT = 15 * 60  # 15 minutes
@@ -704,7 +844,8 @@ async def websocket_terminal(
                         if not pty.isalive():
                             logger.info(f"PTY process exited during timeout check")
                             break
-                        if time.monotonic() - last_input_time > INACTIVITY_TIMEOUT:
+                        # Test suites, deploy scripts and reindexing take no input and may run for long
+                        if not (test_command or deploy_script or solr or logs) and time.monotonic() - last_input_time > INACTIVITY_TIMEOUT:
                             await websocket.send_json({"type": "error", "message": "Session timed out due to inactivity"})
                             return
                         continue
@@ -766,6 +907,193 @@ async def websocket_terminal(
             pass
 
 
+@router.websocket("/ws/previews/{project_name}/{preview_name}/db-tunnel")
+async def websocket_db_tunnel(websocket: WebSocket, project_name: str, preview_name: str):
+    """
+    Database tunnel WebSocket endpoint, for local database clients (the
+    CLI's open-db). Each WebSocket carries one connection to port 3306 of
+    the preview's db container.
+
+    Server → Client, first:
+        {"type": "ready", "engine": "mysql:8.0", "database": "...", "user": "...", "password": "..."}
+        {"type": "error", "message": "..."}
+
+    Then both directions: binary messages with the bytes of the connection.
+    """
+    await _authenticate_ws(websocket, Role.manager)
+    await websocket.accept()
+
+    container_name = f"{preview_name}-{project_name}-db"
+    try:
+        proc = await asyncio.create_subprocess_exec(
+            "docker", "inspect", "-f",
+            "{{.State.Running}} {{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}",
+            container_name,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE,
+        )
+        stdout, _ = await asyncio.wait_for(proc.communicate(), timeout=5)
+        fields = stdout.decode().split()
+        if proc.returncode != 0 or len(fields) < 2 or fields[0] != "true":
+            await websocket.send_json({"type": "error", "message": f"Container '{container_name}' is not running"})
+            await websocket.close()
+            return
+        reader, writer = await asyncio.wait_for(asyncio.open_connection(fields[1], 3306), timeout=5)
+    except Exception as e:
+        await websocket.send_json({"type": "error", "message": f"Failed to connect to the database: {e}"})
+        await websocket.close()
+        return
+
+    preview_path = Path(settings.previews_base_path) / project_name / preview_name
+    try:
+        engine = parse_preview_yml(preview_path)["database"]
+    except Exception:
+        engine = ""
+    logger.info(f"Opening database tunnel to {container_name}")
+    await websocket.send_json({
+        "type": "ready",
+        "engine": engine,
+        "database": DB_NAME,
+        "user": DB_USER,
+        "password": DB_PASSWORD,
+    })
+
+    async def db_to_ws():
+        while data := await reader.read(65536):
+            await websocket.send_bytes(data)
+
+    async def ws_to_db():
+        while True:
+            msg = await websocket.receive()
+            if msg["type"] == "websocket.disconnect":
+                break
+            if msg.get("bytes"):
+                writer.write(msg["bytes"])
+                await writer.drain()
+
+    try:
+        done, pending = await asyncio.wait(
+            [asyncio.create_task(db_to_ws()), asyncio.create_task(ws_to_db())],
+            return_when=asyncio.FIRST_COMPLETED,
+        )
+        for task in pending:
+            task.cancel()
+    except Exception as e:
+        logger.info(f"Database tunnel to {container_name} ended: {e}")
+    finally:
+        writer.close()
+        try:
+            await websocket.close()
+        except Exception:
+            pass
+
+
+@router.websocket("/ws/previews/{project_name}/{preview_name}/xdebug")
+async def websocket_xdebug(websocket: WebSocket, project_name: str, preview_name: str):
+    """
+    Xdebug relay WebSocket endpoint (the CLI's xdebug on). Turns Xdebug of
+    the preview on, pointed at a port the server listens on, relays the
+    debug connections Xdebug opens to it over the WebSocket, and turns
+    Xdebug off when the WebSocket closes.
+
+    Server → Client, first:
+        {"type": "ready", "ide_key": "PREVIEW", "document_root": "/var/www/html"}
+        {"type": "error", "message": "..."}
+
+    Then both directions: binary messages of a 4-byte big-endian connection
+    id followed by bytes of that connection. A message with no bytes closes
+    the connection, and the server's first message of an id opens it.
+    """
+    await _authenticate_ws(websocket, Role.manager)
+    await websocket.accept()
+
+    key = f"{project_name}/{preview_name}"
+    if key in xdebug.relays:
+        await websocket.send_json({"type": "error", "message": f"Xdebug of {key} is already relayed to another debugger"})
+        await websocket.close()
+        return
+
+    send_lock = asyncio.Lock()
+    writers: dict[int, asyncio.StreamWriter] = {}
+    next_id = 0
+
+    async def send_frame(conn_id: int, data: bytes):
+        async with send_lock:
+            await websocket.send_bytes(conn_id.to_bytes(4, "big") + data)
+
+    async def on_connection(reader: asyncio.StreamReader, writer: asyncio.StreamWriter):
+        nonlocal next_id
+        next_id += 1
+        conn_id = next_id
+        writers[conn_id] = writer
+        try:
+            while data := await reader.read(65536):
+                await send_frame(conn_id, data)
+        except Exception:
+            pass
+        finally:
+            # Also confirms a close by the client, which can forget the id
+            writers.pop(conn_id, None)
+            try:
+                await send_frame(conn_id, b"")
+            except Exception:
+                pass
+            writer.close()
+
+    xdebug.relays.add(key)
+    server = None
+    try:
+        try:
+            host = await xdebug.network_gateway(project_name, preview_name)
+            server = await asyncio.start_server(on_connection, host, 0)
+            port = server.sockets[0].getsockname()[1]
+            await xdebug.set_xdebug(project_name, preview_name, True, host, port)
+        except (xdebug.XdebugError, OSError) as e:
+            await websocket.send_json({"type": "error", "message": str(e)})
+            return
+
+        logger.info(f"Relaying Xdebug of {key} from {host}:{port}")
+        await websocket.send_json({
+            "type": "ready",
+            "ide_key": xdebug.IDE_KEY,
+            "document_root": xdebug.DOCUMENT_ROOT,
+        })
+
+        while True:
+            msg = await websocket.receive()
+            if msg["type"] == "websocket.disconnect":
+                break
+            data = msg.get("bytes")
+            if not data or len(data) < 4:
+                continue
+            writer = writers.get(int.from_bytes(data[:4], "big"))
+            if writer is None:
+                continue
+            if len(data) == 4:
+                writers.pop(int.from_bytes(data[:4], "big"), None)
+                writer.close()
+                continue
+            writer.write(data[4:])
+            await writer.drain()
+    except Exception as e:
+        logger.info(f"Xdebug relay of {key} ended: {e}")
+    finally:
+        if server is not None:
+            server.close()
+            for writer in list(writers.values()):
+                writer.close()
+            writers.clear()
+            try:
+                await xdebug.set_xdebug(project_name, preview_name, False)
+            except Exception as e:
+                logger.warning(f"Failed to turn Xdebug of {key} off: {e}")
+        xdebug.relays.discard(key)
+        try:
+            await websocket.close()
+        except Exception:
+            pass
+
+
 @router.websocket("/ws/previews/{project_name}/{preview_name}/action")
 async def websocket_preview_action(
     websocket: WebSocket,
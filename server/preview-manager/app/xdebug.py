@@ -0,0 +1,75 @@
+"""Xdebug of preview PHP containers.
+
+Xdebug is installed in the Drupal image but off; the preview-xdebug script
+of the image turns it on, pointing it at a debugger, or off. Debuggers
+usually run on a developer's machine, which the container can't reach: the
+xdebug websocket relays the debug connections of a preview to the CLI,
+which hands them to the IDE (see websockets.websocket_xdebug).
+"""
+
+import asyncio
+
+# IDE key of the debug sessions, and the value of the XDEBUG_TRIGGER that
+# starts one
+IDE_KEY = "PREVIEW"
+
+# Default port debuggers listen on (Xdebug 3)
+DEFAULT_PORT = 9003
+
+# Where the code of a preview is in its PHP container, for path mappings
+DOCUMENT_ROOT = "/var/www/html"
+
+# Previews whose Xdebug is pointed at a relay, by "project/preview", so a
+# plain on/off doesn't pull it from under the relay's user
+relays: set[str] = set()
+
+
+class XdebugError(Exception):
+    pass
+
+
+async def _run(*command: str, timeout: int = 30) -> tuple[int, str]:
+    """Run command and return its exit status and combined output."""
+    process = await asyncio.create_subprocess_exec(
+        *command,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.STDOUT,
+    )
+    try:
+        stdout, _ = await asyncio.wait_for(process.communicate(), timeout=timeout)
+    except asyncio.TimeoutError:
+        process.kill()
+        raise XdebugError(f"Timeout after {timeout}s")
+    return process.returncode, stdout.decode(errors="replace").strip()
+
+
+async def set_xdebug(project: str, preview_name: str, enabled: bool, client_host: str = "", client_port: int = DEFAULT_PORT):
+    """Turn Xdebug of the PHP container of a preview on, connecting to the
+    debugger at client_host:client_port, or off. Restarts PHP, aborting
+    running requests."""
+    container = f"{preview_name}-{project}-php"
+    command = ["docker", "exec", container, "preview-xdebug"]
+    command += ["on", client_host, str(client_port)] if enabled else ["off"]
+    code, output = await _run(*command)
+    if code in (126, 127) or "executable file not found" in output:
+        raise XdebugError(
+            f"The image of {project}/{preview_name} predates Xdebug support: rebuild the preview to get it"
+        )
+    if code != 0:
+        raise XdebugError(output or f"preview-xdebug failed with exit status {code}")
+
+
+async def network_gateway(project: str, preview_name: str) -> str:
+    """Return the address of the host on the network of the PHP container
+    of a preview, where it can reach a relay listening on the host."""
+    container = f"{preview_name}-{project}-php"
+    code, output = await _run(
+        "docker", "inspect", "-f",
+        "{{.State.Running}} {{range .NetworkSettings.Networks}}{{.Gateway}} {{end}}",
+        container,
+        timeout=5,
+    )
+    fields = output.split()
+    if code != 0 or len(fields) < 2 or fields[0] != "true":
+        raise XdebugError(f"Container '{container}' is not running")
+    return fields[1]
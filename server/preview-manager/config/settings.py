@@ -22,8 +22,20 @@ class Settings(BaseSettings):
     docker_network: str = "preview-network"
     drupal_base_image: str = "preview-drupal"
     default_php_version: str = "8.3"
+    # PHP versions of the Drupal images built (docker/Dockerfile.drupal)
+    supported_php_versions: str = "8.1,8.2,8.3"
     default_mysql_version: str = "8.0"
 
+    # Base files
+    # Prior versions kept of each base database and files archive, for
+    # `preview base restore`; 0 keeps none
+    base_versions_kept: int = 3
+
+    # CLI
+    # Oldest CLI release allowed, to turn away releases known to be broken;
+    # empty allows all
+    required_cli_version: str = ""
+
     # GitLab Integration
     gitlab_url: str = "https://gitlab.com"
     gitlab_webhook_secret: str = ""
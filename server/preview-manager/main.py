@@ -97,9 +97,28 @@ app.add_middleware(
     allow_headers=["*"],
 )
 
+from app.debug_log import DebugLogMiddleware
+app.add_middleware(DebugLogMiddleware)
+
 from app.wake_preview import WakePreviewMiddleware
 app.add_middleware(WakePreviewMiddleware)
 
+from app.api_version import APIVersionMiddleware
+app.add_middleware(APIVersionMiddleware)
+
+# Added before compression so it runs inside it, on the uncompressed body
+from app.etag import JSONETagMiddleware
+app.add_middleware(JSONETagMiddleware)
+
+from app.compression import JSONGZipMiddleware
+app.add_middleware(JSONGZipMiddleware)
+
+# Added last so it runs first and every response, even from the other
+# middlewares, carries the request ID
+from app.errors import RequestIDMiddleware, register_error_handlers
+app.add_middleware(RequestIDMiddleware)
+register_error_handlers(app)
+
 from app.api import router
 app.include_router(router)
 
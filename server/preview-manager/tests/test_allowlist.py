@@ -0,0 +1,57 @@
+"""Networks of the IP allowlists: parsed when added, matched on requests."""
+
+import asyncio
+
+import pytest
+from fastapi import HTTPException
+
+from app.auth.models import UserWithRole
+from app.routes import allowlist
+
+USER = UserWithRole(id=1, email="dev@example.com", name="Dev", created_at="", updated_at="")
+
+
+@pytest.fixture
+def entries(monkeypatch):
+    """The allowlist entries stored, in memory."""
+    stored = []
+
+    async def list_allowlist(project):
+        return stored
+
+    async def add_allowlist_entry(project, preview_id, cidr, note, created_by):
+        stored.append({"id": len(stored) + 1, "cidr": cidr, "preview_name": None, "note": note})
+        return len(stored)
+
+    monkeypatch.setattr(allowlist.database, "list_allowlist", list_allowlist)
+    monkeypatch.setattr(allowlist.database, "add_allowlist_entry", add_allowlist_entry)
+    return stored
+
+
+def _add(cidr: str) -> dict:
+    body = allowlist.AllowlistRequest(cidr=cidr)
+    return asyncio.run(allowlist.add_allowlist_entry("drupal-test", body, USER))
+
+
+@pytest.mark.parametrize("cidr", ["office", "203.0.113.0/33", "203.0.113.256", ""])
+def test_invalid_network(entries, cidr):
+    with pytest.raises(HTTPException) as e:
+        _add(cidr)
+    assert e.value.status_code == 400
+    assert not entries
+
+
+def test_network_normalized(entries):
+    assert _add(" 203.0.113.7/24 ")["cidr"] == "203.0.113.0/24"
+    assert _add("198.51.100.7")["cidr"] == "198.51.100.7/32"
+    with pytest.raises(HTTPException) as e:
+        _add("203.0.113.0/24")
+    assert e.value.status_code == 409
+
+
+def test_ip_allowed():
+    networks = ["203.0.113.0/24", "2001:db8::/32"]
+    assert allowlist.ip_allowed("203.0.113.9", networks)
+    assert allowlist.ip_allowed("2001:db8::1", networks)
+    assert not allowlist.ip_allowed("198.51.100.7", networks)
+    assert not allowlist.ip_allowed("unknown", networks)
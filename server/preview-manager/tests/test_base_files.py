@@ -0,0 +1,103 @@
+"""Base files kept in use, with their history and prior versions, when
+an upload or a restore fails."""
+
+import asyncio
+import io
+import tarfile
+from pathlib import Path
+
+import pytest
+
+from app.auth.models import UserWithRole
+from app.routes import base_files
+
+SLUG = "drupal-test"
+
+USER = UserWithRole(id=1, email="dev@example.com", name="Dev", created_at="", updated_at="")
+
+
+def _tar_gz(path: Path, files: dict[str, str]) -> Path:
+    with tarfile.open(path, "w:gz") as tar:
+        for name, content in files.items():
+            data = content.encode()
+            info = tarfile.TarInfo(name)
+            info.size = len(data)
+            tar.addfile(info, io.BytesIO(data))
+    return path
+
+
+@pytest.fixture
+def base_dir(tmp_path, monkeypatch):
+    """The base files directory of SLUG, under a temporary /backups."""
+    base_dir = tmp_path / "base-files" / SLUG / "files"
+
+    async def noop(slug):
+        pass
+
+    monkeypatch.setattr(base_files, "BACKUPS_DIR", tmp_path / "backups")
+    monkeypatch.setattr(base_files, "get_base_files_dir", lambda slug: base_dir)
+    monkeypatch.setattr(base_files, "umount_all_for_project", noop)
+    monkeypatch.setattr(base_files, "remount_all_for_project", noop)
+    monkeypatch.setattr(base_files.settings, "base_versions_kept", 3)
+    (tmp_path / "backups").mkdir()
+    return base_dir
+
+
+def _upload(tmp_path: Path, name: str, files: dict[str, str]) -> None:
+    asyncio.run(base_files._process_files(SLUG, _tar_gz(tmp_path / name, files), USER))
+
+
+def _history() -> list:
+    return asyncio.run(base_files.get_base_files_history(SLUG, USER))["uploads"]
+
+
+def _restore(version: str) -> None:
+    body = base_files.RestoreRequest(version=version)
+    asyncio.run(base_files.restore_base_file(SLUG, "files", body, USER))
+
+
+def test_failed_upload_keeps_files_in_use(tmp_path, base_dir):
+    _upload(tmp_path, "first.tar.gz", {"a.txt": "first"})
+    _upload(tmp_path, "second.tar.gz", {"a.txt": "second"})
+    second, first = _history()
+
+    broken = tmp_path / "broken.tar.gz"
+    broken.write_bytes(b"not a tar.gz")
+    with pytest.raises(RuntimeError):
+        asyncio.run(base_files._process_files(SLUG, broken, USER))
+
+    assert (base_dir / "a.txt").read_text() == "second"
+    assert not base_dir.with_name("files.new").exists()
+    assert base_files._sha256(base_dir.parent / "files.tar.gz") == second.sha256
+    history = _history()
+    assert [e.sha256 for e in history] == [second.sha256, first.sha256]
+    assert history[0].current and not history[0].restorable
+    assert history[1].restorable
+
+    _restore(first.sha256)
+    assert (base_dir / "a.txt").read_text() == "first"
+    history = _history()
+    assert history[0].current and history[0].sha256 == first.sha256
+    # The version replaced is kept in turn; the one restored is in use
+    assert history[1].sha256 == second.sha256 and history[1].restorable
+    assert not base_files._version_path(SLUG, "files", first.sha256).exists()
+
+
+def test_failed_restore_keeps_version(tmp_path, base_dir, monkeypatch):
+    _upload(tmp_path, "first.tar.gz", {"a.txt": "first"})
+    _upload(tmp_path, "second.tar.gz", {"a.txt": "second"})
+    second, first = _history()
+
+    async def broken_extract(*args, **kwargs):
+        raise RuntimeError("Failed to extract files: disk full")
+
+    with monkeypatch.context() as m:
+        m.setattr(base_files.asyncio, "create_subprocess_exec", broken_extract)
+        with pytest.raises(RuntimeError):
+            _restore(first.sha256)
+
+    assert (base_dir / "a.txt").read_text() == "second"
+    history = _history()
+    assert [e.sha256 for e in history] == [second.sha256, first.sha256]
+    assert history[1].restorable
+    assert list((tmp_path / "backups").glob("*.tmp")) == []
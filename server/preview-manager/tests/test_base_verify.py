@@ -0,0 +1,62 @@
+"""Integrity checks of stored bases: truncated uploads and files changed
+after their upload fail."""
+
+import gzip
+import hashlib
+import io
+import tarfile
+
+from app import base_verify
+
+DUMP = b"-- MySQL dump\nCREATE TABLE node (nid int);\nCREATE TABLE users (uid int);\n"
+
+
+def _files_archive(path, files: dict[str, bytes]):
+    with tarfile.open(path, "w:gz") as tar:
+        for name, data in files.items():
+            info = tarfile.TarInfo(name)
+            info.size = len(data)
+            tar.addfile(info, io.BytesIO(data))
+    return path
+
+
+def test_complete_db(tmp_path):
+    path = tmp_path / "db.sql.gz"
+    path.write_bytes(gzip.compress(DUMP))
+    check = base_verify.verify_db(path, hashlib.sha256(path.read_bytes()).hexdigest())
+    assert check.status == "ok", check.message
+    assert check.tables == 2 and check.uncompressed_bytes == len(DUMP)
+
+
+def test_truncated_db(tmp_path):
+    path = tmp_path / "db.sql.gz"
+    path.write_bytes(gzip.compress(DUMP)[:-4])
+    check = base_verify.verify_db(path, None)
+    assert check.status == "failed" and check.message.startswith("Corrupt gzip stream")
+
+
+def test_db_changed_after_upload(tmp_path):
+    path = tmp_path / "db.sql.gz"
+    path.write_bytes(gzip.compress(DUMP))
+    check = base_verify.verify_db(path, "0" * 64)
+    assert check.status == "failed" and "differs from the last upload" in check.message
+
+
+def test_missing_db(tmp_path):
+    assert base_verify.verify_db(tmp_path / "db.sql.gz", None).status == "missing"
+
+
+def test_truncated_files_archive(tmp_path):
+    archive = _files_archive(tmp_path / "files.tar.gz", {"a.txt": b"a" * 4096})
+    archive.write_bytes(archive.read_bytes()[:-8])
+    (tmp_path / "files").mkdir()
+    check = base_verify.verify_files(archive, tmp_path / "files", None)
+    assert check.status == "failed" and check.message.startswith("Corrupt archive")
+
+
+def test_complete_files_archive(tmp_path):
+    archive = _files_archive(tmp_path / "files.tar.gz", {"a.txt": b"a", "b/c.txt": b"bc"})
+    (tmp_path / "files").mkdir()
+    check = base_verify.verify_files(archive, tmp_path / "files", None)
+    assert check.status == "ok", check.message
+    assert check.entries == 2 and check.uncompressed_bytes == 3
@@ -0,0 +1,34 @@
+"""The operation log is only attached to the output of requests asking for
+it with the debug log level."""
+
+import asyncio
+
+import pytest
+
+from app import debug_log
+
+
+def _output(headers: list[tuple[bytes, bytes]]) -> str:
+    """Run a request with headers through the middleware and return the
+    output its route would answer."""
+    outputs = []
+
+    async def route(scope, receive, send):
+        debug_log.log("$ docker compose restart")
+        outputs.append(debug_log.attach("restarted\n"))
+
+    app = debug_log.DebugLogMiddleware(route)
+    asyncio.run(app({"type": "http", "headers": headers}, None, None))
+    return outputs[0]
+
+
+def test_debug_level_attaches_log():
+    output = _output([(b"x-preview-log-level", b"DEBUG")])
+    assert output.startswith("--- server log (debug) ---\n[")
+    assert output.endswith("] $ docker compose restart\n--- end of server log ---\nrestarted\n")
+
+
+@pytest.mark.parametrize("headers", [[], [(b"x-preview-log-level", b"info")]])
+def test_other_levels_leave_output(headers):
+    assert _output(headers) == "restarted\n"
+    assert not debug_log.enabled()
@@ -0,0 +1,29 @@
+"""Custom domains refused before they are attached to a preview."""
+
+import asyncio
+
+import pytest
+from fastapi import HTTPException
+
+from app.auth.models import UserWithRole
+from app.routes import domains
+
+USER = UserWithRole(id=1, email="dev@example.com", name="Dev", created_at="", updated_at="")
+
+
+@pytest.mark.parametrize("hostname", [
+    "localhost", "demo client.com", "demo.client.com/path", "-demo.client.com", "demo.client.c0m", "",
+])
+def test_invalid_hostname(hostname):
+    body = domains.DomainRequest(hostname=hostname)
+    with pytest.raises(HTTPException) as e:
+        asyncio.run(domains.add_domain("drupal-test", "mr-5", body, USER))
+    assert e.value.status_code == 400 and e.value.detail.startswith("Invalid hostname")
+
+
+@pytest.mark.parametrize("hostname", ["preview-mr.com", "Demo.Preview-MR.com."])
+def test_preview_domain_refused(hostname):
+    body = domains.DomainRequest(hostname=hostname)
+    with pytest.raises(HTTPException) as e:
+        asyncio.run(domains.add_domain("drupal-test", "mr-5", body, USER))
+    assert e.value.status_code == 400 and "already served" in e.value.detail
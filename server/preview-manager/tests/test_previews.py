@@ -0,0 +1,127 @@
+"""Preview operations refused or skipped before they change anything."""
+
+import asyncio
+
+import pytest
+from fastapi import HTTPException
+
+from app.auth.models import UserWithRole
+from app.routes import previews
+
+USER = UserWithRole(id=1, email="dev@example.com", name="Dev", created_at="", updated_at="")
+
+
+@pytest.fixture
+def preview_dir(tmp_path, monkeypatch):
+    """The directory of any preview, which exists."""
+    monkeypatch.setattr(previews, "_get_preview_dir", lambda project, name: tmp_path)
+    return tmp_path
+
+
+def _status(coro) -> int:
+    with pytest.raises(HTTPException) as e:
+        asyncio.run(coro)
+    return e.value.status_code
+
+
+def test_scale_needs_a_change(preview_dir):
+    body = previews.ScalePreviewRequest()
+    assert _status(previews.scale_preview("drupal-test", "mr-5", body, USER)) == 400
+
+
+class _Tasks:
+    """Records the background tasks added instead of running them."""
+
+    def __init__(self):
+        self.tasks = []
+
+    def add_task(self, func, *args):
+        self.tasks.append(args)
+
+
+@pytest.fixture
+def deployed(monkeypatch, preview_dir):
+    """drupal-test/mr-5, deployed at commit abc123def456."""
+    async def load_state(project, name):
+        return {"branch": "feature/x", "commit_sha": "abc123def456", "mr_id": 5}
+
+    async def project_path(slug):
+        return "group/drupal-test"
+
+    monkeypatch.setattr(previews.PreviewStateManager, "load_state", load_state)
+    monkeypatch.setattr(previews.config_store, "get_project_path_by_slug", project_path)
+
+
+def test_rebuild_skips_deployed_commit(deployed):
+    tasks = _Tasks()
+    result = asyncio.run(previews.start_rebuild("drupal-test", "mr-5", tasks, "ABC123D"))
+    assert result["up_to_date"] and not tasks.tasks
+
+
+def test_rebuild_other_commit(deployed):
+    tasks = _Tasks()
+    result = asyncio.run(previews.start_rebuild("drupal-test", "mr-5", tasks, "fff000"))
+    assert result["success"] and "up_to_date" not in result
+    assert [args[4] for args in tasks.tasks] == ["fff000"]
+
+
+@pytest.mark.parametrize("body", [
+    previews.RetargetPreviewRequest(),
+    previews.RetargetPreviewRequest(branch="main", mr_iid=9),
+])
+def test_retarget_needs_branch_or_mr(deployed, body):
+    assert _status(previews.retarget_preview("drupal-test", "mr-5", body, _Tasks(), USER)) == 400
+
+
+def test_xdebug_on_needs_client_host(preview_dir):
+    body = previews.XdebugRequest(enabled=True)
+    assert _status(previews.set_preview_xdebug("drupal-test", "mr-5", body, USER)) == 400
+
+
+@pytest.fixture
+def scaled(monkeypatch, preview_dir):
+    """Resource limits of drupal-test/mr-5: the docker commands run, the
+    limits saved, and the result docker update gives (success by default)."""
+    state = {"commands": [], "saved": [], "result": {"success": True, "output": "", "error": ""}}
+
+    async def run_docker_command(command, cwd, timeout=120):
+        state["commands"].append(command)
+        return dict(state["result"])
+
+    async def load_preview_resources(project, name):
+        return {"memory": "1g", "cpus": 2.0}
+
+    async def save_preview_resources(project, name, resources):
+        state["saved"].append(resources)
+
+    async def load_project_settings(project):
+        return {"memory_limit": None, "cpu_limit": 1.0}
+
+    monkeypatch.setattr(previews, "_run_docker_command", run_docker_command)
+    monkeypatch.setattr(previews, "load_preview_resources", load_preview_resources)
+    monkeypatch.setattr(previews, "save_preview_resources", save_preview_resources)
+    monkeypatch.setattr(previews, "load_project_settings", load_project_settings)
+    return state
+
+
+def _scale(**changes) -> dict:
+    body = previews.ScalePreviewRequest(**changes)
+    return asyncio.run(previews.scale_preview("drupal-test", "mr-5", body, USER))
+
+
+def test_scale_keeps_limits_docker_refused(scaled):
+    scaled["result"] = {"success": False, "output": "", "error": "No such container"}
+    assert not _scale(memory="2g")["success"]
+    assert scaled["saved"] == []
+
+
+def test_scale_reset_applies_default(scaled):
+    result = _scale(cpus="")
+    assert scaled["commands"] == [["docker", "update", "--cpus", "1.0", "mr-5-drupal-test-php"]]
+    assert scaled["saved"] == [{"memory": "1g", "cpus": None}]
+    assert result["output"] == "Resource limits of drupal-test/mr-5: memory=1g, cpus=default\n"
+
+
+def test_scale_invalid_limit(scaled):
+    assert _status(previews.scale_preview("drupal-test", "mr-5", previews.ScalePreviewRequest(cpus="-1"), USER)) == 400
+    assert scaled["commands"] == [] and scaled["saved"] == []
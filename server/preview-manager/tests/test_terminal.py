@@ -0,0 +1,87 @@
+"""Terminal websocket requests refused with an error frame before a command
+runs in the preview."""
+
+import asyncio
+
+import pytest
+
+from app import websockets
+
+
+class _WebSocket:
+    """Records the frames sent to the client."""
+
+    def __init__(self):
+        self.sent = []
+        self.closed = False
+
+    async def accept(self):
+        pass
+
+    async def send_json(self, message):
+        self.sent.append(message)
+
+    async def close(self):
+        self.closed = True
+
+
+class _Inspect:
+    """docker inspect of a running container."""
+
+    returncode = 0
+
+    async def communicate(self):
+        return b"true\n", b""
+
+
+@pytest.fixture
+def preview_yml(monkeypatch):
+    """The parsed preview.yml of every preview, without tests or services."""
+    parsed = {"tests": {}, "services": {"redis": False, "solr": False}}
+
+    async def authenticate(websocket, min_role):
+        return 1
+
+    async def inspect(*args, **kwargs):
+        return _Inspect()
+
+    monkeypatch.setattr(websockets, "_authenticate_ws", authenticate)
+    monkeypatch.setattr(websockets.asyncio, "create_subprocess_exec", inspect)
+    monkeypatch.setattr(websockets, "parse_preview_yml", lambda path: parsed)
+    return parsed
+
+
+def _error(**params) -> str:
+    """Open a terminal with params and return the message of the error frame
+    it gets instead."""
+    ws = _WebSocket()
+    asyncio.run(websockets.websocket_terminal(ws, "drupal-test", "mr-5", **params))
+    assert ws.closed and [m["type"] for m in ws.sent] == ["error"]
+    return ws.sent[0]["message"]
+
+
+def test_undefined_test_suite(preview_yml):
+    preview_yml["tests"]["behat"] = {"command": "vendor/bin/behat"}
+    assert _error(test="cypress") == "Test suite 'cypress' is not defined in preview.yml"
+
+
+def test_invalid_deploy_phase(preview_yml):
+    assert _error(deploy="rollback") == "Invalid deploy phase 'rollback': expected new or update"
+
+
+def test_invalid_solr_operation(preview_yml):
+    preview_yml["services"]["solr"] = True
+    assert _error(solr="optimize") == "Invalid solr operation 'optimize': expected reindex or query"
+
+
+def test_disabled_service(preview_yml):
+    assert _error(solr="reindex") == "The solr service is not enabled in preview.yml of this preview"
+
+
+def test_invalid_log(preview_yml):
+    assert _error(logs="mysql") == "Invalid log 'mysql': expected container, php or watchdog"
+
+
+def test_invalid_severity(preview_yml):
+    message = _error(logs="php", severity="fatal")
+    assert message.startswith("Invalid severity 'fatal': expected one of emergency, ")
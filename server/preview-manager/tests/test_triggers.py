@@ -0,0 +1,84 @@
+"""Trigger URLs: what they may run, and how they may be called."""
+
+import asyncio
+from datetime import datetime, timedelta, timezone
+
+import pytest
+from fastapi import HTTPException
+
+from app.auth.models import UserWithRole
+from app.routes import triggers
+
+USER = UserWithRole(id=1, email="dev@example.com", name="Dev", created_at="", updated_at="")
+
+
+def _status(coro) -> int:
+    with pytest.raises(HTTPException) as e:
+        asyncio.run(coro)
+    return e.value.status_code
+
+
+@pytest.mark.parametrize("body", [
+    triggers.TriggerRequest(action="deploy"),
+    triggers.TriggerRequest(action="drush"),
+    triggers.TriggerRequest(action="drush", args="sqlq 'SELECT 1"),
+    triggers.TriggerRequest(action="cron", args="cr"),
+    triggers.TriggerRequest(action="rebuild", expires_in=0),
+    triggers.TriggerRequest(action="rebuild", expires_in=triggers.MAX_EXPIRY + 1),
+])
+def test_invalid_trigger(body):
+    assert _status(triggers.create_trigger("drupal-test", "mr-5", body, USER)) == 400
+
+
+class _Request:
+    def __init__(self, method):
+        self.method = method
+
+
+@pytest.fixture
+def stored(monkeypatch):
+    """A stored trigger, returned for any token, and the actions it ran."""
+    trigger = {
+        "id": 1, "project": "drupal-test", "preview_name": "mr-5", "args": "",
+        "name": "", "token_prefix": "abcdefgh",
+        "expires_at": (datetime.now(timezone.utc) + timedelta(days=1)).isoformat(),
+    }
+    ran = []
+
+    async def get_trigger_by_hash(token_hash):
+        return trigger
+
+    async def mark_trigger_used(trigger_id):
+        pass
+
+    async def run_drush(project, preview_name, args):
+        ran.append(args)
+        return {"success": True, "output": "", "error": ""}
+
+    monkeypatch.setattr(triggers.database, "get_trigger_by_hash", get_trigger_by_hash)
+    monkeypatch.setattr(triggers.database, "mark_trigger_used", mark_trigger_used)
+    monkeypatch.setattr(triggers, "run_drush", run_drush)
+    return trigger, ran
+
+
+def test_cron_trigger_runs_on_get(stored):
+    trigger, ran = stored
+    trigger["action"] = "cron"
+    asyncio.run(triggers.run_trigger("token", _Request("GET"), None))
+    assert ran == [["cron"]]
+
+
+@pytest.mark.parametrize("action", ["rebuild", "drush"])
+def test_other_triggers_refuse_get(stored, action):
+    trigger, ran = stored
+    trigger["action"], trigger["args"] = action, "cr" if action == "drush" else ""
+    assert _status(triggers.run_trigger("token", _Request("GET"), None)) == 405
+    assert not ran
+
+
+def test_expired_trigger(stored):
+    trigger, ran = stored
+    trigger["action"] = "cron"
+    trigger["expires_at"] = (datetime.now(timezone.utc) - timedelta(seconds=1)).isoformat()
+    assert _status(triggers.run_trigger("token", _Request("POST"), None)) == 410
+    assert not ran